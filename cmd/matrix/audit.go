@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Work with the audit log of handled commands",
+	}
+	cmd.AddCommand(newAuditVerifyCommand())
+	return cmd
+}
+
+func newAuditVerifyCommand() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check that no audit record was modified or removed",
+		Long: `Checks the hash chain of the audit log in audit.dir (or --dir). Every
+record holds the hash of the one before it, so a modified, inserted or
+removed record is reported with its file and line. The exit status is 1 if
+the chain is broken.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				cfg, err := loadConfig()
+				if err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+				if dir = cfg.Audit.Dir; dir == "" {
+					return fmt.Errorf("audit.dir is not set, the audit log is disabled")
+				}
+			}
+
+			count, err := audit.Verify(dir)
+			if err != nil {
+				return fmt.Errorf("audit log is not intact after %d records: %w", count, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%d records verified\n", count)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "Audit log directory (default audit.dir)")
+	return cmd
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/server"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"github.com/spf13/cobra"
+	"maunium.net/go/mautrix/id"
+)
+
+// defaultCanaryMessage is sent by --canary without a message
+const defaultCanaryMessage = "matrix-microservice self-test"
+
+func newCheckCommand() *cobra.Command {
+	var canary string
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the deployment end to end and exit",
+		Long: `Validates the configuration, logs into Matrix, makes sure the bot is in
+matrix.roomid and every room of the rooms setting (joining it if it is not),
+checks the encryption setup and sends a HEAD request to every webhook. Every
+check is reported; the exit status is 1 if any failed, so it can gate a deploy.
+
+A webhook passes if it answers with a status below 500; 501 is accepted from
+servers that do not implement HEAD. With --canary a message is posted to
+matrix.roomid as well.`,
+		Example: `  matrix-microservice check
+  matrix-microservice check --canary "Deploy $VERSION passed its self-test"`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheck(cmd, canary)
+		},
+	}
+	cmd.Flags().StringVar(&canary, "canary", "", "Post a message to matrix.roomid (default \""+defaultCanaryMessage+"\" if given without one)")
+	cmd.Flags().Lookup("canary").NoOptDefVal = defaultCanaryMessage
+	return cmd
+}
+
+// checkReport prints the result of every check and counts the failures
+type checkReport struct {
+	out      io.Writer
+	failures int
+}
+
+func (r *checkReport) result(name string, err error, detail string) {
+	if err != nil {
+		r.failures++
+		fmt.Fprintf(r.out, "FAIL  %s: %v\n", name, err)
+		return
+	}
+	if detail != "" {
+		fmt.Fprintf(r.out, "ok    %s: %s\n", name, detail)
+	} else {
+		fmt.Fprintf(r.out, "ok    %s\n", name)
+	}
+}
+
+func (r *checkReport) err() error {
+	if r.failures > 0 {
+		return fmt.Errorf("%d check(s) failed", r.failures)
+	}
+	return nil
+}
+
+// runCheck runs the self-test. Checks that depend on a failed one are
+// skipped, the others run regardless so a single run shows every problem.
+func runCheck(cmd *cobra.Command, canary string) error {
+	report := &checkReport{out: cmd.OutOrStdout()}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := server.ValidateConfig(cfg); err != nil {
+		report.result("configuration", err, "")
+		return report.err()
+	}
+	report.result("configuration", nil, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	client, appLogger, err := connectMatrix(cfg)
+	if err != nil {
+		report.result("matrix login", err, "")
+	} else {
+		defer closeMatrix(client, appLogger)
+		checkMatrix(ctx, report, client, cfg, canary)
+	}
+
+	checkWebhooks(ctx, report, cfg)
+	if err := report.err(); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "All checks passed")
+	return nil
+}
+
+// checkMatrix checks the login, room membership, encryption and optionally
+// sends the canary message
+func checkMatrix(ctx context.Context, report *checkReport, client *matrix.Client, cfg *config.Config, canary string) {
+	userID, err := client.Whoami(ctx)
+	if err != nil {
+		report.result("matrix login", err, "")
+		return
+	}
+	report.result("matrix login", nil, fmt.Sprintf("%s, device %s", userID, client.GetDeviceID()))
+
+	roomIDs := []string{cfg.Matrix.RoomID}
+	for _, room := range cfg.Rooms {
+		if room.RoomID != cfg.Matrix.RoomID {
+			roomIDs = append(roomIDs, room.RoomID)
+		}
+	}
+	for _, roomID := range roomIDs {
+		joined, err := client.EnsureJoined(ctx, id.RoomID(roomID))
+		detail := "member"
+		if joined {
+			detail = "joined"
+		}
+		report.result("room "+roomID, err, detail)
+	}
+
+	if cfg.Matrix.EnableEncryption {
+		status := client.SyncStatus()
+		report.result("encryption", status.EncryptionError, fmt.Sprintf("device %s is set up and verified", client.GetDeviceID()))
+	}
+
+	if canary != "" {
+		eventID, err := client.SendMessage(canary, matrix.WithMsgType(matrix.MsgTypeNotice))
+		report.result("canary message", err, string(eventID))
+	}
+}
+
+// checkWebhooks sends a HEAD request to every configured webhook URL.
+// Command templates that run local commands are not probed.
+func checkWebhooks(ctx context.Context, report *checkReport, cfg *config.Config) {
+	timeout := time.Duration(cfg.Webhook.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	for _, result := range webhook.ProbeAll(ctx, webhook.Endpoints(cfg), timeout, cfg.Webhook.UserAgent) {
+		report.result(result.Name, result.Err, result.Status)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHomeserver answers the requests of the check command. The bot is a
+// member of !room:example.com only.
+func fakeHomeserver(t *testing.T) (*httptest.Server, *[]string) {
+	var mutex sync.Mutex
+	var requests []string
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/account/whoami"):
+			w.Write([]byte(`{"user_id": "@bot:example.com", "device_id": "TESTDEVICE"}`))
+		case strings.HasSuffix(r.URL.Path, "/joined_rooms"):
+			w.Write([]byte(`{"joined_rooms": ["!room:example.com"]}`))
+		case strings.Contains(r.URL.Path, "/join"):
+			w.Write([]byte(`{"room_id": "!other:example.com"}`))
+		case strings.Contains(r.URL.Path, "/send/"):
+			w.Write([]byte(`{"event_id": "$canary"}`))
+		case strings.HasSuffix(r.URL.Path, "/sync"):
+			select {
+			case <-time.After(50 * time.Millisecond):
+				w.Write([]byte(`{"next_batch": "s1"}`))
+			case <-r.Context().Done():
+			}
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	t.Cleanup(homeserver.Close)
+	return homeserver, &requests
+}
+
+func TestCheckCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	homeserver, requests := fakeHomeserver(t)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	noHead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	defer noHead.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	base := `matrix:
+  homeserver: "` + homeserver.URL + `"
+  userid: "@bot:example.com"
+  accesstoken: "syt_token"
+  deviceid: "TESTDEVICE"
+  roomid: "!room:example.com"
+  enable_encryption: false
+rooms:
+  - room_id: "!other:example.com"
+webhook:
+  default: "` + up.URL + `"
+  commands:
+    legacy: "` + noHead.URL + `"
+`
+
+	out, err := runCommand(t, "", "check", "--canary", "--config", writeConfig(t, base))
+	if err != nil {
+		t.Fatalf("check error = %v, output:\n%s", err, out)
+	}
+	for _, want := range []string{
+		"ok    configuration\n",
+		"ok    matrix login: @bot:example.com, device TESTDEVICE\n",
+		"ok    room !room:example.com: member\n",
+		"ok    room !other:example.com: joined\n",
+		"ok    canary message: $canary\n",
+		"ok    webhook.commands.legacy: 501",
+		"ok    webhook.default: 200",
+		"All checks passed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	canaries := 0
+	for _, request := range *requests {
+		if strings.Contains(request, "/send/m.room.message/") {
+			canaries++
+		}
+	}
+	if canaries != 1 {
+		t.Errorf("sent %d canary messages, want 1", canaries)
+	}
+
+	// Every check runs, and any failure fails the command
+	broken := strings.Replace(base, up.URL, down.URL, 1)
+	out, err = runCommand(t, "", "check", "--config", writeConfig(t, broken))
+	if err == nil || err.Error() != "1 check(s) failed" {
+		t.Errorf("check with a failing webhook error = %v, want one failed check", err)
+	}
+	if !strings.Contains(out, "FAIL  webhook.default: ") || !strings.Contains(out, "ok    webhook.commands.legacy") || strings.Contains(out, "canary") {
+		t.Errorf("output =\n%s\nwant the failing webhook among the results", out)
+	}
+}
+
+func TestCheckCommandInvalidConfig(t *testing.T) {
+	path := writeConfig(t, testConfig+"logging:\n  level: verbose\n")
+	out, err := runCommand(t, "", "check", "--config", path)
+	if err == nil || err.Error() != "1 check(s) failed" {
+		t.Errorf("check error = %v, want one failed check", err)
+	}
+	// Nothing is checked with an invalid configuration
+	if !strings.HasPrefix(out, "FAIL  configuration: ") || strings.Contains(out, "matrix login") {
+		t.Errorf("output =\n%s\nwant only the configuration to fail", out)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with config files",
+	}
+	cmd.AddCommand(newConfigInitCommand())
+	return cmd
+}
+
+func newConfigInitCommand() *cobra.Command {
+	var output string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write an example config with every setting, its default and its documentation",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var example bytes.Buffer
+			if err := config.WriteExample(&example); err != nil {
+				return err
+			}
+			if output == "-" {
+				_, err := cmd.OutOrStdout().Write(example.Bytes())
+				return err
+			}
+
+			// Refuse to replace a config by accident
+			flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+			if force {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			}
+			file, err := os.OpenFile(output, flags, 0600)
+			if errors.Is(err, os.ErrExist) {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(example.Bytes()); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "config.yaml", "File to write, - for stdout")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite the file if it exists")
+	return cmd
+}
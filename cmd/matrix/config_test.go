@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigInitCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	out, err := runCommand(t, "", "config", "init", "--output", path)
+	if err != nil || !strings.Contains(out, "Wrote "+path) {
+		t.Fatalf("config init = %q, %v", out, err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing config is only replaced with --force
+	if err := os.WriteFile(path, []byte("# mine\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "", "config", "init", "--output", path); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("config init over an existing file error = %v, want it to refuse", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "# mine\n" {
+		t.Errorf("existing file was changed to %q", data)
+	}
+	if _, err := runCommand(t, "", "config", "init", "--output", path, "--force"); err != nil {
+		t.Errorf("config init --force error = %v", err)
+	}
+
+	stdout, err := runCommand(t, "", "config", "init", "-o", "-")
+	if err != nil || stdout != string(written) {
+		t.Errorf("config init -o - error = %v, want the same example as the file", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/spf13/cobra"
+)
+
+func newCryptoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "crypto",
+		Short: "Back up and restore the encryption keys of the bot's device",
+	}
+	cmd.AddCommand(newCryptoBackupCommand(), newCryptoRestoreCommand())
+	return cmd
+}
+
+// loadCryptoConfig loads the configuration without generating a pickle key,
+// as a new key cannot decrypt an existing crypto store
+func loadCryptoConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.Matrix.PickleKey == "" || cfg.Matrix.PickleKey == "your_pickle_key_here" {
+		return nil, errors.New("matrix.picklekey is not set, set the pickle key the crypto store was created with")
+	}
+	return cfg, nil
+}
+
+func newCryptoBackupCommand() *cobra.Command {
+	var output string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write a snapshot of the crypto store and the device identity to a file",
+		Long: `Writes matrix_crypto.db, with the user, device ID and identity keys of the
+bot's device, to a gzipped tar archive. The service may be running. The
+access token of the device is kept encrypted with matrix.picklekey, which is
+not part of the backup: restoring needs the same pickle key.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCryptoConfig()
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			var file *os.File
+			if output != "-" {
+				// Refuse to replace a backup by accident
+				flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+				if force {
+					flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+				}
+				file, err = os.OpenFile(output, flags, 0600)
+				if errors.Is(err, os.ErrExist) {
+					return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+				}
+				if err != nil {
+					return err
+				}
+				w = file
+			}
+
+			backup, err := matrix.BackupCrypto(w, &cfg.Matrix, matrix.CryptoDBPath)
+			if file != nil {
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					os.Remove(output)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if file != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Wrote backup of device %s of %s to %s\n", backup.DeviceID, backup.UserID, output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "matrix_crypto-backup.tar.gz", "File to write, - for stdout")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite the file if it exists")
+	return cmd
+}
+
+func newCryptoRestoreCommand() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore the crypto store and the device identity from a backup",
+		Long: `Restores matrix_crypto.db from a backup written by crypto backup, - reading
+it from stdin, and writes the device ID and access token of the backed up
+device to the config file, so the bot keeps its device, its verification and
+the keys of past messages. matrix.picklekey must be the pickle key of the host
+the backup was taken on, and matrix.userid, if set, its user. Stop the
+service first; an existing crypto store is only replaced with --force.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCryptoConfig()
+			if err != nil {
+				return err
+			}
+
+			var r io.Reader = cmd.InOrStdin()
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+
+			backup, err := matrix.RestoreCrypto(r, &cfg.Matrix, matrix.CryptoDBPath, force)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Restored device %s of %s, backed up %s\n", backup.DeviceID, backup.UserID, backup.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+
+			cfg.Matrix.DeviceID = backup.DeviceID
+			if backup.AccessToken != "" {
+				cfg.Matrix.AccessToken = backup.AccessToken
+			}
+			err = config.SaveConfig(cfg)
+			if errors.Is(err, config.ErrNoConfigFile) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "No config file to save the device to, set %s_MATRIX_DEVICEID to %s and %s_MATRIX_ACCESSTOKEN to the access token of the device\n", config.EnvPrefix, backup.DeviceID, config.EnvPrefix)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to save the device to the config: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing crypto store")
+	return cmd
+}
@@ -1,79 +1,92 @@
 package main
 
 import (
+	"errors"
 	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
 	"github.com/mule-ai/mule/matrix-microservice/internal/config"
-	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
-	"github.com/mule-ai/mule/matrix-microservice/internal/server"
+	"github.com/spf13/cobra"
 )
 
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
 	}
+}
 
-	// Generate pickle key if not set
-	if cfg.EnsurePickleKey() {
-		log.Println("Generated new pickle key, saving to config...")
-		if err := config.SaveConfig(cfg); err != nil {
-			log.Printf("Warning: Failed to save pickle key to config: %v", err)
-		} else {
-			log.Println("Pickle key saved to config.yaml")
-		}
-	}
+// newRootCommand builds the CLI. Without a subcommand the service is started,
+// as before subcommands existed.
+func newRootCommand() *cobra.Command {
+	var configFile, profile string
+	var validate, check bool
 
-	// Initialize logger
-	appLogger, err := logger.New(&cfg.Logging)
-	if err != nil {
-		log.Fatalf("Failed to initialize logger: %v", err)
+	root := &cobra.Command{
+		Use:          "matrix-microservice",
+		Short:        "Bridge between a Matrix room and webhooks, commands and monitoring hooks",
+		Version:      buildinfo.Get().String(),
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if configFile != "" {
+				if err := config.SetConfigFile(configFile); err != nil {
+					return err
+				}
+			}
+			if profile != "" {
+				return config.SetProfile(profile)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if validate {
+				return runValidate(cmd)
+			}
+			if check {
+				return runCheck(cmd, "")
+			}
+			return runServe()
+		},
 	}
+	root.SetVersionTemplate("matrix-microservice {{.Version}}\n")
+	root.PersistentFlags().StringVar(&configFile, "config", "", "Config file to use (.yaml, .yml, .json or .toml) instead of searching for config.yaml")
+	root.PersistentFlags().StringVar(&profile, "profile", "", "Overlay merged over the config file, e.g. prod for config.prod.yaml (default $APP_ENV)")
+	root.Flags().BoolVar(&validate, "validate", false, "Check the configuration and exit")
+	root.Flags().MarkDeprecated("validate", "use the validate command instead")
+	root.Flags().BoolVar(&check, "check", false, "Run the deployment self-test and exit, like the check command")
 
-	appLogger.Info("Starting Matrix microservice")
+	root.AddCommand(
+		newServeCommand(),
+		newValidateCommand(),
+		newVerifyDeviceCommand(),
+		newCryptoCommand(),
+		newCheckCommand(),
+		newSendCommand(),
+		newConfigCommand(),
+		newAuditCommand(),
+		newReplayCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
 
-	// Create server
-	srv, err := server.New(cfg, appLogger)
+// loadConfig loads the configuration and generates a pickle key if none is
+// set, since every command that connects to Matrix needs one
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		appLogger.Error("Failed to create server: %v", err)
-		log.Fatalf("Failed to create server: %v", err)
+		return nil, err
 	}
 
-	// After Matrix client is initialized, save any updated credentials
-	// (e.g., device ID may have changed after first login)
-	if err := config.SaveConfig(cfg); err != nil {
-		appLogger.Warn("Failed to save config after startup: %v", err)
-	} else {
-		appLogger.Debug("Config saved successfully")
-	}
-
-	// Handle shutdown gracefully
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Start server in a goroutine
-	go func() {
-		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
-			appLogger.Error("Failed to start server: %v", err)
-			log.Fatalf("Failed to start server: %v", err)
+	if cfg.EnsurePickleKey() {
+		log.Println("Generated new pickle key, saving to config...")
+		if err := config.SaveConfig(cfg); errors.Is(err, config.ErrNoConfigFile) {
+			log.Printf("Warning: No config file to save the pickle key to, set %s_MATRIX_PICKLEKEY to keep encryption state across restarts", config.EnvPrefix)
+		} else if err != nil {
+			log.Printf("Warning: Failed to save pickle key to config: %v", err)
+		} else {
+			log.Println("Pickle key saved to config file")
 		}
-	}()
-
-	appLogger.Info("Server started successfully on port %d", cfg.Server.Port)
-
-	// Wait for shutdown signal
-	<-sigChan
-	appLogger.Info("Shutting down server...")
-
-	// Stop server
-	if err := srv.Stop(); err != nil {
-		appLogger.Error("Error stopping server: %v", err)
 	}
-
-	appLogger.Info("Server stopped")
+	return cfg, nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// runCommand runs the CLI with the given arguments and stdin and returns
+// its output
+func runCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var out bytes.Buffer
+	root := newRootCommand()
+	root.SetArgs(args)
+	root.SetIn(strings.NewReader(stdin))
+	root.SetOut(&out)
+	root.SetErr(&out)
+	err := root.Execute()
+	return out.String(), err
+}
+
+// writeConfig writes a config file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfig = `matrix:
+  homeserver: "https://matrix.example.com"
+  userid: "@bot:example.com"
+  accesstoken: "syt_token"
+  roomid: "!room:example.com"
+`
+
+func TestValidateCommand(t *testing.T) {
+	valid := writeConfig(t, testConfig)
+	invalid := writeConfig(t, testConfig+"logging:\n  level: verbose\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"valid", []string{"validate", "--config", valid}, "Configuration is valid", ""},
+		{"deprecated flag", []string{"--validate", "--config", valid}, "Configuration is valid", ""},
+		{"invalid", []string{"validate", "--config", invalid}, "", "logging.level"},
+		{"missing file", []string{"validate", "--config", filepath.Join(t.TempDir(), "missing.yaml")}, "", "failed to load config"},
+		{"unsupported format", []string{"validate", "--config", "config.ini"}, "", "unsupported config file"},
+		{"arguments", []string{"validate", "extra"}, "", "unknown command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCommand(t, "", tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("output = %q, want it to contain %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	for _, args := range [][]string{{"version"}, {"--version"}} {
+		out, err := runCommand(t, "", args...)
+		if err != nil || !strings.HasPrefix(out, "matrix-microservice ") {
+			t.Errorf("%v = %q, %v, want the version", args, out, err)
+		}
+	}
+}
+
+func TestSendCommandEmptyMessage(t *testing.T) {
+	// The message is checked before connecting to Matrix
+	for _, args := range [][]string{{"send"}, {"send", "-"}} {
+		if _, err := runCommand(t, "  \n", args...); err == nil || !strings.Contains(err.Error(), "the message is empty") {
+			t.Errorf("%v error = %v, want the empty message error", args, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+// connectMatrix validates the configuration and connects to Matrix like the
+// service does, including encryption setup, for one-off commands
+func connectMatrix(cfg *config.Config) (*matrix.Client, *logger.Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	appLogger, err := logger.New(&cfg.Logging)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	appLogger.AddSecrets(cfg.SecretValues()...)
+
+	client, err := matrix.New(&cfg.Matrix, appLogger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the device of a first login, as the service does
+	if deviceID := client.GetDeviceID(); deviceID != "" && deviceID != cfg.Matrix.DeviceID {
+		cfg.Matrix.DeviceID = deviceID
+	}
+	if err := config.SaveConfig(cfg); err != nil && !errors.Is(err, config.ErrNoConfigFile) {
+		appLogger.Warn("Failed to save config: %v", err)
+	}
+	return client, appLogger, nil
+}
+
+// closeMatrix stops the sync loop started by connectMatrix
+func closeMatrix(client *matrix.Client, appLogger *logger.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.Close(ctx); err != nil {
+		appLogger.Warn("Failed to close Matrix client: %v", err)
+	}
+}
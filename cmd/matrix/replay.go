@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/server"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"github.com/spf13/cobra"
+)
+
+func newReplayCommand() *cobra.Command {
+	var dir string
+	var failOnChange bool
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Replay recorded dispatches against the configuration and show what changed",
+		Long: `Dispatches the messages recorded to webhook.dev.record_dir (or --dir) again
+with the current configuration: the command's webhook, template, payload
+script, auth token, response pages and jq selector, and the defaults of the
+room or tenant the message came from. Requests are answered with the
+recorded responses in order, so no backend is contacted.
+
+Every recording whose requests, reply or error differ is printed with the
+differences. With --fail-on-change the exit status is 1 if any did.`,
+		Example: `  matrix-microservice replay --config config.next.yaml
+  matrix-microservice replay --dir recordings/ --fail-on-change`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if err := server.ValidateConfig(cfg); err != nil {
+				return err
+			}
+			if dir == "" {
+				if dir = cfg.Webhook.Dev.RecordDir; dir == "" {
+					return fmt.Errorf("webhook.dev.record_dir is not set, pass --dir")
+				}
+			}
+			recordings, err := webhook.LoadRecordings(dir)
+			if err != nil {
+				return fmt.Errorf("failed to read recordings: %w", err)
+			}
+
+			// Logs of the dispatches would drown the report
+			appLogger, err := logger.New(&config.LoggingConfig{Level: "error"})
+			if err != nil {
+				return fmt.Errorf("failed to create logger: %w", err)
+			}
+			changed := replayRecordings(cmd.OutOrStdout(), cfg, recordings, webhook.NewReplayer(&cfg.Webhook, appLogger))
+			fmt.Fprintf(cmd.OutOrStdout(), "%d dispatches replayed, %d changed\n", len(recordings), changed)
+			if failOnChange && changed > 0 {
+				return fmt.Errorf("%d dispatches changed", changed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory of the recordings (default webhook.dev.record_dir)")
+	cmd.Flags().BoolVar(&failOnChange, "fail-on-change", false, "Exit with status 1 if any dispatch changed")
+	return cmd
+}
+
+// replayRecordings replays every recording, prints those that changed and
+// returns how many did
+func replayRecordings(out io.Writer, cfg *config.Config, recordings []*webhook.Recording, replayer *webhook.Dispatcher) int {
+	changed := 0
+	for _, recorded := range recordings {
+		replayed := replayer.Replay(context.Background(), recorded, replayOptions(cfg, recorded.RoomID)...)
+		diffs := recorded.Compare(replayed)
+		if len(diffs) == 0 {
+			continue
+		}
+		changed++
+		fmt.Fprintf(out, "CHANGED %s (%s)\n", recorded.File, strings.Join(strings.Fields(recorded.Message), " "))
+		for _, diff := range diffs {
+			fmt.Fprintf(out, "  %s\n    - %s\n    + %s\n", diff.Field, diff.Recorded, diff.Replayed)
+		}
+	}
+	return changed
+}
+
+// replayOptions returns the dispatch options of messages of the room, its
+// tenant's webhooks and then its defaults, like the server applies them
+func replayOptions(cfg *config.Config, roomID string) []webhook.DispatchOption {
+	var opts []webhook.DispatchOption
+	for i := range cfg.Tenants {
+		for _, room := range cfg.Tenants[i].Rooms {
+			if room == roomID {
+				opts = append(opts, webhook.WithTenant(&cfg.Tenants[i]))
+			}
+		}
+	}
+	for i := range cfg.Rooms {
+		if cfg.Rooms[i].RoomID == roomID {
+			opts = append(opts, webhook.WithRoomDefaults(&cfg.Rooms[i].Webhook))
+		}
+	}
+	return opts
+}
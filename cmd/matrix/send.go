@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/spf13/cobra"
+	"maunium.net/go/mautrix/id"
+)
+
+func newSendCommand() *cobra.Command {
+	var roomID, format, msgType, threadRoot, inReplyTo string
+
+	cmd := &cobra.Command{
+		Use:   "send [message...]",
+		Short: "Send a message to Matrix and exit",
+		Long: `Sends a message like POST /message does, without a running service. The
+message is taken from the arguments, or read from stdin if there are none or
+the only argument is "-".`,
+		Example: `  matrix-microservice send "Deploy finished"
+  make test 2>&1 | tail -n 20 | matrix-microservice send --format plain`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			message := strings.Join(args, " ")
+			if len(args) == 0 || message == "-" {
+				data, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return fmt.Errorf("failed to read message: %w", err)
+				}
+				message = strings.TrimSpace(string(data))
+			}
+			if message == "" {
+				return fmt.Errorf("the message is empty")
+			}
+
+			var opts []matrix.SendMessageOption
+			if roomID != "" {
+				opts = append(opts, matrix.WithRoom(id.RoomID(roomID)))
+			}
+			if format != "" {
+				opts = append(opts, matrix.WithFormat(format))
+			}
+			if msgType != "" {
+				opts = append(opts, matrix.WithMsgType(msgType))
+			}
+			if threadRoot != "" {
+				opts = append(opts, matrix.WithThread(id.EventID(threadRoot)))
+			}
+			if inReplyTo != "" {
+				opts = append(opts, matrix.WithReplyTo(id.EventID(inReplyTo)))
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			client, appLogger, err := connectMatrix(cfg)
+			if err != nil {
+				return err
+			}
+			defer closeMatrix(client, appLogger)
+
+			eventID, err := client.SendMessage(message, opts...)
+			if err != nil {
+				return fmt.Errorf("failed to send message: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), eventID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "Room ID to post to (default matrix.roomid)")
+	cmd.Flags().StringVar(&format, "format", "", "How the message is interpreted: markdown (default), html or plain")
+	cmd.Flags().StringVar(&msgType, "msgtype", "", "text (default) or notice")
+	cmd.Flags().StringVar(&threadRoot, "thread", "", "Event ID of a thread root to post in")
+	cmd.Flags().StringVar(&inReplyTo, "reply-to", "", "Event ID to reply to")
+	return cmd
+}
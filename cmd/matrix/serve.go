@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Run the service (the default without a command)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe()
+		},
+	}
+}
+
+// runServe runs the service until SIGINT or SIGTERM
+func runServe() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize logger
+	appLogger, err := logger.New(&cfg.Logging)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	appLogger.AddSecrets(cfg.SecretValues()...)
+
+	// Startup lines say what is deployed
+	build := buildinfo.Get()
+	startLog := appLogger.With("version", build.Version)
+	startLog.Info("Starting Matrix microservice %s", build)
+
+	// Create server
+	srv, err := server.New(cfg, appLogger)
+	if err != nil {
+		appLogger.Error("Failed to create server: %v", err)
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+
+	// After Matrix client is initialized, save any updated credentials
+	// (e.g., device ID may have changed after first login)
+	if err := config.SaveConfig(cfg); errors.Is(err, config.ErrNoConfigFile) {
+		appLogger.Debug("Configured from the environment, not saving config")
+	} else if err != nil {
+		appLogger.Warn("Failed to save config after startup: %v", err)
+	} else {
+		appLogger.Debug("Config saved successfully")
+	}
+
+	// Handle shutdown gracefully
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start server in a goroutine
+	serveErr := make(chan error, 1)
+	go func() {
+		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
+			appLogger.Error("Failed to start server: %v", err)
+			serveErr <- err
+		}
+	}()
+
+	startLog.Info("Server started successfully on port %d", cfg.Server.Port)
+
+	// Wait for shutdown signal
+	select {
+	case <-sigChan:
+	case err := <-serveErr:
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	appLogger.Info("Shutting down server...")
+
+	// Drain in-flight work, giving up after the configured timeout
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout) * time.Second
+	appLogger.Info("Waiting up to %v for in-flight work to finish", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		appLogger.Error("Error stopping server: %v", err)
+	}
+
+	appLogger.Info("Server stopped")
+	return nil
+}
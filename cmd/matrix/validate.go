@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration and exit without starting anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runValidate(cmd)
+		},
+	}
+}
+
+// runValidate loads and checks the configuration. Unlike the other commands
+// it never writes to the config file.
+func runValidate(cmd *cobra.Command) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := server.ValidateConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+	return nil
+}
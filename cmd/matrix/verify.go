@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newVerifyDeviceCommand() *cobra.Command {
+	var prompt bool
+
+	cmd := &cobra.Command{
+		Use:   "verify-device",
+		Short: "Verify the bot's device with the recovery key",
+		Long: `Connects to Matrix, sets up encryption and verifies the device with the
+recovery key, reporting whether it worked. The key is read from the terminal
+(or stdin) if matrix.recoverykey is not set, or with --prompt. A key that was
+entered is not saved.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if !cfg.Matrix.EnableEncryption {
+				return fmt.Errorf("matrix.enable_encryption is off, there is nothing to verify")
+			}
+
+			if prompt || cfg.Matrix.RecoveryKey == "" || cfg.Matrix.RecoveryKey == "your_recovery_key_here" {
+				fmt.Fprint(cmd.ErrOrStderr(), "Recovery key: ")
+				line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("failed to read recovery key: %w", err)
+				}
+				cfg.Matrix.RecoveryKey = strings.TrimSpace(line)
+			}
+
+			client, appLogger, err := connectMatrix(cfg)
+			if err != nil {
+				return err
+			}
+			defer closeMatrix(client, appLogger)
+
+			if err := client.SyncStatus().EncryptionError; err != nil {
+				return fmt.Errorf("device %s was not verified: %w", client.GetDeviceID(), err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Device %s is verified\n", client.GetDeviceID())
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&prompt, "prompt", false, "Ask for the recovery key even if matrix.recoverykey is set")
+	return cmd
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/spf13/cobra"
+)
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit and build date",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintf(cmd.OutOrStdout(), "matrix-microservice %s\n", buildinfo.Get())
+		},
+	}
+}
@@ -0,0 +1,277 @@
+// Package archive keeps the messages the service handles and the replies it
+// sends in S3-compatible object storage. Records are buffered and written as
+// batches of JSON lines, one object per batch, and batches older than the
+// retention are deleted once a day.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// Directions of archived messages
+const (
+	DirectionIn  = "in"  // Received by the bot
+	DirectionOut = "out" // Sent by the bot
+)
+
+const (
+	// Batches kept in memory at most while the storage is unreachable; the
+	// oldest records are dropped beyond
+	maxPendingBatches = 10
+	pruneInterval     = 24 * time.Hour
+	contentType       = "application/x-ndjson"
+	keyTimeLayout     = "20060102T150405Z"
+)
+
+// Record is an archived message
+type Record struct {
+	Time       time.Time `json:"time"`
+	Direction  string    `json:"direction"`
+	RoomID     string    `json:"room_id"`
+	EventID    string    `json:"event_id,omitempty"`
+	Sender     string    `json:"sender"`
+	ThreadRoot string    `json:"thread_root,omitempty"`
+	InReplyTo  string    `json:"in_reply_to,omitempty"`
+	Body       string    `json:"body"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Stats counts the records archived since startup
+type Stats struct {
+	Archived uint64 // Written to the storage
+	Dropped  uint64 // Dropped because too many were pending
+	Failed   uint64 // Failed writes of batches, which are retried
+	Pending  int    // Waiting to be written
+}
+
+// Archiver writes records to a bucket in the background, so that a slow or
+// unreachable storage does not hold up the Matrix sync
+type Archiver struct {
+	client        *s3Client
+	prefix        string
+	batchSize     int
+	flushInterval time.Duration
+	retention     time.Duration // Zero keeps batches forever
+	timeout       time.Duration
+	instance      string // Tells the batches of several replicas apart
+	now           func() time.Time
+	logger        *logger.Logger
+
+	mutex    sync.Mutex
+	pending  []Record
+	sequence uint64
+	stats    Stats
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns an archiver writing to the bucket of cfg
+func New(cfg *config.ArchiveConfig, log *logger.Logger) (*Archiver, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("archive.endpoint: %w", err)
+	}
+	instance := make([]byte, 4)
+	if _, err := rand.Read(instance); err != nil {
+		return nil, err
+	}
+	a := &Archiver{
+		prefix:        cfg.Prefix,
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushInterval) * time.Second,
+		retention:     time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		timeout:       time.Duration(cfg.Timeout) * time.Second,
+		instance:      hex.EncodeToString(instance),
+		now:           time.Now,
+		logger:        log,
+		wake:          make(chan struct{}, 1),
+	}
+	a.client = &s3Client{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		pathStyle: cfg.PathStyle,
+		region:    cfg.Region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		http:      &http.Client{},
+		now:       func() time.Time { return a.now() },
+	}
+	return a, nil
+}
+
+// Add queues a record, writing the batch at once when it is full
+func (a *Archiver) Add(r Record) {
+	if r.Time.IsZero() {
+		r.Time = a.now()
+	}
+	r.Time = r.Time.UTC()
+
+	a.mutex.Lock()
+	a.pending = append(a.pending, r)
+	a.trim()
+	full := len(a.pending) >= a.batchSize
+	a.mutex.Unlock()
+
+	if full {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest pending records beyond maxPendingBatches batches.
+// The mutex must be held.
+func (a *Archiver) trim() {
+	if excess := len(a.pending) - maxPendingBatches*a.batchSize; excess > 0 {
+		a.pending = append([]Record(nil), a.pending[excess:]...)
+		a.stats.Dropped += uint64(excess)
+		a.logger.Warn("Archive storage is behind, dropped %d records", excess)
+	}
+}
+
+// Stats returns the counts of archived records
+func (a *Archiver) Stats() Stats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	stats := a.stats
+	stats.Pending = len(a.pending)
+	return stats
+}
+
+// Start writes batches and deletes expired ones in the background until Stop
+func (a *Archiver) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		flushTicker := time.NewTicker(a.flushInterval)
+		defer flushTicker.Stop()
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+		if a.retention > 0 {
+			a.prune(ctx)
+		}
+		for {
+			select {
+			case <-a.wake:
+				a.flush(ctx, false)
+			case <-flushTicker.C:
+				a.flush(ctx, true)
+			case <-pruneTicker.C:
+				if a.retention > 0 {
+					a.prune(ctx)
+				}
+			case <-ctx.Done():
+				// Write what was queued before stopping
+				a.flush(context.Background(), true)
+				return
+			}
+		}
+	}()
+}
+
+// Stop writes the records still pending and stops
+func (a *Archiver) Stop() {
+	if a.cancel == nil {
+		return
+	}
+	a.cancel()
+	<-a.done
+}
+
+// flush writes the full batches pending, and the last one that is not full
+// if all is set. A batch that cannot be written is put back to be retried.
+func (a *Archiver) flush(ctx context.Context, all bool) {
+	for {
+		a.mutex.Lock()
+		n := len(a.pending)
+		if n > a.batchSize {
+			n = a.batchSize
+		}
+		if n == 0 || (n < a.batchSize && !all) {
+			a.mutex.Unlock()
+			return
+		}
+		batch := a.pending[:n:n]
+		a.pending = a.pending[n:]
+		a.sequence++
+		key := a.key(a.sequence)
+		a.mutex.Unlock()
+
+		if err := a.write(ctx, key, batch); err != nil {
+			a.logger.Error("Failed to archive %d records to %s: %v", len(batch), key, err)
+			a.mutex.Lock()
+			a.pending = append(batch, a.pending...)
+			a.stats.Failed++
+			a.trim()
+			a.mutex.Unlock()
+			return
+		}
+		a.mutex.Lock()
+		a.stats.Archived += uint64(len(batch))
+		a.mutex.Unlock()
+		a.logger.Debug("Archived %d records to %s", len(batch), key)
+	}
+}
+
+// key returns the object key of a batch, sorted by the time it is written
+func (a *Archiver) key(sequence uint64) string {
+	now := a.now().UTC()
+	return fmt.Sprintf("%s%s%s-%s-%06d.jsonl", a.prefix, now.Format("2006/01/02/"), now.Format(keyTimeLayout), a.instance, sequence)
+}
+
+func (a *Archiver) write(ctx context.Context, key string, batch []Record) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, r := range batch {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	return a.client.put(ctx, key, contentType, body.Bytes())
+}
+
+// prune deletes the batches written before the retention
+func (a *Archiver) prune(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	objects, err := a.client.list(ctx, a.prefix)
+	if err != nil {
+		a.logger.Error("Failed to list archived batches: %v", err)
+		return
+	}
+	cutoff := a.now().Add(-a.retention)
+	deleted := 0
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, ".jsonl") || !object.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := a.client.delete(ctx, object.Key); err != nil {
+			a.logger.Error("Failed to delete archived batch %s: %v", object.Key, err)
+			return
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		a.logger.Info("Deleted %d archived batches older than %s", deleted, cutoff.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// fakeBucket is an S3-compatible bucket named "archive" kept in memory
+type fakeBucket struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	modified map[string]time.Time
+	fail     bool
+	auth     []string
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{objects: make(map[string]string), modified: make(map[string]time.Time)}
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.auth = append(b.auth, r.Header.Get("Authorization"))
+	if b.fail {
+		http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/archive/")
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		b.objects[key] = string(body)
+		b.modified[key] = time.Now()
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		keys := make([]string, 0, len(b.objects))
+		for key := range b.objects {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, key := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified></Contents>", key, b.modified[key].UTC().Format(time.RFC3339))
+		}
+		fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+	}
+}
+
+func (b *fakeBucket) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testArchiver(t *testing.T, endpoint string, batchSize int) *Archiver {
+	t.Helper()
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	a, err := New(&config.ArchiveConfig{
+		Endpoint:      endpoint,
+		Region:        "us-east-1",
+		Bucket:        "archive",
+		Prefix:        "matrix/",
+		AccessKey:     "access",
+		SecretKey:     "secret",
+		PathStyle:     true,
+		BatchSize:     batchSize,
+		FlushInterval: 3600,
+		RetentionDays: 7,
+		Timeout:       5,
+	}, log)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return a
+}
+
+func TestArchiverFlush(t *testing.T) {
+	bucket := newFakeBucket()
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	a := testArchiver(t, srv.URL, 2)
+
+	a.Add(Record{Direction: DirectionIn, RoomID: "!room:example.com", EventID: "$1", Sender: "@alice:example.com", Body: "hello"})
+	a.flush(context.Background(), false)
+	if keys := bucket.keys(); len(keys) != 0 {
+		t.Fatalf("Batch that is not full written: %v", keys)
+	}
+	a.Add(Record{Direction: DirectionOut, RoomID: "!room:example.com", EventID: "$2", Sender: "@bot:example.com", InReplyTo: "$1", Body: "hi"})
+	a.Add(Record{Direction: DirectionIn, RoomID: "!room:example.com", EventID: "$3", Sender: "@alice:example.com", Body: "bye"})
+	a.flush(context.Background(), true)
+
+	keys := bucket.keys()
+	if len(keys) != 2 {
+		t.Fatalf("Batches = %v, want 2", keys)
+	}
+	if !strings.HasPrefix(keys[0], "matrix/") || !strings.HasSuffix(keys[0], "-000001.jsonl") || !strings.HasSuffix(keys[1], "-000002.jsonl") {
+		t.Errorf("Keys = %v", keys)
+	}
+	var records []Record
+	for scanner := bufio.NewScanner(strings.NewReader(bucket.objects[keys[0]])); scanner.Scan(); {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 || records[0].Body != "hello" || records[1].Direction != DirectionOut || records[1].InReplyTo != "$1" || records[0].Time.IsZero() {
+		t.Errorf("First batch = %+v", records)
+	}
+	if !strings.HasPrefix(bucket.auth[0], "AWS4-HMAC-SHA256 Credential=access/") {
+		t.Errorf("Authorization = %q", bucket.auth[0])
+	}
+	if stats := a.Stats(); stats.Archived != 3 || stats.Pending != 0 || stats.Failed != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestArchiverRetry(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.fail = true
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	a := testArchiver(t, srv.URL, 1)
+
+	for i := 0; i < 12; i++ {
+		a.Add(Record{Direction: DirectionIn, Body: fmt.Sprint(i)})
+	}
+	a.flush(context.Background(), true)
+	if stats := a.Stats(); stats.Failed != 1 || stats.Pending != 10 || stats.Dropped != 2 {
+		t.Fatalf("Stats() after a failure = %+v, want 1 failed, 10 pending and 2 dropped", stats)
+	}
+
+	bucket.mu.Lock()
+	bucket.fail = false
+	bucket.mu.Unlock()
+	a.Start()
+	a.Stop()
+	if stats := a.Stats(); stats.Archived != 10 || stats.Pending != 0 {
+		t.Errorf("Stats() after Stop = %+v, want the pending records written", stats)
+	}
+	if keys := bucket.keys(); len(keys) != 10 || !strings.Contains(bucket.objects[keys[0]], `"body":"2"`) {
+		t.Errorf("Batches = %v, want the 10 newest records", keys)
+	}
+}
+
+func TestArchiverPrune(t *testing.T) {
+	bucket := newFakeBucket()
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	a := testArchiver(t, srv.URL, 10)
+
+	now := time.Now()
+	bucket.objects["matrix/old.jsonl"] = "{}\n"
+	bucket.modified["matrix/old.jsonl"] = now.Add(-8 * 24 * time.Hour)
+	bucket.objects["matrix/new.jsonl"] = "{}\n"
+	bucket.modified["matrix/new.jsonl"] = now.Add(-6 * 24 * time.Hour)
+	bucket.objects["other/old.jsonl"] = "{}\n"
+	bucket.modified["other/old.jsonl"] = now.Add(-30 * 24 * time.Hour)
+
+	a.prune(context.Background())
+	if keys := bucket.keys(); strings.Join(keys, ",") != "matrix/new.jsonl,other/old.jsonl" {
+		t.Errorf("Objects after prune = %v, want the expired batch deleted", keys)
+	}
+}
@@ -0,0 +1,40 @@
+package archive
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, sha256Hex(nil), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	tests := []struct {
+		in          string
+		encodeSlash bool
+		want        string
+	}{
+		{"", false, "/"},
+		{"/bucket/a b/ü.jsonl", false, "/bucket/a%20b/%C3%BC.jsonl"},
+		{"matrix-archive/2024", true, "matrix-archive%2F2024"},
+		{"a+b=c~", true, "a%2Bb%3Dc~"},
+	}
+	for _, tt := range tests {
+		if got := uriEncode(tt.in, tt.encodeSlash); got != tt.want {
+			t.Errorf("uriEncode(%q, %v) = %q, want %q", tt.in, tt.encodeSlash, got, tt.want)
+		}
+	}
+}
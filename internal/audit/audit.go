@@ -0,0 +1,289 @@
+// Package audit keeps an append-only, tamper-evident log of the commands the
+// service handles. Records are JSON lines in one file per day (UTC), and each
+// record holds the hash of the previous one, so that editing or removing a
+// record breaks the chain, which Verify detects.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of audited commands
+const (
+	KindWebhook = "webhook" // Dispatched to a webhook
+	KindExec    = "exec"    // Executed as a local command
+	KindLLM     = "llm"     // Answered by the LLM backend
+	KindCommand = "command" // Refused before it was routed
+)
+
+// Statuses of audited commands
+const (
+	StatusOK        = "ok"
+	StatusFailed    = "failed"
+	StatusRejected  = "rejected" // Not allowed for the sender or in the room
+	StatusDryRun    = "dry_run"
+	StatusDuplicate = "duplicate" // Already dispatched, e.g. for an event the sync delivered again
+)
+
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Record is one handled command
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Sender    string    `json:"sender"`
+	RoomID    string    `json:"room_id"`
+	EventID   string    `json:"event_id,omitempty"`
+	Command   string    `json:"command"`
+	// SHA-256 of the arguments, which are not recorded themselves
+	ArgsHash string `json:"args_hash,omitempty"`
+	Kind     string `json:"kind"`
+	// Webhook URL, or the command template that was executed
+	Target     string `json:"target"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	// Hash of the previous record and of this one, chaining the records
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// HashArgs returns the hash recorded for command arguments, "" for none
+func HashArgs(args string) string {
+	if args == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(args))
+	return hex.EncodeToString(sum[:])
+}
+
+// hash computes the hash of a record, which covers every field but Hash
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends records to the daily files in a directory
+type Log struct {
+	dir           string
+	retentionDays int
+	now           func() time.Time
+
+	mutex    sync.Mutex
+	file     *os.File
+	day      string // Day of the open file
+	lastHash string
+}
+
+// Open opens the audit log in dir, continuing the hash chain of the latest
+// file. Files older than retentionDays days are deleted (0 = keep them).
+func Open(dir string, retentionDays int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	l := &Log{dir: dir, retentionDays: retentionDays, now: time.Now}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last, err := lastRecord(files[len(files)-1])
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.lastHash = last.Hash
+		}
+	}
+	return l, nil
+}
+
+// Write completes a record with the time and hashes and appends it. The
+// file is synced before Write returns.
+func (l *Log) Write(record Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if record.Time.IsZero() {
+		record.Time = l.now()
+	}
+	record.Time = record.Time.UTC()
+	if err := l.openDay(record.Time.Format(dayLayout)); err != nil {
+		return err
+	}
+
+	record.PrevHash = l.lastHash
+	hash, err := record.hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash audit record: %w", err)
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	l.lastHash = hash
+	return nil
+}
+
+// openDay makes sure the file of the day is open, deleting expired files
+// when a new one is started
+func (l *Log) openDay(day string) error {
+	if l.file != nil && l.day == day {
+		return nil
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	path := filepath.Join(l.dir, filePrefix+day+fileSuffix)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file, l.day = file, day
+	return l.prune()
+}
+
+// prune deletes the files older than the retention period
+func (l *Log) prune() error {
+	if l.retentionDays <= 0 {
+		return nil
+	}
+	cutoff := l.now().UTC().AddDate(0, 0, -l.retentionDays).Format(dayLayout)
+	files, err := listFiles(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if fileDay(path) < cutoff {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to delete expired audit log: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the open file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Verify checks the hash chain of every file in dir and returns the number
+// of records. The first record may refer to a file deleted by the retention
+// period; any other break in the chain is reported with its location.
+func Verify(dir string) (int, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	prevHash := ""
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return count, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			location := fmt.Sprintf("%s:%d", filepath.Base(path), line)
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				file.Close()
+				return count, fmt.Errorf("%s: invalid record: %w", location, err)
+			}
+			if count > 0 && record.PrevHash != prevHash {
+				file.Close()
+				return count, fmt.Errorf("%s: chain broken, the previous record was changed or removed", location)
+			}
+			if hash, err := record.hash(); err != nil || hash != record.Hash {
+				file.Close()
+				return count, fmt.Errorf("%s: record was modified", location)
+			}
+			prevHash = record.Hash
+			count++
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// listFiles returns the audit files in dir, oldest first
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func fileDay(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), filePrefix), fileSuffix)
+}
+
+// lastRecord returns the last record of a file, nil if it is empty
+func lastRecord(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var record Record
+	if err := json.Unmarshal(data[bytes.LastIndexByte(data, '\n')+1:], &record); err != nil {
+		return nil, fmt.Errorf("%s: invalid last record: %w", filepath.Base(path), err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	for i, when := range []time.Time{day, day.Add(time.Minute), day.Add(2 * time.Minute)} {
+		record := Record{Time: when, Sender: "@alice:example.com", Command: "deploy", ArgsHash: HashArgs("prod"), Kind: KindExec, Status: StatusOK, DurationMS: int64(i)}
+		if err := log.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// The chain continues after reopening
+	log, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Write(Record{Time: day.Add(time.Hour), Command: "status", Kind: KindWebhook, Status: StatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	count, err := Verify(dir)
+	if err != nil || count != 4 {
+		t.Fatalf("Verify = %d, %v, want 4 records", count, err)
+	}
+	files, _ := listFiles(dir)
+	if len(files) != 2 {
+		t.Errorf("got files %v, want one per day", files)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		want   string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"status":"failed"`, `"status":"ok"`, 1)
+			return lines
+		}, "audit-2024-05-01.jsonl:2: record was modified"},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, "audit-2024-05-01.jsonl:2: chain broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			log, err := Open(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			for _, status := range []string{StatusOK, StatusFailed, StatusOK} {
+				if err := log.Write(Record{Time: now, Command: "deploy", Kind: KindExec, Status: status}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			log.Close()
+
+			path := filepath.Join(dir, "audit-2024-05-01.jsonl")
+			data, _ := os.ReadFile(path)
+			lines := tt.tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Verify(dir); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Verify error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	for _, day := range []string{"2024-04-01", "2024-04-25"} {
+		if err := os.WriteFile(filepath.Join(dir, "audit-"+day+".jsonl"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log, err := Open(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return now }
+	if err := log.Write(Record{Command: "deploy", Kind: KindExec, Status: StatusOK}); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	files, _ := listFiles(dir)
+	var days []string
+	for _, file := range files {
+		days = append(days, fileDay(file))
+	}
+	if strings.Join(days, ",") != "2024-04-25,2024-05-01" {
+		t.Errorf("files after pruning: %v", days)
+	}
+	// A chain starting in a deleted file still verifies
+	if _, err := Verify(dir); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Package buildinfo reports what was built: the version, commit and build
+// date set at build time with
+//
+//	go build -ldflags "-X github.com/mule-ai/mule/matrix-microservice/internal/buildinfo.version=v1.2.0
+//	  -X github.com/mule-ai/mule/matrix-microservice/internal/buildinfo.commit=$(git rev-parse HEAD)
+//	  -X github.com/mule-ai/mule/matrix-microservice/internal/buildinfo.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Whatever is not set falls back to what the Go toolchain recorded.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with -ldflags "-X"
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// Info describes the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information of the running binary. Without
+// ldflags the version is the module version, or dev with the VCS revision,
+// and the commit and build date are those of the VCS checkout; unknown
+// values are "unknown".
+func Get() Info {
+	info := Info{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	var revision, revisionTime string
+	build, ok := debug.ReadBuildInfo()
+	if ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.time":
+				revisionTime = setting.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		switch {
+		case ok && build.Main.Version != "" && build.Main.Version != "(devel)":
+			info.Version = build.Main.Version
+		case len(revision) >= 12:
+			info.Version = "dev-" + revision[:12]
+		default:
+			info.Version = "dev"
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = revision
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = revisionTime
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// String describes the build in one line
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s, %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion, i.Platform)
+}
@@ -3,12 +3,37 @@ package config
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// EnvPrefix prefixes the environment variables overriding config settings,
+// e.g. MATRIXSVC_MATRIX_ACCESSTOKEN for matrix.accesstoken
+const EnvPrefix = "MATRIXSVC"
+
+// configFile is the file set with SetConfigFile, empty to search for
+// config.{yaml,yml,json,toml} in the default locations
+var configFile string
+
+// profile selects the overlay merged over the config file, see SetProfile
+var profile string
+
+// overlayFile is the overlay merged by the last LoadConfig, if any
+var overlayFile string
+
+// ErrNoConfigFile is returned by SaveConfig when the config came from the
+// environment only
+var ErrNoConfigFile = errors.New("no config file was loaded")
+
 func GeneratePickleKey() string {
 	// Generate a 32-byte random key for encryption
 	bytes := make([]byte, 32)
@@ -19,108 +44,2011 @@ func GeneratePickleKey() string {
 	return base64.StdEncoding.EncodeToString(bytes)
 }
 
+// SetConfigFile makes LoadConfig read the given file instead of searching the
+// default locations. Its extension selects the format: .yaml, .yml, .json or
+// .toml.
+func SetConfigFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json", ".toml":
+	default:
+		return fmt.Errorf("unsupported config file %q, expected a .yaml, .yml, .json or .toml file", path)
+	}
+	configFile = path
+	return nil
+}
+
+// SetProfile selects an overlay, e.g. "prod" merges config.prod.yaml over
+// config.yaml. Without a profile the APP_ENV environment variable selects
+// it, in which case a missing overlay is not an error.
+func SetProfile(name string) error {
+	if !validProfile(name) {
+		return fmt.Errorf("invalid profile %q, use letters, digits, - and _", name)
+	}
+	profile = name
+	return nil
+}
+
+func validProfile(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// overlayPath returns the overlay of a config file for a profile, in the same
+// directory and format: /etc/bot.toml becomes /etc/bot.prod.toml
+func overlayPath(base, profile string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + profile + ext
+}
+
+// mergeOverlay deep-merges the overlay of the active profile over the config
+// file that was read. Maps are merged key by key; lists and other values in
+// the overlay replace those of the base file.
+func mergeOverlay() error {
+	overlayFile = ""
+	name, explicit := profile, true
+	if name == "" {
+		name, explicit = os.Getenv("APP_ENV"), false
+		if name == "" {
+			return nil
+		}
+		if !validProfile(name) {
+			return fmt.Errorf("invalid profile %q in APP_ENV", name)
+		}
+	}
+
+	base := viper.ConfigFileUsed()
+	if base == "" {
+		if explicit {
+			return fmt.Errorf("profile %q needs a config file to overlay", name)
+		}
+		return nil
+	}
+
+	path := overlayPath(base, name)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read overlay for profile %q: %w", name, err)
+	}
+	defer file.Close()
+
+	if err := viper.MergeConfig(file); err != nil {
+		return fmt.Errorf("failed to merge overlay %s: %w", path, err)
+	}
+	overlayFile = path
+	return nil
+}
+
+// SaveConfig writes the Matrix credentials that changed since loading (a
+// generated pickle key, or the access token and device ID of a new login)
+// back to the most specific config file: the overlay of the active profile,
+// otherwise the config file. Settings that are secret references keep the
+// reference.
 func SaveConfig(config *Config) error {
-	// Write the current config back to the file
-	viper.Set("matrix.picklekey", config.Matrix.PickleKey)
-	viper.Set("matrix.accesstoken", config.Matrix.AccessToken)
-	viper.Set("matrix.deviceid", config.Matrix.DeviceID)
+	// Never create a file holding secrets that were passed via the environment
+	target := overlayFile
+	if target == "" {
+		target = viper.ConfigFileUsed()
+	}
+	if target == "" {
+		return ErrNoConfigFile
+	}
 
-	// Try to find the config file path
-	configFile := "config.yaml"
-	if _, err := os.Stat("./config.yaml"); err == nil {
-		configFile = "./config.yaml"
-	} else if _, err := os.Stat("../config.yaml"); err == nil {
-		configFile = "../config.yaml"
+	// Only the file's own settings are written back, not the defaults, the
+	// environment or other layers
+	file := viper.New()
+	file.SetConfigFile(target)
+	if err := file.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	changed := false
+	for key, value := range map[string]string{
+		"matrix.picklekey":   config.Matrix.PickleKey,
+		"matrix.accesstoken": config.Matrix.AccessToken,
+		"matrix.deviceid":    config.Matrix.DeviceID,
+	} {
+		if loaded := viper.GetString(key); loaded != value && !IsSecretReference(loaded) {
+			file.Set(key, value)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
 	}
 
-	return viper.WriteConfigAs(configFile)
+	// Written in the format of the file that was read
+	return file.WriteConfig()
 }
 
 type Config struct {
-	Server  ServerConfig  `mapstructure:"server"`
-	Matrix  MatrixConfig  `mapstructure:"matrix"`
-	Webhook WebhookConfig `mapstructure:"webhook"`
-	Logging LoggingConfig `mapstructure:"logging"`
+	Server    ServerConfig    `mapstructure:"server"`  // HTTP API
+	Matrix    MatrixConfig    `mapstructure:"matrix"`  // Bot account and room
+	Webhook   WebhookConfig   `mapstructure:"webhook"` // Outgoing webhooks and command execution
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Hooks     HooksConfig     `mapstructure:"hooks"`     // Inbound webhook receivers
+	Stream    StreamConfig    `mapstructure:"stream"`    // Room activity streamed over /ws and /events
+	Notify    NotifyConfig    `mapstructure:"notify"`    // Templates served at /notify/{template}
+	Secrets   SecretsConfig   `mapstructure:"secrets"`   // Backends of vault: and sops: secret references
+	Rooms     []RoomConfig    `mapstructure:"rooms"`     // Further rooms and per-room overrides
+	Tenants   []TenantConfig  `mapstructure:"tenants"`   // Groups of rooms with isolated webhooks and sessions
+	Audit     AuditConfig     `mapstructure:"audit"`     // Tamper-evident record of handled commands
+	Archive   ArchiveConfig   `mapstructure:"archive"`   // Messages and replies kept in object storage
+	Plugins   PluginsConfig   `mapstructure:"plugins"`   // External handler and transformer binaries
+	WASM      WASMConfig      `mapstructure:"wasm"`      // Sandboxed WebAssembly message transforms
+	LLM       LLMConfig       `mapstructure:"llm"`       // OpenAI-compatible chat completions backend
+	Ollama    OllamaConfig    `mapstructure:"ollama"`    // Self-hosted models with streamed replies
+	Feeds     FeedsConfig     `mapstructure:"feeds"`     // RSS and Atom feeds posted to rooms
+	Schedule  ScheduleConfig  `mapstructure:"schedule"`  // Recurring announcements and reports
+	Reminders RemindersConfig `mapstructure:"reminders"` // Reminders set with /remind
+	Email     EmailConfig     `mapstructure:"email"`     // Email sent over SMTP and read over IMAP
+	Telegram  TelegramConfig  `mapstructure:"telegram"`  // Chat relayed with a Telegram group
+	Push      PushConfig      `mapstructure:"push"`      // Messages mirrored to ntfy or Pushover
+	PagerDuty PagerDutyConfig `mapstructure:"pagerduty"` // Incidents paged with /page
+	Jira      JiraConfig      `mapstructure:"jira"`      // Issues handled with /jira
+	// Home Assistant services called with /ha and state changes posted
+	HomeAssistant HomeAssistantConfig `mapstructure:"homeassistant"`
+	// Translations of /translate and of rooms with auto_translate
+	Translate TranslateConfig `mapstructure:"translate"`
+	// Images described by a vision or OCR endpoint and handled as messages
+	Vision VisionConfig `mapstructure:"vision"`
+	// Reactions to the bot's replies forwarded to a webhook
+	Feedback FeedbackConfig `mapstructure:"feedback"`
+	// Long replies split into pages, the next posted on a reaction
+	Pagination PaginationConfig `mapstructure:"pagination"`
+	// Reachability of the webhooks reported to a room at startup and reload
+	WebhookProbe WebhookProbeConfig `mapstructure:"webhook_probe"`
+	// Values remembered with /set, available to templates as {{.MEMORY.key}}
+	Memory MemoryConfig `mapstructure:"memory"`
+	// Commands only some senders may run
+	Permissions PermissionsConfig `mapstructure:"permissions"`
+	// Commands held until a user confirms them by a reaction or reply
+	Confirmations ConfirmationsConfig `mapstructure:"confirmations"`
+	// Times notifications are held and posted when they end, overridden by
+	// rooms and tenants
+	QuietHours QuietHoursConfig `mapstructure:"quiet_hours"`
+	// Bridge bots whose relayed messages are handled as sent by the people
+	// they relay
+	Bridges []BridgeConfig `mapstructure:"bridges"`
+	// Sizes and MIME types of the attachments the bot reads and uploads
+	Attachments AttachmentsConfig `mapstructure:"attachments"`
+	// Notices posted when the service starts and stops
+	LifecycleNotices LifecycleNoticesConfig `mapstructure:"lifecycle_notices"`
+	// Language of the bot's own replies, errors and notices
+	I18n I18nConfig `mapstructure:"i18n"`
+	// Replies telling senders that a webhook or model request failed
+	ErrorReplies ErrorRepliesConfig `mapstructure:"error_replies"`
+	// Replies with backend output to rooms without encryption
+	EncryptionPolicy EncryptionPolicyConfig `mapstructure:"encryption_policy"`
+	// Rules rewriting or dropping messages, in order, before commands are
+	// extracted and messages dispatched
+	InboundTransforms []TransformRule `mapstructure:"inbound_transforms"`
+	// Matrix events and hook deliveries remembered, so that duplicates are
+	// skipped
+	Dedup DedupConfig `mapstructure:"dedup"`
+	// Database keeping the state of feeds, the schedule, reminders, the
+	// mailbox and registered commands
+	Storage StorageConfig `mapstructure:"storage"`
 }
 
 type ServerConfig struct {
+	// Port the HTTP API listens on
 	Port int `mapstructure:"port"`
+	// Seconds to wait for in-flight work to finish on shutdown
+	ShutdownTimeout int `mapstructure:"shutdown_timeout"`
+	// Maximum size of files accepted by POST /media, in megabytes
+	MediaMaxSizeMB int `mapstructure:"media_max_size_mb"`
+	// /ready fails if the last Matrix sync is older than this many seconds
+	ReadyMaxSyncAge int `mapstructure:"ready_max_sync_age"`
+	// Serve /debug/pprof and /debug/runtime (do not expose publicly)
+	EnableDebug bool `mapstructure:"enable_debug"`
+	// Bearer token for the /admin endpoints (empty = admin API disabled)
+	AdminToken string `mapstructure:"admin_token"`
+	// Matrix users allowed to run /addcommand, /removecommand and to change
+	// feeds with /rss
+	AdminUsers []string `mapstructure:"admin_users"`
+	// Requests per second each client IP may make to /message, /media and
+	// the hooks (0 = unlimited), with bursts of up to rate_limit_burst
+	RateLimit      float64 `mapstructure:"rate_limit"`
+	RateLimitBurst int     `mapstructure:"rate_limit_burst"`
+	// Take the client IP from X-Forwarded-For (only behind a proxy)
+	TrustProxyHeaders bool `mapstructure:"trust_proxy_headers"`
+	// CIDRs or addresses of the proxies whose X-Forwarded-For is believed.
+	// The client is the rightmost entry that is not one of them.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Maximum body size of /message and hook requests, in kilobytes
+	MaxBodySizeKB int `mapstructure:"max_body_size_kb"`
+	// Cross-origin access for browser-based tools
+	CORS CORSConfig `mapstructure:"cors"`
+	// CIDRs allowed to call each route group (api, hooks, stream, admin,
+	// debug, metrics, appservice), checked in addition to tokens. Groups not listed are
+	// open.
+	IPAllowlists map[string][]string `mapstructure:"ip_allowlists"`
+}
+
+// CORSConfig lists what cross-origin requests browsers may make. CORS is
+// disabled while AllowedOrigins is empty; "*" allows any origin, method or
+// header.
+type CORSConfig struct {
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`   // e.g. https://tools.example.com
+	AllowedMethods   []string `mapstructure:"allowed_methods"`   // Methods preflight requests may ask for
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`   // Request headers scripts may send
+	ExposedHeaders   []string `mapstructure:"exposed_headers"`   // Response headers scripts may read
+	AllowCredentials bool     `mapstructure:"allow_credentials"` // Let browsers send cookies and HTTP authentication
+	// Seconds browsers may cache preflight responses
+	MaxAge int `mapstructure:"max_age"`
 }
 
 type MatrixConfig struct {
-	Homeserver       string `mapstructure:"homeserver"`
-	UserID           string `mapstructure:"userid"`
-	AccessToken      string `mapstructure:"accesstoken"`
-	DeviceID         string `mapstructure:"deviceid"`
-	RecoveryKey      string `mapstructure:"recoverykey"`
-	PickleKey        string `mapstructure:"picklekey"`
-	RoomID           string `mapstructure:"roomid"`
-	EnableEncryption bool   `mapstructure:"enable_encryption"`
-	SyncTimeout      int    `mapstructure:"sync_timeout"`
-	SkipInitialSync  bool   `mapstructure:"skip_initial_sync"`
+	Homeserver       string `mapstructure:"homeserver"`        // e.g. https://matrix.example.com
+	UserID           string `mapstructure:"userid"`            // Bot user, e.g. @bot:example.com
+	AccessToken      string `mapstructure:"accesstoken"`       // Access token of the bot user
+	DeviceID         string `mapstructure:"deviceid"`          // Updated after the first login
+	RecoveryKey      string `mapstructure:"recoverykey"`       // Verifies the device for end-to-end encryption
+	PickleKey        string `mapstructure:"picklekey"`         // Encrypts the crypto store, generated if empty
+	RoomID           string `mapstructure:"roomid"`            // Room to listen and post in, e.g. !roomid:example.com
+	EnableEncryption bool   `mapstructure:"enable_encryption"` // Support encrypted rooms
+	SyncTimeout      int    `mapstructure:"sync_timeout"`      // Seconds to wait for the initial sync
+	SkipInitialSync  bool   `mapstructure:"skip_initial_sync"` // Start without waiting for the initial sync
+	// Notify a room when encrypted events cannot be decrypted
+	DecryptionAlert DecryptionAlertConfig `mapstructure:"decryption_alert"`
+	// Reply to senders whose device withheld the key of their message from
+	// the bot (blacklisted, unverified or unauthorised), once per session
+	WithheldNotice bool `mapstructure:"withheld_notice"`
+	// Events handed from the sync loop to the message and event handlers
+	Queue InboundQueueConfig `mapstructure:"queue"`
+	// Restarts the sync loop when sync responses stop arriving
+	Watchdog SyncWatchdogConfig `mapstructure:"watchdog"`
+	// When the Megolm sessions the bot encrypts with are replaced, and which
+	// devices get their keys
+	KeyRotation KeyRotationConfig `mapstructure:"key_rotation"`
+	// Logs in through the homeserver's OpenID Connect provider instead of
+	// with accesstoken
+	OIDC OIDCConfig `mapstructure:"oidc"`
+	// Skips events handled before a restart, anchored on the fully-read
+	// markers of the rooms
+	ReadMarker ReadMarkerConfig `mapstructure:"read_marker"`
+	// Runs the bot as an application service of the homeserver instead of
+	// syncing
+	AppService AppServiceConfig `mapstructure:"appservice"`
+}
+
+type DecryptionAlertConfig struct {
+	// Room the alert is posted to (empty = no alerts)
+	RoomID string `mapstructure:"room_id"`
+	// Alert when threshold events failed to decrypt within window seconds,
+	// at most once per window
+	Threshold int `mapstructure:"threshold"`
+	Window    int `mapstructure:"window"`
+}
+
+// InboundQueueConfig sizes the queue between the sync loop and the
+// handlers. Events of the same room and thread are handled in order.
+type InboundQueueConfig struct {
+	// Events handled at once (0 = in the sync loop, which waits for each)
+	Workers int `mapstructure:"workers"`
+	// Events waiting for each worker
+	Size int `mapstructure:"size"`
+	// When a worker's queue is full: block (the sync loop waits),
+	// drop_newest or drop_oldest
+	Overflow string `mapstructure:"overflow"`
+}
+
+// KeyRotationConfig tightens the rotation of the outbound Megolm sessions.
+// Where a room's encryption settings are stricter, they apply.
+type KeyRotationConfig struct {
+	// Messages encrypted with one session, at most 10000 (0 = the room's
+	// setting, 100 if it has none)
+	MaxMessages int `mapstructure:"max_messages"`
+	// Seconds one session is used, at least 3600 (0 = the room's setting, a
+	// week if it has none)
+	MaxAge int `mapstructure:"max_age"`
+	// Seconds between starting a new session in every encrypted room and
+	// sharing its keys with the members' devices (0 = never)
+	ReshareInterval int `mapstructure:"reshare_interval"`
+	// Share keys only with devices verified by cross-signing
+	VerifiedDevicesOnly bool `mapstructure:"verified_devices_only"`
+}
+
+// OIDCConfig logs the bot in on homeservers using next-generation auth
+// (MSC3861), with the device authorization grant. The tokens are refreshed
+// as they expire, and the login is repeated if they are revoked.
+type OIDCConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Issuer of the provider, discovered from the homeserver if empty
+	Issuer string `mapstructure:"issuer"`
+	// Client ID registered with the provider, registered dynamically
+	// (MSC2966) if empty
+	ClientID string `mapstructure:"client_id"`
+	// Name and homepage shown when the login is approved
+	ClientName string `mapstructure:"client_name"`
+	ClientURI  string `mapstructure:"client_uri"`
+	// File keeping the tokens and the client ID across restarts
+	TokenFile string `mapstructure:"token_file"`
+}
+
+// ReadMarkerConfig moves the account's fully-read marker of each room to the
+// events handled, and at startup skips the events up to the marker
+type ReadMarkerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Seconds before the marker from which events are still handled, for
+	// events that arrive late from other servers. Rooms without a marker
+	// are anchored at the start.
+	GraceWindow int `mapstructure:"grace_window"`
+	// Seconds between updates of the markers
+	Interval int `mapstructure:"interval"`
+}
+
+// AppServiceConfig runs the bot as an application service: the homeserver
+// pushes events to /_matrix/app/v1/transactions instead of the bot syncing,
+// and replies of a command or hook can be sent as their own virtual user
+type AppServiceConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	ASToken string `mapstructure:"as_token"` // Token the bot authenticates with (as_token of the registration)
+	HSToken string `mapstructure:"hs_token"` // Token the homeserver authenticates with (hs_token of the registration)
+	// Localpart of the virtual user each command or hook replies as, e.g.
+	// grafana: alerts-grafana. Others reply as the bot.
+	Users map[string]string `mapstructure:"users"`
+}
+
+// SyncWatchdogConfig restarts a sync loop that stopped receiving responses
+type SyncWatchdogConfig struct {
+	// Seconds without a sync response before the sync loop is restarted
+	// (0 = no watchdog)
+	StaleAfter int `mapstructure:"stale_after"`
+	// Seconds between checks
+	Interval int `mapstructure:"interval"`
+	// Restarts after which the connections to the homeserver are reset too
+	MaxRestarts int `mapstructure:"max_restarts"`
+	// Room notified when the sync stalls and recovers (empty = no alerts)
+	AlertRoomID string `mapstructure:"alert_room_id"`
 }
 
 type WebhookConfig struct {
-	Default          string            `mapstructure:"default"`
-	Commands         map[string]string `mapstructure:"commands"`
-	Template         string            `mapstructure:"template"`
-	CommandTemplates map[string]string `mapstructure:"command_templates"`
-	AuthTokens       map[string]string `mapstructure:"auth_tokens"`
-	DefaultAuth      string            `mapstructure:"default_auth"`
-	JQSelector       string            `mapstructure:"jq_selector"`
-	CommandSelectors map[string]string `mapstructure:"command_selectors"`
-	SkipEmpty        bool              `mapstructure:"skip_empty"`
-	Timeout          int               `mapstructure:"timeout"`
+	Default          string            `mapstructure:"default"`           // Webhook for messages without a known command
+	Commands         map[string]string `mapstructure:"commands"`          // Webhooks keyed by command name
+	Template         string            `mapstructure:"template"`          // Payload template, the message is {{.MESSAGE}}
+	CommandTemplates map[string]string `mapstructure:"command_templates"` // Payload templates or command lines keyed by command
+	AuthTokens       map[string]string `mapstructure:"auth_tokens"`       // Authorization headers keyed by command
+	DefaultAuth      string            `mapstructure:"default_auth"`      // Key of auth_tokens used for other commands
+	JQSelector       string            `mapstructure:"jq_selector"`       // Extracts the reply from webhook responses
+	CommandSelectors map[string]string `mapstructure:"command_selectors"` // jq selectors keyed by command
+	SkipEmpty        bool              `mapstructure:"skip_empty"`        // Send no reply when the selector finds nothing
+	Timeout          int               `mapstructure:"timeout"`           // Webhook timeout in seconds
+	UserAgent        string            `mapstructure:"user_agent"`        // User-Agent header of dispatches
+	PropagateTrace   bool              `mapstructure:"propagate_trace"`   // Send W3C traceparent and baggage headers
+	PingURL          string            `mapstructure:"ping_url"`          // No-op endpoint /ping dispatches to (empty = not measured)
+	RetryAttempts    int               `mapstructure:"retry_attempts"`    // Dispatches sent again after a transient failure
+	RetryDelay       int               `mapstructure:"retry_delay"`       // Seconds before a failed dispatch is sent again
+	// Pages of paginated responses fetched and merged before the jq
+	// selector applies, per command
+	ResponsePages        map[string]ResponsePagesConfig `mapstructure:"response_pages"`
+	DefaultResponsePages ResponsePagesConfig            `mapstructure:"default_response_pages"` // For commands not in response_pages
 	// Command execution settings
 	EnableCommands bool   `mapstructure:"enable_commands"`
-	CommandPrefix  string `mapstructure:"command_prefix"`
-	SessionTimeout int    `mapstructure:"session_timeout"`
+	CommandPrefix  string `mapstructure:"command_prefix"`  // Messages starting with it run commands
+	SessionTimeout int    `mapstructure:"session_timeout"` // Seconds before an idle session is closed
+	// Hard cap on live sessions, least recently used are evicted (0 = unlimited)
+	MaxSessions int `mapstructure:"max_sessions"`
+	// Only the session owner and users invited via /share may run commands in a session
+	EnforceSessionOwnership bool `mapstructure:"enforce_session_ownership"`
+	// Max commands waiting behind a running command in the same session
+	CommandQueueDepth int `mapstructure:"command_queue_depth"`
 	// Default command to execute (e.g., "pi -p")
 	DefaultCommand string `mapstructure:"default_command"`
+	// Render commands and post them back instead of executing them
+	DryRun        bool            `mapstructure:"dry_run"`
+	CommandDryRun map[string]bool `mapstructure:"command_dry_run"`
+	// How commands are run: "shell" (sh -c, default) or "argv" (no shell)
+	ExecMode string `mapstructure:"exec_mode"`
+	// Executables (names, paths or directories ending in /) commands may invoke
+	AllowedExecutables []string `mapstructure:"allowed_executables"`
+	// Post-processing applied to command output before posting, per command
+	OutputProcessors        map[string][]OutputProcessorStep `mapstructure:"output_processors"`
+	DefaultOutputProcessors []OutputProcessorStep            `mapstructure:"default_output_processors"`
+	// How replies are rendered, per command: auto (tables for CSV, TSV and
+	// JSON arrays, code blocks for JSON), table, code, code:<language> or
+	// none (unchanged)
+	Render        map[string]string `mapstructure:"render"`
+	DefaultRender string            `mapstructure:"default_render"` // For commands not in render
+	// Registration of commands at runtime, kept in storage (empty =
+	// registration disabled). The JSON file earlier versions kept them in,
+	// imported on first start.
+	CommandStore string `mapstructure:"command_store"`
+	// Lua scripts for logic too dynamic for templates. Each returns a string
+	// or nil to keep the default. route_script picks the command a message
+	// is dispatched to (globals message, command, sender, room_id);
+	// payload_script rewrites the rendered webhook payload (payload,
+	// message, command); reply_script rewrites replies, "" drops them
+	// (reply, sender, room_id).
+	RouteScript   string `mapstructure:"route_script"`
+	PayloadScript string `mapstructure:"payload_script"`
+	ReplyScript   string `mapstructure:"reply_script"`
+	// Milliseconds a script may run
+	ScriptTimeout int `mapstructure:"script_timeout"`
+	// Keys identifying dispatches, so that none is delivered twice
+	Idempotency IdempotencyConfig `mapstructure:"idempotency"`
+	// How payload templates are rendered
+	TemplateOptions PayloadTemplateConfig `mapstructure:"template_options"`
+	// Destinations dispatches may connect to
+	Egress EgressConfig `mapstructure:"egress"`
+	// Transcripts of command sessions, posted by /export
+	Transcript TranscriptConfig `mapstructure:"transcript"`
+	// Recording and mock responses for developing without live backends
+	Dev WebhookDevConfig `mapstructure:"dev"`
+}
+
+// WebhookDevConfig helps developing command routing, templates and
+// selectors without live backends. Recorded dispatches can be replayed
+// against a changed config with the replay command.
+type WebhookDevConfig struct {
+	// Directory every dispatch is written to as a JSON file, with its
+	// message, requests, responses and reply (empty = no recording)
+	RecordDir string `mapstructure:"record_dir"`
+	// Answer dispatches with the mock responses instead of sending them
+	Mock bool `mapstructure:"mock"`
+	// Mock responses keyed by command
+	MockResponses map[string]MockResponseConfig `mapstructure:"mock_responses"`
+	// Mock response of the default webhook and commands without one
+	DefaultMockResponse MockResponseConfig `mapstructure:"default_mock_response"`
+}
+
+// Enabled reports whether dispatches are recorded or mocked
+func (c *WebhookDevConfig) Enabled() bool {
+	return c.Mock || c.RecordDir != ""
+}
+
+// MockResponseConfig is the response of a mocked dispatch
+type MockResponseConfig struct {
+	// HTTP status (default 200)
+	Status int `mapstructure:"status"`
+	// Response body, a template of the request's .COMMAND, .MESSAGE, .URL,
+	// .METHOD, .BODY (the parsed JSON payload) and .PAGE (1 for the
+	// dispatch, 2 for the first further page, etc.)
+	Body string `mapstructure:"body"`
+}
+
+// TranscriptConfig sets how the transcripts of command sessions are kept and
+// posted to the room as a file
+type TranscriptConfig struct {
+	// File format: markdown or jsonl
+	Format string `mapstructure:"format"`
+	// Commands kept per session, older ones are dropped (0 = no transcript)
+	MaxEntries int `mapstructure:"max_entries"`
+	// Post the transcript of a session when it expires or is evicted
+	ExportOnExpiry bool `mapstructure:"export_on_expiry"`
+}
+
+// PayloadTemplateConfig sets how webhook payload templates are rendered.
+// Every payload template is rendered with a sample message at startup and
+// on reload.
+type PayloadTemplateConfig struct {
+	// Variables a template uses but a dispatch lacks: default (rendered as
+	// <no value>), zero (empty) or error (the dispatch fails)
+	MissingKey string `mapstructure:"missing_key"`
+	// Fail dispatches whose template uses a missing or empty variable, and
+	// reject templates whose sample payload is not valid JSON
+	Strict bool `mapstructure:"strict"`
+	// Escaping of the variables: none, or json for use inside JSON strings
+	Escape string `mapstructure:"escape"`
+	// Escaping keyed by command, overriding escape
+	CommandEscapes map[string]string `mapstructure:"command_escapes"`
+}
+
+// IdempotencyConfig sends each dispatch with a key derived from the Matrix
+// event (or scheduled run) causing it, in the Idempotency-Key header and the
+// idempotency_key field of JSON payloads, and skips dispatches whose key was
+// already delivered
+type IdempotencyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Seconds a delivered key is remembered
+	TTL int `mapstructure:"ttl"`
+}
+
+// EgressConfig restricts the destinations of dispatches, so that a webhook
+// URL, e.g. of a command registered at runtime, cannot reach internal
+// services. Every address connected to is checked, including those of
+// redirects.
+type EgressConfig struct {
+	// Networks (CIDRs or addresses) and hostnames (*.example.com for
+	// subdomains) dispatches may go to. Both empty = any destination not
+	// blocked below.
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	// Allows link-local addresses (169.254.0.0/16, fe80::/10) and the
+	// metadata services of cloud providers, which are blocked otherwise
+	AllowLinkLocal bool `mapstructure:"allow_link_local"`
+}
+
+// RoomConfig overrides settings for the messages of one room. The bot also
+// listens in rooms other than matrix.roomid that are listed here; it must
+// already be a member. Unset settings fall back to the global ones.
+type RoomConfig struct {
+	// Room the settings apply to, e.g. !ops:example.com
+	RoomID string `mapstructure:"room_id"`
+	// Webhook settings for messages in the room
+	Webhook RoomWebhookConfig `mapstructure:"webhook"`
+	// Commands available in the room, keys of webhook.commands or
+	// webhook.command_templates (empty = all)
+	Commands []string `mapstructure:"commands"`
+	// Users whose messages are handled (empty = everyone in the room)
+	AllowedUsers []string `mapstructure:"allowed_users"`
+	// Session settings, overriding the webhook settings of the same name
+	EnableCommands          *bool  `mapstructure:"enable_commands"`
+	EnforceSessionOwnership *bool  `mapstructure:"enforce_session_ownership"`
+	DefaultCommand          string `mapstructure:"default_command"`
+	// Ignore unencrypted messages (needs matrix.enable_encryption)
+	RequireEncryption bool `mapstructure:"require_encryption"`
+	// Language messages of the room are translated to, e.g. en (needs
+	// translate.enabled)
+	AutoTranslate string `mapstructure:"auto_translate"`
+	// Language of the bot's own replies in the room, overriding
+	// i18n.language
+	Language string `mapstructure:"language"`
+	// Replies with backend output if the room is not encrypted: off, warn
+	// or refuse, overriding encryption_policy.mode
+	EncryptionPolicy string `mapstructure:"encryption_policy"`
+	// Quiet hours of the room, replacing those of its tenant and quiet_hours
+	QuietHours *QuietHoursConfig `mapstructure:"quiet_hours"`
+	// Post the startup and shutdown notices of lifecycle_notices in the
+	// room (default: only in matrix.roomid)
+	LifecycleNotices *bool `mapstructure:"lifecycle_notices"`
+	// Templates put before and after every reply with backend output in
+	// the room, e.g. an environment tag or a signature, with .Sender,
+	// .RoomID and .Version
+	ReplyPrefix string `mapstructure:"reply_prefix"`
+	ReplySuffix string `mapstructure:"reply_suffix"`
+}
+
+// RoomWebhookConfig overrides the webhook defaults for one room
+type RoomWebhookConfig struct {
+	Default     string `mapstructure:"default"`      // Webhook for messages without a known command
+	Template    string `mapstructure:"template"`     // Payload template of the default webhook
+	JQSelector  string `mapstructure:"jq_selector"`  // Extracts the reply from webhook responses
+	DefaultAuth string `mapstructure:"default_auth"` // Key of webhook.auth_tokens used for the default webhook
+}
+
+// AllowsUser reports whether messages of the user are handled in the room.
+// A nil room allows everyone.
+func (r *RoomConfig) AllowsUser(userID string) bool {
+	if r == nil || len(r.AllowedUsers) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowedUsers {
+		if allowed == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCommand reports whether the named command is available in the room.
+// A nil room allows every command.
+func (r *RoomConfig) AllowsCommand(command string) bool {
+	if r == nil || len(r.Commands) == 0 {
+		return true
+	}
+	for _, allowed := range r.Commands {
+		if allowed == command {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantConfig isolates a group of rooms, e.g. those of one team: their
+// messages go to the tenant's webhooks with the tenant's auth tokens, their
+// sessions are kept apart from those of other rooms and they share a rate
+// limit. Each room belongs to one tenant at most; rooms of no tenant use the
+// global settings.
+type TenantConfig struct {
+	// Name of the tenant in logs, metrics and the admin API
+	Name string `mapstructure:"name"`
+	// Rooms of the tenant, matrix.roomid or rooms listed in rooms
+	Rooms []string `mapstructure:"rooms"`
+	// Webhooks of the tenant, replacing the global ones
+	Webhook TenantWebhookConfig `mapstructure:"webhook"`
+	// Directory of the session files (empty = a directory named after the
+	// tenant in /tmp/pi-sessions)
+	SessionDir string `mapstructure:"session_dir"`
+	// Messages per second handled across the tenant's rooms (0 = unlimited),
+	// and how many may arrive at once (0 = 1)
+	RateLimit      float64 `mapstructure:"rate_limit"`
+	RateLimitBurst int     `mapstructure:"rate_limit_burst"`
+	// Quiet hours of the tenant's rooms, replacing quiet_hours
+	QuietHours *QuietHoursConfig `mapstructure:"quiet_hours"`
+}
+
+// TenantWebhookConfig holds the webhook settings of a tenant. They replace
+// the settings of the same name in webhook as a whole, so that a tenant's
+// rooms never reach the webhooks, commands or auth tokens of other teams;
+// the remaining webhook settings are shared.
+type TenantWebhookConfig struct {
+	Default          string            `mapstructure:"default"`           // Webhook for messages without a known command
+	Commands         map[string]string `mapstructure:"commands"`          // Webhooks keyed by command name
+	Template         string            `mapstructure:"template"`          // Payload template, the message is {{.MESSAGE}}
+	CommandTemplates map[string]string `mapstructure:"command_templates"` // Payload templates or command lines keyed by command
+	DefaultCommand   string            `mapstructure:"default_command"`   // Command line of messages without a command template (empty = refused)
+	AuthTokens       map[string]string `mapstructure:"auth_tokens"`       // Authorization headers keyed by command
+	DefaultAuth      string            `mapstructure:"default_auth"`      // Key of auth_tokens used for other commands
+	JQSelector       string            `mapstructure:"jq_selector"`       // Extracts the reply from webhook responses
+	CommandSelectors map[string]string `mapstructure:"command_selectors"` // jq selectors keyed by command
+}
+
+// Apply replaces the settings of cfg the tenant has its own of
+func (t *TenantWebhookConfig) Apply(cfg *WebhookConfig) {
+	cfg.Default = t.Default
+	cfg.Commands = t.Commands
+	cfg.Template = t.Template
+	cfg.CommandTemplates = t.CommandTemplates
+	cfg.DefaultCommand = t.DefaultCommand
+	cfg.AuthTokens = t.AuthTokens
+	cfg.DefaultAuth = t.DefaultAuth
+	cfg.JQSelector = t.JQSelector
+	cfg.CommandSelectors = t.CommandSelectors
+}
+
+// TenantOf returns the tenant a room belongs to, nil if it belongs to none
+func (c *Config) TenantOf(roomID string) *TenantConfig {
+	for i := range c.Tenants {
+		for _, room := range c.Tenants[i].Rooms {
+			if room == roomID {
+				return &c.Tenants[i]
+			}
+		}
+	}
+	return nil
+}
+
+// QuietHoursFor returns the quiet hours of a room: its own, else those of
+// its tenant, else quiet_hours
+func (c *Config) QuietHoursFor(roomID string) *QuietHoursConfig {
+	for i := range c.Rooms {
+		if c.Rooms[i].RoomID == roomID && c.Rooms[i].QuietHours != nil {
+			return c.Rooms[i].QuietHours
+		}
+	}
+	if tenant := c.TenantOf(roomID); tenant != nil && tenant.QuietHours != nil {
+		return tenant.QuietHours
+	}
+	return &c.QuietHours
+}
+
+// WebhookFor returns the webhook settings of the messages of a room, those
+// of its tenant if it has one
+func (c *Config) WebhookFor(roomID string) *WebhookConfig {
+	tenant := c.TenantOf(roomID)
+	if tenant == nil {
+		return &c.Webhook
+	}
+	return c.TenantWebhook(tenant)
+}
+
+// TenantWebhook returns the webhook settings of the messages of a tenant's
+// rooms
+func (c *Config) TenantWebhook(tenant *TenantConfig) *WebhookConfig {
+	cfg := c.Webhook
+	tenant.Webhook.Apply(&cfg)
+	return &cfg
+}
+
+// OutputProcessorStep is one step of a command output pipeline. Exactly one
+// of JQ, Regex or Tail should be set.
+type OutputProcessorStep struct {
+	JQ      string `mapstructure:"jq"`      // jq program applied to JSON output
+	Regex   string `mapstructure:"regex"`   // Regular expression to replace
+	Replace string `mapstructure:"replace"` // Replacement for Regex (supports $1 etc.)
+	Tail    int    `mapstructure:"tail"`    // Keep only the last N lines
+}
+
+// ResponsePagesConfig follows the pages of a paginated webhook response,
+// either by a next-page URL or by a cursor. Items must be set, and exactly
+// one of Next and Cursor.
+type ResponsePagesConfig struct {
+	Items       string `mapstructure:"items"`        // jq path of the items of a page, e.g. .results
+	Next        string `mapstructure:"next"`         // jq selecting the URL of the next page, fetched with GET
+	Cursor      string `mapstructure:"cursor"`       // jq selecting the cursor the dispatch is posted again with
+	CursorParam string `mapstructure:"cursor_param"` // Query parameter of the cursor (default: cursor)
+	MaxPages    int    `mapstructure:"max_pages"`    // Pages fetched at most, the first included (default: 10)
+}
+
+// Enabled reports whether responses are paginated
+func (r *ResponsePagesConfig) Enabled() bool {
+	return r.Items != ""
+}
+
+// HooksConfig configures inbound webhook receivers that post notifications
+// from other systems to Matrix
+type HooksConfig struct {
+	Alertmanager AlertmanagerConfig  `mapstructure:"alertmanager"`
+	Grafana      GrafanaConfig       `mapstructure:"grafana"`
+	Discord      DiscordConfig       `mapstructure:"discord"`
+	PagerDuty    PagerDutyHookConfig `mapstructure:"pagerduty"`
+	// Config-defined hooks keyed by name, served at /hook/{name}
+	Custom map[string]CustomHookConfig `mapstructure:"custom"`
+}
+
+type AlertmanagerConfig struct {
+	// Serve /hook/alertmanager
+	Enabled bool `mapstructure:"enabled"`
+	// Bearer token Alertmanager must send (empty = no authentication)
+	Token string `mapstructure:"token"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Rooms keyed by Alertmanager receiver name
+	Rooms map[string]string `mapstructure:"rooms"`
+	// Go text/template rendering a notification as markdown (empty = built-in)
+	Template string `mapstructure:"template"`
+	// Emoji and color per severity label value, merged over the built-in ones
+	SeverityEmoji  map[string]string `mapstructure:"severity_emoji"`
+	SeverityColors map[string]string `mapstructure:"severity_colors"`
+}
+
+type GrafanaConfig struct {
+	// Serve /hook/grafana
+	Enabled bool `mapstructure:"enabled"`
+	// Bearer token Grafana must send (empty = no authentication)
+	Token string `mapstructure:"token"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Download panel images referenced by the alert and post them as images
+	UploadImages bool `mapstructure:"upload_images"`
+}
+
+type DiscordConfig struct {
+	// Serve /hook/discord and /hook/discord/{id}/{token}
+	Enabled bool `mapstructure:"enabled"`
+	// Token callers must send as the {token} of the URL or as a bearer token
+	// (empty = no authentication)
+	Token string `mapstructure:"token"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Rooms keyed by the {id} of the URL
+	Rooms map[string]string `mapstructure:"rooms"`
+}
+
+type PagerDutyHookConfig struct {
+	// Serve /hook/pagerduty
+	Enabled bool `mapstructure:"enabled"`
+	// Signing secret of the webhook subscription (empty = signatures are
+	// not checked)
+	Secret string `mapstructure:"secret"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+}
+
+// CustomHookConfig defines a generic inbound hook. The incoming JSON is
+// transformed by JQ, then Template; at least one of them must be set.
+type CustomHookConfig struct {
+	// Bearer token callers must send (empty = no authentication)
+	Secret string `mapstructure:"secret"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// jq program applied to the incoming JSON
+	JQ string `mapstructure:"jq"`
+	// Go text/template rendering the (jq-transformed) JSON into the message
+	Template string `mapstructure:"template"`
+	// Message format (markdown, html or plain) and msgtype (text or notice)
+	Format  string `mapstructure:"format"`
+	MsgType string `mapstructure:"msgtype"`
+}
+
+// NotifyConfig defines the templates served at /notify/{template}
+type NotifyConfig struct {
+	// Bearer token callers must send (empty = no authentication)
+	Token string `mapstructure:"token"`
+	// Templates keyed by name
+	Templates map[string]NotifyTemplateConfig `mapstructure:"templates"`
+}
+
+// NotifyTemplateConfig renders the JSON posted to /notify/{template} into a
+// message for its room
+type NotifyTemplateConfig struct {
+	// Go text/template with sprig-style functions, rendering the JSON body
+	Template string `mapstructure:"template"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Message format (markdown, html or plain) and msgtype (text or notice)
+	Format  string `mapstructure:"format"`
+	MsgType string `mapstructure:"msgtype"`
+}
+
+// StreamConfig configures the endpoints that stream room activity to
+// external consumers
+type StreamConfig struct {
+	// Serve /ws and /events
+	Enabled bool `mapstructure:"enabled"`
+	// Bearer token consumers must present, required when enabled
+	Token string `mapstructure:"token"`
+	// Events buffered per consumer before a slow consumer is disconnected
+	BufferSize int `mapstructure:"buffer_size"`
+	// Recent events kept so that reconnecting consumers can resume
+	HistorySize int `mapstructure:"history_size"`
 }
 
 type LoggingConfig struct {
-	Level string `mapstructure:"level"`
-	File  string `mapstructure:"file"`
+	Level string `mapstructure:"level"` // debug, info, warn or error
+	File  string `mapstructure:"file"`  // Also log to this file (empty = stdout only)
+	// Also log to "syslog" or "journald" (empty = neither), with the level as
+	// priority. Under systemd, stdout is not written if it already goes to
+	// the journal.
+	Target string `mapstructure:"target"`
+	// Remote syslog server as network://host:port, e.g. udp://logs:514
+	// (empty = the local syslog daemon)
+	SyslogAddress string `mapstructure:"syslog_address"`
+	// Program name syslog and journal entries are tagged with
+	Tag string `mapstructure:"tag"`
+	// The file is rotated when it reaches max_size megabytes (0 = never).
+	// Rotated files are named after the time of rotation.
+	MaxSize int `mapstructure:"max_size"`
+	// Also rotate it every rotate_hours hours counted from midnight, e.g. 24
+	// for a file per day (0 = by size only). Needs max_size.
+	RotateHours int `mapstructure:"rotate_hours"`
+	// Rotated files older than max_age days or beyond the newest max_backups
+	// are deleted (0 = keep them)
+	MaxAge     int `mapstructure:"max_age"`
+	MaxBackups int `mapstructure:"max_backups"`
+	// Compress rotated files with gzip
+	Compress bool `mapstructure:"compress"`
+	// Replace message bodies, command output and webhook payloads in log
+	// output with their length, to keep end-to-end encrypted content out of
+	// log aggregation. Credentials are always redacted.
+	RedactMessages bool `mapstructure:"redact_messages"`
+	// Debug lines of high-frequency call sites (every sync event, to-device
+	// event dumps) are sampled: each site logs sample_initial lines per
+	// second, then every sample_thereafter-th (sample_initial 0 = log all)
+	SampleInitial    int `mapstructure:"sample_initial"`
+	SampleThereafter int `mapstructure:"sample_thereafter"`
+	// Recent log entries kept in memory for GET /admin/logs (0 = none)
+	BufferSize int `mapstructure:"buffer_size"`
+}
+
+type AuditConfig struct {
+	// Directory the audit log is written to, one file per day (empty =
+	// disabled)
+	Dir string `mapstructure:"dir"`
+	// Files older than this many days are deleted (0 = keep them)
+	RetentionDays int `mapstructure:"retention_days"`
+}
+
+// DedupConfig remembers the keys of handled events, dispatches and hook
+// deliveries in storage, so that duplicates delivered again are skipped.
+// Dispatches are remembered for webhook.idempotency.ttl.
+type DedupConfig struct {
+	// Keys of each kind kept in memory, the oldest forgotten first; older
+	// ones are still found in storage
+	MaxEntries int `mapstructure:"max_entries"`
+	// Matrix events delivered again, e.g. replayed by the sync after a
+	// restart or retried by the homeserver
+	Events DedupScopeConfig `mapstructure:"events"`
+	// Deliveries to /hook/* sent again, by their Idempotency-Key header or
+	// else their body
+	Hooks DedupScopeConfig `mapstructure:"hooks"`
+}
+
+type DedupScopeConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Seconds a key is remembered
+	TTL int `mapstructure:"ttl"`
+}
+
+// TransformRule is one step of inbound_transforms. Exactly one of Regex,
+// StripQuotes, Normalize and Drop should be set.
+type TransformRule struct {
+	Name        string   `mapstructure:"name"`         // In logs and metrics (default: the index)
+	Rooms       []string `mapstructure:"rooms"`        // Rooms the rule applies in (empty = all)
+	Regex       string   `mapstructure:"regex"`        // Regular expression to replace
+	Replace     string   `mapstructure:"replace"`      // Replacement for Regex (supports $1 etc.)
+	StripQuotes string   `mapstructure:"strip_quotes"` // fallback (the quote of a reply) or all (every line starting with >)
+	Normalize   string   `mapstructure:"normalize"`    // Unicode normal form: NFC, NFD, NFKC or NFKD
+	Drop        string   `mapstructure:"drop"`         // Regular expression of messages to ignore, e.g. spam
+}
+
+type EncryptionPolicyConfig struct {
+	// What to do with webhook replies, command output and model answers
+	// for rooms without encryption: off (send them), warn (send them and
+	// warn once per room) or refuse (send a notice instead)
+	Mode string `mapstructure:"mode"`
+	// Enable encryption in such rooms where the bot's power level allows
+	// it (needs matrix.enable_encryption)
+	EnableEncryption bool `mapstructure:"enable_encryption"`
+}
+
+type ErrorRepliesConfig struct {
+	// Reply when a webhook dispatch or a model request fails, instead of
+	// only logging the error
+	Enabled bool `mapstructure:"enabled"`
+	// Go template of the replies, see the README for its fields (empty =
+	// the built-in reply in the language of the room)
+	Template string `mapstructure:"template"`
+	// Templates keyed by subsystem (webhook, llm or ollama), overriding
+	// template
+	Templates map[string]string `mapstructure:"templates"`
+	// Show the error itself, which may name internal URLs and responses
+	IncludeError bool `mapstructure:"include_error"`
+	// Collapse everything but the first line of the built-in reply in a
+	// spoiler
+	Spoiler bool `mapstructure:"spoiler"`
+}
+
+type ArchiveConfig struct {
+	// Write handled messages and the bot's replies to S3-compatible storage
+	Enabled bool `mapstructure:"enabled"`
+	// URL of the storage, e.g. https://s3.eu-central-1.amazonaws.com or
+	// http://minio:9000
+	Endpoint string `mapstructure:"endpoint"`
+	// Region requests are signed for
+	Region string `mapstructure:"region"`
+	Bucket string `mapstructure:"bucket"`
+	// Prepended to the keys of the batches, which end in
+	// YYYY/MM/DD/<time>-<instance>-<sequence>.jsonl
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	// Bucket in the path instead of the host name, as MinIO and most
+	// S3-compatible services expect
+	PathStyle bool `mapstructure:"path_style"`
+	// Messages per batch; a full batch is written at once
+	BatchSize int `mapstructure:"batch_size"`
+	// Seconds after which a batch that is not full is written
+	FlushInterval int `mapstructure:"flush_interval"`
+	// Batches older than this many days are deleted (0 = keep them)
+	RetentionDays int `mapstructure:"retention_days"`
+	// Seconds a request to the storage may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+type PluginsConfig struct {
+	// Directory whose executables are started as plugins at startup (empty
+	// = no plugins)
+	Dir string `mapstructure:"dir"`
+	// Seconds a plugin may take to handle a message or transform a reply
+	Timeout int `mapstructure:"timeout"`
+}
+
+type WASMConfig struct {
+	// Modules run in order on messages and replies
+	Modules []WASMModuleConfig `mapstructure:"modules"`
+	// Milliseconds a module may take per message or reply
+	Timeout int `mapstructure:"timeout"`
+	// Memory a module instance may use
+	MaxMemoryMB int `mapstructure:"max_memory_mb"`
+}
+
+type WASMModuleConfig struct {
+	// Name in logs
+	Name string `mapstructure:"name"`
+	// WebAssembly file exporting alloc and inbound and/or outbound
+	Path string `mapstructure:"path"`
+	// Rooms the module runs in, as patterns like !abc*:example.com (empty =
+	// all rooms)
+	Rooms []string `mapstructure:"rooms"`
+	// Commands the module runs for, as patterns like deploy* (empty = all
+	// messages)
+	Commands []string `mapstructure:"commands"`
+}
+
+// AppliesTo reports whether the module runs for a message or reply in a room
+// for a command ("" for none)
+func (m *WASMModuleConfig) AppliesTo(roomID, command string) bool {
+	if len(m.Rooms) > 0 && !matchesAny(m.Rooms, roomID) {
+		return false
+	}
+	return len(m.Commands) == 0 || matchesAny(m.Commands, command)
+}
+
+// LLMConfig lets an OpenAI-compatible chat completions API answer messages
+// instead of a webhook
+type LLMConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// API base URL, e.g. https://api.openai.com/v1
+	BaseURL string `mapstructure:"base_url"`
+	// Sent as a bearer token (empty = no authentication)
+	APIKey       string  `mapstructure:"api_key"`
+	Model        string  `mapstructure:"model"`
+	SystemPrompt string  `mapstructure:"system_prompt"`
+	Temperature  float64 `mapstructure:"temperature"`
+	// Commands answered by the model, e.g. ask for "/ask ..."
+	Commands []string `mapstructure:"commands"`
+	// Also answer messages that would go to the default webhook
+	Default bool `mapstructure:"default"`
+	// Messages of each conversation (room or thread) sent along with a new
+	// one, and seconds after which an idle conversation is forgotten
+	MaxHistory int `mapstructure:"max_history"`
+	HistoryTTL int `mapstructure:"history_ttl"`
+	// Seconds to wait for a completion
+	Timeout int `mapstructure:"timeout"`
+}
+
+// OllamaConfig lets models of an Ollama server answer messages, streaming
+// the answer into the reply as it is generated
+type OllamaConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Server URL, e.g. http://localhost:11434
+	URL string `mapstructure:"url"`
+	// Model answering each command, e.g. code: codellama for "/code ..."
+	Models map[string]string `mapstructure:"models"`
+	// Model answering messages that would go to the default webhook (empty
+	// = none)
+	DefaultModel string `mapstructure:"default_model"`
+	SystemPrompt string `mapstructure:"system_prompt"`
+	// Messages of the conversation sent along with a new one
+	MaxHistory int `mapstructure:"max_history"`
+	// Milliseconds between edits of the reply while the answer streams in
+	EditInterval int `mapstructure:"edit_interval"`
+	// Seconds an answer may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// FeedsConfig configures the RSS and Atom feeds whose new entries are
+// posted to rooms
+type FeedsConfig struct {
+	// Poll feeds and handle /rss
+	Enabled bool `mapstructure:"enabled"`
+	// File earlier versions kept the subscriptions made with /rss and the
+	// entries already posted in, imported into storage on first start
+	StateFile string `mapstructure:"state_file"`
+	// Seconds between polls of a feed, unless set per feed
+	Interval int `mapstructure:"interval"`
+	// Go text/template with sprig-style functions rendering an entry, with
+	// the fields .Feed, .Title, .Link, .Summary and .Published
+	Template string `mapstructure:"template"`
+	// New entries posted per poll at most, the newest are kept (0 = all)
+	MaxItems int `mapstructure:"max_items"`
+	// Feeds posted in addition to those subscribed with /rss
+	Subscriptions []FeedSubscriptionConfig `mapstructure:"subscriptions"`
+}
+
+type FeedSubscriptionConfig struct {
+	URL string `mapstructure:"url"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Seconds between polls (0 = feeds.interval)
+	Interval int `mapstructure:"interval"`
+}
+
+// ScheduleConfig configures jobs posting messages, or webhook replies, on
+// cron schedules
+type ScheduleConfig struct {
+	// File earlier versions kept the time each job last ran in, imported
+	// into storage on first start
+	StateFile string `mapstructure:"state_file"`
+	// Time zone of the cron expressions, e.g. Europe/Berlin (empty = local)
+	TimeZone string `mapstructure:"timezone"`
+	// A run missed while the service was down is made up at startup if it
+	// was due at most this many seconds ago (0 = never)
+	CatchUp int                  `mapstructure:"catch_up"`
+	Jobs    []ScheduledJobConfig `mapstructure:"jobs"`
+}
+
+// ScheduledJobConfig posts a message, or sends it to the webhook of a
+// command and posts the reply
+type ScheduledJobConfig struct {
+	// Unique name, the key of the job in the state file
+	Name string `mapstructure:"name"`
+	// Cron expression (minute hour day-of-month month day-of-week) or
+	// @hourly, @daily, @weekly, @monthly
+	Cron string `mapstructure:"cron"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID  string `mapstructure:"room_id"`
+	Message string `mapstructure:"message"`
+	// Command of webhook.commands the message is dispatched to; its reply is
+	// posted instead of the message (empty = post the message)
+	Command string `mapstructure:"command"`
+	// Message format (markdown, html or plain) and msgtype (text or notice)
+	Format  string `mapstructure:"format"`
+	MsgType string `mapstructure:"msgtype"`
+}
+
+// RemindersConfig configures the reminders users set with /remind
+type RemindersConfig struct {
+	// Handle /remind and /reminders
+	Enabled bool `mapstructure:"enabled"`
+	// File earlier versions kept the pending reminders in, imported into
+	// storage on first start
+	StateFile string `mapstructure:"state_file"`
+	// Time zone of the times given to /remind, e.g. Europe/Berlin (empty =
+	// local)
+	TimeZone string `mapstructure:"timezone"`
+	// Time of day of reminders set for a day without a time, e.g. tomorrow
+	DefaultTime string `mapstructure:"default_time"`
+	// Pending reminders a user may have set at most (0 = no limit)
+	MaxPerUser int `mapstructure:"max_per_user"`
+}
+
+// EmailConfig configures the templated emails sent over SMTP with /email
+// and POST /email/{template}, and the inbound email posted to a room
+type EmailConfig struct {
+	// Bearer token callers of /email/{template} must send (empty = no
+	// authentication)
+	Token string     `mapstructure:"token"`
+	SMTP  SMTPConfig `mapstructure:"smtp"`
+	// Emails that can be sent, keyed by name
+	Templates map[string]EmailTemplateConfig `mapstructure:"templates"`
+	IMAP      IMAPConfig                     `mapstructure:"imap"`
+}
+
+// SMTPConfig is the server emails are sent through
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"` // Empty = no authentication
+	Password string `mapstructure:"password"`
+	// Sender address, e.g. "Mule <mule@example.com>"
+	From string `mapstructure:"from"`
+	// starttls (default), tls (implicit TLS, usually port 465) or none
+	TLS string `mapstructure:"tls"`
+	// Seconds sending an email may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// EmailTemplateConfig is an email with fixed recipients whose subject and
+// body are Go text/templates with sprig-style functions
+type EmailTemplateConfig struct {
+	To      []string `mapstructure:"to"`
+	Subject string   `mapstructure:"subject"`
+	Body    string   `mapstructure:"body"`
+}
+
+// IMAPConfig configures the mailbox whose new emails are posted to a room
+type IMAPConfig struct {
+	// Poll the mailbox
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// tls (default, usually port 993), starttls or none
+	TLS     string `mapstructure:"tls"`
+	Mailbox string `mapstructure:"mailbox"`
+	// Seconds between polls
+	Interval int `mapstructure:"interval"`
+	// File earlier versions kept the last email seen in, imported into
+	// storage on first start
+	StateFile string `mapstructure:"state_file"`
+	// Room to post to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Only emails from these addresses are posted, with * wildcards such
+	// as *@example.com (empty = all)
+	From []string `mapstructure:"from"`
+	// Only emails whose subject matches this regular expression are posted
+	// (empty = all)
+	Subject string `mapstructure:"subject"`
+	// Go text/template with sprig-style functions rendering an email, with
+	// the fields .From, .To, .Subject, .Date and .Body
+	Template string `mapstructure:"template"`
+}
+
+// TelegramConfig configures the relay between a Telegram chat and a room
+type TelegramConfig struct {
+	// Relay the chat through the Telegram Bot API
+	Enabled bool `mapstructure:"enabled"`
+	// Bot token given by @BotFather
+	Token string `mapstructure:"token"`
+	// Chat relayed, e.g. -1001234567890 for a group
+	ChatID int64 `mapstructure:"chat_id"`
+	// Room relayed, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Matrix user IDs keyed by Telegram user ID or @username. Messages of
+	// these users run commands as the Matrix user; others are only relayed.
+	Users map[string]string `mapstructure:"users"`
+	// Relay every message of the room to Telegram, not only the replies to
+	// relayed messages and the bot's replies to Telegram users
+	RelayAll bool `mapstructure:"relay_all"`
+	// Base URL of the Bot API
+	APIURL string `mapstructure:"api_url"`
+	// Seconds a request for updates waits for new messages
+	PollTimeout int `mapstructure:"poll_timeout"`
+}
+
+// PushConfig mirrors the room messages matching a rule to phones through
+// ntfy or Pushover
+type PushConfig struct {
+	// Where notifications are sent, keyed by name
+	Targets map[string]PushTargetConfig `mapstructure:"targets"`
+	// A message is sent to the targets of every rule it matches, once per
+	// target
+	Rules []PushRuleConfig `mapstructure:"rules"`
+	// Seconds sending a notification may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// PushTargetConfig is an ntfy topic or a Pushover user
+type PushTargetConfig struct {
+	// ntfy or pushover
+	Type string `mapstructure:"type"`
+	// ntfy server (default https://ntfy.sh), or Pushover API endpoint
+	// (default https://api.pushover.net/1/messages.json)
+	URL string `mapstructure:"url"`
+	// ntfy topic, and access token for protected topics
+	Topic string `mapstructure:"topic"`
+	Token string `mapstructure:"token"`
+	// Pushover application token, user or group key, and optional device
+	AppToken string `mapstructure:"app_token"`
+	UserKey  string `mapstructure:"user_key"`
+	Device   string `mapstructure:"device"`
+}
+
+// PushRuleConfig selects messages to mirror. Every condition set must
+// match; a rule without conditions mirrors every message.
+type PushRuleConfig struct {
+	Name string `mapstructure:"name"`
+	// Rooms and senders the message must come from (empty = any)
+	Rooms   []string `mapstructure:"rooms"`
+	Senders []string `mapstructure:"senders"`
+	// Regular expression the message text must match
+	Match string `mapstructure:"match"`
+	// Users at least one of which the message must mention
+	Mentions []string `mapstructure:"mentions"`
+	// Names of push.targets
+	Targets []string `mapstructure:"targets"`
+	// min, low, default, high or urgent
+	Priority string `mapstructure:"priority"`
+	// Title of the notification (empty = the rule name)
+	Title string `mapstructure:"title"`
+}
+
+// PagerDutyConfig configures paging with /page and acknowledging or
+// resolving incidents by reacting to their messages
+type PagerDutyConfig struct {
+	// Events API v2 integration keys keyed by the service name given to /page
+	Services map[string]string `mapstructure:"services"`
+	// Severity of the incidents /page triggers: critical, error, warning or
+	// info
+	Severity string `mapstructure:"severity"`
+	// REST API token, needed to acknowledge and resolve the incidents posted
+	// by /hook/pagerduty
+	APIToken string `mapstructure:"api_token"`
+	// Email of the PagerDuty user the REST API acts as
+	From string `mapstructure:"from"`
+	// Reactions acknowledging and resolving the incident of a message
+	AckReaction     string `mapstructure:"ack_reaction"`
+	ResolveReaction string `mapstructure:"resolve_reaction"`
+	// Endpoints of the Events API and of the REST API
+	EventsURL string `mapstructure:"events_url"`
+	APIURL    string `mapstructure:"api_url"`
+	// Seconds a request to PagerDuty may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// JiraConfig configures the /jira commands and the summaries of the issue
+// keys mentioned in rooms
+type JiraConfig struct {
+	// Serve /jira and expand issue keys
+	Enabled bool `mapstructure:"enabled"`
+	// Base URL of the site, e.g. https://example.atlassian.net
+	URL string `mapstructure:"url"`
+	// Email of the account of an API token on Jira Cloud; empty when the
+	// token is a personal access token of Jira Data Center
+	Email string `mapstructure:"email"`
+	Token string `mapstructure:"token"`
+	// Key of the project /jira create creates issues in, and their type
+	Project   string `mapstructure:"project"`
+	IssueType string `mapstructure:"issue_type"`
+	// Post a one-line summary of the issues whose keys messages mention
+	ExpandKeys bool `mapstructure:"expand_keys"`
+	// Projects whose keys are expanded (empty = every project)
+	ExpandProjects []string `mapstructure:"expand_projects"`
+	// Most summaries posted for one message
+	MaxExpansions int `mapstructure:"max_expansions"`
+	// Seconds a request to Jira may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// HomeAssistantConfig configures the /ha commands calling Home Assistant
+// services and the state changes and events posted to rooms
+type HomeAssistantConfig struct {
+	// Serve /ha and watch Home Assistant
+	Enabled bool `mapstructure:"enabled"`
+	// Base URL, e.g. http://homeassistant.local:8123
+	URL string `mapstructure:"url"`
+	// Long-lived access token of a Home Assistant user
+	Token string `mapstructure:"token"`
+	// Commands of /ha keyed by name
+	Commands map[string]HomeAssistantCommandConfig `mapstructure:"commands"`
+	// Users allowed to run the commands (empty = anyone the room allows);
+	// /ha state is open to everyone
+	AllowedUsers []string `mapstructure:"allowed_users"`
+	// State changes and events posted to rooms
+	Watch []HomeAssistantWatchConfig `mapstructure:"watch"`
+	// Room watched changes are posted to, defaults to matrix.roomid
+	RoomID string `mapstructure:"room_id"`
+	// Seconds a service call may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// HomeAssistantCommandConfig maps an /ha command to a service call
+type HomeAssistantCommandConfig struct {
+	// Service called, as domain.service, e.g. light.turn_on
+	Service string `mapstructure:"service"`
+	// Service data. String values are Go text/templates of .args (the text
+	// after the command) and .sender.
+	Data map[string]interface{} `mapstructure:"data"`
+	// Go text/template of the reply, with .args, .sender and .states, the
+	// entities the call changed (empty = a confirmation)
+	Reply string `mapstructure:"reply"`
+}
+
+// HomeAssistantWatchConfig selects state changes or events to post
+type HomeAssistantWatchConfig struct {
+	// Entities whose state changes are posted; * matches any characters,
+	// e.g. binary_sensor.*_door
+	Entities []string `mapstructure:"entities"`
+	// Only changes to these states (empty = every change of state)
+	To []string `mapstructure:"to"`
+	// Event type posted instead of state changes, e.g. doorbell_pressed
+	Event string `mapstructure:"event"`
+	// Go text/template of the message, with .entity_id, .name, .from, .to
+	// and .attributes for state changes, .event_type and .data for events
+	// (empty = a built-in message)
+	Template string `mapstructure:"template"`
+	// Room to post to, defaults to homeassistant.room_id
+	RoomID string `mapstructure:"room_id"`
+}
+
+// TranslateConfig configures the translation service of /translate and of
+// the rooms with auto_translate
+type TranslateConfig struct {
+	// Serve /translate and translate rooms with auto_translate
+	Enabled bool `mapstructure:"enabled"`
+	// Translation service: deepl or libretranslate
+	Provider string `mapstructure:"provider"`
+	// Base URL of the API. Empty for DeepL picks its free or pro API by the
+	// key; required for LibreTranslate, e.g. https://libretranslate.com
+	URL string `mapstructure:"url"`
+	// DeepL authentication key, or LibreTranslate API key if the instance
+	// requires one
+	APIKey string `mapstructure:"api_key"`
+	// Longest text translated, in characters
+	MaxLength int `mapstructure:"max_length"`
+	// Seconds a translation may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// VisionConfig configures the image pipeline: images posted in the rooms
+// are sent to a vision or OCR endpoint, and the text it returns is handled
+// like a message, with the caption of the image
+type VisionConfig struct {
+	// Send images to the endpoint
+	Enabled bool `mapstructure:"enabled"`
+	// API of the endpoint: openai (chat completions with the image, e.g. a
+	// vision model of OpenAI, Ollama or vLLM) or multipart (the image POSTed
+	// as form field image, with the fields caption, sender and room_id)
+	API string `mapstructure:"api"`
+	// Endpoint URL, e.g. https://api.openai.com/v1/chat/completions
+	URL string `mapstructure:"url"`
+	// Bearer token of the endpoint
+	APIKey string `mapstructure:"api_key"`
+	// Model of the openai API
+	Model string `mapstructure:"model"`
+	// Instruction sent with the image to the openai API; the caption is
+	// appended
+	Prompt string `mapstructure:"prompt"`
+	// Extracts the text from JSON responses of the multipart API (empty =
+	// the response body is the text)
+	JQSelector string `mapstructure:"jq_selector"`
+	// Only images whose caption mentions the bot
+	RequireMention bool `mapstructure:"require_mention"`
+	// Go text/template of the message handled, with .Caption, .Text and
+	// .FileName
+	Template string `mapstructure:"template"`
+	// Largest image sent, in megabytes
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+	// Seconds the endpoint may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// StorageConfig selects the database the state is kept in
+type StorageConfig struct {
+	// sqlite or postgres
+	Driver string `mapstructure:"driver"`
+	// Path of the SQLite file, or Postgres connection string, e.g.
+	// postgres://mule:secret@db/mule?sslmode=disable
+	DSN string `mapstructure:"dsn"`
+}
+
+// FeedbackConfig forwards reactions to the replies of commands, webhooks
+// and models to a feedback webhook, e.g. to rate answers or approve them
+type FeedbackConfig struct {
+	// Forward reactions to replies
+	Enabled bool `mapstructure:"enabled"`
+	// Webhook the reactions are POSTed to as JSON
+	URL string `mapstructure:"url"`
+	// Authorization header sent to the webhook, e.g. "Bearer <token>"
+	AuthToken string `mapstructure:"auth_token"`
+	// Reactions forwarded, e.g. 👍 and 👎 (empty = every reaction)
+	Reactions []string `mapstructure:"reactions"`
+	// Commands whose replies collect feedback; replies to messages without
+	// a command are default, llm or ollama (empty = every reply)
+	Commands []string `mapstructure:"commands"`
+	// Replies remembered, the oldest are forgotten first
+	MaxReplies int `mapstructure:"max_replies"`
+	// Seconds the webhook may take
+	Timeout int `mapstructure:"timeout"`
+}
+
+// PaginationConfig splits replies longer than a page. The first page is
+// posted with a reaction; reacting with it posts the next page in a thread
+// on the first.
+type PaginationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Characters per page
+	PageSize int `mapstructure:"page_size"`
+	// Pages of a reply, the rest is cut off
+	MaxPages int `mapstructure:"max_pages"`
+	// Reaction the bot adds to a page with more to come, e.g. "▶ more"
+	Reaction string `mapstructure:"reaction"`
+	// Replies whose next page can be requested, the oldest are forgotten
+	// first
+	MaxReplies int `mapstructure:"max_replies"`
+}
+
+// WebhookProbeConfig sends a HEAD request to every configured webhook at
+// startup and after each reload, and posts which answered, and how fast, to
+// a room. Like the check command, a webhook answering below 500 passes.
+type WebhookProbeConfig struct {
+	// Room the report is posted to (empty = no probing)
+	RoomID string `mapstructure:"room_id"`
+	// Seconds each webhook may take to answer
+	Timeout int `mapstructure:"timeout"`
+	// Post the report only if a webhook is unreachable
+	OnlyFailures bool `mapstructure:"only_failures"`
+}
+
+// Scopes of memory.scope
+const (
+	MemoryScopeRoomUser = "room_user" // Each user has own values in each room
+	MemoryScopeUser     = "user"      // Each user has the same values in every room
+	MemoryScopeRoom     = "room"      // The users of a room share its values
+)
+
+// MemoryConfig is a small key-value memory set with /set, read with /get and
+// cleared with /forget, so that bots remember preferences between
+// conversations. It is kept encrypted in storage.
+type MemoryConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Whose values a message sees: room_user, user or room
+	Scope string `mapstructure:"scope"`
+	// Secret the memory is encrypted with (empty = matrix.picklekey).
+	// Values stored with another key cannot be read.
+	EncryptionKey string `mapstructure:"encryption_key"`
+	// Keys of each user or room
+	MaxKeys int `mapstructure:"max_keys"`
+	// Characters of each value
+	MaxValueLength int `mapstructure:"max_value_length"`
+}
+
+// PermissionsConfig restricts commands to some senders. A command matching
+// the commands of a rule may only be run by the users of the rules it
+// matches; commands matching no rule are open to everyone.
+type PermissionsConfig struct {
+	// Lists of user ID patterns, used in rules as group:<name>
+	Groups map[string][]string `mapstructure:"groups"`
+	Rules  []PermissionRule    `mapstructure:"rules"`
+}
+
+// PermissionRule lets users run commands. Both are glob patterns as of
+// path.Match, e.g. @*:ops.example.com or db_*; commands are named without
+// the slash.
+type PermissionRule struct {
+	Users    []string `mapstructure:"users"`
+	Commands []string `mapstructure:"commands"`
+}
+
+// ConfirmationsConfig holds dangerous commands: the bot replies with a
+// summary and runs the command only once a user confirms it with the
+// reaction or a reply within the timeout.
+type ConfirmationsConfig struct {
+	// Glob patterns of the commands, without the slash, e.g. deploy or db_*
+	// (empty = none)
+	Commands []string `mapstructure:"commands"`
+	// Seconds a command waits for its confirmation
+	Timeout int `mapstructure:"timeout"`
+	// Reaction to the summary confirming the command
+	Reaction string `mapstructure:"reaction"`
+	// Replies confirming the command, ignoring case
+	Replies []string `mapstructure:"replies"`
+	// Who may confirm: sender (who sent the command), permitted (anyone
+	// permitted to run it) or admins (server.admin_users only). Admins may
+	// confirm in every mode.
+	ConfirmBy string `mapstructure:"confirm_by"`
+}
+
+// Requires reports whether the command waits for a confirmation
+func (c *ConfirmationsConfig) Requires(command string) bool {
+	command = strings.TrimPrefix(command, "/")
+	return command != "" && matchesAny(c.Commands, command)
+}
+
+// QuietHoursConfig holds the times notifications such as alerts, feed
+// entries and scheduled jobs are held and posted when they end. Replies to
+// messages and commands are never held.
+type QuietHoursConfig struct {
+	// Times of day, e.g. "22:00-07:00"; a range ending before it starts
+	// ends the next day (empty = never quiet)
+	Ranges []string `mapstructure:"ranges"`
+	// Days the ranges start on: mon, tue, wed, thu, fri, sat or sun
+	// (empty = every day)
+	Days []string `mapstructure:"days"`
+	// Time zone of the ranges, e.g. Europe/Berlin (empty = local time)
+	TimeZone string `mapstructure:"timezone"`
+	// Glob patterns of the sources posted also during quiet hours, e.g.
+	// alertmanager:* or hook:pagerduty. Sources are message, notify:<template>,
+	// hook:<name>, alertmanager:<receiver>, grafana, discord, feed,
+	// schedule:<job>, email, homeassistant and webhook_probe.
+	Critical []string `mapstructure:"critical"`
+}
+
+// IsCritical reports whether notifications of the source are posted also
+// during quiet hours
+func (q *QuietHoursConfig) IsCritical(source string) bool {
+	return matchesAny(q.Critical, source)
+}
+
+// BridgeConfig describes a bridge bot that relays the messages of people on
+// another network, e.g. IRC or Telegram, under its own Matrix user. Relayed
+// messages are handled as sent by a user ID made for each person, so that
+// allowed users, permissions and sessions apply to them rather than to the
+// bridge bot.
+type BridgeConfig struct {
+	// Name of the bridge, used in the user IDs of bridged senders, e.g.
+	// telegram
+	Name string `mapstructure:"name"`
+	// Glob patterns of the bridge bot users, e.g. @telegrambot:example.com
+	Users []string `mapstructure:"users"`
+	// Regular expression matching the sender's name at the start of a
+	// relayed message, in a group named name, e.g. "^<(?P<name>[^>]+)> ".
+	// The match is removed from the message.
+	Prefix string `mapstructure:"prefix"`
+	// Take the sender from the per-message profile (MSC4144) that bridges
+	// add to relayed messages, before the prefix
+	PerMessageProfile bool `mapstructure:"per_message_profile"`
+	// User ID of bridged senders: {name} is the sender's profile ID or name,
+	// {bridge} the name of the bridge and {server} the server of the bridge
+	// bot
+	SenderFormat string `mapstructure:"sender_format"`
+}
+
+// DefaultBridgeSenderFormat is the sender_format of bridges that set none
+const DefaultBridgeSenderFormat = "@{bridge}_{name}:{server}"
+
+// RelayedBy reports whether the user is a bot of the bridge
+func (b *BridgeConfig) RelayedBy(userID string) bool {
+	return matchesAny(b.Users, userID)
+}
+
+// LifecycleNoticesConfig posts notices when the service starts and stops,
+// so that users know when the bot is unavailable. They go to matrix.roomid
+// and the rooms that set lifecycle_notices, unless the room turns them off.
+type LifecycleNoticesConfig struct {
+	// Post that the bot is online, with its version, once the service
+	// started
+	Startup bool `mapstructure:"startup"`
+	// Post that the bot is going offline when the service stops
+	Shutdown bool `mapstructure:"shutdown"`
+	// Templates replacing the built-in notices, with .Version, .Commit and
+	// .BuildDate (empty = the notice in the language of the room)
+	StartupTemplate  string `mapstructure:"startup_template"`
+	ShutdownTemplate string `mapstructure:"shutdown_template"`
+}
+
+// LifecycleNoticeRooms returns the rooms the startup and shutdown notices
+// are posted to: matrix.roomid and the rooms that set lifecycle_notices,
+// without those that turn it off
+func (c *Config) LifecycleNoticeRooms() []string {
+	var roomIDs []string
+	seen := make(map[string]bool)
+	for _, room := range c.Rooms {
+		seen[room.RoomID] = true
+		if room.LifecycleNotices != nil && *room.LifecycleNotices || room.LifecycleNotices == nil && room.RoomID == c.Matrix.RoomID {
+			roomIDs = append(roomIDs, room.RoomID)
+		}
+	}
+	if c.Matrix.RoomID != "" && !seen[c.Matrix.RoomID] {
+		roomIDs = append([]string{c.Matrix.RoomID}, roomIDs...)
+	}
+	return roomIDs
+}
+
+// AttachmentsConfig limits the files passing through the bot, so that it
+// cannot be used to move arbitrary binaries between rooms and services
+type AttachmentsConfig struct {
+	// Attachments posted in rooms that the bot reads, i.e. images sent to
+	// the vision endpoint
+	Inbound AttachmentPolicy `mapstructure:"inbound"`
+	// Media the bot uploads to rooms: POST /media, Discord attachments and
+	// Grafana images
+	Outbound AttachmentPolicy `mapstructure:"outbound"`
+}
+
+// AttachmentPolicy holds the attachments allowed in one direction
+type AttachmentPolicy struct {
+	// Largest attachment in megabytes, lowering the limit of the feature,
+	// vision.max_size_mb or server.media_max_size_mb (0 = that limit)
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+	// Glob patterns of the MIME types allowed, e.g. image/* or
+	// application/pdf (empty = all)
+	MimeTypes []string `mapstructure:"mime_types"`
+}
+
+// MaxSize returns the largest attachment allowed in bytes by the policy and
+// featureMB, the limit of the feature in megabytes
+func (p *AttachmentPolicy) MaxSize(featureMB int) int64 {
+	if p.MaxSizeMB > 0 && p.MaxSizeMB < featureMB {
+		featureMB = p.MaxSizeMB
+	}
+	return int64(featureMB) << 20
+}
+
+// AllowsType reports whether attachments of the MIME type are allowed.
+// Parameters such as charset are ignored.
+func (p *AttachmentPolicy) AllowsType(mimeType string) bool {
+	if len(p.MimeTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	return matchesAny(p.MimeTypes, strings.TrimSpace(mediaType))
+}
+
+// PermissionGroupPrefix marks a group in the users of a rule
+const PermissionGroupPrefix = "group:"
+
+// Allows reports whether the user may run the command
+func (p *PermissionsConfig) Allows(userID, command string) bool {
+	command = strings.ToLower(strings.TrimPrefix(command, "/"))
+	restricted := false
+	for _, rule := range p.Rules {
+		if !matchesAny(rule.Commands, command) {
+			continue
+		}
+		restricted = true
+		for _, user := range rule.Users {
+			if group, ok := strings.CutPrefix(user, PermissionGroupPrefix); ok {
+				if matchesAny(p.Groups[strings.ToLower(group)], userID) {
+					return true
+				}
+			} else if matchesAny([]string{user}, userID) {
+				return true
+			}
+		}
+	}
+	return !restricted
+}
+
+// matchesAny reports whether value matches one of the glob patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// I18nConfig selects the message catalog of the bot's own replies
+type I18nConfig struct {
+	// Language of the replies, e.g. de or pt-BR. Messages missing in its
+	// catalog are sent in English.
+	Language string `mapstructure:"language"`
+	// Directory of <language>.json catalogs adding languages or replacing
+	// messages of the built-in ones
+	CatalogDir string `mapstructure:"catalog_dir"`
 }
 
 func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("../config")
-
-	// Set default values
-	viper.SetDefault("server.port", 8080)
-	viper.SetDefault("webhook.template", `{"message": "{{MESSAGE}}"}`)
-	viper.SetDefault("webhook.timeout", 30)
-	viper.SetDefault("logging.level", "info")
-	viper.SetDefault("logging.file", "")
-	viper.SetDefault("matrix.enable_encryption", true)
-	viper.SetDefault("matrix.sync_timeout", 120)
-	viper.SetDefault("matrix.skip_initial_sync", false)
-	// Command execution defaults
-	viper.SetDefault("webhook.enable_commands", false)
-	viper.SetDefault("webhook.command_prefix", "/cmd")
-	viper.SetDefault("webhook.session_timeout", 600) // 10 minutes
-	viper.SetDefault("webhook.default_command", "")
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		// The format follows the extension of the file found
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("./config")
+		viper.AddConfigPath("../config")
+	}
 
-	// Environment variable support
+	setDefaults(viper.GetViper())
+
+	// Environment variables override the file. AutomaticEnv only applies to
+	// keys viper already knows, so every setting is bound explicitly.
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
+	if err := bindEnvs(reflect.TypeOf(Config{}), ""); err != nil {
+		return nil, fmt.Errorf("failed to bind environment variables: %w", err)
+	}
 
+	// The file is optional when the config comes from the environment, unless
+	// it was given explicitly
 	if err := viper.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	if err := mergeOverlay(); err != nil {
+		return nil, err
 	}
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		jsonStringHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := viper.Unmarshal(&config, decodeHook); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := resolveSecrets(&config); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
 
 	return &config, nil
 }
 
+// setDefaults sets the default value of every setting that has one
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("server.port", 8080)
+	v.SetDefault("server.shutdown_timeout", 30)
+	v.SetDefault("server.media_max_size_mb", 50)
+	v.SetDefault("server.ready_max_sync_age", 120)
+	v.SetDefault("server.enable_debug", false)
+	v.SetDefault("server.rate_limit", 0)
+	v.SetDefault("server.rate_limit_burst", 20)
+	v.SetDefault("server.trust_proxy_headers", false)
+	v.SetDefault("server.trusted_proxies", []string{})
+	v.SetDefault("server.max_body_size_kb", 1024)
+	v.SetDefault("server.cors.allowed_methods", []string{"GET", "POST", "PUT", "DELETE"})
+	v.SetDefault("server.cors.allowed_headers", []string{"Authorization", "Content-Type", "X-Request-ID"})
+	v.SetDefault("server.cors.exposed_headers", []string{"Retry-After", "X-Request-ID"})
+	v.SetDefault("server.cors.max_age", 600)
+	v.SetDefault("webhook.template", `{"message": "{{.MESSAGE}}"}`)
+	v.SetDefault("webhook.timeout", 30)
+	v.SetDefault("webhook.user_agent", "matrix-microservice")
+	v.SetDefault("webhook.propagate_trace", true)
+	v.SetDefault("webhook.ping_url", "")
+	v.SetDefault("webhook.retry_attempts", 0)
+	v.SetDefault("webhook.retry_delay", 5)
+	v.SetDefault("dedup.max_entries", 10000)
+	v.SetDefault("dedup.events.enabled", true)
+	v.SetDefault("dedup.events.ttl", 86400)
+	v.SetDefault("dedup.hooks.enabled", false)
+	v.SetDefault("dedup.hooks.ttl", 600)
+	v.SetDefault("encryption_policy.mode", "off")
+	v.SetDefault("encryption_policy.enable_encryption", false)
+	v.SetDefault("error_replies.enabled", true)
+	v.SetDefault("error_replies.template", "")
+	v.SetDefault("error_replies.include_error", false)
+	v.SetDefault("error_replies.spoiler", false)
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.file", "")
+	v.SetDefault("logging.target", "")
+	v.SetDefault("logging.syslog_address", "")
+	v.SetDefault("logging.tag", "matrix-microservice")
+	v.SetDefault("logging.max_size", 100)
+	v.SetDefault("logging.rotate_hours", 0)
+	v.SetDefault("logging.max_age", 0)
+	v.SetDefault("logging.max_backups", 0)
+	v.SetDefault("logging.compress", false)
+	v.SetDefault("logging.redact_messages", false)
+	v.SetDefault("logging.sample_initial", 10)
+	v.SetDefault("logging.sample_thereafter", 100)
+	v.SetDefault("logging.buffer_size", 1000)
+	v.SetDefault("audit.dir", "")
+	v.SetDefault("audit.retention_days", 365)
+	v.SetDefault("archive.enabled", false)
+	v.SetDefault("archive.region", "us-east-1")
+	v.SetDefault("archive.prefix", "matrix-archive/")
+	v.SetDefault("archive.path_style", true)
+	v.SetDefault("archive.batch_size", 500)
+	v.SetDefault("archive.flush_interval", 60)
+	v.SetDefault("archive.retention_days", 0)
+	v.SetDefault("archive.timeout", 30)
+	v.SetDefault("plugins.dir", "")
+	v.SetDefault("plugins.timeout", 5)
+	v.SetDefault("wasm.timeout", 1000)
+	v.SetDefault("wasm.max_memory_mb", 64)
+	v.SetDefault("llm.enabled", false)
+	v.SetDefault("llm.temperature", 0.7)
+	v.SetDefault("llm.max_history", 20)
+	v.SetDefault("llm.history_ttl", 3600)
+	v.SetDefault("llm.timeout", 60)
+	v.SetDefault("ollama.enabled", false)
+	v.SetDefault("ollama.url", "http://localhost:11434")
+	v.SetDefault("ollama.max_history", 20)
+	v.SetDefault("ollama.edit_interval", 1000)
+	v.SetDefault("ollama.timeout", 300)
+	v.SetDefault("feeds.enabled", false)
+	v.SetDefault("feeds.state_file", "feeds.json")
+	v.SetDefault("feeds.interval", 900)
+	v.SetDefault("feeds.template", "**{{.Feed}}**: [{{.Title}}]({{.Link}})")
+	v.SetDefault("feeds.max_items", 5)
+	v.SetDefault("schedule.state_file", "schedule.json")
+	v.SetDefault("schedule.timezone", "")
+	v.SetDefault("schedule.catch_up", 3600)
+	v.SetDefault("reminders.enabled", false)
+	v.SetDefault("reminders.state_file", "reminders.json")
+	v.SetDefault("reminders.timezone", "")
+	v.SetDefault("reminders.default_time", "09:00")
+	v.SetDefault("reminders.max_per_user", 25)
+	v.SetDefault("email.smtp.port", 587)
+	v.SetDefault("email.smtp.tls", "starttls")
+	v.SetDefault("email.smtp.timeout", 30)
+	v.SetDefault("email.imap.enabled", false)
+	v.SetDefault("email.imap.port", 993)
+	v.SetDefault("email.imap.tls", "tls")
+	v.SetDefault("email.imap.mailbox", "INBOX")
+	v.SetDefault("email.imap.interval", 60)
+	v.SetDefault("email.imap.state_file", "email.json")
+	v.SetDefault("push.timeout", 10)
+	v.SetDefault("pagerduty.severity", "critical")
+	v.SetDefault("pagerduty.events_url", "https://events.pagerduty.com/v2/enqueue")
+	v.SetDefault("pagerduty.api_url", "https://api.pagerduty.com")
+	v.SetDefault("pagerduty.ack_reaction", "👀")
+	v.SetDefault("pagerduty.resolve_reaction", "✅")
+	v.SetDefault("pagerduty.timeout", 30)
+	v.SetDefault("jira.enabled", false)
+	v.SetDefault("jira.issue_type", "Task")
+	v.SetDefault("jira.expand_keys", true)
+	v.SetDefault("jira.max_expansions", 3)
+	v.SetDefault("jira.timeout", 30)
+	v.SetDefault("vision.enabled", false)
+	v.SetDefault("vision.api", "openai")
+	v.SetDefault("vision.prompt", "Describe this image. Transcribe any text in it, such as error messages, exactly.")
+	v.SetDefault("storage.driver", "sqlite")
+	v.SetDefault("storage.dsn", "state.db")
+	v.SetDefault("feedback.enabled", false)
+	v.SetDefault("feedback.max_replies", 1000)
+	v.SetDefault("feedback.timeout", 10)
+	v.SetDefault("confirmations.timeout", 300)
+	v.SetDefault("confirmations.reaction", "✅")
+	v.SetDefault("confirmations.replies", []string{"yes"})
+	v.SetDefault("confirmations.confirm_by", "permitted")
+	v.SetDefault("pagination.enabled", false)
+	v.SetDefault("pagination.page_size", 4000)
+	v.SetDefault("pagination.max_pages", 10)
+	v.SetDefault("pagination.reaction", "▶ more")
+	v.SetDefault("pagination.max_replies", 1000)
+	v.SetDefault("webhook_probe.room_id", "")
+	v.SetDefault("webhook_probe.timeout", 5)
+	v.SetDefault("webhook_probe.only_failures", false)
+	v.SetDefault("memory.enabled", false)
+	v.SetDefault("memory.scope", MemoryScopeRoomUser)
+	v.SetDefault("memory.encryption_key", "")
+	v.SetDefault("memory.max_keys", 50)
+	v.SetDefault("memory.max_value_length", 1000)
+	v.SetDefault("i18n.language", "en")
+	v.SetDefault("i18n.catalog_dir", "")
+	v.SetDefault("vision.template", "{{ .Caption }}\n\n{{ .Text }}")
+	v.SetDefault("vision.max_size_mb", 10)
+	v.SetDefault("vision.timeout", 60)
+	v.SetDefault("translate.enabled", false)
+	v.SetDefault("translate.provider", "deepl")
+	v.SetDefault("translate.max_length", 5000)
+	v.SetDefault("translate.timeout", 30)
+	v.SetDefault("homeassistant.enabled", false)
+	v.SetDefault("homeassistant.timeout", 30)
+	v.SetDefault("telegram.enabled", false)
+	v.SetDefault("telegram.api_url", "https://api.telegram.org")
+	v.SetDefault("telegram.poll_timeout", 50)
+	v.SetDefault("email.imap.template", "**{{.Subject}}** from {{.From}}\n\n{{.Body | trunc 2000}}")
+	v.SetDefault("matrix.enable_encryption", true)
+	v.SetDefault("matrix.sync_timeout", 120)
+	v.SetDefault("matrix.skip_initial_sync", false)
+	v.SetDefault("matrix.decryption_alert.room_id", "")
+	v.SetDefault("matrix.decryption_alert.threshold", 5)
+	v.SetDefault("matrix.decryption_alert.window", 300)
+	v.SetDefault("matrix.withheld_notice", true)
+	v.SetDefault("matrix.queue.workers", 4)
+	v.SetDefault("matrix.queue.size", 100)
+	v.SetDefault("matrix.queue.overflow", "block")
+	v.SetDefault("matrix.watchdog.stale_after", 300)
+	v.SetDefault("matrix.watchdog.interval", 30)
+	v.SetDefault("matrix.watchdog.max_restarts", 3)
+	v.SetDefault("matrix.watchdog.alert_room_id", "")
+	v.SetDefault("matrix.key_rotation.max_messages", 0)
+	v.SetDefault("matrix.key_rotation.max_age", 0)
+	v.SetDefault("matrix.key_rotation.reshare_interval", 0)
+	v.SetDefault("matrix.key_rotation.verified_devices_only", false)
+	v.SetDefault("matrix.oidc.enabled", false)
+	v.SetDefault("matrix.oidc.issuer", "")
+	v.SetDefault("matrix.oidc.client_id", "")
+	v.SetDefault("matrix.oidc.client_name", "matrix-microservice")
+	v.SetDefault("matrix.oidc.client_uri", "https://github.com/mule-ai/mule")
+	v.SetDefault("matrix.oidc.token_file", "oidc_tokens.json")
+	v.SetDefault("matrix.read_marker.enabled", false)
+	v.SetDefault("matrix.read_marker.grace_window", 0)
+	v.SetDefault("matrix.read_marker.interval", 5)
+	v.SetDefault("matrix.appservice.enabled", false)
+	// Command execution defaults
+	v.SetDefault("webhook.enable_commands", false)
+	v.SetDefault("webhook.command_prefix", "/cmd")
+	v.SetDefault("webhook.session_timeout", 600) // 10 minutes
+	v.SetDefault("webhook.default_command", "")
+	v.SetDefault("webhook.command_queue_depth", 5)
+	v.SetDefault("webhook.max_sessions", 100)
+	v.SetDefault("webhook.enforce_session_ownership", false)
+	v.SetDefault("webhook.dry_run", false)
+	v.SetDefault("webhook.exec_mode", "shell")
+	v.SetDefault("webhook.script_timeout", 100)
+	v.SetDefault("webhook.idempotency.enabled", true)
+	v.SetDefault("webhook.idempotency.ttl", 86400)
+	v.SetDefault("webhook.egress.allow_link_local", false)
+	v.SetDefault("webhook.transcript.format", "markdown")
+	v.SetDefault("webhook.transcript.max_entries", 200)
+	v.SetDefault("webhook.transcript.export_on_expiry", false)
+	v.SetDefault("webhook.template_options.missing_key", "default")
+	v.SetDefault("webhook.template_options.strict", false)
+	v.SetDefault("webhook.template_options.escape", "none")
+	v.SetDefault("webhook.command_store", "registered_commands.json")
+	// Inbound hook defaults
+	v.SetDefault("hooks.alertmanager.enabled", false)
+	v.SetDefault("hooks.grafana.enabled", false)
+	v.SetDefault("hooks.grafana.upload_images", true)
+	v.SetDefault("hooks.discord.enabled", false)
+	// Secret backend defaults
+	v.SetDefault("secrets.vault.timeout", 10)
+	v.SetDefault("secrets.sops.binary", "sops")
+	// Stream defaults
+	v.SetDefault("stream.enabled", false)
+	v.SetDefault("stream.buffer_size", 64)
+	v.SetDefault("stream.history_size", 1000)
+}
+
+// bindEnvs binds an environment variable to every setting of a config
+// struct. Maps and lists are bound as a whole.
+func bindEnvs(t reflect.Type, prefix string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			if err := bindEnvs(field.Type, key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := viper.BindEnv(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonStringHookFunc decodes maps and lists given as JSON strings, which is
+// how they are passed in environment variables (e.g.
+// MATRIXSVC_WEBHOOK_COMMANDS='{"deploy": "http://ci/deploy"}'). Lists of
+// plain values may also be comma-separated.
+func jsonStringHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		text, ok := data.(string)
+		if from.Kind() != reflect.String || !ok {
+			return data, nil
+		}
+		trimmed := strings.TrimSpace(text)
+		isJSON := (to.Kind() == reflect.Map && strings.HasPrefix(trimmed, "{")) ||
+			(to.Kind() == reflect.Slice && strings.HasPrefix(trimmed, "["))
+		if !isJSON {
+			return data, nil
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(trimmed), &decoded); err != nil {
+			return nil, fmt.Errorf("invalid JSON value %q: %w", text, err)
+		}
+		return decoded, nil
+	}
+}
+
 // EnsurePickleKey generates a pickle key if one is not set in the config
 func (c *Config) EnsurePickleKey() bool {
 	if c.Matrix.PickleKey == "" || c.Matrix.PickleKey == "your_pickle_key_here" {
@@ -129,3 +2057,14 @@ func (c *Config) EnsurePickleKey() bool {
 	}
 	return false
 }
+
+// IsDryRun reports whether the named command should be rendered without being
+// executed. A per-command setting overrides the global dry_run flag.
+func (w *WebhookConfig) IsDryRun(command string) bool {
+	if command != "" {
+		if dryRun, exists := w.CommandDryRun[command]; exists {
+			return dryRun
+		}
+	}
+	return w.DryRun
+}
@@ -0,0 +1,300 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/spf13/viper"
+)
+
+// loadTestConfig loads the config file at path with LoadConfig, which reads
+// the global viper instance, and resets it afterwards
+func loadTestConfig(t *testing.T, path string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		configFile, profile, overlayFile = "", "", ""
+	})
+	if err := SetConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig()
+}
+
+// writeTestFile writes a file to dir and returns its path
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDecryptsAge(t *testing.T) {
+	dir := t.TempDir()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGE_KEY_FILE", writeTestFile(t, dir, "age.key", identity.String()+"\n"))
+
+	accessToken := "age:" + encryptAge(t, identity.Recipient(), "syt_secret", false)
+	recoveryKey := encryptAge(t, identity.Recipient(), "EsTc recovery key\n", true)
+	path := writeTestFile(t, dir, "config.yaml", `matrix:
+  homeserver: "https://matrix.example.com"
+  accesstoken: "`+accessToken+`"
+  recoverykey: |
+    age:`+strings.ReplaceAll(strings.TrimSpace(recoveryKey), "\n", "\n    ")+`
+`)
+
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Matrix.AccessToken != "syt_secret" {
+		t.Errorf("matrix.accesstoken = %q, want the plaintext", cfg.Matrix.AccessToken)
+	}
+	if cfg.Matrix.RecoveryKey != "EsTc recovery key" {
+		t.Errorf("matrix.recoverykey = %q, want the plaintext without its trailing newline", cfg.Matrix.RecoveryKey)
+	}
+
+	// Saving keeps the ciphertext
+	cfg.Matrix.DeviceID = "NEWDEVICE"
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "syt_secret") || !strings.Contains(string(saved), accessToken) || !strings.Contains(string(saved), "NEWDEVICE") {
+		t.Errorf("saved config =\n%s\nwant the device ID and the encrypted access token", saved)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "config.yaml", `matrix:
+  homeserver: "https://matrix.example.com"
+  accesstoken: "from_file"
+server:
+  port: 8080
+webhook:
+  commands:
+    status: "http://ci:3000/status"
+`)
+	t.Setenv("MATRIXSVC_MATRIX_ACCESSTOKEN", "syt_from_env")
+	t.Setenv("MATRIXSVC_SERVER_PORT", "9090")
+	t.Setenv("MATRIXSVC_SERVER_CORS_ALLOWED_ORIGINS", "https://tools.example.com,https://ops.example.com")
+	t.Setenv("MATRIXSVC_SERVER_CORS_ALLOWED_METHODS", `["GET", "POST"]`)
+	t.Setenv("MATRIXSVC_WEBHOOK_COMMANDS", `{"deploy": "http://ci:3000/deploy"}`)
+
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Matrix.Homeserver != "https://matrix.example.com" {
+		t.Errorf("matrix.homeserver = %q, want the file value", cfg.Matrix.Homeserver)
+	}
+	if cfg.Matrix.AccessToken != "syt_from_env" || cfg.Server.Port != 9090 {
+		t.Errorf("matrix.accesstoken = %q, server.port = %d, want the environment values", cfg.Matrix.AccessToken, cfg.Server.Port)
+	}
+	if got := cfg.Server.CORS.AllowedOrigins; !reflect.DeepEqual(got, []string{"https://tools.example.com", "https://ops.example.com"}) {
+		t.Errorf("server.cors.allowed_origins = %q", got)
+	}
+	if got := cfg.Server.CORS.AllowedMethods; !reflect.DeepEqual(got, []string{"GET", "POST"}) {
+		t.Errorf("server.cors.allowed_methods = %q", got)
+	}
+	// A map from the environment replaces the one in the file
+	if got := cfg.Webhook.Commands; !reflect.DeepEqual(got, map[string]string{"deploy": "http://ci:3000/deploy"}) {
+		t.Errorf("webhook.commands = %v", got)
+	}
+}
+
+func TestLoadConfigFromEnvInvalidJSON(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "config.yaml", "server:\n  port: 8080\n")
+	t.Setenv("MATRIXSVC_WEBHOOK_COMMANDS", `{"deploy": `)
+
+	if _, err := loadTestConfig(t, path); err == nil || !strings.Contains(err.Error(), "invalid JSON value") {
+		t.Errorf("LoadConfig() error = %v, want an invalid JSON error", err)
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("MATRIXSVC_MATRIX_HOMESERVER", "https://matrix.example.com")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Matrix.Homeserver != "https://matrix.example.com" || cfg.Server.Port != 8080 {
+		t.Errorf("matrix.homeserver = %q, server.port = %d, want the environment value and the default port", cfg.Matrix.Homeserver, cfg.Server.Port)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", "matrix:\n  homeserver: https://matrix.example.com\nserver:\n  port: 9090\nwebhook:\n  commands:\n    deploy: http://ci:3000/deploy\n"},
+		{"config.yml", "matrix:\n  homeserver: https://matrix.example.com\nserver:\n  port: 9090\nwebhook:\n  commands:\n    deploy: http://ci:3000/deploy\n"},
+		{"config.json", `{"matrix": {"homeserver": "https://matrix.example.com"}, "server": {"port": 9090}, "webhook": {"commands": {"deploy": "http://ci:3000/deploy"}}}`},
+		{"config.toml", "[matrix]\nhomeserver = \"https://matrix.example.com\"\n\n[server]\nport = 9090\n\n[webhook.commands]\ndeploy = \"http://ci:3000/deploy\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), tt.name, tt.content)
+			cfg, err := loadTestConfig(t, path)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.Matrix.Homeserver != "https://matrix.example.com" || cfg.Server.Port != 9090 || cfg.Webhook.Commands["deploy"] != "http://ci:3000/deploy" {
+				t.Errorf("LoadConfig() = %+v, %+v, %v, want the file's settings", cfg.Matrix, cfg.Server, cfg.Webhook.Commands)
+			}
+
+			// Saving keeps the format, so the file loads again
+			cfg.Matrix.DeviceID = "NEWDEVICE"
+			if err := SaveConfig(cfg); err != nil {
+				t.Fatalf("SaveConfig() error = %v", err)
+			}
+			saved, err := loadTestConfig(t, path)
+			if err != nil {
+				t.Fatalf("LoadConfig() after saving error = %v", err)
+			}
+			if saved.Matrix.DeviceID != "NEWDEVICE" || saved.Server.Port != 9090 {
+				t.Errorf("saved config has device ID %q and port %d", saved.Matrix.DeviceID, saved.Server.Port)
+			}
+		})
+	}
+}
+
+func TestSetConfigFile(t *testing.T) {
+	t.Cleanup(func() { configFile = "" })
+	for _, path := range []string{"bot.yaml", "/etc/matrix/bot.YML", "bot.json", "bot.toml"} {
+		if err := SetConfigFile(path); err != nil {
+			t.Errorf("SetConfigFile(%q) error = %v", path, err)
+		}
+	}
+	for _, path := range []string{"bot.ini", "bot", "bot.yaml.bak"} {
+		if err := SetConfigFile(path); err == nil {
+			t.Errorf("SetConfigFile(%q) succeeded, want an unsupported format error", path)
+		}
+	}
+}
+
+func TestLoadConfigOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := `matrix:
+  homeserver: "https://matrix.example.com"
+  roomid: "!dev:example.com"
+server:
+  admin_users: ["@alice:example.com", "@bob:example.com"]
+webhook:
+  commands:
+    status: "http://ci:3000/status"
+logging:
+  level: "debug"
+`
+	path := writeTestFile(t, dir, "config.yaml", base)
+	overlay := writeTestFile(t, dir, "config.prod.yaml", `matrix:
+  roomid: "!prod:example.com"
+server:
+  admin_users: ["@carol:example.com"]
+webhook:
+  commands:
+    deploy: "http://ci:3000/deploy"
+logging:
+  level: "warn"
+`)
+	t.Setenv("MATRIXSVC_LOGGING_LEVEL", "error")
+	if err := SetProfile("prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Matrix.Homeserver != "https://matrix.example.com" || cfg.Matrix.RoomID != "!prod:example.com" {
+		t.Errorf("matrix = %+v, want the base homeserver and the overlay room", cfg.Matrix)
+	}
+	// Maps are merged, lists replaced
+	if want := map[string]string{"status": "http://ci:3000/status", "deploy": "http://ci:3000/deploy"}; !reflect.DeepEqual(cfg.Webhook.Commands, want) {
+		t.Errorf("webhook.commands = %v, want %v", cfg.Webhook.Commands, want)
+	}
+	if want := []string{"@carol:example.com"}; !reflect.DeepEqual(cfg.Server.AdminUsers, want) {
+		t.Errorf("server.admin_users = %q, want %q", cfg.Server.AdminUsers, want)
+	}
+	// The environment overrides both files
+	if cfg.Logging.Level != "error" {
+		t.Errorf("logging.level = %q, want the environment value", cfg.Logging.Level)
+	}
+
+	// Generated credentials go to the overlay
+	cfg.Matrix.DeviceID = "PRODDEVICE"
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+	for file, want := range map[string]bool{path: false, overlay: true} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(data), "PRODDEVICE"); got != want {
+			t.Errorf("%s contains the device ID = %v, want %v", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestLoadConfigProfileSelection(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.yaml", "matrix:\n  roomid: \"!dev:example.com\"\n")
+	writeTestFile(t, dir, "config.staging.yaml", "matrix:\n  roomid: \"!staging:example.com\"\n")
+
+	tests := []struct {
+		name     string
+		profile  string
+		appEnv   string
+		wantRoom string
+		wantErr  string
+	}{
+		{name: "no profile", wantRoom: "!dev:example.com"},
+		{name: "APP_ENV", appEnv: "staging", wantRoom: "!staging:example.com"},
+		{name: "APP_ENV without overlay", appEnv: "prod", wantRoom: "!dev:example.com"},
+		{name: "profile beats APP_ENV", profile: "staging", appEnv: "prod", wantRoom: "!staging:example.com"},
+		{name: "profile without overlay", profile: "prod", wantErr: "failed to read overlay"},
+		{name: "invalid APP_ENV", appEnv: "../prod", wantErr: "invalid profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", tt.appEnv)
+			if tt.profile != "" {
+				if err := SetProfile(tt.profile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := loadTestConfig(t, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.Matrix.RoomID != tt.wantRoom {
+				t.Errorf("matrix.roomid = %q, want %q", cfg.Matrix.RoomID, tt.wantRoom)
+			}
+		})
+	}
+}
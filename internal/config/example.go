@@ -0,0 +1,239 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// The package source is embedded so that the example config takes its
+// comments from the struct definitions and never drifts from them
+//
+//go:embed *.go
+var sources embed.FS
+
+// WriteExample writes a YAML config with every setting, its default and its
+// documentation. Maps and lists of structs are empty, with a commented-out
+// entry listing their fields.
+func WriteExample(w io.Writer) error {
+	docs, err := parseDocs()
+	if err != nil {
+		return fmt.Errorf("failed to parse config documentation: %w", err)
+	}
+	defaults := viper.New()
+	setDefaults(defaults)
+
+	e := &exampleWriter{docs: docs, defaults: defaults}
+	e.printf("# matrix-microservice configuration with every setting and its default,\n")
+	e.printf("# generated by `matrix-microservice config init`. Any setting can also be\n")
+	e.printf("# set with an environment variable, e.g. %s_SERVER_PORT for server.port.\n", EnvPrefix)
+	e.section(reflect.TypeOf(Config{}), "", "")
+	if e.err != nil {
+		return e.err
+	}
+	_, err = w.Write(e.buf.Bytes())
+	return err
+}
+
+// typeDocs holds the doc comments of the config structs and their fields
+type typeDocs struct {
+	types  map[string]string // keyed by type name
+	fields map[string]string // keyed by "Type.Field"
+}
+
+// parseDocs collects the doc comments from the embedded source. A field
+// without a doc comment may have a line comment instead.
+func parseDocs() (*typeDocs, error) {
+	docs := &typeDocs{types: make(map[string]string), fields: make(map[string]string)}
+	entries, err := sources.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		src, err := sources.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(fset, entry.Name(), src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				structType, ok := typeSpec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				docs.types[typeSpec.Name.Name] = firstNonEmpty(typeSpec.Doc.Text(), gen.Doc.Text())
+				for _, field := range structType.Fields.List {
+					for _, name := range field.Names {
+						docs.fields[typeSpec.Name.Name+"."+name.Name] = firstNonEmpty(field.Doc.Text(), field.Comment.Text())
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
+
+type exampleWriter struct {
+	docs     *typeDocs
+	defaults *viper.Viper
+	buf      bytes.Buffer
+	err      error
+}
+
+func (e *exampleWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&e.buf, format, args...)
+}
+
+// comment writes a doc comment as YAML comment lines
+func (e *exampleWriter) comment(indent, doc string) {
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		if line == "" {
+			e.printf("%s#\n", indent)
+		} else {
+			e.printf("%s# %s\n", indent, line)
+		}
+	}
+}
+
+// section writes the settings of a struct. Top-level sections are separated
+// by blank lines.
+func (e *exampleWriter) section(t reflect.Type, prefix, indent string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+
+		doc := e.docs.fields[t.Name()+"."+field.Name]
+		if doc == "" && field.Type.Kind() == reflect.Struct {
+			// Type docs start with the type name, which means nothing in YAML
+			doc = strings.TrimPrefix(e.docs.types[field.Type.Name()], field.Type.Name()+" ")
+		}
+		if prefix == "" {
+			e.printf("\n")
+		}
+		if doc != "" {
+			e.comment(indent, doc)
+		}
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			e.printf("%s%s:\n", indent, name)
+			e.section(field.Type, key+".", indent+"  ")
+		case hasStructEntries(field.Type):
+			e.printf("%s%s: %s\n", indent, name, e.value(key, field.Type))
+			e.entryExample(field.Type, indent+"#   ")
+		default:
+			e.printf("%s%s: %s\n", indent, name, e.value(key, field.Type))
+		}
+	}
+}
+
+// hasStructEntries reports whether a map or list holds structs (or lists of
+// them), whose fields are shown in an example entry
+func hasStructEntries(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice:
+		return t.Elem().Kind() == reflect.Struct || hasStructEntries(t.Elem())
+	}
+	return false
+}
+
+// entryExample writes a commented-out entry of a map or list of structs
+func (e *exampleWriter) entryExample(t reflect.Type, indent string) {
+	switch t.Kind() {
+	case reflect.Map:
+		e.printf("%s<name>:\n", indent)
+		e.entryExample(t.Elem(), indent+"  ")
+	case reflect.Slice:
+		e.entryFields(t.Elem(), indent, "- ")
+	case reflect.Struct:
+		e.entryFields(t, indent, "")
+	}
+}
+
+// entryFields writes the fields of an example entry with their docs as line
+// comments. The first field of a list item gets the "- " marker.
+func (e *exampleWriter) entryFields(t reflect.Type, indent, marker string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		line := fmt.Sprintf("%s%s%s:", indent, marker, name)
+		if field.Type.Kind() != reflect.Struct {
+			line += " " + e.value("", field.Type)
+		}
+		if doc := e.docs.fields[t.Name()+"."+field.Name]; doc != "" {
+			line += "  # " + strings.Join(strings.Fields(doc), " ")
+		}
+		e.printf("%s\n", line)
+		if field.Type.Kind() == reflect.Struct {
+			e.entryFields(field.Type, indent+strings.Repeat(" ", len(marker))+"  ", "")
+		}
+		marker = strings.Repeat(" ", len(marker))
+	}
+}
+
+// value renders the default of a setting (the zero value if it has none) as
+// YAML. JSON is valid YAML and quotes strings safely.
+func (e *exampleWriter) value(key string, t reflect.Type) string {
+	var value interface{}
+	if key != "" {
+		value = e.defaults.Get(key)
+	}
+	if value == nil {
+		switch t.Kind() {
+		case reflect.Map:
+			return "{}"
+		case reflect.Slice:
+			return "[]"
+		}
+		value = reflect.Zero(t).Interface()
+	}
+
+	// Lists are written as flow sequences with the usual spacing
+	if list := reflect.ValueOf(value); list.Kind() == reflect.Slice {
+		items := make([]string, list.Len())
+		for i := range items {
+			items[i] = e.encode(key, list.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return e.encode(key, value)
+}
+
+func (e *exampleWriter) encode(key string, value interface{}) string {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out.String())
+}
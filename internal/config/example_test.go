@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteExample(t *testing.T) {
+	var example bytes.Buffer
+	if err := WriteExample(&example); err != nil {
+		t.Fatalf("WriteExample() error = %v", err)
+	}
+	for _, want := range []string{
+		"# Port the HTTP API listens on\n  port: 8080\n",
+		"# e.g. https://tools.example.com\n    allowed_origins: []\n",
+		"rooms: []\n#   - room_id: \"\"  # Room the settings apply to",
+	} {
+		if !strings.Contains(example.String(), want) {
+			t.Errorf("example does not contain %q", want)
+		}
+	}
+
+	// The example loads to the same config as the defaults alone
+	dir := t.TempDir()
+	cfg, err := loadTestConfig(t, writeTestFile(t, dir, "config.yaml", example.String()))
+	if err != nil {
+		t.Fatalf("LoadConfig() of the example error = %v", err)
+	}
+	defaults, err := loadTestConfig(t, writeTestFile(t, dir, "empty.yaml", "{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := settingsJSON(t, cfg), settingsJSON(t, defaults); got != want {
+		t.Errorf("example config =\n%s\nwant the defaults\n%s", got, want)
+	}
+}
+
+// settingsJSON encodes a config for comparison, with empty maps and lists
+// the same as unset ones
+func settingsJSON(t *testing.T, cfg *Config) string {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.NewReplacer(`:[]`, `:null`, `:{}`, `:null`).Replace(string(data))
+}
@@ -0,0 +1,382 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Prefixes of config values that are resolved from a secret backend, e.g.
+// "vault:secret/data/matrix#accesstoken" or
+// "sops:secrets.enc.yaml#matrix.accesstoken". Values prefixed with "age:"
+// hold the ciphertext itself.
+const (
+	vaultPrefix = "vault:"
+	sopsPrefix  = "sops:"
+	agePrefix   = "age:"
+)
+
+// SecretsConfig configures the backends secret references are resolved from
+type SecretsConfig struct {
+	Vault VaultConfig `mapstructure:"vault"`
+	SOPS  SOPSConfig  `mapstructure:"sops"`
+	Age   AgeConfig   `mapstructure:"age"`
+}
+
+type VaultConfig struct {
+	// Vault server, defaults to $VAULT_ADDR
+	Address string `mapstructure:"address"`
+	// Token to authenticate with, defaults to $VAULT_TOKEN, then the file
+	// at token_file (e.g. written by the Vault agent)
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+	// Enterprise namespace (optional)
+	Namespace string `mapstructure:"namespace"`
+	// Request timeout in seconds
+	Timeout int `mapstructure:"timeout"`
+}
+
+type SOPSConfig struct {
+	// sops executable used to decrypt files
+	Binary string `mapstructure:"binary"`
+}
+
+type AgeConfig struct {
+	// File with the age identities that decrypt "age:" values, defaults to
+	// $AGE_KEY_FILE
+	KeyFile string `mapstructure:"key_file"`
+}
+
+// IsSecretReference reports whether a config value refers to a secret
+// backend or is encrypted, instead of holding the value itself
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, vaultPrefix) || strings.HasPrefix(value, sopsPrefix) || strings.HasPrefix(value, agePrefix)
+}
+
+// secretResolver resolves secret references, fetching every Vault path and
+// decrypting every SOPS file once
+type secretResolver struct {
+	config     *SecretsConfig
+	documents  map[string]map[string]interface{}
+	identities []age.Identity // Read on the first "age:" value
+}
+
+// resolveSecrets replaces the secret references in every setting with the
+// secret values
+func resolveSecrets(cfg *Config) error {
+	resolver := &secretResolver{config: &cfg.Secrets, documents: make(map[string]map[string]interface{})}
+	return resolver.resolve(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func (r *secretResolver) resolve(v reflect.Value, setting string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !IsSecretReference(v.String()) {
+			return nil
+		}
+		var value string
+		var err error
+		if strings.HasPrefix(v.String(), agePrefix) {
+			value, err = r.decryptAge(strings.TrimPrefix(v.String(), agePrefix))
+		} else {
+			value, err = r.lookup(v.String())
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", setting, err)
+		}
+		v.SetString(value)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := field.Tag.Get("mapstructure")
+			// The backend settings themselves are never references
+			if name == "" || field.Type == reflect.TypeOf(SecretsConfig{}) {
+				continue
+			}
+			if err := r.resolve(v.Field(i), joinSetting(setting, name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.resolve(v.Index(i), fmt.Sprintf("%s[%d]", setting, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map elements cannot be modified in place
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := r.resolve(elem, joinSetting(setting, fmt.Sprint(iter.Key()))); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+func joinSetting(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// lookup resolves a single "<backend>:<location>#<key>" reference
+func (r *secretResolver) lookup(reference string) (string, error) {
+	backend, rest, _ := strings.Cut(reference, ":")
+	location, key, found := strings.Cut(rest, "#")
+	if !found || location == "" || key == "" {
+		return "", fmt.Errorf("invalid secret reference %q, expected %s:<location>#<key>", reference, backend)
+	}
+
+	cacheKey := backend + ":" + location
+	document, cached := r.documents[cacheKey]
+	if !cached {
+		var err error
+		if backend+":" == vaultPrefix {
+			document, err = r.readVault(location)
+		} else {
+			document, err = r.decryptSOPS(location)
+		}
+		if err != nil {
+			return "", err
+		}
+		r.documents[cacheKey] = document
+	}
+
+	value, err := lookupKey(document, key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cacheKey, err)
+	}
+	return value, nil
+}
+
+// lookupKey finds a dotted key in a decoded document. Secrets must be
+// strings or numbers.
+func lookupKey(document map[string]interface{}, key string) (string, error) {
+	var current interface{} = document
+	for _, part := range strings.Split(key, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+		if current, ok = object[part]; !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+	}
+	switch value := current.(type) {
+	case string:
+		return value, nil
+	case float64, bool:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("key %q is not a string", key)
+	}
+}
+
+// readVault reads a secret with the Vault HTTP API. For KV version 2 paths
+// (e.g. secret/data/matrix) the secret's fields are under data.data, for
+// other engines under data.
+func (r *secretResolver) readVault(path string) (map[string]interface{}, error) {
+	cfg := r.config.Vault
+	address := firstNonEmpty(cfg.Address, os.Getenv("VAULT_ADDR"))
+	if address == "" {
+		return nil, fmt.Errorf("vault:%s: secrets.vault.address or $VAULT_ADDR is required", path)
+	}
+	token := firstNonEmpty(cfg.Token, os.Getenv("VAULT_TOKEN"))
+	if token == "" && cfg.TokenFile != "" {
+		data, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets.vault.token_file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault:%s: secrets.vault.token, $VAULT_TOKEN or secrets.vault.token_file is required", path)
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	endpoint := strings.TrimSuffix(address, "/") + "/v1/" + (&url.URL{Path: strings.TrimPrefix(path, "/")}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault:%s: %w", path, err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cfg.Namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault:%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault:%s: Vault returned status %d", path, resp.StatusCode)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("vault:%s: invalid response: %w", path, err)
+	}
+	if inner, ok := secret.Data["data"].(map[string]interface{}); ok && secret.Data["metadata"] != nil {
+		return inner, nil
+	}
+	return secret.Data, nil
+}
+
+// decryptSOPS decrypts a SOPS-encrypted file with the sops executable, which
+// takes care of the key management (age, PGP or a cloud KMS)
+func (r *secretResolver) decryptSOPS(file string) (map[string]interface{}, error) {
+	binary := firstNonEmpty(r.config.SOPS.Binary, "sops")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, "--decrypt", "--output-type", "json", file)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sops:%s: failed to decrypt: %v: %s", file, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &document); err != nil {
+		return nil, fmt.Errorf("sops:%s: decrypted file is not a map: %w", file, err)
+	}
+	return document, nil
+}
+
+// decryptAge decrypts an age ciphertext, either ASCII-armored or the binary
+// format encoded as base64
+func (r *secretResolver) decryptAge(ciphertext string) (string, error) {
+	if r.identities == nil {
+		keyFile := firstNonEmpty(r.config.Age.KeyFile, os.Getenv("AGE_KEY_FILE"))
+		if keyFile == "" {
+			return "", fmt.Errorf("age: secrets.age.key_file or $AGE_KEY_FILE is required")
+		}
+		file, err := os.Open(keyFile)
+		if err != nil {
+			return "", fmt.Errorf("age: failed to read key file: %w", err)
+		}
+		defer file.Close()
+		if r.identities, err = age.ParseIdentities(file); err != nil {
+			return "", fmt.Errorf("age: invalid key file %s: %w", keyFile, err)
+		}
+	}
+
+	var encrypted io.Reader
+	ciphertext = strings.TrimSpace(ciphertext)
+	if strings.HasPrefix(ciphertext, armor.Header) {
+		encrypted = armor.NewReader(strings.NewReader(ciphertext))
+	} else {
+		// Line breaks are allowed, e.g. from base64 without -w0
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(ciphertext), ""))
+		if err != nil {
+			return "", fmt.Errorf("age: value is neither armored nor base64: %w", err)
+		}
+		encrypted = bytes.NewReader(data)
+	}
+
+	decrypted, err := age.Decrypt(encrypted, r.identities...)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	plaintext, err := io.ReadAll(decrypted)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	return strings.TrimSuffix(string(plaintext), "\n"), nil
+}
+
+// SecretValues returns the credentials held by the configuration, so that
+// they can be redacted from log output. Authorization headers are also
+// returned without their scheme (e.g. "Bearer").
+func (c *Config) SecretValues() []string {
+	values := []string{
+		c.Matrix.AccessToken,
+		c.Matrix.RecoveryKey,
+		c.Matrix.PickleKey,
+		c.Server.AdminToken,
+		c.Hooks.Alertmanager.Token,
+		c.Hooks.Grafana.Token,
+		c.Hooks.Discord.Token,
+		c.Hooks.PagerDuty.Secret,
+		c.Notify.Token,
+		c.Stream.Token,
+		c.Secrets.Vault.Token,
+		c.LLM.APIKey,
+		c.Email.Token,
+		c.Email.SMTP.Password,
+		c.Email.IMAP.Password,
+		c.Telegram.Token,
+		c.PagerDuty.APIToken,
+		c.Jira.Token,
+		c.HomeAssistant.Token,
+		c.Translate.APIKey,
+		c.Vision.APIKey,
+		c.Feedback.AuthToken,
+		c.Memory.EncryptionKey,
+		c.Archive.SecretKey,
+	}
+	// Postgres connection strings may hold a password, SQLite ones are paths
+	if c.Storage.Driver == "postgres" {
+		values = append(values, c.Storage.DSN)
+	}
+	for _, header := range c.Webhook.AuthTokens {
+		values = append(values, header)
+		if fields := strings.Fields(header); len(fields) == 2 {
+			values = append(values, fields[1])
+		}
+	}
+	for _, tenant := range c.Tenants {
+		for _, header := range tenant.Webhook.AuthTokens {
+			values = append(values, header)
+			if fields := strings.Fields(header); len(fields) == 2 {
+				values = append(values, fields[1])
+			}
+		}
+	}
+	for _, hook := range c.Hooks.Custom {
+		values = append(values, hook.Secret)
+	}
+	for _, target := range c.Push.Targets {
+		values = append(values, target.Token, target.AppToken, target.UserKey)
+	}
+	for _, key := range c.PagerDuty.Services {
+		values = append(values, key)
+	}
+	return values
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
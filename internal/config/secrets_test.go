@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestSecretValues(t *testing.T) {
+	cfg := &Config{
+		Matrix:  MatrixConfig{AccessToken: "syt_token"},
+		Archive: ArchiveConfig{AccessKey: "AKIAEXAMPLE", SecretKey: "archive-secret"},
+		Storage: StorageConfig{Driver: "postgres", DSN: "postgres://mule:pg-secret@db/mule"},
+		Webhook: WebhookConfig{AuthTokens: map[string]string{"deploy": "Bearer deploy-token"}},
+	}
+	values := make(map[string]bool)
+	for _, value := range cfg.SecretValues() {
+		values[value] = true
+	}
+	for _, want := range []string{"syt_token", "archive-secret", "postgres://mule:pg-secret@db/mule", "Bearer deploy-token", "deploy-token"} {
+		if !values[want] {
+			t.Errorf("SecretValues() lacks %q", want)
+		}
+	}
+	// Access keys identify, they do not authenticate
+	if values["AKIAEXAMPLE"] {
+		t.Error("SecretValues() has the archive access key")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/matrix":
+			w.Write([]byte(`{"data": {"data": {"accesstoken": "syt_vault_kv2", "port": 8448}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/matrix":
+			w.Write([]byte(`{"data": {"accesstoken": "syt_vault_kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+
+	// sops is replaced by a script that decrypts one file
+	dir := t.TempDir()
+	sops := filepath.Join(dir, "sops")
+	script := `#!/bin/sh
+case "$4" in
+*secrets.enc.yaml) echo '{"matrix": {"accesstoken": "syt_sops"}}' ;;
+*) echo "failed to read $4" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(sops, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "age.key")
+	if err := os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := SecretsConfig{
+		Vault: VaultConfig{Address: vault.URL, Token: "vault-token"},
+		SOPS:  SOPSConfig{Binary: sops},
+		Age:   AgeConfig{KeyFile: keyFile},
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"Plain value", "syt_plain", "syt_plain", ""},
+		{"Vault KV version 2", "vault:secret/data/matrix#accesstoken", "syt_vault_kv2", ""},
+		{"Vault KV version 1", "vault:kv/matrix#accesstoken", "syt_vault_kv1", ""},
+		{"Vault number", "vault:secret/data/matrix#port", "8448", ""},
+		{"Vault missing key", "vault:secret/data/matrix#password", "", `key "password" not found`},
+		{"Vault missing path", "vault:secret/data/other#accesstoken", "", "Vault returned status 404"},
+		{"Reference without key", "vault:secret/data/matrix", "", "invalid secret reference"},
+		{"SOPS", "sops:" + filepath.Join(dir, "secrets.enc.yaml") + "#matrix.accesstoken", "syt_sops", ""},
+		{"SOPS missing key", "sops:" + filepath.Join(dir, "secrets.enc.yaml") + "#matrix.password", "", `key "matrix.password" not found`},
+		{"SOPS failure", "sops:" + filepath.Join(dir, "other.enc.yaml") + "#matrix.accesstoken", "", "failed to decrypt"},
+		{"age armored", "age:" + encryptAge(t, identity.Recipient(), "syt_age", true), "syt_age", ""},
+		{"age base64", "age:" + encryptAge(t, identity.Recipient(), "syt_age", false), "syt_age", ""},
+		{"age other recipient", "age:" + encryptAge(t, other.Recipient(), "syt_age", true), "", "no identity matched"},
+		{"age garbage", "age:not a ciphertext", "", "neither armored nor base64"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Secrets: secrets, Matrix: MatrixConfig{AccessToken: tt.value}}
+		err := resolveSecrets(cfg)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "matrix.accesstoken: ") {
+				t.Errorf("%s: resolveSecrets() error = %v, want it to name matrix.accesstoken and contain %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: resolveSecrets() error = %v", tt.name, err)
+		} else if cfg.Matrix.AccessToken != tt.want {
+			t.Errorf("%s: resolved to %q, want %q", tt.name, cfg.Matrix.AccessToken, tt.want)
+		}
+	}
+}
+
+func TestResolveSecretsWithoutBackend(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("AGE_KEY_FILE", "")
+
+	tests := []struct {
+		secrets SecretsConfig
+		value   string
+		wantErr string
+	}{
+		{SecretsConfig{}, "vault:secret/data/matrix#accesstoken", "secrets.vault.address or $VAULT_ADDR is required"},
+		{SecretsConfig{Vault: VaultConfig{Address: "http://vault.invalid"}}, "vault:secret/data/matrix#accesstoken", "secrets.vault.token, $VAULT_TOKEN or secrets.vault.token_file is required"},
+		{SecretsConfig{}, "age:YWdl", "secrets.age.key_file or $AGE_KEY_FILE is required"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Secrets: tt.secrets, Matrix: MatrixConfig{AccessToken: tt.value}}
+		if err := resolveSecrets(cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("resolveSecrets(%q) error = %v, want it to contain %q", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+// encryptAge encrypts plaintext to recipient, ASCII-armored or as base64
+func encryptAge(t *testing.T, recipient age.Recipient, plaintext string, armored bool) string {
+	var buf bytes.Buffer
+	var out io.WriteCloser = nopCloser{&buf}
+	if armored {
+		out = armor.NewWriter(&buf)
+	}
+	w, err := age.Encrypt(out, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, plaintext)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if armored {
+		return buf.String()
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
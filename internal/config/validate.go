@@ -0,0 +1,1322 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/script"
+)
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validator collects problems so that all of them are reported at once
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the settings that can be checked without connecting to
+// anything: required Matrix fields, webhook URLs, webhook templates, jq
+// selectors and numeric limits. Templates of inbound hooks and commands are
+// checked when the server compiles them.
+func (c *Config) Validate() error {
+	v := &validator{}
+	v.server(&c.Server)
+	v.matrix(&c.Matrix)
+	v.webhook(&c.Webhook)
+	v.logging(&c.Logging)
+	v.rooms(c)
+	v.tenants(c)
+	v.notNegative("audit.retention_days", c.Audit.RetentionDays)
+	if c.Archive.Enabled {
+		v.archive(&c.Archive)
+	}
+	if c.Plugins.Dir != "" {
+		v.positive("plugins.timeout", c.Plugins.Timeout)
+	}
+	if len(c.WASM.Modules) > 0 {
+		v.wasm(&c.WASM)
+	}
+	if c.LLM.Enabled {
+		v.llm(&c.LLM)
+	}
+	if c.Ollama.Enabled {
+		v.ollama(&c.Ollama)
+	}
+	if c.Feeds.Enabled {
+		v.feeds(&c.Feeds)
+	}
+	v.schedule(c)
+	if c.Reminders.Enabled {
+		v.reminders(&c.Reminders)
+	}
+	v.email(&c.Email)
+	if c.Telegram.Enabled {
+		v.telegram(&c.Telegram)
+	}
+	if len(c.Push.Rules) > 0 {
+		v.push(&c.Push)
+	}
+	if len(c.PagerDuty.Services) > 0 || c.Hooks.PagerDuty.Enabled {
+		v.pagerDuty(&c.PagerDuty)
+	}
+	if c.Jira.Enabled {
+		v.jira(&c.Jira)
+	}
+	if c.HomeAssistant.Enabled {
+		v.homeAssistant(&c.HomeAssistant)
+	}
+	if c.Translate.Enabled {
+		v.translate(&c.Translate)
+	}
+	if c.Vision.Enabled {
+		v.vision(&c.Vision)
+	}
+	if c.Feedback.Enabled {
+		v.feedback(&c.Feedback)
+	}
+	if c.Pagination.Enabled {
+		v.pagination(&c.Pagination)
+	}
+	if c.WebhookProbe.RoomID != "" {
+		v.webhookProbe(&c.WebhookProbe)
+	}
+	if c.Memory.Enabled {
+		v.memory(&c.Memory)
+	}
+	v.permissions(&c.Permissions)
+	if len(c.Confirmations.Commands) > 0 {
+		v.confirmations(&c.Confirmations)
+	}
+	v.quietHours("quiet_hours", &c.QuietHours)
+	v.bridges(c.Bridges)
+	v.attachmentPolicy("attachments.inbound", &c.Attachments.Inbound)
+	v.attachmentPolicy("attachments.outbound", &c.Attachments.Outbound)
+	if c.LifecycleNotices.StartupTemplate != "" && !c.LifecycleNotices.Startup {
+		v.addf("lifecycle_notices.startup_template: needs lifecycle_notices.startup")
+	}
+	if c.LifecycleNotices.ShutdownTemplate != "" && !c.LifecycleNotices.Shutdown {
+		v.addf("lifecycle_notices.shutdown_template: needs lifecycle_notices.shutdown")
+	}
+	if c.I18n.Language != "" && !languageRegex.MatchString(c.I18n.Language) {
+		v.addf("i18n.language: %q is not a language code, e.g. en or pt-BR", c.I18n.Language)
+	}
+	v.storage(&c.Storage)
+	if c.EncryptionPolicy.Mode != "" {
+		v.encryptionPolicyMode("encryption_policy.mode", c.EncryptionPolicy.Mode)
+	}
+	if c.EncryptionPolicy.EnableEncryption && !c.Matrix.EnableEncryption {
+		v.addf("encryption_policy.enable_encryption: needs matrix.enable_encryption")
+	}
+	v.inboundTransforms(c.InboundTransforms)
+	v.notNegative("dedup.max_entries", c.Dedup.MaxEntries)
+	if c.Dedup.Events.Enabled {
+		v.positive("dedup.events.ttl", c.Dedup.Events.TTL)
+	}
+	if c.Dedup.Hooks.Enabled {
+		v.positive("dedup.hooks.ttl", c.Dedup.Hooks.TTL)
+	}
+	for _, subsystem := range sortedKeys(c.ErrorReplies.Templates) {
+		switch subsystem {
+		case "webhook", "llm", "ollama":
+		default:
+			v.addf("error_replies.templates.%s: is not one of webhook, llm or ollama", subsystem)
+		}
+	}
+	if c.Stream.Enabled && c.Stream.BufferSize < 1 {
+		v.addf("stream.buffer_size: must be at least 1, got %d", c.Stream.BufferSize)
+	}
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *validator) server(cfg *ServerConfig) {
+	v.port("server.port", cfg.Port)
+	v.notNegative("server.shutdown_timeout", cfg.ShutdownTimeout)
+	v.positive("server.media_max_size_mb", cfg.MediaMaxSizeMB)
+	v.positive("server.ready_max_sync_age", cfg.ReadyMaxSyncAge)
+	v.positive("server.max_body_size_kb", cfg.MaxBodySizeKB)
+	if cfg.RateLimit < 0 {
+		v.addf("server.rate_limit: must not be negative (0 disables rate limiting), got %v", cfg.RateLimit)
+	}
+	if cfg.RateLimit > 0 && cfg.RateLimitBurst < 1 {
+		v.addf("server.rate_limit_burst: must be at least 1 when server.rate_limit is set, got %d", cfg.RateLimitBurst)
+	}
+	if cfg.TrustProxyHeaders && len(cfg.TrustedProxies) == 0 {
+		v.addf("server.trusted_proxies: is required with server.trust_proxy_headers, list the proxies whose X-Forwarded-For is believed")
+	}
+	v.notNegative("server.cors.max_age", cfg.CORS.MaxAge)
+	for i, userID := range cfg.AdminUsers {
+		if !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+			v.addf("server.admin_users[%d]: %q is not a Matrix user ID, expected @localpart:server", i, userID)
+		}
+	}
+}
+
+func (v *validator) matrix(cfg *MatrixConfig) {
+	if cfg.Homeserver == "" {
+		v.addf("matrix.homeserver: is required, e.g. https://matrix.example.com")
+	} else {
+		v.url("matrix.homeserver", cfg.Homeserver)
+	}
+	if cfg.UserID == "" {
+		v.addf("matrix.userid: is required, e.g. @bot:example.com")
+	} else if !strings.HasPrefix(cfg.UserID, "@") || !strings.Contains(cfg.UserID, ":") {
+		v.addf("matrix.userid: %q is not a Matrix user ID, expected @localpart:server", cfg.UserID)
+	}
+	if cfg.AppService.Enabled {
+		v.appService(cfg)
+	} else if cfg.OIDC.Enabled {
+		v.oidc(&cfg.OIDC)
+	} else if cfg.AccessToken == "" || cfg.AccessToken == "your_access_token_here" {
+		v.addf("matrix.accesstoken: is required, log in as the bot user and copy its access token, or enable matrix.oidc")
+	}
+	if cfg.RoomID == "" {
+		v.addf("matrix.roomid: is required, e.g. !roomid:example.com (found under the room's advanced settings)")
+	} else if !strings.HasPrefix(cfg.RoomID, "!") {
+		v.addf("matrix.roomid: %q is not a room ID, expected !opaque:server (aliases starting with # are not supported)", cfg.RoomID)
+	}
+	v.notNegative("matrix.sync_timeout", cfg.SyncTimeout)
+	if alert := cfg.DecryptionAlert; alert.RoomID != "" {
+		if !strings.HasPrefix(alert.RoomID, "!") {
+			v.addf("matrix.decryption_alert.room_id: %q is not a room ID, expected !opaque:server", alert.RoomID)
+		}
+		v.positive("matrix.decryption_alert.threshold", alert.Threshold)
+		v.positive("matrix.decryption_alert.window", alert.Window)
+	}
+	v.notNegative("matrix.queue.workers", cfg.Queue.Workers)
+	if cfg.Queue.Workers > 0 {
+		v.positive("matrix.queue.size", cfg.Queue.Size)
+		switch cfg.Queue.Overflow {
+		case "block", "drop_newest", "drop_oldest":
+		default:
+			v.addf("matrix.queue.overflow: %q is not one of block, drop_newest or drop_oldest", cfg.Queue.Overflow)
+		}
+	}
+	if cfg.ReadMarker.Enabled {
+		v.notNegative("matrix.read_marker.grace_window", cfg.ReadMarker.GraceWindow)
+		v.positive("matrix.read_marker.interval", cfg.ReadMarker.Interval)
+	}
+	v.notNegative("matrix.watchdog.stale_after", cfg.Watchdog.StaleAfter)
+	if cfg.Watchdog.StaleAfter > 0 {
+		v.positive("matrix.watchdog.interval", cfg.Watchdog.Interval)
+		v.notNegative("matrix.watchdog.max_restarts", cfg.Watchdog.MaxRestarts)
+		if roomID := cfg.Watchdog.AlertRoomID; roomID != "" && !strings.HasPrefix(roomID, "!") {
+			v.addf("matrix.watchdog.alert_room_id: %q is not a room ID, expected !opaque:server", roomID)
+		}
+	}
+	rotation := cfg.KeyRotation
+	if rotation.MaxMessages < 0 || rotation.MaxMessages > 10000 {
+		v.addf("matrix.key_rotation.max_messages: must be between 0 and 10000, got %d", rotation.MaxMessages)
+	}
+	if rotation.MaxAge != 0 && rotation.MaxAge < 3600 {
+		v.addf("matrix.key_rotation.max_age: must be 0 or at least 3600 seconds, got %d", rotation.MaxAge)
+	}
+	v.notNegative("matrix.key_rotation.reshare_interval", rotation.ReshareInterval)
+}
+
+func (v *validator) webhook(cfg *WebhookConfig) {
+	if cfg.Default != "" {
+		v.url("webhook.default", cfg.Default)
+	}
+	for _, name := range sortedKeys(cfg.Commands) {
+		v.url("webhook.commands."+name, cfg.Commands[name])
+	}
+
+	v.webhookTemplate("webhook.template", cfg.Template)
+	// Templates of commands without a webhook are command lines, checked by
+	// the server
+	for _, name := range sortedKeys(cfg.CommandTemplates) {
+		if _, isWebhook := cfg.Commands[name]; isWebhook {
+			v.webhookTemplate("webhook.command_templates."+name, cfg.CommandTemplates[name])
+		}
+	}
+
+	if cfg.JQSelector != "" {
+		v.jq("webhook.jq_selector", cfg.JQSelector)
+	}
+	for _, name := range sortedKeys(cfg.CommandSelectors) {
+		v.jq("webhook.command_selectors."+name, cfg.CommandSelectors[name])
+	}
+	if cfg.DefaultResponsePages != (ResponsePagesConfig{}) {
+		v.responsePages("webhook.default_response_pages", &cfg.DefaultResponsePages)
+	}
+	paginated := make([]string, 0, len(cfg.ResponsePages))
+	for name := range cfg.ResponsePages {
+		paginated = append(paginated, name)
+	}
+	sort.Strings(paginated)
+	for _, name := range paginated {
+		pages := cfg.ResponsePages[name]
+		v.responsePages("webhook.response_pages."+name, &pages)
+	}
+
+	if cfg.DefaultAuth != "" {
+		if _, exists := cfg.AuthTokens[cfg.DefaultAuth]; !exists {
+			v.addf("webhook.default_auth: %q is not a key of webhook.auth_tokens", cfg.DefaultAuth)
+		}
+	}
+
+	v.positive("webhook.timeout", cfg.Timeout)
+	if cfg.PingURL != "" {
+		v.url("webhook.ping_url", cfg.PingURL)
+	}
+	v.notNegative("webhook.retry_attempts", cfg.RetryAttempts)
+	if cfg.RetryAttempts > 0 {
+		v.positive("webhook.retry_delay", cfg.RetryDelay)
+	}
+	if cfg.EnableCommands {
+		v.positive("webhook.session_timeout", cfg.SessionTimeout)
+	}
+	switch cfg.ExecMode {
+	case "", "shell", "argv":
+	default:
+		v.addf("webhook.exec_mode: %q is not one of shell or argv", cfg.ExecMode)
+	}
+	v.notNegative("webhook.command_queue_depth", cfg.CommandQueueDepth)
+	v.notNegative("webhook.max_sessions", cfg.MaxSessions)
+	v.egress(&cfg.Egress)
+	switch cfg.Transcript.Format {
+	case "", "markdown", "jsonl":
+	default:
+		v.addf("webhook.transcript.format: %q is not one of markdown or jsonl", cfg.Transcript.Format)
+	}
+	v.notNegative("webhook.transcript.max_entries", cfg.Transcript.MaxEntries)
+
+	v.webhookDev(&cfg.Dev)
+
+	v.script("webhook.route_script", cfg.RouteScript)
+	v.script("webhook.payload_script", cfg.PayloadScript)
+	v.script("webhook.reply_script", cfg.ReplyScript)
+	if cfg.RouteScript != "" || cfg.PayloadScript != "" || cfg.ReplyScript != "" {
+		v.positive("webhook.script_timeout", cfg.ScriptTimeout)
+	}
+	if cfg.Idempotency.Enabled {
+		v.positive("webhook.idempotency.ttl", cfg.Idempotency.TTL)
+	}
+	switch cfg.TemplateOptions.MissingKey {
+	case "", "default", "zero", "error":
+	default:
+		v.addf("webhook.template_options.missing_key: %q is not one of default, zero or error", cfg.TemplateOptions.MissingKey)
+	}
+	v.templateEscape("webhook.template_options.escape", cfg.TemplateOptions.Escape)
+	for _, name := range sortedKeys(cfg.TemplateOptions.CommandEscapes) {
+		v.templateEscape("webhook.template_options.command_escapes."+name, cfg.TemplateOptions.CommandEscapes[name])
+	}
+}
+
+func (v *validator) templateEscape(setting, value string) {
+	if value != "" && value != "none" && value != "json" {
+		v.addf("%s: %q is not one of none or json", setting, value)
+	}
+}
+
+func (v *validator) llm(cfg *LLMConfig) {
+	if cfg.BaseURL == "" {
+		v.addf("llm.base_url: is required when llm.enabled is set, e.g. https://api.openai.com/v1")
+	} else {
+		v.url("llm.base_url", cfg.BaseURL)
+	}
+	if cfg.Model == "" {
+		v.addf("llm.model: is required when llm.enabled is set")
+	}
+	if cfg.Temperature < 0 || cfg.Temperature > 2 {
+		v.addf("llm.temperature: must be between 0 and 2, got %g", cfg.Temperature)
+	}
+	if len(cfg.Commands) == 0 && !cfg.Default {
+		v.addf("llm: set llm.commands or llm.default, or the model answers no messages")
+	}
+	v.notNegative("llm.max_history", cfg.MaxHistory)
+	v.positive("llm.history_ttl", cfg.HistoryTTL)
+	v.positive("llm.timeout", cfg.Timeout)
+}
+
+func (v *validator) ollama(cfg *OllamaConfig) {
+	v.url("ollama.url", cfg.URL)
+	if len(cfg.Models) == 0 && cfg.DefaultModel == "" {
+		v.addf("ollama: set ollama.models or ollama.default_model, or no message is answered")
+	}
+	for _, command := range sortedKeys(cfg.Models) {
+		if cfg.Models[command] == "" {
+			v.addf("ollama.models.%s: is empty, expected a model name such as llama3.2", command)
+		}
+	}
+	v.notNegative("ollama.max_history", cfg.MaxHistory)
+	v.positive("ollama.edit_interval", cfg.EditInterval)
+	v.positive("ollama.timeout", cfg.Timeout)
+}
+
+func (v *validator) feeds(cfg *FeedsConfig) {
+	v.positive("feeds.interval", cfg.Interval)
+	v.notNegative("feeds.max_items", cfg.MaxItems)
+	for i, feed := range cfg.Subscriptions {
+		setting := fmt.Sprintf("feeds.subscriptions[%d]", i)
+		v.url(setting+".url", feed.URL)
+		if feed.RoomID != "" && !strings.HasPrefix(feed.RoomID, "!") {
+			v.addf("%s.room_id: %q is not a room ID, expected !opaque:server", setting, feed.RoomID)
+		}
+		v.notNegative(setting+".interval", feed.Interval)
+	}
+}
+
+// schedule checks the jobs; their cron expressions are checked by the
+// server, which parses them
+func (v *validator) schedule(c *Config) {
+	cfg := &c.Schedule
+	if len(cfg.Jobs) == 0 {
+		return
+	}
+	if cfg.TimeZone != "" {
+		if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+			v.addf("schedule.timezone: %q is not a time zone, e.g. Europe/Berlin: %v", cfg.TimeZone, err)
+		}
+	}
+	v.notNegative("schedule.catch_up", cfg.CatchUp)
+
+	seen := make(map[string]bool)
+	for i, job := range cfg.Jobs {
+		setting := fmt.Sprintf("schedule.jobs[%d]", i)
+		switch {
+		case job.Name == "":
+			v.addf("%s.name: is required", setting)
+		case seen[job.Name]:
+			v.addf("%s.name: %s is used by more than one job", setting, job.Name)
+		}
+		seen[job.Name] = true
+		if job.Cron == "" {
+			v.addf("%s.cron: is required, e.g. \"0 9 * * mon-fri\"", setting)
+		}
+		if job.RoomID != "" && !strings.HasPrefix(job.RoomID, "!") {
+			v.addf("%s.room_id: %q is not a room ID, expected !opaque:server", setting, job.RoomID)
+		}
+		if job.Message == "" {
+			v.addf("%s.message: is required", setting)
+		}
+		if job.Command != "" {
+			if _, exists := c.Webhook.Commands[job.Command]; !exists {
+				v.addf("%s.command: %q is not a key of webhook.commands", setting, job.Command)
+			}
+		}
+	}
+}
+
+func (v *validator) reminders(cfg *RemindersConfig) {
+	if cfg.TimeZone != "" {
+		if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+			v.addf("reminders.timezone: %q is not a time zone, e.g. Europe/Berlin: %v", cfg.TimeZone, err)
+		}
+	}
+	if _, err := time.Parse("15:04", cfg.DefaultTime); err != nil {
+		v.addf("reminders.default_time: %q is not a time of day, e.g. 09:00", cfg.DefaultTime)
+	}
+	v.notNegative("reminders.max_per_user", cfg.MaxPerUser)
+}
+
+func (v *validator) email(cfg *EmailConfig) {
+	if len(cfg.Templates) > 0 {
+		if cfg.SMTP.Host == "" {
+			v.addf("email.smtp.host: is required when email.templates are set")
+		}
+		if _, err := mail.ParseAddress(cfg.SMTP.From); err != nil {
+			v.addf("email.smtp.from: %q is not an email address: %v", cfg.SMTP.From, err)
+		}
+		v.port("email.smtp.port", cfg.SMTP.Port)
+		v.tlsMode("email.smtp.tls", cfg.SMTP.TLS)
+		v.positive("email.smtp.timeout", cfg.SMTP.Timeout)
+	}
+	names := make([]string, 0, len(cfg.Templates))
+	for name := range cfg.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tpl := cfg.Templates[name]
+		if len(tpl.To) == 0 {
+			v.addf("email.templates.%s.to: at least one recipient is required", name)
+		}
+		for _, to := range tpl.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				v.addf("email.templates.%s.to: %q is not an email address: %v", name, to, err)
+			}
+		}
+		if tpl.Body == "" {
+			v.addf("email.templates.%s.body: is required", name)
+		}
+	}
+
+	if !cfg.IMAP.Enabled {
+		return
+	}
+	if cfg.IMAP.Host == "" {
+		v.addf("email.imap.host: is required when email.imap.enabled is set")
+	}
+	v.port("email.imap.port", cfg.IMAP.Port)
+	v.tlsMode("email.imap.tls", cfg.IMAP.TLS)
+	if cfg.IMAP.Username == "" {
+		v.addf("email.imap.username: is required when email.imap.enabled is set")
+	}
+	if cfg.IMAP.Mailbox == "" {
+		v.addf("email.imap.mailbox: is required when email.imap.enabled is set")
+	}
+	v.positive("email.imap.interval", cfg.IMAP.Interval)
+	if cfg.IMAP.RoomID != "" && !strings.HasPrefix(cfg.IMAP.RoomID, "!") {
+		v.addf("email.imap.room_id: %q is not a room ID, expected !opaque:server", cfg.IMAP.RoomID)
+	}
+	if cfg.IMAP.Subject != "" {
+		if _, err := regexp.Compile(cfg.IMAP.Subject); err != nil {
+			v.addf("email.imap.subject: invalid regular expression: %v", err)
+		}
+	}
+	if cfg.IMAP.Template == "" {
+		v.addf("email.imap.template: is required when email.imap.enabled is set")
+	}
+}
+
+func (v *validator) archive(cfg *ArchiveConfig) {
+	v.url("archive.endpoint", cfg.Endpoint)
+	if cfg.Bucket == "" {
+		v.addf("archive.bucket: is required when archive.enabled is set")
+	}
+	if cfg.Region == "" {
+		v.addf("archive.region: is required, e.g. us-east-1")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		v.addf("archive.access_key, archive.secret_key: are required when archive.enabled is set")
+	}
+	v.positive("archive.batch_size", cfg.BatchSize)
+	v.positive("archive.flush_interval", cfg.FlushInterval)
+	v.notNegative("archive.retention_days", cfg.RetentionDays)
+	v.positive("archive.timeout", cfg.Timeout)
+}
+
+func (v *validator) telegram(cfg *TelegramConfig) {
+	if cfg.Token == "" {
+		v.addf("telegram.token: is required when telegram.enabled is set")
+	}
+	if cfg.ChatID == 0 {
+		v.addf("telegram.chat_id: is required when telegram.enabled is set")
+	}
+	v.url("telegram.api_url", cfg.APIURL)
+	v.positive("telegram.poll_timeout", cfg.PollTimeout)
+	users := make([]string, 0, len(cfg.Users))
+	for user := range cfg.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if userID := cfg.Users[user]; !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+			v.addf("telegram.users.%s: %q is not a Matrix user ID, expected @localpart:server", user, userID)
+		}
+	}
+}
+
+func (v *validator) push(cfg *PushConfig) {
+	v.positive("push.timeout", cfg.Timeout)
+	names := make([]string, 0, len(cfg.Targets))
+	for name := range cfg.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target := cfg.Targets[name]
+		setting := "push.targets." + name
+		switch target.Type {
+		case "ntfy":
+			if target.Topic == "" {
+				v.addf("%s.topic: is required for ntfy", setting)
+			}
+		case "pushover":
+			if target.AppToken == "" || target.UserKey == "" {
+				v.addf("%s: app_token and user_key are required for pushover", setting)
+			}
+		default:
+			v.addf("%s.type: %q is not one of ntfy or pushover", setting, target.Type)
+		}
+		if target.URL != "" {
+			v.url(setting+".url", target.URL)
+		}
+	}
+
+	for i, rule := range cfg.Rules {
+		setting := fmt.Sprintf("push.rules[%d]", i)
+		if rule.Name == "" {
+			v.addf("%s.name: is required", setting)
+		}
+		if len(rule.Targets) == 0 {
+			v.addf("%s.targets: at least one target is required", setting)
+		}
+		for _, target := range rule.Targets {
+			if _, exists := cfg.Targets[target]; !exists {
+				v.addf("%s.targets: %q is not defined in push.targets", setting, target)
+			}
+		}
+		if rule.Match != "" {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				v.addf("%s.match: invalid regular expression: %v", setting, err)
+			}
+		}
+		for j, userID := range rule.Mentions {
+			if !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+				v.addf("%s.mentions[%d]: %q is not a Matrix user ID, expected @localpart:server", setting, j, userID)
+			}
+		}
+		switch rule.Priority {
+		case "", "min", "low", "default", "high", "urgent":
+		default:
+			v.addf("%s.priority: %q is not one of min, low, default, high or urgent", setting, rule.Priority)
+		}
+	}
+}
+
+func (v *validator) pagerDuty(cfg *PagerDutyConfig) {
+	services := make([]string, 0, len(cfg.Services))
+	for service := range cfg.Services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		if cfg.Services[service] == "" {
+			v.addf("pagerduty.services.%s: integration key is empty", service)
+		}
+	}
+	switch cfg.Severity {
+	case "critical", "error", "warning", "info":
+	default:
+		v.addf("pagerduty.severity: %q is not one of critical, error, warning or info", cfg.Severity)
+	}
+	if cfg.APIToken != "" && !strings.Contains(cfg.From, "@") {
+		v.addf("pagerduty.from: the email of a PagerDuty user is required with pagerduty.api_token")
+	}
+	if cfg.AckReaction == "" || cfg.ResolveReaction == "" || cfg.AckReaction == cfg.ResolveReaction {
+		v.addf("pagerduty.ack_reaction and pagerduty.resolve_reaction: must be set and differ")
+	}
+	v.url("pagerduty.events_url", cfg.EventsURL)
+	v.url("pagerduty.api_url", cfg.APIURL)
+	v.positive("pagerduty.timeout", cfg.Timeout)
+}
+
+// Jira project keys, e.g. OPS
+var jiraProjectRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]+$`)
+
+func (v *validator) jira(cfg *JiraConfig) {
+	v.url("jira.url", cfg.URL)
+	if cfg.Token == "" {
+		v.addf("jira.token: is required when jira.enabled is set")
+	}
+	if !jiraProjectRegex.MatchString(cfg.Project) {
+		v.addf("jira.project: %q is not a project key, e.g. OPS", cfg.Project)
+	}
+	if cfg.IssueType == "" {
+		v.addf("jira.issue_type: is required when jira.enabled is set")
+	}
+	for i, project := range cfg.ExpandProjects {
+		if !jiraProjectRegex.MatchString(project) {
+			v.addf("jira.expand_projects[%d]: %q is not a project key, e.g. OPS", i, project)
+		}
+	}
+	v.positive("jira.max_expansions", cfg.MaxExpansions)
+	v.positive("jira.timeout", cfg.Timeout)
+}
+
+func (v *validator) homeAssistant(cfg *HomeAssistantConfig) {
+	v.url("homeassistant.url", cfg.URL)
+	if cfg.Token == "" {
+		v.addf("homeassistant.token: is required when homeassistant.enabled is set")
+	}
+	names := make([]string, 0, len(cfg.Commands))
+	for name := range cfg.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "state" {
+			v.addf("homeassistant.commands.state: name is reserved for /ha state")
+		}
+		if domain, service, found := strings.Cut(cfg.Commands[name].Service, "."); !found || domain == "" || service == "" {
+			v.addf("homeassistant.commands.%s.service: %q is not domain.service, e.g. light.turn_on", name, cfg.Commands[name].Service)
+		}
+	}
+	for i, watch := range cfg.Watch {
+		if (len(watch.Entities) == 0) == (watch.Event == "") {
+			v.addf("homeassistant.watch[%d]: set either entities or event", i)
+		}
+		if watch.Event != "" && len(watch.To) > 0 {
+			v.addf("homeassistant.watch[%d].to: only applies to entities", i)
+		}
+	}
+	v.positive("homeassistant.timeout", cfg.Timeout)
+}
+
+// Language codes such as de, EN-GB or pt-BR
+var languageRegex = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+func (v *validator) translate(cfg *TranslateConfig) {
+	switch strings.ToLower(cfg.Provider) {
+	case "deepl":
+		if cfg.APIKey == "" {
+			v.addf("translate.api_key: is required for DeepL")
+		}
+	case "libretranslate":
+		if cfg.URL == "" {
+			v.addf("translate.url: is required for LibreTranslate, e.g. https://libretranslate.com")
+		}
+	default:
+		v.addf("translate.provider: %q is not one of deepl or libretranslate", cfg.Provider)
+	}
+	if cfg.URL != "" {
+		v.url("translate.url", cfg.URL)
+	}
+	v.positive("translate.max_length", cfg.MaxLength)
+	v.positive("translate.timeout", cfg.Timeout)
+}
+
+func (v *validator) storage(cfg *StorageConfig) {
+	switch cfg.Driver {
+	case "sqlite", "postgres":
+	default:
+		v.addf("storage.driver: %q is not one of sqlite or postgres", cfg.Driver)
+	}
+	if cfg.DSN == "" {
+		v.addf("storage.dsn: is required, e.g. state.db")
+	}
+}
+
+func (v *validator) feedback(cfg *FeedbackConfig) {
+	v.url("feedback.url", cfg.URL)
+	for i, reaction := range cfg.Reactions {
+		if strings.TrimSpace(reaction) == "" {
+			v.addf("feedback.reactions[%d]: is empty", i)
+		}
+	}
+	v.positive("feedback.max_replies", cfg.MaxReplies)
+	v.positive("feedback.timeout", cfg.Timeout)
+}
+
+func (v *validator) pagination(cfg *PaginationConfig) {
+	if cfg.PageSize < 100 {
+		v.addf("pagination.page_size: must be at least 100, got %d", cfg.PageSize)
+	}
+	v.positive("pagination.max_pages", cfg.MaxPages)
+	if strings.TrimSpace(cfg.Reaction) == "" {
+		v.addf("pagination.reaction: is required, e.g. \"▶ more\"")
+	}
+	v.positive("pagination.max_replies", cfg.MaxReplies)
+}
+
+func (v *validator) confirmations(cfg *ConfirmationsConfig) {
+	for i, pattern := range cfg.Commands {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.addf("confirmations.commands[%d]: invalid pattern %q: %v", i, pattern, err)
+		}
+	}
+	v.positive("confirmations.timeout", cfg.Timeout)
+	if strings.TrimSpace(cfg.Reaction) == "" {
+		v.addf("confirmations.reaction: is required, e.g. \"✅\"")
+	}
+	if len(cfg.Replies) == 0 {
+		v.addf("confirmations.replies: at least one is required, e.g. yes")
+	}
+	for i, reply := range cfg.Replies {
+		if strings.TrimSpace(reply) == "" {
+			v.addf("confirmations.replies[%d]: is empty", i)
+		}
+	}
+	switch cfg.ConfirmBy {
+	case "sender", "permitted", "admins":
+	default:
+		v.addf("confirmations.confirm_by: %q is not one of sender, permitted or admins", cfg.ConfirmBy)
+	}
+}
+
+// quietDays are the days of quiet_hours.days
+var quietDays = map[string]bool{"mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true, "sun": true}
+
+func (v *validator) quietHours(setting string, cfg *QuietHoursConfig) {
+	for i, r := range cfg.Ranges {
+		start, end, found := strings.Cut(r, "-")
+		startTime, startErr := time.Parse("15:04", strings.TrimSpace(start))
+		endTime, endErr := time.Parse("15:04", strings.TrimSpace(end))
+		switch {
+		case !found || startErr != nil || endErr != nil:
+			v.addf("%s.ranges[%d]: %q is not a range of times of day, e.g. 22:00-07:00", setting, i, r)
+		case startTime.Equal(endTime):
+			v.addf("%s.ranges[%d]: %q starts and ends at the same time", setting, i, r)
+		}
+	}
+	for i, day := range cfg.Days {
+		if !quietDays[strings.ToLower(day)] {
+			v.addf("%s.days[%d]: %q is not one of mon, tue, wed, thu, fri, sat or sun", setting, i, day)
+		}
+	}
+	if cfg.TimeZone != "" {
+		if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+			v.addf("%s.timezone: %q is not a time zone, e.g. Europe/Berlin: %v", setting, cfg.TimeZone, err)
+		}
+	}
+	for i, pattern := range cfg.Critical {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.addf("%s.critical[%d]: invalid pattern %q: %v", setting, i, pattern, err)
+		}
+	}
+}
+
+func (v *validator) bridges(bridges []BridgeConfig) {
+	names := make(map[string]bool)
+	for i, bridge := range bridges {
+		setting := fmt.Sprintf("bridges[%d]", i)
+		switch {
+		case bridge.Name == "":
+			v.addf("%s.name: is required, e.g. telegram", setting)
+		case !tenantNameRegex.MatchString(bridge.Name):
+			v.addf("%s.name: %q may only contain letters, digits, - and _", setting, bridge.Name)
+		case names[bridge.Name]:
+			v.addf("%s.name: %s is used by more than one bridge", setting, bridge.Name)
+		}
+		names[bridge.Name] = true
+		if len(bridge.Users) == 0 {
+			v.addf("%s.users: at least one bridge bot is required, e.g. @telegrambot:example.com", setting)
+		}
+		for j, pattern := range bridge.Users {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.addf("%s.users[%d]: invalid pattern %q: %v", setting, j, pattern, err)
+			}
+		}
+		if bridge.Prefix == "" && !bridge.PerMessageProfile {
+			v.addf("%s: prefix or per_message_profile is required to tell who sent a relayed message", setting)
+		}
+		if bridge.Prefix != "" {
+			if re, err := regexp.Compile(bridge.Prefix); err != nil {
+				v.addf("%s.prefix: invalid regular expression: %v", setting, err)
+			} else if re.SubexpIndex("name") < 0 {
+				v.addf("%s.prefix: has no group named name, e.g. \"^<(?P<name>[^>]+)> \"", setting)
+			}
+		}
+		if bridge.SenderFormat != "" && (!strings.HasPrefix(bridge.SenderFormat, "@") || !strings.Contains(bridge.SenderFormat, ":") || !strings.Contains(bridge.SenderFormat, "{name}")) {
+			v.addf("%s.sender_format: %q is not a user ID with {name}, e.g. %s", setting, bridge.SenderFormat, DefaultBridgeSenderFormat)
+		}
+	}
+}
+
+func (v *validator) wasm(cfg *WASMConfig) {
+	v.positive("wasm.timeout", cfg.Timeout)
+	v.positive("wasm.max_memory_mb", cfg.MaxMemoryMB)
+	if cfg.MaxMemoryMB > 4096 {
+		v.addf("wasm.max_memory_mb: must be at most 4096, the most a module can address, got %d", cfg.MaxMemoryMB)
+	}
+	names := make(map[string]bool)
+	for i, module := range cfg.Modules {
+		setting := fmt.Sprintf("wasm.modules[%d]", i)
+		switch {
+		case module.Name == "":
+			v.addf("%s.name: is required", setting)
+		case names[module.Name]:
+			v.addf("%s.name: %s is used by more than one module", setting, module.Name)
+		}
+		names[module.Name] = true
+		if module.Path == "" {
+			v.addf("%s.path: is required, e.g. /etc/matrix/transforms/redact.wasm", setting)
+		}
+		for j, pattern := range module.Rooms {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.addf("%s.rooms[%d]: invalid pattern %q: %v", setting, j, pattern, err)
+			}
+		}
+		for j, pattern := range module.Commands {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.addf("%s.commands[%d]: invalid pattern %q: %v", setting, j, pattern, err)
+			}
+		}
+	}
+}
+
+func (v *validator) attachmentPolicy(setting string, cfg *AttachmentPolicy) {
+	v.notNegative(setting+".max_size_mb", cfg.MaxSizeMB)
+	for i, pattern := range cfg.MimeTypes {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+			v.addf("%s.mime_types[%d]: %q is not a MIME type pattern, e.g. image/* or application/pdf", setting, i, pattern)
+		}
+	}
+}
+
+func (v *validator) egress(cfg *EgressConfig) {
+	for i, entry := range cfg.AllowedCIDRs {
+		var err error
+		if strings.Contains(entry, "/") {
+			_, err = netip.ParsePrefix(entry)
+		} else {
+			_, err = netip.ParseAddr(entry)
+		}
+		if err != nil {
+			v.addf("webhook.egress.allowed_cidrs[%d]: %q is not a CIDR or address", i, entry)
+		}
+	}
+	for i, host := range cfg.AllowedHosts {
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.ContainsAny(name, "*/: ") {
+			v.addf("webhook.egress.allowed_hosts[%d]: %q is not a hostname, expected e.g. hooks.example.com or *.example.com", i, host)
+		}
+	}
+}
+
+func (v *validator) webhookProbe(cfg *WebhookProbeConfig) {
+	if !strings.HasPrefix(cfg.RoomID, "!") {
+		v.addf("webhook_probe.room_id: %q is not a room ID, expected !opaque:server", cfg.RoomID)
+	}
+	v.positive("webhook_probe.timeout", cfg.Timeout)
+}
+
+func (v *validator) oidc(cfg *OIDCConfig) {
+	if cfg.Issuer != "" {
+		v.url("matrix.oidc.issuer", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		if cfg.ClientName == "" {
+			v.addf("matrix.oidc.client_name: is required to register the client")
+		}
+		if cfg.ClientURI == "" {
+			v.addf("matrix.oidc.client_uri: is required to register the client")
+		} else {
+			v.url("matrix.oidc.client_uri", cfg.ClientURI)
+		}
+	}
+	if cfg.TokenFile == "" {
+		v.addf("matrix.oidc.token_file: is required")
+	}
+}
+
+// localpartRegex matches the localparts Matrix allows for new users
+var localpartRegex = regexp.MustCompile(`^[a-z0-9._=/+-]+$`)
+
+func (v *validator) appService(cfg *MatrixConfig) {
+	if cfg.AppService.ASToken == "" {
+		v.addf("matrix.appservice.as_token: is required, copy it from the registration file")
+	}
+	if cfg.AppService.HSToken == "" {
+		v.addf("matrix.appservice.hs_token: is required, copy it from the registration file")
+	}
+	if cfg.OIDC.Enabled {
+		v.addf("matrix.oidc.enabled: cannot be used with matrix.appservice, the bot authenticates with as_token")
+	}
+	if cfg.EnableEncryption {
+		v.addf("matrix.enable_encryption: is not supported with matrix.appservice")
+	}
+	names := make([]string, 0, len(cfg.AppService.Users))
+	for name := range cfg.AppService.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if localpart := cfg.AppService.Users[name]; !localpartRegex.MatchString(localpart) {
+			v.addf("matrix.appservice.users.%s: %q is not a localpart, expected lowercase letters, digits and ._=/+-", name, localpart)
+		}
+	}
+}
+
+func (v *validator) permissions(cfg *PermissionsConfig) {
+	names := make([]string, 0, len(cfg.Groups))
+	for name := range cfg.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, user := range cfg.Groups[name] {
+			if _, err := path.Match(user, ""); err != nil {
+				v.addf("permissions.groups.%s: invalid pattern %q: %v", name, user, err)
+			}
+		}
+	}
+	for i, rule := range cfg.Rules {
+		if len(rule.Users) == 0 {
+			v.addf("permissions.rules[%d].users: is required", i)
+		}
+		if len(rule.Commands) == 0 {
+			v.addf("permissions.rules[%d].commands: is required", i)
+		}
+		for _, user := range rule.Users {
+			if group, ok := strings.CutPrefix(user, PermissionGroupPrefix); ok {
+				if _, exists := cfg.Groups[strings.ToLower(group)]; !exists {
+					v.addf("permissions.rules[%d].users: group %q is not defined in permissions.groups", i, group)
+				}
+			} else if _, err := path.Match(user, ""); err != nil {
+				v.addf("permissions.rules[%d].users: invalid pattern %q: %v", i, user, err)
+			}
+		}
+		for _, command := range rule.Commands {
+			if _, err := path.Match(command, ""); err != nil {
+				v.addf("permissions.rules[%d].commands: invalid pattern %q: %v", i, command, err)
+			}
+		}
+	}
+}
+
+func (v *validator) memory(cfg *MemoryConfig) {
+	switch cfg.Scope {
+	case MemoryScopeRoomUser, MemoryScopeUser, MemoryScopeRoom:
+	default:
+		v.addf("memory.scope: %q is not one of room_user, user or room", cfg.Scope)
+	}
+	v.positive("memory.max_keys", cfg.MaxKeys)
+	v.positive("memory.max_value_length", cfg.MaxValueLength)
+}
+
+func (v *validator) vision(cfg *VisionConfig) {
+	switch strings.ToLower(cfg.API) {
+	case "openai":
+		if cfg.Model == "" {
+			v.addf("vision.model: is required for the openai API")
+		}
+	case "multipart":
+		if cfg.JQSelector != "" {
+			v.jq("vision.jq_selector", cfg.JQSelector)
+		}
+	default:
+		v.addf("vision.api: %q is not one of openai or multipart", cfg.API)
+	}
+	v.url("vision.url", cfg.URL)
+	v.positive("vision.max_size_mb", cfg.MaxSizeMB)
+	v.positive("vision.timeout", cfg.Timeout)
+}
+
+func (v *validator) rooms(c *Config) {
+	seen := make(map[string]bool)
+	for i, room := range c.Rooms {
+		setting := fmt.Sprintf("rooms[%d]", i)
+		if room.RoomID == "" {
+			v.addf("%s.room_id: is required, e.g. !roomid:example.com", setting)
+		} else if !strings.HasPrefix(room.RoomID, "!") {
+			v.addf("%s.room_id: %q is not a room ID, expected !opaque:server", setting, room.RoomID)
+		} else if seen[room.RoomID] {
+			v.addf("%s.room_id: %s is listed more than once", setting, room.RoomID)
+		}
+		seen[room.RoomID] = true
+
+		if room.Webhook.Default != "" {
+			v.url(setting+".webhook.default", room.Webhook.Default)
+		}
+		if room.Webhook.Template != "" {
+			v.webhookTemplate(setting+".webhook.template", room.Webhook.Template)
+		}
+		if room.Webhook.JQSelector != "" {
+			v.jq(setting+".webhook.jq_selector", room.Webhook.JQSelector)
+		}
+		// Rooms of a tenant use the webhooks of the tenant
+		webhook, webhookSetting := c.WebhookFor(room.RoomID), "webhook"
+		if tenant := c.TenantOf(room.RoomID); tenant != nil {
+			webhookSetting = fmt.Sprintf("tenants.%s.webhook", tenant.Name)
+		}
+		if room.Webhook.DefaultAuth != "" {
+			if _, exists := webhook.AuthTokens[room.Webhook.DefaultAuth]; !exists {
+				v.addf("%s.webhook.default_auth: %q is not a key of %s.auth_tokens", setting, room.Webhook.DefaultAuth, webhookSetting)
+			}
+		}
+
+		for _, command := range room.Commands {
+			_, isWebhook := webhook.Commands[command]
+			_, hasTemplate := webhook.CommandTemplates[command]
+			if !isWebhook && !hasTemplate {
+				v.addf("%s.commands: %q is not defined in %s.commands or %s.command_templates", setting, command, webhookSetting, webhookSetting)
+			}
+		}
+		for j, userID := range room.AllowedUsers {
+			if !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+				v.addf("%s.allowed_users[%d]: %q is not a Matrix user ID, expected @localpart:server", setting, j, userID)
+			}
+		}
+		if room.QuietHours != nil {
+			v.quietHours(setting+".quiet_hours", room.QuietHours)
+		}
+		if room.RequireEncryption && !c.Matrix.EnableEncryption {
+			v.addf("%s.require_encryption: needs matrix.enable_encryption", setting)
+		}
+		if room.AutoTranslate != "" {
+			if !languageRegex.MatchString(room.AutoTranslate) {
+				v.addf("%s.auto_translate: %q is not a language code, e.g. en or pt-BR", setting, room.AutoTranslate)
+			}
+			if !c.Translate.Enabled {
+				v.addf("%s.auto_translate: needs translate.enabled", setting)
+			}
+		}
+		if room.Language != "" && !languageRegex.MatchString(room.Language) {
+			v.addf("%s.language: %q is not a language code, e.g. en or pt-BR", setting, room.Language)
+		}
+		if room.EncryptionPolicy != "" {
+			v.encryptionPolicyMode(setting+".encryption_policy", room.EncryptionPolicy)
+		}
+	}
+}
+
+func (v *validator) webhookDev(cfg *WebhookDevConfig) {
+	commands := make([]string, 0, len(cfg.MockResponses))
+	for name := range cfg.MockResponses {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	for _, name := range commands {
+		v.mockResponse("webhook.dev.mock_responses."+name, cfg.MockResponses[name])
+	}
+	v.mockResponse("webhook.dev.default_mock_response", cfg.DefaultMockResponse)
+}
+
+func (v *validator) mockResponse(setting string, cfg MockResponseConfig) {
+	if cfg.Status != 0 && (cfg.Status < 100 || cfg.Status > 599) {
+		v.addf("%s.status: %d is not an HTTP status", setting, cfg.Status)
+	}
+	if _, err := template.New(setting).Funcs(payloadTemplateFuncs).Parse(cfg.Body); err != nil {
+		v.addf("%s.body: invalid template: %v", setting, err)
+	}
+}
+
+func (v *validator) responsePages(setting string, cfg *ResponsePagesConfig) {
+	if cfg.Items == "" {
+		v.addf("%s.items: is required", setting)
+	} else {
+		v.jq(setting+".items", cfg.Items)
+	}
+	if (cfg.Next == "") == (cfg.Cursor == "") {
+		v.addf("%s: exactly one of next or cursor must be set", setting)
+	}
+	if cfg.Next != "" {
+		v.jq(setting+".next", cfg.Next)
+	}
+	if cfg.Cursor != "" {
+		v.jq(setting+".cursor", cfg.Cursor)
+	}
+	v.notNegative(setting+".max_pages", cfg.MaxPages)
+}
+
+func (v *validator) inboundTransforms(rules []TransformRule) {
+	for i, rule := range rules {
+		setting := fmt.Sprintf("inbound_transforms[%d]", i)
+		set := 0
+		for _, value := range []string{rule.Regex, rule.StripQuotes, rule.Normalize, rule.Drop} {
+			if value != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			v.addf("%s: exactly one of regex, strip_quotes, normalize or drop must be set", setting)
+		}
+		for _, pattern := range []struct{ key, value string }{{"regex", rule.Regex}, {"drop", rule.Drop}} {
+			if pattern.value == "" {
+				continue
+			}
+			if _, err := regexp.Compile(pattern.value); err != nil {
+				v.addf("%s.%s: invalid regular expression: %v", setting, pattern.key, err)
+			}
+		}
+		switch rule.StripQuotes {
+		case "", "fallback", "all":
+		default:
+			v.addf("%s.strip_quotes: %q is not one of fallback or all", setting, rule.StripQuotes)
+		}
+		switch rule.Normalize {
+		case "", "NFC", "NFD", "NFKC", "NFKD":
+		default:
+			v.addf("%s.normalize: %q is not one of NFC, NFD, NFKC or NFKD", setting, rule.Normalize)
+		}
+		for j, roomID := range rule.Rooms {
+			if !strings.HasPrefix(roomID, "!") {
+				v.addf("%s.rooms[%d]: %q is not a room ID, expected !opaque:server", setting, j, roomID)
+			}
+		}
+	}
+}
+
+func (v *validator) encryptionPolicyMode(setting, mode string) {
+	switch mode {
+	case "off", "warn", "refuse":
+	default:
+		v.addf("%s: %q is not one of off, warn or refuse", setting, mode)
+	}
+}
+
+var tenantNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (v *validator) tenants(c *Config) {
+	listened := map[string]bool{c.Matrix.RoomID: true}
+	for _, room := range c.Rooms {
+		listened[room.RoomID] = true
+	}
+	names := make(map[string]bool)
+	tenantOf := make(map[string]string)
+	for i, tenant := range c.Tenants {
+		setting := fmt.Sprintf("tenants[%d]", i)
+		if tenant.Name == "" {
+			v.addf("%s.name: is required, e.g. ops", setting)
+		} else if !tenantNameRegex.MatchString(tenant.Name) {
+			v.addf("%s.name: %q may only contain letters, digits, - and _", setting, tenant.Name)
+		} else if names[tenant.Name] {
+			v.addf("%s.name: %s is used by more than one tenant", setting, tenant.Name)
+		}
+		names[tenant.Name] = true
+
+		if len(tenant.Rooms) == 0 {
+			v.addf("%s.rooms: at least one room is required", setting)
+		}
+		for j, roomID := range tenant.Rooms {
+			if other, exists := tenantOf[roomID]; exists {
+				v.addf("%s.rooms[%d]: %s already belongs to tenant %s", setting, j, roomID, other)
+			} else if !listened[roomID] {
+				v.addf("%s.rooms[%d]: %s is neither matrix.roomid nor listed in rooms", setting, j, roomID)
+			}
+			tenantOf[roomID] = tenant.Name
+		}
+
+		webhook := &tenant.Webhook
+		if webhook.Default != "" {
+			v.url(setting+".webhook.default", webhook.Default)
+		}
+		for _, name := range sortedKeys(webhook.Commands) {
+			v.url(setting+".webhook.commands."+name, webhook.Commands[name])
+		}
+		v.webhookTemplate(setting+".webhook.template", webhook.Template)
+		for _, name := range sortedKeys(webhook.CommandTemplates) {
+			if _, isWebhook := webhook.Commands[name]; isWebhook {
+				v.webhookTemplate(setting+".webhook.command_templates."+name, webhook.CommandTemplates[name])
+			}
+		}
+		if webhook.JQSelector != "" {
+			v.jq(setting+".webhook.jq_selector", webhook.JQSelector)
+		}
+		for _, name := range sortedKeys(webhook.CommandSelectors) {
+			v.jq(setting+".webhook.command_selectors."+name, webhook.CommandSelectors[name])
+		}
+		if webhook.DefaultAuth != "" {
+			if _, exists := webhook.AuthTokens[webhook.DefaultAuth]; !exists {
+				v.addf("%s.webhook.default_auth: %q is not a key of %s.webhook.auth_tokens", setting, webhook.DefaultAuth, setting)
+			}
+		}
+
+		if tenant.RateLimit < 0 {
+			v.addf("%s.rate_limit: must not be negative (0 disables rate limiting), got %v", setting, tenant.RateLimit)
+		}
+		v.notNegative(setting+".rate_limit_burst", tenant.RateLimitBurst)
+		if tenant.QuietHours != nil {
+			v.quietHours(setting+".quiet_hours", tenant.QuietHours)
+		}
+	}
+}
+
+func (v *validator) logging(cfg *LoggingConfig) {
+	switch strings.ToLower(cfg.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		v.addf("logging.level: %q is not one of debug, info, warn or error", cfg.Level)
+	}
+	switch strings.ToLower(cfg.Target) {
+	case "", "syslog", "journald":
+	default:
+		v.addf("logging.target: %q is not one of syslog or journald", cfg.Target)
+	}
+	if cfg.SyslogAddress != "" {
+		if network, address, found := strings.Cut(cfg.SyslogAddress, "://"); !found || network == "" || address == "" {
+			v.addf("logging.syslog_address: %q is not of the form network://host:port", cfg.SyslogAddress)
+		}
+	}
+	v.notNegative("logging.max_size", cfg.MaxSize)
+	v.notNegative("logging.rotate_hours", cfg.RotateHours)
+	if cfg.RotateHours > 0 && cfg.MaxSize == 0 {
+		v.addf("logging.rotate_hours: needs logging.max_size, which enables rotation")
+	}
+	v.notNegative("logging.max_age", cfg.MaxAge)
+	v.notNegative("logging.max_backups", cfg.MaxBackups)
+	v.notNegative("logging.sample_initial", cfg.SampleInitial)
+	v.notNegative("logging.buffer_size", cfg.BufferSize)
+	if cfg.SampleInitial > 0 {
+		v.positive("logging.sample_thereafter", cfg.SampleThereafter)
+	}
+}
+
+// url checks that an http(s) URL is absolute
+func (v *validator) url(setting, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.addf("%s: %q is not a valid URL: %v", setting, value, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf("%s: %q must be an absolute http:// or https:// URL", setting, value)
+	}
+}
+
+// payloadTemplateFuncs stand in for the helpers of payload templates, whose
+// names are all parsing needs. The server renders every template with the
+// real ones.
+var payloadTemplateFuncs = template.FuncMap{"json": fmt.Sprint, "jsonescape": fmt.Sprint}
+
+func (v *validator) webhookTemplate(setting, value string) {
+	if _, err := template.New(setting).Funcs(payloadTemplateFuncs).Parse(value); err != nil {
+		v.addf("%s: invalid template: %v (the message is available as {{.MESSAGE}})", setting, err)
+	}
+}
+
+func (v *validator) jq(setting, value string) {
+	query, err := gojq.Parse(value)
+	if err == nil {
+		_, err = gojq.Compile(query)
+	}
+	if err != nil {
+		v.addf("%s: invalid jq selector %q: %v", setting, value, err)
+	}
+}
+
+func (v *validator) script(setting, value string) {
+	if _, err := script.Compile(setting, value, 0); err != nil {
+		v.addf("%s: invalid Lua script: %v", setting, err)
+	}
+}
+
+func (v *validator) port(setting string, value int) {
+	if value < 1 || value > 65535 {
+		v.addf("%s: must be between 1 and 65535, got %d", setting, value)
+	}
+}
+
+func (v *validator) tlsMode(setting, value string) {
+	switch value {
+	case "tls", "starttls", "none":
+	default:
+		v.addf("%s: must be tls, starttls or none, got %q", setting, value)
+	}
+}
+
+func (v *validator) positive(setting string, value int) {
+	if value < 1 {
+		v.addf("%s: must be at least 1, got %d", setting, value)
+	}
+}
+
+func (v *validator) notNegative(setting string, value int) {
+	if value < 0 {
+		v.addf("%s: must not be negative, got %d", setting, value)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
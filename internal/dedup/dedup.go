@@ -0,0 +1,236 @@
+// Package dedup remembers the keys of messages and actions already handled,
+// such as Matrix events, webhook dispatches and hook deliveries, so that
+// duplicates delivered again are skipped. Keys are kept in memory and, with
+// a storage, across restarts.
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// Interval between deletions of expired keys from the storage
+const pruneInterval = time.Hour
+
+// Stats are the lookups of a scope since startup
+type Stats struct {
+	Scope     string
+	Hits      uint64 // Duplicates found
+	Misses    uint64 // Keys not seen before
+	Evictions uint64 // Keys forgotten by memory before their TTL, because of max_entries
+	Entries   int    // Keys in memory
+}
+
+// Deduplicator keeps the scopes of keys, sharing a storage
+type Deduplicator struct {
+	store      storage.Store // nil to keep keys in memory only
+	maxEntries int
+	logger     *logger.Logger
+	mutex      sync.Mutex
+	scopes     map[string]*Scope
+}
+
+// New returns a deduplicator keeping the keys of each scope in store, which
+// may be nil, and at most maxEntries keys of each scope in memory (0 =
+// unlimited). Keys forgotten by memory are still found in the store.
+func New(store storage.Store, maxEntries int, log *logger.Logger) *Deduplicator {
+	return &Deduplicator{store: store, maxEntries: maxEntries, logger: log, scopes: make(map[string]*Scope)}
+}
+
+// Scope returns the scope of the name, creating it with the storage keys
+// prefixed by prefix. The TTL of an existing scope is updated.
+func (d *Deduplicator) Scope(name, prefix string, ttl time.Duration) *Scope {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if s, exists := d.scopes[name]; exists {
+		s.SetTTL(ttl)
+		return s
+	}
+	s := &Scope{
+		name:       name,
+		prefix:     prefix,
+		ttl:        ttl,
+		store:      d.store,
+		maxEntries: d.maxEntries,
+		logger:     d.logger,
+		seen:       make(map[string]time.Time),
+		pending:    make(map[string]bool),
+	}
+	d.scopes[name] = s
+	return s
+}
+
+// Stats returns the stats of every scope, by name
+func (d *Deduplicator) Stats() []Stats {
+	d.mutex.Lock()
+	scopes := make([]*Scope, 0, len(d.scopes))
+	for _, s := range d.scopes {
+		scopes = append(scopes, s)
+	}
+	d.mutex.Unlock()
+
+	stats := make([]Stats, 0, len(scopes))
+	for _, s := range scopes {
+		stats = append(stats, s.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Scope < stats[j].Scope })
+	return stats
+}
+
+// Scope remembers the keys of one kind of message or action
+type Scope struct {
+	name       string
+	prefix     string
+	store      storage.Store
+	maxEntries int
+	logger     *logger.Logger
+
+	mutex   sync.Mutex
+	ttl     time.Duration
+	seen    map[string]time.Time // Handled keys by when they were handled
+	order   []string             // Keys of seen, oldest first
+	pending map[string]bool      // Keys being handled
+	pruned  time.Time
+	stats   Stats
+}
+
+// record is what is stored for a handled key. The field is named as in the
+// records of delivered dispatches of earlier versions.
+type record struct {
+	HandledAt time.Time `json:"delivered_at"`
+}
+
+// SetTTL changes how long handled keys are remembered, e.g. after a config
+// reload
+func (s *Scope) SetTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ttl = ttl
+}
+
+// Claim reports whether the key may be handled: it was not handled within
+// the TTL and is not being handled. A claimed key is released by Release.
+func (s *Scope) Claim(ctx context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	if s.pending[key] || s.seenLocked(key) {
+		s.stats.Hits++
+		s.mutex.Unlock()
+		return false, nil
+	}
+	s.pending[key] = true
+	ttl := s.ttl
+	prune := s.store != nil && time.Since(s.pruned) > pruneInterval
+	if prune {
+		s.pruned = time.Now()
+	}
+	s.mutex.Unlock()
+
+	if prune {
+		go s.prune(ttl)
+	}
+	if s.store != nil {
+		data, err := s.store.Get(ctx, s.prefix+key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			s.Release(key, false)
+			return false, err
+		}
+		var previous record
+		if err == nil && json.Unmarshal(data, &previous) == nil && time.Since(previous.HandledAt) <= ttl {
+			s.mutex.Lock()
+			delete(s.pending, key)
+			s.rememberLocked(key, previous.HandledAt)
+			s.stats.Hits++
+			s.mutex.Unlock()
+			return false, nil
+		}
+	}
+
+	s.mutex.Lock()
+	s.stats.Misses++
+	s.mutex.Unlock()
+	return true, nil
+}
+
+// Release ends the handling of a claimed key, remembering it if it was
+// handled. A key that was not handled may be claimed again.
+func (s *Scope) Release(key string, handled bool) error {
+	now := time.Now()
+	s.mutex.Lock()
+	delete(s.pending, key)
+	if handled {
+		s.rememberLocked(key, now)
+	}
+	s.mutex.Unlock()
+
+	if !handled || s.store == nil {
+		return nil
+	}
+	data, _ := json.Marshal(record{HandledAt: now.UTC()})
+	return s.store.Put(context.Background(), s.prefix+key, data)
+}
+
+// Seen claims the key and releases it as handled at once, reporting
+// whether it was a duplicate
+func (s *Scope) Seen(ctx context.Context, key string) (bool, error) {
+	claimed, err := s.Claim(ctx, key)
+	if err != nil || !claimed {
+		return !claimed && err == nil, err
+	}
+	return false, s.Release(key, true)
+}
+
+// Stats returns the lookups of the scope since startup
+func (s *Scope) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	stats.Scope = s.name
+	stats.Entries = len(s.seen)
+	return stats
+}
+
+// seenLocked reports whether the key was handled within the TTL
+func (s *Scope) seenLocked(key string) bool {
+	handledAt, exists := s.seen[key]
+	return exists && time.Since(handledAt) <= s.ttl
+}
+
+// rememberLocked remembers a handled key, forgetting expired keys and,
+// beyond max_entries, the oldest
+func (s *Scope) rememberLocked(key string, handledAt time.Time) {
+	if _, exists := s.seen[key]; !exists {
+		s.order = append(s.order, key)
+	}
+	s.seen[key] = handledAt
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		expired := time.Since(s.seen[oldest]) > s.ttl
+		if !expired && (s.maxEntries <= 0 || len(s.order) <= s.maxEntries) {
+			break
+		}
+		if !expired {
+			s.stats.Evictions++
+		}
+		delete(s.seen, oldest)
+		s.order = s.order[1:]
+	}
+}
+
+// prune deletes the keys older than ttl from the storage
+func (s *Scope) prune(ttl time.Duration) {
+	deleted, err := s.store.DeleteBefore(context.Background(), s.prefix, time.Now().Add(-ttl))
+	if err != nil {
+		s.logger.Error("Failed to prune the %s keys: %v", s.name, err)
+		return
+	}
+	if deleted > 0 {
+		s.logger.Debug("Pruned %d %s keys", deleted, s.name)
+	}
+}
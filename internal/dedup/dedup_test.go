@@ -0,0 +1,94 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func testLogger(t *testing.T) *logger.Logger {
+	log, err := logger.New(&config.LoggingConfig{Level: "error"})
+	if err != nil {
+		t.Fatalf("logger.New() error = %v", err)
+	}
+	return log
+}
+
+func TestClaimAndRelease(t *testing.T) {
+	ctx := context.Background()
+	scope := New(nil, 0, testLogger(t)).Scope("test", "test/", time.Hour)
+
+	if claimed, _ := scope.Claim(ctx, "a"); !claimed {
+		t.Fatal("Claim() of a new key = false")
+	}
+	if claimed, _ := scope.Claim(ctx, "a"); claimed {
+		t.Error("Claim() of a pending key = true")
+	}
+	scope.Release("a", false)
+	if claimed, _ := scope.Claim(ctx, "a"); !claimed {
+		t.Error("Claim() of a key that was not handled = false")
+	}
+	scope.Release("a", true)
+	if duplicate, _ := scope.Seen(ctx, "a"); !duplicate {
+		t.Error("Seen() of a handled key = false")
+	}
+	if duplicate, _ := scope.Seen(ctx, "b"); duplicate {
+		t.Error("Seen() of a new key = true")
+	}
+
+	stats := scope.Stats()
+	if stats.Scope != "test" || stats.Hits != 2 || stats.Misses != 3 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestStoredKeysAndTTL(t *testing.T) {
+	ctx := context.Background()
+	log := testLogger(t)
+	store := storage.NewMemory()
+	scope := New(store, 0, log).Scope("test", "test/", time.Hour)
+	scope.Seen(ctx, "a")
+
+	// After a restart the key is found in the store
+	restarted := New(store, 0, log).Scope("test", "test/", time.Hour)
+	if duplicate, _ := restarted.Seen(ctx, "a"); !duplicate {
+		t.Error("Seen() of a stored key after a restart = false")
+	}
+
+	// Keys expire after the TTL
+	expiring := New(store, 0, log).Scope("test", "test/", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if duplicate, _ := expiring.Seen(ctx, "a"); duplicate {
+		t.Error("Seen() of an expired key = true")
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	log := testLogger(t)
+	d := New(nil, 2, log)
+	scope := d.Scope("test", "test/", time.Hour)
+	for _, key := range []string{"a", "b", "c"} {
+		scope.Seen(ctx, key)
+	}
+	if duplicate, _ := scope.Seen(ctx, "a"); duplicate {
+		t.Error("Seen() of a key evicted from memory without a store = true")
+	}
+
+	stats := d.Stats()
+	if len(stats) != 1 || stats[0].Evictions != 2 || stats[0].Entries != 2 {
+		t.Errorf("Stats() = %+v, want 2 evictions and 2 entries", stats)
+	}
+
+	// With a store, evicted keys are still found
+	stored := New(storage.NewMemory(), 1, log).Scope("test", "test/", time.Hour)
+	stored.Seen(ctx, "a")
+	stored.Seen(ctx, "b")
+	if duplicate, _ := stored.Seen(ctx, "a"); !duplicate {
+		t.Error("Seen() of a key evicted from memory but stored = false")
+	}
+}
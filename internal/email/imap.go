@@ -0,0 +1,213 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Largest literal (email) read from the IMAP server
+const maxLiteralSize = 25 << 20
+
+// imapClient is a minimal IMAP4rev1 client covering what the poller needs:
+// login, selecting a mailbox, searching and fetching by UID
+type imapClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// imapResponse is a response line with the literals it carries
+type imapResponse struct {
+	text     string // The line, literals replaced by their {size} markers
+	literals [][]byte
+}
+
+// dialIMAP connects and logs in to the IMAP server of cfg
+func dialIMAP(ctx context.Context, cfg *config.IMAPConfig) (*imapClient, error) {
+	conn, err := dial(ctx, cfg.Host, cfg.Port, cfg.TLS == "tls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	// Unblock reads and writes when the poll is cancelled or times out
+	context.AfterFunc(ctx, func() { conn.Close() })
+	c := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP server refused the connection: %s", greeting.text)
+	}
+
+	if cfg.TLS == "starttls" {
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: cfg.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("IMAP STARTTLS failed: %w", err)
+		}
+		c.conn, c.reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	if _, err := c.command("LOGIN %s %s", quote(cfg.Username), quote(cfg.Password)); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("IMAP login failed: %w", err)
+	}
+	return c, nil
+}
+
+// selectMailbox opens a mailbox and returns its UIDVALIDITY
+func (c *imapClient) selectMailbox(name string) (uint32, error) {
+	responses, err := c.command("SELECT %s", quote(name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to select mailbox %s: %w", name, err)
+	}
+	for _, resp := range responses {
+		if i := strings.Index(strings.ToUpper(resp.text), "[UIDVALIDITY "); i >= 0 {
+			value := resp.text[i+len("[UIDVALIDITY "):]
+			if end := strings.Index(value, "]"); end >= 0 {
+				if validity, err := strconv.ParseUint(value[:end], 10, 32); err == nil {
+					return uint32(validity), nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("IMAP server sent no UIDVALIDITY for mailbox %s", name)
+}
+
+// searchUIDs returns the UIDs of the messages of the selected mailbox
+// matching an IMAP search, e.g. "UID 42:*" or "ALL"
+func (c *imapClient) searchUIDs(criteria string) ([]uint32, error) {
+	responses, err := c.command("UID SEARCH %s", criteria)
+	if err != nil {
+		return nil, fmt.Errorf("IMAP search failed: %w", err)
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.text)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IMAP search result %q", field)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the raw email with a UID, without marking it as seen
+func (c *imapClient) fetch(uid uint32) ([]byte, error) {
+	responses, err := c.command("UID FETCH %d BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch email %d: %w", uid, err)
+	}
+	for _, resp := range responses {
+		if strings.HasPrefix(resp.text, "* ") && strings.Contains(strings.ToUpper(resp.text), "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("email %d no longer exists", uid)
+}
+
+// logout ends the session and closes the connection
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// command sends a tagged command and returns the untagged responses before
+// its completion, or an error if it did not complete with OK
+func (c *imapClient) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(resp.text, tag+" ") {
+			responses = append(responses, resp)
+			continue
+		}
+		status := strings.TrimPrefix(resp.text, tag+" ")
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			return nil, errors.New(status)
+		}
+		return responses, nil
+	}
+}
+
+// readResponse reads a response line, with the literals ({size} followed by
+// size bytes) it contains
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	var text strings.Builder
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		text.WriteString(line)
+
+		size, ok := literalSize(line)
+		if !ok {
+			resp.text = text.String()
+			return resp, nil
+		}
+		if size > maxLiteralSize {
+			return resp, fmt.Errorf("IMAP literal of %d bytes is too large", size)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+// literalSize returns the size of the literal announced at the end of a
+// line, e.g. {1024}
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	start := strings.LastIndex(line, "{")
+	if start < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(line[start+1 : len(line)-1])
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// quote returns an IMAP quoted string
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,194 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Email is a received email
+type Email struct {
+	UID     uint32
+	From    string // Display name and address, e.g. Alice <alice@example.com>
+	Address string // Address of the sender
+	To      string
+	Subject string
+	Date    time.Time
+	Body    string // Plain text part, or the HTML part without markup
+}
+
+// Largest part of a multipart email searched for the body
+const maxPartSize = 1 << 20
+
+var (
+	tagRegex        = regexp.MustCompile(`<[^>]*>`)
+	blockTagRegex   = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr|/h[1-6])\b[^>]*>`)
+	styleRegex      = regexp.MustCompile(`(?is)<(style|script)\b.*?</(style|script)>`)
+	blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+)
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// parseEmail parses a raw RFC 5322 email
+func parseEmail(raw []byte) (Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Email{}, fmt.Errorf("invalid email: %w", err)
+	}
+
+	e := Email{
+		To:      decodeHeader(msg.Header.Get("To")),
+		Subject: decodeHeader(msg.Header.Get("Subject")),
+	}
+	e.From = decodeHeader(msg.Header.Get("From"))
+	if from, err := (&mail.AddressParser{WordDecoder: headerDecoder}).Parse(msg.Header.Get("From")); err == nil {
+		e.Address = strings.ToLower(from.Address)
+		if from.Name != "" {
+			e.From = fmt.Sprintf("%s <%s>", from.Name, from.Address)
+		} else {
+			e.From = from.Address
+		}
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		e.Date = date
+	}
+
+	text, isHTML, err := findBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return Email{}, err
+	}
+	if isHTML {
+		text = htmlToText(text)
+	}
+	e.Body = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	return e, nil
+}
+
+// findBody returns the text of a part, preferring text/plain over text/html
+// in multipart emails
+func findBody(contentType, transferEncoding string, body io.Reader) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var htmlText string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", false, fmt.Errorf("invalid multipart email: %w", err)
+			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			text, isHTML, err := findBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), io.LimitReader(part, maxPartSize))
+			if err != nil {
+				return "", false, err
+			}
+			if text == "" {
+				continue
+			}
+			if !isHTML {
+				return text, false, nil
+			}
+			if htmlText == "" {
+				htmlText = text
+			}
+		}
+		return htmlText, htmlText != "", nil
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", false, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &lineJoiner{r: body})
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxPartSize))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode email body: %w", err)
+	}
+	return decodeCharset(params["charset"], data), mediaType == "text/html", nil
+}
+
+// lineJoiner drops the line breaks of base64 content
+type lineJoiner struct {
+	r io.Reader
+}
+
+func (l *lineJoiner) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	kept := 0
+	for _, c := range p[:n] {
+		if c != '\r' && c != '\n' {
+			p[kept] = c
+			kept++
+		}
+	}
+	return kept, err
+}
+
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// charsetReader converts the Latin-1 family to UTF-8 and passes other
+// charsets through
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(decodeCharset(charset, data)), nil
+}
+
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	default:
+		return string(data)
+	}
+}
+
+// htmlToText strips markup, keeping line breaks of block elements
+func htmlToText(s string) string {
+	s = styleRegex.ReplaceAllString(s, "")
+	s = strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+	s = blockTagRegex.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(tagRegex.ReplaceAllString(s, ""))
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return blankLinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
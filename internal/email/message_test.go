@@ -0,0 +1,72 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		from    string
+		address string
+		subject string
+		body    string
+	}{
+		{
+			name: "plain",
+			raw: "From: Alice <Alice@Example.com>\r\nTo: ops@example.com\r\nSubject: Disk full\r\nDate: Tue, 13 Oct 2026 08:00:00 +0000\r\n\r\n" +
+				"db1 is at 95%.\r\nPlease check.\r\n",
+			from: "Alice <Alice@Example.com>", address: "alice@example.com", subject: "Disk full", body: "db1 is at 95%.\nPlease check.",
+		},
+		{
+			name: "encoded words and quoted-printable",
+			raw: "From: =?utf-8?q?J=C3=BCrgen?= <j@example.com>\r\nSubject: =?utf-8?b?R3LDvMOfZQ==?=\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				"Sch=C3=B6ne Gr=C3=BC=C3=9Fe, a very long line that was soft=\r\n broken\r\n",
+			from: "Jürgen <j@example.com>", address: "j@example.com", subject: "Grüße", body: "Schöne Grüße, a very long line that was soft broken",
+		},
+		{
+			name: "multipart alternative prefers text",
+			raw: "From: bot@example.com\r\nSubject: Report\r\nContent-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+				"--b1\r\nContent-Type: text/html\r\n\r\n<p>HTML version</p>\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\nVGV4dCB2ZXJz\r\naW9u\r\n" +
+				"--b1--\r\n",
+			from: "bot@example.com", address: "bot@example.com", subject: "Report", body: "Text version",
+		},
+		{
+			name: "html only, attachment skipped",
+			raw: "From: bot@example.com\r\nSubject: Alert\r\nContent-Type: multipart/mixed; boundary=b2\r\n\r\n" +
+				"--b2\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=log.txt\r\n\r\nattached log\r\n" +
+				"--b2\r\nContent-Type: text/html; charset=iso-8859-1\r\n\r\n<style>p {}</style><p>Caf\xe9 &amp; more</p><p>Second<br>line</p>\r\n" +
+				"--b2--\r\n",
+			from: "bot@example.com", address: "bot@example.com", subject: "Alert", body: "Café & more\nSecond\nline",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseEmail([]byte(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.From != tt.from || e.Address != tt.address || e.Subject != tt.subject || e.Body != tt.body {
+				t.Errorf("got from %q, address %q, subject %q, body %q; want %q, %q, %q, %q", e.From, e.Address, e.Subject, e.Body, tt.from, tt.address, tt.subject, tt.body)
+			}
+		})
+	}
+}
+
+func TestParseEmailDate(t *testing.T) {
+	e, err := parseEmail([]byte("From: a@example.com\r\nDate: Tue, 13 Oct 2026 08:00:00 +0200\r\n\r\nx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.October, 13, 6, 0, 0, 0, time.UTC); !e.Date.Equal(want) {
+		t.Errorf("date = %v, want %v", e.Date, want)
+	}
+	if _, err := parseEmail([]byte("not an email")); err == nil || !strings.Contains(err.Error(), "invalid email") {
+		t.Errorf("error = %v, want invalid email", err)
+	}
+}
@@ -0,0 +1,209 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// Key of the state in storage
+const storageKey = "email.imap"
+
+// PostFunc posts a new email that matches the filters
+type PostFunc func(e Email)
+
+// Poller polls a mailbox over IMAP and posts the new emails matching its
+// filters. The UID of the last email seen is kept in storage, so that
+// nothing is posted twice across restarts.
+type Poller struct {
+	cfg      config.IMAPConfig
+	store    storage.Store
+	from     []string // Lower-case address patterns
+	subject  *regexp.Regexp
+	post     PostFunc
+	logger   *logger.Logger
+	interval time.Duration
+
+	state state // Only used by the polling goroutine
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// state is what is kept in storage
+type state struct {
+	UIDValidity uint32 `json:"uid_validity"`
+	LastUID     uint32 `json:"last_uid"`
+}
+
+// NewPoller compiles the filters and loads the stored state
+func NewPoller(cfg *config.IMAPConfig, store storage.Store, post PostFunc, log *logger.Logger) (*Poller, error) {
+	p := &Poller{
+		cfg:      *cfg,
+		store:    store,
+		post:     post,
+		logger:   log,
+		interval: time.Duration(cfg.Interval) * time.Second,
+	}
+	for _, pattern := range cfg.From {
+		p.from = append(p.from, strings.ToLower(pattern))
+	}
+	if cfg.Subject != "" {
+		var err error
+		if p.subject, err = regexp.Compile(cfg.Subject); err != nil {
+			return nil, fmt.Errorf("invalid email.imap.subject: %w", err)
+		}
+	}
+
+	data, err := storage.Load(context.Background(), store, storageKey, cfg.StateFile)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to read email state: %w", err)
+	case data != nil:
+		if err := json.Unmarshal(data, &p.state); err != nil {
+			return nil, fmt.Errorf("invalid email state: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Start polls the mailbox in the background until Stop
+func (p *Poller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+				p.logger.Warn("Failed to poll mailbox %s on %s: %v", p.cfg.Mailbox, p.cfg.Host, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling, abandoning a poll in progress
+func (p *Poller) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// poll posts the emails that arrived since the last poll, oldest first. The
+// emails already in the mailbox when it is first polled are not posted.
+func (p *Poller) poll(ctx context.Context) error {
+	// A poll must not run into the next one
+	timeout := p.interval
+	if timeout < time.Minute {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client, err := dialIMAP(ctx, &p.cfg)
+	if err != nil {
+		return err
+	}
+	defer client.logout()
+	validity, err := client.selectMailbox(p.cfg.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	current := p.state
+
+	if current.UIDValidity != validity {
+		// First poll, or the mailbox was recreated and its UIDs changed
+		uids, err := client.searchUIDs("ALL")
+		if err != nil {
+			return err
+		}
+		var last uint32
+		for _, uid := range uids {
+			if uid > last {
+				last = uid
+			}
+		}
+		p.logger.Info("Mailbox %s has %d emails, posting emails received from now on", p.cfg.Mailbox, len(uids))
+		return p.record(state{UIDValidity: validity, LastUID: last})
+	}
+
+	// UID n:* always matches the last email, even below n
+	uids, err := client.searchUIDs(fmt.Sprintf("UID %d:*", current.LastUID+1))
+	if err != nil {
+		return err
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	for _, uid := range uids {
+		if uid <= current.LastUID {
+			continue
+		}
+		raw, err := client.fetch(uid)
+		if err != nil {
+			return err
+		}
+		e, err := parseEmail(raw)
+		if err != nil {
+			p.logger.Warn("Skipping email %d: %v", uid, err)
+		} else if p.matches(e) {
+			e.UID = uid
+			p.post(e)
+		} else {
+			p.logger.Debug("Email %d from %s does not match the filters", uid, e.Address)
+		}
+		current.LastUID = uid
+		if err := p.record(current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether an email passes the from and subject filters
+func (p *Poller) matches(e Email) bool {
+	if len(p.from) > 0 {
+		matched := false
+		for _, pattern := range p.from {
+			if ok, _ := path.Match(pattern, e.Address); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return p.subject == nil || p.subject.MatchString(e.Subject)
+}
+
+// record stores the state
+func (p *Poller) record(s state) error {
+	p.state = s
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := p.store.Put(context.Background(), storageKey, data); err != nil {
+		return fmt.Errorf("failed to save email state: %w", err)
+	}
+	return nil
+}
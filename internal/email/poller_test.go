@@ -0,0 +1,195 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// imapServer serves a mailbox whose emails can be changed
+type imapServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	validity uint32
+	emails   map[uint32]string
+	logins   []string
+}
+
+func newIMAPServer(t *testing.T) *imapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &imapServer{listener: listener, validity: 1, emails: make(map[uint32]string)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *imapServer) add(uid uint32, from, subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emails[uid] = fmt.Sprintf("From: %s\r\nSubject: %s\r\n\r\nBody of %d\r\n", from, subject, uid)
+}
+
+func (s *imapServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		tag, command := fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+		s.mu.Lock()
+		switch {
+		case strings.HasPrefix(command, "LOGIN "):
+			s.logins = append(s.logins, strings.Join(fields[2:], " "))
+			fmt.Fprintf(conn, "%s OK Logged in\r\n", tag)
+		case strings.HasPrefix(command, "SELECT "):
+			fmt.Fprintf(conn, "* %d EXISTS\r\n* OK [UIDVALIDITY %d] UIDs valid\r\n%s OK [READ-WRITE] Selected\r\n", len(s.emails), s.validity, tag)
+		case strings.HasPrefix(command, "UID SEARCH "):
+			criteria := fields[3:]
+			var from uint32 = 1
+			if strings.EqualFold(criteria[0], "UID") {
+				n, _ := strconv.Atoi(strings.TrimSuffix(criteria[1], ":*"))
+				from = uint32(n)
+			}
+			var uids, all []int
+			for uid := range s.emails {
+				all = append(all, int(uid))
+				if uid >= from {
+					uids = append(uids, int(uid))
+				}
+			}
+			sort.Ints(all)
+			// n:* always includes the last email
+			if len(uids) == 0 && len(all) > 0 {
+				uids = all[len(all)-1:]
+			}
+			found := make([]string, len(uids))
+			for i, uid := range uids {
+				found[i] = strconv.Itoa(uid)
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK Search completed\r\n", strings.Join(found, " "), tag)
+		case strings.HasPrefix(command, "UID FETCH "):
+			uid, _ := strconv.Atoi(fields[3])
+			if raw, ok := s.emails[uint32(uid)]; ok {
+				fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(raw), raw)
+			}
+			fmt.Fprintf(conn, "%s OK Fetch completed\r\n", tag)
+		case command == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK Logged out\r\n", tag)
+			s.mu.Unlock()
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD Unknown command\r\n", tag)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestPoller(t *testing.T) {
+	server := newIMAPServer(t)
+	server.add(1, "alerts@example.com", "Old alert")
+
+	cfg := &config.IMAPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.listener.Addr().(*net.TCPAddr).Port,
+		Username: "mule",
+		Password: `pa"ss`,
+		TLS:      "none",
+		Mailbox:  "INBOX",
+		Interval: 60,
+		From:     []string{"*@Example.com"},
+		Subject:  "(?i)alert|incident",
+	}
+	var posted []Email
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	store := storage.NewMemory()
+	newPoller := func() *Poller {
+		p, err := NewPoller(cfg, store, func(e Email) { posted = append(posted, e) }, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p := newPoller()
+	// The emails already in the mailbox are not posted
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 0 {
+		t.Fatalf("posted %v on the first poll", posted)
+	}
+	if server.logins[0] != `"mule" "pa\"ss"` {
+		t.Errorf("login = %s", server.logins[0])
+	}
+
+	// Nothing new: n:* still matches the last email, which is not posted again
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 0 {
+		t.Fatalf("posted %v without new emails", posted)
+	}
+
+	server.add(2, "alerts@example.com", "New alert")
+	server.add(3, "someone@elsewhere.org", "Alert from elsewhere")
+	server.add(4, "ci@example.com", "Newsletter")
+	server.add(5, "ci@example.com", "Incident 42")
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 || posted[0].UID != 2 || posted[1].UID != 5 {
+		t.Fatalf("posted %v, want emails 2 and 5", posted)
+	}
+	if posted[1].Subject != "Incident 42" || posted[1].Body != "Body of 5" {
+		t.Errorf("posted email = %+v", posted[1])
+	}
+
+	// The last UID survives a restart
+	posted = nil
+	server.add(6, "alerts@example.com", "Alert after restart")
+	p = newPoller()
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0].UID != 6 {
+		t.Errorf("posted %v after restart, want email 6", posted)
+	}
+
+	// A new UIDVALIDITY starts over
+	posted = nil
+	server.mu.Lock()
+	server.validity = 2
+	server.mu.Unlock()
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 0 || p.state.UIDValidity != 2 || p.state.LastUID != 6 {
+		t.Errorf("posted %v with state %+v after UIDVALIDITY changed", posted, p.state)
+	}
+}
@@ -0,0 +1,173 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// smtpServer accepts the emails of one connection
+type smtpServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	auth     string
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			decoded, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			s.auth = string(decoded)
+			reply("235 Authenticated")
+		case "MAIL":
+			s.from = line
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 Not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSend(t *testing.T) {
+	server := newSMTPServer(t)
+	cfg := &config.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "mule",
+		Password: "s3cret",
+		From:     "Mule <mule@example.com>",
+		TLS:      "none",
+		Timeout:  5,
+	}
+	msg := Message{To: []string{"Ops <ops@example.com>", "oncall@example.com"}, Subject: "Deploy of api: ✅", Body: "Version v1.2 is live.\nDetails follow."}
+	if err := Send(context.Background(), cfg, msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP session did not finish")
+	}
+
+	if server.auth != "\x00mule\x00s3cret" {
+		t.Errorf("auth = %q", server.auth)
+	}
+	if server.from != "MAIL FROM:<mule@example.com> BODY=8BITMIME" && server.from != "MAIL FROM:<mule@example.com>" {
+		t.Errorf("from = %q", server.from)
+	}
+	if len(server.to) != 2 || server.to[0] != "RCPT TO:<ops@example.com>" || server.to[1] != "RCPT TO:<oncall@example.com>" {
+		t.Errorf("recipients = %q", server.to)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(server.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := headerDecoder.DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != msg.Subject {
+		t.Errorf("subject = %q, want %q", subject, msg.Subject)
+	}
+	if to := parsed.Header.Get("To"); to != "Ops <ops@example.com>, oncall@example.com" {
+		t.Errorf("To = %q", to)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSuffix(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n"); got != msg.Body {
+		t.Errorf("body = %q, want %q", got, msg.Body)
+	}
+}
+
+func TestSendInvalidAddresses(t *testing.T) {
+	cfg := &config.SMTPConfig{Host: "127.0.0.1", Port: 1, From: "mule@example.com", TLS: "none", Timeout: 1}
+	if err := Send(context.Background(), cfg, Message{To: []string{"not an address"}, Body: "x"}); err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Errorf("error = %v, want invalid recipient", err)
+	}
+}
+
+func TestBuildMessageHeaderInjection(t *testing.T) {
+	from := &mail.Address{Address: "mule@example.com"}
+	data, err := buildMessage(from, Message{To: []string{"ops@example.com"}, Subject: "Hi\r\nBcc: evil@example.com", Body: "x"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("subject injected a Bcc header: %q", bcc)
+	}
+}
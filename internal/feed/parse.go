@@ -0,0 +1,199 @@
+// Package feed polls RSS and Atom feeds and reports their new entries
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Item is an entry of a feed
+type Item struct {
+	ID        string // guid or id, falling back to the link
+	Title     string
+	Link      string
+	Summary   string // Plain text, without markup
+	Published time.Time
+}
+
+// Feed is a parsed RSS or Atom document, with its entries in document order
+type Feed struct {
+	Title string
+	Items []Item
+}
+
+// rssDocument covers RSS 2.0 (items in the channel) and RSS 1.0 (items
+// next to the channel)
+type rssDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomDocument struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// Date layouts found in feeds, RFC 822 variants for RSS and RFC 3339 for
+// Atom and Dublin Core
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+// Parse parses an RSS 1.0, RSS 2.0 or Atom document
+func Parse(data []byte) (*Feed, error) {
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := newDecoder(data)
+	switch root {
+	case "rss", "RDF":
+		var doc rssDocument
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid RSS feed: %w", err)
+		}
+		items := doc.Channel.Items
+		if len(items) == 0 {
+			items = doc.Items
+		}
+		feed := &Feed{Title: strings.TrimSpace(doc.Channel.Title)}
+		for _, item := range items {
+			feed.Items = append(feed.Items, Item{
+				ID:        firstNonEmpty(item.GUID, item.Link, item.Title),
+				Title:     plainText(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Summary:   plainText(item.Description),
+				Published: parseDate(firstNonEmpty(item.PubDate, item.Date)),
+			})
+		}
+		return feed, nil
+	case "feed":
+		var doc atomDocument
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid Atom feed: %w", err)
+		}
+		feed := &Feed{Title: plainText(doc.Title)}
+		for _, entry := range doc.Entries {
+			link := entry.link()
+			feed.Items = append(feed.Items, Item{
+				ID:        firstNonEmpty(entry.ID, link, entry.Title),
+				Title:     plainText(entry.Title),
+				Link:      link,
+				Summary:   plainText(firstNonEmpty(entry.Summary, entry.Content)),
+				Published: parseDate(firstNonEmpty(entry.Published, entry.Updated)),
+			})
+		}
+		return feed, nil
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed (root element %q)", root)
+	}
+}
+
+// link returns the alternate link of an entry
+func (e *atomEntry) link() string {
+	for _, link := range e.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	if len(e.Links) > 0 {
+		return strings.TrimSpace(e.Links[0].Href)
+	}
+	return ""
+}
+
+// rootElement returns the local name of the document element
+func rootElement(data []byte) (string, error) {
+	decoder := newDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("not an XML document: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func newDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Feeds are often sloppy: HTML entities and non-UTF-8 declarations
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder
+}
+
+var (
+	tagRegex        = regexp.MustCompile(`<[^>]*>`)
+	whitespaceRegex = regexp.MustCompile(`\s+`)
+)
+
+// plainText strips markup and collapses whitespace
+func plainText(s string) string {
+	s = tagRegex.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(whitespaceRegex.ReplaceAllString(s, " "))
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		title     string
+		want      []Item
+		wantError string
+	}{
+		{
+			name: "rss 2.0",
+			document: `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+  <title>Release notes</title>
+  <atom:link href="https://example.com/feed.xml" rel="self"/>
+  <item>
+    <title>v2.0 &amp; more</title>
+    <link>https://example.com/v2</link>
+    <guid isPermaLink="false">release-2</guid>
+    <description>&lt;p&gt;Big &lt;b&gt;release&lt;/b&gt;&lt;/p&gt;</description>
+    <pubDate>Tue, 06 Oct 2026 09:30:00 +0000</pubDate>
+  </item>
+  <item><title>v1.0</title><link>https://example.com/v1</link></item>
+</channel></rss>`,
+			title: "Release notes",
+			want: []Item{
+				{ID: "release-2", Title: "v2.0 & more", Link: "https://example.com/v2", Summary: "Big release", Published: time.Date(2026, 10, 6, 9, 30, 0, 0, time.UTC)},
+				{ID: "https://example.com/v1", Title: "v1.0", Link: "https://example.com/v1"},
+			},
+		},
+		{
+			name: "rss 1.0",
+			document: `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel><title>Planet</title></channel>
+  <item><title>Post</title><link>https://example.com/post</link><dc:date>2026-10-01T12:00:00Z</dc:date></item>
+</rdf:RDF>`,
+			title: "Planet",
+			want:  []Item{{ID: "https://example.com/post", Title: "Post", Link: "https://example.com/post", Published: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "atom",
+			document: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title type="html">Status &lt;b&gt;updates&lt;/b&gt;</title>
+  <entry>
+    <id>tag:example.com,2026:incident-7</id>
+    <title>Database degraded</title>
+    <link rel="edit" href="https://example.com/api/7"/>
+    <link rel="alternate" href="https://example.com/incidents/7"/>
+    <content type="html">&lt;p&gt;Investigating&lt;/p&gt;</content>
+    <updated>2026-10-02T08:00:00+02:00</updated>
+  </entry>
+</feed>`,
+			title: "Status updates",
+			want:  []Item{{ID: "tag:example.com,2026:incident-7", Title: "Database degraded", Link: "https://example.com/incidents/7", Summary: "Investigating", Published: time.Date(2026, 10, 2, 6, 0, 0, 0, time.UTC)}},
+		},
+		{name: "html", document: `<html><body>Not a feed</body></html>`, wantError: "not an RSS or Atom feed"},
+		{name: "not xml", document: `{"items": []}`, wantError: "not an XML document"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := Parse([]byte(tt.document))
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("Parse error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.Title != tt.title {
+				t.Errorf("title = %q, want %q", feed.Title, tt.title)
+			}
+			if len(feed.Items) != len(tt.want) {
+				t.Fatalf("items = %+v, want %+v", feed.Items, tt.want)
+			}
+			for i, item := range feed.Items {
+				want := tt.want[i]
+				if item.ID != want.ID || item.Title != want.Title || item.Link != want.Link || item.Summary != want.Summary || !item.Published.Equal(want.Published) {
+					t.Errorf("item %d = %+v, want %+v", i, item, want)
+				}
+			}
+		})
+	}
+}
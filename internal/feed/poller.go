@@ -0,0 +1,451 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+const (
+	// Largest feed document read
+	maxFeedSize = 10 << 20
+	// Entry IDs remembered per subscription, beyond those in the feed
+	maxSeen = 500
+	// How often the poller looks for subscriptions due
+	defaultTick = 10 * time.Second
+	// Key of the state in storage
+	storageKey = "feeds"
+)
+
+var (
+	// Returned by Subscribe for a feed the room is already subscribed to
+	ErrSubscribed = errors.New("the room is already subscribed to this feed")
+	// Returned by Unsubscribe for feeds of the config file
+	ErrConfigured = errors.New("the feed is configured in the config file")
+)
+
+// Subscription posts the new entries of a feed to a room
+type Subscription struct {
+	URL          string    `json:"url"`
+	RoomID       string    `json:"room_id"`
+	Title        string    `json:"title,omitempty"`    // Of the feed, as last fetched
+	Interval     int       `json:"interval,omitempty"` // Seconds between polls, 0 = feeds.interval
+	SubscribedBy string    `json:"subscribed_by,omitempty"`
+	SubscribedAt time.Time `json:"subscribed_at"`
+	Configured   bool      `json:"-"` // From the config file, not stored
+}
+
+// PostFunc posts a new entry of a subscription
+type PostFunc func(sub Subscription, item Item)
+
+// Poller polls the subscribed feeds and posts their new entries. The
+// subscriptions made at runtime and the IDs of the entries already seen are
+// kept in storage, so that nothing is posted twice across restarts.
+type Poller struct {
+	store    storage.Store
+	interval time.Duration
+	maxItems int
+	client   *http.Client
+	post     PostFunc
+	logger   *logger.Logger
+	tick     time.Duration
+
+	mu            sync.Mutex // Guards the fields below and the stored state
+	subscriptions map[string]*subscription
+	seen          map[string][]string // Entry IDs by subscription key
+
+	cancel context.CancelFunc // Stops polling
+	done   chan struct{}
+}
+
+// subscription is a Subscription with its polling state
+type subscription struct {
+	Subscription
+	nextPoll     time.Time
+	etag         string
+	lastModified string
+}
+
+// state is what is kept in storage
+type state struct {
+	Subscriptions []Subscription      `json:"subscriptions"`
+	Seen          map[string][]string `json:"seen"`
+}
+
+func subscriptionKey(roomID, feedURL string) string {
+	return roomID + " " + feedURL
+}
+
+// NewPoller loads the stored state and adds the feeds of the config file.
+// Feeds without a room are posted to defaultRoom.
+func NewPoller(cfg *config.FeedsConfig, defaultRoom string, store storage.Store, post PostFunc, log *logger.Logger) (*Poller, error) {
+	p := &Poller{
+		store:         store,
+		interval:      time.Duration(cfg.Interval) * time.Second,
+		maxItems:      cfg.MaxItems,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		post:          post,
+		logger:        log,
+		tick:          defaultTick,
+		subscriptions: make(map[string]*subscription),
+		seen:          make(map[string][]string),
+	}
+
+	data, err := storage.Load(context.Background(), store, storageKey, cfg.StateFile)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to read feed state: %w", err)
+	case data != nil:
+		var stored state
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("invalid feed state: %w", err)
+		}
+		for _, sub := range stored.Subscriptions {
+			p.subscriptions[subscriptionKey(sub.RoomID, sub.URL)] = &subscription{Subscription: sub}
+		}
+		if stored.Seen != nil {
+			p.seen = stored.Seen
+		}
+	}
+
+	for _, feed := range cfg.Subscriptions {
+		roomID := feed.RoomID
+		if roomID == "" {
+			roomID = defaultRoom
+		}
+		p.subscriptions[subscriptionKey(roomID, feed.URL)] = &subscription{Subscription: Subscription{
+			URL:        feed.URL,
+			RoomID:     roomID,
+			Interval:   feed.Interval,
+			Configured: true,
+		}}
+	}
+	// Forget the entries of feeds removed from the config file
+	for key := range p.seen {
+		if _, subscribed := p.subscriptions[key]; !subscribed {
+			delete(p.seen, key)
+		}
+	}
+	log.Info("Loaded %d feed subscriptions", len(p.subscriptions))
+	return p, nil
+}
+
+// Start polls the feeds in the background until Stop
+func (p *Poller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.tick)
+		defer ticker.Stop()
+		for {
+			p.pollDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling, abandoning a poll in progress
+func (p *Poller) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// pollDue polls the subscriptions whose interval has passed
+func (p *Poller) pollDue(ctx context.Context) {
+	now := time.Now()
+	p.mu.Lock()
+	var due []*subscription
+	for _, sub := range p.subscriptions {
+		if !sub.nextPoll.After(now) {
+			due = append(due, sub)
+		}
+	}
+	p.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i].nextPoll.Before(due[j].nextPoll) })
+
+	for _, sub := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		p.poll(ctx, sub)
+	}
+}
+
+// poll fetches a feed and posts its new entries, oldest first
+func (p *Poller) poll(ctx context.Context, sub *subscription) {
+	key := subscriptionKey(sub.RoomID, sub.URL)
+	p.mu.Lock()
+	sub.nextPoll = time.Now().Add(p.intervalOf(sub.Subscription))
+	etag, lastModified := sub.etag, sub.lastModified
+	p.mu.Unlock()
+
+	feed, etag, lastModified, err := p.fetch(ctx, sub.URL, etag, lastModified)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		p.logger.Warn("Failed to poll feed %s for room %s: %v", sub.URL, sub.RoomID, err)
+		return
+	}
+	if feed == nil {
+		p.logger.Debug("Feed %s is unchanged", sub.URL)
+		return
+	}
+
+	p.mu.Lock()
+	if _, subscribed := p.subscriptions[key]; !subscribed {
+		// Unsubscribed while fetching
+		p.mu.Unlock()
+		return
+	}
+	sub.etag, sub.lastModified = etag, lastModified
+	if feed.Title != "" {
+		sub.Title = feed.Title
+	}
+	previous, known := p.seen[key]
+	var fresh []Item
+	if known {
+		fresh = unseen(feed.Items, previous)
+	}
+	p.seen[key] = mergeSeen(feed.Items, previous)
+	if err := p.save(); err != nil {
+		p.logger.Error("%v", err)
+	}
+	posted := sub.Subscription
+	p.mu.Unlock()
+
+	if !known {
+		// Entries published before the subscription are not posted
+		p.logger.Info("Feed %s has %d entries, posting entries published from now on", sub.URL, len(feed.Items))
+		return
+	}
+	if p.maxItems > 0 && len(fresh) > p.maxItems {
+		p.logger.Info("Feed %s has %d new entries, posting the newest %d", sub.URL, len(fresh), p.maxItems)
+		fresh = fresh[:p.maxItems]
+	}
+	for i := len(fresh) - 1; i >= 0; i-- {
+		p.post(posted, fresh[i])
+	}
+}
+
+func (p *Poller) intervalOf(sub Subscription) time.Duration {
+	if sub.Interval > 0 {
+		return time.Duration(sub.Interval) * time.Second
+	}
+	return p.interval
+}
+
+// fetch downloads and parses a feed. It returns a nil feed if the server
+// reports it unchanged since the given validators.
+func (p *Poller) fetch(ctx context.Context, feedURL, etag, lastModified string) (*Feed, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("User-Agent", "matrix-microservice feed poller")
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8, */*;q=0.5")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, lastModified, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", "", fmt.Errorf("feed returned status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read feed: %w", err)
+	}
+	feed, err := Parse(data)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return feed, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// unseen returns the items whose IDs are not in seen
+func unseen(items []Item, seen []string) []Item {
+	known := make(map[string]bool, len(seen))
+	for _, id := range seen {
+		known[id] = true
+	}
+	var fresh []Item
+	for _, item := range items {
+		if !known[item.ID] {
+			fresh = append(fresh, item)
+			known[item.ID] = true
+		}
+	}
+	return fresh
+}
+
+// mergeSeen returns the IDs of the items followed by the previously seen
+// IDs no longer in the feed, up to maxSeen of them
+func mergeSeen(items []Item, previous []string) []string {
+	merged := make([]string, 0, len(items)+len(previous))
+	included := make(map[string]bool)
+	for _, item := range items {
+		if !included[item.ID] {
+			merged = append(merged, item.ID)
+			included[item.ID] = true
+		}
+	}
+	for _, id := range previous {
+		if len(merged) >= maxSeen {
+			break
+		}
+		if !included[id] {
+			merged = append(merged, id)
+			included[id] = true
+		}
+	}
+	return merged
+}
+
+// Subscribe subscribes a room to a feed. The feed is fetched right away so
+// that invalid feeds are rejected; its current entries are not posted.
+func (p *Poller) Subscribe(ctx context.Context, roomID, feedURL, subscribedBy string, interval int) (Subscription, error) {
+	u, err := url.Parse(feedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("%q is not an http:// or https:// URL", feedURL)
+	}
+	key := subscriptionKey(roomID, feedURL)
+	p.mu.Lock()
+	_, exists := p.subscriptions[key]
+	p.mu.Unlock()
+	if exists {
+		return Subscription{}, ErrSubscribed
+	}
+
+	feed, etag, lastModified, err := p.fetch(ctx, feedURL, "", "")
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub := &subscription{
+		Subscription: Subscription{
+			URL:          feedURL,
+			RoomID:       roomID,
+			Interval:     interval,
+			SubscribedBy: subscribedBy,
+			SubscribedAt: time.Now().UTC(),
+			Title:        feed.Title,
+		},
+		etag:         etag,
+		lastModified: lastModified,
+	}
+	sub.nextPoll = time.Now().Add(p.intervalOf(sub.Subscription))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.subscriptions[key]; exists {
+		return Subscription{}, ErrSubscribed
+	}
+	p.subscriptions[key] = sub
+	previous := p.seen[key]
+	p.seen[key] = mergeSeen(feed.Items, nil)
+	if err := p.save(); err != nil {
+		delete(p.subscriptions, key)
+		p.seen[key] = previous
+		return Subscription{}, err
+	}
+	p.logger.Info("Subscribed room %s to feed %s", roomID, feedURL)
+	return sub.Subscription, nil
+}
+
+// Unsubscribe removes a subscription made at runtime. It reports whether the
+// room was subscribed to the feed.
+func (p *Poller) Unsubscribe(roomID, feedURL string) (bool, error) {
+	key := subscriptionKey(roomID, feedURL)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sub, exists := p.subscriptions[key]
+	if !exists {
+		return false, nil
+	}
+	if sub.Configured {
+		return true, ErrConfigured
+	}
+	delete(p.subscriptions, key)
+	seen := p.seen[key]
+	delete(p.seen, key)
+	if err := p.save(); err != nil {
+		p.subscriptions[key] = sub
+		p.seen[key] = seen
+		return true, err
+	}
+	p.logger.Info("Unsubscribed room %s from feed %s", roomID, feedURL)
+	return true, nil
+}
+
+// List returns the subscriptions of a room, or of all rooms if roomID is
+// empty, sorted by room and URL
+func (p *Poller) List(roomID string) []Subscription {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var subs []Subscription
+	for _, sub := range p.subscriptions {
+		if roomID == "" || sub.RoomID == roomID {
+			subs = append(subs, sub.Subscription)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].RoomID != subs[j].RoomID {
+			return subs[i].RoomID < subs[j].RoomID
+		}
+		return subs[i].URL < subs[j].URL
+	})
+	return subs
+}
+
+// save stores the state. Called with mu held.
+func (p *Poller) save() error {
+	stored := state{Seen: p.seen}
+	for _, sub := range p.subscriptions {
+		if !sub.Configured {
+			stored.Subscriptions = append(stored.Subscriptions, sub.Subscription)
+		}
+	}
+	sort.Slice(stored.Subscriptions, func(i, j int) bool {
+		return subscriptionKey(stored.Subscriptions[i].RoomID, stored.Subscriptions[i].URL) < subscriptionKey(stored.Subscriptions[j].RoomID, stored.Subscriptions[j].URL)
+	})
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := p.store.Put(context.Background(), storageKey, data); err != nil {
+		return fmt.Errorf("failed to save feed state: %w", err)
+	}
+	return nil
+}
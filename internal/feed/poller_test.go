@@ -0,0 +1,156 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// testFeed serves an RSS feed whose entries can be changed
+type testFeed struct {
+	mu    sync.Mutex
+	items []string // Newest first
+}
+
+func (f *testFeed) set(items ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = items
+}
+
+func (f *testFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprint(w, `<rss version="2.0"><channel><title>News</title>`)
+	for _, item := range f.items {
+		fmt.Fprintf(w, `<item><guid>%s</guid><title>%s</title></item>`, item, item)
+	}
+	fmt.Fprint(w, `</channel></rss>`)
+}
+
+// recorder collects posted entries
+type recorder struct {
+	mu     sync.Mutex
+	posted []string
+}
+
+func (r *recorder) post(sub Subscription, item Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posted = append(r.posted, sub.RoomID+":"+item.Title)
+}
+
+func (r *recorder) take() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	posted := strings.Join(r.posted, " ")
+	r.posted = nil
+	return posted
+}
+
+func newTestPoller(t *testing.T, cfg *config.FeedsConfig, store storage.Store, rec *recorder) *Poller {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	p, err := NewPoller(cfg, "!default:example.com", store, rec.post, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPollPostsNewEntries(t *testing.T) {
+	feed := &testFeed{}
+	feed.set("b", "a")
+	server := httptest.NewServer(feed)
+	defer server.Close()
+
+	cfg := &config.FeedsConfig{
+		Interval:      900,
+		MaxItems:      2,
+		Subscriptions: []config.FeedSubscriptionConfig{{URL: server.URL}},
+	}
+	rec := &recorder{}
+	store := storage.NewMemory()
+	p := newTestPoller(t, cfg, store, rec)
+	ctx := context.Background()
+
+	// Entries present at the first poll are not posted
+	p.pollDue(ctx)
+	if posted := rec.take(); posted != "" {
+		t.Errorf("first poll posted %s", posted)
+	}
+
+	// New entries are posted oldest first, at most max_items of them
+	feed.set("e", "d", "c", "b", "a")
+	p.poll(ctx, p.subscriptions[subscriptionKey("!default:example.com", server.URL)])
+	if posted := rec.take(); posted != "!default:example.com:d !default:example.com:e" {
+		t.Errorf("posted %s, want d and e", posted)
+	}
+
+	// Nothing is posted twice, also after a restart
+	p = newTestPoller(t, cfg, store, rec)
+	p.pollDue(ctx)
+	if posted := rec.take(); posted != "" {
+		t.Errorf("poll after restart posted %s", posted)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	feed := &testFeed{}
+	feed.set("a")
+	server := httptest.NewServer(feed)
+	defer server.Close()
+
+	cfg := &config.FeedsConfig{
+		Interval:      900,
+		Subscriptions: []config.FeedSubscriptionConfig{{URL: server.URL + "/configured", RoomID: "!ops:example.com"}},
+	}
+	rec := &recorder{}
+	store := storage.NewMemory()
+	p := newTestPoller(t, cfg, store, rec)
+	ctx := context.Background()
+
+	sub, err := p.Subscribe(ctx, "!dev:example.com", server.URL, "@admin:example.com", 0)
+	if err != nil || sub.Title != "News" {
+		t.Fatalf("Subscribe = %+v, %v", sub, err)
+	}
+	if _, err := p.Subscribe(ctx, "!dev:example.com", server.URL, "@admin:example.com", 0); !errors.Is(err, ErrSubscribed) {
+		t.Errorf("second Subscribe error = %v, want ErrSubscribed", err)
+	}
+	if _, err := p.Subscribe(ctx, "!dev:example.com", "ftp://example.com/feed", "@admin:example.com", 0); err == nil {
+		t.Error("Subscribe accepted an ftp URL")
+	}
+
+	// Subscriptions survive restarts and entries seen at subscription are
+	// not posted
+	feed.set("b", "a")
+	p = newTestPoller(t, cfg, store, rec)
+	if subs := p.List("!dev:example.com"); len(subs) != 1 || subs[0].SubscribedBy != "@admin:example.com" {
+		t.Fatalf("List after restart = %+v", subs)
+	}
+	p.poll(ctx, p.subscriptions[subscriptionKey("!dev:example.com", server.URL)])
+	if posted := rec.take(); posted != "!dev:example.com:b" {
+		t.Errorf("posted %s, want b", posted)
+	}
+
+	if _, err := p.Unsubscribe("!ops:example.com", server.URL+"/configured"); !errors.Is(err, ErrConfigured) {
+		t.Errorf("Unsubscribe of a configured feed error = %v", err)
+	}
+	if removed, err := p.Unsubscribe("!dev:example.com", server.URL); !removed || err != nil {
+		t.Errorf("Unsubscribe = %v, %v", removed, err)
+	}
+	if removed, _ := p.Unsubscribe("!dev:example.com", server.URL); removed {
+		t.Error("second Unsubscribe removed the subscription again")
+	}
+	if subs := p.List(""); len(subs) != 1 || !subs[0].Configured {
+		t.Errorf("List = %+v, want the configured feed only", subs)
+	}
+}
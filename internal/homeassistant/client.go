@@ -0,0 +1,128 @@
+// Package homeassistant calls Home Assistant services through its REST API
+// and receives its events through its WebSocket API, authenticating with a
+// long-lived access token
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// ErrNotFound is returned for entities and services that do not exist
+var ErrNotFound = errors.New("not found")
+
+// State is the state of an entity
+type State struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+}
+
+// Name returns the friendly name of the entity, else its ID
+func (s *State) Name() string {
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return s.EntityID
+}
+
+// Client calls the REST API of a Home Assistant instance
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client of the instance of cfg
+func NewClient(cfg *config.HomeAssistantConfig) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		token:   cfg.Token,
+		http:    &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+// CallService calls a service, e.g. light.turn_on, and returns the states of
+// the entities it changed
+func (c *Client) CallService(ctx context.Context, domain, service string, data map[string]interface{}) ([]State, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var states []State
+	if err := c.call(ctx, http.MethodPost, "/api/services/"+url.PathEscape(domain)+"/"+url.PathEscape(service), data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// GetState returns the state of an entity, ErrNotFound if there is none
+func (c *Client) GetState(ctx context.Context, entityID string) (*State, error) {
+	var state State
+	if err := c.call(ctx, http.MethodGet, "/api/states/"+url.PathEscape(entityID), nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// call sends a request to the API and decodes the JSON response into result
+func (c *Client) call(ctx context.Context, method, path string, params, result interface{}) error {
+	var body io.Reader
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if params != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("home assistant request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("home assistant request failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		// Errors are either JSON with a message or plain text
+		var problem struct {
+			Message string `json:"message"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &problem) == nil && problem.Message != "" {
+			message = problem.Message
+		}
+		if message != "" {
+			return fmt.Errorf("home assistant request failed with status %d: %s", resp.StatusCode, message)
+		}
+		return fmt.Errorf("home assistant request failed with status %d", resp.StatusCode)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
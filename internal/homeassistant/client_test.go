@@ -0,0 +1,65 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestClient(t *testing.T) {
+	var called map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/services/light/turn_on":
+			json.NewDecoder(r.Body).Decode(&called)
+			w.Write([]byte(`[{"entity_id": "light.kitchen", "state": "on", "attributes": {"friendly_name": "Kitchen"}}]`))
+		case "POST /api/services/light/explode":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Service not found."}`))
+		case "GET /api/states/sensor.temperature":
+			w.Write([]byte(`{"entity_id": "sensor.temperature", "state": "21.5", "attributes": {"unit_of_measurement": "°C"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Entity not found."}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.HomeAssistantConfig{URL: server.URL + "/", Token: "token", Timeout: 5})
+	ctx := context.Background()
+
+	states, err := client.CallService(ctx, "light", "turn_on", map[string]interface{}{"entity_id": "light.kitchen"})
+	if err != nil {
+		t.Fatalf("CallService() error = %v", err)
+	}
+	if len(states) != 1 || states[0].Name() != "Kitchen" || states[0].State != "on" {
+		t.Errorf("CallService() = %+v", states)
+	}
+	if called["entity_id"] != "light.kitchen" {
+		t.Errorf("service data = %v", called)
+	}
+	if _, err := client.CallService(ctx, "light", "explode", nil); err == nil || !strings.Contains(err.Error(), "Service not found.") {
+		t.Errorf("CallService() error = %v, want the Home Assistant message", err)
+	}
+
+	state, err := client.GetState(ctx, "sensor.temperature")
+	if err != nil {
+		t.Fatalf("GetState() error = %v", err)
+	}
+	if state.State != "21.5" || state.Name() != "sensor.temperature" {
+		t.Errorf("GetState() = %+v", state)
+	}
+	if _, err := client.GetState(ctx, "sensor.missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetState() error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,185 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"golang.org/x/net/websocket"
+)
+
+// Wait after a lost or failed connection before connecting again
+const retryDelay = 5 * time.Second
+
+// EventStateChanged is the type of the events of state changes
+const EventStateChanged = "state_changed"
+
+// Event is an event of the Home Assistant event bus
+type Event struct {
+	EventType string          `json:"event_type"`
+	Data      json.RawMessage `json:"data"`
+	TimeFired time.Time       `json:"time_fired"`
+}
+
+// StateChange is the data of a state_changed event. OldState is nil for new
+// entities, NewState for removed ones.
+type StateChange struct {
+	EntityID string `json:"entity_id"`
+	OldState *State `json:"old_state"`
+	NewState *State `json:"new_state"`
+}
+
+// StateChange decodes the data of a state_changed event
+func (e *Event) StateChange() (*StateChange, error) {
+	var change StateChange
+	if err := json.Unmarshal(e.Data, &change); err != nil {
+		return nil, fmt.Errorf("invalid state_changed event: %w", err)
+	}
+	return &change, nil
+}
+
+// HandleFunc handles an event the watcher subscribed to
+type HandleFunc func(e *Event)
+
+// message is a message of the WebSocket API
+type message struct {
+	ID          int    `json:"id,omitempty"`
+	Type        string `json:"type"`
+	AccessToken string `json:"access_token,omitempty"`
+	EventType   string `json:"event_type,omitempty"`
+	Success     *bool  `json:"success,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Error       *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Event *Event `json:"event,omitempty"`
+}
+
+// Watcher subscribes to events through the WebSocket API, reconnecting
+// whenever the connection is lost
+type Watcher struct {
+	url        string
+	origin     string
+	token      string
+	eventTypes []string
+	handle     HandleFunc
+	logger     *logger.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWatcher creates a watcher of the events of eventTypes of the instance
+// of cfg, handing them to handle
+func NewWatcher(cfg *config.HomeAssistantConfig, eventTypes []string, handle HandleFunc, log *logger.Logger) *Watcher {
+	base := strings.TrimSuffix(cfg.URL, "/")
+	wsURL := "ws" + strings.TrimPrefix(base, "http") + "/api/websocket"
+	return &Watcher{
+		url:        wsURL,
+		origin:     base,
+		token:      cfg.Token,
+		eventTypes: eventTypes,
+		handle:     handle,
+		logger:     log,
+	}
+}
+
+// Start receives events in the background until Stop. Events fired while
+// disconnected are missed.
+func (w *Watcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		for {
+			err := w.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			w.logger.Warn("Lost the Home Assistant connection: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}()
+}
+
+// Stop stops receiving events, closing the connection
+func (w *Watcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// watch connects, authenticates and subscribes, then hands events to the
+// handler until the connection fails or ctx is done
+func (w *Watcher) watch(ctx context.Context) error {
+	wsConfig, err := websocket.NewConfig(w.url, w.origin)
+	if err != nil {
+		return err
+	}
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Unblock the receive below when stopped
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var msg message
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("unexpected %q message instead of auth_required", msg.Type)
+	}
+	if err := websocket.JSON.Send(conn, message{Type: "auth", AccessToken: w.token}); err != nil {
+		return err
+	}
+	msg = message{}
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		return err
+	}
+	if msg.Type != "auth_ok" {
+		return fmt.Errorf("authentication failed: %s %s", msg.Type, msg.Message)
+	}
+
+	// Subscriptions are numbered from 1, in the order of eventTypes
+	for i, eventType := range w.eventTypes {
+		if err := websocket.JSON.Send(conn, message{ID: i + 1, Type: "subscribe_events", EventType: eventType}); err != nil {
+			return err
+		}
+	}
+	w.logger.Info("Connected to Home Assistant, watching %s", strings.Join(w.eventTypes, ", "))
+
+	for {
+		msg = message{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "result":
+			if msg.Success != nil && !*msg.Success && msg.ID >= 1 && msg.ID <= len(w.eventTypes) {
+				reason := ""
+				if msg.Error != nil {
+					reason = msg.Error.Message
+				}
+				return fmt.Errorf("subscribing to %s failed: %s", w.eventTypes[msg.ID-1], reason)
+			}
+		case "event":
+			if msg.Event != nil {
+				w.handle(msg.Event)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package homeassistant
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"golang.org/x/net/websocket"
+)
+
+// fakeHomeAssistant serves the WebSocket API, sending a state change to
+// every subscription
+func fakeHomeAssistant(subscribed chan<- string) *httptest.Server {
+	return httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		websocket.JSON.Send(conn, map[string]string{"type": "auth_required"})
+		var auth message
+		if err := websocket.JSON.Receive(conn, &auth); err != nil {
+			return
+		}
+		if auth.AccessToken != "token" {
+			websocket.JSON.Send(conn, map[string]string{"type": "auth_invalid", "message": "Invalid access token"})
+			return
+		}
+		websocket.JSON.Send(conn, map[string]string{"type": "auth_ok"})
+		for {
+			var msg message
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				return
+			}
+			subscribed <- msg.EventType
+			websocket.JSON.Send(conn, map[string]interface{}{"id": msg.ID, "type": "result", "success": true})
+			websocket.JSON.Send(conn, map[string]interface{}{"id": msg.ID, "type": "event", "event": map[string]interface{}{
+				"event_type": msg.EventType,
+				"data": map[string]interface{}{
+					"entity_id": "binary_sensor.front_door",
+					"old_state": map[string]string{"entity_id": "binary_sensor.front_door", "state": "off"},
+					"new_state": map[string]string{"entity_id": "binary_sensor.front_door", "state": "on"},
+				},
+			}})
+		}
+	}))
+}
+
+func TestWatcher(t *testing.T) {
+	subscribed := make(chan string, 1)
+	server := fakeHomeAssistant(subscribed)
+	defer server.Close()
+
+	events := make(chan *Event, 1)
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	watcher := NewWatcher(&config.HomeAssistantConfig{URL: server.URL, Token: "token"}, []string{EventStateChanged}, func(e *Event) { events <- e }, log)
+	watcher.Start()
+	defer watcher.Stop()
+
+	select {
+	case eventType := <-subscribed:
+		if eventType != EventStateChanged {
+			t.Errorf("subscribed to %q, want state_changed", eventType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription")
+	}
+	select {
+	case e := <-events:
+		change, err := e.StateChange()
+		if err != nil {
+			t.Fatalf("StateChange() error = %v", err)
+		}
+		if change.EntityID != "binary_sensor.front_door" || change.OldState.State != "off" || change.NewState.State != "on" {
+			t.Errorf("StateChange() = %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+}
+
+func TestWatcherInvalidToken(t *testing.T) {
+	server := fakeHomeAssistant(make(chan string, 1))
+	defer server.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	watcher := NewWatcher(&config.HomeAssistantConfig{URL: server.URL, Token: "wrong"}, []string{EventStateChanged}, func(e *Event) {}, log)
+	err := watcher.watch(context.Background())
+	if err == nil || err.Error() != "authentication failed: auth_invalid Invalid access token" {
+		t.Errorf("watch() error = %v, want an authentication error", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package i18n holds the message catalogs of the bot's own replies: errors,
+// usage help and notices. A catalog maps message keys to fmt formats and is
+// kept per language; messages missing in a catalog fall back to English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fallback is the language of messages missing in the catalog of a room's
+// language
+const Fallback = "en"
+
+//go:embed catalogs/*.json
+var builtinFiles embed.FS
+
+var (
+	builtinOnce sync.Once
+	builtin     *Catalogs
+)
+
+// Catalogs are the messages of each language, by key
+type Catalogs struct {
+	messages map[string]map[string]string
+}
+
+// Builtin returns the catalogs shipped with the service
+func Builtin() *Catalogs {
+	builtinOnce.Do(func() {
+		builtin = &Catalogs{messages: make(map[string]map[string]string)}
+		entries, _ := builtinFiles.ReadDir("catalogs")
+		for _, entry := range entries {
+			data, err := builtinFiles.ReadFile(path.Join("catalogs", entry.Name()))
+			if err == nil {
+				err = builtin.add(entry.Name(), data)
+			}
+			if err != nil {
+				panic(fmt.Sprintf("i18n: built-in catalog %s: %v", entry.Name(), err))
+			}
+		}
+	})
+	return builtin
+}
+
+// Load returns the built-in catalogs with the <language>.json files of dir
+// added to them; their messages replace the built-in ones of the same key.
+// An empty dir loads the built-in catalogs only.
+func Load(dir string) (*Catalogs, error) {
+	c := &Catalogs{messages: make(map[string]map[string]string)}
+	for language, messages := range Builtin().messages {
+		c.messages[language] = make(map[string]string, len(messages))
+		for key, text := range messages {
+			c.messages[language][key] = text
+		}
+	}
+	if dir == "" {
+		return c, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no <language>.json catalogs in %s", dir)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.add(filepath.Base(file), data); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return c, nil
+}
+
+// add merges the catalog file name, a JSON object of key to message
+func (c *Catalogs) add(name string, data []byte) error {
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+	language := normalize(strings.TrimSuffix(name, ".json"))
+	if c.messages[language] == nil {
+		c.messages[language] = make(map[string]string, len(messages))
+	}
+	for key, text := range messages {
+		c.messages[language][key] = text
+	}
+	return nil
+}
+
+// Languages returns the languages with a catalog, sorted
+func (c *Catalogs) Languages() []string {
+	languages := make([]string, 0, len(c.messages))
+	for language := range c.messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Has reports whether language, or the language it is a variant of, has a
+// catalog
+func (c *Catalogs) Has(language string) bool {
+	language = normalize(language)
+	base, _, _ := strings.Cut(language, "-")
+	return c.messages[language] != nil || c.messages[base] != nil
+}
+
+// Text formats the message key in language with args. The message is looked
+// up in the catalog of the language (pt-BR), of the language it is a variant
+// of (pt) and in English, in that order; the key itself is returned if none
+// has it. Nil catalogs are the built-in ones.
+func (c *Catalogs) Text(language, key string, args ...interface{}) string {
+	if c == nil {
+		c = Builtin()
+	}
+	language = normalize(language)
+	base, _, _ := strings.Cut(language, "-")
+	for _, lang := range []string{language, base, Fallback} {
+		if text, exists := c.messages[lang][key]; exists {
+			if len(args) == 0 {
+				return text
+			}
+			return fmt.Sprintf(text, args...)
+		}
+	}
+	return key
+}
+
+// normalize lowercases a language code, pt_BR and pt-BR both become pt-br
+func normalize(language string) string {
+	return strings.ToLower(strings.ReplaceAll(language, "_", "-"))
+}
@@ -0,0 +1,103 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	c := Builtin()
+	tests := []struct {
+		language, key string
+		args          []interface{}
+		want          string
+	}{
+		{"en", "command.denied", []interface{}{"deploy"}, "You are not allowed to run /deploy."},
+		{"de", "command.denied", []interface{}{"deploy"}, "Du darfst /deploy nicht ausführen."},
+		{"de-AT", "command.denied", []interface{}{"deploy"}, "Du darfst /deploy nicht ausführen."},
+		{"DE_ch", "share.usage", nil, "Verwendung: `/share @user:server [@user2:server ...]`"},
+		{"fr", "command.denied", []interface{}{"deploy"}, "You are not allowed to run /deploy."},
+		{"", "queue.behind_one", nil, "Queued behind 1 running command"},
+		{"de", "no.such.key", nil, "no.such.key"},
+	}
+	for _, tt := range tests {
+		if got := c.Text(tt.language, tt.key, tt.args...); got != tt.want {
+			t.Errorf("Text(%q, %q) = %q, want %q", tt.language, tt.key, got, tt.want)
+		}
+	}
+
+	var nilCatalogs *Catalogs
+	if got := nilCatalogs.Text("de", "share.done", "@bob:example.com"); got != "Sitzung geteilt mit @bob:example.com" {
+		t.Errorf("Text() of nil catalogs = %q, want the built-in message", got)
+	}
+}
+
+// verbRegex matches the verbs of a format
+var verbRegex = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+// TestCatalogsMatchEnglish checks that every message of the built-in
+// catalogs has an English one and takes its arguments
+func TestCatalogsMatchEnglish(t *testing.T) {
+	c := Builtin()
+	english := c.messages[Fallback]
+	for _, language := range c.Languages() {
+		for key := range c.messages[language] {
+			format, exists := english[key]
+			if !exists {
+				t.Errorf("%s: %s has no English message", language, key)
+				continue
+			}
+			var args []interface{}
+			for _, verb := range verbRegex.FindAllString(format, -1) {
+				switch verb[len(verb)-1] {
+				case '%':
+				case 'd':
+					args = append(args, 1)
+				default:
+					args = append(args, "x")
+				}
+			}
+			if got := c.Text(language, key, args...); strings.Contains(got, "%!") {
+				t.Errorf("%s: %s = %q, the arguments do not match the English message", language, key, got)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"share.usage": "Teilen mit: /share @user:server"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "pt-BR.json"), []byte(`{"command.denied": "Você não pode executar /%s."}`), 0644)
+
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := c.Text("de", "share.usage"); got != "Teilen mit: /share @user:server" {
+		t.Errorf("replaced message = %q", got)
+	}
+	if got := c.Text("de", "share.not_owner", "@alice:example.com"); got != "Nur die Person, der die Sitzung gehört (@alice:example.com), kann sie teilen." {
+		t.Errorf("built-in message next to a replaced one = %q", got)
+	}
+	if got := c.Text("pt-BR", "command.denied", "deploy"); got != "Você não pode executar /deploy." {
+		t.Errorf("added language = %q", got)
+	}
+	if !c.Has("pt-br") || !c.Has("de-AT") || c.Has("fr") {
+		t.Errorf("Languages() = %v", c.Languages())
+	}
+	// The built-in catalogs are left alone
+	if got := Builtin().Text("de", "share.usage"); got == "Teilen mit: /share @user:server" {
+		t.Error("Load() changed the built-in catalogs")
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Error("Load() of a broken catalog succeeded")
+	}
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Error("Load() of a directory without catalogs succeeded")
+	}
+}
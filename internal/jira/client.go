@@ -0,0 +1,214 @@
+// Package jira reads, creates, comments on and transitions Jira issues
+// through the Jira REST API v2
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// ErrNotFound is returned for issues that do not exist or that the account
+// cannot see
+var ErrNotFound = errors.New("issue not found")
+
+// Issue is an issue with the fields the replies show
+type Issue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary   string `json:"summary"`
+		Status    *named `json:"status"`
+		IssueType *named `json:"issuetype"`
+		Priority  *named `json:"priority"`
+		Assignee  *struct {
+			DisplayName string `json:"displayName"`
+		} `json:"assignee"`
+	} `json:"fields"`
+}
+
+// named is a Jira object shown by its name, e.g. a status
+type named struct {
+	Name string `json:"name"`
+}
+
+// Status returns the name of the status of the issue
+func (i *Issue) Status() string {
+	if i.Fields.Status == nil {
+		return ""
+	}
+	return i.Fields.Status.Name
+}
+
+// Assignee returns the display name of the assignee, empty if unassigned
+func (i *Issue) Assignee() string {
+	if i.Fields.Assignee == nil {
+		return ""
+	}
+	return i.Fields.Assignee.DisplayName
+}
+
+// Transition moves an issue to another status
+type Transition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   named  `json:"to"`
+}
+
+// Client calls the REST API of a Jira site
+type Client struct {
+	baseURL string
+	email   string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client of the site of cfg. With an email the token is
+// an API token of that Atlassian account (Jira Cloud), else a personal
+// access token (Jira Data Center).
+func NewClient(cfg *config.JiraConfig) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		email:   cfg.Email,
+		token:   cfg.Token,
+		http:    &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+// BrowseURL returns the web page of an issue
+func (c *Client) BrowseURL(key string) string {
+	return c.baseURL + "/browse/" + url.PathEscape(key)
+}
+
+// GetIssue returns an issue by key, ErrNotFound if there is none
+func (c *Client) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	var issue Issue
+	query := url.Values{"fields": {"summary,status,issuetype,priority,assignee"}}
+	if err := c.call(ctx, http.MethodGet, "/issue/"+url.PathEscape(key)+"?"+query.Encode(), nil, &issue); err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// CreateIssue creates an issue in a project and returns its key
+func (c *Client) CreateIssue(ctx context.Context, project, issueType, summary, description string) (string, error) {
+	fields := map[string]interface{}{
+		"project":   map[string]string{"key": project},
+		"issuetype": map[string]string{"name": issueType},
+		"summary":   summary,
+	}
+	if description != "" {
+		fields["description"] = description
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+		return "", err
+	}
+	return created.Key, nil
+}
+
+// AddComment comments on an issue
+func (c *Client) AddComment(ctx context.Context, key, body string) error {
+	return c.call(ctx, http.MethodPost, "/issue/"+url.PathEscape(key)+"/comment", map[string]string{"body": body}, nil)
+}
+
+// Transitions returns the transitions available to an issue
+func (c *Client) Transitions(ctx context.Context, key string) ([]Transition, error) {
+	var result struct {
+		Transitions []Transition `json:"transitions"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/issue/"+url.PathEscape(key)+"/transitions", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Transitions, nil
+}
+
+// Transition applies a transition to an issue
+func (c *Client) Transition(ctx context.Context, key, transitionID string) error {
+	return c.call(ctx, http.MethodPost, "/issue/"+url.PathEscape(key)+"/transitions", map[string]interface{}{
+		"transition": map[string]string{"id": transitionID},
+	}, nil)
+}
+
+// call sends a request to the API and decodes the JSON response into
+// result, unless it is nil
+func (c *Client) call(ctx context.Context, method, path string, params, result interface{}) error {
+	var body io.Reader
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/rest/api/2"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if params != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.email != "" {
+		req.SetBasicAuth(c.email, c.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("jira request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("jira request failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("jira request failed with status %d%s", resp.StatusCode, explain(data))
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// explain returns the messages of a Jira error response, e.g.
+// ": summary: You must specify a summary of the issue."
+func explain(data []byte) string {
+	var problem struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(data, &problem) != nil {
+		return ""
+	}
+	messages := problem.ErrorMessages
+	fields := make([]string, 0, len(problem.Errors))
+	for field := range problem.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, field+": "+problem.Errors[field])
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,114 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestClient(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, ok := r.BasicAuth(); !ok || user != "bot@example.com" || token != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /rest/api/2/issue/OPS-1":
+			w.Write([]byte(`{"key": "OPS-1", "fields": {"summary": "Disk full", "status": {"name": "In Progress"}, "assignee": {"displayName": "Alice"}}}`))
+		case "POST /rest/api/2/issue":
+			if fields, _ := body["fields"].(map[string]interface{}); fields["summary"] == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errorMessages": [], "errors": {"summary": "You must specify a summary of the issue."}}`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "10001", "key": "OPS-2"}`))
+		case "POST /rest/api/2/issue/OPS-1/comment":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "1"}`))
+		case "GET /rest/api/2/issue/OPS-1/transitions":
+			w.Write([]byte(`{"transitions": [{"id": "31", "name": "Finish", "to": {"name": "Done"}}]}`))
+		case "POST /rest/api/2/issue/OPS-1/transitions":
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /rest/api/2/issue/OPS-404/comment":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errorMessages": ["Issue does not exist or you do not have permission to see it."]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.JiraConfig{URL: server.URL + "/", Email: "bot@example.com", Token: "secret", Timeout: 5})
+	ctx := context.Background()
+
+	issue, err := client.GetIssue(ctx, "OPS-1")
+	if err != nil {
+		t.Fatalf("GetIssue() error = %v", err)
+	}
+	if issue.Fields.Summary != "Disk full" || issue.Status() != "In Progress" || issue.Assignee() != "Alice" {
+		t.Errorf("GetIssue() = %+v", issue)
+	}
+
+	key, err := client.CreateIssue(ctx, "OPS", "Task", "Rotate keys", "Before Friday")
+	if err != nil || key != "OPS-2" {
+		t.Errorf("CreateIssue() = %q, %v, want OPS-2", key, err)
+	}
+	fields, _ := bodies[1]["fields"].(map[string]interface{})
+	if fields["summary"] != "Rotate keys" || fields["description"] != "Before Friday" {
+		t.Errorf("created fields = %v", fields)
+	}
+
+	if err := client.AddComment(ctx, "OPS-1", "Looking"); err != nil {
+		t.Errorf("AddComment() error = %v", err)
+	}
+	transitions, err := client.Transitions(ctx, "OPS-1")
+	if err != nil || len(transitions) != 1 || transitions[0].To.Name != "Done" {
+		t.Errorf("Transitions() = %+v, %v", transitions, err)
+	}
+	if err := client.Transition(ctx, "OPS-1", "31"); err != nil {
+		t.Errorf("Transition() error = %v", err)
+	}
+
+	if err := client.AddComment(ctx, "OPS-404", "Hello"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddComment() error = %v, want ErrNotFound", err)
+	}
+	_, err = client.CreateIssue(ctx, "OPS", "Task", "", "")
+	if err == nil {
+		t.Fatal("CreateIssue() succeeded with the server rejecting it")
+	}
+	if !strings.Contains(err.Error(), "summary: You must specify a summary of the issue.") {
+		t.Errorf("CreateIssue() error = %v, want the Jira error", err)
+	}
+	if client.BrowseURL("OPS-1") != server.URL+"/browse/OPS-1" {
+		t.Errorf("BrowseURL() = %q", client.BrowseURL("OPS-1"))
+	}
+}
+
+func TestClientBearerToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"key": "OPS-1", "fields": {"summary": "Disk full"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.JiraConfig{URL: server.URL, Token: "pat", Timeout: 5})
+	if _, err := client.GetIssue(context.Background(), "OPS-1"); err != nil {
+		t.Fatalf("GetIssue() error = %v", err)
+	}
+	if authorization != "Bearer pat" {
+		t.Errorf("Authorization = %q, want a bearer token", authorization)
+	}
+}
@@ -0,0 +1,177 @@
+// Package llm answers messages with an OpenAI-compatible chat completions
+// API, keeping the history of each conversation
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+// Roles of chat messages
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ChatMessage is a message of a chat completions request
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message ChatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// Client sends messages to the model along with their conversation history
+type Client struct {
+	cfg    *config.LLMConfig
+	client *http.Client
+	logger *logger.Logger
+
+	mutex         sync.Mutex
+	conversations map[string]*conversation
+}
+
+// conversation is the history of a room or thread, without the system prompt
+type conversation struct {
+	messages []ChatMessage
+	lastUsed time.Time
+}
+
+func New(cfg *config.LLMConfig, log *logger.Logger) *Client {
+	log.Info("Initializing LLM backend with model %s at %s", cfg.Model, cfg.BaseURL)
+	return &Client{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		logger:        log,
+		conversations: make(map[string]*conversation),
+	}
+}
+
+// Endpoint returns the chat completions URL
+func (c *Client) Endpoint() string {
+	return strings.TrimSuffix(c.cfg.BaseURL, "/") + "/chat/completions"
+}
+
+// Chat sends prompt as the next user message of a conversation and returns
+// the answer of the model. The exchange is added to the history only if it
+// succeeds.
+func (c *Client) Chat(ctx context.Context, conversationKey, prompt string) (string, error) {
+	log := c.logger.Ctx(ctx)
+	history := c.history(conversationKey)
+
+	messages := make([]ChatMessage, 0, len(history)+2)
+	if c.cfg.SystemPrompt != "" {
+		messages = append(messages, ChatMessage{Role: RoleSystem, Content: c.cfg.SystemPrompt})
+	}
+	messages = append(messages, history...)
+	messages = append(messages, ChatMessage{Role: RoleUser, Content: prompt})
+
+	body, err := json.Marshal(chatRequest{Model: c.cfg.Model, Messages: messages, Temperature: c.cfg.Temperature})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		req.Header.Set(requestid.Header, requestID)
+	}
+
+	log.Info("Sending %d messages to model %s", len(messages), c.cfg.Model)
+	startTime := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send chat completion request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	log.Info("Chat completion response status: %d (Duration: %v)", resp.StatusCode, time.Since(startTime))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail := string(respBody)
+		if len(detail) > 500 {
+			detail = detail[:500] + "... (truncated)"
+		}
+		return "", fmt.Errorf("chat completion returned status code: %d (Response: %s)", resp.StatusCode, detail)
+	}
+
+	var completion chatResponse
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		return "", fmt.Errorf("invalid chat completion response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("chat completion response has no choices")
+	}
+	answer := completion.Choices[0].Message.Content
+	log.Debug("Model answered: %s", log.Message(answer))
+
+	c.remember(conversationKey, ChatMessage{Role: RoleUser, Content: prompt}, ChatMessage{Role: RoleAssistant, Content: answer})
+	return answer, nil
+}
+
+// history returns a copy of the history of a conversation, forgetting the
+// conversations idle for longer than history_ttl
+func (c *Client) history(key string) []ChatMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ttl := time.Duration(c.cfg.HistoryTTL) * time.Second
+	for k, conv := range c.conversations {
+		if time.Since(conv.lastUsed) > ttl {
+			delete(c.conversations, k)
+		}
+	}
+	conv, exists := c.conversations[key]
+	if !exists {
+		return nil
+	}
+	return append([]ChatMessage(nil), conv.messages...)
+}
+
+// remember appends messages to a conversation, keeping its last max_history
+// messages
+func (c *Client) remember(key string, messages ...ChatMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	conv, exists := c.conversations[key]
+	if !exists {
+		conv = &conversation{}
+		c.conversations[key] = conv
+	}
+	conv.messages = append(conv.messages, messages...)
+	if excess := len(conv.messages) - c.cfg.MaxHistory; excess > 0 {
+		conv.messages = append([]ChatMessage(nil), conv.messages[excess:]...)
+	}
+	conv.lastUsed = time.Now()
+}
@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// echoServer answers every request with the number of messages it was sent
+// and the content of the last one, and records the requests
+func echoServer(t *testing.T, requests *[]chatRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		*requests = append(*requests, req)
+		last := req.Messages[len(req.Messages)-1].Content
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": "re: " + last}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testClient(baseURL string) *Client {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	return New(&config.LLMConfig{
+		BaseURL:      baseURL,
+		APIKey:       "sk-test",
+		Model:        "gpt-test",
+		SystemPrompt: "Be brief.",
+		Temperature:  0.2,
+		MaxHistory:   2,
+		HistoryTTL:   60,
+		Timeout:      5,
+	}, log)
+}
+
+func TestChatKeepsHistory(t *testing.T) {
+	var requests []chatRequest
+	server := echoServer(t, &requests)
+	c := testClient(server.URL + "/v1/")
+	ctx := context.Background()
+
+	for _, prompt := range []string{"one", "two", "three"} {
+		answer, err := c.Chat(ctx, "!room", prompt)
+		if err != nil || answer != "re: "+prompt {
+			t.Fatalf("Chat(%q) = %q, %v", prompt, answer, err)
+		}
+	}
+	if _, err := c.Chat(ctx, "!other", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// System prompt, the last max_history messages and the prompt
+	var roles []string
+	for _, m := range requests[2].Messages {
+		roles = append(roles, m.Role+":"+m.Content)
+	}
+	want := "system:Be brief. user:two assistant:re: two user:three"
+	if got := strings.Join(roles, " "); got != want {
+		t.Errorf("third request = %s, want %s", got, want)
+	}
+	if requests[2].Model != "gpt-test" || requests[2].Temperature != 0.2 {
+		t.Errorf("request for model %s at temperature %g", requests[2].Model, requests[2].Temperature)
+	}
+	// Conversations are separate
+	if n := len(requests[3].Messages); n != 2 {
+		t.Errorf("first request of another conversation has %d messages, want 2", n)
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+	var requests []chatRequest
+	server := echoServer(t, &requests)
+	c := testClient(server.URL)
+	ctx := context.Background()
+
+	// Wrong path
+	if _, err := c.Chat(ctx, "!room", "hello"); err == nil || !strings.Contains(err.Error(), "status code: 401") {
+		t.Fatalf("Chat error = %v, want the status code", err)
+	}
+	// Failed exchanges are not remembered
+	if _, ok := c.conversations["!room"]; ok {
+		t.Error("failed exchange added to the history")
+	}
+}
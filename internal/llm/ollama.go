@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+type ollamaChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// ollamaChunk is a line of a streamed /api/chat response
+type ollamaChunk struct {
+	Message ChatMessage `json:"message"`
+	Done    bool        `json:"done"`
+	Error   string      `json:"error"`
+}
+
+// Ollama streams answers from the models of an Ollama server. Conversation
+// history is kept by the caller.
+type Ollama struct {
+	url    string
+	client *http.Client // Without timeout, answers are bounded by the context
+	logger *logger.Logger
+}
+
+func NewOllama(cfg *config.OllamaConfig, log *logger.Logger) *Ollama {
+	log.Info("Initializing Ollama backend at %s", cfg.URL)
+	return &Ollama{url: strings.TrimSuffix(cfg.URL, "/"), client: &http.Client{}, logger: log}
+}
+
+// Endpoint returns the chat URL
+func (o *Ollama) Endpoint() string {
+	return o.url + "/api/chat"
+}
+
+// ChatStream sends messages to model and returns its answer. onText is
+// called with the answer so far each time more of it arrives.
+func (o *Ollama) ChatStream(ctx context.Context, model string, messages []ChatMessage, onText func(answer string)) (string, error) {
+	log := o.logger.Ctx(ctx)
+
+	body, err := json.Marshal(ollamaChatRequest{Model: model, Messages: messages, Stream: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		req.Header.Set(requestid.Header, requestID)
+	}
+
+	log.Info("Sending %d messages to Ollama model %s", len(messages), model)
+	startTime := time.Now()
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send chat request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		var chunk ollamaChunk
+		if json.Unmarshal(detail, &chunk) == nil && chunk.Error != "" {
+			return "", fmt.Errorf("ollama returned status code: %d: %s", resp.StatusCode, chunk.Error)
+		}
+		return "", fmt.Errorf("ollama returned status code: %d (Response: %s)", resp.StatusCode, detail)
+	}
+
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return "", fmt.Errorf("invalid chat response line: %w", err)
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			answer.WriteString(chunk.Message.Content)
+			onText(answer.String())
+		}
+		if chunk.Done {
+			log.Info("Ollama model %s answered in %v", model, time.Since(startTime))
+			return answer.String(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read chat response: %w", err)
+	}
+	return "", fmt.Errorf("chat response ended before the answer was done")
+}
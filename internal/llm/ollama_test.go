@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/api/chat" || !req.Stream {
+			http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Model != "llama3.2" {
+			http.Error(w, fmt.Sprintf(`{"error": "model %q not found"}`, req.Model), http.StatusNotFound)
+			return
+		}
+		for _, token := range []string{"Hel", "lo", "!"} {
+			fmt.Fprintf(w, `{"message": {"role": "assistant", "content": %q}, "done": false}`+"\n", token)
+		}
+		fmt.Fprintln(w, `{"message": {"role": "assistant", "content": ""}, "done": true}`)
+	}))
+	defer server.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	o := NewOllama(&config.OllamaConfig{URL: server.URL + "/"}, log)
+	ctx := context.Background()
+	messages := []ChatMessage{{Role: RoleUser, Content: "Hi"}}
+
+	var updates []string
+	answer, err := o.ChatStream(ctx, "llama3.2", messages, func(text string) { updates = append(updates, text) })
+	if err != nil || answer != "Hello!" {
+		t.Fatalf("ChatStream = %q, %v", answer, err)
+	}
+	if got := strings.Join(updates, "|"); got != "Hel|Hello|Hello!" {
+		t.Errorf("updates = %s", got)
+	}
+
+	if _, err := o.ChatStream(ctx, "missing", messages, func(string) {}); err == nil || !strings.Contains(err.Error(), `model "missing" not found`) {
+		t.Errorf("ChatStream error = %v, want the error of the server", err)
+	}
+}
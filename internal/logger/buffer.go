@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is a log line kept in memory for the admin API
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	Level     string            `json:"level"`
+	Component string            `json:"component,omitempty"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"` // Correlation fields, see With
+}
+
+// ringBuffer keeps the most recent entries, overwriting the oldest
+type ringBuffer struct {
+	mutex   sync.Mutex
+	entries []Entry
+	next    int  // Index the next entry is written to
+	full    bool // Whether entries has wrapped around
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &ringBuffer{entries: make([]Entry, size)}
+}
+
+func (b *ringBuffer) add(entry Entry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// WithComponent returns a logger whose entries are attributed to a part of
+// the service, e.g. "matrix" or "webhook", for filtering in the admin API
+func (l *Logger) WithComponent(component string) *Logger {
+	tagged := *l
+	tagged.component = component
+	return &tagged
+}
+
+func (l *Logger) entry(level LogLevel, message string) Entry {
+	entry := Entry{Time: time.Now(), Level: level.String(), Component: l.component, Message: message}
+	if len(l.fields) > 0 {
+		entry.Fields = make(map[string]string, len(l.fields))
+		for _, f := range l.fields {
+			entry.Fields[f.key] = l.redact(f.value)
+		}
+	}
+	return entry
+}
+
+// BufferEnabled reports whether recent entries are kept (logging.buffer_size)
+func (l *Logger) BufferEnabled() bool {
+	return l.buffer != nil
+}
+
+// Recent returns up to limit of the most recent entries at or above
+// minLevel, oldest first. An empty component matches every component;
+// limit 0 returns all matching entries.
+func (l *Logger) Recent(minLevel LogLevel, component string, limit int) []Entry {
+	if l.buffer == nil {
+		return nil
+	}
+	b := l.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	count, start := b.next, 0
+	if b.full {
+		count, start = len(b.entries), b.next
+	}
+	var matched []Entry
+	// Newest first, so that the limit keeps the most recent
+	for i := count - 1; i >= 0 && (limit <= 0 || len(matched) < limit); i-- {
+		entry := b.entries[(start+i)%len(b.entries)]
+		level, _ := ParseLevel(entry.Level)
+		if level < minLevel || (component != "" && entry.Component != component) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
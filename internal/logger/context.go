@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+// field is a correlation field, e.g. the room or event a line is about.
+// Lines show them as "[key=value ...]", journal entries as fields.
+type field struct {
+	key   string
+	value string
+}
+
+type contextKey struct{}
+
+// With returns a logger that tags every message with the given key/value
+// pairs, e.g. With("room_id", roomID, "event_id", eventID). Pairs with an
+// empty value are left out; a key that is already set is replaced.
+func (l *Logger) With(keyValues ...string) *Logger {
+	fields := appendFields(l.fields, keyValues)
+	if len(fields) == 0 {
+		return l
+	}
+
+	tagged := *l
+	tagged.fields = fields
+	pairs := make([]string, len(fields))
+	for i, f := range fields {
+		pairs[i] = f.key + "=" + f.value
+	}
+	tagged.prefix = "[" + strings.Join(pairs, " ") + "] "
+	return &tagged
+}
+
+// Ctx returns a logger that tags every message with the request ID and the
+// correlation fields carried by ctx
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	fields, _ := ctx.Value(contextKey{}).([]field)
+	keyValues := []string{"request_id", requestid.FromContext(ctx)}
+	for _, f := range fields {
+		keyValues = append(keyValues, f.key, f.value)
+	}
+	return l.With(keyValues...)
+}
+
+// NewContext returns a copy of ctx carrying additional correlation fields
+// as key/value pairs, which loggers obtained with Ctx tag every message with
+func NewContext(ctx context.Context, keyValues ...string) context.Context {
+	fields, _ := ctx.Value(contextKey{}).([]field)
+	return context.WithValue(ctx, contextKey{}, appendFields(fields, keyValues))
+}
+
+// appendFields returns a copy of fields with the key/value pairs set
+func appendFields(fields []field, keyValues []string) []field {
+	result := append([]field(nil), fields...)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		key, value := keyValues[i], keyValues[i+1]
+		if value == "" {
+			continue
+		}
+		replaced := false
+		for j := range result {
+			if result[j].key == key {
+				result[j].value = value
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, field{key: key, value: value})
+		}
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+func TestWith(t *testing.T) {
+	l, out := newTestLogger(t, &config.LoggingConfig{Level: "info", BufferSize: 10})
+
+	room := l.With("room_id", "!room:example.com", "event_id", "")
+	room.With("event_id", "$event", "room_id", "!other:example.com").Info("Handled")
+	room.Info("Joined")
+	l.Info("Started")
+	if l.With() != l || l.With("event_id", "") != l {
+		t.Error("With() without values returned a new logger")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		// A key that is set again is replaced in place
+		"[INFO] [room_id=!other:example.com event_id=$event] Handled",
+		// Empty values are left out, the parent logger is unchanged
+		"[INFO] [room_id=!room:example.com] Joined",
+		"[INFO] Started",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output =\n%s\nwant %d lines", out, len(want))
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want it to end with %q", i, lines[i], want[i])
+		}
+	}
+
+	// Buffered entries keep the fields apart from the message
+	entries := l.Recent(DEBUG, "", 0)
+	if len(entries) != 3 || entries[0].Message != "Handled" ||
+		!reflect.DeepEqual(entries[0].Fields, map[string]string{"room_id": "!other:example.com", "event_id": "$event"}) || entries[2].Fields != nil {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestCtx(t *testing.T) {
+	l, out := newTestLogger(t, &config.LoggingConfig{Level: "info"})
+
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	ctx = NewContext(ctx, "room_id", "!room:example.com", "sender", "@alice:example.com")
+	// Fields added later replace those of the outer context
+	inner := NewContext(ctx, "sender", "@bob:example.com", "command", "deploy")
+
+	l.Ctx(inner).Info("Running")
+	l.Ctx(ctx).With("command", "status").Info("Queued")
+	l.Ctx(context.Background()).Info("Idle")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"[INFO] [request_id=req-1 room_id=!room:example.com sender=@bob:example.com command=deploy] Running",
+		"[INFO] [request_id=req-1 room_id=!room:example.com sender=@alice:example.com command=status] Queued",
+		"[INFO] Idle",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output =\n%s\nwant %d lines", out, len(want))
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want it to end with %q", i, lines[i], want[i])
+		}
+	}
+}
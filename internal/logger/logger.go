@@ -1,12 +1,17 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type LogLevel int
@@ -18,13 +23,58 @@ const (
 	ERROR
 )
 
+var levelNames = map[LogLevel]string{DEBUG: "DEBUG", INFO: "INFO", WARN: "WARN", ERROR: "ERROR"}
+
+// defaultTag identifies the service in syslog and the journal
+const defaultTag = "matrix-microservice"
+
+// systemLogger forwards log lines to syslog or the systemd journal with the
+// level as priority
+type systemLogger interface {
+	write(level LogLevel, message string, fields []field)
+}
+
 type Logger struct {
-	logger *log.Logger
-	level  LogLevel
+	logger    *log.Logger
+	level     LogLevel
+	fields    []field      // Correlation fields, see With
+	prefix    string       // The fields as prepended to every message
+	redactor  *redactor    // Shared with the loggers derived from this one
+	sampler   *sampler     // Shared with the loggers derived from this one
+	system    systemLogger // Set by logging.target
+	buffer    *ringBuffer  // Recent entries for the admin API, nil if disabled
+	component string       // Part of the service the lines come from, see WithComponent
+}
+
+// redactedSecret replaces secrets in log output
+const redactedSecret = "[REDACTED]"
+
+// minSecretLength keeps short values (e.g. placeholder tokens) from
+// redacting common words
+const minSecretLength = 6
+
+// redactor removes credentials and optionally message bodies from log output
+type redactor struct {
+	mutex    sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer // nil until a secret is added
+	messages bool
 }
 
 func New(cfg *config.LoggingConfig) (*Logger, error) {
-	var writer io.Writer = os.Stdout
+	var stdout io.Writer = os.Stdout
+	var system systemLogger
+	if cfg.Target != "" {
+		var toSystem bool
+		var err error
+		if system, toSystem, err = newSystemLogger(cfg); err != nil {
+			return nil, err
+		}
+		if toSystem {
+			stdout = io.Discard
+		}
+	}
+	writer := stdout
 
 	// If a log file is specified, write to both stdout and file
 	if cfg.File != "" {
@@ -32,52 +82,218 @@ func New(cfg *config.LoggingConfig) (*Logger, error) {
 		if err != nil {
 			return nil, err
 		}
-		writer = io.MultiWriter(os.Stdout, file)
+		if cfg.MaxSize > 0 {
+			// lumberjack opens the file itself, this only checks it is writable
+			file.Close()
+			rotated := &lumberjack.Logger{
+				Filename:   cfg.File,
+				MaxSize:    cfg.MaxSize,
+				MaxAge:     cfg.MaxAge,
+				MaxBackups: cfg.MaxBackups,
+				LocalTime:  true,
+				Compress:   cfg.Compress,
+			}
+			if cfg.RotateHours > 0 {
+				writer = io.MultiWriter(stdout, newIntervalWriter(rotated, time.Duration(cfg.RotateHours)*time.Hour))
+			} else {
+				writer = io.MultiWriter(stdout, rotated)
+			}
+		} else {
+			writer = io.MultiWriter(stdout, file)
+		}
 	}
 
-	// Parse log level from config
-	level := INFO // default to INFO
-	switch strings.ToLower(cfg.Level) {
-	case "debug":
-		level = DEBUG
-	case "info":
+	// Parse log level from config, defaulting to INFO
+	level, ok := ParseLevel(cfg.Level)
+	if !ok {
 		level = INFO
-	case "warn":
-		level = WARN
-	case "error":
-		level = ERROR
 	}
 
 	return &Logger{
-		logger: log.New(writer, "", log.LstdFlags|log.Lshortfile),
-		level:  level,
+		logger:   log.New(writer, "", log.LstdFlags|log.Lshortfile),
+		level:    level,
+		redactor: &redactor{secrets: make(map[string]bool), messages: cfg.RedactMessages},
+		sampler: &sampler{
+			initial:    cfg.SampleInitial,
+			thereafter: cfg.SampleThereafter,
+			sites:      make(map[string]*sampledSite),
+		},
+		system: system,
+		buffer: newRingBuffer(cfg.BufferSize),
 	}, nil
 }
 
+// ParseLevel parses a level name (debug, info, warn or error)
+func ParseLevel(name string) (LogLevel, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, true
+		}
+	}
+	return INFO, false
+}
+
+func (level LogLevel) String() string {
+	return strings.ToLower(levelNames[level])
+}
+
+// AddSecrets registers values that are replaced with [REDACTED] wherever
+// they appear in log output, e.g. tokens from the configuration or a login.
+// Empty and very short values are ignored.
+func (l *Logger) AddSecrets(secrets ...string) {
+	r := l.redactor
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	added := false
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength && !r.secrets[secret] {
+			r.secrets[secret] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Longer secrets first, so that a secret containing another is replaced
+	// as a whole
+	sorted := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, redactedSecret)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Message returns a message body, command output or payload for logging.
+// With logging.redact_messages it is replaced by its length.
+func (l *Logger) Message(body string) string {
+	if l.redactor.messages {
+		return fmt.Sprintf("[%d bytes redacted]", len(body))
+	}
+	return body
+}
+
+func (l *Logger) redact(line string) string {
+	r := l.redactor
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.replacer == nil {
+		return line
+	}
+	return r.replacer.Replace(line)
+}
+
+// sampler counts the lines of sampled call sites per second
+type sampler struct {
+	initial    int // 0 disables sampling
+	thereafter int
+	mutex      sync.Mutex
+	sites      map[string]*sampledSite // Keyed by format string
+}
+
+type sampledSite struct {
+	second  int64 // Unix second the count is for
+	count   int   // Lines in that second
+	dropped int   // Lines dropped since the last one logged
+}
+
+// sample reports whether a line of a call site is logged, and how many of
+// its lines were dropped before it
+func (s *sampler) sample(site string, now time.Time) (bool, int) {
+	if s.initial <= 0 {
+		return true, 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, ok := s.sites[site]
+	if !ok {
+		state = &sampledSite{}
+		s.sites[site] = state
+	}
+	if second := now.Unix(); state.second != second {
+		state.second = second
+		state.count = 0
+	}
+	state.count++
+	if state.count > s.initial && (state.count-s.initial)%s.thereafter != 0 {
+		state.dropped++
+		return false, 0
+	}
+	dropped := state.dropped
+	state.dropped = 0
+	return true, dropped
+}
+
+// output formats and writes a line after redacting secrets. The call depth
+// attributes the line to the caller of Info, Warn, etc.
+func (l *Logger) output(level LogLevel, format string, v ...interface{}) {
+	body := fmt.Sprintf(format, v...)
+	message := l.redact(l.prefix + body)
+	l.logger.Output(3, "["+levelNames[level]+"] "+message)
+	if l.system != nil {
+		l.system.write(level, message, l.fields)
+	}
+	if l.buffer != nil {
+		l.buffer.add(l.entry(level, l.redact(body)))
+	}
+}
+
+// WithRequestID returns a logger that tags every message with the request
+// ID, so that the lines caused by one user action can be found together. An
+// empty ID returns the logger itself.
+func (l *Logger) WithRequestID(requestID string) *Logger {
+	return l.With("request_id", requestID)
+}
+
 func (l *Logger) shouldLog(level LogLevel) bool {
 	return level >= l.level
 }
 
 func (l *Logger) Info(format string, v ...interface{}) {
 	if l.shouldLog(INFO) {
-		l.logger.Printf("[INFO] "+format, v...)
+		l.output(INFO, format, v...)
 	}
 }
 
 func (l *Logger) Error(format string, v ...interface{}) {
 	if l.shouldLog(ERROR) {
-		l.logger.Printf("[ERROR] "+format, v...)
+		l.output(ERROR, format, v...)
 	}
 }
 
 func (l *Logger) Debug(format string, v ...interface{}) {
 	if l.shouldLog(DEBUG) {
-		l.logger.Printf("[DEBUG] "+format, v...)
+		l.output(DEBUG, format, v...)
+	}
+}
+
+// DebugSampled logs at debug level like Debug, for call sites that log for
+// every event. Each format string logs logging.sample_initial lines per
+// second, then every logging.sample_thereafter-th line, which notes how many
+// were dropped since the previous one.
+func (l *Logger) DebugSampled(format string, v ...interface{}) {
+	if !l.shouldLog(DEBUG) {
+		return
+	}
+	log, dropped := l.sampler.sample(format, time.Now())
+	if !log {
+		return
+	}
+	if dropped > 0 {
+		format += fmt.Sprintf(" (sampled, %d similar lines dropped)", dropped)
 	}
+	l.output(DEBUG, format, v...)
 }
 
 func (l *Logger) Warn(format string, v ...interface{}) {
 	if l.shouldLog(WARN) {
-		l.logger.Printf("[WARN] "+format, v...)
+		l.output(WARN, format, v...)
 	}
 }
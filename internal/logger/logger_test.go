@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// newTestLogger returns a logger that writes to the returned buffer instead
+// of stdout
+func newTestLogger(t *testing.T, cfg *config.LoggingConfig) (*Logger, *bytes.Buffer) {
+	t.Helper()
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	l.logger.SetOutput(&out)
+	return l, &out
+}
+
+func TestRedactSecrets(t *testing.T) {
+	l, out := newTestLogger(t, &config.LoggingConfig{Level: "debug", BufferSize: 10})
+	tagged := l.With("room_id", "!room:example.com")
+	// Secrets added after deriving a logger apply to it too
+	l.AddSecrets("syt_access_token", "syt_access_token_long", "short", "")
+
+	tagged.Info("Logged in with %s", "syt_access_token")
+	tagged.Warn("Refreshed to syt_access_token_long")
+	l.With("token", "syt_access_token").Error("Login failed")
+	l.Info("Saw a short token")
+
+	lines := out.String()
+	if strings.Contains(lines, "syt_access_token") {
+		t.Errorf("output contains a secret:\n%s", lines)
+	}
+	for _, want := range []string{
+		"[room_id=!room:example.com] Logged in with [REDACTED]",
+		"Refreshed to [REDACTED]\n",
+		"[token=[REDACTED]] Login failed",
+		"Saw a short token",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("output does not contain %q:\n%s", want, lines)
+		}
+	}
+
+	for _, entry := range l.Recent(DEBUG, "", 0) {
+		if strings.Contains(entry.Message, "syt_access_token") || strings.Contains(entry.Fields["token"], "syt_access_token") {
+			t.Errorf("buffered entry contains a secret: %+v", entry)
+		}
+	}
+}
+
+func TestRedactSecretsInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matrix.log")
+	l, err := New(&config.LoggingConfig{Level: "info", File: path, RedactMessages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.AddSecrets("hunter2hunter2")
+
+	l.Info("Password is hunter2hunter2")
+	l.Info("Received %s", l.Message("a private message"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := string(data)
+	if strings.Contains(lines, "hunter2hunter2") || strings.Contains(lines, "a private message") {
+		t.Errorf("log file contains redacted values:\n%s", lines)
+	}
+	if !strings.Contains(lines, "Password is [REDACTED]") || !strings.Contains(lines, "Received [17 bytes redacted]") {
+		t.Errorf("log file =\n%s\nwant the redacted lines", lines)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := &sampler{initial: 2, thereafter: 3, sites: make(map[string]*sampledSite)}
+	second := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		site        string
+		now         time.Time
+		wantLog     bool
+		wantDropped int
+	}{
+		{"event %s", second, true, 0},
+		{"event %s", second, true, 0},
+		{"event %s", second, false, 0},
+		{"event %s", second, false, 0},
+		// Every third line after the initial ones, noting the dropped lines
+		{"event %s", second, true, 2},
+		{"event %s", second, false, 0},
+		// Sites are counted separately
+		{"dump %s", second, true, 0},
+		// The count restarts every second, dropped lines are still noted
+		{"event %s", second.Add(time.Second), true, 1},
+		{"event %s", second.Add(time.Second), true, 0},
+		{"event %s", second.Add(time.Second), false, 0},
+	}
+	for i, tt := range tests {
+		log, dropped := s.sample(tt.site, tt.now)
+		if log != tt.wantLog || dropped != tt.wantDropped {
+			t.Errorf("line %d: sample(%q) = %v, %d, want %v, %d", i, tt.site, log, dropped, tt.wantLog, tt.wantDropped)
+		}
+	}
+
+	// sample_initial 0 logs every line
+	all := &sampler{sites: make(map[string]*sampledSite)}
+	for i := 0; i < 5; i++ {
+		if log, _ := all.sample("event %s", second); !log {
+			t.Errorf("line %d was dropped without sampling", i)
+		}
+	}
+}
+
+func TestDebugSampled(t *testing.T) {
+	l, out := newTestLogger(t, &config.LoggingConfig{Level: "debug", SampleInitial: 1, SampleThereafter: 2})
+	// Lines of one second, unless the test runs across a second boundary
+	start := time.Now().Unix()
+	for i := 0; i < 3; i++ {
+		l.DebugSampled("Received event %d", i)
+	}
+	if time.Now().Unix() != start {
+		t.Skip("crossed a second boundary")
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], "[DEBUG] Received event 0") || !strings.HasSuffix(lines[1], "[DEBUG] Received event 2 (sampled, 1 similar lines dropped)") {
+		t.Errorf("output =\n%s\nwant the first and third line", out)
+	}
+
+	// Lines below the level are not counted
+	quiet, out := newTestLogger(t, &config.LoggingConfig{Level: "info", SampleInitial: 1, SampleThereafter: 2})
+	quiet.DebugSampled("Received event %d", 0)
+	if out.Len() != 0 || len(quiet.sampler.sites) != 0 {
+		t.Errorf("debug line logged at info level: %q", out)
+	}
+}
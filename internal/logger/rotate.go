@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// intervalWriter rotates a lumberjack file at fixed times of day, in
+// addition to its size limit. The file is rotated by the first write after
+// a boundary, so an idle service leaves no empty files behind.
+type intervalWriter struct {
+	mutex    sync.Mutex
+	file     *lumberjack.Logger
+	interval time.Duration
+	next     time.Time        // Boundary after which the next write rotates first
+	now      func() time.Time // Replaced in tests
+}
+
+// newIntervalWriter starts the current period at the last write to the file,
+// so a file left over from an earlier period is rotated by the first line
+func newIntervalWriter(file *lumberjack.Logger, interval time.Duration) *intervalWriter {
+	w := &intervalWriter{file: file, interval: interval, now: time.Now}
+	start := w.now()
+	if info, err := os.Stat(file.Filename); err == nil {
+		start = info.ModTime()
+	}
+	w.next = nextRotation(start, interval)
+	return w
+}
+
+func (w *intervalWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if now := w.now(); !now.Before(w.next) {
+		w.next = nextRotation(now, w.interval)
+		if err := w.file.Rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return w.file.Write(p)
+}
+
+// nextRotation returns the first boundary after t, at multiples of interval
+// since midnight of t's day
+func nextRotation(t time.Time, interval time.Duration) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return midnight.Add(t.Sub(midnight).Truncate(interval) + interval)
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func TestNextRotation(t *testing.T) {
+	day := func(hour, minute int) time.Time { return time.Date(2026, 10, 16, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		t        time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{day(13, 5), 24 * time.Hour, day(24, 0)},
+		{day(0, 0), 24 * time.Hour, day(24, 0)},
+		{day(13, 5), 6 * time.Hour, day(18, 0)},
+		{day(18, 0), 6 * time.Hour, day(24, 0)},
+		{day(23, 59), time.Hour, day(24, 0)},
+	}
+	for _, tt := range tests {
+		if got := nextRotation(tt.t, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("nextRotation(%s, %s) = %s, want %s", tt.t, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestIntervalWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "matrix.log")
+	// A file left over from yesterday
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	yesterday := now.Add(-24 * time.Hour)
+	if err := os.Chtimes(path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+
+	file := &lumberjack.Logger{Filename: path, MaxSize: 100, LocalTime: true}
+	defer file.Close()
+	w := newIntervalWriter(file, 24*time.Hour)
+	w.now = func() time.Time { return now }
+
+	write := func(line string) {
+		t.Helper()
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups := func() int {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(dir, "matrix-*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(matches)
+	}
+
+	write("today\n")
+	write("still today\n")
+	if got := backups(); got != 1 {
+		t.Errorf("%d rotated files after the first write, want the one of yesterday", got)
+	}
+
+	// Rotated files are named by the millisecond
+	time.Sleep(2 * time.Millisecond)
+	w.now = func() time.Time { return now.Add(24 * time.Hour) }
+	write("tomorrow\n")
+	if got := backups(); got != 2 {
+		t.Errorf("%d rotated files after the day changed, want 2", got)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "tomorrow\n" {
+		t.Errorf("current file = %q, want only the line after the rotation", data)
+	}
+}
@@ -0,0 +1,88 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// newSystemLogger connects to logging.target. It reports whether stdout
+// already goes to that target, as it does for systemd services logging to
+// the journal, so that lines are not stored twice.
+func newSystemLogger(cfg *config.LoggingConfig) (systemLogger, bool, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultTag
+	}
+
+	switch strings.ToLower(cfg.Target) {
+	case "syslog":
+		var network, address string
+		if cfg.SyslogAddress != "" {
+			var found bool
+			network, address, found = strings.Cut(cfg.SyslogAddress, "://")
+			if !found {
+				return nil, false, fmt.Errorf("invalid logging.syslog_address %q, expected network://host:port", cfg.SyslogAddress)
+			}
+		}
+		writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		return &syslogLogger{writer: writer}, false, nil
+	case "journald":
+		if !journal.Enabled() {
+			return nil, false, fmt.Errorf("the systemd journal is not available")
+		}
+		toJournal, _ := journal.StdoutIsJournalStream()
+		return &journalLogger{tag: tag}, toJournal, nil
+	}
+	return nil, false, fmt.Errorf("unknown logging.target %q", cfg.Target)
+}
+
+type syslogLogger struct {
+	writer *syslog.Writer
+}
+
+// write sends a line to syslog, which has no fields; the message carries
+// them in its prefix
+func (s *syslogLogger) write(level LogLevel, message string, fields []field) {
+	switch level {
+	case DEBUG:
+		s.writer.Debug(message)
+	case INFO:
+		s.writer.Info(message)
+	case WARN:
+		s.writer.Warning(message)
+	default:
+		s.writer.Err(message)
+	}
+}
+
+type journalLogger struct {
+	tag string
+}
+
+// write sends a line to the journal with the correlation fields as journal
+// fields, e.g. ROOM_ID, so that `journalctl ROOM_ID=...` finds its lines
+func (j *journalLogger) write(level LogLevel, message string, fields []field) {
+	priority := journal.PriErr
+	switch level {
+	case DEBUG:
+		priority = journal.PriDebug
+	case INFO:
+		priority = journal.PriInfo
+	case WARN:
+		priority = journal.PriWarning
+	}
+	vars := map[string]string{"SYSLOG_IDENTIFIER": j.tag}
+	for _, f := range fields {
+		vars[strings.ToUpper(f.key)] = f.value
+	}
+	journal.Send(message, priority, vars)
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logger
+
+import (
+	"fmt"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func newSystemLogger(cfg *config.LoggingConfig) (systemLogger, bool, error) {
+	return nil, false, fmt.Errorf("logging.target %q is not supported on this platform", cfg.Target)
+}
@@ -0,0 +1,61 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestSyslogTarget(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l, out := newTestLogger(t, &config.LoggingConfig{
+		Level:         "info",
+		Target:        "syslog",
+		SyslogAddress: "udp://" + conn.LocalAddr().String(),
+		Tag:           "test-bot",
+	})
+	l.AddSecrets("syt_access_token")
+	l.With("room_id", "!room:example.com").Warn("Token syt_access_token expired")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no syslog message received: %v", err)
+	}
+	// Warnings of the daemon facility have priority 3*8+4
+	message := string(buf[:n])
+	if !strings.HasPrefix(message, "<28>") || !strings.Contains(message, "test-bot[") ||
+		!strings.Contains(message, "[room_id=!room:example.com] Token [REDACTED] expired") {
+		t.Errorf("syslog message = %q", message)
+	}
+	// Syslog does not replace stdout
+	if !strings.Contains(out.String(), "Token [REDACTED] expired") {
+		t.Errorf("output = %q, want the line on stdout too", out)
+	}
+}
+
+func TestSystemTargetErrors(t *testing.T) {
+	tests := []struct {
+		cfg     config.LoggingConfig
+		wantErr string
+	}{
+		{config.LoggingConfig{Target: "syslog", SyslogAddress: "127.0.0.1:514"}, "expected network://host:port"},
+		{config.LoggingConfig{Target: "eventlog"}, "unknown logging.target"},
+	}
+	for _, tt := range tests {
+		if _, err := New(&tt.cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("New(%+v) error = %v, want it to contain %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// appServiceTransactions is how many transaction IDs are remembered to
+// recognize a transaction the homeserver sends again
+const appServiceTransactions = 1000
+
+// appService receives the events of the homeserver in transactions, in place
+// of the sync loop, and sends as the virtual users of matrix.appservice.users
+type appService struct {
+	cfg    *config.AppServiceConfig
+	bot    *mautrix.Client
+	server string // Server name of the virtual users
+	logger *logger.Logger
+
+	txnMutex sync.Mutex
+	seen     map[string]bool // Transactions handled
+	order    []string        // Transactions handled, oldest first
+
+	// Held while a virtual user registers or joins, so it does once
+	usersMutex sync.Mutex
+	users      map[id.UserID]*appServiceUser
+}
+
+// appServiceUser is a virtual user and the rooms it joined
+type appServiceUser struct {
+	client     *mautrix.Client
+	registered bool
+	joined     map[id.RoomID]bool
+}
+
+func newAppService(cfg *config.AppServiceConfig, bot *mautrix.Client, logger *logger.Logger) *appService {
+	_, server, _ := strings.Cut(string(bot.UserID), ":")
+	return &appService{
+		cfg:    cfg,
+		bot:    bot,
+		server: server,
+		logger: logger,
+		seen:   make(map[string]bool),
+		users:  make(map[id.UserID]*appServiceUser),
+	}
+}
+
+// userID returns the virtual user replies of name, a command or hook, are
+// sent as, empty if they are sent as the bot
+func (a *appService) userID(name string) id.UserID {
+	localpart, exists := a.cfg.Users[strings.ToLower(name)]
+	if !exists {
+		return ""
+	}
+	return id.NewUserID(localpart, a.server)
+}
+
+// isVirtualUser reports whether userID is one of the virtual users
+func (a *appService) isVirtualUser(userID id.UserID) bool {
+	localpart, server, err := userID.Parse()
+	if err != nil || server != a.server {
+		return false
+	}
+	for _, user := range a.cfg.Users {
+		if user == localpart {
+			return true
+		}
+	}
+	return false
+}
+
+// firstSeen records a transaction and reports whether it is new
+func (a *appService) firstSeen(txnID string) bool {
+	a.txnMutex.Lock()
+	defer a.txnMutex.Unlock()
+	if a.seen[txnID] {
+		return false
+	}
+	a.seen[txnID] = true
+	a.order = append(a.order, txnID)
+	if len(a.order) > appServiceTransactions {
+		delete(a.seen, a.order[0])
+		a.order = a.order[1:]
+	}
+	return true
+}
+
+// client returns the client sending as the virtual user userID in roomID,
+// registering it and joining the room first if needed
+func (a *appService) client(ctx context.Context, userID id.UserID, roomID id.RoomID) (*mautrix.Client, error) {
+	a.usersMutex.Lock()
+	defer a.usersMutex.Unlock()
+	user, err := a.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.joined[roomID] {
+		if err := a.join(ctx, user.client, roomID); err != nil {
+			return nil, err
+		}
+		user.joined[roomID] = true
+	}
+	return user.client, nil
+}
+
+// user returns the virtual user userID, registering it if needed. The
+// caller holds usersMutex.
+func (a *appService) user(ctx context.Context, userID id.UserID) (*appServiceUser, error) {
+	user, exists := a.users[userID]
+	if !exists {
+		client, err := mautrix.NewClient(a.bot.HomeserverURL.String(), userID, a.bot.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		client.Client = a.bot.Client
+		client.SetAppServiceUserID = true
+		user = &appServiceUser{client: client, joined: make(map[id.RoomID]bool)}
+		a.users[userID] = user
+	}
+
+	if !user.registered {
+		if err := a.register(ctx, user.client); err != nil {
+			return nil, err
+		}
+		user.registered = true
+	}
+	return user, nil
+}
+
+// register creates the account of a virtual user unless it exists
+func (a *appService) register(ctx context.Context, client *mautrix.Client) error {
+	localpart, _, _ := client.UserID.Parse()
+	_, _, err := client.Register(ctx, &mautrix.ReqRegister{Username: localpart, Type: mautrix.AuthTypeAppservice, InhibitLogin: true})
+	switch {
+	case err == nil:
+		a.logger.Info("Registered virtual user %s", client.UserID)
+	case errors.Is(err, mautrix.MUserInUse):
+	default:
+		return fmt.Errorf("failed to register %s: %w", client.UserID, err)
+	}
+	return nil
+}
+
+// join makes a virtual user join roomID, invited by the bot if the room
+// needs an invite
+func (a *appService) join(ctx context.Context, client *mautrix.Client, roomID id.RoomID) error {
+	_, err := client.JoinRoomByID(ctx, roomID)
+	if errors.Is(err, mautrix.MForbidden) {
+		if _, err := a.bot.InviteUser(ctx, roomID, &mautrix.ReqInviteUser{UserID: client.UserID}); err != nil {
+			return fmt.Errorf("failed to invite %s to %s: %w", client.UserID, roomID, err)
+		}
+		_, err = client.JoinRoomByID(ctx, roomID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to join %s to %s: %w", client.UserID, roomID, err)
+	}
+	a.logger.Info("Virtual user %s joined %s", client.UserID, roomID)
+	return nil
+}
+
+// HandleTransaction handles the events of an application service
+// transaction like those of a sync response. A transaction the homeserver
+// sends again is skipped.
+func (c *Client) HandleTransaction(txnID string, events []*event.Event) {
+	if c.appService == nil {
+		return
+	}
+	if !c.appService.firstSeen(txnID) {
+		c.logger.Debug("Skipping transaction %s, it was handled before", txnID)
+		return
+	}
+	c.lastSync.Store(time.Now().UnixNano())
+	ctx := context.Background()
+	for _, evt := range events {
+		if err := parseContent(evt); err != nil {
+			c.logger.Warn("Failed to parse event %s of transaction %s: %v", evt.ID, txnID, err)
+			continue
+		}
+		evt.Mautrix.EventSource = event.SourceJoin | event.SourceTimeline
+		c.processEvent(ctx, evt)
+	}
+}
+
+// IsVirtualUser reports whether userID is a virtual user of
+// matrix.appservice.users
+func (c *Client) IsVirtualUser(userID id.UserID) bool {
+	return c.appService != nil && c.appService.isVirtualUser(userID)
+}
+
+// EnsureVirtualUser registers userID if it is a virtual user of
+// matrix.appservice.users and reports whether it is one, answering the
+// homeserver's query for users of the namespace
+func (c *Client) EnsureVirtualUser(ctx context.Context, userID id.UserID) (bool, error) {
+	if !c.IsVirtualUser(userID) {
+		return false, nil
+	}
+	c.appService.usersMutex.Lock()
+	defer c.appService.usersMutex.Unlock()
+	_, err := c.appService.user(ctx, userID)
+	return err == nil, err
+}
+
+// sender returns the client sending a message with options to roomID: the
+// client of the virtual user of WithSender in appservice mode, otherwise the
+// bot's
+func (c *Client) sender(ctx context.Context, options *SendMessageOptions, roomID id.RoomID) *mautrix.Client {
+	if c.appService == nil || options.Sender == "" {
+		return c.client
+	}
+	userID := c.appService.userID(options.Sender)
+	if userID == "" {
+		return c.client
+	}
+	client, err := c.appService.client(ctx, userID, roomID)
+	if err != nil {
+		c.sendLogger(options).Warn("Sending as the bot, virtual user %s is not available: %v", userID, err)
+		return c.client
+	}
+	return client
+}
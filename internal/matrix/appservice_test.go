@@ -0,0 +1,158 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// fakeHomeserver answers the calls an application service makes, recording
+// them as "METHOD path as user_id"
+type fakeHomeserver struct {
+	mu      sync.Mutex
+	calls   []string
+	invited map[string]bool
+}
+
+func (h *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	userID := r.URL.Query().Get("user_id")
+	h.calls = append(h.calls, r.Method+" "+r.URL.Path+" as "+userID)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/register"):
+		w.Write([]byte(`{"user_id": "@alerts-grafana:example.com"}`))
+	case strings.HasSuffix(r.URL.Path, "/invite"):
+		var req mautrix.ReqInviteUser
+		json.NewDecoder(r.Body).Decode(&req)
+		h.invited[string(req.UserID)] = true
+		w.Write([]byte(`{}`))
+	case strings.HasSuffix(r.URL.Path, "/join"):
+		if !h.invited[userID] {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errcode": "M_FORBIDDEN", "error": "You are not invited to this room."}`))
+			return
+		}
+		w.Write([]byte(`{"room_id": "!room:example.com"}`))
+	case strings.Contains(r.URL.Path, "/send/"):
+		w.Write([]byte(`{"event_id": "$sent"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errcode": "M_UNRECOGNIZED"}`))
+	}
+}
+
+func newAppServiceClient(t *testing.T, homeserver string) *Client {
+	t.Helper()
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	bot, err := mautrix.NewClient(homeserver, "@bot:example.com", "as-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.MatrixConfig{
+		UserID:     "@bot:example.com",
+		RoomID:     "!room:example.com",
+		AppService: config.AppServiceConfig{Enabled: true, Users: map[string]string{"grafana": "alerts-grafana"}},
+	}
+	return &Client{
+		client:     bot,
+		config:     cfg,
+		roomID:     cfg.RoomID,
+		logger:     log,
+		appService: newAppService(&cfg.AppService, bot, log),
+	}
+}
+
+func TestAppServiceSender(t *testing.T) {
+	homeserver := &fakeHomeserver{invited: make(map[string]bool)}
+	server := httptest.NewServer(homeserver)
+	defer server.Close()
+	c := newAppServiceClient(t, server.URL)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.sender(ctx, &SendMessageOptions{Sender: "Grafana"}, "!room:example.com").SendText(ctx, "!room:example.com", "firing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"POST /_matrix/client/v3/register as @alerts-grafana:example.com",
+		"POST /_matrix/client/v3/rooms/!room:example.com/join as @alerts-grafana:example.com",
+		"POST /_matrix/client/v3/rooms/!room:example.com/invite as ",
+		"POST /_matrix/client/v3/rooms/!room:example.com/join as @alerts-grafana:example.com",
+		"PUT /_matrix/client/v3/rooms/!room:example.com/send/m.room.message/",
+		"PUT /_matrix/client/v3/rooms/!room:example.com/send/m.room.message/",
+	}
+	if len(homeserver.calls) != len(want) {
+		t.Fatalf("calls = %q, want the virtual user registered and joined once, then two sends", homeserver.calls)
+	}
+	for i, call := range homeserver.calls {
+		if strings.Contains(want[i], "/send/") {
+			if !strings.HasPrefix(call, want[i]) || !strings.HasSuffix(call, " as @alerts-grafana:example.com") {
+				t.Errorf("call %d = %q, want a send as the virtual user", i, call)
+			}
+		} else if call != want[i] {
+			t.Errorf("call %d = %q, want %q", i, call, want[i])
+		}
+	}
+
+	if sender := c.sender(ctx, &SendMessageOptions{Sender: "deploy"}, "!room:example.com"); sender != c.client {
+		t.Error("a command without a virtual user is not sent as the bot")
+	}
+	if sender := c.sender(ctx, &SendMessageOptions{}, "!room:example.com"); sender != c.client {
+		t.Error("a message without a sender is not sent as the bot")
+	}
+}
+
+type recordingHandler struct{ events []*event.Event }
+
+func (h *recordingHandler) HandleEvent(evt *event.Event) { h.events = append(h.events, evt) }
+
+func TestHandleTransaction(t *testing.T) {
+	c := newAppServiceClient(t, "https://matrix.example.com")
+	handler := &recordingHandler{}
+	c.eventHandler = handler
+
+	var events []*event.Event
+	err := json.Unmarshal([]byte(`[
+		{"type": "m.room.message", "room_id": "!room:example.com", "event_id": "$user", "sender": "@alice:example.com", "content": {"msgtype": "m.text", "body": "hello"}},
+		{"type": "m.room.message", "room_id": "!room:example.com", "event_id": "$virtual", "sender": "@alerts-grafana:example.com", "content": {"msgtype": "m.text", "body": "firing"}},
+		{"type": "m.room.message", "room_id": "!other:example.com", "event_id": "$other", "sender": "@alice:example.com", "content": {"msgtype": "m.text", "body": "hello"}}
+	]`), &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.HandleTransaction("txn1", events)
+	c.HandleTransaction("txn1", events)
+
+	if len(handler.events) != 1 || handler.events[0].ID != "$user" {
+		t.Fatalf("handled %d events, want only $user once", len(handler.events))
+	}
+	if content := handler.events[0].Content.AsMessage(); content.Body != "hello" {
+		t.Errorf("content of the handled event = %+v, want it parsed", content)
+	}
+	if c.SyncStatus().LastSync.IsZero() {
+		t.Error("LastSync was not updated by the transaction")
+	}
+
+	for userID, want := range map[id.UserID]bool{
+		"@alerts-grafana:example.com": true,
+		"@alerts-grafana:other.org":   false,
+		"@alice:example.com":          false,
+	} {
+		if got := c.IsVirtualUser(userID); got != want {
+			t.Errorf("IsVirtualUser(%s) = %v, want %v", userID, got, want)
+		}
+	}
+}
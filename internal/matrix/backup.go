@@ -0,0 +1,295 @@
+package matrix
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/crypto/olm"
+)
+
+// CryptoDBPath is the crypto store: the device's identity keys, the Olm and
+// Megolm sessions, the room state and the sync token
+const CryptoDBPath = "matrix_crypto.db"
+
+const (
+	// cryptoBackupVersion is the version of the backup format
+	cryptoBackupVersion = 1
+	// Files of a backup
+	cryptoBackupManifest = "manifest.json"
+	cryptoBackupDB       = "matrix_crypto.db"
+)
+
+// ErrWrongPickleKey is returned when the pickle key does not decrypt the
+// device's account
+var ErrWrongPickleKey = errors.New("matrix.picklekey does not decrypt the device's keys, use the pickle key of the host the backup was taken on")
+
+// CryptoBackup describes the device a backup of the crypto store belongs to
+type CryptoBackup struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Homeserver  string    `json:"homeserver"`
+	UserID      string    `json:"user_id"`
+	DeviceID    string    `json:"device_id"`
+	IdentityKey string    `json:"identity_key"` // Curve25519
+	SigningKey  string    `json:"signing_key"`  // Ed25519
+	// Access token of the device, encrypted with the pickle key
+	SealedToken []byte `json:"access_token,omitempty"`
+	// Access token of the device, set by RestoreCrypto
+	AccessToken string `json:"-"`
+}
+
+// BackupCrypto writes a snapshot of the crypto store at dbPath, with the
+// identity and access token of the device of cfg, to w as a gzipped tar
+// archive. The store can be in use by a running service. The pickle key must
+// decrypt the device's account, so a backup can be restored with it.
+func BackupCrypto(w io.Writer, cfg *config.MatrixConfig, dbPath string) (*CryptoBackup, error) {
+	if cfg.DeviceID == "" {
+		return nil, errors.New("matrix.deviceid is not set, the bot has not logged in yet")
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("no crypto store to back up: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "crypto-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, cryptoBackupDB)
+	if err := snapshotCryptoDB(dbPath, snapshot); err != nil {
+		return nil, err
+	}
+
+	backup := &CryptoBackup{
+		Version:    cryptoBackupVersion,
+		CreatedAt:  time.Now().UTC(),
+		Homeserver: cfg.Homeserver,
+		UserID:     cfg.UserID,
+		DeviceID:   cfg.DeviceID,
+	}
+	if backup.SigningKey, backup.IdentityKey, err = readCryptoAccount(snapshot, cfg.DeviceID, cfg.PickleKey); err != nil {
+		return nil, err
+	}
+	if cfg.AccessToken != "" {
+		if backup.SealedToken, err = sealToken(cfg.PickleKey, cfg.AccessToken); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	db, err := os.ReadFile(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{cryptoBackupManifest, manifest}, {cryptoBackupDB, db}} {
+		header := &tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.data)), ModTime: backup.CreatedAt}
+		if err := archive.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := archive.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// RestoreCrypto restores a backup written by BackupCrypto to dbPath, which is
+// only replaced with force. The backup must be of the user of cfg, if one is
+// set, and the pickle key of cfg must decrypt it. The returned backup has the
+// access token of the device, for the config of the new host.
+func RestoreCrypto(r io.Reader, cfg *config.MatrixConfig, dbPath string, force bool) (*CryptoBackup, error) {
+	if _, err := os.Stat(dbPath); err == nil && !force {
+		return nil, fmt.Errorf("%s exists, use --force to replace it", dbPath)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a crypto backup: %w", err)
+	}
+	// Written next to the store, so it can be renamed into place
+	restored := dbPath + ".restore"
+	defer os.Remove(restored)
+	var backup *CryptoBackup
+	hasDB := false
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		switch header.Name {
+		case cryptoBackupManifest:
+			backup = &CryptoBackup{}
+			if err := json.NewDecoder(archive).Decode(backup); err != nil {
+				return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+			}
+		case cryptoBackupDB:
+			if err := writeFile(restored, archive); err != nil {
+				return nil, err
+			}
+			hasDB = true
+		}
+	}
+	if backup == nil || !hasDB {
+		return nil, errors.New("not a crypto backup, the manifest or the crypto store is missing")
+	}
+	if backup.Version != cryptoBackupVersion {
+		return nil, fmt.Errorf("backup version %d is not supported", backup.Version)
+	}
+	if cfg.UserID != "" && cfg.UserID != backup.UserID {
+		return nil, fmt.Errorf("the backup is of %s, matrix.userid is %s", backup.UserID, cfg.UserID)
+	}
+
+	signingKey, identityKey, err := readCryptoAccount(restored, backup.DeviceID, cfg.PickleKey)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey != backup.SigningKey || identityKey != backup.IdentityKey {
+		return nil, errors.New("the keys of the crypto store do not match the backup manifest")
+	}
+	if len(backup.SealedToken) > 0 {
+		if backup.AccessToken, err = openToken(cfg.PickleKey, backup.SealedToken); err != nil {
+			return nil, err
+		}
+	}
+
+	// The journal of a replaced store belongs to it
+	for _, f := range []string{dbPath + "-shm", dbPath + "-wal"} {
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := os.Rename(restored, dbPath); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// snapshotCryptoDB copies the crypto store at dbPath to path consistently,
+// including what is still in its write-ahead log
+func snapshotCryptoDB(dbPath, path string) error {
+	db, err := sql.Open("sqlite3", "file:"+dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", dbPath, err)
+	}
+	return nil
+}
+
+// readCryptoAccount returns the identity keys of the account of a device in
+// the crypto store at path, decrypting it with the pickle key
+func readCryptoAccount(path, deviceID, pickleKey string) (string, string, error) {
+	if pickleKey == "" {
+		return "", "", errors.New("matrix.picklekey is not set")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return "", "", err
+	}
+	defer db.Close()
+	var pickled []byte
+	err = db.QueryRow("SELECT account FROM crypto_account WHERE device_id=?", deviceID).Scan(&pickled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", fmt.Errorf("the crypto store has no keys of device %s", deviceID)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the crypto store: %w", err)
+	}
+	account, err := olm.AccountFromPickled(pickled, []byte(pickleKey))
+	if err != nil {
+		return "", "", ErrWrongPickleKey
+	}
+	signingKey, identityKey, err := account.IdentityKeys()
+	if err != nil {
+		return "", "", err
+	}
+	return string(signingKey), string(identityKey), nil
+}
+
+// tokenAEAD returns the cipher the access token is sealed with, keyed by the
+// pickle key
+func tokenAEAD(pickleKey string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(pickleKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts an access token with the pickle key, the nonce first
+func sealToken(pickleKey, token string) ([]byte, error) {
+	aead, err := tokenAEAD(pickleKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(token), []byte(cryptoBackupManifest)), nil
+}
+
+// openToken decrypts an access token sealed by sealToken
+func openToken(pickleKey string, sealed []byte) (string, error) {
+	aead, err := tokenAEAD(pickleKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("the access token of the backup is damaged")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	token, err := aead.Open(nil, nonce, ciphertext, []byte(cryptoBackupManifest))
+	if err != nil {
+		return "", ErrWrongPickleKey
+	}
+	return string(token), nil
+}
+
+// writeFile writes r to a new file only the owner can read
+func writeFile(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,133 @@
+package matrix
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/crypto/olm"
+)
+
+// newCryptoStore writes a crypto store with the account of a device, pickled
+// with pickleKey, and returns its identity key
+func newCryptoStore(t *testing.T, path, deviceID, pickleKey string) string {
+	t.Helper()
+	account, err := olm.NewAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pickled, err := account.Pickle([]byte(pickleKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, identityKey, err := account.IdentityKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE crypto_account (account_id TEXT PRIMARY KEY, device_id TEXT NOT NULL, shared BOOLEAN NOT NULL, sync_token TEXT NOT NULL, account bytea NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO crypto_account VALUES ('', ?, true, 's42', ?)", deviceID, pickled); err != nil {
+		t.Fatal(err)
+	}
+	return string(identityKey)
+}
+
+func TestCryptoBackupRestore(t *testing.T) {
+	src := filepath.Join(t.TempDir(), CryptoDBPath)
+	identityKey := newCryptoStore(t, src, "BOTDEVICE", "pickle")
+	cfg := &config.MatrixConfig{Homeserver: "https://matrix.example.com", UserID: "@bot:example.com", DeviceID: "BOTDEVICE", AccessToken: "syt_secret", PickleKey: "pickle"}
+
+	var archive bytes.Buffer
+	backup, err := BackupCrypto(&archive, cfg, src)
+	if err != nil {
+		t.Fatalf("BackupCrypto() error = %v", err)
+	}
+	if backup.IdentityKey != identityKey || backup.DeviceID != "BOTDEVICE" {
+		t.Errorf("backup = %+v, want device BOTDEVICE with identity key %s", backup, identityKey)
+	}
+	if bytes.Contains(archive.Bytes(), []byte("syt_secret")) {
+		t.Error("backup holds the access token in clear text")
+	}
+
+	// A new host has the pickle key and the user, but no device yet
+	dst := filepath.Join(t.TempDir(), CryptoDBPath)
+	newHost := &config.MatrixConfig{UserID: "@bot:example.com", PickleKey: "pickle"}
+	restored, err := RestoreCrypto(bytes.NewReader(archive.Bytes()), newHost, dst, false)
+	if err != nil {
+		t.Fatalf("RestoreCrypto() error = %v", err)
+	}
+	if restored.DeviceID != "BOTDEVICE" || restored.AccessToken != "syt_secret" || restored.IdentityKey != identityKey {
+		t.Errorf("restored = %+v, want device BOTDEVICE, its token and identity key", restored)
+	}
+	if _, _, err := readCryptoAccount(dst, "BOTDEVICE", "pickle"); err != nil {
+		t.Errorf("restored store: %v", err)
+	}
+	if _, err := os.Stat(dst + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// An existing store is only replaced with force
+	if _, err := RestoreCrypto(bytes.NewReader(archive.Bytes()), newHost, dst, false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("RestoreCrypto() over a store error = %v, want a hint at --force", err)
+	}
+	if _, err := RestoreCrypto(bytes.NewReader(archive.Bytes()), newHost, dst, true); err != nil {
+		t.Errorf("RestoreCrypto() with force error = %v", err)
+	}
+}
+
+func TestCryptoRestoreRejects(t *testing.T) {
+	src := filepath.Join(t.TempDir(), CryptoDBPath)
+	newCryptoStore(t, src, "BOTDEVICE", "pickle")
+	var archive bytes.Buffer
+	if _, err := BackupCrypto(&archive, &config.MatrixConfig{UserID: "@bot:example.com", DeviceID: "BOTDEVICE", AccessToken: "syt_secret", PickleKey: "pickle"}, src); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     *config.MatrixConfig
+		archive []byte
+		want    string
+	}{
+		{"Wrong pickle key", &config.MatrixConfig{PickleKey: "other"}, archive.Bytes(), ErrWrongPickleKey.Error()},
+		{"No pickle key", &config.MatrixConfig{}, archive.Bytes(), "matrix.picklekey is not set"},
+		{"Other user", &config.MatrixConfig{UserID: "@other:example.com", PickleKey: "pickle"}, archive.Bytes(), "the backup is of @bot:example.com"},
+		{"Not a backup", &config.MatrixConfig{PickleKey: "pickle"}, []byte("plain text"), "not a crypto backup"},
+	}
+	for _, tt := range tests {
+		dst := filepath.Join(t.TempDir(), CryptoDBPath)
+		_, err := RestoreCrypto(bytes.NewReader(tt.archive), tt.cfg, dst, false)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: RestoreCrypto() error = %v, want %q", tt.name, err, tt.want)
+		}
+		if _, statErr := os.Stat(dst); !errors.Is(statErr, os.ErrNotExist) {
+			t.Errorf("%s: store written although the restore failed", tt.name)
+		}
+	}
+}
+
+func TestCryptoBackupWrongPickleKey(t *testing.T) {
+	src := filepath.Join(t.TempDir(), CryptoDBPath)
+	newCryptoStore(t, src, "BOTDEVICE", "pickle")
+	var archive bytes.Buffer
+	_, err := BackupCrypto(&archive, &config.MatrixConfig{DeviceID: "BOTDEVICE", PickleKey: "other"}, src)
+	if !errors.Is(err, ErrWrongPickleKey) {
+		t.Errorf("BackupCrypto() error = %v, want ErrWrongPickleKey", err)
+	}
+	_, err = BackupCrypto(&archive, &config.MatrixConfig{DeviceID: "OTHER", PickleKey: "pickle"}, src)
+	if err == nil || !strings.Contains(err.Error(), "no keys of device OTHER") {
+		t.Errorf("BackupCrypto() of another device error = %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package matrix
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// bridge is a bridge of the bridges setting with its compiled prefix
+type bridge struct {
+	config config.BridgeConfig
+	prefix *regexp.Regexp // nil unless prefix is set
+}
+
+// bridges are the bridge bots whose relayed messages are handled as sent by
+// the people they relay
+type bridges struct {
+	mutex sync.RWMutex
+	list  []bridge
+}
+
+// localpartRegex matches the characters of a name not allowed in the
+// localpart of a user ID
+var localpartRegex = regexp.MustCompile(`[^a-z0-9._=/-]+`)
+
+// SetBridges sets the bridge bots whose relayed messages are handled as sent
+// by the people they relay. Prefixes that do not compile were rejected by
+// validation and are ignored.
+func (c *Client) SetBridges(cfgs []config.BridgeConfig) {
+	list := make([]bridge, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		b := bridge{config: cfg}
+		if cfg.Prefix != "" {
+			b.prefix, _ = regexp.Compile(cfg.Prefix)
+		}
+		list = append(list, b)
+	}
+	c.bridges.mutex.Lock()
+	defer c.bridges.mutex.Unlock()
+	c.bridges.list = list
+}
+
+// bridgedSender returns who sent a message that sender relayed as a bridge
+// bot, and body without the prefix naming them. Messages of other users are
+// returned as they are. A relayed message that does not name its sender, or
+// whose sender's name has no characters allowed in user IDs, is refused (ok
+// is false): handling it as sent by the bridge bot would give anyone on the
+// bridged network the bot's permissions.
+func (c *Client) bridgedSender(sender id.UserID, content *event.MessageEventContent, body string) (userID id.UserID, relayed string, ok bool) {
+	c.bridges.mutex.RLock()
+	defer c.bridges.mutex.RUnlock()
+	for _, b := range c.bridges.list {
+		if !b.config.RelayedBy(string(sender)) {
+			continue
+		}
+		var name string
+		if b.config.PerMessageProfile && content.BeeperPerMessageProfile != nil {
+			name = content.BeeperPerMessageProfile.ID
+			if name == "" {
+				name = content.BeeperPerMessageProfile.Displayname
+			}
+		}
+		// The prefix is removed also when the profile names the sender, as
+		// bridges repeat the name in the body for clients without profiles
+		if b.prefix != nil {
+			if match := b.prefix.FindStringSubmatchIndex(body); match != nil && match[0] == 0 {
+				if group := 2 * b.prefix.SubexpIndex("name"); name == "" && group >= 2 && match[group] >= 0 {
+					name = body[match[group]:match[group+1]]
+				}
+				body = body[match[1]:]
+			}
+		}
+		if name == "" {
+			return sender, body, false
+		}
+		if userID = bridgedUserID(&b.config, sender, name); userID == "" {
+			return sender, body, false
+		}
+		return userID, body, true
+	}
+	return sender, body, true
+}
+
+// bridgedUserID returns the user ID of sender_format for the name of a
+// bridged sender, empty if the name has no characters allowed in user IDs
+func bridgedUserID(cfg *config.BridgeConfig, bot id.UserID, name string) id.UserID {
+	localpart := strings.Trim(localpartRegex.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "_"), "_")
+	if localpart == "" {
+		return ""
+	}
+	format := cfg.SenderFormat
+	if format == "" {
+		format = config.DefaultBridgeSenderFormat
+	}
+	_, server, _ := strings.Cut(string(bot), ":")
+	return id.UserID(strings.NewReplacer("{name}", localpart, "{bridge}", strings.ToLower(cfg.Name), "{server}", server).Replace(format))
+}
@@ -0,0 +1,53 @@
+package matrix
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestBridgedSender(t *testing.T) {
+	c := &Client{}
+	c.SetBridges([]config.BridgeConfig{
+		{Name: "irc", Users: []string{"@ircbot:example.com"}, Prefix: `^<(?P<name>[^>]+)> `},
+		{Name: "telegram", Users: []string{"@telegram*:bridge.example.com"}, Prefix: `^(?P<name>[^:]+): `, PerMessageProfile: true, SenderFormat: "@tg_{name}:example.com"},
+	})
+	profile := func(id, name string) *event.MessageEventContent {
+		return &event.MessageEventContent{BeeperPerMessageProfile: &event.BeeperPerMessageProfile{ID: id, Displayname: name}}
+	}
+
+	tests := []struct {
+		name       string
+		sender     id.UserID
+		content    *event.MessageEventContent
+		body       string
+		wantSender id.UserID
+		wantBody   string
+		refused    bool
+	}{
+		{"IRC prefix", "@ircbot:example.com", &event.MessageEventContent{}, "<Alice> /deploy prod", "@irc_alice:example.com", "/deploy prod", false},
+		{"Name with spaces", "@ircbot:example.com", &event.MessageEventContent{}, "<Bob Smith> hi", "@irc_bob_smith:example.com", "hi", false},
+		{"Without prefix", "@ircbot:example.com", &event.MessageEventContent{}, "Alice joined", "", "", true},
+		{"Name without allowed characters", "@ircbot:example.com", &event.MessageEventContent{}, "<✨> /deploy prod", "", "", true},
+		{"Per-message profile", "@telegrambot:bridge.example.com", profile("12345", "Carol"), "Carol: /status", "@tg_12345:example.com", "/status", false},
+		{"Profile without ID", "@telegrambot:bridge.example.com", profile("", "Carol"), "/status", "@tg_carol:example.com", "/status", false},
+		{"Profile without a name", "@telegrambot:bridge.example.com", profile("", ""), "/status", "", "", true},
+		{"Neither profile nor prefix", "@telegrambot:bridge.example.com", &event.MessageEventContent{}, "/status", "", "", true},
+		{"Telegram prefix", "@telegram_2:bridge.example.com", &event.MessageEventContent{}, "Dave: /status", "@tg_dave:example.com", "/status", false},
+		{"Other user", "@alice:example.com", &event.MessageEventContent{}, "<Mallory> /deploy prod", "@alice:example.com", "<Mallory> /deploy prod", false},
+	}
+	for _, tt := range tests {
+		sender, body, ok := c.bridgedSender(tt.sender, tt.content, tt.body)
+		if tt.refused {
+			if ok {
+				t.Errorf("%s: bridgedSender() = %s, %q, want the message refused", tt.name, sender, body)
+			}
+			continue
+		}
+		if !ok || sender != tt.wantSender || body != tt.wantBody {
+			t.Errorf("%s: bridgedSender() = %s, %q, want %s, %q", tt.name, sender, body, tt.wantSender, tt.wantBody)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomarkdown/markdown"
@@ -20,6 +21,7 @@ import (
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -28,6 +30,11 @@ type MessageHandler interface {
 	HandleMessage(roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID)
 }
 
+// EventHandler receives every event of the room, after decryption
+type EventHandler interface {
+	HandleEvent(evt *event.Event)
+}
+
 type sessionRequestInfo struct {
 	lastRequested time.Time
 	retryCount    int
@@ -41,10 +48,65 @@ type Client struct {
 	cryptoHelper          *cryptohelper.CryptoHelper
 	config                *config.MatrixConfig
 	messageHandler        MessageHandler
+	eventHandler          EventHandler
 	mentionRegex          *regexp.Regexp
 	slashCommandRegex     *regexp.Regexp
 	requestedSessionMutex sync.Mutex
 	requestedSessions     map[string]*sessionRequestInfo
+	syncDone              chan struct{} // Closed when the sync loop exits
+	lastSync              atomic.Int64  // Unix nanoseconds of the last processed sync response
+	encryptionMutex       sync.Mutex    // Guards encryptionErr
+	encryptionErr         error         // Set if encryption is enabled but failed to set up
+
+	// Rooms from the rooms setting, true if they only accept encrypted messages
+	roomsMutex sync.RWMutex
+	rooms      map[id.RoomID]bool
+
+	// Bridge bots whose messages are handled as sent by the people they relay
+	bridges bridges
+
+	// Undecryptable events, see DecryptionFailures
+	decryptionStats decryptionStats
+
+	// Withheld room keys, see WithheldKeys
+	withheldStats   withheldStats
+	withheldHandler WithheldHandler
+
+	// Timing of the last events handled, see EventTiming
+	eventTimings eventTimings
+
+	// Hands events to the handlers, nil if they run in the sync loop
+	queue *inboundQueue
+
+	// Skips events handled before the start, nil unless read_marker.enabled
+	readMarkers *readMarkers
+
+	// Receives transactions in place of the sync loop and sends as virtual
+	// users, nil unless appservice.enabled
+	appService *appService
+
+	// The sync loop, restarted by the watchdog when responses stop arriving
+	syncMutex    sync.Mutex
+	syncCancel   context.CancelFunc // Cancels the running sync request
+	syncStop     chan struct{}      // Closed by Close
+	syncRestart  chan struct{}      // Signals runSync that the cancellation is a restart
+	syncRunning  atomic.Bool
+	syncStalled  atomic.Bool
+	syncRestarts atomic.Uint64
+	clientResets atomic.Uint64
+	transport    *resettableTransport
+}
+
+// SyncStatus describes the sync loop and encryption state for readiness checks
+type SyncStatus struct {
+	Running           bool      // The sync loop has not exited
+	LastSync          time.Time // Zero until the first sync response is processed
+	EncryptionEnabled bool
+	EncryptionError   error  // Why encryption setup failed, if it did
+	Stalled           bool   // The watchdog restarted the sync loop and no response arrived since
+	Restarts          uint64 // Sync loop restarts by the watchdog
+	ClientResets      uint64 // Resets of the connections to the homeserver by the watchdog
+	AppService        bool   // Events arrive in appservice transactions, LastSync is the last one
 }
 
 func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
@@ -55,9 +117,23 @@ func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
 		logger.Error("Failed to create Matrix client: %v", err)
 		return nil, fmt.Errorf("failed to create Matrix client: %w", err)
 	}
+	// The watchdog replaces connections that stopped working
+	transport := newResettableTransport()
+	client.Client.Transport = transport
 
 	// If device ID is empty, we need to login to get a device ID
-	if cfg.DeviceID == "" {
+	if cfg.AppService.Enabled {
+		// The registration's token authenticates the sender user, no login
+		logger.AddSecrets(cfg.AppService.ASToken, cfg.AppService.HSToken)
+		client.AccessToken = cfg.AppService.ASToken
+		client.DeviceID = id.DeviceID(cfg.DeviceID)
+	} else if cfg.OIDC.Enabled {
+		logger.Info("Logging in through the OIDC provider of the homeserver...")
+		if err := loginOIDC(context.Background(), client, cfg, transport, logger); err != nil {
+			logger.Error("Failed to login: %v", err)
+			return nil, err
+		}
+	} else if cfg.DeviceID == "" {
 		logger.Info("No device ID specified, performing login...")
 		loginResp, err := client.Login(context.Background(), &mautrix.ReqLogin{
 			Type:                     "m.login.password",
@@ -71,6 +147,8 @@ func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
 			return nil, fmt.Errorf("failed to login: %w", err)
 		}
 		
+		logger.AddSecrets(loginResp.AccessToken)
+
 		// Use the device ID returned by the server
 		cfg.DeviceID = string(loginResp.DeviceID)
 		cfg.AccessToken = loginResp.AccessToken // Use the new access token
@@ -90,6 +168,14 @@ func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
 		logger:            logger,
 		config:            cfg,
 		requestedSessions: make(map[string]*sessionRequestInfo),
+		syncDone:          make(chan struct{}),
+		syncStop:          make(chan struct{}),
+		syncRestart:       make(chan struct{}, 1),
+		transport:         transport,
+	}
+	if alert := cfg.DecryptionAlert; alert.RoomID != "" {
+		c.decryptionStats.threshold = alert.Threshold
+		c.decryptionStats.window = time.Duration(alert.Window) * time.Second
 	}
 
 	c.mentionRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
@@ -100,12 +186,23 @@ func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
 	client.Syncer = syncer
 
 	// Register event handler
+	c.queue = newInboundQueue(&cfg.Queue, logger)
+	if cfg.ReadMarker.Enabled {
+		c.readMarkers = newReadMarkers(&cfg.ReadMarker, client, logger)
+	}
 	syncer.OnEvent(c.processEvent)
 
+	// Record sync progress for readiness checks
+	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+		c.lastSync.Store(time.Now().UnixNano())
+		return true
+	})
+
 	// Setup crypto helper
 	if cfg.EnableEncryption {
 		logger.Info("Encryption enabled, setting up crypto helper")
 		if err := c.setupEncryption(); err != nil {
+			c.setEncryptionError(err)
 			logger.Error("Failed to setup encryption: %v", err)
 			logger.Warn("Continuing without encryption")
 		} else {
@@ -113,29 +210,233 @@ func New(cfg *config.MatrixConfig, logger *logger.Logger) (*Client, error) {
 		}
 	}
 
-	// Start syncing in background
-	go func() {
-		c.logger.Info("Starting Matrix sync loop...")
-		if err := client.Sync(); err != nil {
-			c.logger.Error("Sync loop failed: %v", err)
-			c.logger.Info("Sync error is not fatal - bot will continue running but may not receive new messages")
+	if cfg.AppService.Enabled {
+		// Events arrive in transactions, there is no sync loop to run
+		logger.Info("Running as an application service, waiting for transactions")
+		c.appService = newAppService(&cfg.AppService, client, logger)
+		close(c.syncDone)
+	} else {
+		// Start syncing in background
+		c.syncRunning.Store(true)
+		go c.runSync()
+		if cfg.Watchdog.StaleAfter > 0 {
+			go c.watchSync(&cfg.Watchdog, time.Now())
 		}
-	}()
+	}
+	if c.cryptoHelper != nil && cfg.KeyRotation.ReshareInterval > 0 {
+		go c.reshareKeys(time.Duration(cfg.KeyRotation.ReshareInterval) * time.Second)
+	}
+	if c.readMarkers != nil {
+		go c.readMarkers.run(time.Duration(cfg.ReadMarker.Interval)*time.Second, c.syncStop)
+	}
 
 	logger.Info("Matrix client initialized successfully")
 
 	return c, nil
 }
 
+// runSync runs the sync loop until Close, starting it again when the
+// watchdog restarts it
+func (c *Client) runSync() {
+	defer close(c.syncDone)
+	c.logger.Info("Starting Matrix sync loop...")
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.syncMutex.Lock()
+		select {
+		case <-c.syncStop:
+			// Closed before the sync started, which would not see StopSync
+			c.syncMutex.Unlock()
+			cancel()
+			return
+		default:
+		}
+		c.syncCancel = cancel
+		c.syncMutex.Unlock()
+
+		c.syncRunning.Store(true)
+		err := c.client.SyncWithContext(ctx)
+		c.syncRunning.Store(false)
+		cancel()
+
+		select {
+		case <-c.syncStop:
+			return
+		case <-c.syncRestart:
+			c.logger.Info("Restarting Matrix sync loop")
+			continue
+		default:
+		}
+		if err != nil {
+			c.logger.Error("Sync loop failed: %v", err)
+		}
+		if c.config.Watchdog.StaleAfter <= 0 {
+			c.logger.Info("Sync error is not fatal - bot will continue running but may not receive new messages")
+			return
+		}
+		c.logger.Info("The sync watchdog restarts the sync loop once it notices")
+		select {
+		case <-c.syncStop:
+			return
+		case <-c.syncRestart:
+			c.logger.Info("Restarting Matrix sync loop")
+		}
+	}
+}
+
+// restartSync cancels the running sync request, or wakes a sync loop that
+// failed, so that runSync starts syncing again
+func (c *Client) restartSync() {
+	select {
+	case c.syncRestart <- struct{}{}:
+	default:
+	}
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+	if c.syncCancel != nil {
+		c.syncCancel()
+	}
+}
+
+// Close stops the sync loop, waits for it to exit and for the queued events
+// to be handled (or ctx to expire) and closes the crypto store
+func (c *Client) Close(ctx context.Context) error {
+	c.logger.Info("Stopping Matrix sync loop")
+	close(c.syncStop)
+	c.client.StopSync()
+	// A sync started before StopSync may not see it, so it is cancelled
+	c.syncMutex.Lock()
+	if c.syncCancel != nil {
+		c.syncCancel()
+	}
+	c.syncMutex.Unlock()
+
+	select {
+	case <-c.syncDone:
+		c.logger.Info("Matrix sync loop stopped")
+	case <-ctx.Done():
+		c.logger.Warn("Timed out waiting for Matrix sync loop to stop")
+		return ctx.Err()
+	}
+
+	if c.queue != nil {
+		if err := c.queue.close(ctx); err != nil {
+			c.logger.Warn("Timed out waiting for queued events to be handled")
+			return err
+		}
+	}
+	if c.readMarkers != nil {
+		c.readMarkers.flush(ctx)
+	}
+
+	if c.cryptoHelper != nil {
+		if err := c.cryptoHelper.Close(); err != nil {
+			c.logger.Error("Failed to close crypto store: %v", err)
+			return fmt.Errorf("failed to close crypto store: %w", err)
+		}
+	}
+	return nil
+}
+
+// SyncStatus returns the current state of the sync loop and encryption
+func (c *Client) SyncStatus() SyncStatus {
+	status := SyncStatus{
+		EncryptionEnabled: c.config.EnableEncryption,
+	}
+	c.encryptionMutex.Lock()
+	status.EncryptionError = c.encryptionErr
+	c.encryptionMutex.Unlock()
+
+	status.Running = c.syncRunning.Load()
+	select {
+	case <-c.syncDone:
+		status.Running = false
+	default:
+	}
+	if c.appService != nil {
+		status.AppService = true
+		status.Running = true
+	}
+	status.Stalled = c.syncStalled.Load()
+	status.Restarts = c.syncRestarts.Load()
+	status.ClientResets = c.clientResets.Load()
+	if nanos := c.lastSync.Load(); nanos != 0 {
+		status.LastSync = time.Unix(0, nanos)
+	}
+	return status
+}
+
+func (c *Client) setEncryptionError(err error) {
+	c.encryptionMutex.Lock()
+	defer c.encryptionMutex.Unlock()
+	c.encryptionErr = err
+}
+
+// Reverify verifies the device with the recovery key again, e.g. after the
+// key was rotated or the initial verification failed. On success any earlier
+// encryption error is cleared.
+func (c *Client) Reverify() error {
+	if c.cryptoHelper == nil {
+		return fmt.Errorf("encryption is not set up")
+	}
+
+	c.logger.Info("Re-verifying device with recovery key")
+	if err := c.verifyWithRecoveryKey(c.cryptoHelper.Machine()); err != nil {
+		c.logger.Error("Re-verification failed: %v", err)
+		c.setEncryptionError(fmt.Errorf("failed to verify with recovery key: %w", err))
+		return err
+	}
+
+	c.setEncryptionError(nil)
+	c.logger.Info("Device re-verified successfully")
+	return nil
+}
+
 func (c *Client) SetMessageHandler(handler MessageHandler) {
 	c.messageHandler = handler
 }
 
+// SetEventHandler registers a handler for all events of the room, including
+// those that are not messages to the bot
+func (c *Client) SetEventHandler(handler EventHandler) {
+	c.eventHandler = handler
+}
+
 // GetDeviceID returns the current device ID (may have changed after login)
 func (c *Client) GetDeviceID() string {
 	return string(c.client.DeviceID)
 }
 
+// Whoami returns the user the access token belongs to, confirming that the
+// homeserver accepts it
+func (c *Client) Whoami(ctx context.Context) (id.UserID, error) {
+	resp, err := c.client.Whoami(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to query the logged-in user: %w", err)
+	}
+	return resp.UserID, nil
+}
+
+// EnsureJoined makes sure the bot is a member of a room, joining it if it is
+// not yet. It reports whether the room had to be joined.
+func (c *Client) EnsureJoined(ctx context.Context, roomID id.RoomID) (bool, error) {
+	rooms, err := c.client.JoinedRooms(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to list joined rooms: %w", err)
+	}
+	for _, joined := range rooms.JoinedRooms {
+		if joined == roomID {
+			return false, nil
+		}
+	}
+
+	c.logger.Info("Not a member of room %s, joining", roomID)
+	if _, err := c.client.JoinRoomByID(ctx, roomID); err != nil {
+		return false, fmt.Errorf("failed to join room %s: %w", roomID, err)
+	}
+	return true, nil
+}
+
 func (c *Client) setupEncryption() error {
 	// Setup crypto helper
 	cryptoHelper, err := c.setupCryptoHelper()
@@ -145,6 +446,7 @@ func (c *Client) setupEncryption() error {
 
 	c.client.Crypto = cryptoHelper
 	c.cryptoHelper = cryptoHelper
+	c.setupKeyRotation(cryptoHelper.Machine())
 
 	// Wait for initial sync
 	readyChan := make(chan bool)
@@ -153,36 +455,30 @@ func (c *Client) setupEncryption() error {
 	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
 		// Process to-device events first (they may contain room keys needed for decryption)
 		if len(resp.ToDevice.Events) > 0 {
-			c.logger.Info("Processing to-device events", "count", len(resp.ToDevice.Events))
+			c.logger.Info("Processing %d to-device events", len(resp.ToDevice.Events))
 			for i, evt := range resp.ToDevice.Events {
-				c.logger.Debug("Processing to-device event",
-					"index", i,
-					"type", evt.Type,
-					"sender", evt.Sender)
+				c.logger.DebugSampled("Processing to-device event: index=%d, type=%s, sender=%s",
+					i, evt.Type.Type, evt.Sender)
 
 				// Handle room key events specially to improve logging
 				switch evt.Type {
 				case event.ToDeviceRoomKey:
 					if key, ok := evt.Content.Parsed.(*event.RoomKeyEventContent); ok {
-						c.logger.Info("Received room key",
-							"algorithm", key.Algorithm,
-							"room_id", key.RoomID,
-							"session_id", key.SessionID)
+						c.logger.Info("Received room key: algorithm=%s, room_id=%s, session_id=%s",
+							key.Algorithm, key.RoomID, key.SessionID)
 					}
 				case event.ToDeviceForwardedRoomKey:
 					if key, ok := evt.Content.Parsed.(*event.ForwardedRoomKeyEventContent); ok {
-						c.logger.Info("Received forwarded room key",
-							"algorithm", key.Algorithm,
-							"room_id", key.RoomID,
-							"session_id", key.SessionID,
-							"sender_key", key.SenderKey)
+						c.logger.Info("Received forwarded room key: algorithm=%s, room_id=%s, session_id=%s, sender_key=%s",
+							key.Algorithm, key.RoomID, key.SessionID, key.SenderKey)
 					}
 				case event.ToDeviceRoomKeyRequest:
 					if req, ok := evt.Content.Parsed.(*event.RoomKeyRequestEventContent); ok {
-						c.logger.Debug("Received room key request",
-							"request_id", req.RequestID,
-							"action", req.Action)
+						c.logger.DebugSampled("Received room key request: request_id=%s, action=%s",
+							req.RequestID, req.Action)
 					}
+				case event.ToDeviceRoomKeyWithheld, event.ToDeviceOrgMatrixRoomKeyWithheld:
+					c.handleWithheldEvent(evt)
 				}
 				// The crypto helper will automatically process these to-device events
 				// when ProcessSyncResponse is called by the syncer
@@ -239,7 +535,7 @@ func (c *Client) setupEncryption() error {
 
 func (c *Client) setupCryptoHelper() (*cryptohelper.CryptoHelper, error) {
 	pickleKey := []byte(c.config.PickleKey)
-	dbPath := "matrix_crypto.db"
+	dbPath := CryptoDBPath
 
 	helper, err := cryptohelper.NewCryptoHelper(c.client, pickleKey, dbPath)
 	if err != nil {
@@ -344,11 +640,8 @@ func (c *Client) shouldRequestSession(sessionID string) bool {
 
 		minWait := backoffDurations[backoffIndex]
 		if time.Since(info.lastRequested) < minWait {
-			c.logger.Debug("Session was requested recently, not requesting again",
-				"session_id", sessionID,
-				"retry_count", info.retryCount,
-				"min_wait_seconds", minWait.Seconds(),
-				"elapsed_seconds", time.Since(info.lastRequested).Seconds())
+			c.logger.Debug("Session was requested recently, not requesting again: session_id=%s, retry_count=%d, min_wait=%v, elapsed=%v",
+				sessionID, info.retryCount, minWait, time.Since(info.lastRequested))
 			return false
 		}
 
@@ -374,7 +667,7 @@ func (c *Client) shouldRequestSession(sessionID string) bool {
 func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*event.Event, error) {
 	// Crypto helper not initialized - can't decrypt
 	if c.cryptoHelper == nil {
-		return nil, fmt.Errorf("crypto helper is nil, encryption not initialized")
+		return nil, errNoCryptoHelper
 	}
 
 	if evt.Content.Parsed != nil {
@@ -386,6 +679,10 @@ func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*even
 		c.logger.Debug("Direct decryption failed: %v", err)
 
 		if enc, ok := evt.Content.Parsed.(*event.EncryptedEventContent); ok {
+			if withheld := c.withheldKey(ctx, evt.RoomID, enc.SessionID, err); withheld != nil {
+				c.logger.Info("Not requesting session %s, the sender withheld it: %s", enc.SessionID, withheld.Code)
+				return nil, err
+			}
 			if strings.Contains(err.Error(), "no session with given ID found") {
 				c.logger.Info("Missing megolm session detected, will attempt to request it: session_id=%s, room_id=%s, sender_key=%s, event_id=%s",
 					enc.SessionID, evt.RoomID, enc.SenderKey, evt.ID)
@@ -405,6 +702,9 @@ func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*even
 							if decryptedAfterSess, decryptErr := c.cryptoHelper.Decrypt(ctx, evt); decryptErr == nil {
 								c.logger.Info("Successfully decrypted event after receiving session: session_id=%s, event_id=%s", enc.SessionID, evt.ID)
 								return decryptedAfterSess, nil
+							} else if c.withheldKey(ctx, evt.RoomID, enc.SessionID, decryptErr) != nil {
+								c.logger.Info("Session %s was withheld while waiting for it: %v", enc.SessionID, decryptErr)
+								return nil, decryptErr
 							} else {
 								c.logger.Error("Failed to decrypt even after receiving session: %v", decryptErr)
 							}
@@ -433,6 +733,9 @@ func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*even
 	if err == nil {
 		return decrypted, nil
 	}
+	if c.withheldKey(ctx, evtCopy.RoomID, encContent.SessionID, err) != nil {
+		return nil, err
+	}
 
 	c.logger.Info("Missing megolm session after reparse, will attempt to request it: session_id=%s, room_id=%s, sender_key=%s, event_id=%s",
 		encContent.SessionID, evtCopy.RoomID, encContent.SenderKey, evtCopy.ID)
@@ -454,6 +757,9 @@ func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*even
 				if decryptedAfterSessReparse, decryptErr := c.cryptoHelper.Decrypt(ctx, &evtCopy); decryptErr == nil {
 					c.logger.Info("Successfully decrypted event after receiving session (reparse): session_id=%s, event_id=%s", encContent.SessionID, evtCopy.ID)
 					return decryptedAfterSessReparse, nil
+				} else if c.withheldKey(ctx, evtCopy.RoomID, encContent.SessionID, decryptErr) != nil {
+					c.logger.Info("Session %s was withheld while waiting for it (reparse): %v", encContent.SessionID, decryptErr)
+					return nil, decryptErr
 				} else {
 					c.logger.Error("Failed to decrypt even after receiving session (reparse): %v", decryptErr)
 				}
@@ -466,20 +772,63 @@ func (c *Client) attemptDecryption(ctx context.Context, evt *event.Event) (*even
 	return nil, err
 }
 
+// SetRooms sets the rooms listened to besides matrix.roomid, and which rooms
+// only accept encrypted messages
+func (c *Client) SetRooms(rooms []config.RoomConfig) {
+	byID := make(map[id.RoomID]bool, len(rooms))
+	for _, room := range rooms {
+		byID[id.RoomID(room.RoomID)] = room.RequireEncryption
+	}
+	c.roomsMutex.Lock()
+	defer c.roomsMutex.Unlock()
+	c.rooms = byID
+}
+
+// roomPolicy reports whether the client listens in the room and whether the
+// room only accepts encrypted messages
+func (c *Client) roomPolicy(roomID id.RoomID) (listening bool, requireEncryption bool) {
+	c.roomsMutex.RLock()
+	defer c.roomsMutex.RUnlock()
+	requireEncryption, listed := c.rooms[roomID]
+	return listed || string(roomID) == c.roomID, requireEncryption
+}
+
 func (c *Client) processEvent(ctx context.Context, evt *event.Event) {
-	if string(evt.RoomID) != c.roomID {
+	c.logger.DebugSampled("Sync event: type=%s, room_id=%s, event_id=%s, sender=%s",
+		evt.Type.Type, evt.RoomID, evt.ID, evt.Sender)
+
+	listening, requireEncryption := c.roomPolicy(evt.RoomID)
+	if !listening || c.IsVirtualUser(evt.Sender) {
+		return
+	}
+	if c.readMarkers != nil && c.readMarkers.handledBefore(ctx, evt) {
+		c.logger.DebugSampled("Skipping event %s of %s, sent before the fully-read marker", evt.ID, evt.RoomID)
 		return
 	}
 
+	timing := newEventTiming(evt)
 	if evt.Type == event.EventEncrypted {
 		if evt.RoomID == "" {
 			evt.RoomID = id.RoomID(c.roomID)
 		}
 
 		decryptedEvt, err := c.attemptDecryption(ctx, evt)
+		timing.Decryption = time.Since(timing.Received)
 		if err != nil {
 			c.logger.Error("Failed to decrypt event after all attempts: %v", err)
 
+			var sessionID id.SessionID
+			if enc, ok := evt.Content.Parsed.(*event.EncryptedEventContent); ok {
+				sessionID = enc.SessionID
+			}
+			c.recordDecryptionFailure(evt.RoomID, sessionID, err)
+			if withheld := c.withheldKey(ctx, evt.RoomID, sessionID, err); withheld != nil {
+				c.logger.Warn("Cannot decrypt event %s of %s in %s, the sender's device withheld the key: %s",
+					evt.ID, evt.Sender, evt.RoomID, withheld.Code)
+				c.notifyWithheld(evt, sessionID, withheld.Code)
+				return
+			}
+
 			if evt.Content.Parsed != nil {
 				if enc, ok := evt.Content.Parsed.(*event.EncryptedEventContent); ok {
 					c.logger.Warn("Decryption failed for encrypted event: algorithm=%s, sender_key=%s, session_id=%s, event_id=%s, room_id=%s. This usually means the session was not received from other devices.",
@@ -490,10 +839,39 @@ func (c *Client) processEvent(ctx context.Context, evt *event.Event) {
 			return
 		}
 
+		// The decrypted event does not say where it came from
+		source := evt.Mautrix.EventSource
 		*evt = *decryptedEvt
+		evt.Mautrix.EventSource = source
+	}
+
+	if c.queue == nil {
+		c.handleEvent(evt, timing, requireEncryption)
+		return
+	}
+	c.queue.enqueue(inboundQueueKey(evt), inboundTask{eventID: evt.ID, roomID: evt.RoomID, handle: func() {
+		c.handleEvent(evt, timing, requireEncryption)
+	}})
+}
+
+// handleEvent passes a decrypted event to the event handler, and messages
+// mentioning the bot to the message handler
+func (c *Client) handleEvent(evt *event.Event, timing EventTiming, requireEncryption bool) {
+	if c.readMarkers != nil {
+		defer c.readMarkers.handled(evt)
+	}
+	timing.Handled = time.Now()
+	c.eventTimings.record(evt.ID, timing)
+	if c.eventHandler != nil {
+		c.eventHandler.HandleEvent(evt)
 	}
 
 	if evt.Type == event.EventMessage {
+		if requireEncryption && !timing.Encrypted {
+			c.logger.Warn("Ignoring unencrypted message %s in room %s, which requires encryption", evt.ID, evt.RoomID)
+			return
+		}
+
 		messageContent := evt.Content.AsMessage()
 		if messageContent == nil {
 			c.logger.Debug("Event type is EventMessage but content is not a valid message: event_id=%s, room_id=%s",
@@ -503,7 +881,7 @@ func (c *Client) processEvent(ctx context.Context, evt *event.Event) {
 
 		mentionsMe := false
 		c.logger.Info("=== MATRIX MESSAGE RECEIVED === sender=%s, room_id=%s, body=%s, msgtype=%s, event_id=%s",
-			evt.Sender, evt.RoomID, messageContent.Body, messageContent.MsgType, evt.ID)
+			evt.Sender, evt.RoomID, c.logger.Message(messageContent.Body), messageContent.MsgType, evt.ID)
 
 		if messageContent.Mentions != nil {
 			for _, userID := range messageContent.Mentions.UserIDs {
@@ -514,20 +892,28 @@ func (c *Client) processEvent(ctx context.Context, evt *event.Event) {
 		}
 
 		if !mentionsMe {
-			c.logger.Debug("Message not directed at bot, ignoring")
+			c.logger.DebugSampled("Message not directed at bot, ignoring")
 			return
 		}
 
-		body := c.mentionRegex.ReplaceAllString(messageContent.Body, "$1")
+		sender, relayed, ok := c.bridgedSender(evt.Sender, messageContent, messageContent.Body)
+		if !ok {
+			c.logger.Warn("Ignoring message %s relayed by bridge bot %s without a sender name that maps to a user ID", evt.ID, evt.Sender)
+			return
+		}
+		if sender != evt.Sender {
+			c.logger.Info("Message %s was relayed by bridge bot %s for %s", evt.ID, evt.Sender, sender)
+		}
+		body := c.mentionRegex.ReplaceAllString(relayed, "$1")
 
-		senderID := string(evt.Sender)
+		senderID := string(sender)
 		username := senderID
 		if idx := strings.Index(username, ":"); idx > 0 {
 			username = username[1:idx]
 		}
 
 		c.logger.Info("Processing message from user: username=%s, sender_id=%s, message=%s",
-			username, senderID, body)
+			username, senderID, c.logger.Message(body))
 
 		// Thread detection - check if this message is a reply or in a thread
 		var inReplyToEventID id.EventID
@@ -555,19 +941,33 @@ func (c *Client) processEvent(ctx context.Context, evt *event.Event) {
 		}
 
 		if c.messageHandler != nil {
-			c.messageHandler.HandleMessage(evt.RoomID, evt.Sender, body, inReplyToEventID, threadRootEventID, evt.ID)
+			c.messageHandler.HandleMessage(evt.RoomID, sender, body, inReplyToEventID, threadRootEventID, evt.ID)
 		}
 	}
 }
 
-// SendMessage sends a message to the Matrix room with optional reply and mention support
-func (c *Client) SendMessage(message string, opts ...SendMessageOption) error {
-	c.logger.Info("Sending message to Matrix room %s", c.roomID)
+// Message formats accepted by WithFormat
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatPlain    = "plain"
+)
+
+// Message types accepted by WithMsgType
+const (
+	MsgTypeText   = "text"
+	MsgTypeNotice = "notice"
+)
 
+// SendMessage sends a message to the Matrix room with optional reply, thread,
+// mention and formatting support. It returns the ID of the created event.
+func (c *Client) SendMessage(message string, opts ...SendMessageOption) (id.EventID, error) {
 	// Apply default options
 	options := &SendMessageOptions{
 		InReplyToEventID: "",
 		MentionUserID:    "",
+		Format:           FormatMarkdown,
+		MsgType:          MsgTypeText,
 	}
 
 	// Override with provided options
@@ -575,19 +975,77 @@ func (c *Client) SendMessage(message string, opts ...SendMessageOption) error {
 		opt(options)
 	}
 
-	content := event.MessageEventContent{
-		MsgType:       event.MsgText,
-		Body:          message,
-		Format:        event.FormatHTML,
-		FormattedBody: formatMessage(message),
+	log := c.sendLogger(options)
+	roomID := c.targetRoom(options)
+	log.Info("Sending message to Matrix room %s", roomID)
+
+	content, err := c.buildMessageContent(message, roomID, options)
+	if err != nil {
+		log.Error("Invalid message options: %v", err)
+		return "", err
 	}
 
-	// Set reply if inReplyToEventID is provided
-	if options.InReplyToEventID != "" {
+	resp, err := c.sender(context.Background(), options, roomID).SendMessageEvent(context.Background(), roomID, event.EventMessage, content)
+	if err != nil {
+		log.Error("Failed to send message to Matrix: %v", err)
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+
+	log.Info("Message sent to Matrix successfully (event_id: %s)", resp.EventID)
+	return resp.EventID, nil
+}
+
+// targetRoom returns the room a message should be sent to
+func (c *Client) targetRoom(options *SendMessageOptions) id.RoomID {
+	if options.RoomID != "" {
+		return options.RoomID
+	}
+	return id.RoomID(c.roomID)
+}
+
+// buildMessageContent creates the event content for a message
+func (c *Client) buildMessageContent(message string, roomID id.RoomID, options *SendMessageOptions) (*event.MessageEventContent, error) {
+	content := &event.MessageEventContent{
+		Body: message,
+	}
+
+	switch options.MsgType {
+	case MsgTypeText, "":
+		content.MsgType = event.MsgText
+	case MsgTypeNotice:
+		content.MsgType = event.MsgNotice
+	default:
+		return nil, fmt.Errorf("unknown msgtype %q (expected %q or %q)", options.MsgType, MsgTypeText, MsgTypeNotice)
+	}
+
+	switch options.Format {
+	case FormatMarkdown, "":
+		content.Format = event.FormatHTML
+		content.FormattedBody = formatMessage(message)
+	case FormatHTML:
+		content.Body = format.HTMLToText(message)
+		content.Format = event.FormatHTML
+		content.FormattedBody = message
+	case FormatPlain:
+		// Body only
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected %q, %q or %q)", options.Format, FormatMarkdown, FormatHTML, FormatPlain)
+	}
+
+	if options.ThreadRootEventID != "" {
+		// Post in the thread; an explicit reply target becomes a real reply
+		// inside the thread, otherwise the root is used as reply fallback
+		c.logger.Debug("Setting thread root: %s", options.ThreadRootEventID)
+		content.RelatesTo = (&event.RelatesTo{}).SetThread(options.ThreadRootEventID, options.ThreadRootEventID)
+		if options.InReplyToEventID != "" {
+			content.RelatesTo.SetReplyTo(options.InReplyToEventID)
+		}
+	} else if options.InReplyToEventID != "" {
+		// Set reply if inReplyToEventID is provided
 		c.logger.Debug("Setting reply to event: %s", options.InReplyToEventID)
 		content.SetReply(&event.Event{
 			ID:     options.InReplyToEventID,
-			RoomID: id.RoomID(c.roomID),
+			RoomID: roomID,
 			Sender: id.UserID(c.config.UserID),
 		})
 	}
@@ -600,20 +1058,22 @@ func (c *Client) SendMessage(message string, opts ...SendMessageOption) error {
 		}
 	}
 
-	_, err := c.client.SendMessageEvent(context.Background(), id.RoomID(c.roomID), event.EventMessage, content)
-	if err != nil {
-		c.logger.Error("Failed to send message to Matrix: %v", err)
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-
-	c.logger.Info("Message sent to Matrix successfully")
-	return nil
+	return content, nil
 }
 
 // SendMessageOptions holds optional parameters for SendMessage
 type SendMessageOptions struct {
-	InReplyToEventID id.EventID
-	MentionUserID    id.UserID
+	InReplyToEventID  id.EventID
+	MentionUserID     id.UserID
+	RoomID            id.RoomID  // Defaults to the configured room
+	ThreadRootEventID id.EventID // Post the message in this thread
+	Format            string     // FormatMarkdown (default), FormatHTML or FormatPlain
+	MsgType           string     // MsgTypeText (default) or MsgTypeNotice
+	RequestID         string     // Tags the log lines of the send
+	Sender            string     // Command or hook whose virtual user sends the message
+
+	// Request ID and correlation fields of the log lines of the send
+	LogContext context.Context
 }
 
 // SendMessageOption is a function that modifies SendMessageOptions
@@ -633,41 +1093,227 @@ func WithMention(userID id.UserID) SendMessageOption {
 	}
 }
 
-// SendFile sends a message as a file attachment to the Matrix room
-func (c *Client) SendFile(message, filename string) error {
-	c.logger.Info("Sending message as file to Matrix room %s with filename %s", c.roomID, filename)
+// WithRoom sends the message to a room other than the configured one
+func WithRoom(roomID id.RoomID) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.RoomID = roomID
+	}
+}
+
+// WithThread posts the message in the thread rooted at eventID
+func WithThread(eventID id.EventID) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.ThreadRootEventID = eventID
+	}
+}
+
+// WithFormat sets how the message body is interpreted (markdown, html or plain)
+func WithFormat(format string) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.Format = format
+	}
+}
+
+// WithMsgType sets the message type (text or notice)
+func WithMsgType(msgType string) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.MsgType = msgType
+	}
+}
+
+// WithRequestID tags the log lines of the send with the ID of the request
+// that caused it
+func WithRequestID(requestID string) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.RequestID = requestID
+	}
+}
+
+// WithSender sends the message as the virtual user of a command or hook in
+// matrix.appservice.users. Without appservice mode or a virtual user for
+// name, the bot sends it.
+func WithSender(name string) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.Sender = name
+	}
+}
+
+// WithLogContext tags the log lines of the send with the request ID and
+// correlation fields carried by ctx, e.g. the room and event of the message
+// being replied to
+func WithLogContext(ctx context.Context) SendMessageOption {
+	return func(opts *SendMessageOptions) {
+		opts.LogContext = ctx
+	}
+}
+
+// sendLogger returns the logger for the log lines of a send
+func (c *Client) sendLogger(options *SendMessageOptions) *logger.Logger {
+	log := c.logger.WithRequestID(options.RequestID)
+	if options.LogContext != nil {
+		log = log.Ctx(options.LogContext)
+	}
+	return log
+}
+
+// SendFile sends a message as a file attachment to the Matrix room and
+// returns the ID of the created event. Only the room, thread and reply
+// options apply to files.
+func (c *Client) SendFile(message, filename string, opts ...SendMessageOption) (id.EventID, error) {
+	return c.SendMedia([]byte(message), filename, "text/markdown", "", opts...)
+}
+
+// SendMedia uploads data to the Matrix media repository and posts it to the
+// room as an image, video, audio or file message depending on its MIME type.
+// A non-empty caption is sent as the message body. Only the room, thread and
+// reply options apply to media.
+func (c *Client) SendMedia(data []byte, filename, mimeType, caption string, opts ...SendMessageOption) (id.EventID, error) {
+	options := &SendMessageOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	log := c.sendLogger(options)
+	roomID := c.targetRoom(options)
 
-	// Convert message to bytes
-	data := []byte(message)
+	log.Info("Sending %s media to Matrix room %s with filename %s (%d bytes)", mimeType, roomID, filename, len(data))
 
 	// Upload the file
-	resp, err := c.client.UploadBytesWithName(context.Background(), data, "text/markdown", filename)
+	sender := c.sender(context.Background(), options, roomID)
+	resp, err := sender.UploadBytesWithName(context.Background(), data, mimeType, filename)
 	if err != nil {
-		c.logger.Error("Failed to upload file to Matrix: %v", err)
-		return fmt.Errorf("failed to upload file: %w", err)
+		log.Error("Failed to upload file to Matrix: %v", err)
+		return "", fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	// Create file message content
+	// Create media message content
 	content := event.MessageEventContent{
-		MsgType:  event.MsgFile,
+		MsgType:  mediaMsgType(mimeType),
 		Body:     filename,
 		URL:      resp.ContentURI.CUString(),
 		FileName: filename,
 		Info: &event.FileInfo{
-			MimeType: "text/markdown",
+			MimeType: mimeType,
 			Size:     len(data),
 		},
 	}
+	if caption != "" {
+		content.Body = caption
+		content.Format = event.FormatHTML
+		content.FormattedBody = formatMessage(caption)
+	}
 
-	// Send the file message
-	_, err = c.client.SendMessageEvent(context.Background(), id.RoomID(c.roomID), event.EventMessage, &content)
+	if options.ThreadRootEventID != "" {
+		content.RelatesTo = (&event.RelatesTo{}).SetThread(options.ThreadRootEventID, options.ThreadRootEventID)
+		if options.InReplyToEventID != "" {
+			content.RelatesTo.SetReplyTo(options.InReplyToEventID)
+		}
+	} else if options.InReplyToEventID != "" {
+		content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(options.InReplyToEventID)
+	}
+
+	// Send the media message
+	sendResp, err := sender.SendMessageEvent(context.Background(), roomID, event.EventMessage, &content)
 	if err != nil {
-		c.logger.Error("Failed to send file message to Matrix: %v", err)
-		return fmt.Errorf("failed to send file message: %w", err)
+		log.Error("Failed to send file message to Matrix: %v", err)
+		return "", fmt.Errorf("failed to send file message: %w", err)
 	}
 
-	c.logger.Info("File message sent to Matrix successfully")
-	return nil
+	log.Info("File message sent to Matrix successfully (event_id: %s)", sendResp.EventID)
+	return sendResp.EventID, nil
+}
+
+// SendReaction reacts to an event with key, usually an emoji, and returns the
+// ID of the reaction event. Only the room option applies.
+func (c *Client) SendReaction(eventID id.EventID, key string, opts ...SendMessageOption) (id.EventID, error) {
+	options := &SendMessageOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	log := c.sendLogger(options)
+	roomID := c.targetRoom(options)
+
+	log.Info("Reacting with %s to event %s in Matrix room %s", key, eventID, roomID)
+
+	content := &event.ReactionEventContent{}
+	content.RelatesTo.SetAnnotation(eventID, key)
+	resp, err := c.client.SendMessageEvent(context.Background(), roomID, event.EventReaction, content)
+	if err != nil {
+		log.Error("Failed to send reaction to Matrix: %v", err)
+		return "", fmt.Errorf("failed to send reaction: %w", err)
+	}
+
+	log.Info("Reaction sent to Matrix successfully (event_id: %s)", resp.EventID)
+	return resp.EventID, nil
+}
+
+// EditMessage replaces the content of a message and returns the ID of the
+// edit event. The format and msgtype options apply to the new content; the
+// message keeps its thread and reply relations.
+func (c *Client) EditMessage(eventID id.EventID, message string, opts ...SendMessageOption) (id.EventID, error) {
+	options := &SendMessageOptions{
+		Format:  FormatMarkdown,
+		MsgType: MsgTypeText,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	log := c.sendLogger(options)
+	roomID := c.targetRoom(options)
+
+	log.Info("Editing event %s in Matrix room %s", eventID, roomID)
+
+	content, err := c.buildMessageContent(message, roomID, &SendMessageOptions{Format: options.Format, MsgType: options.MsgType})
+	if err != nil {
+		log.Error("Invalid message options: %v", err)
+		return "", err
+	}
+	content.SetEdit(eventID)
+
+	resp, err := c.client.SendMessageEvent(context.Background(), roomID, event.EventMessage, content)
+	if err != nil {
+		log.Error("Failed to send edit to Matrix: %v", err)
+		return "", fmt.Errorf("failed to send edit: %w", err)
+	}
+
+	log.Info("Edit sent to Matrix successfully (event_id: %s)", resp.EventID)
+	return resp.EventID, nil
+}
+
+// Redact removes the content of an event, with an optional reason shown to
+// clients, and returns the ID of the redaction event. Only the room option
+// applies.
+func (c *Client) Redact(eventID id.EventID, reason string, opts ...SendMessageOption) (id.EventID, error) {
+	options := &SendMessageOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	log := c.sendLogger(options)
+	roomID := c.targetRoom(options)
+
+	log.Info("Redacting event %s in Matrix room %s", eventID, roomID)
+
+	resp, err := c.client.RedactEvent(context.Background(), roomID, eventID, mautrix.ReqRedact{Reason: reason})
+	if err != nil {
+		log.Error("Failed to redact event in Matrix: %v", err)
+		return "", fmt.Errorf("failed to redact event: %w", err)
+	}
+
+	log.Info("Event redacted in Matrix successfully (event_id: %s)", resp.EventID)
+	return resp.EventID, nil
+}
+
+// mediaMsgType picks the message type for an attachment from its MIME type
+func mediaMsgType(mimeType string) event.MessageType {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return event.MsgImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return event.MsgVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return event.MsgAudio
+	default:
+		return event.MsgFile
+	}
 }
 
 // formatMessage converts markdown to HTML for Matrix formatting
@@ -3,6 +3,8 @@ package matrix
 import (
 	"testing"
 
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
@@ -252,3 +254,185 @@ func TestSendMessageOptionsEmptyValues(t *testing.T) {
 		t.Errorf("MentionUserID should remain empty, got %q", opts.MentionUserID)
 	}
 }
+
+func newTestClient() *Client {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	return &Client{
+		roomID: "!room:matrix.org",
+		logger: log,
+		config: &config.MatrixConfig{UserID: "@bot:matrix.org"},
+	}
+}
+
+// Test message content for the supported formats and message types
+func TestBuildMessageContentFormats(t *testing.T) {
+	c := newTestClient()
+
+	tests := []struct {
+		name              string
+		message           string
+		options           *SendMessageOptions
+		expectedMsgType   event.MessageType
+		expectedBody      string
+		expectedFormat    event.Format
+		expectedFormatted string
+	}{
+		{
+			name:              "Markdown text by default",
+			message:           "**bold**",
+			options:           &SendMessageOptions{},
+			expectedMsgType:   event.MsgText,
+			expectedBody:      "**bold**",
+			expectedFormat:    event.FormatHTML,
+			expectedFormatted: "<p><strong>bold</strong></p>\n",
+		},
+		{
+			name:              "HTML notice",
+			message:           "<b>bold</b>",
+			options:           &SendMessageOptions{Format: FormatHTML, MsgType: MsgTypeNotice},
+			expectedMsgType:   event.MsgNotice,
+			expectedBody:      "**bold**",
+			expectedFormat:    event.FormatHTML,
+			expectedFormatted: "<b>bold</b>",
+		},
+		{
+			name:            "Plain text",
+			message:         "**not bold**",
+			options:         &SendMessageOptions{Format: FormatPlain},
+			expectedMsgType: event.MsgText,
+			expectedBody:    "**not bold**",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := c.buildMessageContent(tt.message, "!room:matrix.org", tt.options)
+			if err != nil {
+				t.Fatalf("buildMessageContent() error = %v", err)
+			}
+			if content.MsgType != tt.expectedMsgType {
+				t.Errorf("MsgType = %q, want %q", content.MsgType, tt.expectedMsgType)
+			}
+			if content.Body != tt.expectedBody {
+				t.Errorf("Body = %q, want %q", content.Body, tt.expectedBody)
+			}
+			if content.Format != tt.expectedFormat {
+				t.Errorf("Format = %q, want %q", content.Format, tt.expectedFormat)
+			}
+			if content.FormattedBody != tt.expectedFormatted {
+				t.Errorf("FormattedBody = %q, want %q", content.FormattedBody, tt.expectedFormatted)
+			}
+		})
+	}
+}
+
+// Test that unknown formats and message types are rejected
+func TestBuildMessageContentInvalidOptions(t *testing.T) {
+	c := newTestClient()
+
+	if _, err := c.buildMessageContent("hi", "!room:matrix.org", &SendMessageOptions{Format: "rtf"}); err == nil {
+		t.Error("buildMessageContent() should reject an unknown format")
+	}
+	if _, err := c.buildMessageContent("hi", "!room:matrix.org", &SendMessageOptions{MsgType: "emote"}); err == nil {
+		t.Error("buildMessageContent() should reject an unknown msgtype")
+	}
+}
+
+// Test thread and reply relations on message content
+func TestBuildMessageContentThreading(t *testing.T) {
+	c := newTestClient()
+
+	content, err := c.buildMessageContent("hi", "!room:matrix.org", &SendMessageOptions{ThreadRootEventID: "$root"})
+	if err != nil {
+		t.Fatalf("buildMessageContent() error = %v", err)
+	}
+	if content.RelatesTo.GetThreadParent() != "$root" {
+		t.Errorf("thread parent = %q, want %q", content.RelatesTo.GetThreadParent(), "$root")
+	}
+	if !content.RelatesTo.IsFallingBack {
+		t.Error("thread message without explicit reply should use a fallback reply")
+	}
+
+	content, err = c.buildMessageContent("hi", "!room:matrix.org", &SendMessageOptions{ThreadRootEventID: "$root", InReplyToEventID: "$reply"})
+	if err != nil {
+		t.Fatalf("buildMessageContent() error = %v", err)
+	}
+	if content.RelatesTo.GetThreadParent() != "$root" {
+		t.Errorf("thread parent = %q, want %q", content.RelatesTo.GetThreadParent(), "$root")
+	}
+	if content.RelatesTo.GetNonFallbackReplyTo() != "$reply" {
+		t.Errorf("reply to = %q, want %q", content.RelatesTo.GetNonFallbackReplyTo(), "$reply")
+	}
+}
+
+// Test WithRoom, WithThread, WithFormat and WithMsgType option functions
+func TestDeliveryOptions(t *testing.T) {
+	opts := &SendMessageOptions{}
+	WithRoom("!other:matrix.org")(opts)
+	WithThread("$root")(opts)
+	WithFormat(FormatHTML)(opts)
+	WithMsgType(MsgTypeNotice)(opts)
+
+	if opts.RoomID != "!other:matrix.org" {
+		t.Errorf("RoomID = %q, want %q", opts.RoomID, "!other:matrix.org")
+	}
+	if opts.ThreadRootEventID != "$root" {
+		t.Errorf("ThreadRootEventID = %q, want %q", opts.ThreadRootEventID, "$root")
+	}
+	if opts.Format != FormatHTML {
+		t.Errorf("Format = %q, want %q", opts.Format, FormatHTML)
+	}
+	if opts.MsgType != MsgTypeNotice {
+		t.Errorf("MsgType = %q, want %q", opts.MsgType, MsgTypeNotice)
+	}
+
+	c := newTestClient()
+	if room := c.targetRoom(opts); room != "!other:matrix.org" {
+		t.Errorf("targetRoom() = %q, want %q", room, "!other:matrix.org")
+	}
+	if room := c.targetRoom(&SendMessageOptions{}); room != "!room:matrix.org" {
+		t.Errorf("targetRoom() default = %q, want %q", room, "!room:matrix.org")
+	}
+}
+
+// Test message type selection for media attachments
+func TestMediaMsgType(t *testing.T) {
+	tests := map[string]event.MessageType{
+		"image/png":       event.MsgImage,
+		"video/mp4":       event.MsgVideo,
+		"audio/ogg":       event.MsgAudio,
+		"text/markdown":   event.MsgFile,
+		"application/pdf": event.MsgFile,
+	}
+
+	for mimeType, expected := range tests {
+		if got := mediaMsgType(mimeType); got != expected {
+			t.Errorf("mediaMsgType(%q) = %q, want %q", mimeType, got, expected)
+		}
+	}
+}
+
+func TestRoomPolicy(t *testing.T) {
+	c := newTestClient()
+	c.SetRooms([]config.RoomConfig{
+		{RoomID: "!ops:matrix.org", RequireEncryption: true},
+		{RoomID: "!chat:matrix.org"},
+	})
+
+	tests := []struct {
+		room              id.RoomID
+		listening         bool
+		requireEncryption bool
+	}{
+		{"!room:matrix.org", true, false},
+		{"!ops:matrix.org", true, true},
+		{"!chat:matrix.org", true, false},
+		{"!other:matrix.org", false, false},
+	}
+	for _, tt := range tests {
+		listening, requireEncryption := c.roomPolicy(tt.room)
+		if listening != tt.listening || requireEncryption != tt.requireEncryption {
+			t.Errorf("roomPolicy(%s) = %v, %v, want %v, %v", tt.room, listening, requireEncryption, tt.listening, tt.requireEncryption)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+// Reasons an encrypted event could not be decrypted
+const (
+	DecryptionMissingSession = "missing_session" // The megolm session never arrived
+	DecryptionWithheld       = "withheld"        // The sender withheld the key
+	DecryptionOlmError       = "olm_error"       // Any other crypto error
+	DecryptionNoEncryption   = "no_encryption"   // Encryption is not set up
+)
+
+// maxTrackedSessions bounds the megolm sessions remembered per room and
+// reason, to count distinct sessions without growing forever
+const maxTrackedSessions = 1000
+
+var errNoCryptoHelper = errors.New("crypto helper is nil, encryption not initialized")
+
+// decryptionFailureReason classifies a decryption error
+func decryptionFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errNoCryptoHelper):
+		return DecryptionNoEncryption
+	case errors.Is(err, crypto.ErrGroupSessionWithheld):
+		return DecryptionWithheld
+	case errors.Is(err, crypto.NoSessionFound):
+		return DecryptionMissingSession
+	default:
+		return DecryptionOlmError
+	}
+}
+
+// DecryptionFailure counts the events of a room that failed to decrypt for
+// the same reason
+type DecryptionFailure struct {
+	RoomID   id.RoomID
+	Reason   string
+	Count    uint64
+	Sessions int // Distinct megolm sessions involved
+}
+
+type decryptionKey struct {
+	roomID id.RoomID
+	reason string
+}
+
+// decryptionStats counts decryption failures and decides when to alert.
+// The zero value counts without alerting.
+type decryptionStats struct {
+	mutex    sync.Mutex
+	counts   map[decryptionKey]uint64
+	sessions map[decryptionKey]map[id.SessionID]struct{}
+
+	// Alert when threshold failures happened within window
+	threshold int
+	window    time.Duration
+	recent    []time.Time // Failures within the window, oldest first
+	alerted   time.Time   // When the last alert was raised
+}
+
+// record counts a failure and reports whether an alert is due, along with
+// the number of failures within the alert window
+func (s *decryptionStats) record(roomID id.RoomID, sessionID id.SessionID, reason string, now time.Time) (alert bool, recent int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := decryptionKey{roomID, reason}
+	if s.counts == nil {
+		s.counts = make(map[decryptionKey]uint64)
+		s.sessions = make(map[decryptionKey]map[id.SessionID]struct{})
+	}
+	s.counts[key]++
+	if sessionID != "" {
+		sessions := s.sessions[key]
+		if sessions == nil {
+			sessions = make(map[id.SessionID]struct{})
+			s.sessions[key] = sessions
+		}
+		if len(sessions) < maxTrackedSessions {
+			sessions[sessionID] = struct{}{}
+		}
+	}
+
+	if s.threshold <= 0 {
+		return false, 0
+	}
+	cutoff := now.Add(-s.window)
+	kept := s.recent[:0]
+	for _, t := range s.recent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	s.recent = append(kept, now)
+	if len(s.recent) < s.threshold || now.Sub(s.alerted) < s.window {
+		return false, len(s.recent)
+	}
+	s.alerted = now
+	return true, len(s.recent)
+}
+
+// snapshot returns the failure counts sorted by room and reason
+func (s *decryptionStats) snapshot() []DecryptionFailure {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failures := make([]DecryptionFailure, 0, len(s.counts))
+	for key, count := range s.counts {
+		failures = append(failures, DecryptionFailure{RoomID: key.roomID, Reason: key.reason, Count: count, Sessions: len(s.sessions[key])})
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].RoomID != failures[j].RoomID {
+			return failures[i].RoomID < failures[j].RoomID
+		}
+		return failures[i].Reason < failures[j].Reason
+	})
+	return failures
+}
+
+// DecryptionFailures returns the events that failed to decrypt since
+// startup, by room and reason
+func (c *Client) DecryptionFailures() []DecryptionFailure {
+	return c.decryptionStats.snapshot()
+}
+
+// recordDecryptionFailure counts an undecryptable event and posts an alert
+// to matrix.decryption_alert.room_id once the threshold is reached
+func (c *Client) recordDecryptionFailure(roomID id.RoomID, sessionID id.SessionID, err error) {
+	reason := decryptionFailureReason(err)
+	alert, recent := c.decryptionStats.record(roomID, sessionID, reason, time.Now())
+	if !alert {
+		return
+	}
+
+	window := c.decryptionStats.window
+	c.logger.Warn("%d events failed to decrypt in the last %s, alerting %s", recent, window, c.config.DecryptionAlert.RoomID)
+	message := fmt.Sprintf("⚠️ %d encrypted events could not be decrypted in the last %s. The latest was in %s: %s. "+
+		"Check that the bot's device is verified and that other devices share their keys with it.", recent, window, roomID, reason)
+	// Sent in the background, the sync loop must not wait for it
+	go func() {
+		if _, err := c.SendMessage(message, WithRoom(id.RoomID(c.config.DecryptionAlert.RoomID)), WithMsgType(MsgTypeNotice)); err != nil {
+			c.logger.Error("Failed to send decryption failure alert: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,72 @@
+package matrix
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestDecryptionFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w (ID abc)", crypto.NoSessionFound), DecryptionMissingSession},
+		{fmt.Errorf("failed to get group session: %w", fmt.Errorf("%w (m.unverified)", crypto.ErrGroupSessionWithheld)), DecryptionWithheld},
+		{crypto.SenderKeyMismatch, DecryptionOlmError},
+		{errNoCryptoHelper, DecryptionNoEncryption},
+	}
+	for _, tt := range tests {
+		if got := decryptionFailureReason(tt.err); got != tt.want {
+			t.Errorf("decryptionFailureReason(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDecryptionStats(t *testing.T) {
+	stats := &decryptionStats{threshold: 3, window: time.Minute}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var alerts []int
+	record := func(room, session, reason string, at time.Duration) {
+		if alert, recent := stats.record(id.RoomID("!"+room+":example.com"), id.SessionID("s"+session), reason, start.Add(at)); alert {
+			alerts = append(alerts, recent)
+		}
+	}
+	record("a", "1", DecryptionMissingSession, 0)
+	record("a", "1", DecryptionMissingSession, 10*time.Second)
+	// Alerts at the threshold, then not again within the window
+	record("b", "2", DecryptionWithheld, 20*time.Second)
+	record("a", "3", DecryptionMissingSession, 30*time.Second)
+	// The first failures have left the window, but the last alert has not
+	record("a", "3", DecryptionMissingSession, 70*time.Second)
+	// Enough recent failures again, and the window has passed
+	record("a", "3", DecryptionMissingSession, 81*time.Second)
+	if fmt.Sprint(alerts) != "[3 3]" {
+		t.Errorf("alerts with recent failures %v, want [3 3]", alerts)
+	}
+
+	failures := stats.snapshot()
+	want := []DecryptionFailure{
+		{RoomID: "!a:example.com", Reason: DecryptionMissingSession, Count: 5, Sessions: 2},
+		{RoomID: "!b:example.com", Reason: DecryptionWithheld, Count: 1, Sessions: 1},
+	}
+	if fmt.Sprint(failures) != fmt.Sprint(want) {
+		t.Errorf("snapshot = %v, want %v", failures, want)
+	}
+}
+
+func TestDecryptionStatsWithoutAlerts(t *testing.T) {
+	var stats decryptionStats
+	for i := 0; i < 10; i++ {
+		if alert, _ := stats.record("!a:example.com", "", DecryptionOlmError, time.Now()); alert {
+			t.Fatal("alerted without a threshold")
+		}
+	}
+	if failures := stats.snapshot(); len(failures) != 1 || failures[0].Count != 10 || failures[0].Sessions != 0 {
+		t.Errorf("snapshot = %v", failures)
+	}
+}
@@ -0,0 +1,130 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// historyPageSize is how many events are requested per page of room history
+const historyPageSize = 100
+
+// HistoryMessage is a message of a room's history
+type HistoryMessage struct {
+	ID          id.EventID
+	RoomID      id.RoomID
+	Sender      id.UserID
+	Timestamp   time.Time
+	Body        string // Without the mention of the bot
+	Mentions    []id.UserID
+	InReplyTo   id.EventID
+	ThreadRoot  id.EventID
+	FromBot     bool // Sent by the bot or one of its virtual users
+	MentionsBot bool // Passed to the message handler when it arrived
+}
+
+// parseContent parses the content of an event received outside a sync
+// response, where mautrix does not
+func parseContent(evt *event.Event) error {
+	if evt.StateKey != nil {
+		evt.Type.Class = event.StateEventType
+	} else {
+		evt.Type.Class = event.MessageEventType
+	}
+	err := evt.Content.ParseRaw(evt.Type)
+	if errors.Is(err, event.ErrUnsupportedContentType) || errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil
+	}
+	return err
+}
+
+// Rooms returns the rooms the bot listens in, matrix.roomid first
+func (c *Client) Rooms() []id.RoomID {
+	return c.listeningRooms()
+}
+
+// History returns the messages of roomID sent since since, oldest first.
+// Encrypted messages whose keys the bot has are decrypted, others and edits
+// are left out.
+func (c *Client) History(ctx context.Context, roomID id.RoomID, since time.Time) ([]HistoryMessage, error) {
+	var messages []HistoryMessage
+	from := ""
+	for {
+		resp, err := c.client.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, nil, historyPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the history of %s: %w", roomID, err)
+		}
+		for _, evt := range resp.Chunk {
+			if evt.Timestamp < since.UnixMilli() {
+				return reverse(messages), nil
+			}
+			evt.RoomID = roomID
+			if message, ok := c.historyMessage(ctx, evt); ok {
+				messages = append(messages, message)
+			}
+		}
+		if resp.End == "" || len(resp.Chunk) == 0 {
+			return reverse(messages), nil
+		}
+		from = resp.End
+	}
+}
+
+// historyMessage converts a message event of the history, decrypting it
+// first if needed
+func (c *Client) historyMessage(ctx context.Context, evt *event.Event) (HistoryMessage, bool) {
+	if err := parseContent(evt); err != nil {
+		return HistoryMessage{}, false
+	}
+	if evt.Type == event.EventEncrypted {
+		if c.cryptoHelper == nil {
+			return HistoryMessage{}, false
+		}
+		decrypted, err := c.cryptoHelper.Decrypt(ctx, evt)
+		if err != nil {
+			c.logger.Debug("Leaving out history event %s of %s, it cannot be decrypted: %v", evt.ID, evt.RoomID, err)
+			return HistoryMessage{}, false
+		}
+		evt = decrypted
+	}
+	content := evt.Content.AsMessage()
+	if evt.Type != event.EventMessage || content == nil || content.RelatesTo.GetReplaceID() != "" {
+		return HistoryMessage{}, false
+	}
+
+	botID := id.UserID(c.config.UserID)
+	message := HistoryMessage{
+		ID:         evt.ID,
+		RoomID:     evt.RoomID,
+		Sender:     evt.Sender,
+		Timestamp:  time.UnixMilli(evt.Timestamp),
+		Body:       content.Body,
+		InReplyTo:  content.RelatesTo.GetReplyTo(),
+		ThreadRoot: content.RelatesTo.GetThreadParent(),
+		FromBot:    evt.Sender == botID || c.IsVirtualUser(evt.Sender),
+	}
+	if content.Mentions != nil {
+		message.Mentions = content.Mentions.UserIDs
+		for _, userID := range content.Mentions.UserIDs {
+			if userID == botID {
+				message.MentionsBot = true
+			}
+		}
+	}
+	if message.MentionsBot && c.mentionRegex != nil {
+		message.Body = c.mentionRegex.ReplaceAllString(content.Body, "$1")
+	}
+	return message, true
+}
+
+func reverse(messages []HistoryMessage) []HistoryMessage {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
@@ -0,0 +1,73 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+)
+
+func TestHistory(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration) string { return strconv.FormatInt(now.Add(-ago).UnixMilli(), 10) }
+	// Two pages, newest first
+	pages := map[string]string{
+		"": `{"end": "page2", "chunk": [
+			{"type": "m.room.message", "event_id": "$reply", "sender": "@bot:example.com", "origin_server_ts": ` + at(time.Minute) + `,
+			 "content": {"msgtype": "m.text", "body": "done", "m.relates_to": {"m.in_reply_to": {"event_id": "$command"}}, "m.mentions": {"user_ids": ["@alice:example.com"]}}},
+			{"type": "m.room.message", "event_id": "$edit", "sender": "@alice:example.com", "origin_server_ts": ` + at(2*time.Minute) + `,
+			 "content": {"msgtype": "m.text", "body": "* fixed", "m.relates_to": {"rel_type": "m.replace", "event_id": "$command"}}},
+			{"type": "m.reaction", "event_id": "$reaction", "sender": "@alice:example.com", "origin_server_ts": ` + at(3*time.Minute) + `,
+			 "content": {"m.relates_to": {"rel_type": "m.annotation", "event_id": "$command", "key": "👍"}}}
+		]}`,
+		"page2": `{"end": "page3", "chunk": [
+			{"type": "m.room.message", "event_id": "$command", "sender": "@alice:example.com", "origin_server_ts": ` + at(time.Hour) + `,
+			 "content": {"msgtype": "m.text", "body": "[bot](https://matrix.to/#/@bot:example.com) /deploy", "m.mentions": {"user_ids": ["@bot:example.com"]},
+			 "m.relates_to": {"rel_type": "m.thread", "event_id": "$root"}}},
+			{"type": "m.room.message", "event_id": "$old", "sender": "@alice:example.com", "origin_server_ts": ` + at(3*time.Hour) + `,
+			 "content": {"msgtype": "m.text", "body": "too old"}}
+		]}`,
+	}
+	requested := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested++
+		if r.URL.Query().Get("dir") != "b" {
+			t.Errorf("dir = %q, want backwards", r.URL.Query().Get("dir"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(pages[r.URL.Query().Get("from")]))
+	}))
+	defer server.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cli, err := mautrix.NewClient(server.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{client: cli, logger: log, config: &config.MatrixConfig{UserID: "@bot:example.com"}, mentionRegex: regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)}
+
+	messages, err := c.History(context.Background(), "!room:example.com", now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if requested != 2 {
+		t.Errorf("%d pages requested, want the reading to stop at the first message before the window", requested)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %+v, want the command and the reply", messages)
+	}
+	command, reply := messages[0], messages[1]
+	if command.ID != "$command" || command.RoomID != "!room:example.com" || command.Body != "bot /deploy" || !command.MentionsBot || command.FromBot || command.ThreadRoot != "$root" {
+		t.Errorf("command = %+v", command)
+	}
+	if reply.ID != "$reply" || !reply.FromBot || reply.InReplyTo != "$command" || len(reply.Mentions) != 1 || reply.Mentions[0] != "@alice:example.com" {
+		t.Errorf("reply = %+v", reply)
+	}
+}
@@ -0,0 +1,149 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrEncryptionDisabled is returned for key operations without encryption
+var ErrEncryptionDisabled = errors.New("encryption is not enabled")
+
+// Rotation of outbound Megolm sessions in rooms without rotation settings
+const (
+	defaultRotationMessages = 100
+	defaultRotationPeriod   = 7 * 24 * time.Hour
+)
+
+// strictRotation returns the encryption settings of a room with the
+// rotation settings of cfg where they are stricter than the room's
+func strictRotation(content *event.EncryptionEventContent, cfg *config.KeyRotationConfig) *event.EncryptionEventContent {
+	rotated := event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	if content != nil {
+		rotated = *content
+	}
+	if maxAge := int64(cfg.MaxAge) * 1000; maxAge > 0 {
+		roomAge := rotated.RotationPeriodMillis
+		if roomAge == 0 {
+			roomAge = defaultRotationPeriod.Milliseconds()
+		}
+		rotated.RotationPeriodMillis = min(maxAge, roomAge)
+	}
+	if cfg.MaxMessages > 0 {
+		roomMessages := rotated.RotationPeriodMessages
+		if roomMessages == 0 {
+			roomMessages = defaultRotationMessages
+		}
+		rotated.RotationPeriodMessages = min(cfg.MaxMessages, roomMessages)
+	}
+	return &rotated
+}
+
+// rotationStateStore hands the crypto machine the encryption settings of
+// rooms with the configured rotation applied, which it reads when it starts
+// an outbound session
+type rotationStateStore struct {
+	crypto.StateStore
+	config *config.KeyRotationConfig
+}
+
+func (s *rotationStateStore) GetEncryptionEvent(ctx context.Context, roomID id.RoomID) (*event.EncryptionEventContent, error) {
+	content, err := s.StateStore.GetEncryptionEvent(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return strictRotation(content, s.config), nil
+}
+
+// setupKeyRotation applies matrix.key_rotation to the crypto machine
+func (c *Client) setupKeyRotation(machine *crypto.OlmMachine) {
+	cfg := &c.config.KeyRotation
+	if cfg.MaxAge > 0 || cfg.MaxMessages > 0 {
+		machine.StateStore = &rotationStateStore{StateStore: machine.StateStore, config: cfg}
+		c.logger.Info("Rotating Megolm sessions after at most %d messages and %d seconds (0 = room setting)", cfg.MaxMessages, cfg.MaxAge)
+	}
+	if cfg.VerifiedDevicesOnly {
+		machine.SendKeysMinTrust = id.TrustStateCrossSignedVerified
+		machine.ShareKeysMinTrust = id.TrustStateCrossSignedVerified
+		c.logger.Info("Sharing room keys with verified devices only")
+	}
+}
+
+// RotateKeys discards the outbound Megolm session of a room, or of every
+// encrypted room the client listens in if roomID is empty, and shares a new
+// one with the devices of the room's members. Messages sent before can
+// still be read by those who have their keys. It returns the rooms rotated.
+func (c *Client) RotateKeys(ctx context.Context, roomID id.RoomID) ([]id.RoomID, error) {
+	if c.cryptoHelper == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	rooms := []id.RoomID{roomID}
+	if roomID == "" {
+		rooms = c.listeningRooms()
+	}
+
+	machine := c.cryptoHelper.Machine()
+	var rotated []id.RoomID
+	for _, room := range rooms {
+		encrypted, err := c.client.StateStore.IsEncrypted(ctx, room)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to check encryption of %s: %w", room, err)
+		}
+		if !encrypted {
+			if roomID != "" {
+				return nil, fmt.Errorf("room %s is not encrypted", room)
+			}
+			continue
+		}
+		if err := machine.CryptoStore.RemoveOutboundGroupSession(ctx, room); err != nil {
+			return rotated, fmt.Errorf("failed to discard the session of %s: %w", room, err)
+		}
+		members, err := c.client.StateStore.GetRoomJoinedOrInvitedMembers(ctx, room)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to get the members of %s: %w", room, err)
+		}
+		if err := machine.ShareGroupSession(ctx, room, members); err != nil {
+			return rotated, fmt.Errorf("failed to share a new session in %s: %w", room, err)
+		}
+		c.logger.Info("Rotated the Megolm session of %s and shared it with %d members", room, len(members))
+		rotated = append(rotated, room)
+	}
+	return rotated, nil
+}
+
+// listeningRooms returns matrix.roomid and the rooms of the rooms setting
+func (c *Client) listeningRooms() []id.RoomID {
+	c.roomsMutex.RLock()
+	defer c.roomsMutex.RUnlock()
+	rooms := []id.RoomID{id.RoomID(c.roomID)}
+	for roomID := range c.rooms {
+		if string(roomID) != c.roomID {
+			rooms = append(rooms, roomID)
+		}
+	}
+	return rooms
+}
+
+// reshareKeys rotates the sessions of all encrypted rooms every interval
+// until the client closes, so that the keys in use reach the devices
+// verified since and no longer reach those removed
+func (c *Client) reshareKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.syncStop:
+			return
+		case <-ticker.C:
+			if _, err := c.RotateKeys(context.Background(), ""); err != nil {
+				c.logger.Error("Failed to re-share room keys: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestStrictRotation(t *testing.T) {
+	tests := []struct {
+		name         string
+		room         *event.EncryptionEventContent
+		cfg          config.KeyRotationConfig
+		wantMillis   int64
+		wantMessages int
+	}{
+		{
+			name:       "Unset keeps the room's settings",
+			room:       &event.EncryptionEventContent{RotationPeriodMillis: 7200000, RotationPeriodMessages: 50},
+			wantMillis: 7200000, wantMessages: 50,
+		},
+		{
+			name:       "Stricter than the room",
+			room:       &event.EncryptionEventContent{RotationPeriodMillis: 86400000, RotationPeriodMessages: 50},
+			cfg:        config.KeyRotationConfig{MaxAge: 3600, MaxMessages: 10},
+			wantMillis: 3600000, wantMessages: 10,
+		},
+		{
+			name:       "The room is stricter",
+			room:       &event.EncryptionEventContent{RotationPeriodMillis: 3600000, RotationPeriodMessages: 5},
+			cfg:        config.KeyRotationConfig{MaxAge: 86400, MaxMessages: 10},
+			wantMillis: 3600000, wantMessages: 5,
+		},
+		{
+			name:       "Room without settings",
+			cfg:        config.KeyRotationConfig{MaxAge: 30 * 86400, MaxMessages: 500},
+			wantMillis: defaultRotationPeriod.Milliseconds(), wantMessages: defaultRotationMessages,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strictRotation(tt.room, &tt.cfg)
+			if got.RotationPeriodMillis != tt.wantMillis || got.RotationPeriodMessages != tt.wantMessages {
+				t.Errorf("strictRotation() = %d ms, %d messages, want %d ms, %d messages",
+					got.RotationPeriodMillis, got.RotationPeriodMessages, tt.wantMillis, tt.wantMessages)
+			}
+			if tt.room == nil && got.Algorithm != id.AlgorithmMegolmV1 {
+				t.Errorf("strictRotation() algorithm = %q", got.Algorithm)
+			}
+		})
+	}
+}
+
+// encryptionStateStore returns the same encryption event for every room
+type encryptionStateStore struct {
+	content *event.EncryptionEventContent
+}
+
+func (s *encryptionStateStore) IsEncrypted(context.Context, id.RoomID) (bool, error) {
+	return true, nil
+}
+
+func (s *encryptionStateStore) GetEncryptionEvent(context.Context, id.RoomID) (*event.EncryptionEventContent, error) {
+	return s.content, nil
+}
+
+func (s *encryptionStateStore) FindSharedRooms(context.Context, id.UserID) ([]id.RoomID, error) {
+	return nil, nil
+}
+
+func TestRotationStateStore(t *testing.T) {
+	room := &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1, RotationPeriodMessages: 100}
+	store := &rotationStateStore{StateStore: &encryptionStateStore{content: room}, config: &config.KeyRotationConfig{MaxMessages: 20}}
+
+	got, err := store.GetEncryptionEvent(context.Background(), "!room:example.com")
+	if err != nil {
+		t.Fatalf("GetEncryptionEvent() error = %v", err)
+	}
+	if got.RotationPeriodMessages != 20 {
+		t.Errorf("GetEncryptionEvent() rotates after %d messages, want 20", got.RotationPeriodMessages)
+	}
+	if room.RotationPeriodMessages != 100 {
+		t.Error("GetEncryptionEvent() changed the room's encryption event")
+	}
+}
+
+func TestRotateKeysWithoutEncryption(t *testing.T) {
+	c := &Client{}
+	if _, err := c.RotateKeys(context.Background(), ""); !errors.Is(err, ErrEncryptionDisabled) {
+		t.Errorf("RotateKeys() error = %v, want ErrEncryptionDisabled", err)
+	}
+}
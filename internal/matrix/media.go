@@ -0,0 +1,59 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrMediaTooLarge is returned by DownloadMedia for files over its limit
+var ErrMediaTooLarge = errors.New("media is too large")
+
+// DownloadMedia downloads the file of an image, file, audio or video
+// message, decrypting it if it was sent to an encrypted room. Files larger
+// than maxBytes fail with ErrMediaTooLarge.
+func (c *Client) DownloadMedia(ctx context.Context, content *event.MessageEventContent, maxBytes int64) ([]byte, error) {
+	if content.Info != nil && int64(content.Info.Size) > maxBytes {
+		return nil, ErrMediaTooLarge
+	}
+	uri := content.URL
+	if content.File != nil {
+		uri = content.File.URL
+	}
+	mxc, err := uri.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URL %q: %w", uri, err)
+	}
+
+	data, err := c.download(ctx, mxc, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if content.File != nil {
+		if err := content.File.DecryptInPlace(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt media: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// download reads a file of the media repository, at most maxBytes of it
+func (c *Client) download(ctx context.Context, mxc id.ContentURI, maxBytes int64) ([]byte, error) {
+	resp, err := c.client.Download(ctx, mxc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrMediaTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,67 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestDownloadMedia(t *testing.T) {
+	plaintext := []byte("PNG image data")
+	encrypted := attachment.NewEncryptedFile()
+	ciphertext := encrypted.Encrypt(plaintext)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v1/media/download/example.com/plain":
+			w.Write(plaintext)
+		case "/_matrix/client/v1/media/download/example.com/encrypted":
+			w.Write(ciphertext)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode": "M_NOT_FOUND", "error": "Not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	cli, err := mautrix.NewClient(server.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c := &Client{client: cli}
+	ctx := context.Background()
+
+	data, err := c.DownloadMedia(ctx, &event.MessageEventContent{URL: "mxc://example.com/plain"}, 1024)
+	if err != nil || !bytes.Equal(data, plaintext) {
+		t.Errorf("DownloadMedia(plain) = %q, %v", data, err)
+	}
+
+	// As received in an event
+	var content *event.MessageEventContent
+	fileJSON, _ := json.Marshal(encrypted)
+	json.Unmarshal([]byte(`{"file": `+string(fileJSON)+`}`), &content)
+	content.File.URL = "mxc://example.com/encrypted"
+	data, err = c.DownloadMedia(ctx, content, 1024)
+	if err != nil || !bytes.Equal(data, plaintext) {
+		t.Errorf("DownloadMedia(encrypted) = %q, %v", data, err)
+	}
+
+	if _, err := c.DownloadMedia(ctx, &event.MessageEventContent{URL: "mxc://example.com/plain"}, 4); !errors.Is(err, ErrMediaTooLarge) {
+		t.Errorf("DownloadMedia() error = %v, want ErrMediaTooLarge", err)
+	}
+	announced := &event.MessageEventContent{URL: "mxc://example.com/plain", Info: &event.FileInfo{Size: 2048}}
+	if _, err := c.DownloadMedia(ctx, announced, 1024); !errors.Is(err, ErrMediaTooLarge) {
+		t.Errorf("DownloadMedia() error = %v for an announced size over the limit, want ErrMediaTooLarge", err)
+	}
+	if _, err := c.DownloadMedia(ctx, &event.MessageEventContent{URL: "mxc://example.com/missing"}, 1024); err == nil {
+		t.Error("DownloadMedia() succeeded for missing media")
+	}
+}
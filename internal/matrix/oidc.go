@@ -0,0 +1,541 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// MSC2967 scopes of the client API and of one device
+	oidcAPIScope    = "urn:matrix:org.matrix.msc2967.client:api:*"
+	oidcDeviceScope = "urn:matrix:org.matrix.msc2967.client:device:"
+	// RFC 8628 grant type of the device authorization grant
+	deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+	// How long before they expire the tokens are refreshed
+	refreshMargin = 30 * time.Second
+)
+
+// errInvalidGrant is returned when the provider no longer accepts the
+// refresh token, e.g. because the session was ended
+var errInvalidGrant = errors.New("the refresh token was revoked")
+
+// oidcMetadata holds the endpoints of the provider
+type oidcMetadata struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	RegistrationEndpoint        string `json:"registration_endpoint"`
+}
+
+// oidcTokens is what the token file keeps
+type oidcTokens struct {
+	ClientID     string    `json:"client_id"`
+	DeviceID     string    `json:"device_id"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"` // Zero if the token does not expire
+}
+
+// oauthError is an error response of the provider (RFC 6749 section 5.2)
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// oidcAuth sends the requests to the homeserver with an access token of the
+// OIDC provider. It refreshes the token before it expires and when the
+// homeserver rejects it, and logs in again if the refresh token was revoked.
+type oidcAuth struct {
+	config     *config.OIDCConfig
+	homeserver string
+	deviceID   string
+	base       http.RoundTripper // Sends the requests to the homeserver
+	client     *http.Client      // Sends the requests to the provider
+	logger     *logger.Logger
+
+	// Held while the tokens are refreshed or a login waits for approval,
+	// guards metadata
+	renewMutex sync.Mutex
+	metadata   *oidcMetadata
+
+	tokensMutex sync.RWMutex
+	tokens      oidcTokens
+}
+
+func newOIDCAuth(cfg *config.MatrixConfig, base http.RoundTripper, logger *logger.Logger) *oidcAuth {
+	return &oidcAuth{
+		config:     &cfg.OIDC,
+		homeserver: strings.TrimSuffix(cfg.Homeserver, "/"),
+		deviceID:   cfg.DeviceID,
+		base:       base,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		logger:     logger,
+	}
+}
+
+// loginOIDC authenticates client through the provider of the homeserver
+// and sets matrix.deviceid to the device logged in
+func loginOIDC(ctx context.Context, client *mautrix.Client, cfg *config.MatrixConfig, base http.RoundTripper, logger *logger.Logger) error {
+	auth := newOIDCAuth(cfg, base, logger)
+	if err := auth.login(ctx); err != nil {
+		return fmt.Errorf("OIDC login failed: %w", err)
+	}
+	client.Client.Transport = auth
+	client.AccessToken = "" // Set by auth on every request
+	client.DeviceID = id.DeviceID(auth.deviceID)
+	cfg.DeviceID = auth.deviceID
+
+	whoami, err := client.Whoami(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the OIDC login: %w", err)
+	}
+	if string(whoami.UserID) != cfg.UserID {
+		return fmt.Errorf("the OIDC login is for %s, not matrix.userid %s", whoami.UserID, cfg.UserID)
+	}
+	logger.Info("Logged in through OIDC as %s with device ID %s", whoami.UserID, auth.deviceID)
+	return nil
+}
+
+// login uses the tokens of the token file, or runs the device authorization
+// grant if there are none for the device
+func (a *oidcAuth) login(ctx context.Context) error {
+	tokens, err := a.loadTokens()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil && tokens.AccessToken != "" && (a.deviceID == "" || tokens.DeviceID == a.deviceID) {
+		a.deviceID = tokens.DeviceID
+		a.setTokens(tokens)
+		a.logger.Info("Using the OIDC tokens of %s for device %s", a.config.TokenFile, a.deviceID)
+		return nil
+	}
+
+	if a.deviceID == "" {
+		a.deviceID = randomDeviceID()
+	}
+	if a.config.ClientID == "" && tokens.ClientID != "" {
+		a.setTokens(oidcTokens{ClientID: tokens.ClientID})
+	}
+	a.renewMutex.Lock()
+	defer a.renewMutex.Unlock()
+	tokens, err = a.deviceLogin(ctx)
+	if err != nil {
+		return err
+	}
+	return a.storeTokens(tokens)
+}
+
+// RoundTrip sends req with the current access token, and once more with a
+// renewed one if the homeserver rejects it
+func (a *oidcAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, expiresAt := a.accessToken()
+	if !expiresAt.IsZero() && time.Until(expiresAt) < refreshMargin {
+		if err := a.renew(token); err != nil {
+			a.logger.Error("Failed to refresh the OIDC tokens: %v", err)
+		}
+		token, _ = a.accessToken()
+	}
+
+	resp, err := a.send(req, req.Body, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !unknownToken(resp) {
+		return resp, err
+	}
+	// Bodies that cannot be read again are not retried
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	if err := a.renew(token); err != nil {
+		a.logger.Error("The homeserver rejected the OIDC access token and renewing it failed: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	body := req.Body
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	token, _ = a.accessToken()
+	return a.send(req, body, token)
+}
+
+// send sends a copy of req with body and token
+func (a *oidcAuth) send(req *http.Request, body io.ReadCloser, token string) (*http.Response, error) {
+	authorized := req.Clone(req.Context())
+	authorized.Body = body
+	authorized.Header.Set("Authorization", "Bearer "+token)
+	return a.base.RoundTrip(authorized)
+}
+
+// unknownToken reports whether resp is an M_UNKNOWN_TOKEN error, leaving
+// its body readable
+func unknownToken(resp *http.Response) bool {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	var respErr mautrix.RespError
+	return json.Unmarshal(body, &respErr) == nil && respErr.ErrCode == mautrix.MUnknownToken.ErrCode
+}
+
+// renew refreshes the tokens, or logs in again if the refresh token was
+// revoked. Nothing is done if the tokens changed since stale was read.
+func (a *oidcAuth) renew(stale string) error {
+	a.renewMutex.Lock()
+	defer a.renewMutex.Unlock()
+	if token, _ := a.accessToken(); token != stale {
+		return nil
+	}
+
+	// Not bound to the request, which may be cancelled while a login
+	// waits for approval
+	ctx := context.Background()
+	current := a.currentTokens()
+	if current.RefreshToken != "" {
+		tokens, err := a.refresh(ctx, current)
+		if err == nil {
+			a.logger.Debug("Refreshed the OIDC tokens")
+			a.keepTokens(tokens)
+			return nil
+		}
+		if !errors.Is(err, errInvalidGrant) {
+			return err
+		}
+	}
+	a.logger.Warn("The OIDC tokens were revoked, logging in again")
+	tokens, err := a.deviceLogin(ctx)
+	if err != nil {
+		return err
+	}
+	a.keepTokens(tokens)
+	return nil
+}
+
+// keepTokens uses renewed tokens, which work for now even if they cannot be
+// saved
+func (a *oidcAuth) keepTokens(tokens oidcTokens) {
+	if err := a.storeTokens(tokens); err != nil {
+		a.logger.Warn("%v, the next start needs a new login", err)
+	}
+}
+
+// refresh exchanges the refresh token for new tokens
+func (a *oidcAuth) refresh(ctx context.Context, current oidcTokens) (oidcTokens, error) {
+	metadata, err := a.discover(ctx)
+	if err != nil {
+		return oidcTokens{}, err
+	}
+	tokens, err := a.requestTokens(ctx, metadata, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {current.RefreshToken},
+		"client_id":     {current.ClientID},
+	})
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) && oauthErr.Code == "invalid_grant" {
+		return oidcTokens{}, fmt.Errorf("%w: %v", errInvalidGrant, err)
+	}
+	if err != nil {
+		return oidcTokens{}, err
+	}
+	// Providers may keep the refresh token
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = current.RefreshToken
+	}
+	tokens.ClientID = current.ClientID
+	tokens.DeviceID = current.DeviceID
+	return tokens, nil
+}
+
+// deviceLogin runs the device authorization grant (RFC 8628): it logs the
+// code to enter at the provider and waits until the login is approved. The
+// caller holds renewMutex.
+func (a *oidcAuth) deviceLogin(ctx context.Context) (oidcTokens, error) {
+	metadata, err := a.discover(ctx)
+	if err != nil {
+		return oidcTokens{}, err
+	}
+	if metadata.DeviceAuthorizationEndpoint == "" {
+		return oidcTokens{}, fmt.Errorf("the provider %s does not support the device authorization grant", metadata.Issuer)
+	}
+	clientID, err := a.clientID(ctx, metadata)
+	if err != nil {
+		return oidcTokens{}, err
+	}
+
+	var authorization struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	if err := a.postForm(ctx, metadata.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {clientID},
+		"scope":     {oidcAPIScope + " " + oidcDeviceScope + a.deviceID},
+	}, &authorization); err != nil {
+		return oidcTokens{}, fmt.Errorf("failed to start the device login: %w", err)
+	}
+	expiresAt := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	if authorization.VerificationURIComplete != "" {
+		a.logger.Warn("Approve the login of the bot by opening %s (or open %s and enter the code %s) before %s",
+			authorization.VerificationURIComplete, authorization.VerificationURI, authorization.UserCode, expiresAt.Format(time.RFC3339))
+	} else {
+		a.logger.Warn("Approve the login of the bot by opening %s and entering the code %s before %s",
+			authorization.VerificationURI, authorization.UserCode, expiresAt.Format(time.RFC3339))
+	}
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return oidcTokens{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		tokens, err := a.requestTokens(ctx, metadata, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {authorization.DeviceCode},
+			"client_id":   {clientID},
+		})
+		var oauthErr *oauthError
+		switch {
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+		case err != nil:
+			return oidcTokens{}, fmt.Errorf("the device login failed: %w", err)
+		default:
+			tokens.ClientID = clientID
+			tokens.DeviceID = a.deviceID
+			a.logger.Info("The login of device %s was approved", a.deviceID)
+			return tokens, nil
+		}
+		if authorization.ExpiresIn > 0 && time.Now().After(expiresAt) {
+			return oidcTokens{}, errors.New("the device login was not approved in time")
+		}
+	}
+}
+
+// discover looks up the endpoints of the provider, announced by the
+// homeserver (MSC2965) unless matrix.oidc.issuer is set. The caller holds
+// renewMutex.
+func (a *oidcAuth) discover(ctx context.Context) (*oidcMetadata, error) {
+	if a.metadata != nil {
+		return a.metadata, nil
+	}
+	issuer := a.config.Issuer
+	if issuer == "" {
+		var metadata oidcMetadata
+		if err := a.getJSON(ctx, a.homeserver+"/_matrix/client/v1/auth_metadata", &metadata); err == nil && metadata.TokenEndpoint != "" {
+			a.metadata = &metadata
+			return a.metadata, nil
+		}
+		var resp struct {
+			Issuer string `json:"issuer"`
+		}
+		if err := a.getJSON(ctx, a.homeserver+"/_matrix/client/unstable/org.matrix.msc2965/auth_issuer", &resp); err != nil || resp.Issuer == "" {
+			return nil, fmt.Errorf("the homeserver does not announce an OIDC provider, set matrix.oidc.issuer: %v", err)
+		}
+		issuer = resp.Issuer
+	}
+
+	var metadata oidcMetadata
+	if err := a.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover the OIDC provider %s: %w", issuer, err)
+	}
+	if metadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("the OIDC provider %s has no token endpoint", issuer)
+	}
+	a.metadata = &metadata
+	return a.metadata, nil
+}
+
+// clientID returns matrix.oidc.client_id, the client ID registered before or
+// registers the client (MSC2966)
+func (a *oidcAuth) clientID(ctx context.Context, metadata *oidcMetadata) (string, error) {
+	if a.config.ClientID != "" {
+		return a.config.ClientID, nil
+	}
+	if current := a.currentTokens(); current.ClientID != "" {
+		return current.ClientID, nil
+	}
+	if metadata.RegistrationEndpoint == "" {
+		return "", fmt.Errorf("the provider %s does not register clients, set matrix.oidc.client_id", metadata.Issuer)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"client_name":                a.config.ClientName,
+		"client_uri":                 a.config.ClientURI,
+		"application_type":           "native",
+		"grant_types":                []string{deviceCodeGrant, "refresh_token"},
+		"response_types":             []string{},
+		"token_endpoint_auth_method": "none",
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.RegistrationEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var registration struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := a.do(req, &registration); err != nil {
+		return "", fmt.Errorf("failed to register the client: %w", err)
+	}
+	if registration.ClientID == "" {
+		return "", errors.New("failed to register the client: no client ID returned")
+	}
+	a.logger.Info("Registered the client with the OIDC provider as %s", registration.ClientID)
+	return registration.ClientID, nil
+}
+
+// requestTokens calls the token endpoint
+func (a *oidcAuth) requestTokens(ctx context.Context, metadata *oidcMetadata, form url.Values) (oidcTokens, error) {
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := a.postForm(ctx, metadata.TokenEndpoint, form, &resp); err != nil {
+		return oidcTokens{}, err
+	}
+	if resp.AccessToken == "" {
+		return oidcTokens{}, errors.New("no access token returned")
+	}
+	tokens := oidcTokens{AccessToken: resp.AccessToken, RefreshToken: resp.RefreshToken}
+	if resp.ExpiresIn > 0 {
+		tokens.ExpiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tokens, nil
+}
+
+func (a *oidcAuth) getJSON(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return a.do(req, result)
+}
+
+func (a *oidcAuth) postForm(ctx context.Context, endpoint string, form url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return a.do(req, result)
+}
+
+// do sends req to the provider and decodes the JSON response into result.
+// Error responses of the provider are returned as *oauthError.
+func (a *oidcAuth) do(req *http.Request, result interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var oauthErr oauthError
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL, err)
+	}
+	return nil
+}
+
+func (a *oidcAuth) accessToken() (string, time.Time) {
+	a.tokensMutex.RLock()
+	defer a.tokensMutex.RUnlock()
+	return a.tokens.AccessToken, a.tokens.ExpiresAt
+}
+
+func (a *oidcAuth) currentTokens() oidcTokens {
+	a.tokensMutex.RLock()
+	defer a.tokensMutex.RUnlock()
+	return a.tokens
+}
+
+func (a *oidcAuth) setTokens(tokens oidcTokens) {
+	a.logger.AddSecrets(tokens.AccessToken, tokens.RefreshToken)
+	a.tokensMutex.Lock()
+	a.tokens = tokens
+	a.tokensMutex.Unlock()
+}
+
+// storeTokens uses tokens and writes them to the token file, readable by
+// the service's user only
+func (a *oidcAuth) storeTokens(tokens oidcTokens) error {
+	a.setTokens(tokens)
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.config.TokenFile, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save the OIDC tokens: %w", err)
+	}
+	return nil
+}
+
+func (a *oidcAuth) loadTokens() (oidcTokens, error) {
+	var tokens oidcTokens
+	data, err := os.ReadFile(a.config.TokenFile)
+	if err != nil {
+		return tokens, err
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return tokens, fmt.Errorf("failed to parse %s: %w", a.config.TokenFile, err)
+	}
+	return tokens, nil
+}
+
+// randomDeviceID returns a device ID for a new OIDC login
+func randomDeviceID() string {
+	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, 10)
+	rand.Read(b)
+	for i := range b {
+		b[i] = letters[int(b[i])%len(letters)]
+	}
+	return "MULE" + string(b)
+}
@@ -0,0 +1,156 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+)
+
+// fakeProvider is a homeserver delegating auth to an OIDC provider
+type fakeProvider struct {
+	mu           sync.Mutex
+	valid        map[string]bool // Access tokens the homeserver accepts
+	refreshValid bool
+	issued       int
+	scope        string
+}
+
+func (p *fakeProvider) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v1/auth_metadata", func(w http.ResponseWriter, r *http.Request) {
+		server := "http://" + r.Host
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                      server + "/",
+			TokenEndpoint:               server + "/token",
+			DeviceAuthorizationEndpoint: server + "/device",
+			RegistrationEndpoint:        server + "/register",
+		})
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"client_id": "client"})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.scope = r.FormValue("scope")
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code": "device-code", "user_code": "ABCD-EFGH", "verification_uri": "http://" + r.Host + "/link",
+			"expires_in": 60, "interval": 1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if r.FormValue("client_id") != "client" {
+			t.Errorf("token request of client %q", r.FormValue("client_id"))
+		}
+		if r.FormValue("grant_type") == "refresh_token" && (!p.refreshValid || r.FormValue("refresh_token") != "refresh-token") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(oauthError{Code: "invalid_grant"})
+			return
+		}
+		p.issued++
+		token := "access-token-" + strings.Repeat("x", p.issued)
+		p.valid = map[string]bool{token: true}
+		p.refreshValid = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "refresh_token": "refresh-token", "expires_in": 300})
+	})
+	mux.HandleFunc("/_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Token expired"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"user_id": "@bot:example.com"})
+	})
+	return mux
+}
+
+func TestOIDCLoginAndRenewal(t *testing.T) {
+	provider := &fakeProvider{}
+	server := httptest.NewServer(provider.handler(t))
+	defer server.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	tokenFile := filepath.Join(t.TempDir(), "tokens.json")
+	cfg := &config.MatrixConfig{
+		Homeserver: server.URL,
+		UserID:     "@bot:example.com",
+		OIDC:       config.OIDCConfig{Enabled: true, ClientName: "test", ClientURI: "https://example.com", TokenFile: tokenFile},
+	}
+	client, err := mautrix.NewClient(cfg.Homeserver, "@bot:example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loginOIDC(context.Background(), client, cfg, http.DefaultTransport, log); err != nil {
+		t.Fatalf("loginOIDC() error = %v", err)
+	}
+	if cfg.DeviceID == "" || provider.scope != oidcAPIScope+" "+oidcDeviceScope+cfg.DeviceID {
+		t.Errorf("device %q logged in with scope %q", cfg.DeviceID, provider.scope)
+	}
+
+	// An expired token is refreshed and the request sent again
+	provider.mu.Lock()
+	provider.valid = nil
+	provider.mu.Unlock()
+	if _, err := client.Whoami(context.Background()); err != nil {
+		t.Fatalf("Whoami() after the token expired error = %v", err)
+	}
+	if provider.issued != 2 {
+		t.Errorf("issued %d tokens, want 2", provider.issued)
+	}
+
+	// The saved tokens are used after a restart
+	restarted, _ := mautrix.NewClient(cfg.Homeserver, "@bot:example.com", "")
+	if err := loginOIDC(context.Background(), restarted, cfg, http.DefaultTransport, log); err != nil {
+		t.Fatalf("loginOIDC() after a restart error = %v", err)
+	}
+	if provider.issued != 2 {
+		t.Errorf("issued %d tokens after a restart, want 2", provider.issued)
+	}
+
+	// Revoked tokens lead to a new login of the same device
+	provider.mu.Lock()
+	provider.valid = nil
+	provider.refreshValid = false
+	provider.scope = ""
+	provider.mu.Unlock()
+	if _, err := restarted.Whoami(context.Background()); err != nil {
+		t.Fatalf("Whoami() after the tokens were revoked error = %v", err)
+	}
+	if provider.issued != 3 || !strings.HasSuffix(provider.scope, cfg.DeviceID) {
+		t.Errorf("issued %d tokens with scope %q after a revocation, want a new login of %s", provider.issued, provider.scope, cfg.DeviceID)
+	}
+}
+
+func TestUnknownToken(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid token", "soft_logout": true}`: true,
+		`{"errcode": "M_MISSING_TOKEN"}`:                                                false,
+		`not json`:                                                                      false,
+	} {
+		resp := &http.Response{StatusCode: http.StatusUnauthorized, Body: http.NoBody}
+		recorder := httptest.NewRecorder()
+		recorder.WriteString(body)
+		resp.Body = recorder.Result().Body
+		if got := unknownToken(resp); got != want {
+			t.Errorf("unknownToken(%s) = %v, want %v", body, got, want)
+		}
+		// The body can still be read
+		if read, err := io.ReadAll(resp.Body); err != nil || string(read) != body {
+			t.Errorf("body after unknownToken = %q, %v", read, err)
+		}
+	}
+}
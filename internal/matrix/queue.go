@@ -0,0 +1,170 @@
+package matrix
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Policies of matrix.queue.overflow
+const (
+	OverflowBlock      = "block"       // The sync loop waits for room in the queue
+	OverflowDropNewest = "drop_newest" // The event that does not fit is dropped
+	OverflowDropOldest = "drop_oldest" // The longest waiting event makes room
+)
+
+// inboundTask handles one event
+type inboundTask struct {
+	eventID id.EventID
+	roomID  id.RoomID
+	handle  func()
+}
+
+// inboundQueue hands events from the sync loop to a pool of workers. All
+// events of a room and thread go to the same worker, which handles them in
+// the order they arrived.
+type inboundQueue struct {
+	queues   []chan inboundTask // One per worker
+	locks    []sync.Mutex       // Make dropping the oldest event and adding one atomic
+	overflow string
+	logger   *logger.Logger
+	dropped  atomic.Uint64
+	done     sync.WaitGroup
+}
+
+// newInboundQueue starts the workers of cfg, nil if events are handled in
+// the sync loop
+func newInboundQueue(cfg *config.InboundQueueConfig, log *logger.Logger) *inboundQueue {
+	if cfg.Workers <= 0 {
+		return nil
+	}
+	q := &inboundQueue{
+		queues:   make([]chan inboundTask, cfg.Workers),
+		locks:    make([]sync.Mutex, cfg.Workers),
+		overflow: cfg.Overflow,
+		logger:   log,
+	}
+	for i := range q.queues {
+		q.queues[i] = make(chan inboundTask, cfg.Size)
+		q.done.Add(1)
+		go q.work(q.queues[i])
+	}
+	return q
+}
+
+// inboundQueueKey returns the room and thread of an event, whose events are
+// handled in order
+func inboundQueueKey(evt *event.Event) string {
+	key := string(evt.RoomID)
+	if evt.Type == event.EventMessage {
+		if content := evt.Content.AsMessage(); content != nil {
+			if threadRoot := content.RelatesTo.GetThreadParent(); threadRoot != "" {
+				key += "/" + string(threadRoot)
+			}
+		}
+	}
+	return key
+}
+
+// enqueue queues a task behind the earlier tasks of the same key, applying
+// the overflow policy if the worker's queue is full
+func (q *inboundQueue) enqueue(key string, task inboundTask) {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	worker := int(hash.Sum32() % uint32(len(q.queues)))
+	queue := q.queues[worker]
+
+	switch q.overflow {
+	case OverflowDropNewest:
+		select {
+		case queue <- task:
+		default:
+			q.drop(task)
+		}
+	case OverflowDropOldest:
+		q.locks[worker].Lock()
+		defer q.locks[worker].Unlock()
+		for {
+			select {
+			case queue <- task:
+				return
+			default:
+			}
+			select {
+			case oldest := <-queue:
+				q.drop(oldest)
+			default:
+				// The worker took one meanwhile
+			}
+		}
+	default:
+		queue <- task
+	}
+}
+
+// InboundQueueStats describes the queue between the sync loop and the
+// handlers
+type InboundQueueStats struct {
+	Queued  int    // Events waiting for a worker
+	Dropped uint64 // Events dropped because the queue was full
+}
+
+// InboundQueueStats returns the state of the inbound queue, zero if events
+// are handled in the sync loop
+func (c *Client) InboundQueueStats() InboundQueueStats {
+	if c.queue == nil {
+		return InboundQueueStats{}
+	}
+	stats := InboundQueueStats{Dropped: c.queue.dropped.Load()}
+	for _, queue := range c.queue.queues {
+		stats.Queued += len(queue)
+	}
+	return stats
+}
+
+func (q *inboundQueue) drop(task inboundTask) {
+	dropped := q.dropped.Add(1)
+	q.logger.Warn("Inbound queue is full, dropped event %s of room %s (%d dropped so far)", task.eventID, task.roomID, dropped)
+}
+
+func (q *inboundQueue) work(queue chan inboundTask) {
+	defer q.done.Done()
+	for task := range queue {
+		q.run(task)
+	}
+}
+
+// run handles a task, a panic of the handler only losing that event
+func (q *inboundQueue) run(task inboundTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.Error("Handling event %s of room %s panicked: %v", task.eventID, task.roomID, r)
+		}
+	}()
+	task.handle()
+}
+
+// close stops accepting events and waits for the queued ones to be handled,
+// or ctx to expire. Nothing may be enqueued afterwards.
+func (q *inboundQueue) close(ctx context.Context) error {
+	for _, queue := range q.queues {
+		close(queue)
+	}
+	finished := make(chan struct{})
+	go func() {
+		q.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
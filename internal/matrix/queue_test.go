@@ -0,0 +1,116 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestInboundQueueOrder(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	q := newInboundQueue(&config.InboundQueueConfig{Workers: 4, Size: 10, Overflow: OverflowBlock}, log)
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"!a:example.com", "!b:example.com", "!a:example.com/$thread"} {
+			key, i := key, i
+			q.enqueue(key, inboundTask{handle: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				handled[key] = append(handled[key], i)
+			}})
+		}
+	}
+	if err := q.close(context.Background()); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+
+	for key, order := range handled {
+		if len(order) != 50 {
+			t.Errorf("%s: handled %d events, want 50", key, len(order))
+		}
+		for i, n := range order {
+			if n != i {
+				t.Errorf("%s: events handled in order %v", key, order)
+				break
+			}
+		}
+	}
+}
+
+func TestInboundQueueOverflow(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	for _, overflow := range []string{OverflowDropNewest, OverflowDropOldest} {
+		t.Run(overflow, func(t *testing.T) {
+			q := newInboundQueue(&config.InboundQueueConfig{Workers: 1, Size: 2, Overflow: overflow}, log)
+
+			// The worker is busy with the first event while three more arrive
+			started, release := make(chan struct{}), make(chan struct{})
+			q.enqueue("!a:example.com", inboundTask{handle: func() {
+				close(started)
+				<-release
+			}})
+			<-started
+			var handled []id.EventID
+			for i := 1; i <= 3; i++ {
+				eventID := id.EventID(fmt.Sprintf("$%d", i))
+				q.enqueue("!a:example.com", inboundTask{eventID: eventID, handle: func() { handled = append(handled, eventID) }})
+			}
+			close(release)
+			if err := q.close(context.Background()); err != nil {
+				t.Fatalf("close() error = %v", err)
+			}
+
+			expected := "[$1 $2]"
+			if overflow == OverflowDropOldest {
+				expected = "[$2 $3]"
+			}
+			if fmt.Sprint(handled) != expected || q.dropped.Load() != 1 {
+				t.Errorf("handled %v with %d dropped, want %s with 1 dropped", handled, q.dropped.Load(), expected)
+			}
+		})
+	}
+}
+
+func TestInboundQueuePanic(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	q := newInboundQueue(&config.InboundQueueConfig{Workers: 1, Size: 2, Overflow: OverflowBlock}, log)
+	handled := false
+	q.enqueue("!a:example.com", inboundTask{handle: func() { panic("boom") }})
+	q.enqueue("!a:example.com", inboundTask{handle: func() { handled = true }})
+	if err := q.close(context.Background()); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	if !handled {
+		t.Error("the event after a panicking handler was not handled")
+	}
+}
+
+func TestInboundQueueKey(t *testing.T) {
+	message := func(relatesTo *event.RelatesTo) *event.Event {
+		return &event.Event{
+			Type:    event.EventMessage,
+			RoomID:  "!a:example.com",
+			Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hi", RelatesTo: relatesTo}},
+		}
+	}
+	if got := inboundQueueKey(message(nil)); got != "!a:example.com" {
+		t.Errorf("inboundQueueKey() = %q for a message", got)
+	}
+	if got := inboundQueueKey(message((&event.RelatesTo{}).SetThread("$root", "$root"))); got != "!a:example.com/$root" {
+		t.Errorf("inboundQueueKey() = %q for a message in a thread", got)
+	}
+	if got := inboundQueueKey(&event.Event{Type: event.EventReaction, RoomID: "!a:example.com"}); got != "!a:example.com" {
+		t.Errorf("inboundQueueKey() = %q for a reaction", got)
+	}
+	if newInboundQueue(&config.InboundQueueConfig{Workers: 0}, nil) != nil {
+		t.Error("newInboundQueue() != nil without workers")
+	}
+}
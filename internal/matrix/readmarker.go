@@ -0,0 +1,162 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// readMarker is an event of a room and when it was sent
+type readMarker struct {
+	eventID   id.EventID
+	timestamp int64 // Milliseconds since the epoch, as origin_server_ts
+}
+
+// readMarkers skips the events the bot handled before it started, anchored
+// on the account's fully-read marker of each room rather than on local
+// state, and advances the markers as events are handled
+type readMarkers struct {
+	startedAt time.Time
+	grace     time.Duration
+	logger    *logger.Logger
+	// Look up and move the fully-read marker of a room, on the homeserver
+	fetch func(ctx context.Context, roomID id.RoomID) (readMarker, error)
+	send  func(ctx context.Context, roomID id.RoomID, eventID id.EventID) error
+
+	mutex   sync.Mutex
+	anchors map[id.RoomID]readMarker // Events up to here are skipped
+	pending map[id.RoomID]readMarker // Handled, not sent yet
+	sent    map[id.RoomID]int64      // Timestamps of the markers sent
+}
+
+func newReadMarkers(cfg *config.ReadMarkerConfig, client *mautrix.Client, logger *logger.Logger) *readMarkers {
+	return &readMarkers{
+		startedAt: time.Now(),
+		grace:     time.Duration(cfg.GraceWindow) * time.Second,
+		logger:    logger,
+		fetch: func(ctx context.Context, roomID id.RoomID) (readMarker, error) {
+			var content event.FullyReadEventContent
+			if err := client.GetRoomAccountData(ctx, roomID, event.AccountDataFullyRead.Type, &content); err != nil {
+				return readMarker{}, err
+			}
+			evt, err := client.GetEvent(ctx, roomID, content.EventID)
+			if err != nil {
+				return readMarker{}, err
+			}
+			return readMarker{eventID: content.EventID, timestamp: evt.Timestamp}, nil
+		},
+		send: func(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+			return client.SetReadMarkers(ctx, roomID, &mautrix.ReqSetReadMarkers{FullyRead: eventID})
+		},
+		anchors: make(map[id.RoomID]readMarker),
+		pending: make(map[id.RoomID]readMarker),
+		sent:    make(map[id.RoomID]int64),
+	}
+}
+
+// handledBefore reports whether evt was sent before the fully-read marker of
+// its room, less the grace window, as it was when the bot started. Rooms
+// without a marker are anchored at the start.
+func (m *readMarkers) handledBefore(ctx context.Context, evt *event.Event) bool {
+	if evt.Mautrix.EventSource&event.SourceTimeline == 0 {
+		return false
+	}
+	anchor := m.anchor(ctx, evt.RoomID)
+	return evt.ID == anchor.eventID || evt.Timestamp <= anchor.timestamp-m.grace.Milliseconds()
+}
+
+// anchor returns the marker of roomID, fetched at its first event. The lock
+// is not held while fetching, so a slow homeserver does not hold up the
+// other rooms.
+func (m *readMarkers) anchor(ctx context.Context, roomID id.RoomID) readMarker {
+	m.mutex.Lock()
+	anchor, exists := m.anchors[roomID]
+	m.mutex.Unlock()
+	if exists {
+		return anchor
+	}
+
+	anchor, err := m.fetch(ctx, roomID)
+	switch {
+	case errors.Is(err, mautrix.MNotFound):
+		anchor = readMarker{timestamp: m.startedAt.UnixMilli()}
+		m.logger.Info("Room %s has no fully-read marker, skipping events sent before the start", roomID)
+	case err != nil:
+		anchor = readMarker{timestamp: m.startedAt.UnixMilli()}
+		m.logger.Warn("Failed to get the fully-read marker of %s, skipping events sent before the start: %v", roomID, err)
+	default:
+		m.logger.Info("Skipping events of %s up to the fully-read marker %s", roomID, anchor.eventID)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// Another event of the room may have anchored it while fetching
+	if first, exists := m.anchors[roomID]; exists {
+		return first
+	}
+	m.anchors[roomID] = anchor
+	if anchor.timestamp > m.sent[roomID] {
+		m.sent[roomID] = anchor.timestamp
+	}
+	return anchor
+}
+
+// handled advances the marker of the event's room to evt, unless a later
+// event was handled before
+func (m *readMarkers) handled(evt *event.Event) {
+	if evt.Mautrix.EventSource&event.SourceTimeline == 0 || evt.ID == "" {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if evt.Timestamp <= m.sent[evt.RoomID] || evt.Timestamp <= m.pending[evt.RoomID].timestamp {
+		return
+	}
+	m.pending[evt.RoomID] = readMarker{eventID: evt.ID, timestamp: evt.Timestamp}
+}
+
+// flush sends the markers of the events handled since the last flush
+func (m *readMarkers) flush(ctx context.Context) {
+	m.mutex.Lock()
+	pending := m.pending
+	m.pending = make(map[id.RoomID]readMarker)
+	m.mutex.Unlock()
+
+	for roomID, marker := range pending {
+		if err := m.send(ctx, roomID, marker.eventID); err != nil {
+			m.logger.Warn("Failed to move the fully-read marker of %s to %s: %v", roomID, marker.eventID, err)
+			m.mutex.Lock()
+			if marker.timestamp > m.pending[roomID].timestamp {
+				m.pending[roomID] = marker
+			}
+			m.mutex.Unlock()
+			continue
+		}
+		m.mutex.Lock()
+		if marker.timestamp > m.sent[roomID] {
+			m.sent[roomID] = marker.timestamp
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// run flushes the markers every interval until stop is closed
+func (m *readMarkers) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.flush(context.Background())
+		}
+	}
+}
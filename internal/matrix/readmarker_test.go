@@ -0,0 +1,141 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func timelineEvent(roomID id.RoomID, eventID id.EventID, timestamp int64) *event.Event {
+	return &event.Event{RoomID: roomID, ID: eventID, Timestamp: timestamp, Mautrix: event.MautrixInfo{EventSource: event.SourceJoin | event.SourceTimeline}}
+}
+
+func TestReadMarkers(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	start := time.UnixMilli(1_000_000)
+	sent := make(map[id.RoomID]id.EventID)
+	failSend := false
+	m := &readMarkers{
+		startedAt: start,
+		grace:     10 * time.Second,
+		logger:    log,
+		fetch: func(ctx context.Context, roomID id.RoomID) (readMarker, error) {
+			switch roomID {
+			case "!marked:example.com":
+				return readMarker{eventID: "$marker", timestamp: 500_000}, nil
+			case "!new:example.com":
+				return readMarker{}, mautrix.MNotFound
+			}
+			return readMarker{}, errors.New("unreachable")
+		},
+		send: func(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+			if failSend {
+				return errors.New("unreachable")
+			}
+			sent[roomID] = eventID
+			return nil
+		},
+		anchors: make(map[id.RoomID]readMarker),
+		pending: make(map[id.RoomID]readMarker),
+		sent:    make(map[id.RoomID]int64),
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		evt  *event.Event
+		want bool
+	}{
+		{"The marker", timelineEvent("!marked:example.com", "$marker", 500_000), true},
+		{"Before the grace window", timelineEvent("!marked:example.com", "$old", 480_000), true},
+		{"In the grace window", timelineEvent("!marked:example.com", "$late", 495_000), false},
+		{"After the marker", timelineEvent("!marked:example.com", "$new", 600_000), false},
+		{"Before the start without a marker", timelineEvent("!new:example.com", "$old", 900_000), true},
+		{"After the start without a marker", timelineEvent("!new:example.com", "$new", 1_000_001), false},
+		{"Marker not available", timelineEvent("!other:example.com", "$new", 1_000_001), false},
+		{"Not a timeline event", &event.Event{RoomID: "!marked:example.com", Timestamp: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := m.handledBefore(ctx, tt.evt); got != tt.want {
+			t.Errorf("%s: handledBefore() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The marker only moves forward
+	m.handled(timelineEvent("!marked:example.com", "$second", 700_000))
+	m.handled(timelineEvent("!marked:example.com", "$first", 600_000))
+	m.handled(timelineEvent("!marked:example.com", "$old", 400_000))
+	m.flush(ctx)
+	if sent["!marked:example.com"] != "$second" {
+		t.Errorf("marker sent = %s, want $second", sent["!marked:example.com"])
+	}
+	m.handled(timelineEvent("!marked:example.com", "$first", 600_000))
+	delete(sent, "!marked:example.com")
+	m.flush(ctx)
+	if marker, exists := sent["!marked:example.com"]; exists {
+		t.Errorf("marker moved back to %s", marker)
+	}
+
+	// Markers that failed to send are sent with the next flush
+	failSend = true
+	m.handled(timelineEvent("!new:example.com", "$third", 1_100_000))
+	m.flush(ctx)
+	failSend = false
+	m.flush(ctx)
+	if sent["!new:example.com"] != "$third" {
+		t.Errorf("marker sent after a failure = %s, want $third", sent["!new:example.com"])
+	}
+}
+
+func TestReadMarkersFetchWithoutLock(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	m := &readMarkers{
+		startedAt: time.UnixMilli(1_000_000),
+		logger:    log,
+		fetch: func(ctx context.Context, roomID id.RoomID) (readMarker, error) {
+			if roomID == "!slow:example.com" {
+				close(fetching)
+				<-release
+			}
+			return readMarker{eventID: "$marker", timestamp: 500_000}, nil
+		},
+		anchors: make(map[id.RoomID]readMarker),
+		pending: make(map[id.RoomID]readMarker),
+		sent:    make(map[id.RoomID]int64),
+	}
+	ctx := context.Background()
+
+	done := make(chan bool)
+	go func() {
+		done <- m.handledBefore(ctx, timelineEvent("!slow:example.com", "$marker", 500_000))
+	}()
+	<-fetching
+
+	// Other rooms are handled while the marker of the slow one is fetched
+	handled := make(chan bool)
+	go func() {
+		handled <- m.handledBefore(ctx, timelineEvent("!fast:example.com", "$new", 600_000))
+	}()
+	select {
+	case skipped := <-handled:
+		if skipped {
+			t.Error("handledBefore() = true for an event after the marker")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handledBefore() blocked on the fetch of another room")
+	}
+
+	close(release)
+	if skipped := <-done; !skipped {
+		t.Error("handledBefore() = false for the marker")
+	}
+}
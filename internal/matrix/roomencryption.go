@@ -0,0 +1,54 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrEncryptionNotPermitted is returned by EnableRoomEncryption when the
+// bot's power level does not allow it to enable encryption in the room
+var ErrEncryptionNotPermitted = errors.New("power level too low to enable encryption")
+
+// RoomEncrypted reports whether messages the bot sends to the room are
+// encrypted: the room has encryption enabled and the bot has it set up
+func (c *Client) RoomEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	if c.cryptoHelper == nil || c.client.StateStore == nil {
+		return false, nil
+	}
+	return c.client.StateStore.IsEncrypted(ctx, roomID)
+}
+
+// EnableRoomEncryption enables Megolm encryption in a room, if the bot's
+// power level allows it to send m.room.encryption. Encryption cannot be
+// disabled again.
+func (c *Client) EnableRoomEncryption(ctx context.Context, roomID id.RoomID) error {
+	if c.cryptoHelper == nil {
+		return errNoCryptoHelper
+	}
+	levels, err := c.client.StateStore.GetPowerLevels(ctx, roomID)
+	if err != nil || levels == nil {
+		levels = &event.PowerLevelsEventContent{}
+		if err := c.client.StateEvent(ctx, roomID, event.StatePowerLevels, "", levels); err != nil {
+			return fmt.Errorf("failed to read the power levels: %w", err)
+		}
+	}
+	if !canSendState(levels, id.UserID(c.config.UserID), event.StateEncryption) {
+		return ErrEncryptionNotPermitted
+	}
+	content := &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	if _, err := c.client.SendStateEvent(ctx, roomID, event.StateEncryption, "", content); err != nil {
+		return fmt.Errorf("failed to enable encryption: %w", err)
+	}
+	c.logger.Info("Enabled encryption in room %s", roomID)
+	return nil
+}
+
+// canSendState reports whether the power levels allow the user to send a
+// state event of the type
+func canSendState(levels *event.PowerLevelsEventContent, userID id.UserID, eventType event.Type) bool {
+	return levels.GetUserLevel(userID) >= levels.GetEventLevel(eventType)
+}
@@ -0,0 +1,30 @@
+package matrix
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCanSendState(t *testing.T) {
+	bot := id.UserID("@bot:example.com")
+	fifty := 50
+	tests := []struct {
+		name   string
+		levels *event.PowerLevelsEventContent
+		want   bool
+	}{
+		{"Default state level", &event.PowerLevelsEventContent{Users: map[id.UserID]int{bot: 50}}, true},
+		{"Moderator below the state default", &event.PowerLevelsEventContent{Users: map[id.UserID]int{bot: 50}, StateDefaultPtr: ptr(100)}, false},
+		{"Event level", &event.PowerLevelsEventContent{Users: map[id.UserID]int{bot: 50}, StateDefaultPtr: ptr(100), Events: map[string]int{event.StateEncryption.Type: fifty}}, true},
+		{"Regular user", &event.PowerLevelsEventContent{}, false},
+	}
+	for _, tt := range tests {
+		if got := canSendState(tt.levels, bot, event.StateEncryption); got != tt.want {
+			t.Errorf("%s: canSendState() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func ptr(i int) *int { return &i }
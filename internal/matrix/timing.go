@@ -0,0 +1,77 @@
+package matrix
+
+import (
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Events whose timing is remembered at most, the oldest are forgotten first
+const maxEventTimings = 256
+
+// EventTiming is how an event made its way to the handlers
+type EventTiming struct {
+	Sent       time.Time     // origin_server_ts, by the clock of the sender's homeserver
+	Received   time.Time     // Delivered by the sync or an appservice transaction
+	Handled    time.Time     // Passed to the handlers, after decryption and queueing
+	Encrypted  bool          // The event was encrypted
+	Decryption time.Duration // Time taken to decrypt, zero unless Encrypted
+}
+
+// SyncLag returns the time from the sender's homeserver to the client,
+// which includes the clock skew between them
+func (t EventTiming) SyncLag() time.Duration {
+	return t.Received.Sub(t.Sent)
+}
+
+// QueueWait returns the time the event waited for a worker of the inbound
+// queue, after it was decrypted
+func (t EventTiming) QueueWait() time.Duration {
+	return t.Handled.Sub(t.Received) - t.Decryption
+}
+
+// eventTimings remembers the timing of the last events handled
+type eventTimings struct {
+	mutex sync.Mutex
+	byID  map[id.EventID]EventTiming
+	order []id.EventID // Oldest first
+}
+
+func (t *eventTimings) record(eventID id.EventID, timing EventTiming) {
+	if eventID == "" {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.byID == nil {
+		t.byID = make(map[id.EventID]EventTiming)
+	}
+	if _, known := t.byID[eventID]; !known {
+		if len(t.order) >= maxEventTimings {
+			delete(t.byID, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.order = append(t.order, eventID)
+	}
+	t.byID[eventID] = timing
+}
+
+func (t *eventTimings) get(eventID id.EventID) (EventTiming, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	timing, ok := t.byID[eventID]
+	return timing, ok
+}
+
+// newEventTiming starts the timing of an event received now
+func newEventTiming(evt *event.Event) EventTiming {
+	return EventTiming{Sent: time.UnixMilli(evt.Timestamp), Received: time.Now(), Encrypted: evt.Type == event.EventEncrypted}
+}
+
+// EventTiming returns how one of the last events handled made its way to
+// the handlers, false if it is not remembered
+func (c *Client) EventTiming(eventID id.EventID) (EventTiming, bool) {
+	return c.eventTimings.get(eventID)
+}
@@ -0,0 +1,47 @@
+package matrix
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestEventTiming(t *testing.T) {
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	evt := &event.Event{ID: "$ping", Type: event.EventEncrypted, Timestamp: sent.UnixMilli()}
+	timing := newEventTiming(evt)
+	if !timing.Sent.Equal(sent) || !timing.Encrypted {
+		t.Fatalf("newEventTiming() = %+v", timing)
+	}
+
+	timing.Received = sent.Add(150 * time.Millisecond)
+	timing.Decryption = 20 * time.Millisecond
+	timing.Handled = timing.Received.Add(25 * time.Millisecond)
+	if got := timing.SyncLag(); got != 150*time.Millisecond {
+		t.Errorf("SyncLag() = %v, want 150ms", got)
+	}
+	if got := timing.QueueWait(); got != 5*time.Millisecond {
+		t.Errorf("QueueWait() = %v, want 5ms", got)
+	}
+}
+
+func TestEventTimings(t *testing.T) {
+	var timings eventTimings
+	for i := 0; i < maxEventTimings+1; i++ {
+		timings.record(id.EventID(fmt.Sprintf("$%d", i)), EventTiming{Decryption: time.Duration(i)})
+	}
+	timings.record("", EventTiming{})
+
+	if _, ok := timings.get("$0"); ok {
+		t.Error("The oldest timing was not forgotten")
+	}
+	if timing, ok := timings.get(id.EventID(fmt.Sprintf("$%d", maxEventTimings))); !ok || timing.Decryption != maxEventTimings {
+		t.Errorf("get(newest) = %+v, %v", timing, ok)
+	}
+	if len(timings.byID) != maxEventTimings || len(timings.order) != maxEventTimings {
+		t.Errorf("%d timings remembered, want %d", len(timings.byID), maxEventTimings)
+	}
+}
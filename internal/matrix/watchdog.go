@@ -0,0 +1,137 @@
+package matrix
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+// Actions of the sync watchdog
+type watchdogAction int
+
+const (
+	watchdogNone      watchdogAction = iota
+	watchdogRestart                  // Restart the sync loop
+	watchdogReset                    // Restart the sync loop on new connections
+	watchdogRecovered                // Sync responses arrive again
+)
+
+// syncWatchdog decides when a sync loop without responses is restarted
+type syncWatchdog struct {
+	staleAfter  time.Duration
+	maxRestarts int
+
+	restarts    int       // Since the last sync response
+	restartedAt time.Time // Zero unless the sync is stalled
+}
+
+// check returns what to do about a sync loop whose last response arrived at
+// lastSync. A restart gets staleAfter to bring a response before the next.
+func (w *syncWatchdog) check(lastSync, now time.Time) watchdogAction {
+	if now.Sub(lastSync) < w.staleAfter {
+		if w.restartedAt.IsZero() {
+			return watchdogNone
+		}
+		w.restarts, w.restartedAt = 0, time.Time{}
+		return watchdogRecovered
+	}
+	if !w.restartedAt.IsZero() && now.Sub(w.restartedAt) < w.staleAfter {
+		return watchdogNone
+	}
+	w.restarts++
+	w.restartedAt = now
+	if w.restarts > w.maxRestarts {
+		return watchdogReset
+	}
+	return watchdogRestart
+}
+
+// watchSync checks the sync loop every interval until the client closes
+func (c *Client) watchSync(cfg *config.SyncWatchdogConfig, startedAt time.Time) {
+	watchdog := &syncWatchdog{
+		staleAfter:  time.Duration(cfg.StaleAfter) * time.Second,
+		maxRestarts: cfg.MaxRestarts,
+	}
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.syncStop:
+			return
+		case now := <-ticker.C:
+			lastSync := startedAt
+			if nanos := c.lastSync.Load(); nanos != 0 {
+				lastSync = time.Unix(0, nanos)
+			}
+			c.applyWatchdog(watchdog.check(lastSync, now), watchdog.restarts, now.Sub(lastSync))
+		}
+	}
+}
+
+func (c *Client) applyWatchdog(action watchdogAction, restarts int, age time.Duration) {
+	age = age.Round(time.Second)
+	switch action {
+	case watchdogRecovered:
+		c.syncStalled.Store(false)
+		c.logger.Info("Matrix sync recovered")
+		c.sendWatchdogAlert("✅ Matrix sync recovered.")
+	case watchdogRestart, watchdogReset:
+		c.syncStalled.Store(true)
+		c.syncRestarts.Add(1)
+		if action == watchdogReset {
+			c.clientResets.Add(1)
+			c.logger.Error("No Matrix sync response for %s after %d restarts, resetting the connections to the homeserver", age, restarts-1)
+			c.transport.reset()
+		} else {
+			c.logger.Error("No Matrix sync response for %s, restarting the sync loop", age)
+		}
+		if restarts == 1 {
+			c.sendWatchdogAlert(fmt.Sprintf("⚠️ No Matrix sync response for %s, restarting the sync loop. Messages are not received until it recovers.", age))
+		}
+		c.restartSync()
+	}
+}
+
+// sendWatchdogAlert posts to matrix.watchdog.alert_room_id, if set
+func (c *Client) sendWatchdogAlert(message string) {
+	roomID := c.config.Watchdog.AlertRoomID
+	if roomID == "" {
+		return
+	}
+	go func() {
+		if _, err := c.SendMessage(message, WithRoom(id.RoomID(roomID)), WithMsgType(MsgTypeNotice)); err != nil {
+			c.logger.Error("Failed to send sync watchdog alert: %v", err)
+		}
+	}()
+}
+
+// resettableTransport sends requests over a transport that can be replaced,
+// dropping connections that stopped working
+type resettableTransport struct {
+	mu        sync.RWMutex
+	transport *http.Transport
+}
+
+func newResettableTransport() *resettableTransport {
+	return &resettableTransport{transport: http.DefaultTransport.(*http.Transport).Clone()}
+}
+
+func (t *resettableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	transport := t.transport
+	t.mu.RUnlock()
+	return transport.RoundTrip(req)
+}
+
+// reset sends further requests over new connections
+func (t *resettableTransport) reset() {
+	t.mu.Lock()
+	previous := t.transport
+	t.transport = previous.Clone()
+	t.mu.Unlock()
+	previous.CloseIdleConnections()
+}
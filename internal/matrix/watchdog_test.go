@@ -0,0 +1,59 @@
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSyncWatchdog(t *testing.T) {
+	w := &syncWatchdog{staleAfter: 5 * time.Minute, maxRestarts: 1}
+	start := time.Now()
+	lastSync := start
+
+	steps := []struct {
+		after    time.Duration
+		expected watchdogAction
+	}{
+		{time.Minute, watchdogNone},
+		{6 * time.Minute, watchdogRestart},
+		{8 * time.Minute, watchdogNone}, // The restart gets stale_after to recover
+		{12 * time.Minute, watchdogReset},
+		{20 * time.Minute, watchdogReset},
+	}
+	for _, step := range steps {
+		if got := w.check(lastSync, start.Add(step.after)); got != step.expected {
+			t.Fatalf("check() after %s = %v, want %v", step.after, got, step.expected)
+		}
+	}
+	if w.restarts != 3 {
+		t.Errorf("restarts = %d, want 3", w.restarts)
+	}
+
+	lastSync = start.Add(21 * time.Minute)
+	if got := w.check(lastSync, lastSync.Add(time.Second)); got != watchdogRecovered {
+		t.Errorf("check() after a response = %v, want recovered", got)
+	}
+	if got := w.check(lastSync, lastSync.Add(6*time.Minute)); got != watchdogRestart {
+		t.Errorf("check() of a new stall = %v, want a restart", got)
+	}
+}
+
+func TestResettableTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	transport := newResettableTransport()
+	client := &http.Client{Transport: transport}
+	before := transport.transport
+	transport.reset()
+	if transport.transport == before {
+		t.Fatal("reset() kept the transport")
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request after reset() error = %v", err)
+	}
+	resp.Body.Close()
+}
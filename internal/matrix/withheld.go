@@ -0,0 +1,131 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// WithheldHandler is told about messages that cannot be decrypted because
+// the sender's device withheld the key on purpose, once per megolm session
+type WithheldHandler interface {
+	HandleWithheld(roomID id.RoomID, sender id.UserID, eventID id.EventID, code event.RoomKeyWithheldCode)
+}
+
+// WithheldKeys counts the m.room_key.withheld events received with a code
+type WithheldKeys struct {
+	Code  string
+	Count uint64
+}
+
+// withheldOnPurpose reports whether a withheld code means the sender decided
+// not to share the key with the bot, so that requesting it again is useless.
+// Other codes (m.unavailable, m.no_olm) say a device could not share it, and
+// another device may still do so.
+func withheldOnPurpose(code event.RoomKeyWithheldCode) bool {
+	switch code {
+	case event.RoomKeyWithheldBlacklisted, event.RoomKeyWithheldUnverified, event.RoomKeyWithheldUnauthorized:
+		return true
+	default:
+		return false
+	}
+}
+
+// withheldStats counts received withheld events by code and remembers the
+// sessions whose senders were told, so that each is told once
+type withheldStats struct {
+	mutex    sync.Mutex
+	counts   map[string]uint64
+	notified map[id.SessionID]struct{}
+}
+
+func (s *withheldStats) record(code event.RoomKeyWithheldCode) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]uint64)
+	}
+	s.counts[string(code)]++
+}
+
+// notify reports whether the sender of a session was not told yet, and
+// marks them told
+func (s *withheldStats) notify(sessionID id.SessionID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, done := s.notified[sessionID]; done {
+		return false
+	}
+	if s.notified == nil || len(s.notified) >= maxTrackedSessions {
+		s.notified = make(map[id.SessionID]struct{})
+	}
+	s.notified[sessionID] = struct{}{}
+	return true
+}
+
+// snapshot returns the counts sorted by code
+func (s *withheldStats) snapshot() []WithheldKeys {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]WithheldKeys, 0, len(s.counts))
+	for code, count := range s.counts {
+		keys = append(keys, WithheldKeys{Code: code, Count: count})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Code < keys[j].Code })
+	return keys
+}
+
+// WithheldKeys returns the m.room_key.withheld events received since
+// startup, by code
+func (c *Client) WithheldKeys() []WithheldKeys {
+	return c.withheldStats.snapshot()
+}
+
+// SetWithheldHandler sets the handler told about messages whose key was
+// withheld on purpose
+func (c *Client) SetWithheldHandler(handler WithheldHandler) {
+	c.withheldHandler = handler
+}
+
+// handleWithheldEvent counts an m.room_key.withheld to-device event. The
+// crypto machine stores it, later decryptions of the session fail with its
+// code.
+func (c *Client) handleWithheldEvent(evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.RoomKeyWithheldEventContent)
+	if !ok {
+		return
+	}
+	c.logger.Info("Room key withheld by %s: code=%s, reason=%q, room_id=%s, session_id=%s",
+		evt.Sender, content.Code, content.Reason, content.RoomID, content.SessionID)
+	c.withheldStats.record(content.Code)
+}
+
+// withheldKey returns the withheld event of a session if decrypting it
+// failed with err because the sender withheld the key on purpose, nil
+// otherwise. The session is then not requested again.
+func (c *Client) withheldKey(ctx context.Context, roomID id.RoomID, sessionID id.SessionID, err error) *event.RoomKeyWithheldEventContent {
+	if c.cryptoHelper == nil || !errors.Is(err, crypto.ErrGroupSessionWithheld) {
+		return nil
+	}
+	withheld, storeErr := c.cryptoHelper.Machine().CryptoStore.GetWithheldGroupSession(ctx, roomID, sessionID)
+	if storeErr != nil || withheld == nil || !withheldOnPurpose(withheld.Code) {
+		return nil
+	}
+	return withheld
+}
+
+// notifyWithheld tells the withheld handler about a message whose key was
+// withheld on purpose, once per session
+func (c *Client) notifyWithheld(evt *event.Event, sessionID id.SessionID, code event.RoomKeyWithheldCode) {
+	if c.withheldHandler == nil || evt.Sender == id.UserID(c.config.UserID) || !c.withheldStats.notify(sessionID) {
+		return
+	}
+	c.logger.Info("Telling %s that the key of %s was withheld (%s)", evt.Sender, evt.ID, code)
+	// The sync loop must not wait for the reply
+	go c.withheldHandler.HandleWithheld(evt.RoomID, evt.Sender, evt.ID, code)
+}
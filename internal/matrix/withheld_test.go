@@ -0,0 +1,76 @@
+package matrix
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type withheldRecorder chan id.EventID
+
+func (r withheldRecorder) HandleWithheld(roomID id.RoomID, sender id.UserID, eventID id.EventID, code event.RoomKeyWithheldCode) {
+	r <- eventID
+}
+
+func TestWithheldOnPurpose(t *testing.T) {
+	for code, want := range map[event.RoomKeyWithheldCode]bool{
+		event.RoomKeyWithheldBlacklisted:  true,
+		event.RoomKeyWithheldUnverified:   true,
+		event.RoomKeyWithheldUnauthorized: true,
+		event.RoomKeyWithheldUnavailable:  false,
+		event.RoomKeyWithheldNoOlmSession: false,
+	} {
+		if got := withheldOnPurpose(code); got != want {
+			t.Errorf("withheldOnPurpose(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestWithheldKeys(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	c := &Client{logger: log, config: &config.MatrixConfig{UserID: "@bot:example.com"}}
+	for _, code := range []event.RoomKeyWithheldCode{event.RoomKeyWithheldUnverified, event.RoomKeyWithheldBlacklisted, event.RoomKeyWithheldUnverified} {
+		c.handleWithheldEvent(&event.Event{Type: event.ToDeviceRoomKeyWithheld, Sender: "@alice:example.com", Content: event.Content{Parsed: &event.RoomKeyWithheldEventContent{Code: code, SessionID: "s1"}}})
+	}
+	want := []WithheldKeys{{Code: "m.blacklisted", Count: 1}, {Code: "m.unverified", Count: 2}}
+	if got := c.WithheldKeys(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("WithheldKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestNotifyWithheld(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	c := &Client{logger: log, config: &config.MatrixConfig{UserID: "@bot:example.com"}}
+	// Without a handler nobody is told
+	c.notifyWithheld(&event.Event{ID: "$ignored", Sender: "@alice:example.com"}, "s0", event.RoomKeyWithheldUnverified)
+
+	told := make(withheldRecorder, 4)
+	c.SetWithheldHandler(told)
+	c.notifyWithheld(&event.Event{ID: "$first", Sender: "@alice:example.com"}, "s1", event.RoomKeyWithheldUnverified)
+	c.notifyWithheld(&event.Event{ID: "$second", Sender: "@alice:example.com"}, "s1", event.RoomKeyWithheldUnverified) // Same session
+	c.notifyWithheld(&event.Event{ID: "$own", Sender: "@bot:example.com"}, "s2", event.RoomKeyWithheldUnverified)
+	c.notifyWithheld(&event.Event{ID: "$other", Sender: "@bob:example.com"}, "s3", event.RoomKeyWithheldBlacklisted)
+
+	var got []id.EventID
+	for len(got) < 2 {
+		select {
+		case eventID := <-told:
+			got = append(got, eventID)
+		case <-time.After(time.Second):
+			t.Fatalf("handler told about %v, want $first and $other", got)
+		}
+	}
+	select {
+	case eventID := <-told:
+		t.Errorf("handler also told about %s", eventID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if (got[0] != "$first" || got[1] != "$other") && (got[0] != "$other" || got[1] != "$first") {
+		t.Errorf("handler told about %v, want $first and $other", got)
+	}
+}
@@ -0,0 +1,225 @@
+// Package memory keeps the small key-value memory of /set, /get and /forget.
+// Values belong to a user in a room, to a user or to a room, depending on
+// the scope, and are kept in storage encrypted with AES-GCM.
+package memory
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// storageKey is the key of the memory in storage
+const storageKey = "memory"
+
+// keyRegex matches keys usable as {{.MEMORY.key}} in templates
+var keyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+var (
+	// ErrInvalidKey is returned for keys that are not usable in templates
+	ErrInvalidKey = errors.New("keys are letters, digits and underscores, starting with a letter or underscore, up to 64 characters")
+	// ErrTooMany is returned by Set when the owner has memory.max_keys keys
+	ErrTooMany = errors.New("too many keys")
+	// ErrTooLong is returned by Set for values over memory.max_value_length
+	ErrTooLong = errors.New("value too long")
+)
+
+// ValidKey reports whether key can be set and used in templates
+func ValidKey(key string) bool {
+	return keyRegex.MatchString(key)
+}
+
+// sealed is what is kept in storage
+type sealed struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Store is the memory, loaded from storage at startup and written back on
+// every change
+type Store struct {
+	store          storage.Store
+	aead           cipher.AEAD
+	scope          string
+	maxKeys        int
+	maxValueLength int
+
+	mu     sync.Mutex
+	values map[string]map[string]string // By owner, then key
+}
+
+// NewStore loads the memory from storage, decrypting it with a key derived
+// from secret
+func NewStore(cfg *config.MemoryConfig, secret string, store storage.Store) (*Store, error) {
+	if secret == "" {
+		return nil, errors.New("memory: no encryption key, set memory.encryption_key or matrix.picklekey")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		store:          store,
+		aead:           aead,
+		scope:          cfg.Scope,
+		maxKeys:        cfg.MaxKeys,
+		maxValueLength: cfg.MaxValueLength,
+		values:         make(map[string]map[string]string),
+	}
+
+	data, err := store.Get(context.Background(), storageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memory: failed to load: %w", err)
+	}
+	var box sealed
+	if err := json.Unmarshal(data, &box); err != nil {
+		return nil, fmt.Errorf("memory: failed to parse: %w", err)
+	}
+	plaintext, err := aead.Open(nil, box.Nonce, box.Ciphertext, []byte(storageKey))
+	if err != nil {
+		return nil, errors.New("memory: failed to decrypt, was the encryption key changed?")
+	}
+	if err := json.Unmarshal(plaintext, &s.values); err != nil {
+		return nil, fmt.Errorf("memory: failed to parse: %w", err)
+	}
+	return s, nil
+}
+
+// owner returns whose values a message of userID in roomID sees
+func (s *Store) owner(roomID, userID string) string {
+	switch s.scope {
+	case config.MemoryScopeUser:
+		return userID
+	case config.MemoryScopeRoom:
+		return roomID
+	default:
+		return roomID + " " + userID
+	}
+}
+
+// Get returns the value of key
+func (s *Store) Get(roomID, userID, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, exists := s.values[s.owner(roomID, userID)][key]
+	return value, exists
+}
+
+// Values returns a copy of all values, for templates
+func (s *Store) Values(roomID, userID string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]string)
+	for key, value := range s.values[s.owner(roomID, userID)] {
+		values[key] = value
+	}
+	return values
+}
+
+// Keys returns the keys set, sorted
+func (s *Store) Keys(roomID, userID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values[s.owner(roomID, userID)]))
+	for key := range s.values[s.owner(roomID, userID)] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set stores the value of key
+func (s *Store) Set(ctx context.Context, roomID, userID, key, value string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	if utf8.RuneCountInString(value) > s.maxValueLength {
+		return fmt.Errorf("%w, the limit is %d characters", ErrTooLong, s.maxValueLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner := s.owner(roomID, userID)
+	values := s.values[owner]
+	if _, exists := values[key]; !exists && len(values) >= s.maxKeys {
+		return fmt.Errorf("%w, the limit is %d", ErrTooMany, s.maxKeys)
+	}
+	if values == nil {
+		values = make(map[string]string)
+		s.values[owner] = values
+	}
+	previous, existed := values[key]
+	values[key] = value
+	if err := s.save(ctx); err != nil {
+		if existed {
+			values[key] = previous
+		} else {
+			delete(values, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Forget deletes key and reports whether it was set
+func (s *Store) Forget(ctx context.Context, roomID, userID, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner := s.owner(roomID, userID)
+	previous, exists := s.values[owner][key]
+	if !exists {
+		return false, nil
+	}
+	delete(s.values[owner], key)
+	if len(s.values[owner]) == 0 {
+		delete(s.values, owner)
+	}
+	if err := s.save(ctx); err != nil {
+		if s.values[owner] == nil {
+			s.values[owner] = make(map[string]string)
+		}
+		s.values[owner][key] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// save encrypts the memory and writes it to storage. The caller holds mu.
+func (s *Store) save(ctx context.Context) error {
+	plaintext, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sealed{Nonce: nonce, Ciphertext: s.aead.Seal(nil, nonce, plaintext, []byte(storageKey))})
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, storageKey, data); err != nil {
+		return fmt.Errorf("memory: failed to save: %w", err)
+	}
+	return nil
+}
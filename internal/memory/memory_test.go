@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.MemoryConfig{Scope: config.MemoryScopeRoomUser, MaxKeys: 2, MaxValueLength: 10}
+	store := storage.NewMemory()
+	s, err := NewStore(cfg, "secret", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "lang", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "tz", "Europe/Berlin"); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Set() of a long value error = %v, want ErrTooLong", err)
+	}
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "2fa", "on"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Set() of an invalid key error = %v, want ErrInvalidKey", err)
+	}
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "team", "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "third", "x"); !errors.Is(err, ErrTooMany) {
+		t.Errorf("Set() of a third key error = %v, want ErrTooMany", err)
+	}
+	if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "lang", "fr"); err != nil {
+		t.Errorf("Set() replacing a key at the limit error = %v", err)
+	}
+
+	if value, exists := s.Get("!a:example.com", "@bob:example.com", "lang"); !exists || value != "fr" {
+		t.Errorf("Get() = %q, %v, want fr", value, exists)
+	}
+	if _, exists := s.Get("!b:example.com", "@bob:example.com", "lang"); exists {
+		t.Error("Get() in another room returned the value")
+	}
+	if keys := s.Keys("!a:example.com", "@bob:example.com"); strings.Join(keys, ",") != "lang,team" {
+		t.Errorf("Keys() = %v, want lang and team", keys)
+	}
+
+	if forgotten, err := s.Forget(ctx, "!a:example.com", "@bob:example.com", "team"); err != nil || !forgotten {
+		t.Errorf("Forget() = %v, %v, want true", forgotten, err)
+	}
+	if forgotten, _ := s.Forget(ctx, "!a:example.com", "@bob:example.com", "team"); forgotten {
+		t.Error("Forget() of a forgotten key returned true")
+	}
+
+	// The memory is encrypted and survives a restart
+	data, _ := store.Get(ctx, storageKey)
+	if strings.Contains(string(data), "fr") && strings.Contains(string(data), "lang") {
+		t.Errorf("storage holds the memory in plain text: %s", data)
+	}
+	reloaded, err := NewStore(cfg, "secret", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := reloaded.Values("!a:example.com", "@bob:example.com"); len(values) != 1 || values["lang"] != "fr" {
+		t.Errorf("Values() after reload = %v, want lang=fr", values)
+	}
+	if _, err := NewStore(cfg, "other", store); err == nil {
+		t.Error("NewStore() with another key succeeded")
+	}
+}
+
+func TestStoreScopes(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		scope     string
+		otherRoom bool // Bob sees his value in another room
+		otherUser bool // Alice sees Bob's value in the same room
+	}{
+		{config.MemoryScopeRoomUser, false, false},
+		{config.MemoryScopeUser, true, false},
+		{config.MemoryScopeRoom, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			s, err := NewStore(&config.MemoryConfig{Scope: tt.scope, MaxKeys: 10, MaxValueLength: 100}, "secret", storage.NewMemory())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Set(ctx, "!a:example.com", "@bob:example.com", "lang", "de"); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := s.Get("!b:example.com", "@bob:example.com", "lang"); exists != tt.otherRoom {
+				t.Errorf("value in another room = %v, want %v", exists, tt.otherRoom)
+			}
+			if _, exists := s.Get("!a:example.com", "@alice:example.com", "lang"); exists != tt.otherUser {
+				t.Errorf("value of another user = %v, want %v", exists, tt.otherUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Package pagerduty triggers, acknowledges and resolves PagerDuty incidents
+// through the Events API v2 and the REST API
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Actions of the Events API
+const (
+	ActionTrigger     = "trigger"
+	ActionAcknowledge = "acknowledge"
+	ActionResolve     = "resolve"
+)
+
+// Event is sent to the Events API to trigger, acknowledge or resolve the
+// alert with a dedup key
+type Event struct {
+	RoutingKey  string   `json:"routing_key"`
+	EventAction string   `json:"event_action"`
+	DedupKey    string   `json:"dedup_key,omitempty"`
+	Payload     *Payload `json:"payload,omitempty"` // Required to trigger
+}
+
+// Payload describes a triggered alert
+type Payload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"` // critical, error, warning or info
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// Client calls the Events API and, with an API token, the REST API
+type Client struct {
+	eventsURL string
+	apiURL    string
+	apiToken  string
+	from      string
+	http      *http.Client
+}
+
+// NewClient creates a client with the endpoints and credentials of cfg
+func NewClient(cfg *config.PagerDutyConfig) *Client {
+	return &Client{
+		eventsURL: cfg.EventsURL,
+		apiURL:    strings.TrimSuffix(cfg.APIURL, "/"),
+		apiToken:  cfg.APIToken,
+		from:      cfg.From,
+		http:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+// CanUpdateIncidents reports whether an API token is set, which
+// SetIncidentStatus needs
+func (c *Client) CanUpdateIncidents() bool {
+	return c.apiToken != ""
+}
+
+// SendEvent sends an event to the Events API and returns the dedup key of
+// its alert, generated by PagerDuty when the event has none
+func (c *Client) SendEvent(ctx context.Context, e Event) (string, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.eventsURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Status   string   `json:"status"`
+		Message  string   `json:"message"`
+		DedupKey string   `json:"dedup_key"`
+		Errors   []string `json:"errors"`
+	}
+	status, err := c.do(req, &result)
+	if err != nil {
+		return "", fmt.Errorf("pagerduty %s failed: %w", e.EventAction, err)
+	}
+	if status >= 300 {
+		problem := result.Message
+		if len(result.Errors) > 0 {
+			problem += ": " + strings.Join(result.Errors, "; ")
+		}
+		return "", fmt.Errorf("pagerduty %s failed with status %d: %s", e.EventAction, status, problem)
+	}
+	return result.DedupKey, nil
+}
+
+// SetIncidentStatus acknowledges or resolves an incident through the REST
+// API, as the user of pagerduty.from. Status is acknowledged or resolved.
+func (c *Client) SetIncidentStatus(ctx context.Context, incidentID, status string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"incident": map[string]string{"type": "incident_reference", "status": status},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.apiURL+"/incidents/"+url.PathEscape(incidentID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
+	req.Header.Set("Authorization", "Token token="+c.apiToken)
+	req.Header.Set("From", c.from)
+
+	var result struct {
+		Error struct {
+			Message string   `json:"message"`
+			Errors  []string `json:"errors"`
+		} `json:"error"`
+	}
+	code, err := c.do(req, &result)
+	if err != nil {
+		return fmt.Errorf("pagerduty incident update failed: %w", err)
+	}
+	if code >= 300 {
+		problem := result.Error.Message
+		if len(result.Error.Errors) > 0 {
+			problem += ": " + strings.Join(result.Error.Errors, "; ")
+		}
+		return fmt.Errorf("pagerduty incident update failed with status %d: %s", code, problem)
+	}
+	return nil
+}
+
+// do sends a request and decodes the JSON response, of errors too, into
+// result. It returns the status code.
+func (c *Client) do(req *http.Request, result interface{}) (int, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	// Error bodies are not always JSON; the status is reported either way
+	json.Unmarshal(data, result)
+	return resp.StatusCode, nil
+}
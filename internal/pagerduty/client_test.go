@@ -0,0 +1,83 @@
+package pagerduty
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestSendEvent(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if received.RoutingKey != "valid" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status": "invalid event", "message": "Event object is invalid", "errors": ["Length of 'routing_key' is incorrect (should be 32 characters)"]}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "success", "message": "Event processed", "dedup_key": "abc123"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.PagerDutyConfig{EventsURL: server.URL, Timeout: 5})
+	dedupKey, err := client.SendEvent(context.Background(), Event{
+		RoutingKey:  "valid",
+		EventAction: ActionTrigger,
+		Payload:     &Payload{Summary: "Database down", Source: "!ops:example.com", Severity: "critical"},
+	})
+	if err != nil {
+		t.Fatalf("SendEvent() error = %v", err)
+	}
+	if dedupKey != "abc123" {
+		t.Errorf("dedup key = %q, want abc123", dedupKey)
+	}
+	if received.Payload == nil || received.Payload.Summary != "Database down" || received.EventAction != ActionTrigger {
+		t.Errorf("received %+v", received)
+	}
+
+	_, err = client.SendEvent(context.Background(), Event{RoutingKey: "short", EventAction: ActionResolve, DedupKey: "abc123"})
+	if err == nil || !strings.Contains(err.Error(), "routing_key") {
+		t.Errorf("SendEvent() error = %v, want the PagerDuty errors", err)
+	}
+}
+
+func TestSetIncidentStatus(t *testing.T) {
+	var request *http.Request
+	var body map[string]map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/incidents/PGR0VU2" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not Found", "code": 2100}}`))
+			return
+		}
+		w.Write([]byte(`{"incident": {"id": "PGR0VU2", "status": "resolved"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.PagerDutyConfig{APIURL: server.URL + "/", APIToken: "token", From: "oncall@example.com", Timeout: 5})
+	if !client.CanUpdateIncidents() {
+		t.Fatal("CanUpdateIncidents() = false with an API token")
+	}
+	if err := client.SetIncidentStatus(context.Background(), "PGR0VU2", "resolved"); err != nil {
+		t.Fatalf("SetIncidentStatus() error = %v", err)
+	}
+	if request.Method != http.MethodPut || request.Header.Get("Authorization") != "Token token=token" || request.Header.Get("From") != "oncall@example.com" {
+		t.Errorf("request = %s with headers %v", request.Method, request.Header)
+	}
+	if body["incident"]["status"] != "resolved" || body["incident"]["type"] != "incident_reference" {
+		t.Errorf("body = %v", body)
+	}
+
+	err := client.SetIncidentStatus(context.Background(), "PMISSING", "acknowledged")
+	if err == nil || !strings.Contains(err.Error(), "404: Not Found") {
+		t.Errorf("SetIncidentStatus() error = %v, want the PagerDuty error", err)
+	}
+}
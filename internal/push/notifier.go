@@ -0,0 +1,220 @@
+// Package push mirrors room messages to phones through ntfy and Pushover
+package push
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// Notifications waiting to be sent at most; more are dropped
+const queueSize = 100
+
+// Message is a room message considered for mirroring
+type Message struct {
+	RoomID   string
+	Sender   string
+	EventID  string
+	Body     string
+	Mentions []string // Users the message mentions
+}
+
+// Notification is sent to a target
+type Notification struct {
+	Title    string
+	Message  string
+	Priority string // min, low, default, high or urgent
+	Click    string // Link opened by tapping the notification
+}
+
+// target sends notifications to a service
+type target interface {
+	send(ctx context.Context, client *http.Client, n Notification) error
+}
+
+// rule is a compiled push rule
+type rule struct {
+	config.PushRuleConfig
+	rooms   map[string]bool
+	senders map[string]bool
+	match   *regexp.Regexp
+}
+
+// job is a notification queued for a target
+type job struct {
+	target       string
+	notification Notification
+}
+
+// Notifier sends the messages matching its rules to their targets, in the
+// background so that slow services do not hold up the Matrix sync
+type Notifier struct {
+	rules   []rule
+	targets map[string]target
+	client  *http.Client
+	timeout time.Duration
+	logger  *logger.Logger
+
+	queue  chan job
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New compiles the rules and targets of cfg
+func New(cfg *config.PushConfig, log *logger.Logger) (*Notifier, error) {
+	n := &Notifier{
+		targets: make(map[string]target, len(cfg.Targets)),
+		client:  &http.Client{},
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+		logger:  log,
+		queue:   make(chan job, queueSize),
+	}
+	for name, targetCfg := range cfg.Targets {
+		switch targetCfg.Type {
+		case "ntfy":
+			n.targets[name] = newNtfy(targetCfg)
+		case "pushover":
+			n.targets[name] = newPushover(targetCfg)
+		default:
+			return nil, fmt.Errorf("push.targets.%s: unknown type %q", name, targetCfg.Type)
+		}
+	}
+	for _, ruleCfg := range cfg.Rules {
+		r := rule{PushRuleConfig: ruleCfg, rooms: toSet(ruleCfg.Rooms), senders: toSet(ruleCfg.Senders)}
+		if ruleCfg.Match != "" {
+			var err error
+			if r.match, err = regexp.Compile(ruleCfg.Match); err != nil {
+				return nil, fmt.Errorf("push rule %s: invalid match: %w", ruleCfg.Name, err)
+			}
+		}
+		n.rules = append(n.rules, r)
+	}
+	return n, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// matches reports whether a message meets every condition of the rule
+func (r *rule) matches(m Message) bool {
+	if len(r.rooms) > 0 && !r.rooms[m.RoomID] {
+		return false
+	}
+	if len(r.senders) > 0 && !r.senders[m.Sender] {
+		return false
+	}
+	if r.match != nil && !r.match.MatchString(m.Body) {
+		return false
+	}
+	if len(r.Mentions) > 0 && !mentionsAny(m, r.Mentions) {
+		return false
+	}
+	return true
+}
+
+// mentionsAny reports whether a message mentions one of the users, in its
+// mentions or by user ID in the text
+func mentionsAny(m Message, users []string) bool {
+	for _, user := range users {
+		for _, mentioned := range m.Mentions {
+			if mentioned == user {
+				return true
+			}
+		}
+		if strings.Contains(m.Body, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle queues a notification of the message for the targets of the rules
+// it matches. A target matched by several rules is notified once, with the
+// first of them.
+func (n *Notifier) Handle(m Message) {
+	notified := make(map[string]bool)
+	for i := range n.rules {
+		r := &n.rules[i]
+		if !r.matches(m) {
+			continue
+		}
+		title := r.Title
+		if title == "" {
+			title = r.Name
+		}
+		notification := Notification{
+			Title:    title,
+			Message:  m.Body,
+			Priority: r.Priority,
+			Click:    fmt.Sprintf("https://matrix.to/#/%s/%s", m.RoomID, m.EventID),
+		}
+		for _, name := range r.Targets {
+			if notified[name] {
+				continue
+			}
+			notified[name] = true
+			select {
+			case n.queue <- job{target: name, notification: notification}:
+				n.logger.Debug("Queued push notification of %s to %s (rule %s)", m.EventID, name, r.Name)
+			default:
+				n.logger.Warn("Push queue is full, dropping notification of %s to %s", m.EventID, name)
+			}
+		}
+	}
+}
+
+// Start sends the queued notifications in the background until Stop
+func (n *Notifier) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		for {
+			select {
+			case j := <-n.queue:
+				n.send(j)
+			case <-ctx.Done():
+				// Send what was queued before stopping
+				for {
+					select {
+					case j := <-n.queue:
+						n.send(j)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop sends the notifications still queued and stops
+func (n *Notifier) Stop() {
+	if n.cancel == nil {
+		return
+	}
+	n.cancel()
+	<-n.done
+}
+
+func (n *Notifier) send(j job) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	if err := n.targets[j.target].send(ctx, n.client, j.notification); err != nil {
+		n.logger.Error("Failed to send push notification to %s: %v", j.target, err)
+		return
+	}
+	n.logger.Info("Sent push notification %q to %s", j.notification.Title, j.target)
+}
@@ -0,0 +1,172 @@
+package push
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// recorder records the requests it receives
+type recorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	status   int
+	response string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, string(body))
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+	w.Write([]byte(rec.response))
+}
+
+func testNotifier(t *testing.T, cfg *config.PushConfig) *Notifier {
+	t.Helper()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	n, err := New(cfg, log)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return n
+}
+
+func TestRuleMatches(t *testing.T) {
+	n := testNotifier(t, &config.PushConfig{
+		Targets: map[string]config.PushTargetConfig{"phone": {Type: "ntfy", Topic: "alerts"}},
+		Rules: []config.PushRuleConfig{
+			{Name: "critical", Rooms: []string{"!alerts:example.com"}, Match: `(?i)severity: critical`, Targets: []string{"phone"}},
+			{Name: "oncall", Mentions: []string{"@oncall:example.com"}, Senders: []string{"@alice:example.com"}, Targets: []string{"phone"}},
+		},
+	})
+	critical, oncall := &n.rules[0], &n.rules[1]
+
+	tests := []struct {
+		name     string
+		rule     *rule
+		message  Message
+		expected bool
+	}{
+		{"Critical in room", critical, Message{RoomID: "!alerts:example.com", Body: "DiskFull Severity: critical"}, true},
+		{"Warning in room", critical, Message{RoomID: "!alerts:example.com", Body: "DiskFull severity: warning"}, false},
+		{"Critical elsewhere", critical, Message{RoomID: "!other:example.com", Body: "severity: critical"}, false},
+		{"Mentioned", oncall, Message{Sender: "@alice:example.com", Mentions: []string{"@oncall:example.com"}}, true},
+		{"Mentioned in text", oncall, Message{Sender: "@alice:example.com", Body: "help @oncall:example.com"}, true},
+		{"Mentioned by someone else", oncall, Message{Sender: "@bob:example.com", Mentions: []string{"@oncall:example.com"}}, false},
+		{"Not mentioned", oncall, Message{Sender: "@alice:example.com", Body: "hello"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.message); got != tt.expected {
+				t.Errorf("matches() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNotifierSendsToTargets(t *testing.T) {
+	ntfyServer := &recorder{}
+	ntfyHTTP := httptest.NewServer(ntfyServer)
+	defer ntfyHTTP.Close()
+	pushoverServer := &recorder{response: `{"status": 1}`}
+	pushoverHTTP := httptest.NewServer(pushoverServer)
+	defer pushoverHTTP.Close()
+
+	n := testNotifier(t, &config.PushConfig{
+		Targets: map[string]config.PushTargetConfig{
+			"ntfy":     {Type: "ntfy", URL: ntfyHTTP.URL, Topic: "ops alerts", Token: "tk_secret"},
+			"pushover": {Type: "pushover", URL: pushoverHTTP.URL, AppToken: "app", UserKey: "user", Device: "phone"},
+		},
+		Rules: []config.PushRuleConfig{
+			{Name: "critical", Match: "critical", Targets: []string{"ntfy", "pushover"}, Priority: "urgent", Title: "Critical alert ⚠"},
+			{Name: "all", Targets: []string{"ntfy"}},
+		},
+	})
+	n.Start()
+	n.Handle(Message{RoomID: "!alerts:example.com", EventID: "$event", Body: "DiskFull is critical"})
+	n.Handle(Message{RoomID: "!alerts:example.com", EventID: "$other", Body: "All good"})
+	n.Stop()
+
+	// The first message matches both rules but reaches ntfy once
+	if len(ntfyServer.requests) != 2 {
+		t.Fatalf("ntfy received %d requests, want 2", len(ntfyServer.requests))
+	}
+	req := ntfyServer.requests[0]
+	if req.URL.Path != "/ops alerts" {
+		t.Errorf("ntfy path = %q, want %q", req.URL.Path, "/ops alerts")
+	}
+	if ntfyServer.bodies[0] != "DiskFull is critical" {
+		t.Errorf("ntfy body = %q", ntfyServer.bodies[0])
+	}
+	if req.Header.Get("Priority") != "urgent" || req.Header.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("ntfy headers = %v", req.Header)
+	}
+	if !strings.HasPrefix(req.Header.Get("Title"), "=?utf-8?q?") {
+		t.Errorf("ntfy Title = %q, want RFC 2047 encoded", req.Header.Get("Title"))
+	}
+	if click := req.Header.Get("Click"); click != "https://matrix.to/#/!alerts:example.com/$event" {
+		t.Errorf("ntfy Click = %q", click)
+	}
+	if title := ntfyServer.requests[1].Header.Get("Title"); title != "all" {
+		t.Errorf("ntfy Title = %q, want the rule name", title)
+	}
+
+	if len(pushoverServer.requests) != 1 {
+		t.Fatalf("pushover received %d requests, want 1", len(pushoverServer.requests))
+	}
+	form, _ := url.ParseQuery(pushoverServer.bodies[0])
+	expected := map[string]string{
+		"token":    "app",
+		"user":     "user",
+		"device":   "phone",
+		"title":    "Critical alert ⚠",
+		"message":  "DiskFull is critical",
+		"priority": "2",
+		"retry":    "60",
+		"expire":   "3600",
+	}
+	for key, value := range expected {
+		if form.Get(key) != value {
+			t.Errorf("pushover %s = %q, want %q", key, form.Get(key), value)
+		}
+	}
+}
+
+func TestTargetErrors(t *testing.T) {
+	server := &recorder{status: http.StatusBadRequest, response: `{"user": "invalid", "errors": ["user identifier is invalid"], "status": 0}`}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	target := newPushover(config.PushTargetConfig{URL: httpServer.URL, AppToken: "app", UserKey: "nobody"})
+	err := target.send(context.Background(), http.DefaultClient, Notification{Title: "t", Message: "m"})
+	if err == nil || !strings.Contains(err.Error(), "user identifier is invalid") {
+		t.Errorf("send() error = %v, want the Pushover error", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate() = %q, want unchanged", got)
+	}
+	got := truncate(strings.Repeat("é", 10), 9)
+	if len(got) > 9 || !strings.HasSuffix(got, "…") || !strings.HasPrefix(got, "éé") {
+		t.Errorf("truncate() = %q, want at most 9 bytes ending with …", got)
+	}
+}
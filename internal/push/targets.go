@@ -0,0 +1,155 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+const (
+	defaultNtfyURL     = "https://ntfy.sh"
+	defaultPushoverURL = "https://api.pushover.net/1/messages.json"
+
+	// ntfy turns longer messages into attachments
+	maxNtfyMessage = 4000
+	// Longest Pushover message and title
+	maxPushoverMessage = 1024
+	maxPushoverTitle   = 250
+)
+
+// pushoverPriorities maps priorities to those of Pushover. Urgent is an
+// emergency notification, repeated until acknowledged.
+var pushoverPriorities = map[string]string{
+	"min":     "-2",
+	"low":     "-1",
+	"":        "0",
+	"default": "0",
+	"high":    "1",
+	"urgent":  "2",
+}
+
+// ntfy publishes to a topic of an ntfy server
+type ntfy struct {
+	url   string
+	token string
+}
+
+func newNtfy(cfg config.PushTargetConfig) *ntfy {
+	server := cfg.URL
+	if server == "" {
+		server = defaultNtfyURL
+	}
+	return &ntfy{url: strings.TrimSuffix(server, "/") + "/" + url.PathEscape(cfg.Topic), token: cfg.Token}
+}
+
+func (t *ntfy) send(ctx context.Context, client *http.Client, n Notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(truncate(n.Message, maxNtfyMessage)))
+	if err != nil {
+		return err
+	}
+	// Headers are ASCII; ntfy decodes RFC 2047 encoded titles
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", n.Title))
+	if n.Priority != "" {
+		req.Header.Set("Priority", n.Priority)
+	}
+	if n.Click != "" {
+		req.Header.Set("Click", n.Click)
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return do(client, req)
+}
+
+// pushover sends to a user or group of a Pushover application
+type pushover struct {
+	url      string
+	appToken string
+	userKey  string
+	device   string
+}
+
+func newPushover(cfg config.PushTargetConfig) *pushover {
+	endpoint := cfg.URL
+	if endpoint == "" {
+		endpoint = defaultPushoverURL
+	}
+	return &pushover{url: endpoint, appToken: cfg.AppToken, userKey: cfg.UserKey, device: cfg.Device}
+}
+
+func (t *pushover) send(ctx context.Context, client *http.Client, n Notification) error {
+	form := url.Values{
+		"token":    {t.appToken},
+		"user":     {t.userKey},
+		"title":    {truncate(n.Title, maxPushoverTitle)},
+		"message":  {truncate(n.Message, maxPushoverMessage)},
+		"priority": {pushoverPriorities[n.Priority]},
+	}
+	if n.Priority == "urgent" {
+		// Repeat every minute for an hour unless acknowledged
+		form.Set("retry", "60")
+		form.Set("expire", "3600")
+	}
+	if n.Click != "" {
+		form.Set("url", n.Click)
+		form.Set("url_title", "Open in Matrix")
+	}
+	if t.device != "" {
+		form.Set("device", t.device)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(client, req)
+}
+
+// do sends a request and turns error statuses into errors, with the
+// service's explanation
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// Pushover lists errors, ntfy has a single one
+	var explained struct {
+		Errors []string `json:"errors"`
+		Error  string   `json:"error"`
+	}
+	if json.Unmarshal(body, &explained) == nil {
+		if len(explained.Errors) > 0 {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, strings.Join(explained.Errors, "; "))
+		}
+		if explained.Error != "" {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, explained.Error)
+		}
+	}
+	return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// truncate shortens a text to at most max bytes, on a rune boundary
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len("…")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
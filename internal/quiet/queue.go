@@ -0,0 +1,193 @@
+package quiet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+const (
+	// How often the queue looks for rooms whose quiet hours ended
+	defaultTick = time.Minute
+	// Key of the held notifications in storage
+	storageKey = "quiet.held"
+)
+
+// Notification is a message held until the quiet hours of its room end
+type Notification struct {
+	Source     string    `json:"source"` // e.g. alertmanager:ops, matched by quiet_hours.critical
+	RoomID     string    `json:"room_id"`
+	Message    string    `json:"message"`
+	Format     string    `json:"format,omitempty"`
+	MsgType    string    `json:"msgtype,omitempty"`
+	ThreadRoot string    `json:"thread_root,omitempty"`
+	InReplyTo  string    `json:"in_reply_to,omitempty"`
+	Sender     string    `json:"sender,omitempty"` // Name the message is posted under, e.g. of a hook
+	HeldAt     time.Time `json:"held_at"`
+}
+
+// QuietFunc reports whether a room is in quiet hours at t
+type QuietFunc func(roomID string, t time.Time) bool
+
+// DeliverFunc posts a held notification. A notification that fails is
+// tried again later.
+type DeliverFunc func(n Notification) error
+
+// Queue keeps the held notifications in storage and posts them, in the
+// order they were held, once the quiet hours of their room end.
+// Notifications held while the service was down are posted after it starts.
+type Queue struct {
+	store   storage.Store
+	quiet   QuietFunc
+	deliver DeliverFunc
+	logger  *logger.Logger
+	tick    time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex // Guards held and the stored state
+	held []Notification
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewQueue loads the held notifications from storage
+func NewQueue(store storage.Store, quiet QuietFunc, deliver DeliverFunc, log *logger.Logger) (*Queue, error) {
+	q := &Queue{
+		store:   store,
+		quiet:   quiet,
+		deliver: deliver,
+		logger:  log,
+		tick:    defaultTick,
+		now:     time.Now,
+	}
+	data, err := store.Get(context.Background(), storageKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read held notifications: %w", err)
+	default:
+		if err := json.Unmarshal(data, &q.held); err != nil {
+			return nil, fmt.Errorf("invalid stored notifications: %w", err)
+		}
+	}
+	if len(q.held) > 0 {
+		log.Info("Loaded %d notifications held for quiet hours", len(q.held))
+	}
+	return q, nil
+}
+
+// Hold stores a notification until the quiet hours of its room end
+func (q *Queue) Hold(n Notification) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n.HeldAt = q.now().UTC()
+	q.held = append(q.held, n)
+	if err := q.save(); err != nil {
+		q.held = q.held[:len(q.held)-1]
+		return err
+	}
+	q.logger.Info("Holding %s notification for %s until its quiet hours end", n.Source, n.RoomID)
+	return nil
+}
+
+// Len returns how many notifications are held
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.held)
+}
+
+// Start posts the notifications in the background until Stop
+func (q *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(q.tick)
+		defer ticker.Stop()
+		for {
+			q.release()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops posting notifications; those still held stay in storage
+func (q *Queue) Stop() {
+	if q.cancel == nil {
+		return
+	}
+	q.cancel()
+	<-q.done
+}
+
+// release posts the notifications of rooms that are no longer quiet. Those
+// that fail are put back in front, to be tried again at the next tick.
+func (q *Queue) release() {
+	now := q.now()
+	q.mu.Lock()
+	var due, kept []Notification
+	for _, n := range q.held {
+		if q.quiet(n.RoomID, now) {
+			kept = append(kept, n)
+		} else {
+			due = append(due, n)
+		}
+	}
+	if len(due) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	q.held = kept
+	if err := q.save(); err != nil {
+		q.logger.Error("%v", err)
+	}
+	q.mu.Unlock()
+
+	var failed []Notification
+	for _, n := range due {
+		if err := q.deliver(n); err != nil {
+			q.logger.Warn("Failed to post %s notification held for %s: %v", n.Source, n.RoomID, err)
+			failed = append(failed, n)
+			continue
+		}
+		q.logger.Info("Posted %s notification held for %s since %s", n.Source, n.RoomID, n.HeldAt.Format(time.RFC3339))
+	}
+	if len(failed) == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held = append(failed, q.held...)
+	if err := q.save(); err != nil {
+		q.logger.Error("%v", err)
+	}
+}
+
+// save stores the held notifications. Called with mu held.
+func (q *Queue) save() error {
+	held := q.held
+	if held == nil {
+		held = []Notification{}
+	}
+	data, err := json.Marshal(held)
+	if err != nil {
+		return err
+	}
+	if err := q.store.Put(context.Background(), storageKey, data); err != nil {
+		return fmt.Errorf("failed to save held notifications: %w", err)
+	}
+	return nil
+}
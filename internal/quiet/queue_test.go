@@ -0,0 +1,66 @@
+package quiet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func TestQueue(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	store := storage.NewMemory()
+	quietRooms := map[string]bool{"!a:example.com": true, "!b:example.com": true}
+	quiet := func(roomID string, t time.Time) bool { return quietRooms[roomID] }
+	var delivered []string
+	fail := false
+	deliver := func(n Notification) error {
+		if fail {
+			return errors.New("unreachable")
+		}
+		delivered = append(delivered, n.Message)
+		return nil
+	}
+
+	q, err := NewQueue(store, quiet, deliver, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []Notification{
+		{Source: "feed", RoomID: "!a:example.com", Message: "first"},
+		{Source: "feed", RoomID: "!b:example.com", Message: "other room"},
+		{Source: "feed", RoomID: "!a:example.com", Message: "second"},
+	} {
+		if err := q.Hold(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.release()
+	if len(delivered) != 0 || q.Len() != 3 {
+		t.Fatalf("Delivered %v during quiet hours", delivered)
+	}
+
+	// The held notifications survive a restart
+	q, err = NewQueue(store, quiet, deliver, log)
+	if err != nil || q.Len() != 3 {
+		t.Fatalf("NewQueue() = %d held, %v, want 3", q.Len(), err)
+	}
+
+	quietRooms["!a:example.com"] = false
+	fail = true
+	q.release()
+	if q.Len() != 3 {
+		t.Errorf("%d held after failed deliveries, want them kept", q.Len())
+	}
+	fail = false
+	q.release()
+	if len(delivered) != 2 || delivered[0] != "first" || delivered[1] != "second" {
+		t.Errorf("Delivered %v, want the notifications of the room in order", delivered)
+	}
+	if q.Len() != 1 {
+		t.Errorf("%d held, want the one of the room still quiet", q.Len())
+	}
+}
@@ -0,0 +1,137 @@
+// Package quiet holds notifications during quiet hours and posts them when
+// the hours end
+package quiet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// weekdays are the days of quiet_hours.days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window is a range of quiet_hours.ranges in minutes after midnight. A
+// window that ends before it starts ends the next day.
+type window struct {
+	start, end int
+}
+
+func (w window) overnight() bool {
+	return w.end < w.start
+}
+
+// Schedule tells when quiet hours are. A nil schedule is never quiet.
+type Schedule struct {
+	config   *config.QuietHoursConfig
+	windows  []window
+	days     map[time.Weekday]bool // nil = every day
+	location *time.Location
+}
+
+// NewSchedule parses quiet hours. It returns nil if they have no ranges.
+func NewSchedule(cfg *config.QuietHoursConfig) (*Schedule, error) {
+	if len(cfg.Ranges) == 0 {
+		return nil, nil
+	}
+	s := &Schedule{config: cfg, location: time.Local}
+	if cfg.TimeZone != "" {
+		var err error
+		if s.location, err = time.LoadLocation(cfg.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	for _, r := range cfg.Ranges {
+		w, err := parseWindow(r)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(cfg.Days) > 0 {
+		s.days = make(map[time.Weekday]bool, len(cfg.Days))
+		for _, day := range cfg.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", day)
+			}
+			s.days[weekday] = true
+		}
+	}
+	return s, nil
+}
+
+// parseWindow parses a range such as 22:00-07:00
+func parseWindow(r string) (window, error) {
+	start, end, found := strings.Cut(r, "-")
+	if !found {
+		return window{}, fmt.Errorf("invalid range %q, expected e.g. 22:00-07:00", r)
+	}
+	var w window
+	for _, bound := range []struct {
+		text    string
+		minutes *int
+	}{{start, &w.start}, {end, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.text))
+		if err != nil {
+			return window{}, fmt.Errorf("invalid range %q, expected e.g. 22:00-07:00", r)
+		}
+		*bound.minutes = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return window{}, fmt.Errorf("range %q starts and ends at the same time", r)
+	}
+	return w, nil
+}
+
+// Active reports whether t is in quiet hours
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+	t = t.In(s.location)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+	yesterday := today.AddDate(0, 0, -1)
+	for _, w := range s.windows {
+		if s.startsOn(today) {
+			end := s.at(today, w.end)
+			if w.overnight() {
+				end = s.at(today.AddDate(0, 0, 1), w.end)
+			}
+			if !t.Before(s.at(today, w.start)) && t.Before(end) {
+				return true
+			}
+		}
+		// A window of yesterday that lasts past midnight
+		if w.overnight() && s.startsOn(yesterday) && t.Before(s.at(today, w.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds reports whether a notification of the source is held at t: it is
+// in quiet hours and the source is not critical
+func (s *Schedule) Holds(source string, t time.Time) bool {
+	return s.Active(t) && !s.config.IsCritical(source)
+}
+
+func (s *Schedule) startsOn(day time.Time) bool {
+	return s.days == nil || s.days[day.Weekday()]
+}
+
+// at returns the time minutes after the midnight of day, in the wall clock
+// time of the location so that days with a DST change keep their hours
+func (s *Schedule) at(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, s.location)
+}
@@ -0,0 +1,71 @@
+package quiet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestScheduleActive(t *testing.T) {
+	s, err := NewSchedule(&config.QuietHoursConfig{
+		Ranges:   []string{"22:00-07:00", "12:00-13:00"},
+		Days:     []string{"mon", "tue", "wed", "thu", "fri"},
+		TimeZone: "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		// October 12, 2026 is a Monday
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"monday evening", at(12, 21, 59), false},
+		{"monday night", at(12, 22, 0), true},
+		{"tuesday early morning", at(13, 6, 59), true},
+		{"tuesday morning", at(13, 7, 0), false},
+		{"lunch", at(14, 12, 30), true},
+		{"saturday early morning after friday night", at(17, 3, 0), true},
+		{"saturday night", at(17, 23, 0), false},
+		{"monday early morning after sunday", at(19, 3, 0), false},
+		{"utc", time.Date(2026, time.October, 12, 20, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		if got := s.Active(tt.t); got != tt.want {
+			t.Errorf("Active() %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleHolds(t *testing.T) {
+	s, err := NewSchedule(&config.QuietHoursConfig{Ranges: []string{"00:00-23:59"}, Critical: []string{"alertmanager:*", "hook:pagerduty"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.October, 12, 12, 0, 0, 0, time.Local)
+	for source, want := range map[string]bool{"feed": true, "hook:grafana": true, "hook:pagerduty": false, "alertmanager:ops": false} {
+		if got := s.Holds(source, now); got != want {
+			t.Errorf("Holds(%s) = %v, want %v", source, got, want)
+		}
+	}
+
+	var never *Schedule
+	if never.Holds("feed", now) {
+		t.Error("Holds() of a nil schedule = true")
+	}
+	if s, err := NewSchedule(&config.QuietHoursConfig{}); s != nil || err != nil {
+		t.Errorf("NewSchedule() without ranges = %v, %v, want nil", s, err)
+	}
+	for _, r := range []string{"22:00", "22:00-25:00", "07:00-07:00"} {
+		if _, err := NewSchedule(&config.QuietHoursConfig{Ranges: []string{r}}); err == nil {
+			t.Errorf("NewSchedule(%q) error = nil", r)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package remind keeps the reminders set with /remind and posts them when
+// they are due
+package remind
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request is a parsed /remind command
+type Request struct {
+	Target string // "me" or the ID of the user to remind
+	Due    time.Time
+	Text   string
+}
+
+const usage = "expected e.g. `me in 2h to rotate the key`, `@alice:example.com tomorrow 9:00 standup` or `me on 2026-12-24 at 18:00 call home`"
+
+var (
+	compactDurationRegex = regexp.MustCompile(`^(\d+[a-z]+)+$`)
+	durationPartRegex    = regexp.MustCompile(`(\d+)([a-z]+)`)
+	clockRegex           = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	userIDRegex          = regexp.MustCompile(`^@[^:\s]+:\S+$`)
+)
+
+var durationUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// Parse parses the arguments of /remind: who to remind, when and what.
+// When is one of
+//
+//	in 2h, in 1h30m, in 3 days
+//	at 17:30 (today, or tomorrow once passed)
+//	today 17:30, tomorrow [9:00], friday [14:00]
+//	on 2026-12-24 [18:00]
+//
+// with times of day such as 9:00, 9am or 5:30pm. Days without a time use
+// defaultTime, the time of day since midnight. Dates and times are in the
+// location of now.
+func Parse(args string, now time.Time, defaultTime time.Duration) (Request, error) {
+	tokens := strings.Fields(args)
+	if len(tokens) < 3 {
+		return Request{}, errors.New(usage)
+	}
+
+	req := Request{Target: tokens[0]}
+	if req.Target != "me" && !userIDRegex.MatchString(req.Target) {
+		return Request{}, fmt.Errorf("%q is neither me nor a user ID such as @alice:example.com", req.Target)
+	}
+
+	due, rest, err := parseWhen(tokens[1:], now, defaultTime)
+	if err != nil {
+		return Request{}, err
+	}
+	if len(rest) > 0 && strings.EqualFold(rest[0], "to") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return Request{}, fmt.Errorf("what to remind of is missing, %s", usage)
+	}
+	if !due.After(now) {
+		return Request{}, fmt.Errorf("%s has already passed", due.Format(TimeLayout))
+	}
+	req.Due = due
+	req.Text = strings.Join(rest, " ")
+	return req, nil
+}
+
+// TimeLayout formats the due times of reminders
+const TimeLayout = "Mon 2 Jan 2006 15:04 MST"
+
+// parseWhen parses the time at the start of tokens and returns the tokens
+// after it
+func parseWhen(tokens []string, now time.Time, defaultTime time.Duration) (time.Time, []string, error) {
+	word := strings.ToLower(tokens[0])
+	rest := tokens[1:]
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch word {
+	case "in":
+		var total time.Duration
+		for len(rest) > 0 {
+			d, n := parseDuration(rest)
+			if n == 0 {
+				break
+			}
+			total += d
+			rest = rest[n:]
+		}
+		if total == 0 {
+			return time.Time{}, nil, fmt.Errorf("expected a duration after in, e.g. 30m, 2h or 3 days")
+		}
+		return now.Add(total), rest, nil
+	case "at":
+		if len(rest) == 0 {
+			return time.Time{}, nil, fmt.Errorf("expected a time after at, e.g. 17:30")
+		}
+		clock, n, ok := parseClock(rest)
+		if !ok {
+			return time.Time{}, nil, fmt.Errorf("%q is not a time of day, e.g. 17:30 or 5pm", rest[0])
+		}
+		due := atClock(today, clock)
+		if !due.After(now) {
+			due = atClock(today.AddDate(0, 0, 1), clock)
+		}
+		return due, rest[n:], nil
+	case "today":
+		clock, rest := optionalClock(rest, defaultTime)
+		return atClock(today, clock), rest, nil
+	case "tomorrow":
+		clock, rest := optionalClock(rest, defaultTime)
+		return atClock(today.AddDate(0, 0, 1), clock), rest, nil
+	case "on":
+		if len(rest) == 0 {
+			return time.Time{}, nil, fmt.Errorf("expected a date after on, e.g. 2026-12-24 or friday")
+		}
+		return parseWhen(rest, now, defaultTime)
+	}
+
+	if weekday, ok := weekdays[word]; ok {
+		clock, rest := optionalClock(rest, defaultTime)
+		// The next such day whose time has not passed, possibly today
+		day := today.AddDate(0, 0, (int(weekday)-int(today.Weekday())+7)%7)
+		due := atClock(day, clock)
+		if !due.After(now) {
+			due = atClock(day.AddDate(0, 0, 7), clock)
+		}
+		return due, rest, nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", tokens[0], now.Location()); err == nil {
+		clock, rest := optionalClock(rest, defaultTime)
+		return atClock(date, clock), rest, nil
+	}
+	return time.Time{}, nil, fmt.Errorf("%q is not a time, %s", tokens[0], usage)
+}
+
+// parseDuration parses a duration such as 2h, 1h30m or "3 days" at the
+// start of tokens and returns the number of tokens it took, 0 if none
+func parseDuration(tokens []string) (time.Duration, int) {
+	token := strings.ToLower(tokens[0])
+	if n, err := strconv.Atoi(token); err == nil && len(tokens) > 1 {
+		if unit, ok := durationUnits[strings.ToLower(tokens[1])]; ok {
+			return time.Duration(n) * unit, 2
+		}
+		return 0, 0
+	}
+
+	// Compact forms, possibly combined: 2h, 1h30m, 1d12h
+	if !compactDurationRegex.MatchString(token) {
+		return 0, 0
+	}
+	var total time.Duration
+	for _, part := range durationPartRegex.FindAllStringSubmatch(token, -1) {
+		unit, ok := durationUnits[part[2]]
+		if !ok {
+			return 0, 0
+		}
+		n, _ := strconv.Atoi(part[1])
+		total += time.Duration(n) * unit
+	}
+	return total, 1
+}
+
+// parseClock parses a time of day such as 9:00, 17:30, 9am or "5:30 pm" at
+// the start of tokens and returns it with the number of tokens it took
+func parseClock(tokens []string) (time.Duration, int, bool) {
+	token := strings.ToLower(tokens[0])
+	n := 1
+	if len(tokens) > 1 {
+		if suffix := strings.ToLower(tokens[1]); suffix == "am" || suffix == "pm" {
+			token += suffix
+			n = 2
+		}
+	}
+	match := clockRegex.FindStringSubmatch(token)
+	if match == nil {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(match[1])
+	minute := 0
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+	switch match[3] {
+	case "":
+		// A bare number is only a time with minutes, 9:00 rather than 9
+		if match[2] == "" {
+			return 0, 0, false
+		}
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if match[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, n, true
+}
+
+// optionalClock parses an optional "[at] time" at the start of tokens,
+// falling back to defaultTime
+func optionalClock(tokens []string, defaultTime time.Duration) (time.Duration, []string) {
+	rest := tokens
+	if len(rest) > 1 && strings.EqualFold(rest[0], "at") {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		if clock, n, ok := parseClock(rest); ok {
+			return clock, rest[n:]
+		}
+	}
+	return defaultTime, tokens
+}
+
+// atClock returns the time of day clock on the date of day
+func atClock(day time.Time, clock time.Duration) time.Time {
+	hour, minute := int(clock/time.Hour), int(clock%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
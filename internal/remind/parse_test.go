@@ -0,0 +1,72 @@
+package remind
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// Wednesday afternoon
+	now := time.Date(2026, time.October, 14, 15, 20, 0, 0, time.UTC)
+	nine := 9 * time.Hour
+
+	tests := []struct {
+		args   string
+		target string
+		due    time.Time
+		text   string
+	}{
+		{"me in 2h to rotate the key", "me", now.Add(2 * time.Hour), "rotate the key"},
+		{"me in 1h30m check the deploy", "me", now.Add(90 * time.Minute), "check the deploy"},
+		{"me in 3 days 2 hours renew", "me", now.Add(74 * time.Hour), "renew"},
+		{"@alice:example.com tomorrow 9:30 standup notes", "@alice:example.com", time.Date(2026, time.October, 15, 9, 30, 0, 0, time.UTC), "standup notes"},
+		{"me tomorrow to water the plants", "me", time.Date(2026, time.October, 15, 9, 0, 0, 0, time.UTC), "water the plants"},
+		{"me tomorrow at 5pm go home", "me", time.Date(2026, time.October, 15, 17, 0, 0, 0, time.UTC), "go home"},
+		{"me at 17:30 leave", "me", time.Date(2026, time.October, 14, 17, 30, 0, 0, time.UTC), "leave"},
+		{"me at 8:00 coffee", "me", time.Date(2026, time.October, 15, 8, 0, 0, 0, time.UTC), "coffee"},
+		{"me at 11 am review", "me", time.Date(2026, time.October, 15, 11, 0, 0, 0, time.UTC), "review"},
+		{"me today 18:00 call", "me", time.Date(2026, time.October, 14, 18, 0, 0, 0, time.UTC), "call"},
+		{"me friday 2pm demo", "me", time.Date(2026, time.October, 16, 14, 0, 0, 0, time.UTC), "demo"},
+		{"me on wednesday retro", "me", time.Date(2026, time.October, 21, 9, 0, 0, 0, time.UTC), "retro"},
+		{"me wed 16:00 retro", "me", time.Date(2026, time.October, 14, 16, 0, 0, 0, time.UTC), "retro"},
+		{"me on 2026-12-24 at 18:00 call home", "me", time.Date(2026, time.December, 24, 18, 0, 0, 0, time.UTC), "call home"},
+		{"me 2026-12-24 presents", "me", time.Date(2026, time.December, 24, 9, 0, 0, 0, time.UTC), "presents"},
+		// A number without a unit is part of the text
+		{"me tomorrow 9 slides", "me", time.Date(2026, time.October, 15, 9, 0, 0, 0, time.UTC), "9 slides"},
+	}
+	for _, tt := range tests {
+		req, err := Parse(tt.args, now, nine)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.args, err)
+			continue
+		}
+		if req.Target != tt.target || !req.Due.Equal(tt.due) || req.Text != tt.text {
+			t.Errorf("Parse(%q) = %q, %v, %q; want %q, %v, %q", tt.args, req.Target, req.Due, req.Text, tt.target, tt.due, tt.text)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 15, 20, 0, 0, time.UTC)
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"me in 2h", "expected e.g."},
+		{"alice in 2h lunch", "neither me nor a user ID"},
+		{"me in soon lunch", "expected a duration"},
+		{"me in 2h to", "missing"},
+		{"me at noon lunch", "not a time of day"},
+		{"me at 25:00 lunch", "not a time of day"},
+		{"me today 10:00 lunch", "already passed"},
+		{"me on 2020-01-01 lunch", "already passed"},
+		{"me later lunch", "is not a time"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.args, now, 9*time.Hour)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", tt.args, err, tt.want)
+		}
+	}
+}
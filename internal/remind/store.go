@@ -0,0 +1,276 @@
+package remind
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+const (
+	// How often the store looks for reminders due
+	defaultTick = time.Second
+	// Key of the pending reminders in storage
+	storageKey = "reminders"
+)
+
+// ErrTooMany is returned by Add when the user has reminders.max_per_user
+// pending reminders
+var ErrTooMany = errors.New("too many pending reminders")
+
+// Reminder is a reminder set with /remind
+type Reminder struct {
+	ID         int       `json:"id"`
+	RoomID     string    `json:"room_id"`
+	ThreadRoot string    `json:"thread_root,omitempty"` // Thread the reminder was set in
+	EventID    string    `json:"event_id,omitempty"`    // Message that set the reminder
+	SetBy      string    `json:"set_by"`
+	Target     string    `json:"target"` // User to remind
+	Text       string    `json:"text"`
+	Due        time.Time `json:"due"`
+	SetAt      time.Time `json:"set_at"`
+}
+
+// FireFunc posts a reminder that is due
+type FireFunc func(r Reminder)
+
+// Store keeps the pending reminders in storage and fires them when they
+// are due. Reminders that fell due while the service was down fire right
+// after it starts.
+type Store struct {
+	store       storage.Store
+	maxPerUser  int
+	location    *time.Location
+	defaultTime time.Duration
+	fire        FireFunc
+	logger      *logger.Logger
+	tick        time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex // Guards the fields below and the stored state
+	reminders map[int]Reminder
+	nextID    int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// state is what is kept in storage
+type state struct {
+	NextID    int        `json:"next_id"`
+	Reminders []Reminder `json:"reminders"`
+}
+
+// NewStore loads the pending reminders from storage
+func NewStore(cfg *config.RemindersConfig, store storage.Store, fire FireFunc, log *logger.Logger) (*Store, error) {
+	location := time.Local
+	if cfg.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid reminders.timezone: %w", err)
+		}
+	}
+	defaultTime, err := time.Parse("15:04", cfg.DefaultTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reminders.default_time: %w", err)
+	}
+
+	s := &Store{
+		store:       store,
+		maxPerUser:  cfg.MaxPerUser,
+		location:    location,
+		defaultTime: time.Duration(defaultTime.Hour())*time.Hour + time.Duration(defaultTime.Minute())*time.Minute,
+		fire:        fire,
+		logger:      log,
+		tick:        defaultTick,
+		now:         time.Now,
+		reminders:   make(map[int]Reminder),
+		nextID:      1,
+	}
+
+	data, err := storage.Load(context.Background(), store, storageKey, cfg.StateFile)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to read reminders: %w", err)
+	case data != nil:
+		var stored state
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("invalid stored reminders: %w", err)
+		}
+		for _, r := range stored.Reminders {
+			s.reminders[r.ID] = r
+			if r.ID >= s.nextID {
+				s.nextID = r.ID + 1
+			}
+		}
+		if stored.NextID > s.nextID {
+			s.nextID = stored.NextID
+		}
+	}
+	log.Info("Loaded %d pending reminders", len(s.reminders))
+	return s, nil
+}
+
+// Parse parses the arguments of /remind in the time zone of the reminders
+func (s *Store) Parse(args string) (Request, error) {
+	return Parse(args, s.now().In(s.location), s.defaultTime)
+}
+
+// Location returns the time zone reminders are set and shown in
+func (s *Store) Location() *time.Location {
+	return s.location
+}
+
+// Start fires the reminders in the background until Stop
+func (s *Store) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+		for {
+			s.fireDue()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops firing reminders
+func (s *Store) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// fireDue removes the reminders that are due and fires them, oldest first
+func (s *Store) fireDue() {
+	now := s.now()
+	s.mu.Lock()
+	var due []Reminder
+	for id, r := range s.reminders {
+		if !r.Due.After(now) {
+			due = append(due, r)
+			delete(s.reminders, id)
+		}
+	}
+	if len(due) > 0 {
+		if err := s.save(); err != nil {
+			s.logger.Error("%v", err)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+	for _, r := range due {
+		s.logger.Info("Firing reminder %d for %s in %s", r.ID, r.Target, r.RoomID)
+		s.fire(r)
+	}
+}
+
+// Add stores a reminder and returns it with its ID
+func (s *Store) Add(r Reminder) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxPerUser > 0 {
+		pending := 0
+		for _, existing := range s.reminders {
+			if existing.SetBy == r.SetBy {
+				pending++
+			}
+		}
+		if pending >= s.maxPerUser {
+			return Reminder{}, ErrTooMany
+		}
+	}
+
+	r.ID = s.nextID
+	r.SetAt = s.now().UTC()
+	s.reminders[r.ID] = r
+	s.nextID++
+	if err := s.save(); err != nil {
+		delete(s.reminders, r.ID)
+		s.nextID--
+		return Reminder{}, err
+	}
+	s.logger.Info("%s set reminder %d for %s at %s", r.SetBy, r.ID, r.Target, r.Due.Format(time.RFC3339))
+	return r, nil
+}
+
+// Get returns a pending reminder
+func (s *Store) Get(id int) (Reminder, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	return r, ok
+}
+
+// Cancel removes a pending reminder. It reports whether the reminder was
+// pending.
+func (s *Store) Cancel(id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.reminders, id)
+	if err := s.save(); err != nil {
+		s.reminders[id] = r
+		return true, err
+	}
+	s.logger.Info("Cancelled reminder %d", id)
+	return true, nil
+}
+
+// List returns the pending reminders of a room set by or for a user,
+// soonest first
+func (s *Store) List(roomID, user string) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Reminder
+	for _, r := range s.reminders {
+		if r.RoomID == roomID && (r.SetBy == user || r.Target == user) {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Due.Equal(list[j].Due) {
+			return list[i].Due.Before(list[j].Due)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// save stores the pending reminders. Called with mu held.
+func (s *Store) save() error {
+	stored := state{NextID: s.nextID, Reminders: make([]Reminder, 0, len(s.reminders))}
+	for _, r := range s.reminders {
+		stored.Reminders = append(stored.Reminders, r)
+	}
+	sort.Slice(stored.Reminders, func(i, j int) bool { return stored.Reminders[i].ID < stored.Reminders[j].ID })
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(context.Background(), storageKey, data); err != nil {
+		return fmt.Errorf("failed to save reminders: %w", err)
+	}
+	return nil
+}
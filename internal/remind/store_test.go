@@ -0,0 +1,106 @@
+package remind
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func newTestStore(t *testing.T, cfg *config.RemindersConfig, store storage.Store, now *time.Time, fired *[]Reminder) *Store {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s, err := NewStore(cfg, store, func(r Reminder) { *fired = append(*fired, r) }, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestStore(t *testing.T) {
+	cfg := &config.RemindersConfig{TimeZone: "UTC", DefaultTime: "09:00", MaxPerUser: 2}
+	now := time.Date(2026, time.October, 14, 15, 0, 0, 0, time.UTC)
+	var fired []Reminder
+	store := storage.NewMemory()
+	s := newTestStore(t, cfg, store, &now, &fired)
+
+	later, err := s.Add(Reminder{RoomID: "!a:example.com", SetBy: "@bob:example.com", Target: "@bob:example.com", Text: "later", Due: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	soon, err := s.Add(Reminder{RoomID: "!a:example.com", SetBy: "@bob:example.com", Target: "@alice:example.com", Text: "soon", Due: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if later.ID != 1 || soon.ID != 2 {
+		t.Errorf("IDs = %d, %d, want 1, 2", later.ID, soon.ID)
+	}
+	if _, err := s.Add(Reminder{RoomID: "!a:example.com", SetBy: "@bob:example.com", Target: "@bob:example.com", Text: "third", Due: now.Add(time.Hour)}); !errors.Is(err, ErrTooMany) {
+		t.Errorf("third reminder error = %v, want ErrTooMany", err)
+	}
+
+	if list := s.List("!a:example.com", "@alice:example.com"); len(list) != 1 || list[0].ID != soon.ID {
+		t.Errorf("reminders of alice = %v, want only %d", list, soon.ID)
+	}
+	if list := s.List("!a:example.com", "@bob:example.com"); len(list) != 2 || list[0].ID != soon.ID {
+		t.Errorf("reminders of bob = %v, want soonest first", list)
+	}
+	if list := s.List("!b:example.com", "@bob:example.com"); len(list) != 0 {
+		t.Errorf("reminders in another room = %v, want none", list)
+	}
+
+	now = now.Add(90 * time.Minute)
+	s.fireDue()
+	if len(fired) != 1 || fired[0].ID != soon.ID {
+		t.Fatalf("fired = %v, want %d", fired, soon.ID)
+	}
+
+	// Pending reminders survive a restart, and fire if due meanwhile
+	fired = nil
+	restarted := newTestStore(t, cfg, store, &now, &fired)
+	if _, ok := restarted.Get(soon.ID); ok {
+		t.Error("fired reminder still pending after restart")
+	}
+	if _, ok := restarted.Get(later.ID); !ok {
+		t.Fatal("pending reminder lost on restart")
+	}
+	next, err := restarted.Add(Reminder{RoomID: "!a:example.com", SetBy: "@carol:example.com", Target: "@carol:example.com", Text: "next", Due: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 3 {
+		t.Errorf("ID after restart = %d, want 3", next.ID)
+	}
+	now = now.Add(3 * time.Hour)
+	restarted.fireDue()
+	if len(fired) != 2 || fired[0].ID != later.ID || fired[1].ID != next.ID {
+		t.Errorf("fired = %v, want %d then %d", fired, later.ID, next.ID)
+	}
+}
+
+func TestStoreCancel(t *testing.T) {
+	cfg := &config.RemindersConfig{DefaultTime: "09:00"}
+	now := time.Date(2026, time.October, 14, 15, 0, 0, 0, time.UTC)
+	var fired []Reminder
+	store := storage.NewMemory()
+	s := newTestStore(t, cfg, store, &now, &fired)
+
+	r, err := s.Add(Reminder{RoomID: "!a:example.com", SetBy: "@bob:example.com", Target: "@bob:example.com", Text: "x", Due: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled, err := s.Cancel(r.ID); !cancelled || err != nil {
+		t.Fatalf("Cancel = %v, %v", cancelled, err)
+	}
+	if cancelled, _ := s.Cancel(r.ID); cancelled {
+		t.Error("cancelled a reminder twice")
+	}
+	now = now.Add(time.Hour)
+	s.fireDue()
+	if len(fired) != 0 {
+		t.Errorf("cancelled reminder fired: %v", fired)
+	}
+}
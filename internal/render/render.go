@@ -0,0 +1,268 @@
+// Package render turns tabular and structured command output into Markdown
+// tables and code blocks, which the Matrix client sends as HTML
+package render
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Render hints, per command in webhook.render
+const (
+	HintNone  = "none"  // Output unchanged
+	HintAuto  = "auto"  // Tables for tabular output, code blocks for JSON
+	HintTable = "table" // Tables for CSV, TSV and JSON arrays
+	HintCode  = "code"  // Code block; code:<language> names the language
+)
+
+// Rows rendered at most; longer tables end with the number left out
+const maxTableRows = 200
+
+// ValidHint reports whether hint is a render hint
+func ValidHint(hint string) bool {
+	switch hint {
+	case "", HintNone, HintAuto, HintTable, HintCode:
+		return true
+	}
+	language, found := strings.CutPrefix(hint, HintCode+":")
+	return found && language != "" && !strings.ContainsAny(language, " \t\n`")
+}
+
+// Render renders output as the hint says. Output that does not fit the hint
+// is returned unchanged.
+func Render(output, hint string) string {
+	switch {
+	case hint == HintAuto:
+		if rows := Table(output, true); rows != nil {
+			return MarkdownTable(rows)
+		}
+		if language := structuredLanguage(output); language != "" {
+			return CodeBlock(output, language)
+		}
+	case hint == HintTable:
+		if rows := Table(output, false); rows != nil {
+			return MarkdownTable(rows)
+		}
+	case hint == HintCode:
+		return CodeBlock(output, structuredLanguage(output))
+	case strings.HasPrefix(hint, HintCode+":"):
+		return CodeBlock(output, strings.TrimPrefix(hint, HintCode+":"))
+	}
+	return output
+}
+
+// Table returns the rows of a JSON array of objects or arrays, or of TSV or
+// CSV output, the header first; nil if the output is none of them. Strict
+// detection rejects CSV with spaces after the commas, which is more likely
+// prose than data.
+func Table(output string, strict bool) [][]string {
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "[") {
+		return jsonTable(output)
+	}
+	firstLine, _, _ := strings.Cut(output, "\n")
+	if strings.Contains(firstLine, "\t") {
+		return delimitedTable(output, '\t', false)
+	}
+	if strings.Contains(firstLine, ",") {
+		return delimitedTable(output, ',', strict)
+	}
+	return nil
+}
+
+// delimitedTable parses CSV or TSV with at least a header and a row, all of
+// the same number of columns, at least two
+func delimitedTable(output string, delimiter rune, strict bool) [][]string {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.Comma = delimiter
+	if delimiter == '\t' {
+		reader.LazyQuotes = true
+	}
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) < 2 || len(rows[0]) < 2 {
+		return nil
+	}
+	if strict {
+		for _, row := range rows {
+			for _, field := range row[1:] {
+				if strings.HasPrefix(field, " ") {
+					return nil
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// jsonTable returns the rows of a JSON array of objects, with their keys in
+// the order they first appear as the header, or of a JSON array of arrays,
+// the first of them the header
+func jsonTable(output string) [][]string {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(output), &items); err != nil || len(items) == 0 {
+		return nil
+	}
+
+	switch strings.TrimSpace(string(items[0]))[0] {
+	case '{':
+		var header []string
+		index := make(map[string]int)
+		var objects []map[string]json.RawMessage
+		for _, item := range items {
+			keys, values, err := objectKeys(item)
+			if err != nil {
+				return nil
+			}
+			for _, key := range keys {
+				if _, seen := index[key]; !seen {
+					index[key] = len(header)
+					header = append(header, key)
+				}
+			}
+			objects = append(objects, values)
+		}
+		rows := [][]string{header}
+		for _, object := range objects {
+			row := make([]string, len(header))
+			for key, value := range object {
+				row[index[key]] = cell(value)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+
+	case '[':
+		var rows [][]string
+		for _, item := range items {
+			var values []json.RawMessage
+			if err := json.Unmarshal(item, &values); err != nil {
+				return nil
+			}
+			row := make([]string, len(values))
+			for i, value := range values {
+				row[i] = cell(value)
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) < 2 {
+			return nil
+		}
+		return rows
+	}
+	return nil
+}
+
+// objectKeys decodes a JSON object, returning its keys in order
+func objectKeys(data json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not an object")
+	}
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+// cell renders a JSON value as table cell text: strings as they are, null
+// empty and other values as JSON
+func cell(value json.RawMessage) string {
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		return text
+	}
+	if string(value) == "null" {
+		return ""
+	}
+	return string(value)
+}
+
+// MarkdownTable renders rows, the header first, as a Markdown table
+func MarkdownTable(rows [][]string) string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := 0; i < columns; i++ {
+			value := ""
+			if i < len(row) {
+				value = tableCell(row[i])
+			}
+			b.WriteString(" " + value + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+	body := rows[1:]
+	omitted := 0
+	if len(body) > maxTableRows {
+		omitted = len(body) - maxTableRows
+		body = body[:maxTableRows]
+	}
+	for _, row := range body {
+		writeRow(row)
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&b, "\n… %d more rows\n", omitted)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tableCell escapes the characters that would break a table row
+func tableCell(value string) string {
+	value = strings.ReplaceAll(strings.TrimSpace(value), "\r\n", " ")
+	value = strings.ReplaceAll(value, "\n", " ")
+	return strings.ReplaceAll(value, "|", `\|`)
+}
+
+// structuredLanguage returns json for output that is a JSON object or array,
+// else empty
+func structuredLanguage(output string) string {
+	trimmed := strings.TrimSpace(output)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	return ""
+}
+
+// CodeBlock renders output as a fenced code block of a language, which
+// Matrix clients highlight. JSON is indented.
+func CodeBlock(output, language string) string {
+	output = strings.Trim(output, "\n")
+	if language == "json" {
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(strings.TrimSpace(output)), "", "  ") == nil {
+			output = indented.String()
+		}
+	}
+	// The fence must be longer than any run of backticks in the output
+	fence := "```"
+	for strings.Contains(output, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + output + "\n" + fence
+}
@@ -0,0 +1,87 @@
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		strict   bool
+		expected [][]string
+	}{
+		{"CSV", "name,status\napi,up\ndb,down\n", true, [][]string{{"name", "status"}, {"api", "up"}, {"db", "down"}}},
+		{"Quoted CSV", "name,note\napi,\"slow, but up\"", true, [][]string{{"name", "note"}, {"api", "slow, but up"}}},
+		{"TSV", "name\tstatus\napi\tup", true, [][]string{{"name", "status"}, {"api", "up"}}},
+		{"JSON objects", `[{"name": "api", "up": true}, {"name": "db", "region": "eu", "up": null}]`, true,
+			[][]string{{"name", "up", "region"}, {"api", "true", ""}, {"db", "", "eu"}}},
+		{"JSON arrays", `[["name", "cpu"], ["api", 0.5]]`, true, [][]string{{"name", "cpu"}, {"api", "0.5"}}},
+		{"Prose", "Hello, world\nFine, thanks", true, nil},
+		{"Prose not strict", "Hello, world\nFine, thanks", false, [][]string{{"Hello", " world"}, {"Fine", " thanks"}}},
+		{"Ragged CSV", "a,b\n1,2,3", true, nil},
+		{"One line", "a,b", true, nil},
+		{"JSON strings", `["a", "b"]`, true, nil},
+		{"Plain", "All good", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Table(tt.output, tt.strict); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Table() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMarkdownTable(t *testing.T) {
+	got := MarkdownTable([][]string{{"name", "note"}, {"api", "a|b\nc"}, {"db"}})
+	expected := "| name | note |\n| --- | --- |\n| api | a\\|b c |\n| db |  |"
+	if got != expected {
+		t.Errorf("MarkdownTable() = %q, want %q", got, expected)
+	}
+
+	rows := [][]string{{"n"}}
+	for i := 0; i < maxTableRows+5; i++ {
+		rows = append(rows, []string{"x"})
+	}
+	if got := MarkdownTable(rows); !strings.HasSuffix(got, "\n… 5 more rows") || strings.Count(got, "| x |") != maxTableRows {
+		t.Errorf("MarkdownTable() of %d rows ends with %q", len(rows), got[len(got)-40:])
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		hint     string
+		expected string
+	}{
+		{"None", "a,b\n1,2", HintNone, "a,b\n1,2"},
+		{"Unset", "a,b\n1,2", "", "a,b\n1,2"},
+		{"Auto table", "a,b\n1,2", HintAuto, "| a | b |\n| --- | --- |\n| 1 | 2 |"},
+		{"Auto JSON", `{"ok":true}`, HintAuto, "```json\n{\n  \"ok\": true\n}\n```"},
+		{"Auto text", "All good", HintAuto, "All good"},
+		{"Table of prose", "Hello, world\nFine, thanks", HintTable, "| Hello | world |\n| --- | --- |\n| Fine | thanks |"},
+		{"Table of text", "All good", HintTable, "All good"},
+		{"Code", "All good\n", HintCode, "```\nAll good\n```"},
+		{"Code language", "package main", "code:go", "```go\npackage main\n```"},
+		{"Code with fence", "```\nx\n```", HintCode, "````\n```\nx\n```\n````"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.output, tt.hint); got != tt.expected {
+				t.Errorf("Render() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidHint(t *testing.T) {
+	for hint, expected := range map[string]bool{"": true, "auto": true, "table": true, "code": true, "code:python": true, "code:": false, "code:a b": false, "html": false} {
+		if got := ValidHint(hint); got != expected {
+			t.Errorf("ValidHint(%q) = %v, want %v", hint, got, expected)
+		}
+	}
+}
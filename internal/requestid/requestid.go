@@ -0,0 +1,50 @@
+// Package requestid carries the ID of a user action, an HTTP request or a
+// Matrix message, through the webhook dispatches and Matrix sends it causes.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the HTTP header carrying request IDs, both on incoming API
+// requests and outgoing webhook dispatches
+const Header = "X-Request-ID"
+
+// maxLength bounds accepted request IDs so callers cannot bloat log lines
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a random request ID
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether a request ID supplied by a caller is safe to log and
+// forward: up to 128 printable ASCII characters without spaces
+func Valid(requestID string) bool {
+	if requestID == "" || len(requestID) > maxLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the request ID carried by ctx, or ""
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
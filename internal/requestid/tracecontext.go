@@ -0,0 +1,95 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Headers of the W3C Trace Context and Baggage recommendations, carried by
+// outgoing webhook dispatches so that the logs and traces of the backend can
+// be joined with those of the bot
+const (
+	TraceparentHeader = "traceparent"
+	BaggageHeader     = "baggage"
+)
+
+// maxBaggageLength bounds the baggage passed on, as recommended by W3C
+const maxBaggageLength = 8192
+
+type traceContextKey struct{}
+
+// TraceContext is the W3C trace context of a user action
+type TraceContext struct {
+	TraceID string // 32 lowercase hex characters
+	SpanID  string // 16 lowercase hex characters, the span of the caller
+	Flags   string // 2 hex characters, 01 if the trace is sampled
+	Baggage string // The baggage header, passed on unchanged
+}
+
+// ParseTraceparent parses a traceparent header of version 00. Headers of
+// later versions are accepted as far as version 00 goes.
+func ParseTraceparent(value string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, false
+	}
+	trace := TraceContext{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}
+	if !isHex(parts[0]) || !isHex(trace.TraceID) || len(trace.TraceID) != 32 || allZero(trace.TraceID) ||
+		!isHex(trace.SpanID) || len(trace.SpanID) != 16 || allZero(trace.SpanID) ||
+		!isHex(trace.Flags) || len(trace.Flags) != 2 {
+		return TraceContext{}, false
+	}
+	return trace, true
+}
+
+// NewTraceContext starts a sampled trace. Its trace ID is the request ID if
+// that is one, as those of New are, so that either finds the other.
+func NewTraceContext(requestID string) TraceContext {
+	traceID := requestID
+	if len(traceID) != 32 || !isHex(traceID) || allZero(traceID) {
+		traceID = randomHex(16)
+	}
+	return TraceContext{TraceID: traceID, SpanID: randomHex(8), Flags: "01"}
+}
+
+// Child returns the traceparent header of a call made within the trace,
+// with a new span ID
+func (t TraceContext) Child() string {
+	return "00-" + t.TraceID + "-" + randomHex(8) + "-" + t.Flags
+}
+
+// WithTrace returns a copy of ctx carrying the trace context
+func WithTrace(ctx context.Context, trace TraceContext) context.Context {
+	if len(trace.Baggage) > maxBaggageLength {
+		trace.Baggage = ""
+	}
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// TraceFromContext returns the trace context carried by ctx
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+// isHex reports whether s consists of lowercase hex digits
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func allZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
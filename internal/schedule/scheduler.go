@@ -0,0 +1,214 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+// Key of the state in storage
+const storageKey = "schedule"
+
+// RunFunc runs a job at the time it was scheduled for
+type RunFunc func(ctx context.Context, job config.ScheduledJobConfig, scheduled time.Time)
+
+// Scheduler runs the jobs of the config file on their schedules. The time
+// each job last ran is kept in storage, so that a run missed while the
+// service was down is made up at startup if it is recent enough.
+type Scheduler struct {
+	store    storage.Store
+	catchUp  time.Duration
+	location *time.Location
+	run      RunFunc
+	logger   *logger.Logger
+	now      func() time.Time
+
+	jobs []*job
+
+	mu       sync.Mutex // Guards lastRuns and the stored state
+	lastRuns map[string]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type job struct {
+	config   config.ScheduledJobConfig
+	schedule *Schedule
+}
+
+// state is what is kept in storage
+type state struct {
+	LastRuns map[string]time.Time `json:"last_runs"`
+}
+
+// New parses the schedules of the jobs and loads the stored state
+func New(cfg *config.ScheduleConfig, store storage.Store, run RunFunc, log *logger.Logger) (*Scheduler, error) {
+	location := time.Local
+	if cfg.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid schedule.timezone: %w", err)
+		}
+	}
+
+	s := &Scheduler{
+		store:    store,
+		catchUp:  time.Duration(cfg.CatchUp) * time.Second,
+		location: location,
+		run:      run,
+		logger:   log,
+		now:      time.Now,
+		lastRuns: make(map[string]time.Time),
+	}
+	for _, jobConfig := range cfg.Jobs {
+		schedule, err := Parse(jobConfig.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule job %s: %w", jobConfig.Name, err)
+		}
+		s.jobs = append(s.jobs, &job{config: jobConfig, schedule: schedule})
+	}
+
+	data, err := storage.Load(context.Background(), store, storageKey, cfg.StateFile)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to read schedule state: %w", err)
+	case data != nil:
+		var stored state
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("invalid schedule state: %w", err)
+		}
+		if stored.LastRuns != nil {
+			s.lastRuns = stored.LastRuns
+		}
+	}
+	return s, nil
+}
+
+// Start runs the jobs in the background until Stop, beginning with the runs
+// missed within schedule.catch_up
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		s.catchUpMissed(ctx)
+		for {
+			next, due := s.nextRun()
+			if due == nil {
+				s.logger.Info("No scheduled jobs left to run")
+				return
+			}
+			timer := time.NewTimer(next.Sub(s.now()))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			for _, j := range due {
+				s.runJob(ctx, j, next)
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler and waits for a running job
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// catchUpMissed runs once each job whose last scheduled time passed while
+// the service was down, if that time is within the catch-up window. Jobs
+// that never ran are not caught up.
+func (s *Scheduler) catchUpMissed(ctx context.Context) {
+	now := s.now().In(s.location)
+	for _, j := range s.jobs {
+		s.mu.Lock()
+		lastRun, ran := s.lastRuns[j.config.Name]
+		s.mu.Unlock()
+		if !ran {
+			s.recordRun(j.config.Name, now)
+			continue
+		}
+		missed := s.lastScheduled(j, lastRun.In(s.location), now)
+		if missed.IsZero() {
+			continue
+		}
+		if now.Sub(missed) > s.catchUp {
+			s.logger.Info("Skipping the run of job %s missed at %s", j.config.Name, missed.Format(time.RFC3339))
+			s.recordRun(j.config.Name, now)
+			continue
+		}
+		s.logger.Info("Catching up on the run of job %s missed at %s", j.config.Name, missed.Format(time.RFC3339))
+		s.runJob(ctx, j, missed)
+	}
+}
+
+// lastScheduled returns the last time after since and up to now the job was
+// scheduled for, or the zero time
+func (s *Scheduler) lastScheduled(j *job, since, now time.Time) time.Time {
+	var last time.Time
+	for t := j.schedule.Next(since); !t.IsZero() && !t.After(now); t = j.schedule.Next(t) {
+		last = t
+	}
+	return last
+}
+
+// nextRun returns the next time a job is due and the jobs due then
+func (s *Scheduler) nextRun() (time.Time, []*job) {
+	now := s.now().In(s.location)
+	var next time.Time
+	var due []*job
+	for _, j := range s.jobs {
+		t := j.schedule.Next(now)
+		switch {
+		case t.IsZero():
+		case next.IsZero() || t.Before(next):
+			next, due = t, []*job{j}
+		case t.Equal(next):
+			due = append(due, j)
+		}
+	}
+	return next, due
+}
+
+func (s *Scheduler) runJob(ctx context.Context, j *job, scheduled time.Time) {
+	s.logger.Info("Running scheduled job %s", j.config.Name)
+	s.run(ctx, j.config, scheduled)
+	s.recordRun(j.config.Name, scheduled)
+}
+
+// recordRun saves the time a job ran (or was skipped)
+func (s *Scheduler) recordRun(name string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRuns[name] = at
+	if err := s.save(); err != nil {
+		s.logger.Error("%v", err)
+	}
+}
+
+// save stores the state. Called with mu held.
+func (s *Scheduler) save() error {
+	data, err := json.Marshal(state{LastRuns: s.lastRuns})
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(context.Background(), storageKey, data); err != nil {
+		return fmt.Errorf("failed to save schedule state: %w", err)
+	}
+	return nil
+}
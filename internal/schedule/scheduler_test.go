@@ -0,0 +1,134 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func newTestScheduler(t *testing.T, cfg *config.ScheduleConfig, store storage.Store, now time.Time, runs *[]string) *Scheduler {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	run := func(ctx context.Context, job config.ScheduledJobConfig, scheduled time.Time) {
+		*runs = append(*runs, job.Name+"@"+scheduled.Format("15:04"))
+	}
+	s, err := New(cfg, store, run, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func writeState(t *testing.T, path string, lastRuns map[string]time.Time) {
+	data, err := json.Marshal(state{LastRuns: lastRuns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatchUpMissed(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "schedule.json")
+	cfg := &config.ScheduleConfig{
+		StateFile: statePath,
+		TimeZone:  "UTC",
+		CatchUp:   3600,
+		Jobs: []config.ScheduledJobConfig{
+			{Name: "recent", Cron: "0 9 * * *", Message: "m"},
+			{Name: "old", Cron: "0 7 * * *", Message: "m"},
+			{Name: "new", Cron: "0 8 * * *", Message: "m"},
+			{Name: "current", Cron: "0 6 * * *", Message: "m"},
+		},
+	}
+	now := time.Date(2024, time.May, 15, 9, 30, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	writeState(t, statePath, map[string]time.Time{
+		"recent":  yesterday,
+		"old":     yesterday,
+		"current": now.Add(-time.Hour),
+	})
+
+	// The state file of earlier versions is imported
+	var runs []string
+	store := storage.NewMemory()
+	s := newTestScheduler(t, cfg, store, now, &runs)
+	s.catchUpMissed(context.Background())
+
+	if len(runs) != 1 || runs[0] != "recent@09:00" {
+		t.Errorf("runs = %v, want only the missed run of recent", runs)
+	}
+
+	data, err := store.Get(context.Background(), storageKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{
+		"recent":  time.Date(2024, time.May, 15, 9, 0, 0, 0, time.UTC),
+		"old":     now,
+		"new":     now,
+		"current": now.Add(-time.Hour),
+	}
+	for name, at := range want {
+		if !saved.LastRuns[name].Equal(at) {
+			t.Errorf("last run of %s = %v, want %v", name, saved.LastRuns[name], at)
+		}
+	}
+
+	// Restarting again runs nothing
+	runs = nil
+	s = newTestScheduler(t, cfg, store, now.Add(time.Minute), &runs)
+	s.catchUpMissed(context.Background())
+	if len(runs) != 0 {
+		t.Errorf("runs after restart = %v, want none", runs)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	cfg := &config.ScheduleConfig{
+		TimeZone: "UTC",
+		Jobs: []config.ScheduledJobConfig{
+			{Name: "a", Cron: "0 10 * * *"},
+			{Name: "b", Cron: "0 10 * * *"},
+			{Name: "c", Cron: "0 11 * * *"},
+		},
+	}
+	var runs []string
+	s := newTestScheduler(t, cfg, storage.NewMemory(), time.Date(2024, time.May, 15, 9, 0, 0, 0, time.UTC), &runs)
+
+	next, due := s.nextRun()
+	if want := time.Date(2024, time.May, 15, 10, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next = %v, want %v", next, want)
+	}
+	if len(due) != 2 || due[0].config.Name != "a" || due[1].config.Name != "b" {
+		t.Errorf("due = %v, want a and b", due)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	dir := t.TempDir()
+
+	if _, err := New(&config.ScheduleConfig{TimeZone: "Nowhere/Special"}, storage.NewMemory(), nil, log); err == nil {
+		t.Error("New accepted an unknown time zone")
+	}
+	badState := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(badState, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&config.ScheduleConfig{StateFile: badState}, storage.NewMemory(), nil, log); err == nil {
+		t.Error("New accepted an invalid state file")
+	}
+}
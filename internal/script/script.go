@@ -0,0 +1,110 @@
+// Package script runs the small Lua snippets of the config that route
+// messages, rewrite webhook payloads and format replies
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Limits of the interpreter a script runs in
+const (
+	callStackSize   = 128
+	registrySize    = 1024
+	registryMaxSize = 64 * 1024
+)
+
+// Globals of the base library removed from the sandbox, since they load
+// code from files or strings
+var unsafeGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// Script is a compiled Lua snippet. Every run gets a fresh interpreter with
+// only the base, string, table and math libraries: scripts cannot reach
+// files, the network, the OS or each other.
+type Script struct {
+	name    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+}
+
+// Compile compiles a script, named after its setting in error messages. An
+// empty source returns nil. Runs are stopped after timeout (0 = no limit).
+func Compile(name, source string, timeout time.Duration) (*Script, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		// Syntax errors start with the script name and position
+		return nil, errors.New(strings.TrimSpace(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &Script{name: name, proto: proto, timeout: timeout}, nil
+}
+
+// Run runs the script with vars as global variables. It returns the string
+// the script returned and true, or false if it returned nothing or nil.
+// Numbers are returned as strings; other values are an error.
+func (s *Script) Run(ctx context.Context, vars map[string]string) (string, bool, error) {
+	L := lua.NewState(lua.Options{
+		CallStackSize:   callStackSize,
+		RegistrySize:    registrySize,
+		RegistryMaxSize: registryMaxSize,
+		SkipOpenLibs:    true,
+	})
+	defer L.Close()
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	for name, value := range vars {
+		L.SetGlobal(name, lua.LString(value))
+	}
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	L.SetContext(ctx)
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return "", false, fmt.Errorf("%s: stopped after %s", s.name, s.timeout)
+		}
+		// Lua errors start with the script name and line
+		return "", false, err
+	}
+
+	switch result := L.Get(-1); result.Type() {
+	case lua.LTNil:
+		return "", false, nil
+	case lua.LTString, lua.LTNumber:
+		return result.String(), true, nil
+	default:
+		return "", false, fmt.Errorf("%s: returned a %s, expected a string or nil", s.name, result.Type())
+	}
+}
@@ -0,0 +1,58 @@
+package script
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		vars    map[string]string
+		want    string
+		ok      bool
+		wantErr string
+	}{
+		{"route", `if message:find("outage") then return "incident" end`, map[string]string{"message": "db outage"}, "incident", true, ""},
+		{"no result", `if message:find("outage") then return "incident" end`, map[string]string{"message": "hello"}, "", false, ""},
+		{"string library", `return string.upper(reply) .. " (" .. #reply .. ")"`, map[string]string{"reply": "done"}, "DONE (4)", true, ""},
+		{"number", `return 6 * 7`, nil, "42", true, ""},
+		{"empty string", `return ""`, nil, "", true, ""},
+		{"wrong type", `return {}`, nil, "", false, "returned a table"},
+		{"runtime error", `error("nope")`, nil, "", false, "nope"},
+		{"no os", `return os.getenv("HOME")`, nil, "", false, "non-table object"},
+		{"no io", `return io.open("/etc/passwd")`, nil, "", false, "non-table object"},
+		{"no loading", `return dofile("/etc/passwd")`, nil, "", false, "non-function object"},
+		{"timeout", `while true do end`, nil, "", false, "stopped after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile("test", tt.source, 50*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := s.Run(context.Background(), tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want || ok != tt.ok {
+				t.Errorf("Run = %q, %v, %v, want %q, %v", got, ok, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	if s, err := Compile("empty", "  \n", time.Second); s != nil || err != nil {
+		t.Errorf("Compile of empty source = %v, %v", s, err)
+	}
+	if _, err := Compile("webhook.route_script", "return (", time.Second); err == nil || !strings.Contains(err.Error(), "webhook.route_script") {
+		t.Errorf("Compile error = %v, want it to name the setting", err)
+	}
+}
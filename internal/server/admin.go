@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"maunium.net/go/mautrix/id"
+)
+
+// AdminResponse is returned by the admin endpoints that perform an action
+type AdminResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReloadResponse is returned by POST /admin/reload
+type ReloadResponse struct {
+	Status string `json:"status"`
+	// Changed settings that only take effect after a restart
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// SessionsResponse is returned by GET /admin/sessions
+type SessionsResponse struct {
+	Sessions []session.SessionInfo `json:"sessions"`
+}
+
+// LogsResponse is returned by GET /admin/logs
+type LogsResponse struct {
+	Entries []logger.Entry `json:"entries"`
+}
+
+// adminRoutes registers the admin API. It is only served when an admin token
+// is configured.
+func (s *Server) adminRoutes(r chi.Router) {
+	r.Use(s.requireAdminToken)
+	r.Post("/reload", s.handleAdminReload)
+	r.Get("/sessions", s.handleAdminListSessions)
+	r.Delete("/sessions/{id}", s.handleAdminKillSession)
+	r.Post("/queue/flush", s.handleAdminFlushQueue)
+	r.Post("/verify", s.handleAdminVerify)
+	r.Post("/keys/rotate", s.handleAdminRotateKeys)
+	r.Post("/pause", s.handleAdminPause)
+	r.Post("/resume", s.handleAdminResume)
+	r.Get("/commands", s.handleAdminListCommands)
+	r.Put("/commands/{name}", s.handleAdminRegisterCommand)
+	r.Delete("/commands/{name}", s.handleAdminUnregisterCommand)
+	r.Get("/logs", s.handleAdminLogs)
+	r.Get("/catchup", s.handleAdminCatchup)
+	r.Post("/catchup", s.handleAdminCatchupDispatch)
+}
+
+// requireAdminToken rejects requests without the configured admin bearer token
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg().Server.AdminToken
+		if token == "" || !hasBearerToken(r, token) {
+			s.logger.Warn("Rejecting admin request %s %s with missing or invalid token", r.Method, r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// restartOnlySettings lists settings that are wired up at startup. A reload
+// keeps their current values.
+var restartOnlySettings = []struct {
+	name  string
+	field func(cfg *config.Config) interface{}
+}{
+	{"matrix", func(cfg *config.Config) interface{} { return &cfg.Matrix }},
+	{"logging", func(cfg *config.Config) interface{} { return &cfg.Logging }},
+	{"audit", func(cfg *config.Config) interface{} { return &cfg.Audit }},
+	{"archive", func(cfg *config.Config) interface{} { return &cfg.Archive }},
+	{"plugins", func(cfg *config.Config) interface{} { return &cfg.Plugins }},
+	{"wasm", func(cfg *config.Config) interface{} { return &cfg.WASM }},
+	{"llm", func(cfg *config.Config) interface{} { return &cfg.LLM }},
+	{"ollama", func(cfg *config.Config) interface{} { return &cfg.Ollama }},
+	{"feeds", func(cfg *config.Config) interface{} { return &cfg.Feeds }},
+	{"schedule", func(cfg *config.Config) interface{} { return &cfg.Schedule }},
+	{"reminders", func(cfg *config.Config) interface{} { return &cfg.Reminders }},
+	{"email.imap", func(cfg *config.Config) interface{} { return &cfg.Email.IMAP }},
+	{"telegram", func(cfg *config.Config) interface{} { return &cfg.Telegram }},
+	{"push", func(cfg *config.Config) interface{} { return &cfg.Push }},
+	{"pagerduty", func(cfg *config.Config) interface{} { return &cfg.PagerDuty }},
+	{"jira", func(cfg *config.Config) interface{} { return &cfg.Jira }},
+	{"homeassistant", func(cfg *config.Config) interface{} { return &cfg.HomeAssistant }},
+	{"translate", func(cfg *config.Config) interface{} { return &cfg.Translate }},
+	{"vision", func(cfg *config.Config) interface{} { return &cfg.Vision }},
+	{"feedback", func(cfg *config.Config) interface{} { return &cfg.Feedback }},
+	{"pagination", func(cfg *config.Config) interface{} { return &cfg.Pagination }},
+	{"memory", func(cfg *config.Config) interface{} { return &cfg.Memory }},
+	{"i18n.catalog_dir", func(cfg *config.Config) interface{} { return &cfg.I18n.CatalogDir }},
+	{"storage", func(cfg *config.Config) interface{} { return &cfg.Storage }},
+	{"dedup.max_entries", func(cfg *config.Config) interface{} { return &cfg.Dedup.MaxEntries }},
+	{"tenants", func(cfg *config.Config) interface{} { return &cfg.Tenants }},
+	{"server.port", func(cfg *config.Config) interface{} { return &cfg.Server.Port }},
+	{"server.enable_debug", func(cfg *config.Config) interface{} { return &cfg.Server.EnableDebug }},
+	{"server.admin_token", func(cfg *config.Config) interface{} { return &cfg.Server.AdminToken }},
+	{"hooks.alertmanager.enabled", func(cfg *config.Config) interface{} { return &cfg.Hooks.Alertmanager.Enabled }},
+	{"hooks.grafana.enabled", func(cfg *config.Config) interface{} { return &cfg.Hooks.Grafana.Enabled }},
+	{"hooks.discord.enabled", func(cfg *config.Config) interface{} { return &cfg.Hooks.Discord.Enabled }},
+	{"hooks.pagerduty.enabled", func(cfg *config.Config) interface{} { return &cfg.Hooks.PagerDuty.Enabled }},
+	{"webhook.command_store", func(cfg *config.Config) interface{} { return &cfg.Webhook.CommandStore }},
+	{"stream.enabled", func(cfg *config.Config) interface{} { return &cfg.Stream.Enabled }},
+	{"stream.history_size", func(cfg *config.Config) interface{} { return &cfg.Stream.HistorySize }},
+}
+
+// keepRestartOnlySettings copies the restart-only settings from current into
+// next and returns the names of those that differ
+func keepRestartOnlySettings(current, next *config.Config) []string {
+	var changed []string
+	for _, setting := range restartOnlySettings {
+		currentValue := reflect.ValueOf(setting.field(current)).Elem()
+		nextValue := reflect.ValueOf(setting.field(next)).Elem()
+		if !reflect.DeepEqual(currentValue.Interface(), nextValue.Interface()) {
+			changed = append(changed, setting.name)
+		}
+		nextValue.Set(currentValue)
+	}
+	return changed
+}
+
+// Reload re-reads the config file and applies it. Settings that need a
+// restart keep their current values and are returned. If the new config is
+// invalid nothing is changed.
+func (s *Server) Reload() ([]string, error) {
+	s.logger.Info("Reloading configuration")
+
+	next, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.logger.AddSecrets(next.SecretValues()...)
+	restartRequired := keepRestartOnlySettings(s.cfg(), next)
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	effective := s.withRegisteredCommands(next)
+	compiled, err := compileConfig(effective)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range compiled.templateWarnings {
+		s.logger.Warn("Webhook template dry run: %s", warning)
+	}
+	// The only session setting that can be rejected, so it goes first. The
+	// session managers of the tenants accept what the global one accepts.
+	if err := s.sessionMgr.SetExecMode(next.Webhook.ExecMode); err != nil {
+		return nil, fmt.Errorf("invalid exec_mode: %w", err)
+	}
+
+	s.sessionMgr.SetDefaultCommand(next.Webhook.DefaultCommand)
+	for _, t := range s.tenants {
+		// Tenants are kept, and so is their default command
+		t.sessions.SetDefaultCommand(t.config.Webhook.DefaultCommand)
+	}
+	for _, sessionMgr := range s.sessionManagers() {
+		sessionMgr.SetExecMode(next.Webhook.ExecMode)
+		sessionMgr.SetQueueDepth(next.Webhook.CommandQueueDepth)
+		sessionMgr.SetMaxSessions(next.Webhook.MaxSessions)
+		sessionMgr.SetSessionTimeout(next.Webhook.SessionTimeout)
+		sessionMgr.SetTranscriptLimit(next.Webhook.Transcript.MaxEntries)
+		sessionMgr.SetAllowlist(compiled.allowlist)
+	}
+	s.webhook.SetConfig(&effective.Webhook)
+
+	s.configMutex.Lock()
+	s.config = effective
+	s.baseConfig = next
+	s.outputPipelines = compiled.outputPipelines
+	s.alertmanagerTemplate = compiled.alertmanagerTemplate
+	s.customHooks = compiled.customHooks
+	s.notifyTemplates = compiled.notifyTemplates
+	s.errorTemplates = compiled.errorTemplates
+	s.lifecycleTemplates = compiled.lifecycleTemplates
+	s.replyWrappers = compiled.replyWrappers
+	s.transforms = compiled.transforms
+	s.ipAllowlists = compiled.ipAllowlists
+	s.trustedProxies = compiled.trustedProxies
+	s.rooms = compiled.rooms
+	s.scripts = compiled.scripts
+	s.feedTemplate = compiled.feedTemplate
+	s.emailTemplates = compiled.emailTemplates
+	s.inboundEmailTemplate = compiled.inboundEmailTemplate
+	s.quietHours = compiled.quietHours
+	s.configMutex.Unlock()
+	if s.matrix != nil {
+		s.matrix.SetRooms(next.Rooms)
+		s.matrix.SetBridges(next.Bridges)
+	}
+
+	if len(restartRequired) > 0 {
+		s.logger.Warn("Configuration reloaded, changes to %v require a restart", restartRequired)
+	} else {
+		s.logger.Info("Configuration reloaded")
+	}
+	go s.reportWebhookReachability("reload")
+	return restartRequired, nil
+}
+
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	restartRequired, err := s.Reload()
+	if err != nil {
+		s.logger.Error("Failed to reload configuration: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to reload configuration: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ReloadResponse{Status: "reloaded", RestartRequired: restartRequired})
+}
+
+func (s *Server) handleAdminListSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SessionsResponse{Sessions: s.listSessions()})
+}
+
+// listSessions returns the sessions of every session manager, those of
+// tenants labeled with the tenant name
+func (s *Server) listSessions() []session.SessionInfo {
+	sessions := s.sessionMgr.ListSessions()
+	for _, t := range s.tenants {
+		for _, info := range t.sessions.ListSessions() {
+			info.Tenant = t.config.Name
+			sessions = append(sessions, info)
+		}
+	}
+	return sessions
+}
+
+// handleAdminKillSession kills a session, one of the tenant named by
+// ?tenant= if given
+func (s *Server) handleAdminKillSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+	sessionMgr := s.sessionMgr
+	if name := r.URL.Query().Get("tenant"); name != "" {
+		sessionMgr = nil
+		for _, t := range s.tenants {
+			if t.config.Name == name {
+				sessionMgr = t.sessions
+			}
+		}
+		if sessionMgr == nil {
+			http.Error(w, "Unknown tenant", http.StatusNotFound)
+			return
+		}
+	}
+	if !sessionMgr.KillSession(sessionID) {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "killed", Message: fmt.Sprintf("Session %s was killed", sessionID)})
+}
+
+func (s *Server) handleAdminFlushQueue(w http.ResponseWriter, r *http.Request) {
+	flushed := 0
+	for _, sessionMgr := range s.sessionManagers() {
+		flushed += sessionMgr.FlushQueues()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "flushed", Message: fmt.Sprintf("Dropped %d queued commands", flushed)})
+}
+
+func (s *Server) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	if err := s.matrix.Reverify(); err != nil {
+		http.Error(w, fmt.Sprintf("Verification failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "verified"})
+}
+
+// handleAdminRotateKeys starts new Megolm sessions in the room of
+// ?room_id=, or in every encrypted room the bot listens in
+func (s *Server) handleAdminRotateKeys(w http.ResponseWriter, r *http.Request) {
+	roomID := id.RoomID(r.URL.Query().Get("room_id"))
+	if roomID != "" && !strings.HasPrefix(string(roomID), "!") {
+		http.Error(w, fmt.Sprintf("Invalid room_id %q", roomID), http.StatusBadRequest)
+		return
+	}
+	rotated, err := s.matrix.RotateKeys(r.Context(), roomID)
+	if errors.Is(err, matrix.ErrEncryptionDisabled) {
+		http.Error(w, "Encryption is disabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to rotate room keys: %v", err)
+		http.Error(w, fmt.Sprintf("Key rotation failed after %d rooms: %v", len(rotated), err), http.StatusInternalServerError)
+		return
+	}
+	s.logger.Warn("Rotated the Megolm sessions of %d rooms via admin API", len(rotated))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "rotated", Message: fmt.Sprintf("Started new sessions in %d encrypted rooms", len(rotated))})
+}
+
+func (s *Server) handleAdminPause(w http.ResponseWriter, r *http.Request) {
+	s.paused.Store(true)
+	s.logger.Warn("Message handling paused via admin API")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "paused", Message: "Incoming Matrix messages are ignored until resumed"})
+}
+
+func (s *Server) handleAdminResume(w http.ResponseWriter, r *http.Request) {
+	s.paused.Store(false)
+	s.logger.Info("Message handling resumed via admin API")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "resumed"})
+}
+
+// handleAdminLogs returns the recent log entries kept in memory, filtered by
+// ?level= (the minimum level), ?component= and ?limit=
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	if !s.logger.BufferEnabled() {
+		http.Error(w, "Log buffer is disabled (logging.buffer_size is 0)", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	minLevel := logger.DEBUG
+	if name := query.Get("level"); name != "" {
+		var ok bool
+		if minLevel, ok = logger.ParseLevel(name); !ok {
+			http.Error(w, fmt.Sprintf("Invalid level %q, expected debug, info, warn or error", name), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries := s.logger.Recent(minLevel, query.Get("component"), limit)
+	if entries == nil {
+		entries = []logger.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LogsResponse{Entries: entries})
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func newAdminTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	sessionMgr := session.NewManager(log, 600, "echo {{.MESSAGE}}", t.TempDir())
+	t.Cleanup(sessionMgr.Stop)
+
+	compiled, err := compileConfig(cfg)
+	if err != nil {
+		t.Fatalf("compileConfig() error = %v", err)
+	}
+	s := &Server{
+		config:               cfg,
+		baseConfig:           cfg,
+		router:               chi.NewRouter(),
+		logger:               log,
+		webhook:              webhook.New(&cfg.Webhook, nil, log),
+		sessionMgr:           sessionMgr,
+		outputPipelines:      compiled.outputPipelines,
+		alertmanagerTemplate: compiled.alertmanagerTemplate,
+		customHooks:          compiled.customHooks,
+		notifyTemplates:      compiled.notifyTemplates,
+		ipAllowlists:         compiled.ipAllowlists,
+		trustedProxies:       compiled.trustedProxies,
+		rooms:                compiled.rooms,
+	}
+	s.routes()
+	return s
+}
+
+func adminRequest(s *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{})
+	if rec := adminRequest(s, http.MethodPost, "/admin/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without admin_token: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	s = newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+	for _, token := range []string{"", "wrong"} {
+		if rec := adminRequest(s, http.MethodPost, "/admin/pause", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if s.paused.Load() {
+		t.Error("unauthorized request paused message handling")
+	}
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+
+	if rec := adminRequest(s, http.MethodPost, "/admin/pause", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("pause status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !s.paused.Load() {
+		t.Error("server is not paused after /admin/pause")
+	}
+
+	if rec := adminRequest(s, http.MethodPost, "/admin/resume", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("resume status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if s.paused.Load() {
+		t.Error("server is still paused after /admin/resume")
+	}
+}
+
+func TestAdminRotateKeys(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+	s.matrix = &matrix.Client{}
+
+	if rec := adminRequest(s, http.MethodPost, "/admin/keys/rotate?room_id=%23ops:example.com", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("alias status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := adminRequest(s, http.MethodPost, "/admin/keys/rotate", "secret"); rec.Code != http.StatusConflict {
+		t.Errorf("without encryption status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+	sess := s.sessionMgr.GetOrCreateSession("", id.UserID("@user:example.com"), "echo {{.MESSAGE}}")
+
+	rec := adminRequest(s, http.MethodGet, "/admin/sessions", "secret")
+	var listed SessionsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	if len(listed.Sessions) != 1 || listed.Sessions[0].ID != sess.ID {
+		t.Fatalf("sessions = %+v, want only %s", listed.Sessions, sess.ID)
+	}
+
+	if rec := adminRequest(s, http.MethodDelete, "/admin/sessions/"+sess.ID, "secret"); rec.Code != http.StatusOK {
+		t.Errorf("kill status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := adminRequest(s, http.MethodDelete, "/admin/sessions/"+sess.ID, "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("second kill status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminLogs(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+	if rec := adminRequest(s, http.MethodGet, "/admin/logs", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("status without a buffer = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "info", BufferSize: 2})
+	log.AddSecrets("secret-token")
+	s.logger = log.WithComponent("server")
+	s.logger.Info("dropped from the buffer")
+	s.logger.With("room_id", "!room:example.com").Warn("sent with secret-token")
+	log.WithComponent("matrix").Error("sync failed")
+	s.logger.Debug("below the level")
+
+	get := func(query string) []logger.Entry {
+		t.Helper()
+		rec := adminRequest(s, http.MethodGet, "/admin/logs"+query, "secret")
+		var resp LogsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("GET /admin/logs%s: %v (status %d)", query, err, rec.Code)
+		}
+		return resp.Entries
+	}
+
+	// The buffer keeps the last two entries
+	if entries := get(""); len(entries) != 2 || entries[0].Message != "sent with [REDACTED]" ||
+		entries[0].Fields["room_id"] != "!room:example.com" || entries[1].Component != "matrix" {
+		t.Errorf("entries = %+v", entries)
+	}
+	if entries := get("?component=matrix"); len(entries) != 1 || entries[0].Message != "sync failed" {
+		t.Errorf("component=matrix entries = %+v", entries)
+	}
+	if entries := get("?level=error"); len(entries) != 1 || entries[0].Message != "sync failed" {
+		t.Errorf("level=error entries = %+v", entries)
+	}
+	if entries := get("?limit=1"); len(entries) != 1 || entries[0].Message != "sync failed" {
+		t.Errorf("limit=1 entries = %+v", entries)
+	}
+	if rec := adminRequest(s, http.MethodGet, "/admin/logs?level=verbose", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid level status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// validTestConfig returns a config that passes Config.Validate
+func validTestConfig() *config.Config {
+	return &config.Config{
+		Server: config.ServerConfig{Port: 8080, MediaMaxSizeMB: 50, ReadyMaxSyncAge: 120, MaxBodySizeKB: 1024},
+		Matrix: config.MatrixConfig{
+			Homeserver:  "https://matrix.example.com",
+			UserID:      "@bot:example.com",
+			AccessToken: "token",
+			RoomID:      "!room:example.com",
+		},
+		Webhook: config.WebhookConfig{Template: `{"text": "{{.MESSAGE}}"}`, Timeout: 30},
+		Storage: config.StorageConfig{Driver: "sqlite", DSN: "state.db"},
+	}
+}
+
+func TestReload(t *testing.T) {
+	current := validTestConfig()
+	current.Server.AdminToken = "secret"
+	current.Webhook.CommandQueueDepth = 5
+	s := newAdminTestServer(t, current)
+
+	next := validTestConfig()
+	next.Server.Port = 9090
+	next.Server.AdminToken = "other"
+	next.Webhook.CommandQueueDepth = 10
+	next.Archive.Bucket = "elsewhere"
+	next.Dedup.MaxEntries = 500
+	next.Hooks.Custom = map[string]config.CustomHookConfig{
+		"ci": {Template: "{{ .status }}"},
+	}
+	s.loadConfig = func() (*config.Config, error) { return next, nil }
+
+	restartRequired, err := s.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if want := []string{"archive", "dedup.max_entries", "server.port", "server.admin_token"}; !reflect.DeepEqual(restartRequired, want) {
+		t.Errorf("restart required = %v, want %v", restartRequired, want)
+	}
+	if cfg := s.cfg(); cfg.Server.Port != 8080 || cfg.Server.AdminToken != "secret" || cfg.Webhook.CommandQueueDepth != 10 {
+		t.Errorf("config after reload = %+v", cfg)
+	}
+	if _, exists := s.customHooks["ci"]; !exists {
+		t.Error("custom hook added by the reload is not served")
+	}
+
+	// An invalid config leaves everything as it was
+	s.loadConfig = func() (*config.Config, error) {
+		return &config.Config{Webhook: config.WebhookConfig{ExecMode: "bogus"}}, nil
+	}
+	if _, err := s.Reload(); err == nil {
+		t.Error("Reload() with invalid exec_mode succeeded")
+	}
+	s.loadConfig = func() (*config.Config, error) {
+		invalid := validTestConfig()
+		invalid.Webhook.Default = "localhost:3000"
+		return invalid, nil
+	}
+	if _, err := s.Reload(); err == nil {
+		t.Error("Reload() with invalid webhook URL succeeded")
+	}
+	s.loadConfig = func() (*config.Config, error) { return nil, errors.New("unreadable") }
+	if _, err := s.Reload(); err == nil {
+		t.Error("Reload() with unreadable config succeeded")
+	}
+	if s.cfg() != next {
+		t.Error("failed reload replaced the config")
+	}
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// AlertmanagerPayload is the webhook body sent by Prometheus Alertmanager
+// (version 4 of the webhook format). Each payload is one alert group.
+type AlertmanagerPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is a single alert of an Alertmanager group
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Firing returns the alerts of the group that are still firing
+func (p *AlertmanagerPayload) Firing() []Alert {
+	return p.alertsWithStatus("firing")
+}
+
+// Resolved returns the alerts of the group that have resolved
+func (p *AlertmanagerPayload) Resolved() []Alert {
+	return p.alertsWithStatus("resolved")
+}
+
+func (p *AlertmanagerPayload) alertsWithStatus(status string) []Alert {
+	var alerts []Alert
+	for _, alert := range p.Alerts {
+		if alert.Status == status {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// Severity returns the severity label shared by all alerts of the group
+func (p *AlertmanagerPayload) Severity() string {
+	return p.CommonLabels["severity"]
+}
+
+// Title names the group after its alertname, or its group labels if the
+// alerts are not grouped by name
+func (p *AlertmanagerPayload) Title() string {
+	if name := p.GroupLabels["alertname"]; name != "" {
+		return name
+	}
+	if labels := formatLabels(p.GroupLabels); labels != "" {
+		return labels
+	}
+	return "Alerts"
+}
+
+// defaultAlertmanagerTemplate renders a group as a colored header followed by
+// one line per alert, firing alerts first
+const defaultAlertmanagerTemplate = `{{ severityEmoji .Status .Severity }} <font data-mx-color="{{ severityColor .Status .Severity }}">**[{{ upper .Status }}{{ with .Firing }}:{{ len . }}{{ end }}] {{ .Title }}**</font>{{ with labels .GroupLabels "alertname" }} {{ . }}{{ end }}
+{{ range .Firing }}
+- {{ template "alert" . }}
+{{- end }}
+{{- range .Resolved }}
+- {{ template "alert" . }}
+{{- end }}
+{{- if .TruncatedAlerts }}
+- _{{ .TruncatedAlerts }} more alerts not shown_
+{{- end }}
+{{- define "alert" }}{{ severityEmoji .Status (index .Labels "severity") }} **{{ or (index .Annotations "summary") (index .Labels "alertname") }}**{{ with index .Annotations "description" }}: {{ . }}{{ end }}{{ with labels .Labels "alertname" "severity" }} ({{ . }}){{ end }}{{ with .GeneratorURL }} [source]({{ . }}){{ end }}{{ end }}`
+
+// Built-in severity styling, overridable per severity in config
+var (
+	defaultSeverityEmoji = map[string]string{
+		"critical": "🔥",
+		"error":    "🚨",
+		"warning":  "⚠️",
+		"info":     "ℹ️",
+		"resolved": "✅",
+		"":         "🔔",
+	}
+	defaultSeverityColors = map[string]string{
+		"critical": "#d32f2f",
+		"error":    "#e64a19",
+		"warning":  "#f9a825",
+		"info":     "#1976d2",
+		"resolved": "#388e3c",
+		"":         "#757575",
+	}
+)
+
+// compileAlertmanagerTemplate parses the configured template, or the built-in
+// one, with the severity helpers bound to the configured styling
+func compileAlertmanagerTemplate(cfg *config.AlertmanagerConfig) (*template.Template, error) {
+	text := cfg.Template
+	if text == "" {
+		text = defaultAlertmanagerTemplate
+	}
+
+	emoji := mergeStyles(defaultSeverityEmoji, cfg.SeverityEmoji)
+	colors := mergeStyles(defaultSeverityColors, cfg.SeverityColors)
+
+	funcs := template.FuncMap{
+		"severityEmoji": func(status, severity string) string {
+			return severityStyle(emoji, status, severity)
+		},
+		"severityColor": func(status, severity string) string {
+			return severityStyle(colors, status, severity)
+		},
+		"labels": formatLabels,
+		"upper":  strings.ToUpper,
+		"lower":  strings.ToLower,
+		"join":   strings.Join,
+	}
+
+	return template.New("alertmanager").Funcs(funcs).Option("missingkey=zero").Parse(text)
+}
+
+// mergeStyles returns the built-in styles with the configured ones applied on top
+func mergeStyles(defaults, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[strings.ToLower(k)] = v
+	}
+	return merged
+}
+
+// severityStyle looks up the style for a severity. Resolved alerts always use
+// the "resolved" style; unknown severities fall back to the "" entry.
+func severityStyle(styles map[string]string, status, severity string) string {
+	if status == "resolved" {
+		return styles["resolved"]
+	}
+	if style, exists := styles[strings.ToLower(severity)]; exists {
+		return style
+	}
+	return styles[""]
+}
+
+// formatLabels renders labels as sorted key=value pairs, leaving out the
+// excluded keys
+func formatLabels(labels map[string]string, exclude ...string) string {
+	var pairs []string
+	for k, v := range labels {
+		excluded := false
+		for _, e := range exclude {
+			if k == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// renderAlertmanager renders an alert group into a markdown message
+func (s *Server) renderAlertmanager(payload *AlertmanagerPayload) (string, error) {
+	s.configMutex.RLock()
+	tmpl := s.alertmanagerTemplate
+	s.configMutex.RUnlock()
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, payload); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// alertmanagerRoom picks the room for a payload: the room_id query parameter,
+// then the room mapped to the receiver, then the configured default. An empty
+// result means the Matrix client's default room.
+func (s *Server) alertmanagerRoom(r *http.Request, payload *AlertmanagerPayload) id.RoomID {
+	if roomID := r.URL.Query().Get("room_id"); roomID != "" {
+		return id.RoomID(roomID)
+	}
+	cfg := &s.cfg().Hooks.Alertmanager
+	if roomID, exists := cfg.Rooms[payload.Receiver]; exists {
+		return id.RoomID(roomID)
+	}
+	return id.RoomID(cfg.RoomID)
+}
+
+// hasBearerToken reports whether the request carries the expected bearer
+// token. An empty expected token disables the check.
+func hasBearerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	provided, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// handleAlertmanager receives Alertmanager webhook notifications and posts
+// them to Matrix
+func (s *Server) handleAlertmanager(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Alertmanager hook called")
+
+	if !hasBearerToken(r, s.cfg().Hooks.Alertmanager.Token) {
+		s.logger.Warn("Rejecting Alertmanager hook call with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload AlertmanagerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.logger.Error("Invalid Alertmanager payload: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	message, err := s.renderAlertmanager(&payload)
+	if err != nil {
+		s.logger.Error("Failed to render Alertmanager notification: %v", err)
+		http.Error(w, "Failed to render notification", http.StatusInternalServerError)
+		return
+	}
+
+	roomID := s.alertmanagerRoom(r, &payload)
+	s.logger.Info("Posting %d alerts (%s) for receiver %q to room %q", len(payload.Alerts), payload.Status, payload.Receiver, roomID)
+
+	if s.holdNotification(r.Context(), quiet.Notification{Source: "alertmanager:" + payload.Receiver, RoomID: string(roomID), Message: message, Sender: "alertmanager"}) {
+		writeHeldResponse(w)
+		return
+	}
+
+	eventID, err := s.matrix.SendMessage(message, matrix.WithRoom(roomID), matrix.WithSender("alertmanager"), withRequestID(r))
+	if err != nil {
+		s.logger.Error("Failed to send alert to Matrix: %v", err)
+		http.Error(w, "Failed to send alert to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+func samplePayload() *AlertmanagerPayload {
+	return &AlertmanagerPayload{
+		Version:      "4",
+		Status:       "firing",
+		Receiver:     "team-db",
+		GroupLabels:  map[string]string{"alertname": "HighLatency"},
+		CommonLabels: map[string]string{"alertname": "HighLatency", "severity": "critical"},
+		Alerts: []Alert{
+			{
+				Status:       "firing",
+				Labels:       map[string]string{"alertname": "HighLatency", "severity": "critical", "instance": "db-1"},
+				Annotations:  map[string]string{"summary": "p99 latency above 2s"},
+				GeneratorURL: "http://prometheus/graph",
+			},
+			{
+				Status: "resolved",
+				Labels: map[string]string{"alertname": "HighLatency", "severity": "critical", "instance": "db-2"},
+			},
+		},
+	}
+}
+
+func TestRenderAlertmanagerDefaultTemplate(t *testing.T) {
+	tmpl, err := compileAlertmanagerTemplate(&config.AlertmanagerConfig{})
+	if err != nil {
+		t.Fatalf("compileAlertmanagerTemplate() error = %v", err)
+	}
+	s := &Server{alertmanagerTemplate: tmpl}
+
+	message, err := s.renderAlertmanager(samplePayload())
+	if err != nil {
+		t.Fatalf("renderAlertmanager() error = %v", err)
+	}
+
+	expected := []string{
+		`🔥 <font data-mx-color="#d32f2f">**[FIRING:1] HighLatency**</font>`,
+		"- 🔥 **p99 latency above 2s** (instance=db-1) [source](http://prometheus/graph)",
+		"- ✅ **HighLatency** (instance=db-2)",
+	}
+	for _, line := range expected {
+		if !strings.Contains(message, line) {
+			t.Errorf("renderAlertmanager() = %q, missing %q", message, line)
+		}
+	}
+	if strings.Index(message, "db-1") > strings.Index(message, "db-2") {
+		t.Error("renderAlertmanager() should list firing alerts before resolved ones")
+	}
+}
+
+func TestRenderAlertmanagerCustomStyles(t *testing.T) {
+	cfg := &config.AlertmanagerConfig{
+		Template:      `{{ severityEmoji .Status .Severity }} {{ .Receiver }}: {{ len .Alerts }}`,
+		SeverityEmoji: map[string]string{"Critical": "💀"},
+	}
+	tmpl, err := compileAlertmanagerTemplate(cfg)
+	if err != nil {
+		t.Fatalf("compileAlertmanagerTemplate() error = %v", err)
+	}
+	s := &Server{alertmanagerTemplate: tmpl}
+
+	message, err := s.renderAlertmanager(samplePayload())
+	if err != nil {
+		t.Fatalf("renderAlertmanager() error = %v", err)
+	}
+	if message != "💀 team-db: 2" {
+		t.Errorf("renderAlertmanager() = %q, want %q", message, "💀 team-db: 2")
+	}
+}
+
+func TestCompileAlertmanagerTemplateInvalid(t *testing.T) {
+	if _, err := compileAlertmanagerTemplate(&config.AlertmanagerConfig{Template: "{{ .Status"}); err == nil {
+		t.Error("compileAlertmanagerTemplate() should reject an invalid template")
+	}
+}
+
+func TestAlertmanagerRoom(t *testing.T) {
+	s := &Server{config: &config.Config{Hooks: config.HooksConfig{Alertmanager: config.AlertmanagerConfig{
+		RoomID: "!default:matrix.org",
+		Rooms:  map[string]string{"team-db": "!db:matrix.org"},
+	}}}}
+
+	tests := []struct {
+		name     string
+		url      string
+		receiver string
+		expected id.RoomID
+	}{
+		{"Query parameter wins", "/hook/alertmanager?room_id=!query:matrix.org", "team-db", "!query:matrix.org"},
+		{"Mapped receiver", "/hook/alertmanager", "team-db", "!db:matrix.org"},
+		{"Unmapped receiver", "/hook/alertmanager", "team-web", "!default:matrix.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, nil)
+			if got := s.alertmanagerRoom(req, &AlertmanagerPayload{Receiver: tt.receiver}); got != tt.expected {
+				t.Errorf("alertmanagerRoom() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHasBearerToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/hook/alertmanager", nil)
+	if !hasBearerToken(req, "") {
+		t.Error("hasBearerToken() should accept any request when no token is configured")
+	}
+	if hasBearerToken(req, "secret") {
+		t.Error("hasBearerToken() should reject a request without a token")
+	}
+	req.Header.Set("Authorization", "Bearer wrong")
+	if hasBearerToken(req, "secret") {
+		t.Error("hasBearerToken() should reject a wrong token")
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if !hasBearerToken(req, "secret") {
+		t.Error("hasBearerToken() should accept the configured token")
+	}
+}
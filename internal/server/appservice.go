@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// appServiceRoutes serves the application service API the homeserver calls
+// in appservice mode
+func (s *Server) appServiceRoutes(r chi.Router) {
+	r.Use(s.appServiceAuth)
+	r.Put("/transactions/{txnID}", s.handleAppServiceTransaction)
+	r.Get("/users/{userID}", s.handleAppServiceUser)
+	r.Get("/rooms/{roomAlias}", s.handleAppServiceRoom)
+	r.Post("/ping", s.handleAppServicePing)
+}
+
+// appServiceError writes an error in the format of the Matrix spec
+func appServiceError(w http.ResponseWriter, status int, errcode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errcode": errcode, "error": message})
+}
+
+// appServiceOK writes the empty object the homeserver expects on success
+func appServiceOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+// appServiceAuth only lets the homeserver in, authenticated with the hs_token
+// as a bearer token or, by older homeservers, the access_token parameter
+func (s *Server) appServiceAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			token = bearer
+		}
+		switch {
+		case token == "":
+			appServiceError(w, http.StatusUnauthorized, "M_UNAUTHORIZED", "Missing hs_token")
+		case subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg().Matrix.AppService.HSToken)) != 1:
+			s.logger.Warn("Rejecting appservice call to %s with an invalid hs_token", r.URL.Path)
+			appServiceError(w, http.StatusForbidden, "M_FORBIDDEN", "Invalid hs_token")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleAppServiceTransaction receives the events the homeserver pushes
+func (s *Server) handleAppServiceTransaction(w http.ResponseWriter, r *http.Request) {
+	var txn struct {
+		Events []*event.Event `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+		appServiceError(w, http.StatusBadRequest, "M_NOT_JSON", "Invalid transaction: "+err.Error())
+		return
+	}
+	txnID := chi.URLParam(r, "txnID")
+	s.logger.Debug("Received appservice transaction %s with %d events", txnID, len(txn.Events))
+	s.matrix.HandleTransaction(txnID, txn.Events)
+	appServiceOK(w)
+}
+
+// handleAppServiceUser answers whether a user of the namespace exists,
+// registering the virtual users the homeserver asks for
+func (s *Server) handleAppServiceUser(w http.ResponseWriter, r *http.Request) {
+	userID := id.UserID(chi.URLParam(r, "userID"))
+	exists, err := s.matrix.EnsureVirtualUser(r.Context(), userID)
+	if err != nil {
+		s.logger.Warn("Failed to register virtual user %s: %v", userID, err)
+	}
+	if !exists {
+		appServiceError(w, http.StatusNotFound, "M_NOT_FOUND", "No such user")
+		return
+	}
+	appServiceOK(w)
+}
+
+// handleAppServiceRoom answers room alias queries, the bot creates no rooms
+func (s *Server) handleAppServiceRoom(w http.ResponseWriter, r *http.Request) {
+	appServiceError(w, http.StatusNotFound, "M_NOT_FOUND", "No such room")
+}
+
+// handleAppServicePing answers the homeserver checking that the bot is
+// reachable
+func (s *Server) handleAppServicePing(w http.ResponseWriter, r *http.Request) {
+	appServiceOK(w)
+}
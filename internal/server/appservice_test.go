@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestAppServiceAuth(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Matrix: config.MatrixConfig{AppService: config.AppServiceConfig{Enabled: true, HSToken: "hs-token"}}}
+	s := &Server{config: cfg, logger: log, router: chi.NewRouter()}
+	s.routes()
+
+	tests := []struct {
+		name, method, target, authorization, body string
+		want                                      int
+	}{
+		{"No token", http.MethodPut, "/_matrix/app/v1/transactions/1", "", `{"events": []}`, http.StatusUnauthorized},
+		{"Wrong token", http.MethodPut, "/_matrix/app/v1/transactions/1", "Bearer as-token", `{"events": []}`, http.StatusForbidden},
+		{"Wrong query token", http.MethodPut, "/_matrix/app/v1/transactions/1?access_token=as-token", "", `{"events": []}`, http.StatusForbidden},
+		{"Not JSON", http.MethodPut, "/_matrix/app/v1/transactions/1", "Bearer hs-token", `{`, http.StatusBadRequest},
+		{"Ping", http.MethodPost, "/_matrix/app/v1/ping", "Bearer hs-token", `{}`, http.StatusOK},
+		{"Ping with query token", http.MethodPost, "/_matrix/app/v1/ping?access_token=hs-token", "", `{}`, http.StatusOK},
+		{"Room alias", http.MethodGet, "/_matrix/app/v1/rooms/%23alias:example.com", "Bearer hs-token", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, rec.Code, tt.want, rec.Body)
+		}
+		if rec.Code != http.StatusOK && !strings.Contains(rec.Body.String(), `"errcode"`) {
+			t.Errorf("%s: body = %s, want a Matrix error", tt.name, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/archive"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"maunium.net/go/mautrix/id"
+)
+
+// archiveMessage archives a message the service handles, if archive.enabled
+func (s *Server) archiveMessage(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) {
+	if s.archiver == nil {
+		return
+	}
+	s.archiver.Add(archive.Record{
+		Direction:  archive.DirectionIn,
+		RoomID:     string(roomID),
+		EventID:    string(eventID),
+		Sender:     string(sender),
+		ThreadRoot: string(threadRootEventID),
+		InReplyTo:  string(inReplyToEventID),
+		Body:       message,
+		RequestID:  requestid.FromContext(ctx),
+	})
+}
+
+// archiveReply archives a reply the bot sent to the reply room of ctx, if
+// archive.enabled
+func (s *Server) archiveReply(ctx context.Context, message string, replyEventID id.EventID, eventID id.EventID) {
+	if s.archiver == nil || eventID == "" {
+		return
+	}
+	roomID := replyRoom(ctx)
+	if roomID == "" {
+		roomID = id.RoomID(s.cfg().Matrix.RoomID)
+	}
+	s.archiver.Add(archive.Record{
+		Direction: archive.DirectionOut,
+		RoomID:    string(roomID),
+		EventID:   string(eventID),
+		Sender:    s.cfg().Matrix.UserID,
+		InReplyTo: string(replyEventID),
+		Body:      message,
+		RequestID: requestid.FromContext(ctx),
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"maunium.net/go/mautrix/id"
+)
+
+// auditCommand starts the audit record of a command handled for a message.
+// The record is written by its done method.
+func (s *Server) auditCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, kind, command, args string) *commandAudit {
+	return &commandAudit{
+		server:  s,
+		ctx:     ctx,
+		started: time.Now(),
+		record: audit.Record{
+			RequestID: requestid.FromContext(ctx),
+			Sender:    string(sender),
+			RoomID:    string(roomID),
+			EventID:   string(eventID),
+			Command:   command,
+			ArgsHash:  audit.HashArgs(args),
+			Kind:      kind,
+		},
+	}
+}
+
+// commandAudit is the audit record of a command being handled
+type commandAudit struct {
+	server  *Server
+	ctx     context.Context
+	started time.Time
+	record  audit.Record
+}
+
+// done writes the record with the target the command went to and its result.
+// Nothing is written if the audit log is disabled.
+func (a *commandAudit) done(target, status string, err error) {
+	if a.server.auditLog == nil {
+		return
+	}
+	a.record.Target = target
+	a.record.Status = status
+	if err != nil {
+		a.record.Error = err.Error()
+	}
+	a.record.DurationMS = time.Since(a.started).Milliseconds()
+	if err := a.server.auditLog.Write(a.record); err != nil {
+		a.server.logger.Ctx(a.ctx).Error("Failed to write audit record: %v", err)
+	}
+}
+
+// resultStatus returns the audit status of a finished command
+func resultStatus(err error) string {
+	if err != nil {
+		return audit.StatusFailed
+	}
+	return audit.StatusOK
+}
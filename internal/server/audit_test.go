@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHandleMessageAuditsDispatch(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Webhook: config.WebhookConfig{Default: target.URL, Template: `{"message": "{{.MESSAGE}}"}`}}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), auditLog: auditLog}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "hello", "", "", "$event")
+	auditLog.Close()
+
+	if count, err := audit.Verify(dir); err != nil || count != 1 {
+		t.Fatalf("Verify = %d, %v, want 1 record", count, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	data, _ := os.ReadFile(files[0])
+	var record audit.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Sender != "@user:example.com" || record.RoomID != "!room:example.com" || record.EventID != "$event" {
+		t.Errorf("record attributed to %s in %s (%s)", record.Sender, record.RoomID, record.EventID)
+	}
+	if record.Kind != audit.KindWebhook || record.Target != target.URL || record.Status != audit.StatusOK {
+		t.Errorf("record = %s to %s: %s", record.Kind, record.Target, record.Status)
+	}
+	if record.ArgsHash != audit.HashArgs("hello") || record.RequestID == "" {
+		t.Errorf("args hash %q, request ID %q", record.ArgsHash, record.RequestID)
+	}
+}
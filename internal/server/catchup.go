@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// catchupMaxWindow bounds how far back /catchup reads the room history
+	catchupMaxWindow = 7 * 24 * time.Hour
+	// catchupConfirmTimeout is how long /catchup confirm dispatches the
+	// commands listed last
+	catchupConfirmTimeout = 10 * time.Minute
+	// catchupBodyLength is where listed messages are cut off
+	catchupBodyLength = 80
+)
+
+// UnansweredCommand is a message of the room history that was passed to the
+// bot and never got a reply
+type UnansweredCommand struct {
+	RoomID  string    `json:"room_id"`
+	EventID string    `json:"event_id"`
+	Sender  string    `json:"sender"`
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// CatchupResponse is returned by GET /admin/catchup
+type CatchupResponse struct {
+	Window   string              `json:"window"`
+	Commands []UnansweredCommand `json:"commands"`
+}
+
+// CatchupRequest is the body of POST /admin/catchup
+type CatchupRequest struct {
+	Window   string   `json:"window"`
+	RoomID   string   `json:"room_id,omitempty"`
+	EventIDs []string `json:"event_ids"` // Listed commands to dispatch again
+}
+
+// pendingCatchups are the commands /catchup listed, by the admin who asked,
+// until they confirm
+type pendingCatchups struct {
+	mu     sync.Mutex
+	byUser map[id.UserID]pendingCatchup
+}
+
+type pendingCatchup struct {
+	commands []matrix.HistoryMessage
+	expires  time.Time
+}
+
+func (p *pendingCatchups) put(userID id.UserID, commands []matrix.HistoryMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byUser == nil {
+		p.byUser = make(map[id.UserID]pendingCatchup)
+	}
+	p.byUser[userID] = pendingCatchup{commands: commands, expires: time.Now().Add(catchupConfirmTimeout)}
+}
+
+// take returns and forgets the commands listed for userID, unless they expired
+func (p *pendingCatchups) take(userID id.UserID) []matrix.HistoryMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, exists := p.byUser[userID]
+	delete(p.byUser, userID)
+	if !exists || time.Now().After(pending.expires) {
+		return nil
+	}
+	return pending.commands
+}
+
+// parseCatchupWindow parses the duration of /catchup, a Go duration or a
+// number of days such as 2d
+func parseCatchupWindow(window string) (time.Duration, error) {
+	var d time.Duration
+	if days, isDays := strings.CutSuffix(window, "d"); isDays {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", window)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(window); err != nil {
+			return 0, fmt.Errorf("invalid duration %q", window)
+		}
+	}
+	if d <= 0 || d > catchupMaxWindow {
+		return 0, fmt.Errorf("duration %q is not between 0 and %s", window, catchupMaxWindow)
+	}
+	return d, nil
+}
+
+// unansweredCommands returns the messages passed to the bot that no later
+// message of the bot answers. A bot message answers the command it replies
+// to or starts a thread on; otherwise it answers the oldest unanswered
+// command in its thread (or outside threads) of a sender it mentions.
+func unansweredCommands(messages []matrix.HistoryMessage) []matrix.HistoryMessage {
+	var commands []matrix.HistoryMessage
+	answered := make(map[id.EventID]bool)
+	for _, m := range messages {
+		if !m.FromBot {
+			if m.MentionsBot && leadingCommand(m.Body) != "catchup" {
+				commands = append(commands, m)
+			}
+			continue
+		}
+		thread := m.ThreadRoot
+		if thread == "" {
+			thread = m.InReplyTo
+		}
+		for _, c := range commands {
+			if answered[c.ID] {
+				continue
+			}
+			if c.ID == thread || (c.ThreadRoot == thread && mentions(m.Mentions, c.Sender)) {
+				answered[c.ID] = true
+				break
+			}
+		}
+	}
+
+	var unanswered []matrix.HistoryMessage
+	for _, c := range commands {
+		if !answered[c.ID] {
+			unanswered = append(unanswered, c)
+		}
+	}
+	return unanswered
+}
+
+func mentions(userIDs []id.UserID, userID id.UserID) bool {
+	for _, mentioned := range userIDs {
+		if mentioned == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// findUnansweredCommands reads the history of the rooms since window ago
+func (s *Server) findUnansweredCommands(ctx context.Context, rooms []id.RoomID, window time.Duration) ([]matrix.HistoryMessage, error) {
+	since := time.Now().Add(-window)
+	var unanswered []matrix.HistoryMessage
+	for _, roomID := range rooms {
+		messages, err := s.matrix.History(ctx, roomID, since)
+		if err != nil {
+			return nil, err
+		}
+		unanswered = append(unanswered, unansweredCommands(messages)...)
+	}
+	return unanswered, nil
+}
+
+// redispatch handles the commands again as if they had just arrived. The
+// permissions of their senders apply, and commands whose dispatch was
+// delivered before are skipped by their idempotency keys.
+func (s *Server) redispatch(commands []matrix.HistoryMessage) {
+	for _, c := range commands {
+		s.logger.Info("Dispatching unanswered command %s of %s in %s again", c.ID, c.Sender, c.RoomID)
+		s.processMessage(context.Background(), c.RoomID, c.Sender, c.Body, c.InReplyTo, c.ThreadRoot, c.ID)
+	}
+}
+
+func isCatchupCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/catchup"
+}
+
+// handleCatchupCommand lets the users in server.admin_users find the
+// commands of the room nobody answered:
+// /catchup <duration> lists them
+// /catchup confirm dispatches the listed ones again
+func (s *Server) handleCatchupCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	if !s.isAdminUser(sender) {
+		s.logger.Ctx(ctx).Warn("User %s is not an admin, refusing /catchup", sender)
+		reply(s.text(roomID, "catchup.admin_only"))
+		return
+	}
+	fields := strings.Fields(message)
+	if len(fields) != 2 {
+		reply(s.text(roomID, "catchup.usage"))
+		return
+	}
+
+	if fields[1] == "confirm" {
+		commands := s.catchups.take(sender)
+		if len(commands) == 0 {
+			reply(s.text(roomID, "catchup.nothing_pending"))
+			return
+		}
+		reply(s.text(roomID, "catchup.dispatching", len(commands)))
+		go s.redispatch(commands)
+		return
+	}
+
+	window, err := parseCatchupWindow(fields[1])
+	if err != nil {
+		reply(s.text(roomID, "catchup.usage"))
+		return
+	}
+	unanswered, err := s.findUnansweredCommands(ctx, []id.RoomID{roomID}, window)
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Failed to find unanswered commands: %v", err)
+		reply(s.text(roomID, "catchup.failed", err))
+		return
+	}
+	if len(unanswered) == 0 {
+		reply(s.text(roomID, "catchup.none", fields[1]))
+		return
+	}
+	s.catchups.put(sender, unanswered)
+
+	lines := []string{s.text(roomID, "catchup.found", len(unanswered), fields[1])}
+	for i, c := range unanswered {
+		lines = append(lines, fmt.Sprintf("%d. %s %s: %s", i+1, c.Timestamp.UTC().Format("2006-01-02 15:04"), c.Sender, abbreviate(catchupBodyLength, strings.Join(strings.Fields(c.Body), " "))))
+	}
+	lines = append(lines, "", s.text(roomID, "catchup.confirm", catchupConfirmTimeout))
+	reply(strings.Join(lines, "\n"))
+}
+
+// catchupRooms returns the room of a catch-up request, all rooms the bot
+// listens in if none is given
+func (s *Server) catchupRooms(roomID string) ([]id.RoomID, error) {
+	if roomID == "" {
+		return s.matrix.Rooms(), nil
+	}
+	if !strings.HasPrefix(roomID, "!") {
+		return nil, fmt.Errorf("invalid room_id %q", roomID)
+	}
+	return []id.RoomID{id.RoomID(roomID)}, nil
+}
+
+// handleAdminCatchup lists the unanswered commands of the last window
+func (s *Server) handleAdminCatchup(w http.ResponseWriter, r *http.Request) {
+	windowParam := r.URL.Query().Get("window")
+	window, err := parseCatchupWindow(windowParam)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rooms, err := s.catchupRooms(r.URL.Query().Get("room_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unanswered, err := s.findUnansweredCommands(r.Context(), rooms, window)
+	if err != nil {
+		s.logger.Error("Failed to find unanswered commands: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	resp := CatchupResponse{Window: windowParam, Commands: []UnansweredCommand{}}
+	for _, c := range unanswered {
+		resp.Commands = append(resp.Commands, UnansweredCommand{RoomID: string(c.RoomID), EventID: string(c.ID), Sender: string(c.Sender), Message: c.Body, SentAt: c.Timestamp.UTC()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAdminCatchupDispatch dispatches the listed commands again that are
+// still unanswered, confirming a listing of GET /admin/catchup
+func (s *Server) handleAdminCatchupDispatch(w http.ResponseWriter, r *http.Request) {
+	var req CatchupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	window, err := parseCatchupWindow(req.Window)
+	if err == nil && len(req.EventIDs) == 0 {
+		err = errors.New("event_ids lists no commands to dispatch")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rooms, err := s.catchupRooms(req.RoomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The history is read again so that commands answered in the meantime
+	// are not dispatched twice
+	unanswered, err := s.findUnansweredCommands(r.Context(), rooms, window)
+	if err != nil {
+		s.logger.Error("Failed to find unanswered commands: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	confirmed := make(map[id.EventID]bool, len(req.EventIDs))
+	for _, eventID := range req.EventIDs {
+		confirmed[id.EventID(eventID)] = true
+	}
+	var commands []matrix.HistoryMessage
+	for _, c := range unanswered {
+		if confirmed[c.ID] {
+			commands = append(commands, c)
+		}
+	}
+	s.logger.Warn("Dispatching %d unanswered commands again via admin API", len(commands))
+	go s.redispatch(commands)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "dispatching", Message: fmt.Sprintf("Dispatching %d of %d commands again, the others are no longer unanswered", len(commands), len(req.EventIDs))})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUnansweredCommands(t *testing.T) {
+	alice, bob := id.UserID("@alice:example.com"), id.UserID("@bob:example.com")
+	command := func(eventID id.EventID, sender id.UserID, threadRoot id.EventID) matrix.HistoryMessage {
+		return matrix.HistoryMessage{ID: eventID, Sender: sender, Body: "/deploy", ThreadRoot: threadRoot, MentionsBot: true}
+	}
+	reply := func(mentioned id.UserID, inReplyTo id.EventID) matrix.HistoryMessage {
+		return matrix.HistoryMessage{Sender: "@bot:example.com", FromBot: true, Mentions: []id.UserID{mentioned}, InReplyTo: inReplyTo}
+	}
+	messages := []matrix.HistoryMessage{
+		command("$answered", alice, ""),
+		reply(alice, ""),
+		command("$first", alice, ""),
+		command("$second", alice, ""),
+		reply(alice, ""), // Answers $first only
+		command("$other_sender", bob, ""),
+		reply(alice, ""), // Answers $second, not bob's
+		command("$threaded", bob, "$root"),
+		reply(bob, "$root"),
+		command("$lost_in_thread", alice, "$root"),
+		command("$replied_to", bob, ""),
+		reply("", "$replied_to"),
+		{ID: "$chat", Sender: alice, Body: "hello everyone"},
+		{ID: "$catchup", Sender: alice, Body: "/catchup 2h", MentionsBot: true},
+		{Sender: "@bot:example.com", FromBot: true, Body: "Alert firing"}, // A hook message answers nothing
+		command("$late", bob, ""),
+	}
+
+	var got []id.EventID
+	for _, c := range unansweredCommands(messages) {
+		got = append(got, c.ID)
+	}
+	want := []id.EventID{"$other_sender", "$lost_in_thread", "$late"}
+	if len(got) != len(want) {
+		t.Fatalf("unanswered = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unanswered = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestParseCatchupWindow(t *testing.T) {
+	for window, want := range map[string]time.Duration{"30m": 30 * time.Minute, "2h": 2 * time.Hour, "2d": 48 * time.Hour, "7d": catchupMaxWindow} {
+		if got, err := parseCatchupWindow(window); err != nil || got != want {
+			t.Errorf("parseCatchupWindow(%q) = %v, %v, want %v", window, got, err, want)
+		}
+	}
+	for _, window := range []string{"", "0s", "-1h", "8d", "xd", "soon"} {
+		if _, err := parseCatchupWindow(window); err == nil {
+			t.Errorf("parseCatchupWindow(%q) succeeded", window)
+		}
+	}
+}
+
+func TestPendingCatchups(t *testing.T) {
+	var pending pendingCatchups
+	admin := id.UserID("@admin:example.com")
+	pending.put(admin, []matrix.HistoryMessage{{ID: "$event"}})
+	if commands := pending.take("@other:example.com"); commands != nil {
+		t.Errorf("take() of another admin = %v", commands)
+	}
+	if commands := pending.take(admin); len(commands) != 1 {
+		t.Errorf("take() = %v, want the listed command", commands)
+	}
+	if commands := pending.take(admin); commands != nil {
+		t.Errorf("second take() = %v, want nothing", commands)
+	}
+
+	pending.put(admin, []matrix.HistoryMessage{{ID: "$event"}})
+	pending.byUser[admin] = pendingCatchup{commands: pending.byUser[admin].commands, expires: time.Now().Add(-time.Second)}
+	if commands := pending.take(admin); commands != nil {
+		t.Errorf("take() after expiry = %v", commands)
+	}
+}
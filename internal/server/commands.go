@@ -0,0 +1,414 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+// RegisteredCommand is a command→webhook mapping added at runtime
+type RegisteredCommand struct {
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Template     string    `json:"template,omitempty"`
+	JQSelector   string    `json:"jq_selector,omitempty"`
+	RegisteredBy string    `json:"registered_by,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// CommandRequest is the body of PUT /admin/commands/{name}
+type CommandRequest struct {
+	URL        string `json:"url"`
+	Template   string `json:"template,omitempty"`    // Defaults to webhook.template
+	JQSelector string `json:"jq_selector,omitempty"` // Defaults to webhook.jq_selector
+}
+
+// CommandsResponse is returned by GET /admin/commands
+type CommandsResponse struct {
+	Commands []RegisteredCommand `json:"commands"`
+}
+
+var (
+	// Returned when webhook.command_store is empty
+	errCommandsDisabled = errors.New("runtime command registration is disabled, set webhook.command_store")
+	// Commands defined in the config file cannot be replaced at runtime
+	errCommandConfigured = errors.New("command is defined in the config file")
+)
+
+// Same characters the dispatcher accepts in a slash command
+var commandNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// validate checks that the command can be dispatched with the webhook
+// settings of cfg
+func (req *CommandRequest) validate(name string, cfg *config.WebhookConfig) error {
+	if !commandNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid command name %q, use letters, digits and underscores", name)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http:// or https:// URL", req.URL)
+	}
+	if req.Template != "" {
+		if _, err := webhook.CheckTemplate("template", name, req.Template, cfg); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	}
+	if req.JQSelector != "" {
+		query, err := gojq.Parse(req.JQSelector)
+		if err == nil {
+			_, err = gojq.Compile(query)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid jq selector %q: %v", req.JQSelector, err)
+		}
+	}
+	return nil
+}
+
+// commandStoreKey is the storage key of the registered commands
+const commandStoreKey = "commands"
+
+// commandStore keeps registered commands in storage
+type commandStore struct {
+	store    storage.Store
+	mu       sync.Mutex
+	commands map[string]RegisteredCommand
+}
+
+// openCommandStore loads the commands registered earlier, importing the JSON
+// file at legacyPath until they are first saved to storage
+func openCommandStore(store storage.Store, legacyPath string) (*commandStore, error) {
+	c := &commandStore{store: store, commands: make(map[string]RegisteredCommand)}
+	data, err := storage.Load(context.Background(), store, commandStoreKey, legacyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command store: %w", err)
+	}
+	if data == nil {
+		return c, nil
+	}
+
+	var stored CommandsResponse
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid command store: %w", err)
+	}
+	for _, cmd := range stored.Commands {
+		c.commands[cmd.Name] = cmd
+	}
+	return c, nil
+}
+
+// list returns the registered commands sorted by name
+func (c *commandStore) list() []RegisteredCommand {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	commands := make([]RegisteredCommand, 0, len(c.commands))
+	for _, cmd := range c.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// put adds or replaces a command and saves the store
+func (c *commandStore) put(cmd RegisteredCommand) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, existed := c.commands[cmd.Name]
+	c.commands[cmd.Name] = cmd
+	if err := c.save(); err != nil {
+		if existed {
+			c.commands[cmd.Name] = previous
+		} else {
+			delete(c.commands, cmd.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// remove deletes a command and saves the store. It reports whether the
+// command was registered.
+func (c *commandStore) remove(name string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, existed := c.commands[name]
+	if !existed {
+		return false, nil
+	}
+	delete(c.commands, name)
+	if err := c.save(); err != nil {
+		c.commands[name] = previous
+		return true, err
+	}
+	return true, nil
+}
+
+// save writes the commands to storage. Called with mu held.
+func (c *commandStore) save() error {
+	commands := make([]RegisteredCommand, 0, len(c.commands))
+	for _, cmd := range c.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+
+	data, err := json.Marshal(CommandsResponse{Commands: commands})
+	if err != nil {
+		return err
+	}
+	if err := c.store.Put(context.Background(), commandStoreKey, data); err != nil {
+		return fmt.Errorf("failed to save command store: %w", err)
+	}
+	return nil
+}
+
+// mergeRegisteredCommands returns a copy of cfg with the registered commands
+// added to the webhook settings. Commands defined in the config file take
+// precedence.
+func mergeRegisteredCommands(cfg *config.Config, commands []RegisteredCommand, log *logger.Logger) *config.Config {
+	if len(commands) == 0 {
+		return cfg
+	}
+
+	merged := *cfg
+	merged.Webhook.Commands = copyStringMap(cfg.Webhook.Commands)
+	merged.Webhook.CommandTemplates = copyStringMap(cfg.Webhook.CommandTemplates)
+	merged.Webhook.CommandSelectors = copyStringMap(cfg.Webhook.CommandSelectors)
+	for _, cmd := range commands {
+		if isConfiguredCommand(cfg, cmd.Name) {
+			log.Warn("Registered command %s is shadowed by the config file, ignoring it", cmd.Name)
+			continue
+		}
+		merged.Webhook.Commands[cmd.Name] = cmd.URL
+		if cmd.Template != "" {
+			merged.Webhook.CommandTemplates[cmd.Name] = cmd.Template
+		}
+		if cmd.JQSelector != "" {
+			merged.Webhook.CommandSelectors[cmd.Name] = cmd.JQSelector
+		}
+	}
+	return &merged
+}
+
+// isConfiguredCommand reports whether the config file defines the command,
+// either as a webhook or as an executed command template
+func isConfiguredCommand(cfg *config.Config, name string) bool {
+	_, isWebhook := cfg.Webhook.Commands[name]
+	_, hasTemplate := cfg.Webhook.CommandTemplates[name]
+	return isWebhook || hasTemplate
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+// withRegisteredCommands merges the registered commands into cfg, if
+// registration is enabled
+func (s *Server) withRegisteredCommands(cfg *config.Config) *config.Config {
+	if s.commands == nil {
+		return cfg
+	}
+	return mergeRegisteredCommands(cfg, s.commands.list(), s.logger)
+}
+
+// applyRegisteredCommands makes the webhook dispatcher use the current set
+// of registered commands
+func (s *Server) applyRegisteredCommands() {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.config = s.withRegisteredCommands(s.baseConfig)
+	s.webhook.SetConfig(&s.config.Webhook)
+}
+
+// registerCommand validates, stores and applies a command
+func (s *Server) registerCommand(name string, req CommandRequest, registeredBy string) error {
+	if s.commands == nil {
+		return errCommandsDisabled
+	}
+	if err := req.validate(name, &s.cfg().Webhook); err != nil {
+		return err
+	}
+	// Dispatches would be refused as well, a destination that does not
+	// resolve yet is left to them
+	if err := s.webhook.CheckDestination(context.Background(), req.URL); errors.Is(err, webhook.ErrEgressDenied) {
+		return err
+	}
+	s.configMutex.RLock()
+	configured := isConfiguredCommand(s.baseConfig, name)
+	s.configMutex.RUnlock()
+	if configured {
+		return fmt.Errorf("%w: %s", errCommandConfigured, name)
+	}
+
+	cmd := RegisteredCommand{
+		Name:         name,
+		URL:          req.URL,
+		Template:     req.Template,
+		JQSelector:   req.JQSelector,
+		RegisteredBy: registeredBy,
+		RegisteredAt: time.Now().UTC(),
+	}
+	if err := s.commands.put(cmd); err != nil {
+		return err
+	}
+	s.applyRegisteredCommands()
+	s.logger.Info("Registered command %s -> %s (by %s)", name, req.URL, registeredBy)
+	return nil
+}
+
+// unregisterCommand removes a registered command. It reports whether the
+// command was registered.
+func (s *Server) unregisterCommand(name string) (bool, error) {
+	if s.commands == nil {
+		return false, errCommandsDisabled
+	}
+	removed, err := s.commands.remove(name)
+	if err != nil || !removed {
+		return removed, err
+	}
+	s.applyRegisteredCommands()
+	s.logger.Info("Unregistered command %s", name)
+	return true, nil
+}
+
+func (s *Server) handleAdminListCommands(w http.ResponseWriter, r *http.Request) {
+	if s.commands == nil {
+		http.Error(w, errCommandsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CommandsResponse{Commands: s.commands.list()})
+}
+
+func (s *Server) handleAdminRegisterCommand(w http.ResponseWriter, r *http.Request) {
+	if s.commands == nil {
+		http.Error(w, errCommandsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	name := chi.URLParam(r, "name")
+
+	var req CommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Invalid JSON in request: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(name, &s.cfg().Webhook); err != nil {
+		s.logger.Error("Invalid command registration: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.registerCommand(name, req, "admin API"); err != nil {
+		s.logger.Error("Failed to register command %s: %v", name, err)
+		switch {
+		case errors.Is(err, errCommandConfigured):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, webhook.ErrEgressDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "registered", Message: fmt.Sprintf("Command %s dispatches to %s", name, req.URL)})
+}
+
+func (s *Server) handleAdminUnregisterCommand(w http.ResponseWriter, r *http.Request) {
+	if s.commands == nil {
+		http.Error(w, errCommandsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	name := chi.URLParam(r, "name")
+	removed, err := s.unregisterCommand(name)
+	switch {
+	case err != nil:
+		s.logger.Error("Failed to unregister command %s: %v", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case !removed:
+		http.Error(w, "Unknown command", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminResponse{Status: "unregistered", Message: fmt.Sprintf("Command %s was removed", name)})
+}
+
+// isAdminCommand reports whether the message is /addcommand or /removecommand
+func isAdminCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && (fields[0] == "/addcommand" || fields[0] == "/removecommand")
+}
+
+// handleAdminCommand lets the users in server.admin_users manage registered
+// commands from the room:
+// /addcommand <name> <url> [jq selector]
+// /removecommand <name>
+func (s *Server) handleAdminCommand(ctx context.Context, sender id.UserID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	roomID := replyRoom(ctx)
+	if !s.isAdminUser(sender) {
+		s.logger.Ctx(ctx).Warn("User %s is not an admin, refusing %s", sender, strings.Fields(message)[0])
+		reply(s.text(roomID, "commands.admin_only"))
+		return
+	}
+
+	fields := strings.Fields(message)
+	switch {
+	case fields[0] == "/addcommand" && len(fields) >= 3:
+		req := CommandRequest{URL: fields[2], JQSelector: strings.Join(fields[3:], " ")}
+		if err := s.registerCommand(fields[1], req, string(sender)); err != nil {
+			reply(s.text(roomID, "commands.register_failed", fields[1], err))
+			return
+		}
+		reply(s.text(roomID, "commands.registered", fields[1], req.URL))
+	case fields[0] == "/removecommand" && len(fields) == 2:
+		removed, err := s.unregisterCommand(fields[1])
+		switch {
+		case err != nil:
+			reply(s.text(roomID, "commands.remove_failed", fields[1], err))
+		case !removed:
+			reply(s.text(roomID, "commands.not_registered", fields[1]))
+		default:
+			reply(s.text(roomID, "commands.removed", fields[1]))
+		}
+	default:
+		reply(s.text(roomID, "commands.usage"))
+	}
+}
+
+// isAdminUser reports whether the user is listed in server.admin_users
+func (s *Server) isAdminUser(userID id.UserID) bool {
+	for _, admin := range s.cfg().Server.AdminUsers {
+		if admin == string(userID) {
+			return true
+		}
+	}
+	return false
+}
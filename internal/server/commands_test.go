@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func TestCommandStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.json")
+	if err := os.WriteFile(path, []byte(`{"commands": [{"name": "status", "url": "http://localhost/status"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backend := storage.NewMemory()
+	store, err := openCommandStore(backend, path)
+	if err != nil {
+		t.Fatalf("openCommandStore() error = %v", err)
+	}
+	if commands := store.list(); len(commands) != 1 || commands[0].Name != "status" {
+		t.Fatalf("commands = %+v, want status imported from the legacy file", commands)
+	}
+	for _, name := range []string{"weather", "deploy"} {
+		if err := store.put(RegisteredCommand{Name: name, URL: "http://localhost/" + name}); err != nil {
+			t.Fatalf("put(%s) error = %v", name, err)
+		}
+	}
+	if removed, err := store.remove("status"); err != nil || !removed {
+		t.Fatalf("remove(status) = %v, %v", removed, err)
+	}
+	if removed, err := store.remove("weather"); err != nil || !removed {
+		t.Fatalf("remove(weather) = %v, %v", removed, err)
+	}
+	if removed, _ := store.remove("weather"); removed {
+		t.Error("remove() of an unregistered command reported removal")
+	}
+
+	reopened, err := openCommandStore(backend, path)
+	if err != nil {
+		t.Fatalf("openCommandStore() error = %v", err)
+	}
+	commands := reopened.list()
+	if len(commands) != 1 || commands[0].Name != "deploy" || commands[0].URL != "http://localhost/deploy" {
+		t.Errorf("reopened store = %+v, want only deploy", commands)
+	}
+}
+
+func TestMergeRegisteredCommands(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Webhook: config.WebhookConfig{
+		Commands: map[string]string{"deploy": "http://config/deploy"},
+	}}
+	merged := mergeRegisteredCommands(cfg, []RegisteredCommand{
+		{Name: "deploy", URL: "http://registered/deploy"},
+		{Name: "weather", URL: "http://registered/weather", Template: `{"q": "{{.MESSAGE}}"}`, JQSelector: ".text"},
+	}, log)
+
+	if got := merged.Webhook.Commands["deploy"]; got != "http://config/deploy" {
+		t.Errorf("deploy = %q, the config file should take precedence", got)
+	}
+	if merged.Webhook.Commands["weather"] != "http://registered/weather" ||
+		merged.Webhook.CommandTemplates["weather"] == "" || merged.Webhook.CommandSelectors["weather"] != ".text" {
+		t.Errorf("weather was not merged: %+v", merged.Webhook)
+	}
+	if _, exists := cfg.Webhook.Commands["weather"]; exists {
+		t.Error("merging modified the base config")
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Server.AdminToken = "secret"
+	cfg.Webhook.Commands = map[string]string{"deploy": "http://localhost/deploy"}
+	s := newAdminTestServer(t, cfg)
+	var err error
+	if s.commands, err = openCommandStore(storage.NewMemory(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(name, body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/admin/commands/"+name, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name, command, body string
+		want                int
+	}{
+		{"registered", "weather", `{"url": "http://localhost/weather", "jq_selector": ".text"}`, http.StatusOK},
+		{"defined in config", "deploy", `{"url": "http://localhost/other"}`, http.StatusConflict},
+		{"invalid name", "we-ather", `{"url": "http://localhost/weather"}`, http.StatusBadRequest},
+		{"relative url", "weather", `{"url": "localhost/weather"}`, http.StatusBadRequest},
+		{"metadata service", "meta", `{"url": "http://169.254.169.254/latest/meta-data"}`, http.StatusForbidden},
+		{"invalid jq", "weather", `{"url": "http://localhost/weather", "jq_selector": ".["}`, http.StatusBadRequest},
+		{"invalid json", "weather", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := put(tt.command, tt.body); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := s.cfg().Webhook.Commands["weather"]; got != "http://localhost/weather" {
+		t.Errorf("registered command is not dispatched, commands = %v", s.cfg().Webhook.Commands)
+	}
+	if rec := adminRequest(s, http.MethodGet, "/admin/commands", "secret"); !strings.Contains(rec.Body.String(), `"name":"weather"`) {
+		t.Errorf("GET /admin/commands = %s", rec.Body.String())
+	}
+
+	// Registered commands survive a reload
+	s.loadConfig = func() (*config.Config, error) { return validTestConfig(), nil }
+	if _, err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, exists := s.cfg().Webhook.Commands["weather"]; !exists {
+		t.Error("registered command was dropped by a reload")
+	}
+
+	if rec := adminRequest(s, http.MethodDelete, "/admin/commands/weather", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("DELETE status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, exists := s.cfg().Webhook.Commands["weather"]; exists {
+		t.Error("unregistered command is still dispatched")
+	}
+	if rec := adminRequest(s, http.MethodDelete, "/admin/commands/weather", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminCommandsDisabled(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{AdminToken: "secret"}})
+	if rec := adminRequest(s, http.MethodGet, "/admin/commands", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Who may confirm a held command, as of confirmations.confirm_by
+const (
+	confirmBySender    = "sender"
+	confirmByPermitted = "permitted"
+	confirmByAdmins    = "admins"
+)
+
+// confirmationQuoteLength is where the message quoted in the summary is cut
+// off
+const confirmationQuoteLength = 200
+
+type confirmedKey struct{}
+
+// withConfirmed marks a message as confirmed, so that it is run rather than
+// held again
+func withConfirmed(ctx context.Context) context.Context {
+	return context.WithValue(ctx, confirmedKey{}, true)
+}
+
+// confirmed reports whether ctx carries a confirmed message
+func confirmed(ctx context.Context) bool {
+	ok, _ := ctx.Value(confirmedKey{}).(bool)
+	return ok
+}
+
+// pendingConfirmation is a message held until a user confirms its command
+type pendingConfirmation struct {
+	ctx        context.Context // Passed to processMessage, run again with it
+	roomID     id.RoomID
+	sender     id.UserID
+	command    string
+	message    string
+	inReplyTo  id.EventID
+	threadRoot id.EventID
+	eventID    id.EventID
+	heldAt     time.Time
+	timer      *time.Timer // Expires the confirmation
+}
+
+// pendingConfirmations are the held messages by the event ID of their
+// summary
+type pendingConfirmations struct {
+	mu       sync.Mutex
+	byPrompt map[id.EventID]*pendingConfirmation
+}
+
+func (p *pendingConfirmations) add(promptID id.EventID, pending *pendingConfirmation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byPrompt == nil {
+		p.byPrompt = make(map[id.EventID]*pendingConfirmation)
+	}
+	p.byPrompt[promptID] = pending
+}
+
+// take returns and forgets the message held by the summary promptID if may
+// allows it, so that it is confirmed once. Without promptID, the message
+// held last in the room that may allows is taken; without roomID, the room
+// is not checked. exists reports whether there was a held message at all,
+// also one that may refused.
+func (p *pendingConfirmations) take(roomID id.RoomID, promptID id.EventID, may func(*pendingConfirmation) bool) (taken *pendingConfirmation, exists bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if promptID == "" {
+		for candidateID, pending := range p.byPrompt {
+			if pending.roomID != roomID {
+				continue
+			}
+			exists = true
+			if may(pending) && (taken == nil || pending.heldAt.After(taken.heldAt)) {
+				taken, promptID = pending, candidateID
+			}
+		}
+	} else if pending, held := p.byPrompt[promptID]; held && (roomID == "" || pending.roomID == roomID) {
+		exists = true
+		if may(pending) {
+			taken = pending
+		}
+	}
+	if taken != nil {
+		delete(p.byPrompt, promptID)
+	}
+	return taken, exists
+}
+
+// confirmationReply reports whether a message is one of replies, ignoring
+// case, the quote of the replied-to message and a leading mention such as
+// "bot: " or "@bot "
+func confirmationReply(message string, reply bool, replies []string) bool {
+	text := strings.TrimSpace(stripQuotes(message, reply, false))
+	if _, answer, mentioned := strings.Cut(text, ": "); mentioned {
+		text = answer
+	}
+	if fields := strings.Fields(text); len(fields) == 2 && strings.HasPrefix(fields[0], "@") {
+		text = fields[1]
+	}
+	text = strings.TrimRight(strings.TrimSpace(text), ".!")
+	for _, r := range replies {
+		if strings.EqualFold(text, strings.TrimSpace(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmationCommand returns the command of a message as far as it is
+// known before plugins and route scripts: its leading /command or else, in
+// command execution mode, the command after the prefix
+func (s *Server) confirmationCommand(message string) string {
+	if command := leadingCommand(message); command != "" {
+		return command
+	}
+	command, _ := s.webhook.GetCommandFromPrefix(message)
+	return command
+}
+
+// mayConfirm reports whether user may confirm the held message as of
+// confirmations.confirm_by
+func (s *Server) mayConfirm(pending *pendingConfirmation, user id.UserID) bool {
+	if s.isAdminUser(user) {
+		return true
+	}
+	cfg := s.cfg()
+	switch cfg.Confirmations.ConfirmBy {
+	case confirmBySender:
+		return user == pending.sender
+	case confirmByAdmins:
+		return false
+	default: // confirmByPermitted
+		return user == pending.sender || cfg.Permissions.Allows(string(user), pending.command)
+	}
+}
+
+// holdForConfirmation holds a message whose command is in
+// confirmations.commands, replying with a summary that says how to confirm
+// it, unless origin, the context processMessage was called with, carries a
+// confirmed message. message is held as received, before inbound_transforms.
+// It reports whether the message was held.
+func (s *Server) holdForConfirmation(ctx, origin context.Context, roomID id.RoomID, sender id.UserID, command, message string, inReplyToEventID, threadRootEventID, eventID id.EventID) bool {
+	cfg := s.cfg().Confirmations
+	if confirmed(origin) || !cfg.Requires(command) {
+		return false
+	}
+	log := s.logger.Ctx(ctx)
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	quote := "> " + strings.ReplaceAll(abbreviate(confirmationQuoteLength, strings.TrimSpace(message)), "\n", "\n> ")
+	summary := s.text(roomID, "confirmation.prompt", command, quote, cfg.Reaction, cfg.Replies[0], timeout)
+	promptID := s.sendReply(ctx, summary, sender, threadRootEventID)
+	if promptID == "" {
+		log.Warn("Not running /%s of %s, the confirmation could not be asked for", command, sender)
+		return true
+	}
+	if _, err := s.matrix.SendReaction(promptID, cfg.Reaction, matrix.WithRoom(roomID), matrix.WithLogContext(ctx)); err != nil {
+		log.Warn("Failed to react to the confirmation of /%s: %v", command, err)
+	}
+
+	pending := &pendingConfirmation{
+		ctx:        origin,
+		roomID:     roomID,
+		sender:     sender,
+		command:    command,
+		message:    message,
+		inReplyTo:  inReplyToEventID,
+		threadRoot: threadRootEventID,
+		eventID:    eventID,
+		heldAt:     time.Now(),
+	}
+	pending.timer = time.AfterFunc(timeout, func() { s.expireConfirmation(promptID, timeout) })
+	s.confirmations.add(promptID, pending)
+	log.Info("Holding /%s of %s until it is confirmed within %s", command, sender, timeout)
+	return true
+}
+
+// expireConfirmation tells the sender of a message that was not confirmed
+// in time that it will not run
+func (s *Server) expireConfirmation(promptID id.EventID, timeout time.Duration) {
+	pending, _ := s.confirmations.take("", promptID, func(*pendingConfirmation) bool { return true })
+	if pending == nil {
+		return
+	}
+	s.logger.Info("Dropping /%s of %s, it was not confirmed within %s", pending.command, pending.sender, timeout)
+	ctx := withReplyRoom(pending.ctx, pending.roomID)
+	s.sendReply(ctx, s.text(pending.roomID, "confirmation.expired", pending.command, timeout), pending.sender, pending.threadRoot)
+}
+
+// runConfirmed processes a held message again, now that user confirmed it
+func (s *Server) runConfirmed(pending *pendingConfirmation, user id.UserID) {
+	pending.timer.Stop()
+	s.logger.Info("/%s of %s was confirmed by %s, running it", pending.command, pending.sender, user)
+	s.processMessage(withConfirmed(pending.ctx), pending.roomID, pending.sender, pending.message, pending.inReplyTo, pending.threadRoot, pending.eventID)
+}
+
+// handleConfirmationReply runs the held message a reply such as "yes"
+// confirms: the one replied to or else the one held last in the room. It
+// reports whether the message was a confirmation, also a refused one.
+func (s *Server) handleConfirmationReply(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID, threadRootEventID id.EventID) bool {
+	cfg := s.cfg().Confirmations
+	if len(cfg.Commands) == 0 || !confirmationReply(message, inReplyToEventID != "", cfg.Replies) {
+		return false
+	}
+	may := func(pending *pendingConfirmation) bool { return s.mayConfirm(pending, sender) }
+	pending, exists := s.confirmations.take(roomID, inReplyToEventID, may)
+	if !exists && inReplyToEventID != "" {
+		// A reply to another message may still confirm the last one held
+		pending, exists = s.confirmations.take(roomID, "", may)
+	}
+	if !exists {
+		return false
+	}
+	if pending == nil {
+		s.logger.Ctx(ctx).Info("Ignoring confirmation of %s, who may not confirm the held command", sender)
+		s.sendReply(ctx, s.text(roomID, "confirmation.not_allowed", sender), sender, threadRootEventID)
+		return true
+	}
+	s.runConfirmed(pending, sender)
+	return true
+}
+
+// handleConfirmationReaction runs the held message when a user of the room
+// reacts to its summary with confirmations.reaction
+func (s *Server) handleConfirmationReaction(evt *event.Event) {
+	cfg := s.cfg()
+	if evt.Type != event.EventReaction || evt.Sender == id.UserID(cfg.Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsReaction()
+	if content == nil || !pageReaction(content.RelatesTo.Key, cfg.Confirmations.Reaction) {
+		return
+	}
+	if !s.roomSettings(evt.RoomID).AllowsUser(string(evt.Sender)) {
+		s.logger.Info("Ignoring confirmation of %s, who is not in the allowed users of room %s", evt.Sender, evt.RoomID)
+		return
+	}
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring confirmation of %s", evt.Sender)
+		return
+	}
+	pending, exists := s.confirmations.take(evt.RoomID, content.RelatesTo.EventID, func(pending *pendingConfirmation) bool { return s.mayConfirm(pending, evt.Sender) })
+	if !exists {
+		return
+	}
+	if pending == nil {
+		s.logger.Info("Ignoring confirmation of %s, who may not confirm the held command", evt.Sender)
+		return
+	}
+	// Running the command must not hold up the sync
+	go s.runConfirmed(pending, evt.Sender)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestConfirmationReply(t *testing.T) {
+	replies := []string{"yes", "Ja"}
+	tests := []struct {
+		message string
+		reply   bool
+		want    bool
+	}{
+		{"yes", false, true},
+		{"  YES! ", false, true},
+		{"ja", false, true},
+		{"Mule Bot: yes", false, true},
+		{"@mule yes", false, true},
+		{"> <@mule:example.com> /deploy needs a confirmation\n\nyes", true, true},
+		{"yes please deploy", false, false},
+		{"no", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := confirmationReply(tt.message, tt.reply, replies); got != tt.want {
+			t.Errorf("confirmationReply(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestPendingConfirmationsTake(t *testing.T) {
+	var pending pendingConfirmations
+	anyone := func(*pendingConfirmation) bool { return true }
+	now := time.Now()
+	pending.add("$first", &pendingConfirmation{roomID: "!a:example.com", command: "deploy", heldAt: now.Add(-time.Minute)})
+	pending.add("$second", &pendingConfirmation{roomID: "!a:example.com", command: "restore", heldAt: now})
+	pending.add("$other", &pendingConfirmation{roomID: "!b:example.com", command: "deploy", heldAt: now})
+
+	if taken, exists := pending.take("!b:example.com", "$first", anyone); taken != nil || exists {
+		t.Errorf("take() of a summary of another room = %v, %v", taken, exists)
+	}
+	if taken, exists := pending.take("!a:example.com", "$first", func(*pendingConfirmation) bool { return false }); taken != nil || !exists {
+		t.Errorf("take() refused = %v, %v, want nothing taken of an existing one", taken, exists)
+	}
+	if taken, _ := pending.take("!a:example.com", "", anyone); taken == nil || taken.command != "restore" {
+		t.Errorf("take() without summary = %+v, want the one held last", taken)
+	}
+	if taken, _ := pending.take("", "$first", anyone); taken == nil || taken.command != "deploy" {
+		t.Errorf("take() = %+v, want the one of the summary", taken)
+	}
+	if taken, exists := pending.take("!a:example.com", "", anyone); taken != nil || exists {
+		t.Errorf("take() of a room without held messages = %v, %v", taken, exists)
+	}
+}
+
+func TestMayConfirm(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Server:      config.ServerConfig{AdminUsers: []string{"@admin:example.com"}},
+		Permissions: config.PermissionsConfig{Rules: []config.PermissionRule{{Users: []string{"@ops:*"}, Commands: []string{"deploy"}}}},
+	}
+	s := &Server{config: cfg, logger: log}
+	pending := &pendingConfirmation{sender: "@ops:example.com", command: "deploy"}
+
+	tests := []struct {
+		confirmBy string
+		user      id.UserID
+		want      bool
+	}{
+		{confirmByPermitted, "@ops:example.com", true},
+		{confirmByPermitted, "@ops:other.org", true},
+		{confirmByPermitted, "@bob:example.com", false},
+		{confirmByPermitted, "@admin:example.com", true},
+		{confirmBySender, "@ops:example.com", true},
+		{confirmBySender, "@ops:other.org", false},
+		{confirmByAdmins, "@ops:example.com", false},
+		{confirmByAdmins, "@admin:example.com", true},
+	}
+	for _, tt := range tests {
+		cfg.Confirmations.ConfirmBy = tt.confirmBy
+		if got := s.mayConfirm(pending, tt.user); got != tt.want {
+			t.Errorf("mayConfirm(%s) with confirm_by %s = %v, want %v", tt.user, tt.confirmBy, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmedCommandIsDispatched(t *testing.T) {
+	dispatched := make(chan struct{}, 2)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatched <- struct{}{}
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			Default:       target.URL,
+			Template:      `{"message": "{{.MESSAGE}}"}`,
+			ReplyScript:   `return ""`, // No Matrix client to send the summary with
+			ScriptTimeout: 100,
+		},
+		Confirmations: config.ConfirmationsConfig{Commands: []string{"deploy"}, Timeout: 60, Reaction: "✅", Replies: []string{"yes"}, ConfirmBy: confirmBySender},
+	}
+	scripts, err := compileHookScripts(&cfg.Webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), scripts: scripts}
+
+	room, sender := id.RoomID("!room:example.com"), id.UserID("@ops:example.com")
+	s.HandleMessage(room, sender, "/deploy prod", "", "", "$deploy")
+	if len(dispatched) != 0 {
+		t.Fatal("Command needing a confirmation was dispatched")
+	}
+
+	// The summary could not be sent without a Matrix client, so the message
+	// is held here as if it was
+	s.confirmations.add("$summary", &pendingConfirmation{ctx: context.Background(), roomID: room, sender: sender, command: "deploy", message: "/deploy prod", eventID: "$deploy", heldAt: time.Now(), timer: time.NewTimer(time.Hour)})
+	s.HandleMessage(room, "@bob:example.com", "yes", "$summary", "", "$refused")
+	if len(dispatched) != 0 {
+		t.Fatal("Command confirmed by a user who may not confirm it was dispatched")
+	}
+	s.HandleMessage(room, sender, "yes", "$summary", "", "$confirmed")
+	if len(dispatched) != 1 {
+		t.Errorf("%d dispatches, want the confirmed command", len(dispatched))
+	}
+	if _, exists := s.confirmations.take(room, "$summary", func(*pendingConfirmation) bool { return true }); exists {
+		t.Error("Confirmed command is still held")
+	}
+}
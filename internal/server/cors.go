@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cors adds CORS headers for the configured origins and answers preflight
+// requests, so that browser-based tools can call the API directly. The
+// config is looked up per request so that reloads apply.
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := &s.cfg().Server.CORS
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(cfg.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !containsOrWildcard(cfg.AllowedOrigins, origin, true) {
+			if preflight {
+				s.logger.Warn("Rejecting CORS preflight from origin %s", origin)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// Without CORS headers the browser hides the response
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(cfg.AllowedOrigins, "*") && !cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(cfg.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !containsOrWildcard(cfg.AllowedMethods, method, false) {
+			s.logger.Warn("Rejecting CORS preflight from origin %s for method %s", origin, method)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		headers := requestedHeaders(r)
+		for _, header := range headers {
+			if !containsOrWildcard(cfg.AllowedHeaders, header, false) {
+				s.logger.Warn("Rejecting CORS preflight from origin %s for header %s", origin, header)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", method)
+		if len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// requestedHeaders returns the headers a preflight request asks to send
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, http.CanonicalHeaderKey(header))
+			}
+		}
+	}
+	return headers
+}
+
+// containsOrWildcard reports whether value is in the list or the list
+// contains "*". Origins are compared exactly, methods and headers ignoring case.
+func containsOrWildcard(list []string, value string, exact bool) bool {
+	for _, item := range list {
+		if item == "*" || item == value || (!exact && strings.EqualFold(item, value)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func newCORSTestServer(cors config.CORSConfig) *Server {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Server: config.ServerConfig{CORS: cors}}
+	s := &Server{config: cfg, router: chi.NewRouter(), logger: log}
+	s.routes()
+	return s
+}
+
+func corsRequest(s *Server, method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/health", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSDisabled(t *testing.T) {
+	s := newCORSTestServer(config.CORSConfig{})
+
+	rec := corsRequest(s, http.MethodGet, "https://tools.example.com", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q without CORS config", origin)
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	s := newCORSTestServer(config.CORSConfig{
+		AllowedOrigins: []string{"https://tools.example.com"},
+		ExposedHeaders: []string{"Retry-After"},
+	})
+
+	rec := corsRequest(s, http.MethodGet, "https://tools.example.com", nil)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://tools.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", origin)
+	}
+	if exposed := rec.Header().Get("Access-Control-Expose-Headers"); exposed != "Retry-After" {
+		t.Errorf("Access-Control-Expose-Headers = %q, want Retry-After", exposed)
+	}
+
+	rec = corsRequest(s, http.MethodGet, "https://evil.example.com", nil)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q for an origin that is not allowed", origin)
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	s := newCORSTestServer(config.CORSConfig{AllowedOrigins: []string{"*"}})
+	rec := corsRequest(s, http.MethodGet, "https://any.example.com", nil)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", origin)
+	}
+
+	// Credentials cannot be used with a literal wildcard
+	s = newCORSTestServer(config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	rec = corsRequest(s, http.MethodGet, "https://any.example.com", nil)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://any.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", origin)
+	}
+	if credentials := rec.Header().Get("Access-Control-Allow-Credentials"); credentials != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", credentials)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newCORSTestServer(config.CORSConfig{
+		AllowedOrigins: []string{"https://tools.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         600,
+	})
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		want    int
+	}{
+		{"allowed", "https://tools.example.com", "POST", "content-type, authorization", http.StatusNoContent},
+		{"unknown origin", "https://evil.example.com", "POST", "", http.StatusForbidden},
+		{"method not allowed", "https://tools.example.com", "DELETE", "", http.StatusForbidden},
+		{"header not allowed", "https://tools.example.com", "POST", "X-Custom", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Access-Control-Request-Method": tt.method}
+			if tt.headers != "" {
+				headers["Access-Control-Request-Headers"] = tt.headers
+			}
+			rec := corsRequest(s, http.MethodOptions, tt.origin, headers)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusNoContent {
+				return
+			}
+			if methods := rec.Header().Get("Access-Control-Allow-Methods"); methods != "POST" {
+				t.Errorf("Access-Control-Allow-Methods = %q, want POST", methods)
+			}
+			if allowed := rec.Header().Get("Access-Control-Allow-Headers"); allowed != "Content-Type, Authorization" {
+				t.Errorf("Access-Control-Allow-Headers = %q, want Content-Type, Authorization", allowed)
+			}
+			if maxAge := rec.Header().Get("Access-Control-Max-Age"); maxAge != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", maxAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is returned by GET /debug/runtime
+type RuntimeStats struct {
+	GoVersion  string      `json:"go_version"`
+	Uptime     string      `json:"uptime"`
+	NumCPU     int         `json:"num_cpu"`
+	GOMAXPROCS int         `json:"gomaxprocs"`
+	Goroutines int         `json:"goroutines"`
+	Memory     MemoryStats `json:"memory"`
+	GC         GCStats     `json:"gc"`
+}
+
+// MemoryStats is a subset of runtime.MemStats, in bytes
+type MemoryStats struct {
+	Alloc       uint64 `json:"alloc"`
+	TotalAlloc  uint64 `json:"total_alloc"`
+	Sys         uint64 `json:"sys"`
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapInuse   uint64 `json:"heap_inuse"`
+	HeapObjects uint64 `json:"heap_objects"`
+	StackInuse  uint64 `json:"stack_inuse"`
+}
+
+// GCStats summarizes garbage collector activity
+type GCStats struct {
+	NumGC       uint32  `json:"num_gc"`
+	LastGC      string  `json:"last_gc,omitempty"`
+	LastPause   string  `json:"last_pause"`
+	PauseTotal  string  `json:"pause_total"`
+	NextGC      uint64  `json:"next_gc"`
+	CPUFraction float64 `json:"cpu_fraction"`
+}
+
+// collectRuntimeStats reads the current runtime statistics. ReadMemStats
+// stops the world briefly, which is fine for an on-demand debug endpoint.
+func collectRuntimeStats(startedAt time.Time) RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(startedAt).Round(time.Second).String(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemoryStats{
+			Alloc:       mem.Alloc,
+			TotalAlloc:  mem.TotalAlloc,
+			Sys:         mem.Sys,
+			HeapAlloc:   mem.HeapAlloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			StackInuse:  mem.StackInuse,
+		},
+		GC: GCStats{
+			NumGC:       mem.NumGC,
+			LastPause:   time.Duration(mem.PauseNs[(mem.NumGC+255)%256]).String(),
+			PauseTotal:  time.Duration(mem.PauseTotalNs).String(),
+			NextGC:      mem.NextGC,
+			CPUFraction: mem.GCCPUFraction,
+		},
+	}
+	if mem.LastGC != 0 {
+		stats.GC.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("Runtime debug endpoint called")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(collectRuntimeStats(s.startedAt))
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func newDebugTestServer(enableDebug bool) *Server {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Server: config.ServerConfig{EnableDebug: enableDebug}}
+	s := &Server{config: cfg, router: chi.NewRouter(), logger: log, startedAt: time.Now()}
+	s.routes()
+	return s
+}
+
+func TestDebugEndpointsDisabledByDefault(t *testing.T) {
+	s := newDebugTestServer(false)
+
+	for _, path := range []string{"/debug/runtime", "/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDebugEndpointsEnabled(t *testing.T) {
+	s := newDebugTestServer(true)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/runtime", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/runtime status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var stats RuntimeStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode runtime stats: %v", err)
+	}
+	if stats.Goroutines == 0 || stats.Memory.Sys == 0 || stats.GoVersion == "" {
+		t.Errorf("runtime stats look empty: %+v", stats)
+	}
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /debug/pprof/ status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+// Scopes of dedup besides the delivered dispatches of the webhook package
+const (
+	dedupScopeEvents = "events"
+	dedupScopeHooks  = "hooks"
+)
+
+// dedupScope returns the scope of the name with the TTL of cfg, nil if it
+// is disabled
+func (s *Server) dedupScope(name string, cfg config.DedupScopeConfig) *dedup.Scope {
+	if s.dedup == nil || !cfg.Enabled {
+		return nil
+	}
+	return s.dedup.Scope(name, "dedup."+name+"/", time.Duration(cfg.TTL)*time.Second)
+}
+
+// duplicateEvent reports whether the Matrix event was handled before, e.g.
+// when the sync replays it after a restart, and remembers it otherwise
+func (s *Server) duplicateEvent(eventID id.EventID) bool {
+	scope := s.dedupScope(dedupScopeEvents, s.cfg().Dedup.Events)
+	if scope == nil || eventID == "" {
+		return false
+	}
+	duplicate, err := scope.Seen(context.Background(), string(eventID))
+	if err != nil {
+		s.logger.Error("Failed to check whether event %s was handled, handling it: %v", eventID, err)
+		return false
+	}
+	return duplicate
+}
+
+// hookDeliveryKey identifies a delivery to a hook by its path, its
+// Authorization header and its Idempotency-Key header or else its body. The
+// body is left for the handler to read.
+func hookDeliveryKey(r *http.Request) (string, error) {
+	source := r.URL.Path + "\n" + r.Header.Get("Authorization") + "\n"
+	if key := r.Header.Get(webhook.IdempotencyHeader); key != "" {
+		return webhook.IdempotencyKey(source + "key:" + key), nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return webhook.IdempotencyKey(source + "body:" + string(body)), nil
+}
+
+// dedupHooks answers deliveries to hooks that were handled before with the
+// duplicate status instead of handling them again, if dedup.hooks.enabled.
+// A delivery is remembered once its handler answered with 2xx.
+func (s *Server) dedupHooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := s.dedupScope(dedupScopeHooks, s.cfg().Dedup.Hooks)
+		if scope == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := hookDeliveryKey(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		claimed, err := scope.Claim(r.Context(), key)
+		if err != nil {
+			s.logger.Error("Failed to check whether the delivery to %s was handled, handling it: %v", r.URL.Path, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !claimed {
+			s.logger.Info("Skipping delivery to %s, it was handled before", r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(SendResponse{Status: "duplicate"})
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if err := scope.Release(key, status == 0 || (status >= 200 && status < 300)); err != nil {
+			s.logger.Error("Failed to record the delivery to %s: %v", r.URL.Path, err)
+		}
+	})
+}
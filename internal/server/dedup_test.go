@@ -0,0 +1,78 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestDedupHooks(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Dedup: config.DedupConfig{Hooks: config.DedupScopeConfig{Enabled: true, TTL: 600}}}
+	s := &Server{config: cfg, logger: log, dedup: dedup.New(nil, 0, log)}
+
+	var handled []string
+	status := http.StatusOK
+	handler := s.dedupHooks(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		handled = append(handled, string(body))
+		w.WriteHeader(status)
+	}))
+	deliver := func(body, idempotencyKey string) string {
+		req := httptest.NewRequest(http.MethodPost, "/hook/deploy", strings.NewReader(body))
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	deliver(`{"n": 1}`, "")
+	if reply := deliver(`{"n": 1}`, ""); !strings.Contains(reply, `"duplicate"`) {
+		t.Errorf("Second delivery of the same body = %q, want the duplicate status", reply)
+	}
+	deliver(`{"n": 2}`, "k1")
+	deliver(`{"n": 3}`, "k1")
+	// Deliveries that failed are handled again
+	status = http.StatusInternalServerError
+	deliver(`{"n": 4}`, "")
+	status = http.StatusOK
+	deliver(`{"n": 4}`, "")
+
+	want := []string{`{"n": 1}`, `{"n": 2}`, `{"n": 4}`, `{"n": 4}`}
+	if strings.Join(handled, " ") != strings.Join(want, " ") {
+		t.Errorf("Handled %q, want %q", handled, want)
+	}
+
+	var b strings.Builder
+	writeDedupMetrics(&b, s.dedup.Stats())
+	for _, metric := range []string{`matrix_dedup_hits_total{scope="hooks"} 2`, `matrix_dedup_misses_total{scope="hooks"} 4`, `matrix_dedup_entries{scope="hooks"} 3`} {
+		if !strings.Contains(b.String(), metric) {
+			t.Errorf("Metrics missing %q:\n%s", metric, b.String())
+		}
+	}
+}
+
+func TestDuplicateEvent(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{}
+	s := &Server{config: cfg, logger: log, dedup: dedup.New(nil, 0, log)}
+	if s.duplicateEvent("$event") || s.duplicateEvent("$event") {
+		t.Error("duplicateEvent() with dedup.events disabled = true")
+	}
+
+	cfg.Dedup.Events = config.DedupScopeConfig{Enabled: true, TTL: 60}
+	if s.duplicateEvent("$event") {
+		t.Error("duplicateEvent() of a new event = true")
+	}
+	if !s.duplicateEvent("$event") {
+		t.Error("duplicateEvent() of a handled event = false")
+	}
+}
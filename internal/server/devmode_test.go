@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestDispatchMockResponses(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	dir := t.TempDir()
+	cfg := &config.WebhookConfig{
+		Default:    "http://backend.invalid/default",
+		Commands:   map[string]string{"deploy": "http://backend.invalid/deploy"},
+		AuthTokens: map[string]string{"deploy": "secret"},
+		Template:   `{"message": {{json .MESSAGE}}}`,
+		JQSelector: ".text",
+		Timeout:    5,
+		Dev: config.WebhookDevConfig{
+			RecordDir: dir,
+			Mock:      true,
+			MockResponses: map[string]config.MockResponseConfig{
+				"deploy": {Body: `{"text": {{json (printf "%s to %s" .BODY.message .COMMAND)}}}`},
+			},
+			DefaultMockResponse: config.MockResponseConfig{Status: http.StatusServiceUnavailable, Body: `{"error": "down"}`},
+		},
+	}
+	d := webhook.New(cfg, nil, log)
+	ctx := webhook.NewOriginContext(context.Background(), "!room:example.com", "@ops:example.com")
+
+	reply, err := d.Dispatch(ctx, "/deploy prod", "deploy")
+	if err != nil || reply != "/deploy prod to deploy" {
+		t.Errorf("Dispatch() = %q, %v, want the mock response", reply, err)
+	}
+	var statusErr *webhook.StatusError
+	if _, err := d.Dispatch(ctx, "hello", ""); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Dispatch() of the default webhook error = %v, want the mocked 503", err)
+	}
+
+	recordings, err := webhook.LoadRecordings(dir)
+	if err != nil || len(recordings) != 2 {
+		t.Fatalf("LoadRecordings() = %d recordings, %v, want 2", len(recordings), err)
+	}
+	rec := recordings[0]
+	if rec.Command != "deploy" || rec.RoomID != "!room:example.com" || rec.Sender != "@ops:example.com" || rec.Reply != "/deploy prod to deploy" {
+		t.Errorf("First recording = %+v", rec)
+	}
+	if len(rec.Exchanges) != 1 || !rec.Exchanges[0].Mocked || rec.Exchanges[0].Headers["Authorization"] != "[redacted]" || rec.Exchanges[0].Body != `{"message": "/deploy prod"}` {
+		t.Errorf("First recording exchanges = %+v", rec.Exchanges)
+	}
+	if recordings[1].Error == "" || recordings[1].Exchanges[0].Status != http.StatusServiceUnavailable {
+		t.Errorf("Second recording = %+v, want the failed dispatch", recordings[1])
+	}
+	if diffs := recordings[1].Compare(webhook.NewReplayer(cfg, log).Replay(context.Background(), recordings[1])); len(diffs) != 0 {
+		t.Errorf("Compare() of the replayed failure = %+v", diffs)
+	}
+}
+
+func TestReplayRecordings(t *testing.T) {
+	requests := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"status": "deployed", "items": [1, 2]}`)
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	dir := t.TempDir()
+	cfg := config.WebhookConfig{
+		Commands:   map[string]string{"deploy": target.URL + "/deploy"},
+		Template:   `{"message": {{json .MESSAGE}}}`,
+		JQSelector: ".status",
+		Timeout:    5,
+		Dev:        config.WebhookDevConfig{RecordDir: dir},
+	}
+	if _, err := webhook.New(&cfg, nil, log).Dispatch(context.Background(), "/deploy prod", "deploy"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	recordings, err := webhook.LoadRecordings(dir)
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("LoadRecordings() = %d recordings, %v, want 1", len(recordings), err)
+	}
+
+	// The same config replays the same dispatch
+	unchanged := webhook.NewReplayer(&cfg, log).Replay(context.Background(), recordings[0])
+	if diffs := recordings[0].Compare(unchanged); len(diffs) != 0 {
+		t.Errorf("Compare() with the same config = %+v", diffs)
+	}
+
+	changed := cfg
+	changed.Template = `{"text": {{json .MESSAGE}}}`
+	changed.CommandSelectors = map[string]string{"deploy": ".items | length"}
+	replayed := webhook.NewReplayer(&changed, log).Replay(context.Background(), recordings[0])
+	diffs := recordings[0].Compare(replayed)
+	want := []webhook.Difference{
+		{Field: "request 1 body", Recorded: `{"message":"/deploy prod"}`, Replayed: `{"text":"/deploy prod"}`},
+		{Field: "reply", Recorded: "deployed", Replayed: "2"},
+	}
+	if fmt.Sprint(diffs) != fmt.Sprint(want) {
+		t.Errorf("Compare() = %+v, want %+v", diffs, want)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests reached the backend, want only the recorded one", requests)
+	}
+}
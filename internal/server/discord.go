@@ -0,0 +1,366 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// DiscordPayload is the body of a Discord "execute webhook" request
+type DiscordPayload struct {
+	Content   string         `json:"content"`
+	Username  string         `json:"username"`
+	AvatarURL string         `json:"avatar_url"`
+	Embeds    []DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed is a rich embed of a Discord message
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	URL         string              `json:"url"`
+	Color       int                 `json:"color"`
+	Timestamp   string              `json:"timestamp"`
+	Author      *DiscordEmbedAuthor `json:"author"`
+	Fields      []DiscordEmbedField `json:"fields"`
+	Footer      *DiscordEmbedFooter `json:"footer"`
+	Image       *DiscordEmbedMedia  `json:"image"`
+	Thumbnail   *DiscordEmbedMedia  `json:"thumbnail"`
+}
+
+// DiscordEmbedAuthor is the author line of an embed
+type DiscordEmbedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// DiscordEmbedField is a name/value pair of an embed
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// DiscordEmbedFooter is the footer line of an embed
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// DiscordEmbedMedia is an image or thumbnail of an embed
+type DiscordEmbedMedia struct {
+	URL string `json:"url"`
+}
+
+var (
+	// <t:1700000000> or <t:1700000000:R>
+	discordTimestampRegex = regexp.MustCompile(`<t:(-?\d+)(?::([tTdDfFR]))?>`)
+	// <:name:id> or <a:name:id> for animated emoji
+	discordEmojiRegex = regexp.MustCompile(`<a?:(\w+):\d+>`)
+)
+
+// discordTimestampLayouts maps the styles of Discord timestamps to layouts
+var discordTimestampLayouts = map[string]string{
+	"t": "15:04 MST",
+	"T": "15:04:05 MST",
+	"d": "2006-01-02",
+	"D": "2 January 2006",
+	"f": "2 January 2006 15:04 MST",
+	"F": "Monday, 2 January 2006 15:04 MST",
+	"R": "2 January 2006 15:04 MST",
+}
+
+// discordColorEmoji are the colored squares embed colors are rounded to
+var discordColorEmoji = []struct {
+	r, g, b int
+	emoji   string
+}{
+	{0xe7, 0x4c, 0x3c, "🟥"},
+	{0xe6, 0x7e, 0x22, "🟧"},
+	{0xf1, 0xc4, 0x0f, "🟨"},
+	{0x2e, 0xcc, 0x71, "🟩"},
+	{0x34, 0x98, 0xdb, "🟦"},
+	{0x9b, 0x59, 0xb6, "🟪"},
+	{0x8b, 0x5a, 0x2b, "🟫"},
+	{0x20, 0x20, 0x20, "⬛"},
+	{0xf0, 0xf0, 0xf0, "⬜"},
+}
+
+// discordColor returns the colored square closest to an embed color, or an
+// empty string for embeds without a color
+func discordColor(color int) string {
+	if color <= 0 {
+		return ""
+	}
+	r, g, b := color>>16&0xff, color>>8&0xff, color&0xff
+	best, bestDistance := "", -1
+	for _, c := range discordColorEmoji {
+		distance := (r-c.r)*(r-c.r) + (g-c.g)*(g-c.g) + (b-c.b)*(b-c.b)
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = c.emoji, distance
+		}
+	}
+	return best
+}
+
+// convertDiscordMarkup replaces the Discord-only markup of a text, timestamps
+// and custom emoji, by plain text
+func convertDiscordMarkup(text string) string {
+	text = discordTimestampRegex.ReplaceAllStringFunc(text, func(match string) string {
+		parts := discordTimestampRegex.FindStringSubmatch(match)
+		seconds, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return match
+		}
+		style := parts[2]
+		if style == "" {
+			style = "f"
+		}
+		return time.Unix(seconds, 0).UTC().Format(discordTimestampLayouts[style])
+	})
+	return discordEmojiRegex.ReplaceAllString(text, ":$1:")
+}
+
+// formatDiscordMessage renders the content and embeds of a payload as markdown
+func formatDiscordMessage(p *DiscordPayload) string {
+	var sections []string
+	if content := strings.TrimSpace(convertDiscordMarkup(p.Content)); content != "" {
+		if p.Username != "" {
+			content = fmt.Sprintf("**%s**: %s", p.Username, content)
+		}
+		sections = append(sections, content)
+	}
+	for i := range p.Embeds {
+		if embed := formatDiscordEmbed(&p.Embeds[i]); embed != "" {
+			sections = append(sections, embed)
+		}
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// formatDiscordEmbed renders an embed as markdown: the title with a square of
+// the embed's color, the author, description, fields, image and footer
+func formatDiscordEmbed(e *DiscordEmbed) string {
+	var lines []string
+
+	title := strings.TrimSpace(convertDiscordMarkup(e.Title))
+	if title != "" {
+		title = "**" + title + "**"
+		if e.URL != "" {
+			title = fmt.Sprintf("[%s](%s)", title, e.URL)
+		}
+	}
+	if square := discordColor(e.Color); square != "" {
+		title = strings.TrimSpace(square + " " + title)
+	}
+	if title != "" {
+		lines = append(lines, title)
+	}
+
+	if e.Author != nil && e.Author.Name != "" {
+		author := e.Author.Name
+		if e.Author.URL != "" {
+			author = fmt.Sprintf("[%s](%s)", author, e.Author.URL)
+		}
+		lines = append(lines, "_"+author+"_")
+	}
+
+	if description := strings.TrimSpace(convertDiscordMarkup(e.Description)); description != "" {
+		lines = append(lines, description)
+	}
+
+	for _, field := range e.Fields {
+		name := strings.TrimSpace(convertDiscordMarkup(field.Name))
+		value := strings.TrimSpace(convertDiscordMarkup(field.Value))
+		switch {
+		case name != "" && value != "":
+			lines = append(lines, fmt.Sprintf("- **%s**: %s", name, value))
+		case name != "" || value != "":
+			lines = append(lines, "- "+name+value)
+		}
+	}
+
+	if e.Image != nil && e.Image.URL != "" {
+		lines = append(lines, fmt.Sprintf("[image](%s)", e.Image.URL))
+	}
+
+	var footer []string
+	if e.Footer != nil && strings.TrimSpace(e.Footer.Text) != "" {
+		footer = append(footer, strings.TrimSpace(convertDiscordMarkup(e.Footer.Text)))
+	}
+	if timestamp, err := time.Parse(time.RFC3339, e.Timestamp); err == nil {
+		footer = append(footer, timestamp.UTC().Format(discordTimestampLayouts["f"]))
+	}
+	if len(footer) > 0 {
+		lines = append(lines, "_"+strings.Join(footer, " · ")+"_")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// hasDiscordToken checks the token of a /hook/discord/{id}/{token} URL, as
+// Discord clients carry the secret in the URL, or else a bearer token
+func hasDiscordToken(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	if provided := chi.URLParam(r, "token"); provided != "" {
+		return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+	}
+	return hasBearerToken(r, token)
+}
+
+// discordRoom picks the room for a message: the room_id query parameter,
+// then the room of the webhook ID in the URL, then the configured room. An
+// empty result means the Matrix client's default.
+func (s *Server) discordRoom(r *http.Request) id.RoomID {
+	if roomID := r.URL.Query().Get("room_id"); roomID != "" {
+		return id.RoomID(roomID)
+	}
+	cfg := s.cfg().Hooks.Discord
+	if roomID, exists := cfg.Rooms[chi.URLParam(r, "id")]; exists {
+		return id.RoomID(roomID)
+	}
+	return id.RoomID(cfg.RoomID)
+}
+
+// readDiscordPayload decodes a JSON body, or the payload_json field of a
+// multipart body, as used by Discord clients that attach files
+func readDiscordPayload(r *http.Request) (*DiscordPayload, error) {
+	var payload DiscordPayload
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return &payload, nil
+	}
+
+	// The route limits the size of the body
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %w", err)
+	}
+	if data := r.FormValue("payload_json"); data != "" {
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			return nil, fmt.Errorf("invalid payload_json: %w", err)
+		}
+	} else {
+		payload.Content = r.FormValue("content")
+		payload.Username = r.FormValue("username")
+	}
+	return &payload, nil
+}
+
+// handleDiscord receives Discord webhook messages and posts them to Matrix,
+// with embeds rendered as markdown and attached files posted as media
+func (s *Server) handleDiscord(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Discord hook called")
+
+	if !hasDiscordToken(r, s.cfg().Hooks.Discord.Token) {
+		s.logger.Warn("Rejecting Discord hook call with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	payload, err := readDiscordPayload(r)
+	if err != nil {
+		s.logger.Error("Invalid Discord payload: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := formatDiscordMessage(payload)
+	var files int
+	if r.MultipartForm != nil {
+		for _, headers := range r.MultipartForm.File {
+			files += len(headers)
+		}
+	}
+	if message == "" && files == 0 {
+		http.Error(w, "Message has no content, embeds or files", http.StatusBadRequest)
+		return
+	}
+
+	roomID := s.discordRoom(r)
+	s.logger.Info("Posting Discord message with %d embeds and %d files to room %q", len(payload.Embeds), files, roomID)
+
+	// Messages with attachments are posted right away, only text is held
+	if files == 0 && s.holdNotification(r.Context(), quiet.Notification{Source: "discord", RoomID: string(roomID), Message: message, Sender: "discord"}) {
+		if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); !wait {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeHeldResponse(w)
+		return
+	}
+
+	var eventID id.EventID
+	if message != "" {
+		eventID, err = s.matrix.SendMessage(message, matrix.WithRoom(roomID), matrix.WithSender("discord"), withRequestID(r))
+		if err != nil {
+			s.logger.Error("Failed to send Discord message to Matrix: %v", err)
+			http.Error(w, "Failed to send message to Matrix", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if files > 0 {
+		cfg := s.cfg()
+		maxSize := cfg.Attachments.Outbound.MaxSize(cfg.Server.MediaMaxSizeMB)
+		// Fields are named files[0], files[1], ...
+		fields := make([]string, 0, len(r.MultipartForm.File))
+		for field := range r.MultipartForm.File {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			for _, header := range r.MultipartForm.File[field] {
+				file, err := header.Open()
+				if err != nil {
+					s.logger.Warn("Failed to read Discord attachment %s: %v", header.Filename, err)
+					continue
+				}
+				data, err := readLimited(file, maxSize)
+				file.Close()
+				if err != nil {
+					s.logger.Warn("Skipping Discord attachment %s: %v", header.Filename, err)
+					continue
+				}
+				mimeType := detectMimeType(header.Header.Get("Content-Type"), header.Filename, data)
+				if err := attachmentTypeError(&cfg.Attachments.Outbound, mimeType); err != nil {
+					s.logger.Warn("Skipping Discord attachment %s: %v", header.Filename, err)
+					continue
+				}
+				mediaEventID, err := s.matrix.SendMedia(data, header.Filename, mimeType, "", matrix.WithRoom(roomID), matrix.WithSender("discord"), withRequestID(r))
+				if err != nil {
+					s.logger.Error("Failed to send Discord attachment to Matrix: %v", err)
+					http.Error(w, "Failed to send attachment to Matrix", http.StatusInternalServerError)
+					return
+				}
+				if eventID == "" {
+					eventID = mediaEventID
+				}
+			}
+		}
+	}
+
+	// Like Discord, only answer with the message when asked to wait for it
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); !wait {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
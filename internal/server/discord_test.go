@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestFormatDiscordMessage(t *testing.T) {
+	body := `{
+		"username": "Deploy bot",
+		"content": "Release started <t:1700000000:t> <:rocket:123456>",
+		"embeds": [{
+			"title": "v1.2.3",
+			"url": "https://ci.example.com/builds/42",
+			"color": 3066993,
+			"author": {"name": "alice"},
+			"description": "All checks passed",
+			"fields": [
+				{"name": "Environment", "value": "production", "inline": true},
+				{"name": "Duration", "value": "3m"}
+			],
+			"image": {"url": "https://ci.example.com/chart.png"},
+			"footer": {"text": "CI"},
+			"timestamp": "2023-11-14T22:13:20.000Z"
+		}]
+	}`
+	var payload DiscordPayload
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	expected := "**Deploy bot**: Release started 22:13 UTC :rocket:\n\n" +
+		"🟩 [**v1.2.3**](https://ci.example.com/builds/42)\n" +
+		"_alice_\n" +
+		"All checks passed\n" +
+		"- **Environment**: production\n" +
+		"- **Duration**: 3m\n" +
+		"[image](https://ci.example.com/chart.png)\n" +
+		"_CI · 14 November 2023 22:13 UTC_"
+	if got := formatDiscordMessage(&payload); got != expected {
+		t.Errorf("formatDiscordMessage() = %q, want %q", got, expected)
+	}
+}
+
+func TestDiscordColor(t *testing.T) {
+	tests := []struct {
+		color    int
+		expected string
+	}{
+		{0, ""},
+		{0xff0000, "🟥"},
+		{0x00ff00, "🟩"},
+		{0x5865f2, "🟦"},
+		{0xffffff, "⬜"},
+	}
+	for _, tt := range tests {
+		if got := discordColor(tt.color); got != tt.expected {
+			t.Errorf("discordColor(%#x) = %q, want %q", tt.color, got, tt.expected)
+		}
+	}
+}
+
+func TestDiscordRoom(t *testing.T) {
+	s := &Server{config: &config.Config{Hooks: config.HooksConfig{Discord: config.DiscordConfig{
+		RoomID: "!discord:matrix.org",
+		Rooms:  map[string]string{"42": "!deploys:matrix.org"},
+	}}}}
+
+	var got []string
+	router := chi.NewRouter()
+	router.Post("/hook/discord", func(w http.ResponseWriter, r *http.Request) { got = append(got, string(s.discordRoom(r))) })
+	router.Post("/hook/discord/{id}/{token}", func(w http.ResponseWriter, r *http.Request) { got = append(got, string(s.discordRoom(r))) })
+	for _, target := range []string{
+		"/hook/discord",
+		"/hook/discord/42/secret",
+		"/hook/discord/7/secret",
+		"/hook/discord/42/secret?room_id=!other:matrix.org",
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, target, nil))
+	}
+
+	expected := []string{"!discord:matrix.org", "!deploys:matrix.org", "!discord:matrix.org", "!other:matrix.org"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("discordRoom() = %v, want %v", got, expected)
+	}
+}
+
+func TestReadDiscordPayloadMultipart(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("payload_json", `{"content": "See attached"}`)
+	part, _ := writer.CreateFormFile("files[0]", "report.txt")
+	part.Write([]byte("report"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/hook/discord", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	payload, err := readDiscordPayload(req)
+	if err != nil {
+		t.Fatalf("readDiscordPayload() error = %v", err)
+	}
+	if payload.Content != "See attached" {
+		t.Errorf("Content = %q, want %q", payload.Content, "See attached")
+	}
+	if len(req.MultipartForm.File["files[0]"]) != 1 {
+		t.Errorf("Files = %v, want files[0]", req.MultipartForm.File)
+	}
+}
+
+func TestHandleDiscordRejects(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{
+		config: &config.Config{Hooks: config.HooksConfig{Discord: config.DiscordConfig{Enabled: true, Token: "secret"}}},
+		logger: log,
+	}
+	router := chi.NewRouter()
+	router.Post("/hook/discord", s.handleDiscord)
+	router.Post("/hook/discord/{id}/{token}", s.handleDiscord)
+
+	tests := []struct {
+		name     string
+		target   string
+		auth     string
+		body     string
+		expected int
+	}{
+		{"No token", "/hook/discord", "", `{"content": "hi"}`, http.StatusUnauthorized},
+		{"Wrong URL token", "/hook/discord/1/wrong", "", `{"content": "hi"}`, http.StatusUnauthorized},
+		{"Invalid JSON", "/hook/discord", "Bearer secret", `{`, http.StatusBadRequest},
+		{"Empty message", "/hook/discord/1/secret", "", `{"embeds": [{}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.expected {
+				t.Errorf("status = %d, want %d", rec.Code, tt.expected)
+			}
+		})
+	}
+}
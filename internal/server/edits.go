@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// ReactionRequest is the body of POST /reaction
+type ReactionRequest struct {
+	EventID string `json:"event_id"`          // Event to react to
+	Key     string `json:"key"`               // Reaction, usually an emoji
+	RoomID  string `json:"room_id,omitempty"` // Defaults to matrix.roomid
+}
+
+// EditRequest is the body of PUT /message/{eventID}
+type EditRequest struct {
+	Message string `json:"message"`
+	RoomID  string `json:"room_id,omitempty"`
+	Format  string `json:"format,omitempty"`  // markdown (default), html or plain
+	MsgType string `json:"msgtype,omitempty"` // text (default) or notice
+}
+
+// validate checks the fields of a reaction request
+func (req *ReactionRequest) validate() error {
+	if !strings.HasPrefix(req.EventID, "$") {
+		return fmt.Errorf("invalid event_id %q", req.EventID)
+	}
+	if req.Key == "" {
+		return fmt.Errorf("missing key")
+	}
+	return (&MessageRequest{RoomID: req.RoomID}).validate()
+}
+
+// validate checks the fields of an edit request
+func (req *EditRequest) validate() error {
+	if req.Message == "" {
+		return fmt.Errorf("missing message")
+	}
+	return (&MessageRequest{RoomID: req.RoomID, Format: req.Format, MsgType: req.MsgType}).validate()
+}
+
+// messageEventID returns the {eventID} path parameter, which clients may
+// have percent-encoded
+func messageEventID(r *http.Request) (id.EventID, error) {
+	eventID, err := url.PathUnescape(chi.URLParam(r, "eventID"))
+	if err != nil || !strings.HasPrefix(eventID, "$") {
+		return "", fmt.Errorf("invalid event ID %q", chi.URLParam(r, "eventID"))
+	}
+	return id.EventID(eventID), nil
+}
+
+// roomOption targets the room if one is given, otherwise matrix.roomid
+func roomOption(roomID string) matrix.SendMessageOption {
+	return matrix.WithRoom(id.RoomID(roomID))
+}
+
+func (s *Server) handleReaction(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Reaction endpoint called")
+
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Invalid JSON in request: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.logger.Error("Invalid reaction request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eventID, err := s.matrix.SendReaction(id.EventID(req.EventID), req.Key, roomOption(req.RoomID), withRequestID(r))
+	if err != nil {
+		s.logger.Error("Failed to send reaction to Matrix: %v", err)
+		http.Error(w, "Failed to send reaction to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
+
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Edit message endpoint called")
+
+	target, err := messageEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Invalid JSON in request: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.logger.Error("Invalid edit request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := []matrix.SendMessageOption{roomOption(req.RoomID), withRequestID(r)}
+	if req.Format != "" {
+		opts = append(opts, matrix.WithFormat(req.Format))
+	}
+	if req.MsgType != "" {
+		opts = append(opts, matrix.WithMsgType(req.MsgType))
+	}
+
+	eventID, err := s.matrix.EditMessage(target, req.Message, opts...)
+	if err != nil {
+		s.logger.Error("Failed to edit message in Matrix: %v", err)
+		http.Error(w, "Failed to edit message in Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
+
+// handleRedactMessage redacts an event. The room_id and reason are query
+// parameters since DELETE requests have no body.
+func (s *Server) handleRedactMessage(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Redact message endpoint called")
+
+	target, err := messageEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	roomID := r.URL.Query().Get("room_id")
+	if err := (&MessageRequest{RoomID: roomID}).validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eventID, err := s.matrix.Redact(target, r.URL.Query().Get("reason"), roomOption(roomID), withRequestID(r))
+	if err != nil {
+		s.logger.Error("Failed to redact message in Matrix: %v", err)
+		http.Error(w, "Failed to redact message in Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestReactionRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ReactionRequest
+		wantErr bool
+	}{
+		{"Minimal request", ReactionRequest{EventID: "$event", Key: "👍"}, false},
+		{"With room", ReactionRequest{EventID: "$event", Key: "👍", RoomID: "!room:matrix.org"}, false},
+		{"Missing event", ReactionRequest{Key: "👍"}, true},
+		{"Invalid event", ReactionRequest{EventID: "event", Key: "👍"}, true},
+		{"Missing key", ReactionRequest{EventID: "$event"}, true},
+		{"Room alias instead of ID", ReactionRequest{EventID: "$event", Key: "👍", RoomID: "#room:matrix.org"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEditRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     EditRequest
+		wantErr bool
+	}{
+		{"Minimal request", EditRequest{Message: "fixed"}, false},
+		{"All options", EditRequest{Message: "fixed", RoomID: "!room:matrix.org", Format: "plain", MsgType: "notice"}, false},
+		{"Missing message", EditRequest{}, true},
+		{"Unknown format", EditRequest{Message: "fixed", Format: "rtf"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessageEventID(t *testing.T) {
+	tests := []struct {
+		param   string
+		want    string
+		wantErr bool
+	}{
+		{"$abc:matrix.org", "$abc:matrix.org", false},
+		{"%24abc%3Amatrix.org", "$abc:matrix.org", false},
+		{"abc", "", true},
+		{"%zz", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("eventID", tt.param)
+			req := httptest.NewRequest(http.MethodDelete, "/message/x", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeContext))
+
+			got, err := messageEventID(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("messageEventID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("messageEventID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestDispatchEgress(t *testing.T) {
+	dispatched := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatched++
+	}))
+	defer target.Close()
+	// Redirects are checked like the first destination
+	redirect := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data", http.StatusFound))
+	defer redirect.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	tests := []struct {
+		name   string
+		url    string
+		egress config.EgressConfig
+		denied bool
+	}{
+		{"No allowlist", target.URL, config.EgressConfig{}, false},
+		{"Allowed network", target.URL, config.EgressConfig{AllowedCIDRs: []string{"127.0.0.0/8", "::1"}}, false},
+		{"Allowed host", target.URL, config.EgressConfig{AllowedHosts: []string{"127.0.0.1"}}, false},
+		{"Other network", target.URL, config.EgressConfig{AllowedCIDRs: []string{"10.0.0.0/8"}, AllowedHosts: []string{"*.example.com"}}, true},
+		{"Redirect to the metadata service", redirect.URL, config.EgressConfig{}, true},
+	}
+	for _, tt := range tests {
+		dispatched = 0
+		cfg := &config.WebhookConfig{Default: tt.url, Template: `{"message": "{{.MESSAGE}}"}`, Timeout: 5, Egress: tt.egress}
+		_, err := webhook.New(cfg, nil, log).Dispatch(context.Background(), "status", "")
+		if denied := errors.Is(err, webhook.ErrEgressDenied); denied != tt.denied {
+			t.Errorf("%s: Dispatch() error = %v, denied = %v, want %v", tt.name, err, denied, tt.denied)
+		}
+		if !tt.denied && dispatched != 1 {
+			t.Errorf("%s: %d dispatches, want 1", tt.name, dispatched)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/email"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// emailTemplate is a compiled email template
+type emailTemplate struct {
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+// inboundEmail is the data email.imap.template renders
+type inboundEmail struct {
+	From    string
+	To      string
+	Subject string
+	Date    time.Time // Zero if the email has no valid date
+	Body    string
+}
+
+// compileEmailTemplates parses the subjects and bodies of email.templates
+func compileEmailTemplates(templates map[string]config.EmailTemplateConfig) (map[string]*emailTemplate, error) {
+	compiled := make(map[string]*emailTemplate, len(templates))
+	for name, cfg := range templates {
+		subject, err := template.New(name + ".subject").Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("email.templates.%s.subject: invalid template: %w", name, err)
+		}
+		body, err := template.New(name + ".body").Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("email.templates.%s.body: invalid template: %w", name, err)
+		}
+		compiled[name] = &emailTemplate{to: cfg.To, subject: subject, body: body}
+	}
+	return compiled, nil
+}
+
+// compileInboundEmailTemplate parses email.imap.template, nil unless the
+// mailbox is polled
+func compileInboundEmailTemplate(cfg *config.IMAPConfig) (*template.Template, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return template.New("email").Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Template)
+}
+
+// render executes the subject and body templates. An empty body means
+// nothing should be sent.
+func (t *emailTemplate) render(data interface{}) (email.Message, error) {
+	var subject, body strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return email.Message{}, fmt.Errorf("subject: %w", err)
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return email.Message{}, fmt.Errorf("body: %w", err)
+	}
+	return email.Message{To: t.to, Subject: strings.TrimSpace(subject.String()), Body: strings.TrimSpace(body.String())}, nil
+}
+
+// emailTemplate returns a template of email.templates
+func (s *Server) emailTemplate(name string) (*emailTemplate, bool) {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	tpl, exists := s.emailTemplates[name]
+	return tpl, exists
+}
+
+// handleEmail renders the JSON body through a named email template and
+// sends the email. An empty body is not sent, so templates can filter.
+func (s *Server) handleEmail(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "template")
+	tpl, exists := s.emailTemplate(name)
+	if !exists {
+		http.Error(w, "Unknown template", http.StatusNotFound)
+		return
+	}
+
+	s.logger.Info("Email endpoint called with template %q", name)
+
+	if !hasBearerToken(r, s.cfg().Email.Token) {
+		s.logger.Warn("Rejecting email request with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var data interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		s.logger.Error("Invalid JSON in email request: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := tpl.render(data)
+	if err != nil {
+		s.logger.Error("Failed to render email template %q: %v", name, err)
+		http.Error(w, fmt.Sprintf("Failed to render email: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if msg.Body == "" {
+		s.logger.Info("Email template %q rendered an empty body, nothing sent", name)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SendResponse{Status: "skipped"})
+		return
+	}
+
+	if err := email.Send(r.Context(), &s.cfg().Email.SMTP, msg); err != nil {
+		s.logger.Error("Failed to send email %q: %v", name, err)
+		http.Error(w, "Failed to send email", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success"})
+}
+
+// isEmailCommand reports whether the message is an /email command
+func isEmailCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/email"
+}
+
+// handleEmailCommand sends an email of email.templates, rendered with the
+// rest of the message as .message:
+// /email <template> [text]
+func (s *Server) handleEmailCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	log := s.logger.Ctx(ctx)
+
+	fields := strings.Fields(message)
+	if len(fields) < 2 {
+		s.configMutex.RLock()
+		names := make([]string, 0, len(s.emailTemplates))
+		for name := range s.emailTemplates {
+			names = append(names, name)
+		}
+		s.configMutex.RUnlock()
+		sort.Strings(names)
+		reply(s.text(roomID, "email.usage", strings.Join(names, ", ")))
+		return
+	}
+
+	name := fields[1]
+	tpl, exists := s.emailTemplate(name)
+	if !exists {
+		reply(s.text(roomID, "email.unknown", name))
+		return
+	}
+	// The text keeps its line breaks
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), "/email"))
+	text := strings.TrimSpace(strings.TrimPrefix(args, name))
+	msg, err := tpl.render(map[string]interface{}{
+		"message": text,
+		"sender":  string(sender),
+		"room_id": string(roomID),
+	})
+	if err != nil {
+		log.Error("Failed to render email template %q: %v", name, err)
+		reply(s.text(roomID, "email.render_failed", name, err))
+		return
+	}
+	if msg.Body == "" {
+		reply(s.text(roomID, "email.empty", name))
+		return
+	}
+
+	if err := email.Send(ctx, &s.cfg().Email.SMTP, msg); err != nil {
+		log.Error("Failed to send email %q: %v", name, err)
+		reply(s.text(roomID, "email.send_failed", name))
+		return
+	}
+	log.Info("Sent email %q for %s to %v", name, sender, msg.To)
+	reply(s.text(roomID, "email.sent", name, strings.Join(msg.To, ", ")))
+}
+
+// postEmail posts a new email of the polled mailbox to email.imap.room_id
+func (s *Server) postEmail(e email.Email) {
+	s.configMutex.RLock()
+	tpl := s.inboundEmailTemplate
+	s.configMutex.RUnlock()
+
+	var b strings.Builder
+	if err := tpl.Execute(&b, inboundEmail{From: e.From, To: e.To, Subject: e.Subject, Date: e.Date, Body: e.Body}); err != nil {
+		s.logger.Error("Failed to render email %d: %v", e.UID, err)
+		return
+	}
+	message := strings.TrimSpace(b.String())
+	if message == "" {
+		return
+	}
+
+	roomID := s.cfg().Email.IMAP.RoomID
+	if s.holdNotification(context.Background(), quiet.Notification{Source: "email", RoomID: roomID, Message: message}) {
+		return
+	}
+	var opts []matrix.SendMessageOption
+	if roomID != "" {
+		opts = append(opts, matrix.WithRoom(id.RoomID(roomID)))
+	}
+	if _, err := s.matrix.SendMessage(message, opts...); err != nil {
+		s.logger.Error("Failed to post email %d: %v", e.UID, err)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestEmailTemplateRender(t *testing.T) {
+	templates, err := compileEmailTemplates(map[string]config.EmailTemplateConfig{
+		"incident": {
+			To:      []string{"ops@example.com"},
+			Subject: `[{{ .severity | default "info" | upper }}] {{ .title }}`,
+			Body:    "{{ .message }}\n\nReported by {{ .sender }}",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := templates["incident"].render(map[string]interface{}{"title": "DB down", "message": "Primary is unreachable", "sender": "@alice:example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "[INFO] DB down" || msg.Body != "Primary is unreachable\n\nReported by @alice:example.com" || len(msg.To) != 1 {
+		t.Errorf("rendered %+v", msg)
+	}
+
+	if _, err := compileEmailTemplates(map[string]config.EmailTemplateConfig{"bad": {Subject: "{{ .x", Body: "x"}}); err == nil || !strings.Contains(err.Error(), "email.templates.bad.subject") {
+		t.Errorf("error = %v, want one about email.templates.bad.subject", err)
+	}
+}
+
+func TestHandleEmail(t *testing.T) {
+	templates, err := compileEmailTemplates(map[string]config.EmailTemplateConfig{
+		"deploy": {To: []string{"ops@example.com"}, Subject: "Deploy", Body: `{{ if eq .status "failed" }}{{ .service }} failed{{ end }}`},
+		"broken": {To: []string{"ops@example.com"}, Body: `{{ index .items 5 }}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{
+		config:         &config.Config{Email: config.EmailConfig{Token: "s3cret"}},
+		logger:         log,
+		emailTemplates: templates,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/email/{template}", s.handleEmail)
+
+	tests := []struct {
+		name       string
+		path       string
+		auth       string
+		body       string
+		wantStatus int
+	}{
+		{"Unknown template", "/email/missing", "Bearer s3cret", `{}`, http.StatusNotFound},
+		{"Missing token", "/email/deploy", "", `{}`, http.StatusUnauthorized},
+		{"Invalid JSON", "/email/deploy", "Bearer s3cret", `{`, http.StatusBadRequest},
+		{"Render error", "/email/broken", "Bearer s3cret", `{"items": []}`, http.StatusUnprocessableEntity},
+		{"Empty body", "/email/deploy", "Bearer s3cret", `{"status": "ok", "service": "api"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var resp SendResponse
+				json.NewDecoder(rec.Body).Decode(&resp)
+				if resp.Status != "skipped" {
+					t.Errorf("response status = %q, want %q", resp.Status, "skipped")
+				}
+			}
+		})
+	}
+}
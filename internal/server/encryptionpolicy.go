@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+// Encryption policies of replies with backend output
+const (
+	encryptionPolicyOff    = "off"
+	encryptionPolicyWarn   = "warn"
+	encryptionPolicyRefuse = "refuse"
+)
+
+// encryptionPolicy returns the encryption policy of a room: its own, or else
+// encryption_policy.mode
+func encryptionPolicy(room *config.RoomConfig, cfg *config.Config) string {
+	if room != nil && room.EncryptionPolicy != "" {
+		return room.EncryptionPolicy
+	}
+	if cfg.EncryptionPolicy.Mode == "" {
+		return encryptionPolicyOff
+	}
+	return cfg.EncryptionPolicy.Mode
+}
+
+// plaintextRooms remembers the unencrypted rooms that were warned about and
+// those the bot tried to enable encryption in, so that each happens once
+type plaintextRooms struct {
+	mutex   sync.Mutex
+	warned  map[id.RoomID]bool
+	enabled map[id.RoomID]bool
+}
+
+// warn reports whether the room was not warned about yet, and marks it
+func (p *plaintextRooms) warn(roomID id.RoomID) bool {
+	return p.once(&p.warned, roomID)
+}
+
+// tryEnable reports whether enabling encryption in the room was not tried
+// yet, and marks it
+func (p *plaintextRooms) tryEnable(roomID id.RoomID) bool {
+	return p.once(&p.enabled, roomID)
+}
+
+func (p *plaintextRooms) once(done *map[id.RoomID]bool, roomID id.RoomID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if (*done)[roomID] {
+		return false
+	}
+	if *done == nil {
+		*done = make(map[id.RoomID]bool)
+	}
+	(*done)[roomID] = true
+	return true
+}
+
+// sendOutput sends a reply carrying backend output, such as a webhook reply,
+// command output or a model answer, if the encryption policy of the reply
+// room allows it, wrapped in the room's reply_prefix and reply_suffix
+func (s *Server) sendOutput(ctx context.Context, message string, sender id.UserID, replyEventID id.EventID) id.EventID {
+	if !s.allowOutput(ctx, sender, replyEventID) {
+		return ""
+	}
+	return s.sendReply(ctx, s.wrapOutput(ctx, sender, message), sender, replyEventID)
+}
+
+// allowOutput applies the encryption policy of the reply room of ctx before
+// backend output is sent there. In a room without encryption it enables
+// encryption if encryption_policy.enable_encryption is set and the bot may,
+// warns once with the warn policy, and refuses the output with the refuse
+// policy, telling the sender.
+func (s *Server) allowOutput(ctx context.Context, sender id.UserID, replyEventID id.EventID) bool {
+	cfg := s.cfg()
+	roomID := replyRoom(ctx)
+	if roomID == "" {
+		roomID = id.RoomID(cfg.Matrix.RoomID)
+	}
+	policy := encryptionPolicy(s.roomSettings(roomID), cfg)
+	if policy == encryptionPolicyOff || s.matrix == nil {
+		return true
+	}
+	log := s.logger.Ctx(ctx)
+	encrypted, err := s.matrix.RoomEncrypted(ctx, roomID)
+	if err != nil {
+		log.Warn("Failed to check whether room %s is encrypted, treating it as unencrypted: %v", roomID, err)
+	}
+	if encrypted {
+		return true
+	}
+
+	if cfg.EncryptionPolicy.EnableEncryption && s.plaintextRooms.tryEnable(roomID) {
+		if err := s.matrix.EnableRoomEncryption(ctx, roomID); err != nil {
+			log.Warn("Failed to enable encryption in room %s: %v", roomID, err)
+		} else {
+			s.sendReply(ctx, s.text(roomID, "encryption.enabled"), sender, replyEventID)
+			return true
+		}
+	}
+
+	if policy == encryptionPolicyRefuse {
+		log.Warn("Refusing to send backend output to room %s, which is not encrypted", roomID)
+		s.sendReply(ctx, s.text(roomID, "encryption.refused"), sender, replyEventID)
+		return false
+	}
+	if s.plaintextRooms.warn(roomID) {
+		log.Warn("Sending backend output to room %s, which is not encrypted", roomID)
+		s.sendReply(ctx, s.text(roomID, "encryption.warning"), sender, replyEventID)
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestEncryptionPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	if got := encryptionPolicy(nil, cfg); got != encryptionPolicyOff {
+		t.Errorf("encryptionPolicy() without a mode = %q, want off", got)
+	}
+	cfg.EncryptionPolicy.Mode = encryptionPolicyWarn
+	if got := encryptionPolicy(&config.RoomConfig{}, cfg); got != encryptionPolicyWarn {
+		t.Errorf("encryptionPolicy() of a room without its own = %q, want warn", got)
+	}
+	if got := encryptionPolicy(&config.RoomConfig{EncryptionPolicy: encryptionPolicyRefuse}, cfg); got != encryptionPolicyRefuse {
+		t.Errorf("encryptionPolicy() of a room with its own = %q, want refuse", got)
+	}
+}
+
+func TestPlaintextRooms(t *testing.T) {
+	var rooms plaintextRooms
+	if !rooms.warn("!a:example.com") || rooms.warn("!a:example.com") {
+		t.Error("warn() should be true once per room")
+	}
+	if !rooms.warn("!b:example.com") {
+		t.Error("warn() of another room should be true")
+	}
+	if !rooms.tryEnable("!a:example.com") || rooms.tryEnable("!a:example.com") {
+		t.Error("tryEnable() should be true once per room, apart from warn()")
+	}
+}
+
+func TestAllowOutputWithoutPolicy(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Rooms: []config.RoomConfig{{RoomID: "!plain:example.com", EncryptionPolicy: encryptionPolicyOff}}}
+	cfg.EncryptionPolicy.Mode = encryptionPolicyRefuse
+	s := &Server{config: cfg, logger: log, rooms: compileRooms(cfg.Rooms)}
+
+	ctx := withReplyRoom(context.Background(), id.RoomID("!plain:example.com"))
+	if !s.allowOutput(ctx, "@alice:example.com", "") {
+		t.Error("allowOutput() in a room with the off policy = false, want true")
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+// Subsystems named in error replies
+const (
+	subsystemWebhook = "webhook"
+	subsystemLLM     = "llm"
+	subsystemOllama  = "ollama"
+)
+
+// errorReply is the data of error_replies templates
+type errorReply struct {
+	RequestID string        // Correlation ID, also in the logs, the audit log and the dispatch headers
+	Subsystem string        // webhook, llm or ollama
+	Command   string        // Without the slash, empty for the default webhook
+	Hint      string        // What probably went wrong, in the language of the room
+	Error     string        // The error, empty unless error_replies.include_error is set
+	Retry     bool          // The request is sent again after RetryIn
+	RetryIn   time.Duration // Delay before the next attempt
+	Attempt   int           // The attempt that failed, from 1
+	Attempts  int           // Attempts planned in total
+}
+
+// errorReplyFuncs are the helpers of error reply templates
+func errorReplyFuncs() template.FuncMap {
+	return template.FuncMap{"spoiler": spoiler}
+}
+
+// spoiler hides text behind a Matrix spoiler
+func spoiler(text string) string {
+	return "<span data-mx-spoiler>" + text + "</span>"
+}
+
+// compileErrorReplyTemplates parses error_replies.template, keyed by "", and
+// error_replies.templates keyed by subsystem
+func compileErrorReplyTemplates(cfg *config.ErrorRepliesConfig) (map[string]*template.Template, error) {
+	compiled := make(map[string]*template.Template, len(cfg.Templates)+1)
+	if cfg.Template != "" {
+		tpl, err := template.New("error_replies.template").Funcs(errorReplyFuncs()).Option("missingkey=zero").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error_replies.template: %w", err)
+		}
+		compiled[""] = tpl
+	}
+	for subsystem, text := range cfg.Templates {
+		tpl, err := template.New("error_replies.templates." + subsystem).Funcs(errorReplyFuncs()).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error_replies.templates.%s: %w", subsystem, err)
+		}
+		compiled[subsystem] = tpl
+	}
+	return compiled, nil
+}
+
+// replyError tells the sender that a request failed, if
+// error_replies.enabled is set. The subsystem, command and retry fields of
+// r are filled in by the caller.
+func (s *Server) replyError(ctx context.Context, roomID id.RoomID, sender id.UserID, replyEventID id.EventID, r errorReply, err error) {
+	cfg := s.cfg().ErrorReplies
+	if !cfg.Enabled {
+		return
+	}
+	r.RequestID = requestid.FromContext(ctx)
+	r.Hint = s.errorHint(roomID, err)
+	if cfg.IncludeError {
+		r.Error = err.Error()
+	}
+	message, renderErr := s.renderErrorReply(roomID, r)
+	if renderErr != nil {
+		s.logger.Ctx(ctx).Error("Failed to render the error reply: %v", renderErr)
+		return
+	}
+	if message != "" {
+		s.sendReply(ctx, message, sender, replyEventID)
+	}
+}
+
+// renderErrorReply renders the template of the subsystem, the default
+// template, or else the built-in reply
+func (s *Server) renderErrorReply(roomID id.RoomID, r errorReply) (string, error) {
+	s.configMutex.RLock()
+	tpl, exists := s.errorTemplates[r.Subsystem]
+	if !exists {
+		tpl, exists = s.errorTemplates[""]
+	}
+	s.configMutex.RUnlock()
+	if exists {
+		var b strings.Builder
+		if err := tpl.Execute(&b, r); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(b.String()), nil
+	}
+	return s.builtinErrorReply(roomID, r), nil
+}
+
+// builtinErrorReply lists what failed, the hint, the retry and the
+// reference below a summary, in a spoiler if error_replies.spoiler is set
+func (s *Server) builtinErrorReply(roomID id.RoomID, r errorReply) string {
+	summary := s.text(roomID, "error.summary."+r.Subsystem)
+	if r.Subsystem == subsystemWebhook && r.Command != "" {
+		summary = s.text(roomID, "error.summary.command", r.Command)
+	}
+	var details []string
+	if r.Hint != "" {
+		details = append(details, r.Hint)
+	}
+	if r.Retry {
+		details = append(details, s.text(roomID, "error.retry", r.RetryIn, r.Attempt+1, r.Attempts))
+	} else {
+		details = append(details, s.text(roomID, "error.no_retry"))
+	}
+	if r.RequestID != "" {
+		details = append(details, s.text(roomID, "error.reference", r.RequestID))
+	}
+	if r.Error != "" {
+		details = append(details, s.text(roomID, "error.details", strings.ReplaceAll(r.Error, "`", "'")))
+	}
+	if s.cfg().ErrorReplies.Spoiler {
+		return summary + "\n" + spoiler(strings.Join(details, " · "))
+	}
+	return summary + "\n- " + strings.Join(details, "\n- ")
+}
+
+// errorHint returns what probably went wrong, empty if that is unknown
+func (s *Server) errorHint(roomID id.RoomID, err error) string {
+	var status *webhook.StatusError
+	switch {
+	case errors.Is(err, webhook.ErrEgressDenied):
+		return s.text(roomID, "error.hint.egress")
+	case webhook.Timeout(err):
+		return s.text(roomID, "error.hint.timeout")
+	case errors.As(err, &status) && status.StatusCode == 429:
+		return s.text(roomID, "error.hint.rate_limited")
+	case errors.As(err, &status) && status.StatusCode >= 500:
+		return s.text(roomID, "error.hint.server_error", status.StatusCode)
+	case errors.As(err, &status):
+		return s.text(roomID, "error.hint.rejected", status.StatusCode)
+	case webhook.Transient(err):
+		return s.text(roomID, "error.hint.unreachable")
+	default:
+		return ""
+	}
+}
+
+// dispatchWithRetry dispatches a message, sending it again after transient
+// failures up to webhook.retry_attempts times. The sender is told about the
+// first failure and the last one.
+func (s *Server) dispatchWithRetry(ctx context.Context, roomID id.RoomID, sender id.UserID, replyEventID id.EventID, message, command string, opts ...webhook.DispatchOption) (string, error) {
+	webhookCfg := s.cfg().WebhookFor(string(roomID))
+	attempts := 1 + webhookCfg.RetryAttempts
+	delay := time.Duration(webhookCfg.RetryDelay) * time.Second
+	for attempt := 1; ; attempt++ {
+		reply, err := s.webhook.Dispatch(ctx, message, command, opts...)
+		if err == nil || errors.Is(err, webhook.ErrAlreadyDelivered) {
+			return reply, err
+		}
+		retry := attempt < attempts && webhook.Transient(err)
+		if attempt == 1 || !retry {
+			s.replyError(ctx, roomID, sender, replyEventID, errorReply{Subsystem: subsystemWebhook, Command: command, Retry: retry, RetryIn: delay, Attempt: attempt, Attempts: attempts}, err)
+		}
+		if !retry {
+			return "", err
+		}
+		s.logger.Ctx(ctx).Warn("Dispatch attempt %d of %d failed, retrying in %v: %v", attempt, attempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", err
+		}
+	}
+}
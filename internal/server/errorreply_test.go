@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestBuiltinErrorReply(t *testing.T) {
+	r := errorReply{RequestID: "req-1", Subsystem: subsystemWebhook, Command: "deploy", Hint: "The service did not answer in time.", Error: "status `503`", Retry: true, RetryIn: 5 * time.Second, Attempt: 1, Attempts: 3}
+
+	s := &Server{config: &config.Config{}}
+	reply, err := s.renderErrorReply("!room:example.com", r)
+	if err != nil {
+		t.Fatalf("renderErrorReply() error = %v", err)
+	}
+	want := "⚠️ The webhook of /deploy failed.\n- The service did not answer in time.\n- Retrying in 5s (attempt 2 of 3).\n- Reference: `req-1`\n- Error: `status '503'`"
+	if reply != want {
+		t.Errorf("renderErrorReply() = %q, want %q", reply, want)
+	}
+
+	s.config.ErrorReplies.Spoiler = true
+	r.Command, r.Retry, r.Hint, r.Error = "", false, "", ""
+	reply, _ = s.renderErrorReply("!room:example.com", r)
+	want = "⚠️ The webhook failed to answer your message.\n<span data-mx-spoiler>No retry is planned. · Reference: `req-1`</span>"
+	if reply != want {
+		t.Errorf("renderErrorReply() with spoiler = %q, want %q", reply, want)
+	}
+}
+
+func TestErrorReplyTemplates(t *testing.T) {
+	templates, err := compileErrorReplyTemplates(&config.ErrorRepliesConfig{
+		Template:  `{{.Subsystem}} failed, ref {{.RequestID}}`,
+		Templates: map[string]string{"llm": `Model down{{if .Hint}} {{spoiler .Hint}}{{end}}`},
+	})
+	if err != nil {
+		t.Fatalf("compileErrorReplyTemplates() error = %v", err)
+	}
+	s := &Server{config: &config.Config{}, errorTemplates: templates}
+
+	if reply, _ := s.renderErrorReply("", errorReply{Subsystem: subsystemWebhook, RequestID: "abc"}); reply != "webhook failed, ref abc" {
+		t.Errorf("Default template = %q", reply)
+	}
+	if reply, _ := s.renderErrorReply("", errorReply{Subsystem: subsystemLLM, Hint: "timeout"}); reply != "Model down <span data-mx-spoiler>timeout</span>" {
+		t.Errorf("llm template = %q", reply)
+	}
+
+	if _, err := compileErrorReplyTemplates(&config.ErrorRepliesConfig{Templates: map[string]string{"ollama": "{{.Broken"}}); err == nil || !strings.Contains(err.Error(), "error_replies.templates.ollama") {
+		t.Errorf("compileErrorReplyTemplates() of an invalid template error = %v", err)
+	}
+}
+
+func TestErrorHint(t *testing.T) {
+	s := &Server{config: &config.Config{}}
+	tests := []struct {
+		err       error
+		hint      string
+		transient bool
+	}{
+		{fmt.Errorf("failed to send webhook: %w", webhook.ErrEgressDenied), "webhook.egress", false},
+		{context.DeadlineExceeded, "did not answer in time", true},
+		{&webhook.StatusError{StatusCode: 429}, "rate limiting", true},
+		{&webhook.StatusError{StatusCode: 502}, "internal error (502)", true},
+		{&webhook.StatusError{StatusCode: 404}, "rejected the request (404)", false},
+		{errors.New("failed to parse response with JQ"), "", false},
+	}
+	for _, tt := range tests {
+		hint := s.errorHint("", tt.err)
+		if (tt.hint == "" && hint != "") || !strings.Contains(hint, tt.hint) {
+			t.Errorf("errorHint(%v) = %q, want %q", tt.err, hint, tt.hint)
+		}
+		if got := webhook.Transient(tt.err); got != tt.transient {
+			t.Errorf("Transient(%v) = %v, want %v", tt.err, got, tt.transient)
+		}
+	}
+}
+
+func TestDispatchWithRetry(t *testing.T) {
+	attempts := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"reply": "done"}`))
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	tests := []struct {
+		name          string
+		retryAttempts int
+		wantAttempts  int
+		wantErr       bool
+	}{
+		{"Retried until it succeeds", 2, 3, false},
+		{"Out of attempts", 1, 2, true},
+		{"No retries", 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts = 0
+			cfg := &config.Config{Webhook: config.WebhookConfig{Default: target.URL, Template: `{"message": "{{.MESSAGE}}"}`, JQSelector: ".reply", Timeout: 5, RetryAttempts: tt.retryAttempts}}
+			s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log)}
+
+			reply, err := s.dispatchWithRetry(context.Background(), "!room:example.com", "@alice:example.com", "", "status", "")
+			if (err != nil) != tt.wantErr || attempts != tt.wantAttempts {
+				t.Fatalf("dispatchWithRetry() = %q, %v after %d attempts, want %d attempts", reply, err, attempts, tt.wantAttempts)
+			}
+			if !tt.wantErr && reply != "done" {
+				t.Errorf("dispatchWithRetry() = %q, want done", reply)
+			}
+		})
+	}
+}
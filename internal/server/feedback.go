@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Names of the replies that answer messages without a command, as listed in
+// feedback.commands
+const (
+	feedbackDefaultCommand = "default"
+	feedbackLLMCommand     = "llm"
+	feedbackOllamaCommand  = "ollama"
+)
+
+// FeedbackEvent is the body POSTed to the feedback webhook for a reaction
+// to a reply
+type FeedbackEvent struct {
+	Reaction        string    `json:"reaction"`
+	Reactor         string    `json:"reactor"`
+	RoomID          string    `json:"room_id"`
+	ReplyEventID    string    `json:"reply_event_id"`
+	ReactionEventID string    `json:"reaction_event_id"`
+	Command         string    `json:"command"`
+	Message         string    `json:"message"` // The message the reply answers
+	MessageEventID  string    `json:"message_event_id"`
+	Sender          string    `json:"sender"` // Who sent the message
+	Reply           string    `json:"reply"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// feedbackReply is what is remembered of a reply to forward reactions to it
+type feedbackReply struct {
+	Command        string
+	Message        string
+	MessageEventID id.EventID
+	Sender         id.UserID
+	Reply          string
+}
+
+// feedbackReplies remembers replies by event ID, forgetting the oldest past
+// its capacity
+type feedbackReplies struct {
+	mu       sync.Mutex
+	capacity int
+	replies  map[id.EventID]feedbackReply
+	order    []id.EventID
+}
+
+func newFeedbackReplies(capacity int) *feedbackReplies {
+	return &feedbackReplies{capacity: capacity, replies: make(map[id.EventID]feedbackReply)}
+}
+
+func (f *feedbackReplies) add(eventID id.EventID, reply feedbackReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.replies[eventID]; !exists {
+		f.order = append(f.order, eventID)
+	}
+	f.replies[eventID] = reply
+	if len(f.order) > f.capacity {
+		delete(f.replies, f.order[0])
+		f.order = f.order[1:]
+	}
+}
+
+func (f *feedbackReplies) lookup(eventID id.EventID) (feedbackReply, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply, exists := f.replies[eventID]
+	return reply, exists
+}
+
+// feedbackCommand names the command of a reply, fallback for messages
+// without one
+func feedbackCommand(command, fallback string) string {
+	if command == "" {
+		return fallback
+	}
+	return command
+}
+
+// recordFeedbackReply remembers a reply sent to Matrix if reactions to it
+// are forwarded
+func (s *Server) recordFeedbackReply(eventID id.EventID, reply feedbackReply) {
+	if s.feedback == nil || eventID == "" {
+		return
+	}
+	if commands := s.cfg().Feedback.Commands; len(commands) > 0 && !containsString(commands, reply.Command) {
+		return
+	}
+	s.feedback.add(eventID, reply)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// feedbackReaction reports whether a reaction is forwarded, ignoring
+// variation selectors
+func feedbackReaction(key string, reactions []string) bool {
+	if len(reactions) == 0 {
+		return true
+	}
+	normalize := func(s string) string { return strings.ReplaceAll(s, "\ufe0f", "") }
+	for _, reaction := range reactions {
+		if normalize(reaction) == normalize(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFeedbackReaction forwards a reaction of a user of the room to a
+// remembered reply
+func (s *Server) handleFeedbackReaction(evt *event.Event) {
+	cfg := s.cfg()
+	if evt.Type != event.EventReaction || evt.Sender == id.UserID(cfg.Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsReaction()
+	if content == nil || !feedbackReaction(content.RelatesTo.Key, cfg.Feedback.Reactions) {
+		return
+	}
+	reply, exists := s.feedback.lookup(content.RelatesTo.EventID)
+	if !exists {
+		return
+	}
+	if !s.roomSettings(evt.RoomID).AllowsUser(string(evt.Sender)) {
+		s.logger.Info("Ignoring feedback of %s, who is not in the allowed users of room %s", evt.Sender, evt.RoomID)
+		return
+	}
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring feedback of %s", evt.Sender)
+		return
+	}
+
+	feedback := FeedbackEvent{
+		Reaction:        content.RelatesTo.Key,
+		Reactor:         string(evt.Sender),
+		RoomID:          string(evt.RoomID),
+		ReplyEventID:    string(content.RelatesTo.EventID),
+		ReactionEventID: string(evt.ID),
+		Command:         reply.Command,
+		Message:         reply.Message,
+		MessageEventID:  string(reply.MessageEventID),
+		Sender:          string(reply.Sender),
+		Reply:           reply.Reply,
+		Timestamp:       time.UnixMilli(evt.Timestamp).UTC(),
+	}
+	// The webhook must not hold up the sync
+	go func() {
+		if err := s.postFeedback(&feedback); err != nil {
+			s.logger.Error("Failed to forward feedback of %s on %s: %v", evt.Sender, feedback.ReplyEventID, err)
+			return
+		}
+		s.logger.Info("Forwarded %s feedback of %s on the %s reply %s", feedback.Reaction, evt.Sender, feedback.Command, feedback.ReplyEventID)
+	}()
+}
+
+// postFeedback POSTs a reaction to the feedback webhook
+func (s *Server) postFeedback(feedback *FeedbackEvent) error {
+	cfg := s.cfg().Feedback
+	body, err := json.Marshal(feedback)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.AuthToken != "" {
+		req.Header.Set("Authorization", cfg.AuthToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("feedback webhook returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestFeedbackReplies(t *testing.T) {
+	replies := newFeedbackReplies(3)
+	replies.add("$first", feedbackReply{Command: "ask"})
+	for i := 0; i < 3; i++ {
+		replies.add(id.EventID(fmt.Sprintf("$%d", i)), feedbackReply{Command: "deploy"})
+	}
+	if _, exists := replies.lookup("$first"); exists {
+		t.Error("lookup($first) exists, want it forgotten")
+	}
+	if reply, exists := replies.lookup("$2"); !exists || reply.Command != "deploy" {
+		t.Errorf("lookup($2) = %+v, %v", reply, exists)
+	}
+}
+
+func TestFeedbackReaction(t *testing.T) {
+	tests := []struct {
+		key       string
+		reactions []string
+		expected  bool
+	}{
+		{"👍", nil, true},
+		{"👍", []string{"👍", "👎"}, true},
+		{"❤\ufe0f", []string{"❤"}, true}, // With a variation selector
+		{"🎉", []string{"👍", "👎"}, false},
+	}
+	for _, tt := range tests {
+		if got := feedbackReaction(tt.key, tt.reactions); got != tt.expected {
+			t.Errorf("feedbackReaction(%q, %v) = %v, want %v", tt.key, tt.reactions, got, tt.expected)
+		}
+	}
+}
+
+func TestHandleFeedbackReaction(t *testing.T) {
+	received := make(chan FeedbackEvent, 1)
+	var authorization string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		var feedback FeedbackEvent
+		json.NewDecoder(r.Body).Decode(&feedback)
+		received <- feedback
+	}))
+	defer hook.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Matrix:   config.MatrixConfig{UserID: "@bot:example.com"},
+		Feedback: config.FeedbackConfig{Enabled: true, URL: hook.URL, AuthToken: "Bearer secret", Reactions: []string{"👍", "👎"}, Commands: []string{"ask"}, MaxReplies: 10, Timeout: 5},
+	}
+	s := &Server{
+		config:   cfg,
+		logger:   log,
+		rooms:    compileRooms([]config.RoomConfig{{RoomID: "!room:example.com", AllowedUsers: []string{"@alice:example.com", "@bob:example.com"}}}),
+		feedback: newFeedbackReplies(cfg.Feedback.MaxReplies),
+	}
+	s.recordFeedbackReply("$reply", feedbackReply{Command: "ask", Message: "/ask why?", MessageEventID: "$message", Sender: "@bob:example.com", Reply: "Because."})
+	s.recordFeedbackReply("$other", feedbackReply{Command: "deploy", Reply: "Deployed"})
+
+	reaction := func(sender, target, key string) *event.Event {
+		return &event.Event{
+			Type:      event.EventReaction,
+			ID:        "$reaction",
+			RoomID:    "!room:example.com",
+			Sender:    id.UserID(sender),
+			Timestamp: 1700000000000,
+			Content: event.Content{Parsed: &event.ReactionEventContent{
+				RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: id.EventID(target), Key: key},
+			}},
+		}
+	}
+
+	// None of these are forwarded
+	s.handleFeedbackReaction(reaction("@alice:example.com", "$other", "👍"))   // Command without feedback
+	s.handleFeedbackReaction(reaction("@alice:example.com", "$reply", "🎉"))   // Reaction not listed
+	s.handleFeedbackReaction(reaction("@mallory:example.com", "$reply", "👍")) // User not allowed
+	s.handleFeedbackReaction(reaction("@bot:example.com", "$reply", "👍"))     // The bot itself
+
+	s.handleFeedbackReaction(reaction("@alice:example.com", "$reply", "👎"))
+	select {
+	case feedback := <-received:
+		expected := FeedbackEvent{
+			Reaction:        "👎",
+			Reactor:         "@alice:example.com",
+			RoomID:          "!room:example.com",
+			ReplyEventID:    "$reply",
+			ReactionEventID: "$reaction",
+			Command:         "ask",
+			Message:         "/ask why?",
+			MessageEventID:  "$message",
+			Sender:          "@bob:example.com",
+			Reply:           "Because.",
+			Timestamp:       time.UnixMilli(1700000000000).UTC(),
+		}
+		if feedback != expected {
+			t.Errorf("feedback = %+v, want %+v", feedback, expected)
+		}
+		if authorization != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the auth_token", authorization)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("feedback was not forwarded")
+	}
+	select {
+	case feedback := <-received:
+		t.Errorf("unexpected feedback %+v", feedback)
+	default:
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/feed"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// Shortest interval accepted by /rss subscribe
+const minFeedInterval = time.Minute
+
+// feedEntry is the data feeds.template renders
+type feedEntry struct {
+	Feed      string // Title of the feed, or its URL
+	Title     string
+	Link      string
+	Summary   string
+	Published time.Time // Zero if the feed has no date
+}
+
+// compileFeedTemplate parses feeds.template, nil unless feeds are enabled
+func compileFeedTemplate(cfg *config.FeedsConfig) (*template.Template, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return template.New("feed").Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Template)
+}
+
+// postFeedItem posts a new feed entry to the room of its subscription
+func (s *Server) postFeedItem(sub feed.Subscription, item feed.Item) {
+	entry := feedEntry{Feed: sub.Title, Title: item.Title, Link: item.Link, Summary: item.Summary, Published: item.Published}
+	if entry.Feed == "" {
+		entry.Feed = sub.URL
+	}
+
+	s.configMutex.RLock()
+	tpl := s.feedTemplate
+	s.configMutex.RUnlock()
+
+	var b strings.Builder
+	if err := tpl.Execute(&b, entry); err != nil {
+		s.logger.Error("Failed to render feed entry %s of %s: %v", item.ID, sub.URL, err)
+		return
+	}
+	if s.holdNotification(context.Background(), quiet.Notification{Source: "feed", RoomID: sub.RoomID, Message: b.String(), MsgType: matrix.MsgTypeNotice}) {
+		return
+	}
+	if _, err := s.matrix.SendMessage(b.String(), matrix.WithRoom(id.RoomID(sub.RoomID)), matrix.WithMsgType(matrix.MsgTypeNotice)); err != nil {
+		s.logger.Error("Failed to post feed entry %s of %s: %v", item.ID, sub.URL, err)
+	}
+}
+
+// isFeedCommand reports whether the message is an /rss command
+func isFeedCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/rss"
+}
+
+// handleFeedCommand manages the feeds of the room. Anyone can list them;
+// the users in server.admin_users can change them:
+// /rss list
+// /rss subscribe <url> [interval, e.g. 30m]
+// /rss unsubscribe <url>
+func (s *Server) handleFeedCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, threadRootEventID id.EventID) {
+	fields := strings.Fields(message)
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	usage := s.text(roomID, "feeds.usage")
+
+	if len(fields) < 2 {
+		reply(usage)
+		return
+	}
+	if fields[1] != "list" && !s.isAdminUser(sender) {
+		s.logger.Ctx(ctx).Warn("User %s is not an admin, refusing /rss %s", sender, fields[1])
+		reply(s.text(roomID, "feeds.admin_only"))
+		return
+	}
+
+	switch {
+	case fields[1] == "list" && len(fields) == 2:
+		subs := s.feeds.List(string(roomID))
+		if len(subs) == 0 {
+			reply(s.text(roomID, "feeds.none"))
+			return
+		}
+		var b strings.Builder
+		b.WriteString(s.text(roomID, "feeds.list") + "\n")
+		for _, sub := range subs {
+			title := sub.Title
+			if title == "" {
+				title = sub.URL
+			}
+			fmt.Fprintf(&b, "- [%s](%s)", title, sub.URL)
+			if sub.Configured {
+				b.WriteString(" " + s.text(roomID, "feeds.configured"))
+			}
+			b.WriteString("\n")
+		}
+		reply(b.String())
+	case fields[1] == "subscribe" && (len(fields) == 3 || len(fields) == 4):
+		var interval int
+		if len(fields) == 4 {
+			d, err := time.ParseDuration(fields[3])
+			if err != nil || d < minFeedInterval {
+				reply(s.text(roomID, "feeds.invalid_interval", fields[3], minFeedInterval))
+				return
+			}
+			interval = int(d / time.Second)
+		}
+		sub, err := s.feeds.Subscribe(ctx, string(roomID), fields[2], string(sender), interval)
+		if err != nil {
+			reply(s.text(roomID, "feeds.subscribe_failed", fields[2], err))
+			return
+		}
+		title := sub.Title
+		if title == "" {
+			title = sub.URL
+		}
+		reply(s.text(roomID, "feeds.subscribed", title))
+	case fields[1] == "unsubscribe" && len(fields) == 3:
+		removed, err := s.feeds.Unsubscribe(string(roomID), fields[2])
+		switch {
+		case errors.Is(err, feed.ErrConfigured):
+			reply(s.text(roomID, "feeds.configured_in_file", fields[2]))
+		case err != nil:
+			reply(s.text(roomID, "feeds.unsubscribe_failed", fields[2], err))
+		case !removed:
+			reply(s.text(roomID, "feeds.not_subscribed", fields[2]))
+		default:
+			reply(s.text(roomID, "feeds.unsubscribed", fields[2]))
+		}
+	default:
+		reply(usage)
+	}
+}
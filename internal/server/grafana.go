@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// GrafanaPayload is the webhook body sent by Grafana. Unified alerting
+// fills Status and Alerts; legacy dashboard alerts fill State, RuleName,
+// RuleURL, ImageURL and EvalMatches instead.
+type GrafanaPayload struct {
+	Receiver    string         `json:"receiver"`
+	Status      string         `json:"status"`
+	State       string         `json:"state"`
+	Title       string         `json:"title"`
+	Message     string         `json:"message"`
+	ExternalURL string         `json:"externalURL"`
+	Alerts      []GrafanaAlert `json:"alerts"`
+	// Legacy alerting fields
+	RuleName    string             `json:"ruleName"`
+	RuleURL     string             `json:"ruleUrl"`
+	ImageURL    string             `json:"imageUrl"`
+	EvalMatches []GrafanaEvalMatch `json:"evalMatches"`
+}
+
+// GrafanaAlert is a single alert of a unified alerting notification
+type GrafanaAlert struct {
+	Status       string             `json:"status"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	Values       map[string]float64 `json:"values"`
+	GeneratorURL string             `json:"generatorURL"`
+	DashboardURL string             `json:"dashboardURL"`
+	PanelURL     string             `json:"panelURL"`
+	SilenceURL   string             `json:"silenceURL"`
+	ImageURL     string             `json:"imageURL"`
+}
+
+// GrafanaEvalMatch is a metric value that triggered a legacy alert
+type GrafanaEvalMatch struct {
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+}
+
+// grafanaStateEmoji maps unified alerting statuses and legacy states to emoji
+var grafanaStateEmoji = map[string]string{
+	"firing":   "🔥",
+	"alerting": "🔥",
+	"resolved": "✅",
+	"ok":       "✅",
+	"no_data":  "❓",
+	"pending":  "⏳",
+	"paused":   "⏸️",
+}
+
+// state returns the notification state, whichever alerting flavor sent it
+func (p *GrafanaPayload) state() string {
+	if p.Status != "" {
+		return p.Status
+	}
+	return p.State
+}
+
+// imageURLs returns the distinct panel image URLs of the notification
+func (p *GrafanaPayload) imageURLs() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	add(p.ImageURL)
+	for _, alert := range p.Alerts {
+		add(alert.ImageURL)
+	}
+	return urls
+}
+
+// formatGrafanaNotification renders a notification as markdown
+func formatGrafanaNotification(p *GrafanaPayload) string {
+	state := p.state()
+	emoji, exists := grafanaStateEmoji[strings.ToLower(state)]
+	if !exists {
+		emoji = "🔔"
+	}
+
+	title := p.Title
+	if title == "" {
+		title = p.RuleName
+	}
+	if title == "" {
+		title = "Grafana alert"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s **%s**", emoji, title)
+	if p.RuleURL != "" {
+		fmt.Fprintf(&b, " [view](%s)", p.RuleURL)
+	}
+	b.WriteString("\n")
+
+	if message := strings.TrimSpace(p.Message); message != "" {
+		b.WriteString("\n" + message + "\n")
+	}
+
+	// Unified alerting messages already describe each alert, so only the
+	// links are added; legacy alerts list the metrics that matched.
+	for _, alert := range p.Alerts {
+		var links []string
+		for _, link := range []struct{ name, url string }{
+			{"source", alert.GeneratorURL},
+			{"dashboard", alert.DashboardURL},
+			{"panel", alert.PanelURL},
+			{"silence", alert.SilenceURL},
+		} {
+			if link.url != "" {
+				links = append(links, fmt.Sprintf("[%s](%s)", link.name, link.url))
+			}
+		}
+		if len(links) > 0 {
+			name := alert.Labels["alertname"]
+			if name == "" {
+				name = "alert"
+			}
+			fmt.Fprintf(&b, "\n- %s: %s", name, strings.Join(links, " | "))
+		}
+	}
+	for _, match := range p.EvalMatches {
+		fmt.Fprintf(&b, "\n- `%s`: %g", match.Metric, match.Value)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// grafanaRoom picks the room for a notification: the room_id query parameter,
+// then the configured room. An empty result means the Matrix client's default.
+func (s *Server) grafanaRoom(r *http.Request) id.RoomID {
+	if roomID := r.URL.Query().Get("room_id"); roomID != "" {
+		return id.RoomID(roomID)
+	}
+	return id.RoomID(s.cfg().Hooks.Grafana.RoomID)
+}
+
+// handleGrafana receives Grafana webhook notifications and posts them to
+// Matrix, uploading panel images as Matrix images
+func (s *Server) handleGrafana(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Grafana hook called")
+
+	if !hasBearerToken(r, s.cfg().Hooks.Grafana.Token) {
+		s.logger.Warn("Rejecting Grafana hook call with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload GrafanaPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.logger.Error("Invalid Grafana payload: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	roomID := s.grafanaRoom(r)
+	s.logger.Info("Posting Grafana notification %q (%s) to room %q", payload.Title, payload.state(), roomID)
+
+	message := formatGrafanaNotification(&payload)
+	// Held notifications are posted without their images
+	if s.holdNotification(r.Context(), quiet.Notification{Source: "grafana", RoomID: string(roomID), Message: message, Sender: "grafana"}) {
+		writeHeldResponse(w)
+		return
+	}
+
+	eventID, err := s.matrix.SendMessage(message, matrix.WithRoom(roomID), matrix.WithSender("grafana"), withRequestID(r))
+	if err != nil {
+		s.logger.Error("Failed to send Grafana notification to Matrix: %v", err)
+		http.Error(w, "Failed to send notification to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	// Images are best effort: the notification itself has been delivered
+	if cfg := s.cfg(); cfg.Hooks.Grafana.UploadImages {
+		maxSize := cfg.Attachments.Outbound.MaxSize(cfg.Server.MediaMaxSizeMB)
+		for _, imageURL := range payload.imageURLs() {
+			s.postGrafanaImage(r, imageURL, roomID, maxSize)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
+
+// postGrafanaImage downloads a rendered panel image and posts it to the room
+func (s *Server) postGrafanaImage(r *http.Request, imageURL string, roomID id.RoomID, maxSize int64) {
+	parsed, err := url.Parse(imageURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		s.logger.Warn("Skipping Grafana image with invalid URL %q", imageURL)
+		return
+	}
+
+	upload, err := s.downloadMedia(r.Context(), parsed, maxSize)
+	if err != nil {
+		s.logger.Warn("Failed to download Grafana image %s: %v", imageURL, err)
+		return
+	}
+	if !strings.HasPrefix(upload.mimeType, "image/") {
+		s.logger.Warn("Skipping Grafana image %s with content type %s", imageURL, upload.mimeType)
+		return
+	}
+	if err := attachmentTypeError(&s.cfg().Attachments.Outbound, upload.mimeType); err != nil {
+		s.logger.Warn("Skipping Grafana image %s: %v", imageURL, err)
+		return
+	}
+
+	if _, err := s.matrix.SendMedia(upload.data, upload.filename, upload.mimeType, "", matrix.WithRoom(roomID), matrix.WithSender("grafana"), withRequestID(r)); err != nil {
+		s.logger.Error("Failed to send Grafana image to Matrix: %v", err)
+	}
+}
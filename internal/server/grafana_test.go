@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestFormatGrafanaNotificationUnified(t *testing.T) {
+	body := `{
+		"receiver": "matrix",
+		"status": "firing",
+		"title": "[FIRING:1] DiskFull",
+		"message": "Disk usage on db-1 is 97%",
+		"alerts": [{
+			"status": "firing",
+			"labels": {"alertname": "DiskFull"},
+			"dashboardURL": "http://grafana/d/abc",
+			"silenceURL": "http://grafana/alerting/silence/new",
+			"imageURL": "http://grafana/render/panel.png"
+		}]
+	}`
+	var payload GrafanaPayload
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	message := formatGrafanaNotification(&payload)
+	expected := []string{
+		"🔥 **[FIRING:1] DiskFull**",
+		"Disk usage on db-1 is 97%",
+		"- DiskFull: [dashboard](http://grafana/d/abc) | [silence](http://grafana/alerting/silence/new)",
+	}
+	for _, line := range expected {
+		if !strings.Contains(message, line) {
+			t.Errorf("formatGrafanaNotification() = %q, missing %q", message, line)
+		}
+	}
+}
+
+func TestFormatGrafanaNotificationLegacy(t *testing.T) {
+	payload := &GrafanaPayload{
+		State:       "ok",
+		RuleName:    "CPU usage",
+		RuleURL:     "http://grafana/d/cpu",
+		EvalMatches: []GrafanaEvalMatch{{Metric: "cpu", Value: 12.5}},
+	}
+
+	message := formatGrafanaNotification(payload)
+	expected := "✅ **CPU usage** [view](http://grafana/d/cpu)\n\n- `cpu`: 12.5"
+	if message != expected {
+		t.Errorf("formatGrafanaNotification() = %q, want %q", message, expected)
+	}
+}
+
+func TestGrafanaImageURLs(t *testing.T) {
+	payload := &GrafanaPayload{
+		ImageURL: "http://grafana/a.png",
+		Alerts: []GrafanaAlert{
+			{ImageURL: "http://grafana/a.png"},
+			{ImageURL: "http://grafana/b.png"},
+			{},
+		},
+	}
+
+	expected := []string{"http://grafana/a.png", "http://grafana/b.png"}
+	if got := payload.imageURLs(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("imageURLs() = %v, want %v", got, expected)
+	}
+}
+
+func TestGrafanaRoom(t *testing.T) {
+	s := &Server{config: &config.Config{Hooks: config.HooksConfig{Grafana: config.GrafanaConfig{RoomID: "!grafana:matrix.org"}}}}
+
+	req := httptest.NewRequest(http.MethodPost, "/hook/grafana", nil)
+	if got := s.grafanaRoom(req); got != "!grafana:matrix.org" {
+		t.Errorf("grafanaRoom() = %q, want %q", got, "!grafana:matrix.org")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/hook/grafana?room_id=!other:matrix.org", nil)
+	if got := s.grafanaRoom(req); got != "!other:matrix.org" {
+		t.Errorf("grafanaRoom() = %q, want %q", got, "!other:matrix.org")
+	}
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/homeassistant"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// homeAssistantCommand is a compiled /ha command
+type homeAssistantCommand struct {
+	domain  string
+	service string
+	data    map[string]interface{} // String values replaced by their templates
+	reply   *template.Template     // nil for the built-in reply
+}
+
+// homeAssistantWatch is a compiled homeassistant.watch entry
+type homeAssistantWatch struct {
+	entities []string
+	to       []string
+	event    string
+	template *template.Template // nil for the built-in message
+	roomID   id.RoomID
+}
+
+// homeAssistantSetup holds the compiled commands and watches, nil unless
+// homeassistant.enabled is set
+type homeAssistantSetup struct {
+	commands map[string]*homeAssistantCommand
+	watches  []*homeAssistantWatch
+}
+
+// eventTypes returns the event types the watches need, nil if none
+func (h *homeAssistantSetup) eventTypes() []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, watch := range h.watches {
+		eventType := watch.event
+		if eventType == "" {
+			eventType = homeassistant.EventStateChanged
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			types = append(types, eventType)
+		}
+	}
+	return types
+}
+
+// compileHomeAssistant parses the templates of the commands and watches of
+// homeassistant, nil unless it is enabled
+func compileHomeAssistant(cfg *config.HomeAssistantConfig, defaultRoom string) (*homeAssistantSetup, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	parse := func(name, text string) (*template.Template, error) {
+		return template.New(name).Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(text)
+	}
+
+	setup := &homeAssistantSetup{commands: make(map[string]*homeAssistantCommand, len(cfg.Commands))}
+	for name, commandCfg := range cfg.Commands {
+		domain, service, _ := strings.Cut(commandCfg.Service, ".")
+		command := &homeAssistantCommand{domain: domain, service: service}
+		data, err := compileHomeAssistantData(commandCfg.Data, "homeassistant.commands."+name+".data", parse)
+		if err != nil {
+			return nil, err
+		}
+		command.data, _ = data.(map[string]interface{})
+		if commandCfg.Reply != "" {
+			if command.reply, err = parse(name, commandCfg.Reply); err != nil {
+				return nil, fmt.Errorf("homeassistant.commands.%s.reply: invalid template: %w", name, err)
+			}
+		}
+		setup.commands[name] = command
+	}
+
+	room := cfg.RoomID
+	if room == "" {
+		room = defaultRoom
+	}
+	for i, watchCfg := range cfg.Watch {
+		watch := &homeAssistantWatch{entities: watchCfg.Entities, to: watchCfg.To, event: watchCfg.Event, roomID: id.RoomID(watchCfg.RoomID)}
+		if watch.roomID == "" {
+			watch.roomID = id.RoomID(room)
+		}
+		for _, pattern := range watch.entities {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("homeassistant.watch[%d].entities: invalid pattern %q", i, pattern)
+			}
+		}
+		if watchCfg.Template != "" {
+			var err error
+			if watch.template, err = parse(fmt.Sprintf("watch[%d]", i), watchCfg.Template); err != nil {
+				return nil, fmt.Errorf("homeassistant.watch[%d].template: invalid template: %w", i, err)
+			}
+		}
+		setup.watches = append(setup.watches, watch)
+	}
+	return setup, nil
+}
+
+// compileHomeAssistantData replaces the strings of service data, however
+// deeply nested, by their templates
+func compileHomeAssistantData(value interface{}, name string, parse func(name, text string) (*template.Template, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		tpl, err := parse(name, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid template: %w", name, err)
+		}
+		return tpl, nil
+	case map[string]interface{}:
+		compiled := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var err error
+			if compiled[key], err = compileHomeAssistantData(item, name+"."+key, parse); err != nil {
+				return nil, err
+			}
+		}
+		return compiled, nil
+	case []interface{}:
+		compiled := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if compiled[i], err = compileHomeAssistantData(item, fmt.Sprintf("%s[%d]", name, i), parse); err != nil {
+				return nil, err
+			}
+		}
+		return compiled, nil
+	default:
+		return value, nil
+	}
+}
+
+// renderHomeAssistantData executes the templates of compiled service data
+func renderHomeAssistantData(value interface{}, data interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *template.Template:
+		var b strings.Builder
+		if err := v.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name(), err)
+		}
+		return b.String(), nil
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var err error
+			if rendered[key], err = renderHomeAssistantData(item, data); err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if rendered[i], err = renderHomeAssistantData(item, data); err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+// isHomeAssistantCommand reports whether the message is an /ha command
+func isHomeAssistantCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/ha"
+}
+
+// handleHomeAssistantCommand lists the commands, shows the state of an
+// entity or calls the service of a command:
+// /ha
+// /ha state <entity_id>
+// /ha <command> [arguments]
+func (s *Server) handleHomeAssistantCommand(ctx context.Context, sender id.UserID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	log := s.logger.Ctx(ctx)
+	roomID := replyRoom(ctx)
+
+	fields := strings.Fields(message)
+	if len(fields) < 2 {
+		reply(s.homeAssistantUsage(roomID))
+		return
+	}
+	name := strings.ToLower(fields[1])
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), fields[0]))
+	args := strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+
+	if name == "state" {
+		if len(fields) != 3 {
+			reply(s.text(roomID, "homeassistant.state_usage"))
+			return
+		}
+		state, err := s.homeAssistant.GetState(ctx, fields[2])
+		if errors.Is(err, homeassistant.ErrNotFound) {
+			reply(s.text(roomID, "homeassistant.no_entity", fields[2]))
+			return
+		}
+		if err != nil {
+			log.Error("Failed to read Home Assistant entity %s: %v", fields[2], err)
+			reply(s.text(roomID, "homeassistant.read_failed", fields[2], err))
+			return
+		}
+		reply(formatHomeAssistantState(state))
+		return
+	}
+
+	command, exists := s.homeAssistantSetup.commands[name]
+	if !exists {
+		reply(s.text(roomID, "homeassistant.unknown", name) + " " + s.homeAssistantUsage(roomID))
+		return
+	}
+	if !homeAssistantAllows(s.cfg().HomeAssistant.AllowedUsers, sender) {
+		log.Warn("User %s is not in homeassistant.allowed_users, refusing /ha %s", sender, name)
+		reply(s.text(roomID, "homeassistant.denied"))
+		return
+	}
+
+	vars := map[string]interface{}{"args": args, "sender": string(sender)}
+	rendered, err := renderHomeAssistantData(command.data, vars)
+	if err != nil {
+		log.Error("Failed to render the data of /ha %s: %v", name, err)
+		reply(s.text(roomID, "homeassistant.render_failed", err))
+		return
+	}
+	serviceData, _ := rendered.(map[string]interface{})
+	states, err := s.homeAssistant.CallService(ctx, command.domain, command.service, serviceData)
+	if errors.Is(err, homeassistant.ErrNotFound) {
+		err = fmt.Errorf("service %s.%s does not exist", command.domain, command.service)
+	}
+	if err != nil {
+		log.Error("Failed to call Home Assistant service %s.%s: %v", command.domain, command.service, err)
+		reply(s.text(roomID, "homeassistant.call_failed", command.domain+"."+command.service, err))
+		return
+	}
+	log.Info("%s called Home Assistant service %s.%s with /ha %s", sender, command.domain, command.service, name)
+
+	if command.reply == nil {
+		reply(formatHomeAssistantCall(command, states))
+		return
+	}
+	vars["states"] = homeAssistantStateValues(states)
+	var b strings.Builder
+	if err := command.reply.Execute(&b, vars); err != nil {
+		log.Error("Failed to render the reply of /ha %s: %v", name, err)
+		reply(formatHomeAssistantCall(command, states))
+		return
+	}
+	if text := strings.TrimSpace(b.String()); text != "" {
+		reply(text)
+	}
+}
+
+// homeAssistantUsage lists the commands of homeassistant.commands
+func (s *Server) homeAssistantUsage(roomID id.RoomID) string {
+	names := make([]string, 0, len(s.homeAssistantSetup.commands))
+	for name := range s.homeAssistantSetup.commands {
+		names = append(names, "`"+name+"`")
+	}
+	sort.Strings(names)
+	usage := s.text(roomID, "homeassistant.usage")
+	if len(names) > 0 {
+		usage += ". " + s.text(roomID, "homeassistant.commands", strings.Join(names, ", "))
+	}
+	return usage
+}
+
+// homeAssistantAllows reports whether a user may run /ha commands
+func homeAssistantAllows(allowedUsers []string, sender id.UserID) bool {
+	if len(allowedUsers) == 0 {
+		return true
+	}
+	for _, user := range allowedUsers {
+		if user == string(sender) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHomeAssistantState renders a state, e.g. **Living room** (`sensor.temperature`): 21.5 °C
+func formatHomeAssistantState(state *homeassistant.State) string {
+	value := state.State
+	if unit, ok := state.Attributes["unit_of_measurement"].(string); ok && unit != "" {
+		value += " " + unit
+	}
+	return fmt.Sprintf("**%s** (`%s`): %s", state.Name(), state.EntityID, value)
+}
+
+// formatHomeAssistantCall confirms a service call with the states it changed
+func formatHomeAssistantCall(command *homeAssistantCommand, states []homeassistant.State) string {
+	text := fmt.Sprintf("Called `%s.%s`", command.domain, command.service)
+	if len(states) == 0 {
+		return text
+	}
+	changes := make([]string, 0, len(states))
+	for i := range states {
+		changes = append(changes, fmt.Sprintf("%s is %s", states[i].Name(), states[i].State))
+	}
+	return text + ": " + strings.Join(changes, ", ")
+}
+
+// homeAssistantStateValues converts states to the maps templates see, with
+// the keys of the Home Assistant API
+func homeAssistantStateValues(states []homeassistant.State) []map[string]interface{} {
+	values := make([]map[string]interface{}, 0, len(states))
+	for i := range states {
+		values = append(values, homeAssistantStateValue(&states[i]))
+	}
+	return values
+}
+
+func homeAssistantStateValue(state *homeassistant.State) map[string]interface{} {
+	return map[string]interface{}{
+		"entity_id":    state.EntityID,
+		"name":         state.Name(),
+		"state":        state.State,
+		"attributes":   state.Attributes,
+		"last_changed": state.LastChanged,
+	}
+}
+
+// handleHomeAssistantEvent posts the state changes and events the watches
+// select. Changes of attributes only are not posted.
+func (s *Server) handleHomeAssistantEvent(e *homeassistant.Event) {
+	for _, post := range homeAssistantPosts(s.homeAssistantSetup.watches, e, s.logger.Warn) {
+		if s.holdNotification(context.Background(), quiet.Notification{Source: "homeassistant", RoomID: string(post.roomID), Message: post.text, MsgType: matrix.MsgTypeNotice}) {
+			continue
+		}
+		if _, err := s.matrix.SendMessage(post.text, matrix.WithRoom(post.roomID), matrix.WithMsgType(matrix.MsgTypeNotice)); err != nil {
+			s.logger.Error("Failed to post Home Assistant %s event: %v", e.EventType, err)
+		}
+	}
+}
+
+// homeAssistantPost is a message of a watched change
+type homeAssistantPost struct {
+	roomID id.RoomID
+	text   string
+}
+
+// homeAssistantPosts renders the messages of the watches selecting an event
+func homeAssistantPosts(watches []*homeAssistantWatch, e *homeassistant.Event, warn func(format string, args ...interface{})) []homeAssistantPost {
+	var vars map[string]interface{}
+	var change *homeassistant.StateChange
+	if e.EventType == homeassistant.EventStateChanged {
+		var err error
+		if change, err = e.StateChange(); err != nil {
+			warn("Ignoring Home Assistant event: %v", err)
+			return nil
+		}
+		if change.NewState == nil || (change.OldState != nil && change.OldState.State == change.NewState.State) {
+			return nil
+		}
+		vars = homeAssistantStateValue(change.NewState)
+		vars["to"] = change.NewState.State
+		vars["from"] = ""
+		if change.OldState != nil {
+			vars["from"] = change.OldState.State
+		}
+	} else {
+		var data map[string]interface{}
+		json.Unmarshal(e.Data, &data)
+		vars = map[string]interface{}{"event_type": e.EventType, "data": data}
+	}
+
+	var posts []homeAssistantPost
+	for _, watch := range watches {
+		if change != nil {
+			if !homeAssistantWatchMatches(watch, change.EntityID, change.NewState.State) {
+				continue
+			}
+		} else if watch.event != e.EventType {
+			continue
+		}
+
+		var text string
+		if watch.template == nil {
+			text = formatHomeAssistantEvent(e.EventType, vars)
+		} else {
+			var b strings.Builder
+			if err := watch.template.Execute(&b, vars); err != nil {
+				warn("Failed to render the Home Assistant %s message: %v", e.EventType, err)
+				continue
+			}
+			text = strings.TrimSpace(b.String())
+		}
+		// An empty message lets templates filter
+		if text != "" {
+			posts = append(posts, homeAssistantPost{roomID: watch.roomID, text: text})
+		}
+	}
+	return posts
+}
+
+// homeAssistantWatchMatches reports whether a watch of entities selects a
+// change of an entity to a state
+func homeAssistantWatchMatches(watch *homeAssistantWatch, entityID, state string) bool {
+	if watch.event != "" {
+		return false
+	}
+	matched := false
+	for _, pattern := range watch.entities {
+		if ok, _ := path.Match(pattern, entityID); ok {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	if len(watch.to) == 0 {
+		return true
+	}
+	for _, to := range watch.to {
+		if to == state {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHomeAssistantEvent is the built-in message of a watched change
+func formatHomeAssistantEvent(eventType string, vars map[string]interface{}) string {
+	if eventType == homeassistant.EventStateChanged {
+		text := fmt.Sprintf("🏠 **%s** is %s", vars["name"], vars["to"])
+		if from, _ := vars["from"].(string); from != "" {
+			text += fmt.Sprintf(" (was %s)", from)
+		}
+		return text
+	}
+	text := fmt.Sprintf("🏠 Home Assistant event `%s`", eventType)
+	if data, _ := vars["data"].(map[string]interface{}); len(data) > 0 {
+		encoded, _ := json.Marshal(data)
+		text += ": `" + string(encoded) + "`"
+	}
+	return text
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/homeassistant"
+)
+
+func TestHomeAssistantCommandData(t *testing.T) {
+	cfg := &config.HomeAssistantConfig{
+		Enabled: true,
+		Commands: map[string]config.HomeAssistantCommandConfig{
+			"dim": {Service: "light.turn_on", Data: map[string]interface{}{
+				"entity_id":  []interface{}{"light.kitchen", "light.{{ .args | lower }}"},
+				"brightness": 80,
+				"message":    map[string]interface{}{"by": "{{ .sender }}"},
+			}},
+		},
+	}
+	setup, err := compileHomeAssistant(cfg, "!default:example.com")
+	if err != nil {
+		t.Fatalf("compileHomeAssistant() error = %v", err)
+	}
+	command := setup.commands["dim"]
+	if command.domain != "light" || command.service != "turn_on" {
+		t.Errorf("service = %s.%s, want light.turn_on", command.domain, command.service)
+	}
+
+	rendered, err := renderHomeAssistantData(command.data, map[string]interface{}{"args": "Hall", "sender": "@alice:example.com"})
+	if err != nil {
+		t.Fatalf("renderHomeAssistantData() error = %v", err)
+	}
+	expected := map[string]interface{}{
+		"entity_id":  []interface{}{"light.kitchen", "light.hall"},
+		"brightness": 80,
+		"message":    map[string]interface{}{"by": "@alice:example.com"},
+	}
+	if !reflect.DeepEqual(rendered, expected) {
+		t.Errorf("renderHomeAssistantData() = %v, want %v", rendered, expected)
+	}
+
+	cfg.Commands["broken"] = config.HomeAssistantCommandConfig{Service: "light.turn_on", Data: map[string]interface{}{"entity_id": "{{ .args"}}
+	if _, err := compileHomeAssistant(cfg, ""); err == nil {
+		t.Error("compileHomeAssistant() accepted an invalid data template")
+	}
+}
+
+func TestHomeAssistantPosts(t *testing.T) {
+	cfg := &config.HomeAssistantConfig{
+		Enabled: true,
+		RoomID:  "!home:example.com",
+		Watch: []config.HomeAssistantWatchConfig{
+			{Entities: []string{"binary_sensor.*_door"}},
+			{Entities: []string{"binary_sensor.front_door"}, To: []string{"on"}, Template: "{{ .name }} opened", RoomID: "!alerts:example.com"},
+			{Event: "doorbell_pressed", Template: "Ding dong at {{ .data.door }}"},
+		},
+	}
+	setup, err := compileHomeAssistant(cfg, "!default:example.com")
+	if err != nil {
+		t.Fatalf("compileHomeAssistant() error = %v", err)
+	}
+	if types := setup.eventTypes(); !reflect.DeepEqual(types, []string{"state_changed", "doorbell_pressed"}) {
+		t.Errorf("eventTypes() = %v", types)
+	}
+
+	stateChange := func(entityID, from, to string) *homeassistant.Event {
+		data, _ := json.Marshal(map[string]interface{}{
+			"entity_id": entityID,
+			"old_state": map[string]interface{}{"entity_id": entityID, "state": from},
+			"new_state": map[string]interface{}{"entity_id": entityID, "state": to, "attributes": map[string]string{"friendly_name": "Front door"}},
+		})
+		return &homeassistant.Event{EventType: homeassistant.EventStateChanged, Data: data}
+	}
+	tests := []struct {
+		name     string
+		event    *homeassistant.Event
+		expected []homeAssistantPost
+	}{
+		{"Opened", stateChange("binary_sensor.front_door", "off", "on"), []homeAssistantPost{
+			{roomID: "!home:example.com", text: "🏠 **Front door** is on (was off)"},
+			{roomID: "!alerts:example.com", text: "Front door opened"},
+		}},
+		{"Closed", stateChange("binary_sensor.front_door", "on", "off"), []homeAssistantPost{
+			{roomID: "!home:example.com", text: "🏠 **Front door** is off (was on)"},
+		}},
+		{"Attributes only", stateChange("binary_sensor.front_door", "on", "on"), nil},
+		{"Not watched", stateChange("light.kitchen", "off", "on"), nil},
+		{"Event", &homeassistant.Event{EventType: "doorbell_pressed", Data: json.RawMessage(`{"door": "back"}`)}, []homeAssistantPost{
+			{roomID: "!home:example.com", text: "Ding dong at back"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts := homeAssistantPosts(setup.watches, tt.event, t.Logf)
+			if !reflect.DeepEqual(posts, tt.expected) {
+				t.Errorf("homeAssistantPosts() = %v, want %v", posts, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatHomeAssistantState(t *testing.T) {
+	state := &homeassistant.State{EntityID: "sensor.temperature", State: "21.5", Attributes: map[string]interface{}{"friendly_name": "Living room", "unit_of_measurement": "°C"}}
+	expected := "**Living room** (`sensor.temperature`): 21.5 °C"
+	if got := formatHomeAssistantState(state); got != expected {
+		t.Errorf("formatHomeAssistantState() = %q, want %q", got, expected)
+	}
+}
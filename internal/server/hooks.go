@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+)
+
+// customHook is a compiled config-defined inbound hook
+type customHook struct {
+	name     string
+	config   config.CustomHookConfig
+	jq       *gojq.Code
+	template *template.Template
+}
+
+// compileCustomHooks compiles the jq programs and templates of the configured hooks
+func compileCustomHooks(hooks map[string]config.CustomHookConfig) (map[string]*customHook, error) {
+	compiled := make(map[string]*customHook, len(hooks))
+	for name, cfg := range hooks {
+		if name == "alertmanager" || name == "grafana" || name == "discord" || name == "pagerduty" {
+			return nil, fmt.Errorf("hooks.custom.%s: name is reserved for the built-in receiver", name)
+		}
+		hook, err := compileCustomHook(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("hooks.custom.%s: %w", name, err)
+		}
+		compiled[name] = hook
+	}
+	return compiled, nil
+}
+
+func compileCustomHook(name string, cfg config.CustomHookConfig) (*customHook, error) {
+	if cfg.JQ == "" && cfg.Template == "" {
+		return nil, fmt.Errorf("at least one of jq or template must be set")
+	}
+
+	// The delivery settings are validated like a POST /message request
+	delivery := MessageRequest{RoomID: cfg.RoomID, Format: cfg.Format, MsgType: cfg.MsgType}
+	if err := delivery.validate(); err != nil {
+		return nil, err
+	}
+
+	hook := &customHook{name: name, config: cfg}
+
+	if cfg.JQ != "" {
+		query, err := gojq.Parse(cfg.JQ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jq program: %w", err)
+		}
+		hook.jq, err = gojq.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jq program: %w", err)
+		}
+	}
+
+	if cfg.Template != "" {
+		funcs := template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				data, err := json.Marshal(v)
+				return string(data), err
+			},
+			"upper": strings.ToUpper,
+			"lower": strings.ToLower,
+			"join":  strings.Join,
+		}
+		var err error
+		hook.template, err = template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	return hook, nil
+}
+
+// render transforms the incoming JSON into the message text. The jq program
+// runs first; with a single result the template receives that result,
+// otherwise the list of results. Without a template the jq results are
+// joined with newlines, strings without quotes.
+func (h *customHook) render(input interface{}) (string, error) {
+	data := input
+	if h.jq != nil {
+		var results []interface{}
+		iter := h.jq.Run(input)
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				return "", fmt.Errorf("jq execution error: %w", err)
+			}
+			results = append(results, v)
+		}
+
+		if h.template == nil {
+			return joinJQResults(results)
+		}
+		if len(results) == 1 {
+			data = results[0]
+		} else {
+			data = results
+		}
+	}
+
+	var b strings.Builder
+	if err := h.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template execution error: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// joinJQResults joins jq results into text, skipping null results
+func joinJQResults(results []interface{}) (string, error) {
+	var lines []string
+	for _, v := range results {
+		switch val := v.(type) {
+		case nil:
+		case string:
+			lines = append(lines, val)
+		default:
+			jsonBytes, err := json.Marshal(val)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal jq result: %w", err)
+			}
+			lines = append(lines, string(jsonBytes))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// handleCustomHook receives a call to a config-defined hook, transforms the
+// JSON body and posts the result to the hook's room. An empty result (e.g.
+// a jq select() that filtered the event out) is not posted.
+func (s *Server) handleCustomHook(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	s.configMutex.RLock()
+	hook, exists := s.customHooks[name]
+	s.configMutex.RUnlock()
+	if !exists {
+		http.Error(w, "Unknown hook", http.StatusNotFound)
+		return
+	}
+
+	s.logger.Info("Custom hook %q called", name)
+
+	if !hasBearerToken(r, hook.config.Secret) {
+		s.logger.Warn("Rejecting call to hook %q with missing or invalid secret", name)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input interface{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		s.logger.Error("Invalid JSON in call to hook %q: %v", name, err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	message, err := hook.render(input)
+	if err != nil {
+		s.logger.Error("Failed to render hook %q: %v", name, err)
+		http.Error(w, fmt.Sprintf("Failed to render message: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if message == "" {
+		s.logger.Info("Hook %q rendered an empty message, nothing posted", name)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SendResponse{Status: "skipped"})
+		return
+	}
+
+	if s.holdNotification(r.Context(), quiet.Notification{
+		Source: "hook:" + name, RoomID: hook.config.RoomID, Message: message, Format: hook.config.Format, MsgType: hook.config.MsgType, Sender: name,
+	}) {
+		writeHeldResponse(w)
+		return
+	}
+
+	delivery := MessageRequest{RoomID: hook.config.RoomID, Format: hook.config.Format, MsgType: hook.config.MsgType}
+	eventID, err := s.matrix.SendMessage(message, append(delivery.sendOptions(), matrix.WithSender(name), withRequestID(r))...)
+	if err != nil {
+		s.logger.Error("Failed to send message of hook %q to Matrix: %v", name, err)
+		http.Error(w, "Failed to send message to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
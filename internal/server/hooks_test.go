@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestCustomHookRender(t *testing.T) {
+	input := map[string]interface{}{
+		"event": "deploy",
+		"app":   "api",
+		"hosts": []interface{}{"web-1", "web-2"},
+	}
+
+	tests := []struct {
+		name     string
+		config   config.CustomHookConfig
+		expected string
+	}{
+		{
+			name:     "Template only",
+			config:   config.CustomHookConfig{Template: "Deployed **{{ .app }}** to {{ len .hosts }} hosts"},
+			expected: "Deployed **api** to 2 hosts",
+		},
+		{
+			name:     "jq only",
+			config:   config.CustomHookConfig{JQ: `"\(.app): \(.hosts | join(", "))"`},
+			expected: "api: web-1, web-2",
+		},
+		{
+			name:     "jq feeds template",
+			config:   config.CustomHookConfig{JQ: "{name: .app, count: (.hosts | length)}", Template: "{{ upper .name }} x{{ .count }}"},
+			expected: "API x2",
+		},
+		{
+			name:     "jq filter drops event",
+			config:   config.CustomHookConfig{JQ: `select(.event == "build") | .app`},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, err := compileCustomHook("test", tt.config)
+			if err != nil {
+				t.Fatalf("compileCustomHook() error = %v", err)
+			}
+			got, err := hook.render(input)
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("render() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompileCustomHooksErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   map[string]config.CustomHookConfig
+		wantErr string
+	}{
+		{"No transform", map[string]config.CustomHookConfig{"ci": {}}, "at least one of jq or template"},
+		{"Invalid jq", map[string]config.CustomHookConfig{"ci": {JQ: ".foo |"}}, "invalid jq program"},
+		{"Invalid template", map[string]config.CustomHookConfig{"ci": {Template: "{{ .foo"}}, "invalid template"},
+		{"Invalid format", map[string]config.CustomHookConfig{"ci": {Template: "x", Format: "rtf"}}, "invalid format"},
+		{"Reserved name", map[string]config.CustomHookConfig{"grafana": {Template: "x"}}, "reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileCustomHooks(tt.hooks)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compileCustomHooks() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleCustomHook(t *testing.T) {
+	hooks, err := compileCustomHooks(map[string]config.CustomHookConfig{
+		"ci": {Secret: "s3cret", JQ: `select(.status == "failed") | .job`},
+	})
+	if err != nil {
+		t.Fatalf("compileCustomHooks() error = %v", err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{logger: log, customHooks: hooks}
+
+	router := chi.NewRouter()
+	router.Post("/hook/{name}", s.handleCustomHook)
+
+	tests := []struct {
+		name       string
+		path       string
+		auth       string
+		body       string
+		wantStatus int
+	}{
+		{"Unknown hook", "/hook/missing", "Bearer s3cret", `{}`, http.StatusNotFound},
+		{"Missing secret", "/hook/ci", "", `{}`, http.StatusUnauthorized},
+		{"Invalid JSON", "/hook/ci", "Bearer s3cret", `{`, http.StatusBadRequest},
+		{"Filtered out", "/hook/ci", "Bearer s3cret", `{"status": "passed", "job": "lint"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var resp map[string]string
+				json.NewDecoder(rec.Body).Decode(&resp)
+				if resp["status"] != "skipped" {
+					t.Errorf("response status = %q, want %q", resp["status"], "skipped")
+				}
+			}
+		})
+	}
+}
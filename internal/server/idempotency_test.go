@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHandleMessageDispatchesOnce(t *testing.T) {
+	type dispatch struct {
+		header  string
+		payload map[string]string
+	}
+	dispatches := make(chan dispatch, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]string
+		json.Unmarshal(body, &payload)
+		dispatches <- dispatch{header: r.Header.Get(webhook.IdempotencyHeader), payload: payload}
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Webhook: config.WebhookConfig{
+		Default:     target.URL,
+		Template:    `{"message": "{{.MESSAGE}}"}`,
+		Idempotency: config.IdempotencyConfig{Enabled: true, TTL: 3600},
+	}}
+	store := storage.NewMemory()
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, dedup.New(store, 0, log), log)}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "deploy", "", "", "$event")
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "deploy", "", "", "$event")
+	// After a restart, the delivery is still known
+	s.webhook = webhook.New(&cfg.Webhook, dedup.New(store, 0, log), log)
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "deploy", "", "", "$event")
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "deploy", "", "", "$other")
+
+	expected := []string{webhook.IdempotencyKey("$event"), webhook.IdempotencyKey("$other")}
+	for _, key := range expected {
+		select {
+		case d := <-dispatches:
+			if d.header != key || d.payload["idempotency_key"] != key || d.payload["message"] != "deploy" {
+				t.Errorf("dispatch = %+v, want idempotency key %s in the header and payload", d, key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dispatch with key %s not received", key)
+		}
+	}
+	select {
+	case d := <-dispatches:
+		t.Errorf("unexpected dispatch %+v", d)
+	default:
+	}
+}
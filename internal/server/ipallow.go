@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Route groups that server.ip_allowlists can restrict
+var ipAllowlistGroups = map[string]string{
+	"api":        "/message, /media, /reaction and /notify/*",
+	"hooks":      "/hook/*",
+	"stream":     "/ws and /events",
+	"admin":      "/admin/*",
+	"debug":      "/debug/*",
+	"metrics":    "/metrics",
+	"appservice": "/_matrix/app/v1/*",
+}
+
+// ipAllowlist is a compiled list of networks allowed to call a route group
+type ipAllowlist []netip.Prefix
+
+// contains reports whether ip is in one of the networks
+func (l ipAllowlist) contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileIPAllowlists parses the CIDRs of every route group. Plain addresses
+// are accepted as single-host networks. Groups without entries are left out
+// and stay open.
+func compileIPAllowlists(lists map[string][]string) (map[string]ipAllowlist, error) {
+	compiled := make(map[string]ipAllowlist, len(lists))
+	for group, entries := range lists {
+		if _, known := ipAllowlistGroups[group]; !known {
+			return nil, fmt.Errorf("unknown route group %q, expected one of %s", group, strings.Join(ipAllowlistGroupNames(), ", "))
+		}
+		var allowlist ipAllowlist
+		for _, entry := range entries {
+			prefix, err := parseAllowlistEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", group, err)
+			}
+			allowlist = append(allowlist, prefix)
+		}
+		if len(allowlist) > 0 {
+			compiled[group] = allowlist
+		}
+	}
+	return compiled, nil
+}
+
+// compileTrustedProxies parses server.trusted_proxies like an allowlist
+func compileTrustedProxies(entries []string) (ipAllowlist, error) {
+	var proxies ipAllowlist
+	for _, entry := range entries {
+		prefix, err := parseAllowlistEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+func parseAllowlistEntry(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func ipAllowlistGroupNames() []string {
+	names := make([]string, 0, len(ipAllowlistGroups))
+	for name := range ipAllowlistGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allowIPs rejects clients outside the allowlist of a route group. It is
+// checked in addition to any token the routes require.
+func (s *Server) allowIPs(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.configMutex.RLock()
+			allowlist := s.ipAllowlists[group]
+			s.configMutex.RUnlock()
+			if allowlist == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := s.clientIP(r)
+			ip, err := netip.ParseAddr(client)
+			if err != nil || !allowlist.contains(ip) {
+				s.logger.Warn("Rejecting %s %s from %s, not in the %s allowlist", r.Method, r.URL.Path, client, group)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
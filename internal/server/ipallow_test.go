@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestCompileIPAllowlists(t *testing.T) {
+	tests := []struct {
+		name    string
+		lists   map[string][]string
+		wantErr bool
+	}{
+		{"CIDRs and addresses", map[string][]string{"hooks": {"10.1.0.0/16", "192.168.1.5"}, "admin": {"127.0.0.1", "::1"}}, false},
+		{"Empty group", map[string][]string{"api": {}}, false},
+		{"Unknown group", map[string][]string{"hook": {"10.0.0.0/8"}}, true},
+		{"Invalid CIDR", map[string][]string{"hooks": {"10.0.0.0/33"}}, true},
+		{"Invalid address", map[string][]string{"admin": {"localhost"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileIPAllowlists(tt.lists)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileIPAllowlists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIPAllowlistContains(t *testing.T) {
+	compiled, err := compileIPAllowlists(map[string][]string{"hooks": {"10.1.0.0/16", "::ffff:192.168.1.5"}})
+	if err != nil {
+		t.Fatalf("compileIPAllowlists() error = %v", err)
+	}
+	allowlist := compiled["hooks"]
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.2.0.1", false},
+		{"192.168.1.5", true},
+		{"::ffff:10.1.0.1", true},
+		{"192.168.1.6", false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.contains(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAllowIPsRestrictsRouteGroups(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{
+		AdminToken:   "secret",
+		IPAllowlists: map[string][]string{"admin": {"127.0.0.1"}},
+	}})
+
+	request := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := request("10.0.0.1:4321"); code != http.StatusForbidden {
+		t.Errorf("from outside the allowlist: status = %d, want %d", code, http.StatusForbidden)
+	}
+	if s.paused.Load() {
+		t.Error("rejected request paused message handling")
+	}
+	if code := request("127.0.0.1:4321"); code != http.StatusOK {
+		t.Errorf("from the allowlist: status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Other groups stay open
+	req := httptest.NewRequest(http.MethodPost, "/hook/unknown", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	if rec.Code == http.StatusForbidden {
+		t.Errorf("hook request without allowlist was rejected")
+	}
+}
+
+func TestAllowIPsIgnoresForgedForwardedFor(t *testing.T) {
+	s := newAdminTestServer(t, &config.Config{Server: config.ServerConfig{
+		AdminToken:        "secret",
+		IPAllowlists:      map[string][]string{"admin": {"192.0.2.0/24"}},
+		TrustProxyHeaders: true,
+		TrustedProxies:    []string{"10.0.0.1"},
+	}})
+
+	request := func(remoteAddr, forwarded string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		// The proxy appends the real client after the forged entry
+		{"forged through the proxy", "10.0.0.1:4321", "192.0.2.10, 203.0.113.7", http.StatusForbidden},
+		{"forged without the proxy", "203.0.113.7:4321", "192.0.2.10", http.StatusForbidden},
+		{"allowed through the proxy", "10.0.0.1:4321", "203.0.113.7, 192.0.2.10", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(tt.remoteAddr, tt.forwarded); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/jira"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Time before an issue mentioned again in a room is summarized again
+const jiraExpansionCooldown = 10 * time.Minute
+
+// Issue keys such as OPS-123; digits and letters around them make them
+// something else, e.g. part of a URL path segment or a hash
+var jiraKeyRegex = regexp.MustCompile(`(?:^|[^A-Za-z0-9_\-/])([A-Z][A-Z0-9_]+-[1-9][0-9]*)\b`)
+
+// jiraExpansions remembers when issues were last summarized in each room
+type jiraExpansions struct {
+	mu   sync.Mutex
+	last map[string]time.Time // By room and issue key
+}
+
+func newJiraExpansions() *jiraExpansions {
+	return &jiraExpansions{last: make(map[string]time.Time)}
+}
+
+// due reports whether an issue may be summarized in a room, and if so
+// records that it is
+func (j *jiraExpansions) due(roomID id.RoomID, key string, now time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for k, at := range j.last {
+		if now.Sub(at) >= jiraExpansionCooldown {
+			delete(j.last, k)
+		}
+	}
+	k := string(roomID) + " " + key
+	if _, recent := j.last[k]; recent {
+		return false
+	}
+	j.last[k] = now
+	return true
+}
+
+// jiraKeys returns the distinct issue keys of a text, at most max of them,
+// of the projects listed or of any project if none are
+func jiraKeys(text string, projects []string, max int) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, match := range jiraKeyRegex.FindAllStringSubmatch(text, -1) {
+		key := match[1]
+		if seen[key] || !jiraProjectListed(key, projects) {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+		if len(keys) == max {
+			break
+		}
+	}
+	return keys
+}
+
+func jiraProjectListed(key string, projects []string) bool {
+	if len(projects) == 0 {
+		return true
+	}
+	project := key[:strings.LastIndex(key, "-")]
+	for _, listed := range projects {
+		if listed == project {
+			return true
+		}
+	}
+	return false
+}
+
+// formatJiraIssue renders an issue as one markdown line:
+// [OPS-12](link) Summary · In Progress · Alice
+func formatJiraIssue(issue *jira.Issue, link string) string {
+	parts := []string{fmt.Sprintf("[%s](%s) %s", issue.Key, link, issue.Fields.Summary)}
+	if status := issue.Status(); status != "" {
+		parts = append(parts, status)
+	}
+	if assignee := issue.Assignee(); assignee != "" {
+		parts = append(parts, assignee)
+	} else {
+		parts = append(parts, "Unassigned")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// isJiraCommand reports whether the message is a /jira command
+func isJiraCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/jira"
+}
+
+// handleJiraCommand creates, comments on and transitions issues:
+// /jira create <summary>
+// /jira comment <key> <text>
+// /jira status <key> [new status]
+func (s *Server) handleJiraCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	log := s.logger.Ctx(ctx)
+	cfg := s.cfg().Jira
+	usage := s.text(roomID, "jira.usage")
+
+	// The first line holds the command, the others a description or comment
+	firstLine, rest, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) < 2 {
+		reply(usage)
+		return
+	}
+	attribution := fmt.Sprintf("Sent from Matrix by %s in %s", sender, roomID)
+
+	switch fields[1] {
+	case "create":
+		summary := strings.Join(fields[2:], " ")
+		if summary == "" {
+			reply(usage)
+			return
+		}
+		description := strings.TrimSpace(rest)
+		if description != "" {
+			description += "\n\n"
+		}
+		key, err := s.jira.CreateIssue(ctx, cfg.Project, cfg.IssueType, summary, description+attribution)
+		if err != nil {
+			log.Error("Failed to create Jira issue: %v", err)
+			reply(s.text(roomID, "jira.create_failed", err))
+			return
+		}
+		log.Info("%s created Jira issue %s", sender, key)
+		reply(s.text(roomID, "jira.created", key, s.jira.BrowseURL(key), summary))
+
+	case "comment":
+		if len(fields) < 3 {
+			reply(usage)
+			return
+		}
+		key := strings.ToUpper(fields[2])
+		text := strings.TrimSpace(strings.Join(fields[3:], " ") + "\n" + rest)
+		if text == "" {
+			reply(usage)
+			return
+		}
+		if err := s.jira.AddComment(ctx, key, text+"\n\n"+attribution); err != nil {
+			log.Error("Failed to comment on Jira issue %s: %v", key, err)
+			reply(s.jiraFailure(roomID, "jira.comment_failed", key, err))
+			return
+		}
+		log.Info("%s commented on Jira issue %s", sender, key)
+		reply(s.text(roomID, "jira.commented", key, s.jira.BrowseURL(key)))
+
+	case "status":
+		if len(fields) < 3 {
+			reply(usage)
+			return
+		}
+		key := strings.ToUpper(fields[2])
+		if target := strings.Join(fields[3:], " "); target != "" {
+			if err := s.transitionJiraIssue(ctx, key, target); err != nil {
+				log.Error("Failed to move Jira issue %s to %q: %v", key, target, err)
+				reply(s.jiraFailure(roomID, "jira.move_failed", key, err))
+				return
+			}
+			log.Info("%s moved Jira issue %s to %q", sender, key, target)
+		}
+		issue, err := s.jira.GetIssue(ctx, key)
+		if err != nil {
+			log.Error("Failed to read Jira issue %s: %v", key, err)
+			reply(s.jiraFailure(roomID, "jira.read_failed", key, err))
+			return
+		}
+		reply(formatJiraIssue(issue, s.jira.BrowseURL(issue.Key)))
+
+	default:
+		reply(usage)
+	}
+}
+
+// jiraFailure describes a failed action on an issue with the message
+// failed, unless the issue does not exist
+func (s *Server) jiraFailure(roomID id.RoomID, failed, key string, err error) string {
+	if errors.Is(err, jira.ErrNotFound) {
+		return s.text(roomID, "jira.no_issue", key)
+	}
+	return s.text(roomID, failed, key, err)
+}
+
+// transitionJiraIssue applies the transition named target, or leading to
+// the status named target
+func (s *Server) transitionJiraIssue(ctx context.Context, key, target string) error {
+	transitions, err := s.jira.Transitions(ctx, key)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(transitions))
+	for _, transition := range transitions {
+		if strings.EqualFold(transition.Name, target) || strings.EqualFold(transition.To.Name, target) {
+			return s.jira.Transition(ctx, key, transition.ID)
+		}
+		names = append(names, transition.To.Name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no status can be reached from the current one")
+	}
+	return fmt.Errorf("no transition to %q, available: %s", target, strings.Join(names, ", "))
+}
+
+// expandJiraKeys posts a one-line summary of the issues a message of the
+// room mentions, unless they were summarized in the room recently. The
+// bot's own messages, notices and /jira commands are skipped.
+func (s *Server) expandJiraKeys(evt *event.Event) {
+	cfg := s.cfg()
+	if evt.Type != event.EventMessage || evt.Sender == id.UserID(cfg.Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.MsgType != event.MsgText || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+	text := messageText(content)
+	if strings.Contains(text, "/jira") || !s.roomSettings(evt.RoomID).AllowsUser(string(evt.Sender)) {
+		return
+	}
+	keys := jiraKeys(text, cfg.Jira.ExpandProjects, cfg.Jira.MaxExpansions)
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Jira.Timeout)*time.Second)
+	defer cancel()
+	var lines []string
+	now := time.Now()
+	for _, key := range keys {
+		if !s.jiraExpansions.due(evt.RoomID, key, now) {
+			continue
+		}
+		issue, err := s.jira.GetIssue(ctx, key)
+		if errors.Is(err, jira.ErrNotFound) {
+			// Most likely not an issue key, e.g. UTF-8
+			continue
+		}
+		if err != nil {
+			s.logger.Warn("Failed to read Jira issue %s: %v", key, err)
+			continue
+		}
+		lines = append(lines, formatJiraIssue(issue, s.jira.BrowseURL(issue.Key)))
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	opts := []matrix.SendMessageOption{matrix.WithRoom(evt.RoomID), matrix.WithMsgType(matrix.MsgTypeNotice)}
+	if threadRoot := content.RelatesTo.GetThreadParent(); threadRoot != "" {
+		opts = append(opts, matrix.WithThread(threadRoot))
+	}
+	if _, err := s.matrix.SendMessage(strings.Join(lines, "\n"), opts...); err != nil {
+		s.logger.Error("Failed to post Jira issue summaries: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/jira"
+)
+
+func TestJiraKeys(t *testing.T) {
+	tests := []struct {
+		text     string
+		projects []string
+		expected []string
+	}{
+		{"Is OPS-12 related to (DEV-3)?", nil, []string{"OPS-12", "DEV-3"}},
+		{"OPS-12 again: OPS-12", nil, []string{"OPS-12"}},
+		{"Only OPS-1, not DEV-2", []string{"OPS"}, []string{"OPS-1"}},
+		{"Not keys: UTF8, ops-1, OPS-0, XOPS-1a, https://jira/browse/OPS-5", nil, nil},
+		{"A-1 B2-2 OPS-1 OPS-2 OPS-3 OPS-4", nil, []string{"B2-2", "OPS-1", "OPS-2"}},
+	}
+	for _, tt := range tests {
+		if got := jiraKeys(tt.text, tt.projects, 3); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("jiraKeys(%q) = %v, want %v", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatJiraIssue(t *testing.T) {
+	var issue jira.Issue
+	json.Unmarshal([]byte(`{"key": "OPS-12", "fields": {"summary": "Disk full", "status": {"name": "In Progress"}}}`), &issue)
+
+	expected := "[OPS-12](https://jira.example.com/browse/OPS-12) Disk full · In Progress · Unassigned"
+	if got := formatJiraIssue(&issue, "https://jira.example.com/browse/OPS-12"); got != expected {
+		t.Errorf("formatJiraIssue() = %q, want %q", got, expected)
+	}
+}
+
+func TestJiraExpansionsCooldown(t *testing.T) {
+	expansions := newJiraExpansions()
+	now := time.Now()
+
+	if !expansions.due("!a:example.com", "OPS-1", now) {
+		t.Error("due() = false for a new issue")
+	}
+	if expansions.due("!a:example.com", "OPS-1", now.Add(time.Minute)) {
+		t.Error("due() = true for an issue summarized a minute ago")
+	}
+	if !expansions.due("!b:example.com", "OPS-1", now.Add(time.Minute)) {
+		t.Error("due() = false in another room")
+	}
+	if !expansions.due("!a:example.com", "OPS-1", now.Add(jiraExpansionCooldown)) {
+		t.Error("due() = false after the cooldown")
+	}
+}
+
+func TestTransitionJiraIssue(t *testing.T) {
+	var applied string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body struct {
+				Transition struct {
+					ID string `json:"id"`
+				} `json:"transition"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			applied = body.Transition.ID
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"transitions": [
+			{"id": "21", "name": "Start progress", "to": {"name": "In Progress"}},
+			{"id": "31", "name": "Finish", "to": {"name": "Done"}}
+		]}`))
+	}))
+	defer api.Close()
+
+	s := &Server{jira: jira.NewClient(&config.JiraConfig{URL: api.URL, Token: "pat", Timeout: 5})}
+	ctx := context.Background()
+
+	if err := s.transitionJiraIssue(ctx, "OPS-1", "done"); err != nil || applied != "31" {
+		t.Errorf("transitionJiraIssue(done) = %v, applied %q, want 31", err, applied)
+	}
+	if err := s.transitionJiraIssue(ctx, "OPS-1", "start progress"); err != nil || applied != "21" {
+		t.Errorf("transitionJiraIssue(start progress) = %v, applied %q, want 21", err, applied)
+	}
+	err := s.transitionJiraIssue(ctx, "OPS-1", "Blocked")
+	if err == nil || !strings.Contains(err.Error(), "available: In Progress, Done") {
+		t.Errorf("transitionJiraIssue(Blocked) error = %v, want the available statuses", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// Events announced by lifecycle notices
+const (
+	lifecycleStartup  = "startup"
+	lifecycleShutdown = "shutdown"
+)
+
+// compileLifecycleTemplates parses the templates of lifecycle_notices, keyed
+// by event
+func compileLifecycleTemplates(cfg *config.LifecycleNoticesConfig) (map[string]*template.Template, error) {
+	compiled := make(map[string]*template.Template)
+	for event, text := range map[string]string{lifecycleStartup: cfg.StartupTemplate, lifecycleShutdown: cfg.ShutdownTemplate} {
+		if text == "" {
+			continue
+		}
+		tpl, err := template.New("lifecycle_notices." + event + "_template").Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifecycle_notices.%s_template: %w", event, err)
+		}
+		compiled[event] = tpl
+	}
+	return compiled, nil
+}
+
+// postLifecycleNotice tells the rooms of lifecycle_notices that the service
+// started or is stopping, if lifecycle_notices enables the event
+func (s *Server) postLifecycleNotice(event string) {
+	cfg := s.cfg()
+	if s.matrix == nil || event == lifecycleStartup && !cfg.LifecycleNotices.Startup || event == lifecycleShutdown && !cfg.LifecycleNotices.Shutdown {
+		return
+	}
+	for _, roomID := range cfg.LifecycleNoticeRooms() {
+		notice, err := s.lifecycleNotice(id.RoomID(roomID), event)
+		if err != nil {
+			s.logger.Error("Failed to render the %s notice: %v", event, err)
+			return
+		}
+		if _, err := s.matrix.SendMessage(notice, matrix.WithRoom(id.RoomID(roomID)), matrix.WithMsgType(matrix.MsgTypeNotice)); err != nil {
+			s.logger.Warn("Failed to post the %s notice to %s: %v", event, roomID, err)
+		}
+	}
+}
+
+// lifecycleNotice renders the notice of an event for a room, from its
+// template or else in the language of the room
+func (s *Server) lifecycleNotice(roomID id.RoomID, event string) (string, error) {
+	build := buildinfo.Get()
+	s.configMutex.RLock()
+	tpl, exists := s.lifecycleTemplates[event]
+	s.configMutex.RUnlock()
+	if exists {
+		var b strings.Builder
+		if err := tpl.Execute(&b, build); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(b.String()), nil
+	}
+	if event == lifecycleStartup {
+		return s.text(roomID, "lifecycle.startup", build.Version), nil
+	}
+	return s.text(roomID, "lifecycle.shutdown"), nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestLifecycleNoticeRooms(t *testing.T) {
+	on, off := true, false
+	cfg := &config.Config{
+		Matrix: config.MatrixConfig{RoomID: "!main:example.com"},
+		Rooms: []config.RoomConfig{
+			{RoomID: "!ops:example.com", LifecycleNotices: &on},
+			{RoomID: "!dev:example.com"},
+			{RoomID: "!quiet:example.com", LifecycleNotices: &off},
+		},
+	}
+	want := []string{"!main:example.com", "!ops:example.com"}
+	if got := cfg.LifecycleNoticeRooms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LifecycleNoticeRooms() = %v, want %v", got, want)
+	}
+
+	// matrix.roomid can turn the notices off like any room
+	cfg.Rooms = append(cfg.Rooms, config.RoomConfig{RoomID: "!main:example.com", LifecycleNotices: &off})
+	want = []string{"!ops:example.com"}
+	if got := cfg.LifecycleNoticeRooms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LifecycleNoticeRooms() with matrix.roomid turned off = %v, want %v", got, want)
+	}
+}
+
+func TestLifecycleNotice(t *testing.T) {
+	cfg := &config.Config{
+		Matrix: config.MatrixConfig{RoomID: "!main:example.com"},
+		Rooms:  []config.RoomConfig{{RoomID: "!de:example.com", Language: "de"}},
+		LifecycleNotices: config.LifecycleNoticesConfig{
+			Startup:          true,
+			Shutdown:         true,
+			ShutdownTemplate: "Back after the {{.Version}} upgrade",
+		},
+	}
+	compiled, err := compileConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: cfg, rooms: compiled.rooms, lifecycleTemplates: compiled.lifecycleTemplates}
+	version := buildinfo.Get().Version
+
+	tests := []struct {
+		room  string
+		event string
+		want  string
+	}{
+		{"!main:example.com", lifecycleStartup, "online (version " + version + ")"},
+		{"!de:example.com", lifecycleStartup, "online (Version " + version + ")"},
+		{"!main:example.com", lifecycleShutdown, "Back after the " + version + " upgrade"},
+	}
+	for _, tt := range tests {
+		notice, err := s.lifecycleNotice(id.RoomID(tt.room), tt.event)
+		if err != nil {
+			t.Fatalf("lifecycleNotice(%s, %s) error = %v", tt.room, tt.event, err)
+		}
+		if !strings.Contains(notice, tt.want) {
+			t.Errorf("lifecycleNotice(%s, %s) = %q, want it to contain %q", tt.room, tt.event, notice, tt.want)
+		}
+	}
+
+	cfg.LifecycleNotices.StartupTemplate = "{{.Version"
+	if _, err := compileConfig(cfg); err == nil || !strings.Contains(err.Error(), "lifecycle_notices.startup_template") {
+		t.Errorf("compileConfig() with a broken template error = %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"maunium.net/go/mautrix/id"
+)
+
+// llmHandles reports whether the LLM backend answers messages for the
+// command ("" for messages without one) instead of a webhook
+func (s *Server) llmHandles(command string) bool {
+	if s.llm == nil {
+		return false
+	}
+	cfg := s.cfg()
+	if command != "" && slices.Contains(cfg.LLM.Commands, command) {
+		return true
+	}
+	_, hasWebhook := cfg.Webhook.Commands[command]
+	return cfg.LLM.Default && !hasWebhook
+}
+
+// handleLLMMessage answers a message with the model. Each thread, and the
+// main timeline of each room, is a conversation of its own.
+func (s *Server) handleLLMMessage(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, threadRootEventID id.EventID, message, command string) {
+	log := s.logger.Ctx(ctx)
+	prompt := commandPrompt(message, command)
+	if prompt == "" {
+		log.Debug("Ignoring empty prompt for command %s", command)
+		return
+	}
+
+	conversation := string(roomID)
+	if threadRootEventID != "" {
+		conversation += "/" + string(threadRootEventID)
+	}
+
+	record := s.auditCommand(ctx, roomID, sender, eventID, audit.KindLLM, command, prompt)
+	reply, err := s.llm.Chat(ctx, conversation, prompt)
+	record.done(s.llm.Endpoint(), resultStatus(err), err)
+	if err != nil {
+		log.Error("Failed to get an answer from the model: %v", err)
+		s.replyError(ctx, roomID, sender, threadRootEventID, errorReply{Subsystem: subsystemLLM, Command: command, Attempt: 1, Attempts: 1}, err)
+		return
+	}
+	if reply == "" {
+		log.Debug("No reply to send to Matrix")
+		return
+	}
+	log.Info("Sending model reply to Matrix: %s", log.Message(reply))
+	replyID := s.sendOutput(ctx, reply, sender, threadRootEventID)
+	s.recordFeedbackReply(replyID, feedbackReply{Command: feedbackCommand(command, feedbackLLMCommand), Message: message, MessageEventID: eventID, Sender: sender, Reply: reply})
+}
+
+// commandPrompt returns the message without the command it starts with
+func commandPrompt(message, command string) string {
+	if command != "" && strings.HasPrefix(message, "/"+command) {
+		return strings.TrimSpace(strings.TrimPrefix(message, "/"+command))
+	}
+	return strings.TrimSpace(message)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/llm"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestLLMHandles(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{Commands: map[string]string{"status": "http://localhost/status"}},
+		LLM:     config.LLMConfig{Enabled: true, Commands: []string{"ask"}},
+	}
+	s := &Server{config: cfg, logger: log, llm: llm.New(&cfg.LLM, log)}
+
+	tests := []struct {
+		command     string
+		withDefault bool
+		want        bool
+	}{
+		{"ask", false, true},
+		{"", false, false},
+		{"status", false, false},
+		{"", true, true},
+		{"unknown", true, true},
+		{"status", true, false},
+	}
+	for _, tt := range tests {
+		cfg.LLM.Default = tt.withDefault
+		if got := s.llmHandles(tt.command); got != tt.want {
+			t.Errorf("llmHandles(%q) with default %v = %v, want %v", tt.command, tt.withDefault, got, tt.want)
+		}
+	}
+
+	s.llm = nil
+	if s.llmHandles("ask") {
+		t.Error("llmHandles = true without the LLM backend")
+	}
+}
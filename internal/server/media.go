@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+// MediaURLRequest is the JSON body of POST /media when the content should be
+// fetched from a URL instead of uploaded
+type MediaURLRequest struct {
+	URL        string `json:"url"`
+	Filename   string `json:"filename,omitempty"`
+	Caption    string `json:"caption,omitempty"`
+	RoomID     string `json:"room_id,omitempty"`
+	ThreadRoot string `json:"thread_root,omitempty"`
+}
+
+// mediaUpload is a media file ready to be posted to Matrix
+type mediaUpload struct {
+	data       []byte
+	filename   string
+	mimeType   string
+	caption    string
+	roomID     string
+	threadRoot string
+}
+
+// handleMedia uploads a file to the Matrix media repository and posts it to
+// a room. It accepts either a multipart form with a "file" field (plus
+// optional "caption", "room_id" and "thread_root" fields) or a JSON body
+// with a "url" to fetch the content from.
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("Media endpoint called")
+
+	cfg := s.cfg()
+	maxSize := cfg.Attachments.Outbound.MaxSize(cfg.Server.MediaMaxSizeMB)
+
+	var upload *mediaUpload
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		upload, err = s.readMultipartMedia(r, maxSize)
+	} else {
+		upload, err = s.fetchMediaFromURL(r, maxSize)
+	}
+	if err != nil {
+		s.logger.Error("Invalid media request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := attachmentTypeError(&cfg.Attachments.Outbound, upload.mimeType); err != nil {
+		s.logger.Warn("Rejecting media %s: %v", upload.filename, err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if upload.roomID != "" && !strings.HasPrefix(upload.roomID, "!") {
+		http.Error(w, fmt.Sprintf("invalid room_id %q", upload.roomID), http.StatusBadRequest)
+		return
+	}
+	if upload.threadRoot != "" && !strings.HasPrefix(upload.threadRoot, "$") {
+		http.Error(w, fmt.Sprintf("invalid thread_root %q", upload.threadRoot), http.StatusBadRequest)
+		return
+	}
+
+	req := MessageRequest{RoomID: upload.roomID, ThreadRoot: upload.threadRoot}
+	eventID, err := s.matrix.SendMedia(upload.data, upload.filename, upload.mimeType, upload.caption, append(req.sendOptions(), withRequestID(r))...)
+	if err != nil {
+		s.logger.Error("Failed to send media to Matrix: %v", err)
+		http.Error(w, "Failed to send media to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
+
+// readMultipartMedia reads the "file" part and metadata fields of a multipart upload
+func (s *Server) readMultipartMedia(r *http.Request, maxSize int64) (*mediaUpload, error) {
+	// The route limits the body to maxSize plus headroom for the other fields
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %w", err)
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("missing file field: %w", err)
+	}
+	defer file.Close()
+
+	data, err := readLimited(file, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mediaUpload{
+		data:       data,
+		filename:   header.Filename,
+		mimeType:   detectMimeType(header.Header.Get("Content-Type"), header.Filename, data),
+		caption:    r.FormValue("caption"),
+		roomID:     r.FormValue("room_id"),
+		threadRoot: r.FormValue("thread_root"),
+	}, nil
+}
+
+// fetchMediaFromURL downloads the content referenced by a JSON media request
+func (s *Server) fetchMediaFromURL(r *http.Request, maxSize int64) (*mediaUpload, error) {
+	var req MediaURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	s.logger.Info("Fetching media from %s", req.URL)
+	upload, err := s.downloadMedia(r.Context(), parsed, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Filename != "" {
+		upload.filename = req.Filename
+		upload.mimeType = detectMimeType(upload.mimeType, upload.filename, upload.data)
+	}
+	upload.caption = req.Caption
+	upload.roomID = req.RoomID
+	upload.threadRoot = req.ThreadRoot
+	return upload, nil
+}
+
+// downloadMedia fetches an http(s) URL, naming the file after the last path
+// segment of the URL. Like dispatches, it only connects to destinations
+// webhook.egress allows.
+func (s *Server) downloadMedia(ctx context.Context, u *url.URL, maxSize int64) (*mediaUpload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch url: %w", err)
+	}
+	client := webhook.NewEgressClient(&s.cfg().Webhook.Egress, 30*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fetching url returned status code %d", resp.StatusCode)
+	}
+
+	data, err := readLimited(resp.Body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := path.Base(u.Path)
+	if filename == "/" || filename == "." {
+		filename = "download"
+	}
+
+	return &mediaUpload{
+		data:     data,
+		filename: filename,
+		mimeType: detectMimeType(resp.Header.Get("Content-Type"), filename, data),
+	}, nil
+}
+
+// readLimited reads at most maxSize bytes and fails if there is more
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("media exceeds maximum size of %d bytes", maxSize)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("media is empty")
+	}
+	return data, nil
+}
+
+// attachmentTypeError returns why an attachment policy refuses attachments
+// of the MIME type, nil if it allows them
+func attachmentTypeError(policy *config.AttachmentPolicy, mimeType string) error {
+	if policy.AllowsType(mimeType) {
+		return nil
+	}
+	return fmt.Errorf("media type %s is not allowed, allowed are %s", mimeType, strings.Join(policy.MimeTypes, ", "))
+}
+
+// detectMimeType returns the declared MIME type if it is specific, otherwise
+// guesses from the filename extension and finally from the content
+func detectMimeType(declared, filename string, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	if byExt := mime.TypeByExtension(path.Ext(filename)); byExt != "" {
+		if mediaType, _, err := mime.ParseMediaType(byExt); err == nil {
+			return mediaType
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestDetectMimeType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name     string
+		declared string
+		filename string
+		data     []byte
+		expected string
+	}{
+		{"Declared type wins", "image/jpeg; charset=binary", "x.bin", []byte("x"), "image/jpeg"},
+		{"Octet-stream falls back to extension", "application/octet-stream", "report.pdf", []byte("x"), "application/pdf"},
+		{"No declared type falls back to content", "", "screenshot", png, "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectMimeType(tt.declared, tt.filename, tt.data); got != tt.expected {
+				t.Errorf("detectMimeType() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if _, err := readLimited(strings.NewReader("12345"), 4); err == nil {
+		t.Error("readLimited() should reject content larger than the limit")
+	}
+	if _, err := readLimited(strings.NewReader(""), 4); err == nil {
+		t.Error("readLimited() should reject empty content")
+	}
+	data, err := readLimited(strings.NewReader("1234"), 4)
+	if err != nil || string(data) != "1234" {
+		t.Errorf("readLimited() = %q, %v, want %q", data, err, "1234")
+	}
+}
+
+func TestFetchMediaFromURL(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("build log"))
+	}))
+	defer origin.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{config: validTestConfig(), logger: log}
+
+	body := `{"url": "` + origin.URL + `/artifacts/build.log", "caption": "nightly", "room_id": "!ci:matrix.org"}`
+	req := httptest.NewRequest(http.MethodPost, "/media", strings.NewReader(body))
+
+	upload, err := s.fetchMediaFromURL(req, 1<<20)
+	if err != nil {
+		t.Fatalf("fetchMediaFromURL() error = %v", err)
+	}
+	if string(upload.data) != "build log" {
+		t.Errorf("data = %q, want %q", upload.data, "build log")
+	}
+	if upload.filename != "build.log" {
+		t.Errorf("filename = %q, want %q", upload.filename, "build.log")
+	}
+	if upload.mimeType != "text/plain" {
+		t.Errorf("mimeType = %q, want %q", upload.mimeType, "text/plain")
+	}
+	if upload.caption != "nightly" || upload.roomID != "!ci:matrix.org" {
+		t.Errorf("caption/room_id = %q/%q, want %q/%q", upload.caption, upload.roomID, "nightly", "!ci:matrix.org")
+	}
+}
+
+func TestFetchMediaFromURLRejectsNonHTTP(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{logger: log}
+
+	req := httptest.NewRequest(http.MethodPost, "/media", strings.NewReader(`{"url": "file:///etc/passwd"}`))
+	if _, err := s.fetchMediaFromURL(req, 1<<20); err == nil {
+		t.Error("fetchMediaFromURL() should reject non-http URLs")
+	}
+}
+
+func TestFetchMediaFromURLRefusesMetadata(t *testing.T) {
+	// Redirects are checked as well
+	redirector := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data/", http.StatusFound))
+	defer redirector.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{config: validTestConfig(), logger: log}
+
+	for _, url := range []string{"http://169.254.169.254/latest/meta-data/", "http://[fd00:ec2::254]/latest/meta-data/", redirector.URL + "/image.png"} {
+		req := httptest.NewRequest(http.MethodPost, "/media", strings.NewReader(`{"url": "`+url+`"}`))
+		if _, err := s.fetchMediaFromURL(req, 1<<20); !errors.Is(err, webhook.ErrEgressDenied) {
+			t.Errorf("fetchMediaFromURL(%s) error = %v, want ErrEgressDenied", url, err)
+		}
+	}
+}
+
+func TestHandleMediaAttachmentPolicy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-msdownload")
+		w.Write([]byte(strings.Repeat("x", 2<<20)))
+	}))
+	defer origin.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Server:      config.ServerConfig{MediaMaxSizeMB: 50},
+		Attachments: config.AttachmentsConfig{Outbound: config.AttachmentPolicy{MimeTypes: []string{"image/*", "application/pdf"}}},
+	}
+	s := &Server{config: cfg, logger: log}
+
+	tests := []struct {
+		name       string
+		maxSizeMB  int
+		wantStatus int
+		wantError  string
+	}{
+		{"Type not allowed", 0, http.StatusUnsupportedMediaType, "media type application/x-msdownload is not allowed, allowed are image/*, application/pdf"},
+		{"Larger than the policy", 1, http.StatusBadRequest, "media exceeds maximum size of 1048576 bytes"},
+	}
+	for _, tt := range tests {
+		cfg.Attachments.Outbound.MaxSizeMB = tt.maxSizeMB
+		req := httptest.NewRequest(http.MethodPost, "/media", strings.NewReader(`{"url": "`+origin.URL+`/setup.exe"}`))
+		rec := httptest.NewRecorder()
+		s.handleMedia(rec, req)
+		if rec.Code != tt.wantStatus || strings.TrimSpace(rec.Body.String()) != tt.wantError {
+			t.Errorf("%s: handleMedia() = %d %q, want %d %q", tt.name, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantError)
+		}
+	}
+}
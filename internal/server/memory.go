@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/memory"
+	"maunium.net/go/mautrix/id"
+)
+
+// isMemoryCommand reports whether the message is a /set, /get or /forget
+// command
+func isMemoryCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && (fields[0] == "/set" || fields[0] == "/get" || fields[0] == "/forget")
+}
+
+// memoryValues returns the values remembered for sender in roomID, nil
+// unless memory.enabled
+func (s *Server) memoryValues(roomID id.RoomID, sender id.UserID) map[string]string {
+	if s.memory == nil {
+		return nil
+	}
+	return s.memory.Values(string(roomID), string(sender))
+}
+
+// handleMemoryCommand remembers, shows and forgets values:
+// /set lang de
+// /get lang
+// /get
+// /forget lang
+func (s *Server) handleMemoryCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, threadRootEventID id.EventID) {
+	fields := strings.Fields(message)
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	room, user := string(roomID), string(sender)
+
+	switch {
+	case fields[0] == "/set" && len(fields) >= 3:
+		key := fields[1]
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), "/set")), key))
+		err := s.memory.Set(ctx, room, user, key, value)
+		switch {
+		case errors.Is(err, memory.ErrInvalidKey), errors.Is(err, memory.ErrTooLong):
+			reply(s.text(roomID, "memory.invalid", key, err))
+		case errors.Is(err, memory.ErrTooMany):
+			reply(s.text(roomID, "memory.too_many", key, err))
+		case err != nil:
+			s.logger.Ctx(ctx).Error("Failed to set %s: %v", key, err)
+			reply(s.text(roomID, "memory.set_failed", key))
+		default:
+			reply(s.text(roomID, "memory.set", key))
+		}
+	case fields[0] == "/get" && len(fields) == 1:
+		keys := s.memory.Keys(room, user)
+		if len(keys) == 0 {
+			reply(s.text(roomID, "memory.empty"))
+			return
+		}
+		reply(s.text(roomID, "memory.keys", "`"+strings.Join(keys, "`, `")+"`"))
+	case fields[0] == "/get" && len(fields) == 2:
+		value, ok := s.memory.Get(room, user, fields[1])
+		if !ok {
+			reply(s.text(roomID, "memory.not_set", fields[1]))
+			return
+		}
+		reply(s.text(roomID, "memory.value", fields[1], value))
+	case fields[0] == "/forget" && len(fields) == 2:
+		forgotten, err := s.memory.Forget(ctx, room, user, fields[1])
+		switch {
+		case err != nil:
+			s.logger.Ctx(ctx).Error("Failed to forget %s: %v", fields[1], err)
+			reply(s.text(roomID, "memory.forget_failed", fields[1]))
+		case !forgotten:
+			reply(s.text(roomID, "memory.not_set", fields[1]))
+		default:
+			reply(s.text(roomID, "memory.forgot", fields[1]))
+		}
+	default:
+		reply(s.text(roomID, "memory.usage"))
+	}
+}
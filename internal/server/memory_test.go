@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/memory"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestIsMemoryCommand(t *testing.T) {
+	for message, want := range map[string]bool{
+		"/set lang de": true,
+		"/get":         true,
+		"/forget lang": true,
+		"/settings":    false,
+		"set lang de":  false,
+		"":             false,
+	} {
+		if got := isMemoryCommand(message); got != want {
+			t.Errorf("isMemoryCommand(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestMemoryInWebhookPayload(t *testing.T) {
+	payloads := make(chan map[string]string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]string
+		json.Unmarshal(body, &payload)
+		payloads <- payload
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			Default:         target.URL,
+			Template:        `{"message": "{{.MESSAGE}}", "lang": "{{.MEMORY.lang}}"}`,
+			TemplateOptions: config.PayloadTemplateConfig{Escape: webhook.EscapeJSON},
+		},
+		Memory: config.MemoryConfig{Enabled: true, Scope: config.MemoryScopeRoomUser, MaxKeys: 5, MaxValueLength: 100},
+	}
+	store := storage.NewMemory()
+	mem, err := memory.NewStore(&cfg.Memory, "secret", store)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err := mem.Set(context.Background(), "!room:example.com", "@user:example.com", "lang", `d"e`); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), memory: mem}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "hello", "", "", "$event")
+	select {
+	case payload := <-payloads:
+		if payload["lang"] != `d"e` || payload["message"] != "hello" {
+			t.Errorf("payload = %v, want the remembered lang", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	// Another user has no value
+	s.HandleMessage("!room:example.com", id.UserID("@other:example.com"), "hello", "", "", "$other")
+	select {
+	case payload := <-payloads:
+		if payload["lang"] != "" {
+			t.Errorf("payload = %v, want no lang for another user", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/archive"
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+// handleMetrics serves metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var failures []matrix.DecryptionFailure
+	var withheld []matrix.WithheldKeys
+	var queue matrix.InboundQueueStats
+	var sync matrix.SyncStatus
+	if s.matrix != nil {
+		failures = s.matrix.DecryptionFailures()
+		withheld = s.matrix.WithheldKeys()
+		queue = s.matrix.InboundQueueStats()
+		sync = s.matrix.SyncStatus()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeBuildInfoMetric(w, buildinfo.Get())
+	writeDecryptionMetrics(w, failures)
+	writeWithheldMetrics(w, withheld)
+	writeInboundQueueMetrics(w, queue)
+	writeSyncMetrics(w, sync)
+	writeTenantMetrics(w, s.tenants)
+	writeTransformMetrics(w, s.transformDrops.snapshot())
+	if s.dedup != nil {
+		writeDedupMetrics(w, s.dedup.Stats())
+	}
+	if s.archiver != nil {
+		writeArchiveMetrics(w, s.archiver.Stats())
+	}
+	if s.quietQueue != nil {
+		writeQuietHoursMetrics(w, s.quietQueue.Len())
+	}
+}
+
+// writeBuildInfoMetric writes the build of the binary as labels of a
+// constant gauge
+func writeBuildInfoMetric(w io.Writer, info buildinfo.Info) {
+	fmt.Fprintln(w, "# HELP matrix_build_info The version, commit, build date and Go version of the binary.")
+	fmt.Fprintln(w, "# TYPE matrix_build_info gauge")
+	fmt.Fprintf(w, "matrix_build_info{version=\"%s\",commit=\"%s\",build_date=\"%s\",go_version=\"%s\"} 1\n",
+		escapeLabel(info.Version), escapeLabel(info.Commit), escapeLabel(info.BuildDate), escapeLabel(info.GoVersion))
+}
+
+// writeSyncMetrics writes the time of the last sync response and what the
+// sync watchdog did
+func writeSyncMetrics(w io.Writer, status matrix.SyncStatus) {
+	var lastSync float64
+	if !status.LastSync.IsZero() {
+		lastSync = float64(status.LastSync.UnixMilli()) / 1000
+	}
+	stalled := 0
+	if status.Stalled {
+		stalled = 1
+	}
+	fmt.Fprintln(w, "# HELP matrix_last_sync_timestamp_seconds When the last sync response arrived, 0 before the first.")
+	fmt.Fprintln(w, "# TYPE matrix_last_sync_timestamp_seconds gauge")
+	fmt.Fprintf(w, "matrix_last_sync_timestamp_seconds %.3f\n", lastSync)
+	fmt.Fprintln(w, "# HELP matrix_sync_stalled Whether the sync watchdog is restarting a sync loop without responses.")
+	fmt.Fprintln(w, "# TYPE matrix_sync_stalled gauge")
+	fmt.Fprintf(w, "matrix_sync_stalled %d\n", stalled)
+	fmt.Fprintln(w, "# HELP matrix_sync_restarts_total Sync loop restarts by the sync watchdog.")
+	fmt.Fprintln(w, "# TYPE matrix_sync_restarts_total counter")
+	fmt.Fprintf(w, "matrix_sync_restarts_total %d\n", status.Restarts)
+	fmt.Fprintln(w, "# HELP matrix_client_resets_total Resets of the connections to the homeserver by the sync watchdog.")
+	fmt.Fprintln(w, "# TYPE matrix_client_resets_total counter")
+	fmt.Fprintf(w, "matrix_client_resets_total %d\n", status.ClientResets)
+}
+
+// writeInboundQueueMetrics writes the length of the inbound queue and the
+// events it dropped
+func writeInboundQueueMetrics(w io.Writer, stats matrix.InboundQueueStats) {
+	fmt.Fprintln(w, "# HELP matrix_inbound_queued Events waiting to be handled.")
+	fmt.Fprintln(w, "# TYPE matrix_inbound_queued gauge")
+	fmt.Fprintf(w, "matrix_inbound_queued %d\n", stats.Queued)
+	fmt.Fprintln(w, "# HELP matrix_inbound_dropped_total Events dropped because the inbound queue was full.")
+	fmt.Fprintln(w, "# TYPE matrix_inbound_dropped_total counter")
+	fmt.Fprintf(w, "matrix_inbound_dropped_total %d\n", stats.Dropped)
+}
+
+// writeDecryptionMetrics writes the decryption failure counters, labeled
+// by room and reason
+func writeDecryptionMetrics(w io.Writer, failures []matrix.DecryptionFailure) {
+	fmt.Fprintln(w, "# HELP matrix_decryption_failures_total Encrypted events that could not be decrypted.")
+	fmt.Fprintln(w, "# TYPE matrix_decryption_failures_total counter")
+	for _, f := range failures {
+		fmt.Fprintf(w, "matrix_decryption_failures_total{room_id=\"%s\",reason=\"%s\"} %d\n", escapeLabel(string(f.RoomID)), f.Reason, f.Count)
+	}
+	fmt.Fprintln(w, "# HELP matrix_undecryptable_sessions Distinct megolm sessions of the events that could not be decrypted.")
+	fmt.Fprintln(w, "# TYPE matrix_undecryptable_sessions gauge")
+	for _, f := range failures {
+		fmt.Fprintf(w, "matrix_undecryptable_sessions{room_id=\"%s\",reason=\"%s\"} %d\n", escapeLabel(string(f.RoomID)), f.Reason, f.Sessions)
+	}
+}
+
+// writeWithheldMetrics writes the withheld room keys received, labeled by
+// code
+func writeWithheldMetrics(w io.Writer, withheld []matrix.WithheldKeys) {
+	fmt.Fprintln(w, "# HELP matrix_room_keys_withheld_total m.room_key.withheld events received from other devices.")
+	fmt.Fprintln(w, "# TYPE matrix_room_keys_withheld_total counter")
+	for _, k := range withheld {
+		fmt.Fprintf(w, "matrix_room_keys_withheld_total{code=\"%s\"} %d\n", escapeLabel(k.Code), k.Count)
+	}
+}
+
+// writeDedupMetrics writes the lookups of each dedup scope
+func writeDedupMetrics(w io.Writer, stats []dedup.Stats) {
+	fmt.Fprintln(w, "# HELP matrix_dedup_hits_total Duplicates skipped, by kind.")
+	fmt.Fprintln(w, "# TYPE matrix_dedup_hits_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "matrix_dedup_hits_total{scope=\"%s\"} %d\n", escapeLabel(s.Scope), s.Hits)
+	}
+	fmt.Fprintln(w, "# HELP matrix_dedup_misses_total Keys not seen before, by kind.")
+	fmt.Fprintln(w, "# TYPE matrix_dedup_misses_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "matrix_dedup_misses_total{scope=\"%s\"} %d\n", escapeLabel(s.Scope), s.Misses)
+	}
+	fmt.Fprintln(w, "# HELP matrix_dedup_evictions_total Keys forgotten by memory before their TTL because of dedup.max_entries.")
+	fmt.Fprintln(w, "# TYPE matrix_dedup_evictions_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "matrix_dedup_evictions_total{scope=\"%s\"} %d\n", escapeLabel(s.Scope), s.Evictions)
+	}
+	fmt.Fprintln(w, "# HELP matrix_dedup_entries Keys kept in memory, by kind.")
+	fmt.Fprintln(w, "# TYPE matrix_dedup_entries gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "matrix_dedup_entries{scope=\"%s\"} %d\n", escapeLabel(s.Scope), s.Entries)
+	}
+}
+
+// writeArchiveMetrics writes the messages and replies written to object
+// storage
+func writeArchiveMetrics(w io.Writer, stats archive.Stats) {
+	fmt.Fprintln(w, "# HELP matrix_archive_records_total Messages and replies written to the archive.")
+	fmt.Fprintln(w, "# TYPE matrix_archive_records_total counter")
+	fmt.Fprintf(w, "matrix_archive_records_total %d\n", stats.Archived)
+	fmt.Fprintln(w, "# HELP matrix_archive_dropped_total Messages and replies dropped because the archive storage was behind.")
+	fmt.Fprintln(w, "# TYPE matrix_archive_dropped_total counter")
+	fmt.Fprintf(w, "matrix_archive_dropped_total %d\n", stats.Dropped)
+	fmt.Fprintln(w, "# HELP matrix_archive_write_failures_total Batches that could not be written and were retried.")
+	fmt.Fprintln(w, "# TYPE matrix_archive_write_failures_total counter")
+	fmt.Fprintf(w, "matrix_archive_write_failures_total %d\n", stats.Failed)
+	fmt.Fprintln(w, "# HELP matrix_archive_pending Messages and replies waiting to be written.")
+	fmt.Fprintln(w, "# TYPE matrix_archive_pending gauge")
+	fmt.Fprintf(w, "matrix_archive_pending %d\n", stats.Pending)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
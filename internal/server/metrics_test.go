@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/archive"
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+func TestWriteDecryptionMetrics(t *testing.T) {
+	var out strings.Builder
+	writeDecryptionMetrics(&out, []matrix.DecryptionFailure{
+		{RoomID: "!a:example.com", Reason: matrix.DecryptionMissingSession, Count: 5, Sessions: 2},
+		{RoomID: `!odd"room:example.com`, Reason: matrix.DecryptionWithheld, Count: 1, Sessions: 1},
+	})
+
+	for _, want := range []string{
+		"# TYPE matrix_decryption_failures_total counter\n",
+		`matrix_decryption_failures_total{room_id="!a:example.com",reason="missing_session"} 5` + "\n",
+		`matrix_decryption_failures_total{room_id="!odd\"room:example.com",reason="withheld"} 1` + "\n",
+		`matrix_undecryptable_sessions{room_id="!a:example.com",reason="missing_session"} 2` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteWithheldMetrics(t *testing.T) {
+	var out strings.Builder
+	writeWithheldMetrics(&out, []matrix.WithheldKeys{{Code: "m.blacklisted", Count: 1}, {Code: "m.unverified", Count: 3}})
+
+	for _, want := range []string{
+		"# TYPE matrix_room_keys_withheld_total counter\n",
+		`matrix_room_keys_withheld_total{code="m.blacklisted"} 1` + "\n",
+		`matrix_room_keys_withheld_total{code="m.unverified"} 3` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteArchiveMetrics(t *testing.T) {
+	var out strings.Builder
+	writeArchiveMetrics(&out, archive.Stats{Archived: 12, Dropped: 2, Failed: 1, Pending: 4})
+
+	for _, want := range []string{"matrix_archive_records_total 12\n", "matrix_archive_dropped_total 2\n", "matrix_archive_write_failures_total 1\n", "# TYPE matrix_archive_pending gauge\n", "matrix_archive_pending 4\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteInboundQueueMetrics(t *testing.T) {
+	var out strings.Builder
+	writeInboundQueueMetrics(&out, matrix.InboundQueueStats{Queued: 3, Dropped: 7})
+
+	for _, want := range []string{"matrix_inbound_queued 3\n", "# TYPE matrix_inbound_dropped_total counter\n", "matrix_inbound_dropped_total 7\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteSyncMetrics(t *testing.T) {
+	var out strings.Builder
+	writeSyncMetrics(&out, matrix.SyncStatus{LastSync: time.UnixMilli(1700000000500), Stalled: true, Restarts: 4, ClientResets: 1})
+
+	for _, want := range []string{
+		"matrix_last_sync_timestamp_seconds 1700000000.500\n",
+		"matrix_sync_stalled 1\n",
+		"# TYPE matrix_sync_restarts_total counter\n",
+		"matrix_sync_restarts_total 4\n",
+		"matrix_client_resets_total 1\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteBuildInfoMetric(t *testing.T) {
+	var out strings.Builder
+	writeBuildInfoMetric(&out, buildinfo.Info{Version: "v1.2.0", Commit: "abc123", BuildDate: "2026-01-02T03:04:05Z", GoVersion: "go1.24.0"})
+
+	want := `matrix_build_info{version="v1.2.0",commit="abc123",build_date="2026-01-02T03:04:05Z",go_version="go1.24.0"} 1` + "\n"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, out.String())
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.handleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var info buildinfo.Info
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if info != buildinfo.Get() || info.Version == "" || info.Commit == "" || info.BuildDate == "" {
+		t.Errorf("handleVersion() = %+v, want %+v", info, buildinfo.Get())
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+)
+
+// notifyTemplate is a compiled notification template
+type notifyTemplate struct {
+	config   config.NotifyTemplateConfig
+	template *template.Template
+}
+
+// compileNotifyTemplates parses the configured notification templates
+func compileNotifyTemplates(templates map[string]config.NotifyTemplateConfig) (map[string]*notifyTemplate, error) {
+	compiled := make(map[string]*notifyTemplate, len(templates))
+	for name, cfg := range templates {
+		if cfg.Template == "" {
+			return nil, fmt.Errorf("notify.templates.%s: template is required", name)
+		}
+		// The delivery settings are validated like a POST /message request
+		delivery := MessageRequest{RoomID: cfg.RoomID, Format: cfg.Format, MsgType: cfg.MsgType}
+		if err := delivery.validate(); err != nil {
+			return nil, fmt.Errorf("notify.templates.%s: %w", name, err)
+		}
+		tpl, err := template.New(name).Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("notify.templates.%s: invalid template: %w", name, err)
+		}
+		compiled[name] = &notifyTemplate{config: cfg, template: tpl}
+	}
+	return compiled, nil
+}
+
+// render executes the template on the decoded JSON body
+func (t *notifyTemplate) render(data interface{}) (string, error) {
+	var b strings.Builder
+	if err := t.template.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// handleNotify renders the JSON body through a named template and posts the
+// result to the template's room. An empty result is not posted, so
+// templates can filter out notifications.
+func (s *Server) handleNotify(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "template")
+	s.configMutex.RLock()
+	tpl, exists := s.notifyTemplates[name]
+	s.configMutex.RUnlock()
+	if !exists {
+		http.Error(w, "Unknown template", http.StatusNotFound)
+		return
+	}
+
+	s.logger.Info("Notify endpoint called with template %q", name)
+
+	if !hasBearerToken(r, s.cfg().Notify.Token) {
+		s.logger.Warn("Rejecting notification with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var data interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		s.logger.Error("Invalid JSON in notification: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	message, err := tpl.render(data)
+	if err != nil {
+		s.logger.Error("Failed to render notification template %q: %v", name, err)
+		http.Error(w, fmt.Sprintf("Failed to render message: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if message == "" {
+		s.logger.Info("Notification template %q rendered an empty message, nothing posted", name)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SendResponse{Status: "skipped"})
+		return
+	}
+
+	if s.holdNotification(r.Context(), quiet.Notification{
+		Source: "notify:" + name, RoomID: tpl.config.RoomID, Message: message, Format: tpl.config.Format, MsgType: tpl.config.MsgType,
+	}) {
+		writeHeldResponse(w)
+		return
+	}
+
+	delivery := MessageRequest{RoomID: tpl.config.RoomID, Format: tpl.config.Format, MsgType: tpl.config.MsgType}
+	eventID, err := s.matrix.SendMessage(message, append(delivery.sendOptions(), withRequestID(r))...)
+	if err != nil {
+		s.logger.Error("Failed to send notification to Matrix: %v", err)
+		http.Error(w, "Failed to send message to Matrix", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
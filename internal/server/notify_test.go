@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestNotifyTemplateRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Field access",
+			template: `**{{ .service }}** deployed {{ .version }}`,
+			input:    `{"service": "api", "version": "v1.2.3"}`,
+			expected: "**api** deployed v1.2.3",
+		},
+		{
+			name:     "Default for missing field",
+			template: `{{ .env | default "production" | upper }}`,
+			input:    `{}`,
+			expected: "PRODUCTION",
+		},
+		{
+			name:     "Lists",
+			template: `{{ join ", " .hosts }} ({{ len .hosts }} hosts)`,
+			input:    `{"hosts": ["a", "b"]}`,
+			expected: "a, b (2 hosts)",
+		},
+		{
+			name:     "Numbers",
+			template: `{{ .duration | int }}s, {{ round (div .ok .total | mul 100) 1 }}%`,
+			input:    `{"duration": 12.7, "ok": 2, "total": 3}`,
+			expected: "12s, 66.7%",
+		},
+		{
+			name:     "Conditional",
+			template: `{{ ternary "✅" "❌" (eq .status "ok") }} {{ .job | title }}`,
+			input:    `{"status": "failed", "job": "nightly build"}`,
+			expected: "❌ Nightly Build",
+		},
+		{
+			name:     "Dates",
+			template: `{{ date "2006-01-02 15:04" .at }} / {{ date "2006-01-02" .ts }}`,
+			input:    `{"at": "2024-03-01T10:30:00Z", "ts": 0}`,
+			expected: "2024-03-01 10:30 / 1970-01-01",
+		},
+		{
+			name:     "Filtered out",
+			template: `{{ if .important }}{{ .text }}{{ end }}`,
+			input:    `{"important": false, "text": "noise"}`,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templates, err := compileNotifyTemplates(map[string]config.NotifyTemplateConfig{"test": {Template: tt.template}})
+			if err != nil {
+				t.Fatalf("compileNotifyTemplates() error = %v", err)
+			}
+			var input interface{}
+			if err := json.Unmarshal([]byte(tt.input), &input); err != nil {
+				t.Fatalf("invalid test input: %v", err)
+			}
+			got, err := templates["test"].render(input)
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("render() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompileNotifyTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]config.NotifyTemplateConfig
+		wantErr   string
+	}{
+		{"Missing template", map[string]config.NotifyTemplateConfig{"deploy": {}}, "template is required"},
+		{"Invalid template", map[string]config.NotifyTemplateConfig{"deploy": {Template: "{{ .foo"}}, "invalid template"},
+		{"Unknown function", map[string]config.NotifyTemplateConfig{"deploy": {Template: "{{ sha256sum .foo }}"}}, "invalid template"},
+		{"Invalid room", map[string]config.NotifyTemplateConfig{"deploy": {Template: "x", RoomID: "#ops:example.com"}}, "room"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileNotifyTemplates(tt.templates)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compileNotifyTemplates() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleNotify(t *testing.T) {
+	templates, err := compileNotifyTemplates(map[string]config.NotifyTemplateConfig{
+		"deploy": {Template: `{{ if eq .status "failed" }}{{ .service }} failed{{ end }}`},
+		"broken": {Template: `{{ index .items 5 }}`},
+	})
+	if err != nil {
+		t.Fatalf("compileNotifyTemplates() error = %v", err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{
+		config:          &config.Config{Notify: config.NotifyConfig{Token: "s3cret"}},
+		logger:          log,
+		notifyTemplates: templates,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/notify/{template}", s.handleNotify)
+
+	tests := []struct {
+		name       string
+		path       string
+		auth       string
+		body       string
+		wantStatus int
+	}{
+		{"Unknown template", "/notify/missing", "Bearer s3cret", `{}`, http.StatusNotFound},
+		{"Missing token", "/notify/deploy", "", `{}`, http.StatusUnauthorized},
+		{"Invalid JSON", "/notify/deploy", "Bearer s3cret", `{`, http.StatusBadRequest},
+		{"Render error", "/notify/broken", "Bearer s3cret", `{"items": []}`, http.StatusUnprocessableEntity},
+		{"Empty message", "/notify/deploy", "Bearer s3cret", `{"status": "ok", "service": "api"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var resp SendResponse
+				json.NewDecoder(rec.Body).Decode(&resp)
+				if resp.Status != "skipped" {
+					t.Errorf("response status = %q, want %q", resp.Status, "skipped")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/llm"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// ollamaFailedReply replaces a streamed reply whose answer failed
+const ollamaFailedReply = "Failed to get an answer from the model."
+
+// ollamaModel returns the Ollama model answering messages for the command
+// ("" for messages without one), or "" if they go to a webhook
+func (s *Server) ollamaModel(command string) string {
+	if s.ollama == nil {
+		return ""
+	}
+	cfg := s.cfg()
+	if model, exists := cfg.Ollama.Models[command]; exists && command != "" {
+		return model
+	}
+	if _, hasWebhook := cfg.Webhook.Commands[command]; hasWebhook {
+		return ""
+	}
+	return cfg.Ollama.DefaultModel
+}
+
+// handleOllamaMessage answers a message with an Ollama model, editing the
+// reply as the answer streams in. The conversation is the context of the
+// session of the thread (or of the sender outside threads), so it expires
+// and can be killed like command sessions.
+func (s *Server) handleOllamaMessage(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, threadRootEventID id.EventID, message, command, model string) {
+	log := s.logger.Ctx(ctx)
+	prompt := commandPrompt(message, command)
+	if prompt == "" {
+		log.Debug("Ignoring empty prompt for command %s", command)
+		return
+	}
+	// The answer is streamed into the room, the policy applies up front
+	if !s.allowOutput(ctx, sender, threadRootEventID) {
+		return
+	}
+	cfg := s.cfg().Ollama
+
+	sessions := s.sessionsFor(roomID)
+	sess := sessions.GetOrCreateSession(threadRootEventID, sender, "")
+	history := decodeConversation(sessions.GetContext(sess))
+	var messages []llm.ChatMessage
+	if cfg.SystemPrompt != "" {
+		messages = append(messages, llm.ChatMessage{Role: llm.RoleSystem, Content: cfg.SystemPrompt})
+	}
+	messages = append(messages, history...)
+	messages = append(messages, llm.ChatMessage{Role: llm.RoleUser, Content: prompt})
+
+	stream := &replyStream{
+		server:       s,
+		ctx:          ctx,
+		sender:       sender,
+		replyEventID: threadRootEventID,
+		interval:     time.Duration(cfg.EditInterval) * time.Millisecond,
+	}
+	record := s.auditCommand(ctx, roomID, sender, eventID, audit.KindLLM, command, prompt)
+	chatCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+	answer, err := s.ollama.ChatStream(chatCtx, model, messages, stream.update)
+	cancel()
+	record.done(s.ollama.Endpoint()+"#"+model, resultStatus(err), err)
+	if err != nil {
+		log.Error("Failed to get an answer from Ollama model %s: %v", model, err)
+		if stream.eventID != "" {
+			stream.finish(ollamaFailedReply)
+		} else {
+			s.replyError(ctx, roomID, sender, threadRootEventID, errorReply{Subsystem: subsystemOllama, Command: command, Attempt: 1, Attempts: 1}, err)
+		}
+		return
+	}
+	stream.finish(answer)
+	if !stream.failed {
+		s.recordFeedbackReply(stream.eventID, feedbackReply{Command: feedbackCommand(command, feedbackOllamaCommand), Message: message, MessageEventID: eventID, Sender: sender, Reply: stream.sent})
+	}
+
+	history = append(history, llm.ChatMessage{Role: llm.RoleUser, Content: prompt}, llm.ChatMessage{Role: llm.RoleAssistant, Content: answer})
+	if excess := len(history) - cfg.MaxHistory; excess > 0 {
+		history = history[excess:]
+	}
+	sessions.UpdateContext(sess, encodeConversation(history))
+}
+
+// decodeConversation returns the conversation stored as session context. A
+// context that is not a conversation starts a new one.
+func decodeConversation(stored string) []llm.ChatMessage {
+	var history []llm.ChatMessage
+	if stored == "" || json.Unmarshal([]byte(stored), &history) != nil {
+		return nil
+	}
+	return history
+}
+
+func encodeConversation(history []llm.ChatMessage) string {
+	data, _ := json.Marshal(history)
+	return string(data)
+}
+
+// replyStream sends the first text of a reply and edits it as more arrives,
+// at most once per interval. Streamed replies skip plugins and the reply
+// script, which need the complete text.
+type replyStream struct {
+	server       *Server
+	ctx          context.Context
+	sender       id.UserID
+	replyEventID id.EventID
+	interval     time.Duration
+
+	eventID  id.EventID // Of the reply, once sent
+	sent     string     // Text of the reply as last sent
+	lastSent time.Time
+	failed   bool // Sending the reply failed, nothing more is sent
+}
+
+// update shows the text unless the reply was sent or edited less than an
+// interval ago
+func (r *replyStream) update(text string) {
+	if r.failed || (r.eventID != "" && time.Since(r.lastSent) < r.interval) {
+		return
+	}
+	r.show(text)
+}
+
+// finish shows the complete text, and relays it to Telegram if the message
+// came from there
+func (r *replyStream) finish(text string) {
+	if !r.failed && text != r.sent && text != "" {
+		r.show(text)
+	}
+	if !r.failed && r.eventID != "" {
+		r.server.relayReplyToTelegram(r.ctx, r.sent, r.eventID)
+	}
+}
+
+func (r *replyStream) show(text string) {
+	log := r.server.logger.Ctx(r.ctx)
+	if r.eventID == "" {
+		eventID, err := r.server.matrix.SendMessage(r.server.wrapOutput(r.ctx, r.sender, text), replyOptions(r.ctx, r.sender, r.replyEventID)...)
+		if err != nil {
+			log.Error("Failed to send reply to Matrix: %v", err)
+			r.failed = true
+			return
+		}
+		r.eventID = eventID
+	} else if _, err := r.server.matrix.EditMessage(r.eventID, r.server.wrapOutput(r.ctx, r.sender, text), matrix.WithRoom(replyRoom(r.ctx)), matrix.WithLogContext(r.ctx)); err != nil {
+		log.Error("Failed to edit reply in Matrix: %v", err)
+		return
+	}
+	r.sent = text
+	r.lastSent = time.Now()
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/llm"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestOllamaModel(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{Commands: map[string]string{"status": "http://localhost/status"}},
+		Ollama:  config.OllamaConfig{Enabled: true, URL: "http://localhost:11434", Models: map[string]string{"code": "codellama"}},
+	}
+	s := &Server{config: cfg, logger: log, ollama: llm.NewOllama(&cfg.Ollama, log)}
+
+	tests := []struct {
+		command      string
+		defaultModel string
+		want         string
+	}{
+		{"code", "", "codellama"},
+		{"", "", ""},
+		{"code", "llama3.2", "codellama"},
+		{"", "llama3.2", "llama3.2"},
+		{"unknown", "llama3.2", "llama3.2"},
+		{"status", "llama3.2", ""},
+	}
+	for _, tt := range tests {
+		cfg.Ollama.DefaultModel = tt.defaultModel
+		if got := s.ollamaModel(tt.command); got != tt.want {
+			t.Errorf("ollamaModel(%q) with default %q = %q, want %q", tt.command, tt.defaultModel, got, tt.want)
+		}
+	}
+}
+
+func TestConversationContext(t *testing.T) {
+	history := []llm.ChatMessage{{Role: llm.RoleUser, Content: "Hi"}, {Role: llm.RoleAssistant, Content: "Hello!"}}
+	if got := decodeConversation(encodeConversation(history)); !reflect.DeepEqual(got, history) {
+		t.Errorf("decoded conversation = %v, want %v", got, history)
+	}
+	// Output of commands run in the same session starts a new conversation
+	if got := decodeConversation("total 0\n"); got != nil {
+		t.Errorf("decodeConversation of command output = %v", got)
+	}
+}
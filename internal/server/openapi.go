@@ -0,0 +1,62 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents the HTTP API. The request and response types below
+// and in server.go and media.go are checked against it by the tests, so
+// clients generated from the document match what the handlers accept.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// SendResponse is returned by every endpoint that posts to Matrix
+type SendResponse struct {
+	Status  string `json:"status"`             // "success", "skipped" if nothing was posted, "duplicate" for a hook delivery handled before, or "held" until quiet hours end
+	EventID string `json:"event_id,omitempty"` // ID of the created Matrix event
+}
+
+// HealthResponse is returned by GET /health
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// swaggerUIDist is the Swagger UI release the docs page loads. It is an exact
+// version, as published npm versions cannot change, unlike a tag such as @5.
+const swaggerUIDist = "https://unpkg.com/swagger-ui-dist@5.17.14/"
+
+// swaggerUIPage renders the OpenAPI document with Swagger UI loaded from a CDN
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Matrix Microservice API</title>
+  <link rel="stylesheet" href="` + swaggerUIDist + `swagger-ui.css" crossorigin="anonymous" referrerpolicy="no-referrer">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + swaggerUIDist + `swagger-ui-bundle.js" crossorigin="anonymous" referrerpolicy="no-referrer"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("OpenAPI endpoint called")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("Docs endpoint called")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return &doc
+}
+
+// jsonFields returns the JSON field names of a struct type
+func jsonFields(v interface{}) []string {
+	var fields []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	types := map[string]interface{}{
+		"MessageRequest":    MessageRequest{},
+		"MediaURLRequest":   MediaURLRequest{},
+		"EditRequest":       EditRequest{},
+		"ReactionRequest":   ReactionRequest{},
+		"SendResponse":      SendResponse{},
+		"HealthResponse":    HealthResponse{},
+		"ReadyResponse":     ReadyResponse{},
+		"AdminResponse":     AdminResponse{},
+		"ReloadResponse":    ReloadResponse{},
+		"SessionInfo":       session.SessionInfo{},
+		"StreamEvent":       StreamEvent{},
+		"CommandRequest":    CommandRequest{},
+		"RegisteredCommand": RegisteredCommand{},
+		"LogEntry":          logger.Entry{},
+		"UnansweredCommand": UnansweredCommand{},
+		"CatchupResponse":   CatchupResponse{},
+		"CatchupRequest":    CatchupRequest{},
+	}
+
+	for name, v := range types {
+		schema, exists := doc.Components.Schemas[name]
+		if !exists {
+			t.Errorf("openapi.json has no schema %s", name)
+			continue
+		}
+		var documented []string
+		for property := range schema.Properties {
+			documented = append(documented, property)
+		}
+		sort.Strings(documented)
+
+		if fields := jsonFields(v); !reflect.DeepEqual(fields, documented) {
+			t.Errorf("schema %s documents %v, type has %v", name, documented, fields)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsAllRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	// Enable every optional API route; the debug endpoints and the appservice
+	// API of the Matrix spec are not part of the API
+	cfg := &config.Config{
+		Server: config.ServerConfig{AdminToken: "secret"},
+		Stream: config.StreamConfig{Enabled: true},
+		Hooks: config.HooksConfig{
+			Alertmanager: config.AlertmanagerConfig{Enabled: true},
+			Grafana:      config.GrafanaConfig{Enabled: true},
+			Discord:      config.DiscordConfig{Enabled: true},
+			PagerDuty:    config.PagerDutyHookConfig{Enabled: true},
+		},
+	}
+	s := &Server{config: cfg, router: chi.NewRouter(), customHooks: map[string]*customHook{"ci": {}}}
+	s.routes()
+
+	chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		operations, exists := doc.Paths[route]
+		if !exists {
+			t.Errorf("route %s %s is not documented in openapi.json", method, route)
+			return nil
+		}
+		if _, exists := operations[strings.ToLower(method)]; !exists {
+			t.Errorf("route %s %s is not documented in openapi.json", method, route)
+		}
+		return nil
+	})
+}
+
+func TestSwaggerUIPinned(t *testing.T) {
+	pinned := regexp.MustCompile(`^https://unpkg\.com/swagger-ui-dist@\d+\.\d+\.\d+/$`)
+	if !pinned.MatchString(swaggerUIDist) {
+		t.Errorf("swaggerUIDist = %s, want an exact version", swaggerUIDist)
+	}
+	for _, tag := range regexp.MustCompile(`<(?:script|link)[^>]*unpkg[^>]*>`).FindAllString(swaggerUIPage, -1) {
+		if !strings.Contains(tag, `crossorigin="anonymous"`) {
+			t.Errorf("%s is loaded without crossorigin", tag)
+		}
+	}
+}
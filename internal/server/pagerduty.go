@@ -0,0 +1,526 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/pagerduty"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Messages about incidents remembered to act on reactions to them
+const pagerDutyIncidentCapacity = 1000
+
+// PagerDutyWebhook is the body of a PagerDuty V3 webhook
+type PagerDutyWebhook struct {
+	Event PagerDutyEvent `json:"event"`
+}
+
+// PagerDutyEvent is an incident lifecycle event, e.g. incident.triggered
+type PagerDutyEvent struct {
+	ID           string              `json:"id"`
+	EventType    string              `json:"event_type"`
+	ResourceType string              `json:"resource_type"`
+	OccurredAt   time.Time           `json:"occurred_at"`
+	Agent        *PagerDutyReference `json:"agent"` // Who caused the event, if anyone
+	Data         PagerDutyEventData  `json:"data"`
+}
+
+// PagerDutyReference names another PagerDuty object
+type PagerDutyReference struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Summary string `json:"summary"`
+	HTMLURL string `json:"html_url"`
+}
+
+// PagerDutyEventData is the incident of an event, or for incident.annotated
+// the note added to Incident
+type PagerDutyEventData struct {
+	ID          string               `json:"id"`
+	Type        string               `json:"type"`
+	HTMLURL     string               `json:"html_url"`
+	Number      int                  `json:"number"`
+	Title       string               `json:"title"`
+	Status      string               `json:"status"`
+	Urgency     string               `json:"urgency"`
+	IncidentKey string               `json:"incident_key"`
+	Service     *PagerDutyReference  `json:"service"`
+	Assignees   []PagerDutyReference `json:"assignees"`
+	Priority    *PagerDutyReference  `json:"priority"`
+	Incident    *PagerDutyReference  `json:"incident"`
+	Content     string               `json:"content"`
+}
+
+// incidentID returns the ID of the incident an event is about
+func (d *PagerDutyEventData) incidentID() string {
+	if d.Type != "incident" && d.Incident != nil {
+		return d.Incident.ID
+	}
+	return d.ID
+}
+
+// pagerDutyEventEmoji leads the messages of the incident events
+var pagerDutyEventEmoji = map[string]string{
+	"incident.triggered":        "🚨",
+	"incident.acknowledged":     "👀",
+	"incident.unacknowledged":   "🔔",
+	"incident.resolved":         "✅",
+	"incident.reopened":         "🔁",
+	"incident.reassigned":       "↪️",
+	"incident.escalated":        "⏫",
+	"incident.delegated":        "↪️",
+	"incident.priority_updated": "🏷️",
+	"incident.annotated":        "📝",
+}
+
+// pagerDutyIncident is what acknowledging or resolving an incident takes:
+// its ID for the REST API, or the integration and dedup keys of the alert
+// for the Events API
+type pagerDutyIncident struct {
+	ID         string
+	RoutingKey string
+	DedupKey   string
+	Name       string // e.g. #42 or the /page summary
+	Thread     id.EventID
+}
+
+// pagerDutyIncidents pairs the messages about incidents with them, forgetting
+// the oldest messages past pagerDutyIncidentCapacity
+type pagerDutyIncidents struct {
+	mu        sync.Mutex
+	incidents map[string]*pagerDutyIncident     // By incident ID and dedup key
+	messages  map[id.EventID]*pagerDutyIncident // By message about them
+	order     []id.EventID
+}
+
+func newPagerDutyIncidents() *pagerDutyIncidents {
+	return &pagerDutyIncidents{incidents: make(map[string]*pagerDutyIncident), messages: make(map[id.EventID]*pagerDutyIncident)}
+}
+
+// find returns the incident with the ID or dedup key of incident, if known.
+// The lock must be held.
+func (p *pagerDutyIncidents) find(incident pagerDutyIncident) *pagerDutyIncident {
+	for _, key := range []string{incident.ID, incident.DedupKey} {
+		if known, exists := p.incidents[key]; key != "" && exists {
+			return known
+		}
+	}
+	return nil
+}
+
+// lookup returns what is known of the incident with the ID or dedup key of
+// incident
+func (p *pagerDutyIncidents) lookup(incident pagerDutyIncident) (pagerDutyIncident, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if known := p.find(incident); known != nil {
+		return *known, true
+	}
+	return pagerDutyIncident{}, false
+}
+
+// add records a message about an incident, merged with what is already
+// known of it. The thread stays the first message.
+func (p *pagerDutyIncidents) add(eventID id.EventID, incident pagerDutyIncident) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := p.find(incident)
+	if known == nil {
+		known = &pagerDutyIncident{}
+	}
+	if incident.ID != "" {
+		known.ID = incident.ID
+	}
+	if incident.RoutingKey != "" {
+		known.RoutingKey = incident.RoutingKey
+	}
+	if incident.DedupKey != "" {
+		known.DedupKey = incident.DedupKey
+	}
+	if incident.Name != "" {
+		known.Name = incident.Name
+	}
+	if known.Thread == "" {
+		known.Thread = incident.Thread
+	}
+	for _, key := range []string{known.ID, known.DedupKey} {
+		if key != "" {
+			p.incidents[key] = known
+		}
+	}
+
+	if _, exists := p.messages[eventID]; !exists {
+		p.order = append(p.order, eventID)
+	}
+	p.messages[eventID] = known
+	if len(p.order) > pagerDutyIncidentCapacity {
+		oldest := p.order[0]
+		p.order = p.order[1:]
+		forgotten := p.messages[oldest]
+		delete(p.messages, oldest)
+		// Keep the incident while other messages are about it
+		for _, incident := range p.messages {
+			if incident == forgotten {
+				return
+			}
+		}
+		delete(p.incidents, forgotten.ID)
+		delete(p.incidents, forgotten.DedupKey)
+	}
+}
+
+// message returns the incident a message is about
+func (p *pagerDutyIncidents) message(eventID id.EventID) (pagerDutyIncident, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if incident, exists := p.messages[eventID]; exists {
+		return *incident, true
+	}
+	return pagerDutyIncident{}, false
+}
+
+// pagerDutyRoom returns the room PagerDuty events are posted to
+func (s *Server) pagerDutyRoom() id.RoomID {
+	cfg := s.cfg()
+	if cfg.Hooks.PagerDuty.RoomID != "" {
+		return id.RoomID(cfg.Hooks.PagerDuty.RoomID)
+	}
+	return id.RoomID(cfg.Matrix.RoomID)
+}
+
+// hasPagerDutySignature checks the X-PagerDuty-Signature header, which lists
+// v1=<hex HMAC-SHA256 of the body> for every active secret of the
+// subscription. An empty secret accepts every request.
+func hasPagerDutySignature(r *http.Request, body []byte, secret string) bool {
+	if secret == "" {
+		return true
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range strings.Split(r.Header.Get("X-PagerDuty-Signature"), ",") {
+		signature = strings.TrimSpace(signature)
+		if !strings.HasPrefix(signature, "v1=") {
+			continue
+		}
+		if provided, err := hex.DecodeString(signature[len("v1="):]); err == nil && hmac.Equal(provided, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// pagerDutyIncidentName returns the short name of the incident of an event
+func pagerDutyIncidentName(e *PagerDutyEvent) string {
+	if e.Data.Type == "incident" && e.Data.Number > 0 {
+		return fmt.Sprintf("#%d", e.Data.Number)
+	}
+	if e.Data.Incident != nil && e.Data.Incident.Summary != "" {
+		return e.Data.Incident.Summary
+	}
+	return e.Data.incidentID()
+}
+
+// formatPagerDutyEvent renders an incident event as markdown. Triggered
+// incidents are described in full; the later events of an incident are
+// replies to that message, so they are short.
+func formatPagerDutyEvent(e *PagerDutyEvent) string {
+	emoji := pagerDutyEventEmoji[e.EventType]
+	if emoji == "" {
+		emoji = "ℹ️"
+	}
+	name := pagerDutyIncidentName(e)
+	by := ""
+	if e.Agent != nil && e.Agent.Summary != "" {
+		by = " by " + e.Agent.Summary
+	}
+	assignees := make([]string, 0, len(e.Data.Assignees))
+	for _, assignee := range e.Data.Assignees {
+		assignees = append(assignees, assignee.Summary)
+	}
+
+	switch e.EventType {
+	case "incident.triggered":
+		var b strings.Builder
+		if e.Data.HTMLURL != "" {
+			fmt.Fprintf(&b, "%s **[%s %s](%s)**", emoji, name, e.Data.Title, e.Data.HTMLURL)
+		} else {
+			fmt.Fprintf(&b, "%s **%s %s**", emoji, name, e.Data.Title)
+		}
+		var details []string
+		if e.Data.Service != nil {
+			details = append(details, "Service: "+e.Data.Service.Summary)
+		}
+		if e.Data.Priority != nil {
+			details = append(details, "Priority: "+e.Data.Priority.Summary)
+		}
+		if e.Data.Urgency != "" {
+			details = append(details, "Urgency: "+e.Data.Urgency)
+		}
+		if len(assignees) > 0 {
+			details = append(details, "Assigned to: "+strings.Join(assignees, ", "))
+		}
+		if len(details) > 0 {
+			b.WriteString("\n" + strings.Join(details, " · "))
+		}
+		return b.String()
+	case "incident.reassigned", "incident.escalated", "incident.delegated":
+		verb := strings.TrimPrefix(e.EventType, "incident.")
+		return fmt.Sprintf("%s %s %s to %s%s", emoji, name, verb, strings.Join(assignees, ", "), by)
+	case "incident.priority_updated":
+		priority := "none"
+		if e.Data.Priority != nil {
+			priority = e.Data.Priority.Summary
+		}
+		return fmt.Sprintf("%s %s priority changed to %s%s", emoji, name, priority, by)
+	case "incident.annotated":
+		return fmt.Sprintf("%s Note on %s%s: %s", emoji, name, by, e.Data.Content)
+	default:
+		what := strings.ReplaceAll(strings.TrimPrefix(e.EventType, "incident."), "_", " ")
+		return fmt.Sprintf("%s %s %s%s", emoji, name, what, by)
+	}
+}
+
+// handlePagerDuty posts the incident events of a PagerDuty webhook
+// subscription. The events of an incident reply to the message of its
+// trigger, or of its /page, and reacting to them acknowledges or resolves it.
+func (s *Server) handlePagerDuty(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("PagerDuty hook called")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !hasPagerDutySignature(r, body, s.cfg().Hooks.PagerDuty.Secret) {
+		s.logger.Warn("Rejecting PagerDuty hook call with missing or invalid signature")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload PagerDutyWebhook
+	if err := json.Unmarshal(body, &payload); err != nil {
+		s.logger.Error("Invalid PagerDuty payload: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	e := &payload.Event
+
+	w.Header().Set("Content-Type", "application/json")
+	// pagey.ping and events about other resources
+	if !strings.HasPrefix(e.EventType, "incident.") {
+		s.logger.Info("Ignoring PagerDuty %s event", e.EventType)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SendResponse{Status: "skipped"})
+		return
+	}
+
+	incident := pagerDutyIncident{ID: e.Data.incidentID(), DedupKey: e.Data.IncidentKey}
+	known, _ := s.pagerDutyIncidents.lookup(incident)
+	if known.Name == "" || e.Data.Number > 0 {
+		incident.Name = pagerDutyIncidentName(e)
+	}
+
+	roomID := s.pagerDutyRoom()
+	opts := []matrix.SendMessageOption{matrix.WithRoom(roomID), matrix.WithSender("pagerduty"), withRequestID(r)}
+	if known.Thread != "" {
+		opts = append(opts, matrix.WithReplyTo(known.Thread))
+	}
+	s.logger.Info("Posting PagerDuty %s of incident %s to room %q", e.EventType, incident.ID, roomID)
+	eventID, err := s.matrix.SendMessage(formatPagerDutyEvent(e), opts...)
+	if err != nil {
+		s.logger.Error("Failed to send PagerDuty event to Matrix: %v", err)
+		http.Error(w, "Failed to send message to Matrix", http.StatusInternalServerError)
+		return
+	}
+	incident.Thread = eventID
+	s.pagerDutyIncidents.add(eventID, incident)
+	if e.EventType == "incident.triggered" && known.Thread == "" {
+		s.offerPagerDutyReactions(roomID, eventID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
+}
+
+// offerPagerDutyReactions reacts to the message of a new incident with the
+// acknowledge and resolve reactions, so that they are one click away
+func (s *Server) offerPagerDutyReactions(roomID id.RoomID, eventID id.EventID) {
+	cfg := s.cfg().PagerDuty
+	for _, key := range []string{cfg.AckReaction, cfg.ResolveReaction} {
+		if _, err := s.matrix.SendReaction(eventID, key, matrix.WithRoom(roomID)); err != nil {
+			s.logger.Warn("Failed to add %s reaction to %s: %v", key, eventID, err)
+		}
+	}
+}
+
+// isPageCommand reports whether the message is a /page command
+func isPageCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/page"
+}
+
+// handlePageCommand triggers an incident on a service of
+// pagerduty.services:
+// /page <service> <summary>
+func (s *Server) handlePageCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, message string, threadRootEventID id.EventID) {
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+	log := s.logger.Ctx(ctx)
+	cfg := s.cfg().PagerDuty
+
+	fields := strings.Fields(message)
+	if len(fields) < 3 {
+		services := make([]string, 0, len(cfg.Services))
+		for service := range cfg.Services {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		reply(s.text(roomID, "pagerduty.usage", strings.Join(services, ", ")))
+		return
+	}
+
+	service := fields[1]
+	routingKey, exists := cfg.Services[service]
+	if !exists {
+		reply(s.text(roomID, "pagerduty.unknown", service))
+		return
+	}
+	summary := strings.Join(fields[2:], " ")
+
+	dedupKey, err := s.pagerDuty.SendEvent(ctx, pagerduty.Event{
+		RoutingKey:  routingKey,
+		EventAction: pagerduty.ActionTrigger,
+		Payload: &pagerduty.Payload{
+			Summary:  summary,
+			Source:   string(roomID),
+			Severity: cfg.Severity,
+			CustomDetails: map[string]interface{}{
+				"paged_by": string(sender),
+				"room_id":  string(roomID),
+				"event_id": string(eventID),
+			},
+		},
+	})
+	if err != nil {
+		log.Error("Failed to page %s: %v", service, err)
+		reply(s.text(roomID, "pagerduty.page_failed", service, err))
+		return
+	}
+	log.Info("%s paged %s (dedup key %s)", sender, service, dedupKey)
+
+	incident := pagerDutyIncident{RoutingKey: routingKey, DedupKey: dedupKey, Name: summary}
+	text := s.text(roomID, "pagerduty.paged", service, summary, cfg.AckReaction, cfg.ResolveReaction)
+	replyID, err := s.matrix.SendMessage(text, replyOptions(ctx, sender, threadRootEventID)...)
+	if err != nil {
+		log.Error("Failed to send reply to Matrix: %v", err)
+		s.pagerDutyIncidents.add(eventID, incident)
+		return
+	}
+	s.relayReplyToTelegram(ctx, text, replyID)
+	incident.Thread = replyID
+	s.pagerDutyIncidents.add(replyID, incident)
+	s.pagerDutyIncidents.add(eventID, incident)
+	s.offerPagerDutyReactions(roomID, replyID)
+}
+
+// updatePagerDutyIncident acknowledges or resolves an incident, through the
+// REST API if it has an ID and an API token is set, else through the Events
+// API if it was paged here
+func (s *Server) updatePagerDutyIncident(ctx context.Context, incident pagerDutyIncident, action string) error {
+	if incident.ID != "" && s.pagerDuty.CanUpdateIncidents() {
+		status := "acknowledged"
+		if action == pagerduty.ActionResolve {
+			status = "resolved"
+		}
+		return s.pagerDuty.SetIncidentStatus(ctx, incident.ID, status)
+	}
+	if incident.RoutingKey != "" {
+		_, err := s.pagerDuty.SendEvent(ctx, pagerduty.Event{RoutingKey: incident.RoutingKey, EventAction: action, DedupKey: incident.DedupKey})
+		return err
+	}
+	return fmt.Errorf("set pagerduty.api_token to update incidents not paged from Matrix")
+}
+
+// pagerDutyAction returns the action of a reaction key, ignoring emoji
+// variation selectors
+func pagerDutyAction(key, ackReaction, resolveReaction string) string {
+	normalize := func(s string) string { return strings.ReplaceAll(s, "\ufe0f", "") }
+	switch normalize(key) {
+	case normalize(ackReaction):
+		return pagerduty.ActionAcknowledge
+	case normalize(resolveReaction):
+		return pagerduty.ActionResolve
+	}
+	return ""
+}
+
+// handlePagerDutyReaction acknowledges or resolves the incident of the
+// message a user of the room reacted to, if they may run /page
+func (s *Server) handlePagerDutyReaction(evt *event.Event) {
+	if evt.Type != event.EventReaction || evt.Sender == id.UserID(s.cfg().Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsReaction()
+	if content == nil {
+		return
+	}
+	cfg := s.cfg().PagerDuty
+	action := pagerDutyAction(content.RelatesTo.Key, cfg.AckReaction, cfg.ResolveReaction)
+	if action == "" {
+		return
+	}
+	incident, exists := s.pagerDutyIncidents.message(content.RelatesTo.EventID)
+	if !exists {
+		return
+	}
+	if !s.roomSettings(evt.RoomID).AllowsUser(string(evt.Sender)) {
+		s.logger.Info("Ignoring PagerDuty reaction of %s, who is not in the allowed users of room %s", evt.Sender, evt.RoomID)
+		return
+	}
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring PagerDuty reaction of %s", evt.Sender)
+		return
+	}
+	// Acting on an incident takes the permission of /page
+	if !s.cfg().Permissions.Allows(string(evt.Sender), "page") {
+		s.logger.Warn("User %s may not run page, ignoring their PagerDuty reaction to %s", evt.Sender, incident.Name)
+		s.auditCommand(context.Background(), evt.RoomID, evt.Sender, evt.ID, audit.KindCommand, "page", action+" "+incident.Name).done("", audit.StatusRejected, fmt.Errorf("%s may not run page", evt.Sender))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout)*time.Second)
+	defer cancel()
+	opts := []matrix.SendMessageOption{matrix.WithRoom(evt.RoomID), matrix.WithReplyTo(content.RelatesTo.EventID), matrix.WithMention(evt.Sender), matrix.WithSender("pagerduty")}
+	verb, failed, done := "acknowledge", "pagerduty.acknowledge_failed", "pagerduty.acknowledged"
+	if action == pagerduty.ActionResolve {
+		verb, failed, done = "resolve", "pagerduty.resolve_failed", "pagerduty.resolved"
+	}
+	if err := s.updatePagerDutyIncident(ctx, incident, action); err != nil {
+		s.logger.Error("Failed to %s PagerDuty incident %s for %s: %v", verb, incident.Name, evt.Sender, err)
+		if _, err := s.matrix.SendMessage(s.text(evt.RoomID, failed, incident.Name, err), opts...); err != nil {
+			s.logger.Error("Failed to send reply to Matrix: %v", err)
+		}
+		return
+	}
+	s.logger.Info("%s %sd PagerDuty incident %s", evt.Sender, verb, incident.Name)
+	// The webhook reports the change when it is set up
+	if !s.cfg().Hooks.PagerDuty.Enabled {
+		if _, err := s.matrix.SendMessage(s.text(evt.RoomID, done, incident.Name, evt.Sender), opts...); err != nil {
+			s.logger.Error("Failed to send reply to Matrix: %v", err)
+		}
+	}
+}
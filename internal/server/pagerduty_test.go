@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/pagerduty"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHasPagerDutySignature(t *testing.T) {
+	body := []byte(`{"event": {}}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	valid := "v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		signature string
+		secret    string
+		expected  bool
+	}{
+		{"No secret", "", "", true},
+		{"Valid", valid, "secret", true},
+		{"One of several", "v1=00ff, " + valid, "secret", true},
+		{"Invalid", "v1=00ff", "secret", false},
+		{"Missing", "", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/hook/pagerduty", nil)
+			if tt.signature != "" {
+				r.Header.Set("X-PagerDuty-Signature", tt.signature)
+			}
+			if got := hasPagerDutySignature(r, body, tt.secret); got != tt.expected {
+				t.Errorf("hasPagerDutySignature() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatPagerDutyEvent(t *testing.T) {
+	incident := `"data": {
+		"id": "PGR0VU2", "type": "incident", "number": 2,
+		"title": "A little bump in the road",
+		"html_url": "https://acme.pagerduty.com/incidents/PGR0VU2",
+		"urgency": "high",
+		"service": {"summary": "API Service"},
+		"priority": {"summary": "P1"},
+		"assignees": [{"summary": "Alice"}]
+	}`
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"Triggered",
+			`{"event_type": "incident.triggered", ` + incident + `}`,
+			"🚨 **[#2 A little bump in the road](https://acme.pagerduty.com/incidents/PGR0VU2)**\nService: API Service · Priority: P1 · Urgency: high · Assigned to: Alice",
+		},
+		{
+			"Acknowledged",
+			`{"event_type": "incident.acknowledged", "agent": {"summary": "Alice"}, ` + incident + `}`,
+			"👀 #2 acknowledged by Alice",
+		},
+		{
+			"Escalated",
+			`{"event_type": "incident.escalated", ` + incident + `}`,
+			"⏫ #2 escalated to Alice",
+		},
+		{
+			"Annotated",
+			`{"event_type": "incident.annotated", "agent": {"summary": "Bob"}, "data": {"id": "P1", "type": "incident_note", "content": "Rolling back", "incident": {"id": "PGR0VU2", "summary": "[#2] A little bump in the road"}}}`,
+			"📝 Note on [#2] A little bump in the road by Bob: Rolling back",
+		},
+		{
+			"Other",
+			`{"event_type": "incident.responder.added", ` + incident + `}`,
+			"ℹ️ #2 responder.added",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e PagerDutyEvent
+			if err := json.Unmarshal([]byte(tt.body), &e); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := formatPagerDutyEvent(&e); got != tt.expected {
+				t.Errorf("formatPagerDutyEvent() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPagerDutyIncidents(t *testing.T) {
+	incidents := newPagerDutyIncidents()
+	// Paged from Matrix, then triggered through the webhook
+	incidents.add("$page", pagerDutyIncident{RoutingKey: "key", DedupKey: "dedup", Name: "Database down", Thread: "$page"})
+	incidents.add("$hook", pagerDutyIncident{ID: "PGR0VU2", DedupKey: "dedup", Name: "#2", Thread: "$hook"})
+
+	incident, exists := incidents.message("$page")
+	expected := pagerDutyIncident{ID: "PGR0VU2", RoutingKey: "key", DedupKey: "dedup", Name: "#2", Thread: "$page"}
+	if !exists || incident != expected {
+		t.Errorf("message($page) = %+v, want %+v", incident, expected)
+	}
+	if incident, _ := incidents.lookup(pagerDutyIncident{ID: "PGR0VU2"}); incident.Thread != "$page" {
+		t.Errorf("lookup(PGR0VU2) thread = %q, want $page", incident.Thread)
+	}
+
+	// Older messages are forgotten, and the incidents no message is about
+	for i := 0; i < pagerDutyIncidentCapacity; i++ {
+		incidents.add(id.EventID(fmt.Sprintf("$%d", i)), pagerDutyIncident{ID: fmt.Sprintf("P%d", i)})
+	}
+	if _, exists := incidents.message("$page"); exists {
+		t.Error("message($page) exists, want it forgotten")
+	}
+	if _, exists := incidents.lookup(pagerDutyIncident{ID: "PGR0VU2"}); exists {
+		t.Error("lookup(PGR0VU2) exists, want it forgotten")
+	}
+	if _, exists := incidents.lookup(pagerDutyIncident{ID: "P0"}); !exists {
+		t.Error("lookup(P0) is missing")
+	}
+}
+
+func TestPagerDutyAction(t *testing.T) {
+	tests := map[string]string{
+		"👀":       pagerduty.ActionAcknowledge,
+		"✅":       pagerduty.ActionResolve,
+		"✅\ufe0f": pagerduty.ActionResolve, // With a variation selector
+		"👍":       "",
+	}
+	for key, expected := range tests {
+		if got := pagerDutyAction(key, "👀", "✅"); got != expected {
+			t.Errorf("pagerDutyAction(%q) = %q, want %q", key, got, expected)
+		}
+	}
+}
+
+func TestUpdatePagerDutyIncident(t *testing.T) {
+	var paths []string
+	var actions []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		var e pagerduty.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		actions = append(actions, e.EventAction)
+		w.Write([]byte(`{"status": "success"}`))
+	}))
+	defer api.Close()
+
+	cfg := &config.PagerDutyConfig{EventsURL: api.URL + "/v2/enqueue", APIURL: api.URL, Timeout: 5}
+	s := &Server{pagerDuty: pagerduty.NewClient(cfg)}
+	ctx := context.Background()
+
+	// Without an API token only incidents paged from Matrix can be updated
+	if err := s.updatePagerDutyIncident(ctx, pagerDutyIncident{ID: "PGR0VU2"}, pagerduty.ActionResolve); err == nil || !strings.Contains(err.Error(), "api_token") {
+		t.Errorf("updatePagerDutyIncident() error = %v, want an api_token error", err)
+	}
+	if err := s.updatePagerDutyIncident(ctx, pagerDutyIncident{ID: "PGR0VU2", RoutingKey: "key", DedupKey: "dedup"}, pagerduty.ActionAcknowledge); err != nil {
+		t.Errorf("updatePagerDutyIncident() error = %v", err)
+	}
+
+	cfg.APIToken, cfg.From = "token", "oncall@example.com"
+	s.pagerDuty = pagerduty.NewClient(cfg)
+	if err := s.updatePagerDutyIncident(ctx, pagerDutyIncident{ID: "PGR0VU2", RoutingKey: "key", DedupKey: "dedup"}, pagerduty.ActionResolve); err != nil {
+		t.Errorf("updatePagerDutyIncident() error = %v", err)
+	}
+
+	expected := []string{"POST /v2/enqueue", "PUT /incidents/PGR0VU2"}
+	if strings.Join(paths, ", ") != strings.Join(expected, ", ") || actions[0] != pagerduty.ActionAcknowledge {
+		t.Errorf("requests = %v with actions %v, want %v", paths, actions, expected)
+	}
+}
+
+func TestPagerDutyReactionRequiresPermission(t *testing.T) {
+	requests := make(chan string, 2)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path
+		w.Write([]byte(`{"status": "success"}`))
+	}))
+	defer api.Close()
+
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		PagerDuty: config.PagerDutyConfig{EventsURL: api.URL + "/v2/enqueue", Timeout: 5, AckReaction: "👀", ResolveReaction: "✅"},
+		// The webhook reports the change, so no reply is sent
+		Hooks:       config.HooksConfig{PagerDuty: config.PagerDutyHookConfig{Enabled: true}},
+		Permissions: config.PermissionsConfig{Rules: []config.PermissionRule{{Users: []string{"@ops:*"}, Commands: []string{"page"}}}},
+	}
+	s := &Server{config: cfg, logger: log, auditLog: auditLog, pagerDuty: pagerduty.NewClient(&cfg.PagerDuty), pagerDutyIncidents: newPagerDutyIncidents()}
+	s.pagerDutyIncidents.add("$incident", pagerDutyIncident{RoutingKey: "key", DedupKey: "dedup", Name: "#42"})
+
+	react := func(sender id.UserID, eventID id.EventID) {
+		s.handlePagerDutyReaction(&event.Event{
+			Type:    event.EventReaction,
+			Sender:  sender,
+			ID:      eventID,
+			RoomID:  "!room:example.com",
+			Content: event.Content{Parsed: &event.ReactionEventContent{RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: "$incident", Key: "✅"}}},
+		})
+	}
+	react("@user:example.com", "$denied")
+	if len(requests) != 0 {
+		t.Fatalf("a user without the page permission resolved the incident")
+	}
+	react("@ops:example.com", "$allowed")
+	if len(requests) != 1 {
+		t.Errorf("%d requests, want the permitted one", len(requests))
+	}
+	auditLog.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	data, _ := os.ReadFile(files[0])
+	var record audit.Record
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.EventID != "$denied" || record.Kind != audit.KindCommand || record.Status != audit.StatusRejected || record.Command != "page" {
+		t.Errorf("first record = %+v, want the refused reaction", record)
+	}
+}
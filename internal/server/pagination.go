@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// codeFence opens and closes code blocks
+const codeFence = "```"
+
+// paginate splits text into pages of at most pageSize characters, at line
+// breaks where possible. A code block split across pages is closed at the
+// end of one page and opened again on the next. Pages past maxPages are
+// dropped; the last page kept says that the reply was cut off.
+func paginate(text string, pageSize, maxPages int) []string {
+	if utf8.RuneCountInString(text) <= pageSize {
+		return []string{text}
+	}
+
+	var pages []string
+	var page strings.Builder
+	pageLen, pageStart := 0, 0
+	fence := "" // Opening line of the code block the page ends in
+	flush := func() {
+		if fence != "" {
+			page.WriteString("\n" + codeFence)
+		}
+		pages = append(pages, page.String())
+		page.Reset()
+		pageLen, pageStart = 0, 0
+		if fence != "" {
+			page.WriteString(fence)
+			pageLen = utf8.RuneCountInString(fence)
+			pageStart = pageLen
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		isFence := strings.HasPrefix(strings.TrimSpace(line), codeFence)
+		remaining := line
+		for {
+			limit := pageSize
+			if fence != "" && !isFence {
+				limit -= len(codeFence) + 1 // The line closing the block
+			}
+			separator := 0
+			if pageLen > 0 {
+				separator = 1
+			}
+			length := utf8.RuneCountInString(remaining)
+			if pageLen+separator+length <= limit {
+				if separator > 0 {
+					page.WriteByte('\n')
+				}
+				page.WriteString(remaining)
+				pageLen += separator + length
+				break
+			}
+			if pageLen > pageStart {
+				flush()
+				continue
+			}
+			// A line longer than a page is split
+			room := max(limit-pageLen-separator, 1)
+			runes := []rune(remaining)
+			if separator > 0 {
+				page.WriteByte('\n')
+			}
+			page.WriteString(string(runes[:room]))
+			pageLen += separator + room
+			remaining = string(runes[room:])
+			flush()
+		}
+		if isFence {
+			if fence == "" {
+				fence = strings.TrimSpace(line)
+			} else {
+				fence = ""
+			}
+		}
+	}
+	if pageLen > pageStart {
+		pages = append(pages, page.String())
+	}
+
+	if len(pages) > maxPages {
+		pages = pages[:maxPages]
+		pages[maxPages-1] += fmt.Sprintf("\n\n_The reply was cut off after %d pages._", maxPages)
+	}
+	return pages
+}
+
+// replyPages splits a reply into pages if pagination is enabled
+func (s *Server) replyPages(message string) []string {
+	if s.pages == nil {
+		return []string{message}
+	}
+	cfg := s.cfg().Pagination
+	return paginate(message, cfg.PageSize, cfg.MaxPages)
+}
+
+// pagedReply is a reply whose next page is posted on request
+type pagedReply struct {
+	pages      []string
+	next       int // Index of the next page
+	roomID     id.RoomID
+	threadRoot id.EventID // The first page, later pages go in its thread
+	sender     string     // Command whose virtual user posts the pages
+}
+
+// pagedReplies remembers the last page posted of each paged reply by event
+// ID, forgetting the oldest past its capacity
+type pagedReplies struct {
+	mu       sync.Mutex
+	capacity int
+	replies  map[id.EventID]*pagedReply
+	order    []id.EventID
+}
+
+func newPagedReplies(capacity int) *pagedReplies {
+	return &pagedReplies{capacity: capacity, replies: make(map[id.EventID]*pagedReply)}
+}
+
+func (p *pagedReplies) add(eventID id.EventID, reply *pagedReply) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.replies[eventID]; !exists {
+		p.order = append(p.order, eventID)
+	}
+	p.replies[eventID] = reply
+	if len(p.order) > p.capacity {
+		delete(p.replies, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+// take returns and forgets the reply whose last page is eventID, so that
+// each page is followed by the next once
+func (p *pagedReplies) take(eventID id.EventID) (*pagedReply, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reply, exists := p.replies[eventID]
+	if exists {
+		delete(p.replies, eventID)
+		for i, known := range p.order {
+			if known == eventID {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+	}
+	return reply, exists
+}
+
+// pageText returns page i of pages with a footer numbering it and, unless
+// it is the last, saying how to get the next
+func pageText(pages []string, i int, reaction string) string {
+	footer := fmt.Sprintf("_Page %d of %d", i+1, len(pages))
+	if i < len(pages)-1 {
+		footer += fmt.Sprintf(", react with %s for the next", reaction)
+	}
+	return pages[i] + "\n\n" + footer + "_"
+}
+
+// sendPagedReply posts the first page of a reply too long for one message
+// and returns its event ID
+func (s *Server) sendPagedReply(ctx context.Context, pages []string, sender id.UserID, replyEventID id.EventID) id.EventID {
+	reaction := s.cfg().Pagination.Reaction
+	eventID, err := s.matrix.SendMessage(pageText(pages, 0, reaction), replyOptions(ctx, sender, replyEventID)...)
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Failed to send reply to Matrix: %v", err)
+		return ""
+	}
+	s.logger.Ctx(ctx).Info("Split the reply into %d pages", len(pages))
+	s.offerNextPage(ctx, eventID, &pagedReply{pages: pages, next: 1, roomID: replyRoom(ctx), threadRoot: eventID, sender: replySender(ctx)})
+	return eventID
+}
+
+// offerNextPage reacts to the page just posted, unless it was the last, and
+// remembers the reply for reactions to it
+func (s *Server) offerNextPage(ctx context.Context, eventID id.EventID, reply *pagedReply) {
+	if reply.next >= len(reply.pages) {
+		return
+	}
+	if _, err := s.matrix.SendReaction(eventID, s.cfg().Pagination.Reaction, matrix.WithRoom(reply.roomID), matrix.WithLogContext(ctx)); err != nil {
+		s.logger.Ctx(ctx).Error("Failed to react to page %d: %v", reply.next, err)
+	}
+	s.pages.add(eventID, reply)
+}
+
+// pageReaction reports whether a reaction asks for the next page, ignoring
+// variation selectors
+func pageReaction(key, reaction string) bool {
+	normalize := func(s string) string { return strings.ReplaceAll(s, "\ufe0f", "") }
+	return normalize(key) == normalize(reaction)
+}
+
+// handlePageReaction posts the next page of a reply when a user of the room
+// reacts to its last page
+func (s *Server) handlePageReaction(evt *event.Event) {
+	cfg := s.cfg()
+	if evt.Type != event.EventReaction || evt.Sender == id.UserID(cfg.Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsReaction()
+	if content == nil || !pageReaction(content.RelatesTo.Key, cfg.Pagination.Reaction) {
+		return
+	}
+	if !s.roomSettings(evt.RoomID).AllowsUser(string(evt.Sender)) {
+		s.logger.Info("Ignoring page request of %s, who is not in the allowed users of room %s", evt.Sender, evt.RoomID)
+		return
+	}
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring page request of %s", evt.Sender)
+		return
+	}
+	reply, exists := s.pages.take(content.RelatesTo.EventID)
+	if !exists {
+		return
+	}
+
+	// Sending must not hold up the sync
+	go func() {
+		ctx := withReplyRoom(context.Background(), reply.roomID)
+		page := pageText(reply.pages, reply.next, cfg.Pagination.Reaction)
+		eventID, err := s.matrix.SendMessage(page, matrix.WithRoom(reply.roomID), matrix.WithThread(reply.threadRoot), matrix.WithReplyTo(content.RelatesTo.EventID), matrix.WithSender(reply.sender))
+		if err != nil {
+			s.logger.Error("Failed to send page %d of %s: %v", reply.next+1, reply.threadRoot, err)
+			// Reacting again retries
+			s.pages.add(content.RelatesTo.EventID, reply)
+			return
+		}
+		s.logger.Info("Posted page %d of %d of %s for %s", reply.next+1, len(reply.pages), reply.threadRoot, evt.Sender)
+		reply.next++
+		s.offerNextPage(ctx, eventID, reply)
+	}()
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		pageSize int
+		maxPages int
+		expected []string
+	}{
+		{
+			name:     "Short reply",
+			text:     "all good",
+			pageSize: 100,
+			maxPages: 3,
+			expected: []string{"all good"},
+		},
+		{
+			name:     "Split at line breaks",
+			text:     "line one\nline two\nline three",
+			pageSize: 18,
+			maxPages: 3,
+			expected: []string{"line one\nline two", "line three"},
+		},
+		{
+			name:     "Long line",
+			text:     "ééééééééé",
+			pageSize: 4,
+			maxPages: 5,
+			expected: []string{"éééé", "éééé", "é"},
+		},
+		{
+			name:     "Code block",
+			text:     "Logs:\n```text\nfirst\nsecond\nthird\n```\ndone",
+			pageSize: 26,
+			maxPages: 5,
+			expected: []string{"Logs:\n```text\nfirst\n```", "```text\nsecond\nthird\n```", "done"},
+		},
+		{
+			name:     "Cut off",
+			text:     "one\ntwo\nthree",
+			pageSize: 4,
+			maxPages: 2,
+			expected: []string{"one", "two\n\n_The reply was cut off after 2 pages._"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := paginate(tt.text, tt.pageSize, tt.maxPages)
+			if strings.Join(pages, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("paginate() = %q, want %q", pages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPaginatePageSize(t *testing.T) {
+	text := strings.Repeat("a line of the reply\n```go\nfmt.Println(\"hello\")\n```\n", 50)
+	for i, page := range paginate(text, 100, 100) {
+		if length := utf8.RuneCountInString(page); length > 100 {
+			t.Errorf("page %d has %d characters, want at most 100", i, length)
+		}
+		if strings.Count(page, "```")%2 != 0 {
+			t.Errorf("page %d leaves a code block open: %q", i, page)
+		}
+	}
+}
+
+func TestPagedReplies(t *testing.T) {
+	replies := newPagedReplies(2)
+	replies.add("$1", &pagedReply{pages: []string{"a", "b"}, next: 1})
+	replies.add("$2", &pagedReply{pages: []string{"c", "d"}, next: 1})
+	replies.add("$3", &pagedReply{pages: []string{"e", "f"}, next: 1})
+
+	if _, exists := replies.take("$1"); exists {
+		t.Error("take() returned a reply past the capacity")
+	}
+	if reply, exists := replies.take("$2"); !exists || reply.pages[reply.next] != "d" {
+		t.Errorf("take() = %+v, %v, want the reply of $2", reply, exists)
+	}
+	if _, exists := replies.take("$2"); exists {
+		t.Error("take() returned a reply twice")
+	}
+}
+
+func TestPageText(t *testing.T) {
+	pages := []string{"first", "second"}
+	if got := pageText(pages, 0, "▶ more"); got != "first\n\n_Page 1 of 2, react with ▶ more for the next_" {
+		t.Errorf("pageText() of the first page = %q", got)
+	}
+	if got := pageText(pages, 1, "▶ more"); got != "second\n\n_Page 2 of 2_" {
+		t.Errorf("pageText() of the last page = %q", got)
+	}
+	if !pageReaction("\u25b6\ufe0f more", "▶ more") || pageReaction("👍", "▶ more") {
+		t.Error("pageReaction() does not match the page reaction only")
+	}
+	var s Server
+	if pages := s.replyPages(strings.Repeat("x", 10000)); len(pages) != 1 {
+		t.Errorf("replyPages() without pagination = %d pages, want 1", len(pages))
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"maunium.net/go/mautrix/id"
+)
+
+// leadingCommand returns the name of the slash command the message starts
+// with, "" if it does not start with one
+func leadingCommand(message string) string {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+	return strings.TrimPrefix(fields[0], "/")
+}
+
+// commandPermitted reports whether sender may run command as of the
+// permissions setting. A refused command is answered with a notice and
+// audited as rejected.
+func (s *Server) commandPermitted(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, kind, command, args string, threadRootEventID id.EventID) bool {
+	if command == "" || s.cfg().Permissions.Allows(string(sender), command) {
+		return true
+	}
+	s.logger.Ctx(ctx).Warn("User %s may not run %s, refusing it", sender, command)
+	s.auditCommand(ctx, roomID, sender, eventID, kind, command, args).done("", audit.StatusRejected, fmt.Errorf("%s may not run %s", sender, command))
+	s.sendReply(ctx, s.text(roomID, "command.denied", command), sender, threadRootEventID)
+	return false
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestPermissionsAllows(t *testing.T) {
+	permissions := &config.PermissionsConfig{
+		Groups: map[string][]string{"sre": {"@alice:example.com", "@*:sre.example.com"}},
+		Rules: []config.PermissionRule{
+			{Users: []string{"@ops:*", "group:sre"}, Commands: []string{"deploy", "db_*"}},
+			{Users: []string{"*"}, Commands: []string{"status"}},
+		},
+	}
+	tests := []struct {
+		user, command string
+		want          bool
+	}{
+		{"@ops:example.com", "deploy", true},
+		{"@ops:other.org", "/deploy", true},
+		{"@alice:example.com", "db_restore", true},
+		{"@bob:sre.example.com", "Deploy", true},
+		{"@bob:example.com", "deploy", false},
+		{"@bob:example.com", "db_restore", false},
+		{"@bob:example.com", "status", true},
+		{"@bob:example.com", "weather", true},
+	}
+	for _, tt := range tests {
+		if got := permissions.Allows(tt.user, tt.command); got != tt.want {
+			t.Errorf("Allows(%s, %s) = %v, want %v", tt.user, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestLeadingCommand(t *testing.T) {
+	for message, want := range map[string]string{
+		"/deploy prod":         "deploy",
+		"  /remind me in 2h x": "remind",
+		"see https://x.org/a":  "",
+		"please /deploy prod":  "",
+		"":                     "",
+	} {
+		if got := leadingCommand(message); got != want {
+			t.Errorf("leadingCommand(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestHandleMessageRefusesCommand(t *testing.T) {
+	dispatched := make(chan struct{}, 2)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatched <- struct{}{}
+	}))
+	defer target.Close()
+
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			Default:       target.URL,
+			Commands:      map[string]string{"deploy": target.URL},
+			Template:      `{"message": "{{.MESSAGE}}"}`,
+			ReplyScript:   `return ""`, // No Matrix client to send the notice with
+			ScriptTimeout: 100,
+		},
+		Permissions: config.PermissionsConfig{Rules: []config.PermissionRule{{Users: []string{"@ops:*"}, Commands: []string{"deploy"}}}},
+	}
+	scripts, err := compileHookScripts(&cfg.Webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), auditLog: auditLog, scripts: scripts}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "/deploy prod", "", "", "$denied")
+	s.HandleMessage("!room:example.com", id.UserID("@ops:example.com"), "/deploy prod", "", "", "$allowed")
+	auditLog.Close()
+
+	if len(dispatched) != 1 {
+		t.Errorf("%d dispatches, want only the allowed one", len(dispatched))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	data, _ := os.ReadFile(files[0])
+	var record audit.Record
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.EventID != "$denied" || record.Kind != audit.KindCommand || record.Status != audit.StatusRejected || record.Command != "deploy" {
+		t.Errorf("first record = %+v, want the refused command", record)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// isPingCommand reports whether the message is a /ping command
+func isPingCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) == 1 && fields[0] == "/ping"
+}
+
+// pingResult is what /ping measured
+type pingResult struct {
+	Timing matrix.EventTiming
+	Timed  bool // Timing is known, false for messages that did not come from the sync
+
+	DispatchURL    string // webhook.ping_url, empty if not set
+	DispatchStatus string
+	DispatchErr    error
+	Dispatch       time.Duration
+
+	LastSync time.Time // Zero before the first sync
+	Replied  time.Time // When the report was written
+}
+
+// handlePing replies with how the message made its way through the service:
+// the sync lag, decryption, queueing and a dispatch to webhook.ping_url
+func (s *Server) handlePing(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, threadRootEventID id.EventID) {
+	result := pingResult{}
+	if s.matrix != nil {
+		result.Timing, result.Timed = s.matrix.EventTiming(eventID)
+		result.LastSync = s.matrix.SyncStatus().LastSync
+	}
+	if result.DispatchURL = s.cfg().Webhook.PingURL; result.DispatchURL != "" {
+		start := time.Now()
+		result.DispatchStatus, result.DispatchErr = s.webhook.Ping(ctx, result.DispatchURL)
+		result.Dispatch = time.Since(start)
+		if result.DispatchErr != nil {
+			s.logger.Ctx(ctx).Warn("Ping of %s failed: %v", result.DispatchURL, result.DispatchErr)
+		}
+	}
+	result.Replied = time.Now()
+	s.sendReply(ctx, s.pingReport(roomID, result), sender, threadRootEventID)
+}
+
+// pingReport formats the result of /ping
+func (s *Server) pingReport(roomID id.RoomID, r pingResult) string {
+	lines := []string{s.text(roomID, "ping.pong")}
+	add := func(key string, args ...interface{}) {
+		lines = append(lines, "- "+s.text(roomID, key, args...))
+	}
+	if r.Timed {
+		add("ping.sync_lag", formatLatency(r.Timing.SyncLag()))
+		if r.Timing.Encrypted {
+			add("ping.decryption", formatLatency(r.Timing.Decryption))
+		} else {
+			add("ping.not_encrypted")
+		}
+		add("ping.queue_wait", formatLatency(r.Timing.QueueWait()))
+	} else {
+		add("ping.not_timed")
+	}
+	switch {
+	case r.DispatchURL == "":
+		add("ping.dispatch_unset")
+	case r.DispatchErr != nil:
+		add("ping.dispatch_failed", formatLatency(r.Dispatch), r.DispatchErr)
+	default:
+		add("ping.dispatch", formatLatency(r.Dispatch), r.DispatchStatus)
+	}
+	if !r.LastSync.IsZero() {
+		add("ping.last_sync", formatLatency(r.Replied.Sub(r.LastSync)))
+	}
+	if r.Timed {
+		add("ping.total", formatLatency(r.Replied.Sub(r.Timing.Received)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatLatency rounds a duration to milliseconds, or microseconds below
+// one millisecond. Negative durations, e.g. from clock skew, are kept.
+func formatLatency(d time.Duration) string {
+	if d > -time.Millisecond && d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+func TestIsPingCommand(t *testing.T) {
+	for message, want := range map[string]bool{
+		"/ping":     true,
+		"  /ping  ": true,
+		"/ping me":  false,
+		"/pingpong": false,
+		"ping":      false,
+	} {
+		if got := isPingCommand(message); got != want {
+			t.Errorf("isPingCommand(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestPingReport(t *testing.T) {
+	s := &Server{config: &config.Config{}}
+	received := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	timed := pingResult{
+		Timing: matrix.EventTiming{
+			Sent:       received.Add(-120 * time.Millisecond),
+			Received:   received,
+			Handled:    received.Add(7 * time.Millisecond),
+			Encrypted:  true,
+			Decryption: 4 * time.Millisecond,
+		},
+		Timed:          true,
+		DispatchURL:    "http://noop.internal/ping",
+		DispatchStatus: "200 OK",
+		Dispatch:       35 * time.Millisecond,
+		LastSync:       received.Add(-2 * time.Second),
+		Replied:        received.Add(50 * time.Millisecond),
+	}
+
+	tests := []struct {
+		name   string
+		result pingResult
+		want   []string
+	}{
+		{
+			name:   "Encrypted",
+			result: timed,
+			want:   []string{"🏓 Pong", "- Sync lag: 120ms", "- Decryption: 4ms", "- Queue wait: 3ms", "ping endpoint: 35ms (200 OK)", "- Last sync: 2.05s ago", "- Receipt to reply: 50ms"},
+		},
+		{
+			name: "Failed dispatch",
+			result: func() pingResult {
+				r := timed
+				r.Timing.Encrypted = false
+				r.DispatchErr = errors.New("connection refused")
+				return r
+			}(),
+			want: []string{"not encrypted", "failed after 35ms: connection refused"},
+		},
+		{
+			name:   "Untimed",
+			result: pingResult{Replied: received},
+			want:   []string{"timing is unknown", "webhook.ping_url is not set"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := s.pingReport("!room:example.com", tt.result)
+			for _, want := range tt.want {
+				if !strings.Contains(report, want) {
+					t.Errorf("Report lacks %q:\n%s", want, report)
+				}
+			}
+			if !tt.result.Timed && strings.Contains(report, "Sync lag") {
+				t.Errorf("Report of an untimed message has a sync lag:\n%s", report)
+			}
+		})
+	}
+}
+
+func TestFormatLatency(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1234567 * time.Nanosecond: "1ms",
+		345678 * time.Nanosecond:  "346µs",
+		-80 * time.Millisecond:    "-80ms",
+		2500 * time.Millisecond:   "2.5s",
+	} {
+		if got := formatLatency(d); got != want {
+			t.Errorf("formatLatency(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+// ReadyResponse is returned by GET /ready. Checks maps each check (sync,
+// crypto, config) to "ok" or the reason it failed.
+type ReadyResponse struct {
+	Status string            `json:"status"` // "ready" or "not ready"
+	Checks map[string]string `json:"checks"`
+}
+
+// checkReadiness evaluates the readiness checks against the Matrix client state
+func checkReadiness(status matrix.SyncStatus, maxSyncAge time.Duration, now time.Time) ReadyResponse {
+	resp := ReadyResponse{Status: "ready", Checks: map[string]string{"config": "ok"}}
+
+	switch {
+	case !status.Running:
+		resp.Checks["sync"] = "sync loop has stopped"
+	case status.AppService:
+		// Transactions only arrive when there are events, a quiet room is
+		// not a stale connection
+		resp.Checks["sync"] = "ok"
+	case status.Stalled:
+		resp.Checks["sync"] = fmt.Sprintf("stalled, the watchdog restarted the sync loop %d times", status.Restarts)
+	case status.LastSync.IsZero():
+		resp.Checks["sync"] = "no sync completed yet"
+	case now.Sub(status.LastSync) > maxSyncAge:
+		resp.Checks["sync"] = fmt.Sprintf("last sync %s ago", now.Sub(status.LastSync).Round(time.Second))
+	default:
+		resp.Checks["sync"] = "ok"
+	}
+
+	switch {
+	case !status.EncryptionEnabled:
+		resp.Checks["crypto"] = "disabled"
+	case status.EncryptionError != nil:
+		resp.Checks["crypto"] = status.EncryptionError.Error()
+	default:
+		resp.Checks["crypto"] = "ok"
+	}
+
+	for _, result := range resp.Checks {
+		if result != "ok" && result != "disabled" {
+			resp.Status = "not ready"
+			break
+		}
+	}
+	return resp
+}
+
+// handleLive only reports that the process is serving HTTP, so a failing
+// Matrix connection does not get the pod restarted in a loop
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+// handleReady reports whether the service can currently deliver messages:
+// the Matrix sync loop is running and recent, and encryption (if enabled)
+// set up correctly
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	maxSyncAge := time.Duration(s.cfg().Server.ReadyMaxSyncAge) * time.Second
+	resp := checkReadiness(s.matrix.SyncStatus(), maxSyncAge, time.Now())
+
+	code := http.StatusOK
+	if resp.Status != "ready" {
+		s.logger.Warn("Readiness check failed: %v", resp.Checks)
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		status     matrix.SyncStatus
+		wantStatus string
+		wantSync   string
+		wantCrypto string
+	}{
+		{
+			name:       "Recent sync without encryption",
+			status:     matrix.SyncStatus{Running: true, LastSync: now.Add(-10 * time.Second)},
+			wantStatus: "ready",
+			wantSync:   "ok",
+			wantCrypto: "disabled",
+		},
+		{
+			name:       "Recent sync with encryption",
+			status:     matrix.SyncStatus{Running: true, LastSync: now, EncryptionEnabled: true},
+			wantStatus: "ready",
+			wantSync:   "ok",
+			wantCrypto: "ok",
+		},
+		{
+			name:       "No sync yet",
+			status:     matrix.SyncStatus{Running: true},
+			wantStatus: "not ready",
+			wantSync:   "no sync completed yet",
+			wantCrypto: "disabled",
+		},
+		{
+			name:       "Stale sync",
+			status:     matrix.SyncStatus{Running: true, LastSync: now.Add(-5 * time.Minute)},
+			wantStatus: "not ready",
+			wantSync:   "last sync 5m0s ago",
+			wantCrypto: "disabled",
+		},
+		{
+			name:       "Stalled sync being restarted",
+			status:     matrix.SyncStatus{Running: true, LastSync: now.Add(-10 * time.Minute), Stalled: true, Restarts: 2},
+			wantStatus: "not ready",
+			wantSync:   "stalled, the watchdog restarted the sync loop 2 times",
+			wantCrypto: "disabled",
+		},
+		{
+			name:       "Sync loop stopped",
+			status:     matrix.SyncStatus{LastSync: now},
+			wantStatus: "not ready",
+			wantSync:   "sync loop has stopped",
+			wantCrypto: "disabled",
+		},
+		{
+			name:       "Encryption failed",
+			status:     matrix.SyncStatus{Running: true, LastSync: now, EncryptionEnabled: true, EncryptionError: errors.New("bad recovery key")},
+			wantStatus: "not ready",
+			wantSync:   "ok",
+			wantCrypto: "bad recovery key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := checkReadiness(tt.status, 2*time.Minute, now)
+			if resp.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", resp.Status, tt.wantStatus)
+			}
+			if resp.Checks["sync"] != tt.wantSync {
+				t.Errorf("sync check = %q, want %q", resp.Checks["sync"], tt.wantSync)
+			}
+			if resp.Checks["crypto"] != tt.wantCrypto {
+				t.Errorf("crypto check = %q, want %q", resp.Checks["crypto"], tt.wantCrypto)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/mule-ai/mule/matrix-microservice/internal/push"
+	"maunium.net/go/mautrix/event"
+)
+
+// messageText returns the text of a message without the quote of the
+// message it replies to. The content is shared with other handlers, so it is
+// not modified.
+func messageText(content *event.MessageEventContent) string {
+	if content.RelatesTo.GetReplyTo() != "" {
+		return event.TrimReplyFallbackText(content.Body)
+	}
+	return content.Body
+}
+
+// mirrorToPush hands the messages of the rooms to the push notifier, which
+// sends those matching its rules. Edits are not mirrored.
+func (s *Server) mirrorToPush(evt *event.Event) {
+	if evt.Type != event.EventMessage {
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.Body == "" || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+
+	m := push.Message{
+		RoomID:  string(evt.RoomID),
+		Sender:  string(evt.Sender),
+		EventID: string(evt.ID),
+		Body:    messageText(content),
+	}
+	if content.Mentions != nil {
+		for _, userID := range content.Mentions.UserIDs {
+			m.Mentions = append(m.Mentions, string(userID))
+		}
+	}
+	s.push.Handle(m)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/push"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestMirrorToPush(t *testing.T) {
+	var sent []string
+	ntfy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent = append(sent, string(body))
+	}))
+	defer ntfy.Close()
+
+	cfg := &config.PushConfig{
+		Targets: map[string]config.PushTargetConfig{"phone": {Type: "ntfy", URL: ntfy.URL, Topic: "alerts"}},
+		Rules:   []config.PushRuleConfig{{Name: "oncall", Mentions: []string{"@oncall:example.com"}, Targets: []string{"phone"}}},
+		Timeout: 5,
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	notifier, err := push.New(cfg, log)
+	if err != nil {
+		t.Fatalf("push.New() error = %v", err)
+	}
+	s := &Server{logger: log, push: notifier}
+	notifier.Start()
+
+	// Mentioned with a pill, in a reply quoting the previous message
+	s.mirrorToPush(messageEvent("$1", "@alice:example.com", &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "> <@bob:example.com> disk full\n\nOn Call please look",
+		Mentions:  &event.Mentions{UserIDs: []id.UserID{"@oncall:example.com"}},
+		RelatesTo: (&event.RelatesTo{}).SetReplyTo("$0"),
+	}))
+	// Not mentioned
+	s.mirrorToPush(messageEvent("$2", "@alice:example.com", &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}))
+	// An edit of the first message
+	s.mirrorToPush(messageEvent("$3", "@alice:example.com", &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "* @oncall:example.com please look",
+		RelatesTo: (&event.RelatesTo{}).SetReplace("$1"),
+	}))
+	notifier.Stop()
+
+	if len(sent) != 1 || sent[0] != "On Call please look" {
+		t.Errorf("sent %q, want only %q", sent, "On Call please look")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"maunium.net/go/mautrix/id"
+)
+
+// quietSchedules are the compiled quiet hours: those of quiet_hours and
+// those of the rooms that replace them, by their own or their tenant's
+type quietSchedules struct {
+	global *quiet.Schedule
+	rooms  map[id.RoomID]*quiet.Schedule
+}
+
+// compileQuietHours parses the quiet hours of quiet_hours, the rooms and the
+// tenants
+func compileQuietHours(cfg *config.Config) (*quietSchedules, error) {
+	global, err := quiet.NewSchedule(&cfg.QuietHours)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours: %w", err)
+	}
+	schedules := &quietSchedules{global: global, rooms: make(map[id.RoomID]*quiet.Schedule)}
+	roomIDs := make([]string, 0, len(cfg.Rooms))
+	for _, room := range cfg.Rooms {
+		roomIDs = append(roomIDs, room.RoomID)
+	}
+	for _, tenant := range cfg.Tenants {
+		roomIDs = append(roomIDs, tenant.Rooms...)
+	}
+	for _, roomID := range roomIDs {
+		hours := cfg.QuietHoursFor(roomID)
+		if hours == &cfg.QuietHours {
+			continue
+		}
+		schedule, err := quiet.NewSchedule(hours)
+		if err != nil {
+			return nil, fmt.Errorf("quiet_hours of %s: %w", roomID, err)
+		}
+		schedules.rooms[id.RoomID(roomID)] = schedule
+	}
+	return schedules, nil
+}
+
+// of returns the quiet hours of a room, nil if it has none
+func (q *quietSchedules) of(roomID id.RoomID) *quiet.Schedule {
+	if q == nil {
+		return nil
+	}
+	if schedule, exists := q.rooms[roomID]; exists {
+		return schedule
+	}
+	return q.global
+}
+
+// quietSchedule returns the quiet hours of a room
+func (s *Server) quietSchedule(roomID id.RoomID) *quiet.Schedule {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.quietHours.of(roomID)
+}
+
+// inQuietHours reports whether a room is in quiet hours at t
+func (s *Server) inQuietHours(roomID string, t time.Time) bool {
+	return s.quietSchedule(id.RoomID(roomID)).Active(t)
+}
+
+// holdNotification holds a notification that is not a reply to a message
+// until the quiet hours of its room end, unless the room is not in quiet
+// hours or its source is critical. A notification without a room goes to
+// matrix.roomid. It reports whether the notification was held; one that
+// cannot be stored is posted right away.
+func (s *Server) holdNotification(ctx context.Context, n quiet.Notification) bool {
+	if s.quietQueue == nil {
+		return false
+	}
+	if n.RoomID == "" {
+		n.RoomID = s.cfg().Matrix.RoomID
+	}
+	if !s.quietSchedule(id.RoomID(n.RoomID)).Holds(n.Source, time.Now()) {
+		return false
+	}
+	if err := s.quietQueue.Hold(n); err != nil {
+		s.logger.Ctx(ctx).Warn("Posting %s notification during quiet hours, it could not be held: %v", n.Source, err)
+		return false
+	}
+	return true
+}
+
+// deliverHeld posts a notification held during quiet hours
+func (s *Server) deliverHeld(n quiet.Notification) error {
+	delivery := MessageRequest{RoomID: n.RoomID, Format: n.Format, MsgType: n.MsgType, ThreadRoot: n.ThreadRoot, InReplyTo: n.InReplyTo}
+	opts := delivery.sendOptions()
+	if n.Sender != "" {
+		opts = append(opts, matrix.WithSender(n.Sender))
+	}
+	_, err := s.matrix.SendMessage(n.Message, opts...)
+	return err
+}
+
+// writeHeldResponse answers a request whose message is held for quiet hours
+func writeHeldResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SendResponse{Status: "held"})
+}
+
+// writeQuietHoursMetrics writes how many notifications are held
+func writeQuietHoursMetrics(w io.Writer, held int) {
+	fmt.Fprintln(w, "# HELP matrix_quiet_hours_held_notifications Notifications held until the quiet hours of their room end.")
+	fmt.Fprintln(w, "# TYPE matrix_quiet_hours_held_notifications gauge")
+	fmt.Fprintf(w, "matrix_quiet_hours_held_notifications %d\n", held)
+}
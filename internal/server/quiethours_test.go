@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+)
+
+func TestCompileQuietHours(t *testing.T) {
+	always := &config.QuietHoursConfig{Ranges: []string{"00:00-23:59"}}
+	cfg := &config.Config{
+		QuietHours: config.QuietHoursConfig{Ranges: []string{"22:00-07:00"}},
+		Rooms: []config.RoomConfig{
+			{RoomID: "!own:example.com", QuietHours: always},
+			{RoomID: "!tenant:example.com"},
+			{RoomID: "!global:example.com"},
+		},
+		Tenants: []config.TenantConfig{{Name: "ops", Rooms: []string{"!tenant:example.com"}, QuietHours: &config.QuietHoursConfig{}}},
+	}
+	schedules, err := compileQuietHours(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedules.of("!own:example.com") == nil || schedules.of("!own:example.com") == schedules.global {
+		t.Error("Room with its own quiet hours uses the global ones")
+	}
+	if schedules.of("!tenant:example.com") != nil {
+		t.Error("Room of a tenant without quiet hours has quiet hours")
+	}
+	if schedules.of("!global:example.com") != schedules.global || schedules.of("!unlisted:example.com") != schedules.global {
+		t.Error("Rooms without quiet hours of their own do not use the global ones")
+	}
+}
+
+func TestNotificationHeldDuringQuietHours(t *testing.T) {
+	templates, err := compileNotifyTemplates(map[string]config.NotifyTemplateConfig{
+		"deploy": {Template: `{{ .service }} deployed`},
+		"outage": {Template: `{{ .service }} is down`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Matrix:     config.MatrixConfig{RoomID: "!ops:example.com"},
+		QuietHours: config.QuietHoursConfig{Ranges: []string{"00:00-23:59", "23:59-00:00"}, Critical: []string{"notify:outage"}},
+	}
+	schedules, err := compileQuietHours(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{config: cfg, logger: log, notifyTemplates: templates, quietHours: schedules}
+	var delivered []quiet.Notification
+	deliver := func(n quiet.Notification) error {
+		delivered = append(delivered, n)
+		return nil
+	}
+	if s.quietQueue, err = quiet.NewQueue(storage.NewMemory(), s.inQuietHours, deliver, log); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Post("/notify/{template}", s.handleNotify)
+	req := httptest.NewRequest(http.MethodPost, "/notify/deploy", strings.NewReader(`{"service": "api"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp SendResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusAccepted || resp.Status != "held" {
+		t.Errorf("POST /notify/deploy = %d %q, want 202 held", rec.Code, resp.Status)
+	}
+	if s.quietQueue.Len() != 1 {
+		t.Fatalf("%d notifications held, want 1", s.quietQueue.Len())
+	}
+
+	// A critical notification is posted right away
+	if s.holdNotification(context.Background(), quiet.Notification{Source: "notify:outage", Message: "api is down"}) {
+		t.Error("Critical notification was held")
+	}
+
+	// The quiet hours end with a reload
+	s.quietHours = &quietSchedules{}
+	s.quietQueue.Start()
+	s.quietQueue.Stop()
+	if len(delivered) != 1 || delivered[0].RoomID != "!ops:example.com" || delivered[0].Message != "api deployed" {
+		t.Errorf("Delivered %+v, want the held notification in matrix.roomid", delivered)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// rateLimiterSweepInterval is how often buckets of idle clients are dropped
+const rateLimiterSweepInterval = time.Minute
+
+// tokenBucket holds the requests a client may still make
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client
+type rateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// allow takes a token from the client's bucket, which refills at rate tokens
+// per second up to burst. If the bucket is empty it returns how long until
+// the next token is available.
+func (l *rateLimiter) allow(client string, rate float64, burst int) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now, rate, burst)
+	}
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that have refilled completely, since a new bucket
+// is equivalent
+func (l *rateLimiter) sweep(now time.Time, rate float64, burst int) {
+	l.lastSweep = now
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientIP identifies the client of a request. X-Forwarded-For is only
+// followed through trusted proxies, since clients can send any value: from
+// the peer address, each trusted hop is replaced by the entry it appended,
+// so the client is the rightmost entry that is not a trusted proxy.
+func clientIP(r *http.Request, trustedProxies ipAllowlist) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if len(trustedProxies) == 0 {
+		return client
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(client)
+		if err != nil || !trustedProxies.contains(ip) {
+			break
+		}
+		client = forwardedAddr(hops[i])
+	}
+	return client
+}
+
+// forwardedAddr strips the port some proxies add to X-Forwarded-For entries
+func forwardedAddr(hop string) string {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().String()
+	}
+	return hop
+}
+
+// clientIP identifies the client of a request with the trusted proxies of
+// the current config
+func (s *Server) clientIP(r *http.Request) string {
+	s.configMutex.RLock()
+	trustedProxies := s.trustedProxies
+	s.configMutex.RUnlock()
+	return clientIP(r, trustedProxies)
+}
+
+// rateLimit rejects clients that exceed server.rate_limit requests per second
+func (s *Server) rateLimit(limiter *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := &s.cfg().Server
+			if cfg.RateLimit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := s.clientIP(r)
+			if allowed, retryAfter := limiter.allow(client, cfg.RateLimit, max(cfg.RateLimitBurst, 1)); !allowed {
+				s.logger.Warn("Rate limiting %s %s from %s", r.Method, r.URL.Path, client)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitRequestBody rejects request bodies larger than maxSize bytes. The
+// limit is looked up per request so that config reloads apply.
+func (s *Server) limitRequestBody(maxSize func(cfg *config.Config) int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := maxSize(s.cfg())
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				s.logger.Warn("Rejecting %s %s with a %d byte body", r.Method, r.URL.Path, r.ContentLength)
+				http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// Bodies without a Content-Length fail to read past the limit
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	// A burst of 2 at 1 request per second
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.allow("10.0.0.1", 1, 2); !allowed {
+			t.Fatalf("request %d within burst was limited", i+1)
+		}
+	}
+	allowed, retryAfter := limiter.allow("10.0.0.1", 1, 2)
+	if allowed {
+		t.Fatal("request over burst was allowed")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("retry after = %v, want 1s", retryAfter)
+	}
+
+	// Other clients have their own bucket
+	if allowed, _ := limiter.allow("10.0.0.2", 1, 2); !allowed {
+		t.Error("request from another client was limited")
+	}
+
+	now = now.Add(time.Second)
+	if allowed, _ := limiter.allow("10.0.0.1", 1, 2); !allowed {
+		t.Error("request after refill was limited")
+	}
+
+	// Idle clients are forgotten once their bucket is full again
+	now = now.Add(rateLimiterSweepInterval)
+	limiter.allow("10.0.0.3", 1, 2)
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets after sweep, want 1", len(limiter.buckets))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := compileTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		proxies    ipAllowlist
+		want       string
+	}{
+		{"no trusted proxies", "192.0.2.1:51234", []string{"203.0.113.7"}, nil, "192.0.2.1"},
+		{"direct client", "198.51.100.4:51234", []string{"203.0.113.7"}, proxies, "198.51.100.4"},
+		{"one proxy", "192.0.2.1:51234", []string{"203.0.113.7"}, proxies, "203.0.113.7"},
+		{"proxy chain", "192.0.2.1:51234", []string{"203.0.113.7, 10.0.0.2"}, proxies, "203.0.113.7"},
+		// Entries left of the first untrusted hop were sent by the client
+		{"forged entry", "192.0.2.1:51234", []string{"127.0.0.1, 203.0.113.7, 10.0.0.2"}, proxies, "203.0.113.7"},
+		{"header per hop", "192.0.2.1:51234", []string{"127.0.0.1", "203.0.113.7", "10.0.0.2"}, proxies, "203.0.113.7"},
+		{"port", "192.0.2.1:51234", []string{"203.0.113.7:4711"}, proxies, "203.0.113.7"},
+		{"only proxies", "192.0.2.1:51234", []string{"10.0.0.3"}, proxies, "10.0.0.3"},
+		{"no header", "192.0.2.1:51234", nil, proxies, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/message", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := clientIP(req, tt.proxies); ip != tt.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tt.name, ip, tt.want)
+		}
+	}
+}
+
+func newLimitsTestServer(serverConfig config.ServerConfig) *Server {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Server: serverConfig}
+	s := &Server{config: cfg, router: chi.NewRouter(), logger: log, customHooks: map[string]*customHook{}}
+	s.routes()
+	return s
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	s := newLimitsTestServer(config.ServerConfig{RateLimit: 0.1, RateLimitBurst: 1})
+
+	// Unknown hooks respond 404 once they get past the limit
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hook/unknown", strings.NewReader("{}")))
+		return rec
+	}
+	if rec := post(); rec.Code != http.StatusNotFound {
+		t.Fatalf("first request status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec := post()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("Retry-After = %q, want 10", retryAfter)
+	}
+
+	// Endpoints that do not accept content are not limited
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /health status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimitIgnoresForgedForwardedFor(t *testing.T) {
+	s := newLimitsTestServer(config.ServerConfig{RateLimit: 0.1, RateLimitBurst: 1, TrustProxyHeaders: true, TrustedProxies: []string{"10.0.0.1"}})
+	s.trustedProxies, _ = compileTrustedProxies(s.config.Server.TrustedProxies)
+
+	// The client claims a new address each time, the proxy appends the real one
+	post := func(forged string) int {
+		req := httptest.NewRequest(http.MethodPost, "/hook/unknown", strings.NewReader("{}"))
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-For", forged+", 203.0.113.7")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("198.51.100.1"); code != http.StatusNotFound {
+		t.Fatalf("first request status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := post("198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("request with a forged address status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	s := newLimitsTestServer(config.ServerConfig{MaxBodySizeKB: 1, MediaMaxSizeMB: 1})
+
+	tests := []struct {
+		path string
+		size int
+		want int
+	}{
+		{"/message", 2 << 10, http.StatusRequestEntityTooLarge},
+		{"/hook/unknown", 2 << 10, http.StatusRequestEntityTooLarge},
+		{"/hook/unknown", 512, http.StatusNotFound},
+		{"/media", 3 << 20, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		body := `{"message": "` + strings.Repeat("x", tt.size) + `"}`
+		s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body)))
+		if rec.Code != tt.want {
+			t.Errorf("POST %s with %d bytes: status = %d, want %d", tt.path, len(body), rec.Code, tt.want)
+		}
+	}
+}
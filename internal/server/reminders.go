@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/remind"
+	"maunium.net/go/mautrix/id"
+)
+
+// isReminderCommand reports whether the message is a /remind or /reminders
+// command
+func isReminderCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && (fields[0] == "/remind" || fields[0] == "/reminders")
+}
+
+// handleReminderCommand sets, lists and cancels reminders:
+// /remind me in 2h to rotate the key
+// /remind @alice:example.com tomorrow 9:00 standup notes
+// /reminders [list]
+// /reminders cancel <id>
+func (s *Server) handleReminderCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, eventID id.EventID, message string, threadRootEventID id.EventID) {
+	fields := strings.Fields(message)
+	reply := func(text string) { s.sendReply(ctx, text, sender, threadRootEventID) }
+
+	if fields[0] == "/remind" {
+		req, err := s.reminders.Parse(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), "/remind")))
+		if err != nil {
+			reply(s.text(roomID, "reminders.invalid", err))
+			return
+		}
+		target := req.Target
+		if target == "me" {
+			target = string(sender)
+		}
+		r, err := s.reminders.Add(remind.Reminder{
+			RoomID:     string(roomID),
+			ThreadRoot: string(threadRootEventID),
+			EventID:    string(eventID),
+			SetBy:      string(sender),
+			Target:     target,
+			Text:       req.Text,
+			Due:        req.Due,
+		})
+		switch {
+		case errors.Is(err, remind.ErrTooMany):
+			reply(s.text(roomID, "reminders.too_many", s.cfg().Reminders.MaxPerUser))
+			return
+		case err != nil:
+			s.logger.Ctx(ctx).Error("Failed to set reminder: %v", err)
+			reply(s.text(roomID, "reminders.set_failed"))
+			return
+		}
+		if target != string(sender) {
+			reply(s.text(roomID, "reminders.set_other", target, s.formatDue(r), r.ID, r.ID))
+		} else {
+			reply(s.text(roomID, "reminders.set_self", s.formatDue(r), r.ID, r.ID))
+		}
+		return
+	}
+
+	switch {
+	case len(fields) == 1 || (len(fields) == 2 && fields[1] == "list"):
+		list := s.reminders.List(string(roomID), string(sender))
+		if len(list) == 0 {
+			reply(s.text(roomID, "reminders.none"))
+			return
+		}
+		var b strings.Builder
+		b.WriteString(s.text(roomID, "reminders.list") + "\n")
+		for _, r := range list {
+			fmt.Fprintf(&b, "- %d: %s", r.ID, s.formatDue(r))
+			if r.Target != string(sender) {
+				b.WriteString(" " + s.text(roomID, "reminders.list_for", r.Target))
+			} else if r.SetBy != string(sender) {
+				b.WriteString(" " + s.text(roomID, "reminders.list_set_by", r.SetBy))
+			}
+			fmt.Fprintf(&b, ": %s\n", r.Text)
+		}
+		reply(b.String())
+	case len(fields) == 3 && fields[1] == "cancel":
+		reminderID, err := strconv.Atoi(strings.TrimPrefix(fields[2], "#"))
+		if err != nil {
+			reply(s.text(roomID, "reminders.invalid_id", fields[2]))
+			return
+		}
+		r, ok := s.reminders.Get(reminderID)
+		if !ok || r.RoomID != string(roomID) {
+			reply(s.text(roomID, "reminders.not_found", reminderID))
+			return
+		}
+		if r.SetBy != string(sender) && r.Target != string(sender) && !s.isAdminUser(sender) {
+			s.logger.Ctx(ctx).Warn("User %s may not cancel reminder %d of %s", sender, reminderID, r.SetBy)
+			reply(s.text(roomID, "reminders.cancel_denied"))
+			return
+		}
+		if _, err := s.reminders.Cancel(reminderID); err != nil {
+			s.logger.Ctx(ctx).Error("Failed to cancel reminder %d: %v", reminderID, err)
+			reply(s.text(roomID, "reminders.cancel_failed", reminderID))
+			return
+		}
+		reply(s.text(roomID, "reminders.cancelled", reminderID))
+	default:
+		reply(s.text(roomID, "reminders.usage"))
+	}
+}
+
+// formatDue formats when a reminder is due in the time zone of reminders
+func (s *Server) formatDue(r remind.Reminder) string {
+	return r.Due.In(s.reminders.Location()).Format(remind.TimeLayout)
+}
+
+// reminderMessage is the message posted when a reminder is due
+func (s *Server) reminderMessage(r remind.Reminder) string {
+	roomID := id.RoomID(r.RoomID)
+	if r.SetBy != r.Target {
+		return s.text(roomID, "reminders.due_from", r.Target, r.SetBy, r.Text)
+	}
+	return s.text(roomID, "reminders.due", r.Target, r.Text)
+}
+
+// postReminder posts a reminder that is due, mentioning its target, in the
+// thread it was set in or as a reply to the message that set it
+func (s *Server) postReminder(r remind.Reminder) {
+	opts := []matrix.SendMessageOption{matrix.WithRoom(id.RoomID(r.RoomID)), matrix.WithMention(id.UserID(r.Target))}
+	switch {
+	case r.ThreadRoot != "":
+		opts = append(opts, matrix.WithThread(id.EventID(r.ThreadRoot)))
+	case r.EventID != "":
+		opts = append(opts, matrix.WithReplyTo(id.EventID(r.EventID)))
+	}
+	if _, err := s.matrix.SendMessage(s.reminderMessage(r), opts...); err != nil {
+		s.logger.Error("Failed to post reminder %d: %v", r.ID, err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/remind"
+)
+
+func TestIsReminderCommand(t *testing.T) {
+	for message, want := range map[string]bool{
+		"/remind me in 2h x":  true,
+		"/reminders":          true,
+		"/reminders cancel 3": true,
+		"/reminder":           false,
+		"remind me":           false,
+		"":                    false,
+	} {
+		if got := isReminderCommand(message); got != want {
+			t.Errorf("isReminderCommand(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestReminderMessage(t *testing.T) {
+	cfg := &config.Config{
+		I18n:  config.I18nConfig{Language: "en"},
+		Rooms: []config.RoomConfig{{RoomID: "!de:example.com", Language: "de"}},
+	}
+	s := &Server{config: cfg, rooms: compileRooms(cfg.Rooms)}
+
+	own := remind.Reminder{RoomID: "!ops:example.com", SetBy: "@bob:example.com", Target: "@bob:example.com", Text: "rotate the key"}
+	if got, want := s.reminderMessage(own), "Reminder for @bob:example.com: rotate the key"; got != want {
+		t.Errorf("reminderMessage = %q, want %q", got, want)
+	}
+	other := remind.Reminder{RoomID: "!ops:example.com", SetBy: "@bob:example.com", Target: "@alice:example.com", Text: "standup"}
+	if got, want := s.reminderMessage(other), "Reminder for @alice:example.com from @bob:example.com: standup"; got != want {
+		t.Errorf("reminderMessage = %q, want %q", got, want)
+	}
+	// Rooms with a language of their own get the reminder in it
+	other.RoomID = "!de:example.com"
+	if got, want := s.reminderMessage(other), "Erinnerung für @alice:example.com von @bob:example.com: standup"; got != want {
+		t.Errorf("reminderMessage = %q, want %q", got, want)
+	}
+}
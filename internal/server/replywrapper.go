@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+// replyWrapper holds the compiled reply_prefix and reply_suffix of a room
+type replyWrapper struct {
+	prefix *template.Template // nil unless reply_prefix is set
+	suffix *template.Template // nil unless reply_suffix is set
+}
+
+// replyWrapperData is the data of reply_prefix and reply_suffix templates
+type replyWrapperData struct {
+	Sender  string // Whom the reply answers
+	RoomID  string // Room the reply is sent to
+	Version string // Version of the service
+}
+
+// compileReplyWrappers parses the reply_prefix and reply_suffix templates of
+// the rooms, keyed by room
+func compileReplyWrappers(rooms []config.RoomConfig) (map[id.RoomID]*replyWrapper, error) {
+	wrappers := make(map[id.RoomID]*replyWrapper)
+	for i, room := range rooms {
+		if room.ReplyPrefix == "" && room.ReplySuffix == "" {
+			continue
+		}
+		wrapper := &replyWrapper{}
+		var err error
+		if wrapper.prefix, err = compileReplyWrapperTemplate(fmt.Sprintf("rooms[%d].reply_prefix", i), room.ReplyPrefix); err != nil {
+			return nil, err
+		}
+		if wrapper.suffix, err = compileReplyWrapperTemplate(fmt.Sprintf("rooms[%d].reply_suffix", i), room.ReplySuffix); err != nil {
+			return nil, err
+		}
+		wrappers[id.RoomID(room.RoomID)] = wrapper
+	}
+	return wrappers, nil
+}
+
+// compileReplyWrapperTemplate parses one wrapper template, nil if it is empty
+func compileReplyWrapperTemplate(setting, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tpl, err := template.New(setting).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", setting, err)
+	}
+	return tpl, nil
+}
+
+// wrapOutput puts the reply_prefix and reply_suffix of the reply room of ctx
+// around backend output. A template that fails is left out and logged.
+func (s *Server) wrapOutput(ctx context.Context, sender id.UserID, message string) string {
+	roomID := replyRoom(ctx)
+	if roomID == "" {
+		roomID = id.RoomID(s.cfg().Matrix.RoomID)
+	}
+	s.configMutex.RLock()
+	wrapper := s.replyWrappers[roomID]
+	s.configMutex.RUnlock()
+	if wrapper == nil {
+		return message
+	}
+
+	data := replyWrapperData{Sender: string(sender), RoomID: string(roomID), Version: buildinfo.Get().Version}
+	return s.renderReplyWrapper(ctx, wrapper.prefix, data) + message + s.renderReplyWrapper(ctx, wrapper.suffix, data)
+}
+
+// renderReplyWrapper renders a wrapper template, empty if it is not set or
+// fails
+func (s *Server) renderReplyWrapper(ctx context.Context, tpl *template.Template, data replyWrapperData) string {
+	if tpl == nil {
+		return ""
+	}
+	var b strings.Builder
+	if err := tpl.Execute(&b, data); err != nil {
+		s.logger.Ctx(ctx).Warn("Failed to render %s, leaving it out: %v", tpl.Name(), err)
+		return ""
+	}
+	return b.String()
+}
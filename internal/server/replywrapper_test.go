@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestWrapOutput(t *testing.T) {
+	cfg := &config.Config{
+		Matrix: config.MatrixConfig{RoomID: "!main:example.com"},
+		Rooms: []config.RoomConfig{
+			{RoomID: "!main:example.com", ReplyPrefix: "[staging] "},
+			{RoomID: "!ops:example.com", ReplySuffix: "\n\n— for {{.Sender}} in {{.RoomID}}"},
+			{RoomID: "!broken:example.com", ReplyPrefix: "{{.Sender.Nope}}", ReplySuffix: " (end)"},
+			{RoomID: "!plain:example.com"},
+		},
+	}
+	compiled, err := compileConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{config: cfg, logger: log, replyWrappers: compiled.replyWrappers}
+
+	tests := []struct {
+		room string
+		want string
+	}{
+		{"", "[staging] deployed"},
+		{"!main:example.com", "[staging] deployed"},
+		{"!ops:example.com", "deployed\n\n— for @alice:example.com in !ops:example.com"},
+		{"!broken:example.com", "deployed (end)"},
+		{"!plain:example.com", "deployed"},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.room != "" {
+			ctx = withReplyRoom(ctx, id.RoomID(tt.room))
+		}
+		if got := s.wrapOutput(ctx, "@alice:example.com", "deployed"); got != tt.want {
+			t.Errorf("wrapOutput() in %q = %q, want %q", tt.room, got, tt.want)
+		}
+	}
+}
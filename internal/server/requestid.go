@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+// requestID tags each request with the caller's X-Request-ID, or a new one if
+// it is missing or invalid, and echoes it in the response. A valid W3C
+// traceparent of the caller, with its baggage, is kept for the webhook
+// dispatches the request causes.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.Header.Get(requestid.Header)
+		if !requestid.Valid(reqID) {
+			reqID = requestid.New()
+		}
+		w.Header().Set(requestid.Header, reqID)
+
+		ctx := requestid.NewContext(r.Context(), reqID)
+		if trace, ok := requestid.ParseTraceparent(r.Header.Get(requestid.TraceparentHeader)); ok {
+			trace.Baggage = r.Header.Get(requestid.BaggageHeader)
+			ctx = requestid.WithTrace(ctx, trace)
+		}
+		// Also shown in the access log written by chi's logger
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, reqID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withRequestID tags a Matrix send with the ID of the request causing it
+func withRequestID(r *http.Request) matrix.SendMessageOption {
+	return matrix.WithRequestID(requestid.FromContext(r.Context()))
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestid.FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"accepted", "deploy-42", true},
+		{"invalid replaced", "has spaces", false},
+		{"too long replaced", strings.Repeat("x", 200), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/message", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestid.Header, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if seen == "" || rec.Header().Get(requestid.Header) != seen {
+				t.Fatalf("context ID %q, response header %q", seen, rec.Header().Get(requestid.Header))
+			}
+			if (seen == tt.incoming) != tt.keep {
+				t.Errorf("request ID = %q for incoming %q", seen, tt.incoming)
+			}
+		})
+	}
+}
+
+func TestHandleMessagePropagatesRequestID(t *testing.T) {
+	headers := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(requestid.Header)
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Webhook: config.WebhookConfig{Default: target.URL, Template: `{"message": "{{.MESSAGE}}"}`}}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log)}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "hello", "", "", "$event")
+
+	if header := <-headers; !requestid.Valid(header) {
+		t.Errorf("webhook dispatch carried request ID %q", header)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"later version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"short span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, valid := requestid.ParseTraceparent(tt.value); valid != tt.valid {
+				t.Errorf("ParseTraceparent(%q) valid = %v, want %v", tt.value, valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDispatchTraceHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.WebhookConfig{Default: target.URL, Template: `{"message": "{{.MESSAGE}}"}`, UserAgent: "ops-bot/1.0", PropagateTrace: true}
+	dispatcher := webhook.New(cfg, nil, log)
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	// A traceparent sent to the API is continued in a new span
+	var ctx context.Context
+	handler := requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/message", nil)
+	req.Header.Set(requestid.TraceparentHeader, incoming)
+	req.Header.Set(requestid.BaggageHeader, "tenant=ops")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if _, err := dispatcher.Dispatch(ctx, "hello", ""); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	got := <-headers
+	if got.Get("User-Agent") != "ops-bot/1.0" {
+		t.Errorf("User-Agent = %q", got.Get("User-Agent"))
+	}
+	trace, ok := requestid.ParseTraceparent(got.Get(requestid.TraceparentHeader))
+	if !ok || trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.SpanID == "00f067aa0ba902b7" || trace.Flags != "01" {
+		t.Errorf("traceparent = %q, want a new span of %q", got.Get(requestid.TraceparentHeader), incoming)
+	}
+	if got.Get(requestid.BaggageHeader) != "tenant=ops" {
+		t.Errorf("baggage = %q, want tenant=ops", got.Get(requestid.BaggageHeader))
+	}
+
+	// Without one, the trace ID is the request ID
+	reqID := requestid.New()
+	if _, err := dispatcher.Dispatch(requestid.NewContext(context.Background(), reqID), "hello", ""); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	got = <-headers
+	if trace, ok := requestid.ParseTraceparent(got.Get(requestid.TraceparentHeader)); !ok || trace.TraceID != reqID {
+		t.Errorf("traceparent = %q, want trace ID %s", got.Get(requestid.TraceparentHeader), reqID)
+	}
+
+	cfg.PropagateTrace = false
+	if _, err := dispatcher.Dispatch(ctx, "hello", ""); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	got = <-headers
+	if got.Get(requestid.TraceparentHeader) != "" || got.Get(requestid.BaggageHeader) != "" {
+		t.Errorf("trace headers sent with propagation off: %v", got)
+	}
+}
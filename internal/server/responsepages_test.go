@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestDispatchFollowsResponsePages(t *testing.T) {
+	var requests []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = r.URL.Query().Get("after")
+		}
+		switch page {
+		case "":
+			fmt.Fprint(w, `{"title": "Open issues", "data": {"items": ["a", "b"]}, "next": "/hook?page=2", "cursor": "c2"}`)
+		case "2", "c2":
+			fmt.Fprint(w, `{"data": {"items": ["c"]}, "next": "/hook?page=3", "cursor": "c3"}`)
+		default:
+			fmt.Fprint(w, `{"data": {"items": ["d"]}, "next": null, "cursor": null}`)
+		}
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	tests := []struct {
+		name      string
+		pages     config.ResponsePagesConfig
+		want      string
+		wantPages int
+		truncated bool
+		requests  []string
+	}{
+		{
+			name:      "Next page URL",
+			pages:     config.ResponsePagesConfig{Items: ".data.items", Next: ".next"},
+			want:      "Open issues: a,b,c,d",
+			wantPages: 3,
+			requests:  []string{"POST /hook secret", "GET /hook?page=2 secret", "GET /hook?page=3 secret"},
+		},
+		{
+			name:      "Cursor",
+			pages:     config.ResponsePagesConfig{Items: ".data.items", Cursor: ".cursor", CursorParam: "after"},
+			want:      "Open issues: a,b,c,d",
+			wantPages: 3,
+			requests:  []string{"POST /hook secret", "POST /hook?after=c2 secret", "POST /hook?after=c3 secret"},
+		},
+		{
+			name:      "Limited",
+			pages:     config.ResponsePagesConfig{Items: ".data.items", Next: ".next", MaxPages: 2},
+			want:      "Open issues: a,b,c",
+			wantPages: 2,
+			truncated: true,
+			requests:  []string{"POST /hook secret", "GET /hook?page=2 secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			cfg := &config.WebhookConfig{
+				Commands:      map[string]string{"issues": target.URL + "/hook"},
+				Template:      `{"message": "{{.MESSAGE}}"}`,
+				AuthTokens:    map[string]string{"issues": "secret"},
+				JQSelector:    `.title + ": " + (.data.items | join(","))`,
+				Timeout:       5,
+				ResponsePages: map[string]config.ResponsePagesConfig{"issues": tt.pages},
+			}
+			ctx, pages := webhook.NewPageCountContext(context.Background())
+			reply, err := webhook.New(cfg, nil, log).Dispatch(ctx, "/issues", "issues")
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if reply != tt.want {
+				t.Errorf("Dispatch() = %q, want %q", reply, tt.want)
+			}
+			if pages.Pages != tt.wantPages || pages.Truncated != tt.truncated {
+				t.Errorf("PageCount = %+v, want %d pages, truncated %v", *pages, tt.wantPages, tt.truncated)
+			}
+			if fmt.Sprint(requests) != fmt.Sprint(tt.requests) {
+				t.Errorf("Requests = %q, want %q", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDispatchResponsePagesFailure(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			http.Error(w, "gone", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"items": [1], "next": "/page/2"}`)
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.WebhookConfig{
+		Default:              target.URL,
+		Template:             `{"message": "{{.MESSAGE}}"}`,
+		JQSelector:           ".items | length",
+		Timeout:              5,
+		DefaultResponsePages: config.ResponsePagesConfig{Items: ".items", Next: ".next"},
+	}
+	_, err := webhook.New(cfg, nil, log).Dispatch(context.Background(), "count", "")
+	if err == nil || !webhook.Transient(err) {
+		t.Errorf("Dispatch() error = %v, want a transient error of page 2", err)
+	}
+}
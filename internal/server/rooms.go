@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+// compileRooms indexes the per-room settings by room ID
+func compileRooms(rooms []config.RoomConfig) map[id.RoomID]*config.RoomConfig {
+	byID := make(map[id.RoomID]*config.RoomConfig, len(rooms))
+	for i := range rooms {
+		byID[id.RoomID(rooms[i].RoomID)] = &rooms[i]
+	}
+	return byID
+}
+
+// commandsEnabledAnywhere reports whether commands may run in any room, in
+// which case command templates are validated against the allowlist
+func commandsEnabledAnywhere(cfg *config.Config) bool {
+	if cfg.Webhook.EnableCommands {
+		return true
+	}
+	for _, room := range cfg.Rooms {
+		if room.EnableCommands != nil && *room.EnableCommands {
+			return true
+		}
+	}
+	return false
+}
+
+// roomSettings returns the settings of a room, nil if the room has no
+// overrides
+func (s *Server) roomSettings(roomID id.RoomID) *config.RoomConfig {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.rooms[roomID]
+}
+
+// commandsEnabled reports whether messages in the room may run commands
+func commandsEnabled(room *config.RoomConfig, cfg *config.Config) bool {
+	if room != nil && room.EnableCommands != nil {
+		return *room.EnableCommands
+	}
+	return cfg.Webhook.EnableCommands
+}
+
+// sessionOwnershipEnforced reports whether only session owners and users
+// they shared with may run commands in the room's sessions
+func sessionOwnershipEnforced(room *config.RoomConfig, cfg *config.Config) bool {
+	if room != nil && room.EnforceSessionOwnership != nil {
+		return *room.EnforceSessionOwnership
+	}
+	return cfg.Webhook.EnforceSessionOwnership
+}
+
+// defaultCommand returns the command template for messages without a known
+// command in the room. Rooms of a tenant fall back to the tenant's, never to
+// the global one.
+func defaultCommand(roomID id.RoomID, room *config.RoomConfig, cfg *config.Config) string {
+	if room != nil && room.DefaultCommand != "" {
+		return room.DefaultCommand
+	}
+	return cfg.WebhookFor(string(roomID)).DefaultCommand
+}
+
+type replyRoomKey struct{}
+
+// withReplyRoom makes replies sent with ctx go to the room the message came
+// from
+func withReplyRoom(ctx context.Context, roomID id.RoomID) context.Context {
+	return context.WithValue(ctx, replyRoomKey{}, roomID)
+}
+
+// replyRoom returns the room replies go to, empty for matrix.roomid
+func replyRoom(ctx context.Context) id.RoomID {
+	roomID, _ := ctx.Value(replyRoomKey{}).(id.RoomID)
+	return roomID
+}
+
+type replySenderKey struct{}
+
+// withReplySender makes replies sent with ctx come from the virtual user of
+// command in appservice mode
+func withReplySender(ctx context.Context, command string) context.Context {
+	return context.WithValue(ctx, replySenderKey{}, command)
+}
+
+// replySender returns the command whose virtual user sends replies, empty
+// for the bot
+func replySender(ctx context.Context) string {
+	command, _ := ctx.Value(replySenderKey{}).(string)
+	return command
+}
+
+// text returns the message key of the catalogs in the language of roomID,
+// formatted with args. An empty roomID is matrix.roomid.
+func (s *Server) text(roomID id.RoomID, key string, args ...interface{}) string {
+	cfg := s.cfg()
+	if roomID == "" {
+		roomID = id.RoomID(cfg.Matrix.RoomID)
+	}
+	language := cfg.I18n.Language
+	if room := s.roomSettings(roomID); room != nil && room.Language != "" {
+		language = room.Language
+	}
+	return s.catalogs.Text(language, key, args...)
+}
+
+// configuredLanguages returns the languages of i18n.language and of the
+// rooms
+func configuredLanguages(cfg *config.Config) []string {
+	var languages []string
+	if cfg.I18n.Language != "" {
+		languages = append(languages, cfg.I18n.Language)
+	}
+	for _, room := range cfg.Rooms {
+		if room.Language != "" {
+			languages = append(languages, room.Language)
+		}
+	}
+	return languages
+}
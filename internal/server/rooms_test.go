@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRoomSettings(t *testing.T) {
+	enabled, disabled := true, false
+	cfg := validTestConfig()
+	cfg.Webhook.DefaultCommand = "echo {{.MESSAGE}}"
+	cfg.Webhook.CommandTemplates = map[string]string{"deploy": "echo deploy", "status": "echo status"}
+	cfg.Rooms = []config.RoomConfig{
+		{RoomID: "!ops:example.com", EnableCommands: &enabled, EnforceSessionOwnership: &enabled, DefaultCommand: "echo ops {{.MESSAGE}}", Commands: []string{"deploy"}},
+		{RoomID: "!chat:example.com", EnableCommands: &disabled, AllowedUsers: []string{"@alice:example.com"}},
+	}
+	s := newAdminTestServer(t, cfg)
+
+	ops := s.roomSettings("!ops:example.com")
+	chat := s.roomSettings("!chat:example.com")
+	if main := s.roomSettings(id.RoomID(cfg.Matrix.RoomID)); main != nil {
+		t.Fatalf("room without overrides has settings %+v", main)
+	}
+
+	if !commandsEnabled(ops, cfg) || commandsEnabled(chat, cfg) || commandsEnabled(nil, cfg) {
+		t.Error("enable_commands is not overridden per room")
+	}
+	if !sessionOwnershipEnforced(ops, cfg) || sessionOwnershipEnforced(chat, cfg) {
+		t.Error("enforce_session_ownership is not overridden per room")
+	}
+	if got := defaultCommand("!ops:example.com", ops, cfg); got != "echo ops {{.MESSAGE}}" {
+		t.Errorf("default command of ops = %q", got)
+	}
+	if got := defaultCommand("!chat:example.com", chat, cfg); got != cfg.Webhook.DefaultCommand {
+		t.Errorf("default command of chat = %q, want the global one", got)
+	}
+
+	if !ops.AllowsCommand("deploy") || ops.AllowsCommand("status") || !chat.AllowsCommand("status") {
+		t.Error("commands are not limited per room")
+	}
+	if !chat.AllowsUser("@alice:example.com") || chat.AllowsUser("@bob:example.com") || !ops.AllowsUser("@bob:example.com") {
+		t.Error("allowed_users is not applied per room")
+	}
+	if !commandsEnabledAnywhere(cfg) {
+		t.Error("commands enabled in a room are not validated")
+	}
+}
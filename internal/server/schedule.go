@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"github.com/mule-ai/mule/matrix-microservice/internal/schedule"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+// validateScheduleJobs checks the cron expressions and delivery settings of
+// the scheduled jobs
+func validateScheduleJobs(jobs []config.ScheduledJobConfig) error {
+	for _, job := range jobs {
+		if _, err := schedule.Parse(job.Cron); err != nil {
+			return fmt.Errorf("schedule job %s: %w", job.Name, err)
+		}
+		delivery := MessageRequest{RoomID: job.RoomID, Format: job.Format, MsgType: job.MsgType}
+		if err := delivery.validate(); err != nil {
+			return fmt.Errorf("schedule job %s: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// runScheduledJob posts the message of a job, or the reply of the webhook
+// it is dispatched to
+func (s *Server) runScheduledJob(ctx context.Context, job config.ScheduledJobConfig, scheduled time.Time) {
+	ctx = requestid.NewContext(ctx, requestid.New())
+	ctx = logger.NewContext(ctx, "job", job.Name)
+	log := s.logger.Ctx(ctx)
+
+	message := job.Message
+	if job.Command != "" {
+		// A run made up after a restart is dispatched once
+		ctx := webhook.NewIdempotencyContext(ctx, webhook.IdempotencyKey("schedule/"+job.Name+"/"+scheduled.UTC().Format(time.RFC3339)))
+		reply, err := s.webhook.Dispatch(ctx, job.Message, job.Command)
+		if errors.Is(err, webhook.ErrAlreadyDelivered) {
+			log.Info("Scheduled job %s (due %s) was already dispatched", job.Name, scheduled.Format(time.RFC3339))
+			return
+		}
+		if err != nil {
+			log.Error("Scheduled job %s (due %s) failed to dispatch webhook: %v", job.Name, scheduled.Format(time.RFC3339), err)
+			return
+		}
+		if reply == "" {
+			log.Info("Scheduled job %s got no reply to post", job.Name)
+			return
+		}
+		message = reply
+	}
+
+	if s.holdNotification(ctx, quiet.Notification{Source: "schedule:" + job.Name, RoomID: job.RoomID, Message: message, Format: job.Format, MsgType: job.MsgType}) {
+		return
+	}
+	delivery := MessageRequest{RoomID: job.RoomID, Format: job.Format, MsgType: job.MsgType}
+	opts := append(delivery.sendOptions(), matrix.WithLogContext(ctx))
+	if _, err := s.matrix.SendMessage(message, opts...); err != nil {
+		log.Error("Scheduled job %s failed to post: %v", job.Name, err)
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestValidateScheduleJobs(t *testing.T) {
+	valid := config.ScheduledJobConfig{Name: "standup", Cron: "0 9 * * mon-fri", RoomID: "!team:example.com", Message: "Standup", MsgType: "notice"}
+	if err := validateScheduleJobs([]config.ScheduledJobConfig{valid}); err != nil {
+		t.Fatalf("valid job rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(job *config.ScheduledJobConfig)
+		want   string
+	}{
+		{"cron", func(job *config.ScheduledJobConfig) { job.Cron = "0 25 * * *" }, "hour"},
+		{"room", func(job *config.ScheduledJobConfig) { job.RoomID = "team" }, "invalid room_id"},
+		{"format", func(job *config.ScheduledJobConfig) { job.Format = "rst" }, "invalid format"},
+		{"msgtype", func(job *config.ScheduledJobConfig) { job.MsgType = "m.emote" }, "invalid msgtype"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := valid
+			tt.modify(&job)
+			err := validateScheduleJobs([]config.ScheduledJobConfig{job})
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "standup") {
+				t.Errorf("error = %v, want one about %q of job standup", err, tt.want)
+			}
+		})
+	}
+}
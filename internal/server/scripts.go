@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/script"
+	"maunium.net/go/mautrix/id"
+)
+
+// hookScripts are the compiled Lua scripts of the webhook settings. The
+// payload script is run by the webhook dispatcher.
+type hookScripts struct {
+	route *script.Script // nil unless webhook.route_script is set
+	reply *script.Script // nil unless webhook.reply_script is set
+}
+
+func compileHookScripts(cfg *config.WebhookConfig) (hookScripts, error) {
+	timeout := time.Duration(cfg.ScriptTimeout) * time.Millisecond
+	route, err := script.Compile("webhook.route_script", cfg.RouteScript, timeout)
+	if err != nil {
+		return hookScripts{}, err
+	}
+	reply, err := script.Compile("webhook.reply_script", cfg.ReplyScript, timeout)
+	if err != nil {
+		return hookScripts{}, err
+	}
+	return hookScripts{route: route, reply: reply}, nil
+}
+
+func (s *Server) hookScripts() hookScripts {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.scripts
+}
+
+// routeCommand returns the command the route script picks for a message, or
+// command if there is no script, it returns nil or it fails
+func (s *Server) routeCommand(ctx context.Context, roomID id.RoomID, sender id.UserID, message, command string) string {
+	route := s.hookScripts().route
+	if route == nil {
+		return command
+	}
+	routed, ok, err := route.Run(ctx, map[string]string{"message": message, "command": command, "sender": string(sender), "room_id": string(roomID)})
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Route script failed, keeping command %q: %v", command, err)
+		return command
+	}
+	if !ok {
+		return command
+	}
+	if routed != command {
+		s.logger.Ctx(ctx).Info("Route script routed the message to command %q", routed)
+	}
+	return routed
+}
+
+// formatReply returns the reply as rewritten by the reply script, "" if the
+// script drops it. The reply is kept if there is no script, it returns nil
+// or it fails.
+func (s *Server) formatReply(ctx context.Context, roomID id.RoomID, sender id.UserID, reply string) string {
+	format := s.hookScripts().reply
+	if format == nil {
+		return reply
+	}
+	formatted, ok, err := format.Run(ctx, map[string]string{"reply": reply, "sender": string(sender), "room_id": string(roomID)})
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Reply script failed, sending the reply unchanged: %v", err)
+		return reply
+	}
+	if !ok {
+		return reply
+	}
+	if formatted == "" {
+		s.logger.Ctx(ctx).Info("Reply dropped by reply script")
+	}
+	return formatted
+}
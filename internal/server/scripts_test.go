@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHandleMessageRunsScripts(t *testing.T) {
+	received := make(chan string, 1)
+	incident := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer incident.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Webhook: config.WebhookConfig{
+		Default:       "http://127.0.0.1:1/unused",
+		Commands:      map[string]string{"incident": incident.URL},
+		Template:      `{"message": "{{.MESSAGE}}"}`,
+		RouteScript:   `if message:find("outage") then return "incident" end`,
+		PayloadScript: `return payload:sub(1, -2) .. ', "command": "' .. command .. '"}'`,
+		ScriptTimeout: 100,
+	}}
+	compiled, err := compileConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), scripts: compiled.scripts}
+
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "db outage", "", "", "$event")
+	select {
+	case body := <-received:
+		if want := `{"message": "db outage", "command": "incident"}`; body != want {
+			t.Errorf("payload = %s, want %s", body, want)
+		}
+	default:
+		t.Fatal("message was not routed to the incident webhook")
+	}
+}
+
+func TestFormatReply(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.WebhookConfig{
+		ReplyScript: `
+if reply:find("^DEBUG") then return "" end
+if room_id == "!ops:example.com" then return "[ops] " .. reply end`,
+		ScriptTimeout: 100,
+	}
+	scripts, err := compileHookScripts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{logger: log, scripts: scripts}
+	ctx := context.Background()
+
+	tests := []struct {
+		room  id.RoomID
+		reply string
+		want  string
+	}{
+		{"!ops:example.com", "deployed", "[ops] deployed"},
+		{"!dev:example.com", "deployed", "deployed"},
+		{"!ops:example.com", "DEBUG trace", ""},
+	}
+	for _, tt := range tests {
+		if got := s.formatReply(ctx, tt.room, "@user:example.com", tt.reply); got != tt.want {
+			t.Errorf("formatReply(%s, %q) = %q, want %q", tt.room, tt.reply, got, tt.want)
+		}
+	}
+}
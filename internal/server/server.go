@@ -1,94 +1,450 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mule-ai/mule/matrix-microservice/internal/archive"
+	"github.com/mule-ai/mule/matrix-microservice/internal/audit"
+	"github.com/mule-ai/mule/matrix-microservice/internal/buildinfo"
 	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/email"
+	"github.com/mule-ai/mule/matrix-microservice/internal/feed"
+	"github.com/mule-ai/mule/matrix-microservice/internal/homeassistant"
+	"github.com/mule-ai/mule/matrix-microservice/internal/i18n"
+	"github.com/mule-ai/mule/matrix-microservice/internal/jira"
+	"github.com/mule-ai/mule/matrix-microservice/internal/llm"
 	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
 	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/memory"
+	"github.com/mule-ai/mule/matrix-microservice/internal/pagerduty"
+	"github.com/mule-ai/mule/matrix-microservice/internal/push"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"github.com/mule-ai/mule/matrix-microservice/internal/remind"
+	"github.com/mule-ai/mule/matrix-microservice/internal/render"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"github.com/mule-ai/mule/matrix-microservice/internal/schedule"
 	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
+	"github.com/mule-ai/mule/matrix-microservice/internal/telegram"
+	"github.com/mule-ai/mule/matrix-microservice/internal/translate"
+	"github.com/mule-ai/mule/matrix-microservice/internal/vision"
+	"github.com/mule-ai/mule/matrix-microservice/internal/wasm"
 	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"github.com/mule-ai/mule/matrix-microservice/plugin"
 	"maunium.net/go/mautrix/id"
 )
 
 type Server struct {
-	config     *config.Config
+	config     *config.Config // Replaced by Reload, read through cfg()
+	baseConfig *config.Config // config before registered commands were merged in
 	router     *chi.Mux
 	matrix     *matrix.Client
 	httpServer *http.Server
 	logger     *logger.Logger
 	webhook    *webhook.Dispatcher
 	sessionMgr *session.Manager
+	// Groups of rooms with their own webhooks, sessions and rate limits,
+	// set up at startup
+	tenants     []*tenant
+	tenantRooms map[id.RoomID]*tenant
+	// Compiled output post-processors keyed by command name ("" is the default)
+	outputPipelines map[string]*session.OutputPipeline
+	// Template rendering Alertmanager notifications
+	alertmanagerTemplate *template.Template
+	// Config-defined inbound hooks keyed by name
+	customHooks map[string]*customHook
+	// Templates served at /notify/{template} keyed by name
+	notifyTemplates map[string]*notifyTemplate
+	// Templates of error replies keyed by subsystem ("" is the default)
+	errorTemplates map[string]*template.Template
+	// Templates of lifecycle_notices keyed by event
+	lifecycleTemplates map[string]*template.Template
+	// reply_prefix and reply_suffix of the rooms that set them
+	replyWrappers map[id.RoomID]*replyWrapper
+	// Unencrypted rooms warned about or tried to enable encryption in
+	plaintextRooms plaintextRooms
+	// Compiled inbound_transforms, read through inboundTransforms()
+	transforms []*inboundTransform
+	// Messages dropped by each rule of inbound_transforms
+	transformDrops transformDrops
+	// Networks allowed to call each route group, open if absent
+	ipAllowlists map[string]ipAllowlist
+	// Proxies whose X-Forwarded-For is believed, nil unless
+	// server.trust_proxy_headers is set
+	trustedProxies ipAllowlist
+	// Per-room overrides keyed by room ID
+	rooms     map[id.RoomID]*config.RoomConfig
+	startedAt time.Time
+
+	// Guards config and the compiled state derived from it
+	configMutex sync.RWMutex
+	// Loads the config file on Reload
+	loadConfig func() (*config.Config, error)
+	// Set while message handling is paused by the admin API
+	paused atomic.Bool
+	// Fans out room activity to stream consumers, nil unless stream.enabled
+	stream *streamHub
+	// Keeps the state of feeds, the schedule, reminders, the mailbox and
+	// registered commands
+	store storage.Store
+	// Remembers handled events, dispatches and hook deliveries
+	dedup *dedup.Deduplicator
+	// Commands registered at runtime, nil unless webhook.command_store is set
+	commands *commandStore
+	// Records handled commands, nil unless audit.dir is set
+	auditLog *audit.Log
+	// Writes handled messages and replies to object storage, nil unless
+	// archive.enabled
+	archiver *archive.Archiver
+	// Handler and transformer plugins, nil unless plugins.dir is set
+	plugins *plugin.Host
+	// Sandboxed message transforms, nil unless wasm.modules is set
+	wasm *wasm.Host
+	// Route and reply scripts, read through hookScripts()
+	scripts hookScripts
+	// Answers messages with a chat completions API, nil unless llm.enabled
+	llm *llm.Client
+	// Streams answers of Ollama models, nil unless ollama.enabled
+	ollama *llm.Ollama
+	// Posts new feed entries, nil unless feeds.enabled
+	feeds *feed.Poller
+	// Renders feed entries, nil unless feeds.enabled
+	feedTemplate *template.Template
+	// Runs schedule.jobs, nil unless any are configured
+	scheduler *schedule.Scheduler
+	// Pending reminders, nil unless reminders.enabled
+	reminders *remind.Store
+	// Compiled quiet hours, read through quietSchedule()
+	quietHours *quietSchedules
+	// Notifications held during quiet hours
+	quietQueue *quiet.Queue
+	// Values of /set, nil unless memory.enabled
+	memory *memory.Store
+	// Messages of the bot's own replies in each language
+	catalogs *i18n.Catalogs
+	// Emails sent with /email and /email/{template} keyed by name
+	emailTemplates map[string]*emailTemplate
+	// Posts new emails of email.imap, nil unless it is enabled
+	emailPoller *email.Poller
+	// Renders inbound emails, nil unless email.imap.enabled
+	inboundEmailTemplate *template.Template
+	// Relays the room with a Telegram chat, nil unless telegram.enabled
+	telegram      *telegram.Relay
+	telegramLinks *telegramLinks
+	// Mirrors messages to ntfy and Pushover, nil unless push.rules are set
+	push *push.Notifier
+	// Pages with /page and updates incidents on reactions, nil unless
+	// pagerduty.services are set or hooks.pagerduty is enabled
+	pagerDuty          *pagerduty.Client
+	pagerDutyIncidents *pagerDutyIncidents
+	// Serves /jira and expands issue keys, nil unless jira.enabled
+	jira           *jira.Client
+	jiraExpansions *jiraExpansions
+	// Serves /ha and posts watched changes, nil unless homeassistant.enabled
+	homeAssistant        *homeassistant.Client
+	homeAssistantSetup   *homeAssistantSetup
+	homeAssistantWatcher *homeassistant.Watcher // nil without watches
+	// Serves /translate and auto_translate, nil unless translate.enabled
+	translator *translate.Client
+	// Reads images posted in the rooms, nil unless vision.enabled
+	vision         *vision.Client
+	visionTemplate *template.Template
+	visionImages   sync.Map // IDs of images mentioning the bot, read by the vision endpoint
+	// Replies whose reactions are forwarded, nil unless feedback.enabled
+	feedback *feedbackReplies
+	// Replies with pages left to post, nil unless pagination.enabled
+	pages *pagedReplies
+	// Commands listed by /catchup, awaiting confirmation
+	catchups pendingCatchups
+	// Messages of confirmations.commands, awaiting confirmation
+	confirmations pendingConfirmations
+}
+
+// cfg returns the current configuration. The returned config is never
+// modified; Reload replaces it with a new one.
+func (s *Server) cfg() *config.Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.config
 }
 
 // Implement the matrix.MessageHandler interface
 func (s *Server) HandleMessage(roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) {
-	s.logger.Info("Processing Matrix message from %s: %s (inReplyTo: %s, threadRoot: %s, eventID: %s)", sender, message, inReplyToEventID, threadRootEventID, eventID)
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring message %s from %s", eventID, sender)
+		return
+	}
+	if s.duplicateEvent(eventID) {
+		s.logger.Info("Skipping message %s from %s, it was handled before", eventID, sender)
+		return
+	}
+
+	if s.stream != nil {
+		s.stream.publish(newMessageStreamEvent(roomID, sender, message, inReplyToEventID, threadRootEventID, eventID))
+	}
+	if _, isImage := s.visionImages.LoadAndDelete(eventID); isImage {
+		return
+	}
+
+	s.processMessage(context.Background(), roomID, sender, message, inReplyToEventID, threadRootEventID, eventID)
+}
+
+// processMessage runs the built-in commands, plugins, commands and webhooks
+// for a message. Replies are also relayed to where ctx says the message came
+// from, such as Telegram.
+func (s *Server) processMessage(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) {
+	// Held messages are processed again as received once confirmed
+	origin, received := ctx, message
+	room := s.roomSettings(roomID)
+	if !room.AllowsUser(string(sender)) {
+		s.logger.Info("Ignoring message %s from %s, who is not in the allowed users of room %s", eventID, sender, roomID)
+		return
+	}
+	tenant := s.tenantOf(roomID)
+	if !allowTenantMessage(tenant) {
+		s.logger.Warn("Dropping message %s from %s, tenant %s exceeds its rate limit", eventID, sender, tenant.config.Name)
+		return
+	}
+	// Rewrite the message before anything looks at it
+	transformed, droppedBy := transformMessage(s.inboundTransforms(), roomID, message, inReplyToEventID != "")
+	if droppedBy != "" {
+		s.logger.Info("Dropping message %s from %s by rule %s of inbound_transforms", eventID, sender, droppedBy)
+		s.transformDrops.add(droppedBy)
+		return
+	}
+	if transformed != message {
+		s.logger.Debug("Message %s rewritten by inbound_transforms: %s", eventID, s.logger.Message(transformed))
+		message = transformed
+	}
+
+	// Trace the webhook dispatches and replies caused by this message, and
+	// reply in the room it was sent in. The message is dispatched once, even
+	// if the sync delivers it again.
+	ctx = requestid.NewContext(ctx, requestid.New())
+	if eventID != "" {
+		ctx = webhook.NewIdempotencyContext(ctx, webhook.IdempotencyKey(string(eventID)))
+	}
+	ctx = webhook.NewOriginContext(ctx, string(roomID), string(sender))
+	ctx = withReplyRoom(ctx, roomID)
+	ctx = withReplySender(ctx, leadingCommand(message))
+	ctx = logger.NewContext(ctx, "room_id", string(roomID), "event_id", string(eventID), "sender", string(sender))
+	if tenant != nil {
+		ctx = logger.NewContext(ctx, "tenant", tenant.config.Name)
+	}
+	log := s.logger.Ctx(ctx)
+	log.Info("Processing Matrix message from %s in %s: %s (inReplyTo: %s, threadRoot: %s, eventID: %s)", sender, roomID, log.Message(message), inReplyToEventID, threadRootEventID, eventID)
+	if !confirmed(origin) {
+		s.archiveMessage(ctx, roomID, sender, message, inReplyToEventID, threadRootEventID, eventID)
+	}
+	if s.handleConfirmationReply(ctx, roomID, sender, message, inReplyToEventID, threadRootEventID) {
+		return
+	}
+
+	// Permissions apply to every command, also those of the service
+	if !s.commandPermitted(ctx, roomID, sender, eventID, audit.KindCommand, leadingCommand(message), message, threadRootEventID) {
+		return
+	}
+	// Dangerous commands wait for a confirmation
+	if s.holdForConfirmation(ctx, origin, roomID, sender, s.confirmationCommand(message), received, inReplyToEventID, threadRootEventID, eventID) {
+		return
+	}
+
+	// Command registration is handled before dispatching so that registered
+	// commands cannot shadow it
+	if isAdminCommand(message) {
+		s.handleAdminCommand(ctx, sender, message, threadRootEventID)
+		return
+	}
+	if isPingCommand(message) {
+		s.handlePing(ctx, roomID, sender, eventID, threadRootEventID)
+		return
+	}
+	if isCatchupCommand(message) {
+		s.handleCatchupCommand(ctx, roomID, sender, message, threadRootEventID)
+		return
+	}
+	if s.feeds != nil && isFeedCommand(message) {
+		s.handleFeedCommand(ctx, roomID, sender, message, threadRootEventID)
+		return
+	}
+	if s.reminders != nil && isReminderCommand(message) {
+		s.handleReminderCommand(ctx, roomID, sender, eventID, message, threadRootEventID)
+		return
+	}
+	if s.memory != nil && isMemoryCommand(message) {
+		s.handleMemoryCommand(ctx, roomID, sender, message, threadRootEventID)
+		return
+	}
+	ctx = webhook.NewMemoryContext(ctx, s.memoryValues(roomID, sender))
+	if len(s.cfg().Email.Templates) > 0 && isEmailCommand(message) {
+		s.handleEmailCommand(ctx, roomID, sender, message, threadRootEventID)
+		return
+	}
+	if s.pagerDuty != nil && len(s.cfg().PagerDuty.Services) > 0 && isPageCommand(message) {
+		s.handlePageCommand(ctx, roomID, sender, eventID, message, threadRootEventID)
+		return
+	}
+	if s.jira != nil && isJiraCommand(message) {
+		s.handleJiraCommand(ctx, roomID, sender, message, threadRootEventID)
+		return
+	}
+	if s.homeAssistant != nil && isHomeAssistantCommand(message) {
+		s.handleHomeAssistantCommand(ctx, sender, message, threadRootEventID)
+		return
+	}
+	if s.translator != nil && isTranslateCommand(message) {
+		s.handleTranslateCommand(ctx, sender, eventID, message, threadRootEventID)
+		return
+	}
+
+	// Session sharing and export are handled before command execution since
+	// an empty command prefix would otherwise treat them as command arguments
+	enableCommands := commandsEnabled(room, s.cfg())
+	if enableCommands && isShareCommand(message) {
+		s.handleShare(ctx, roomID, sender, message, inReplyToEventID, threadRootEventID)
+		return
+	}
+	if enableCommands && isExportCommand(message) {
+		s.handleExport(ctx, roomID, sender, message, inReplyToEventID, threadRootEventID)
+		return
+	}
+
+	// WASM modules and plugins may answer or drop the message or change how
+	// it is routed
+	var pluginCommand string
+	if s.wasm != nil {
+		result := s.wasm.HandleMessage(ctx, wasm.Message{RoomID: string(roomID), Sender: string(sender), EventID: string(eventID), ThreadRoot: string(threadRootEventID), Command: leadingCommand(message), Body: message})
+		if result.Drop {
+			return
+		}
+		if result.Handled {
+			if result.Reply != "" {
+				s.sendOutput(ctx, result.Reply, sender, threadRootEventID)
+			}
+			return
+		}
+		if result.Body != "" {
+			message = result.Body
+		}
+		pluginCommand = result.Command
+	}
+	if s.plugins != nil {
+		result := s.plugins.HandleMessage(ctx, plugin.Message{RoomID: string(roomID), Sender: string(sender), EventID: string(eventID), ThreadRoot: string(threadRootEventID), Body: message})
+		if result.Handled {
+			if result.Reply != "" {
+				s.sendOutput(ctx, result.Reply, sender, threadRootEventID)
+			}
+			return
+		}
+		if result.Body != "" {
+			message = result.Body
+		}
+		if result.Command != "" {
+			pluginCommand = result.Command
+		}
+	}
 
 	// Check if command execution is enabled
-	if s.config.Webhook.EnableCommands && s.webhook.HasCommandPrefix(message) {
+	if enableCommands && s.webhook.HasCommandPrefix(message) {
 		// Command execution mode
-		s.handleCommandExecution(sender, message, inReplyToEventID, threadRootEventID, eventID)
+		s.handleCommandExecution(ctx, roomID, sender, message, inReplyToEventID, threadRootEventID, eventID)
 		return
 	}
 
-	// Extract command from message
+	// Extract command from message. Commands not available in the room go
+	// to the default webhook.
 	command := s.webhook.ExtractCommand(message)
+	if pluginCommand != "" {
+		command = pluginCommand
+	}
+	command = s.routeCommand(ctx, roomID, sender, message, command)
+	if command != "" && !room.AllowsCommand(command) {
+		log.Info("Command %s is not available in room %s, using the default webhook", command, roomID)
+		command = ""
+	}
+	// Plugins and route scripts may have chosen another command
+	if !s.commandPermitted(ctx, roomID, sender, eventID, audit.KindWebhook, command, message, threadRootEventID) {
+		return
+	}
+
+	if s.llmHandles(command) {
+		s.handleLLMMessage(ctx, roomID, sender, eventID, threadRootEventID, message, command)
+		return
+	}
+	if model := s.ollamaModel(command); model != "" {
+		s.handleOllamaMessage(ctx, roomID, sender, eventID, threadRootEventID, message, command, model)
+		return
+	}
 
 	// Dispatch to webhook
-	reply, err := s.webhook.Dispatch(message, command)
+	var opts []webhook.DispatchOption
+	if tenant != nil {
+		opts = append(opts, webhook.WithTenant(tenant.config))
+	}
+	if room != nil {
+		opts = append(opts, webhook.WithRoomDefaults(&room.Webhook))
+	}
+	record := s.auditCommand(ctx, roomID, sender, eventID, audit.KindWebhook, command, message)
+	ctx, pages := webhook.NewPageCountContext(ctx)
+	reply, err := s.dispatchWithRetry(ctx, roomID, sender, threadRootEventID, message, command, opts...)
+	if errors.Is(err, webhook.ErrAlreadyDelivered) {
+		record.done(s.webhook.WebhookURL(command, opts...), audit.StatusDuplicate, nil)
+		log.Info("Message %s was already dispatched, skipping it", eventID)
+		return
+	}
+	record.done(s.webhook.WebhookURL(command, opts...), resultStatus(err), err)
 	if err != nil {
-		s.logger.Error("Failed to dispatch webhook: %v", err)
+		log.Error("Failed to dispatch webhook: %v", err)
 		return
 	}
 
 	// Send reply back to Matrix if not empty
 	if reply != "" {
-		s.logger.Info("Sending webhook reply to Matrix: %s", reply)
-		// Only use threadRootEventID for reply - do NOT fall back to inReplyToEventID
-		// as that can persist from previous messages and cause replies to go to wrong thread
-		replyEventID := threadRootEventID
-
-		// Send with reply and mention if we have thread info
-		if replyEventID != "" {
-			if err := s.matrix.SendMessage(reply, matrix.WithReplyTo(replyEventID), matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send reply to Matrix: %v", err)
-			}
-		} else {
-			// For non-reply messages, still mention the sender
-			if err := s.matrix.SendMessage(reply, matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send reply to Matrix: %v", err)
-			}
+		reply = s.renderOutput(command, reply)
+		if pages.Truncated {
+			reply += "\n\n" + s.text(roomID, "webhook.pages_truncated", pages.Pages)
 		}
+		log.Info("Sending webhook reply to Matrix: %s", log.Message(reply))
+		// Only use threadRootEventID for reply - do NOT fall back to inReplyToEventID
+		// as that can persist from previous messages and cause replies to go to wrong thread.
+		// The sender is mentioned either way.
+		replyID := s.sendOutput(ctx, reply, sender, threadRootEventID)
+		s.recordFeedbackReply(replyID, feedbackReply{Command: feedbackCommand(command, feedbackDefaultCommand), Message: message, MessageEventID: eventID, Sender: sender, Reply: reply})
 	} else {
-		s.logger.Debug("No reply to send to Matrix")
+		log.Debug("No reply to send to Matrix")
 	}
 }
 
 // handleCommandExecution processes command messages and executes them
-func (s *Server) handleCommandExecution(sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) {
-	s.logger.Info("Handling command execution for message from %s", sender)
+func (s *Server) handleCommandExecution(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) {
+	log := s.logger.Ctx(ctx)
+	log.Info("Handling command execution for message from %s", sender)
 
 	// Extract command name and arguments from the message
 	cmdName, args := s.webhook.GetCommandFromPrefix(message)
-	s.logger.Info("Extracted command: %s, args: %s", cmdName, args)
+	log.Info("Extracted command: %s, args: %s", cmdName, log.Message(args))
+	if !s.commandPermitted(ctx, roomID, sender, eventID, audit.KindExec, cmdName, args, threadRootEventID) {
+		return
+	}
+	record := s.auditCommand(ctx, roomID, sender, eventID, audit.KindExec, cmdName, args)
 
 	// Determine the session key
-	// If this is a reply (inReplyToEventID is set), find any existing session for this user
-	// This allows continuing a conversation when replying to the bot's message
+	sessions := s.sessionsFor(roomID)
 	var sessionThreadRoot id.EventID
-	
-	if inReplyToEventID != "" && len(inReplyToEventID) > 0 && string(inReplyToEventID)[0] == '$' {
-		// This is a reply - find any existing session for this user
-		existingSession := s.sessionMgr.GetSessionForUser(sender)
-		if existingSession != nil {
-			s.logger.Info("Found existing session for reply, continuing session: %s", existingSession.ID)
-			sessionThreadRoot = id.EventID(existingSession.ID)
-		}
+	if existingSession := s.findExistingSession(ctx, sessions, sender, inReplyToEventID, threadRootEventID); existingSession != nil {
+		sessionThreadRoot = id.EventID(existingSession.ID)
 	}
 
 	// If no existing session found (or not a reply), create new session with event ID
@@ -102,81 +458,324 @@ func (s *Server) handleCommandExecution(sender id.UserID, message string, inRepl
 		replyEventID = inReplyToEventID
 	}
 
+	// Refuse to run commands in someone else's session unless it was shared
+	room := s.roomSettings(roomID)
+	if sessionOwnershipEnforced(room, s.cfg()) {
+		if existing := sessions.GetSession(sessionThreadRoot, sender); existing != nil && !sessions.CanUseSession(existing, sender) {
+			log.Warn("Rejecting command from %s in session %s owned by %s", sender, existing.ID, existing.UserID)
+			s.sendReply(ctx, s.text(roomID, "session.not_owner", existing.UserID, sender), sender, replyEventID)
+			record.done("", audit.StatusRejected, fmt.Errorf("session %s is owned by %s", existing.ID, existing.UserID))
+			return
+		}
+	}
+
 	// Get command template - first try command-specific template, then default
 	commandTemplate := ""
 	if cmdName != "" {
-		if tpl, exists := s.config.Webhook.CommandTemplates[cmdName]; exists {
+		if tpl, exists := s.cfg().WebhookFor(string(roomID)).CommandTemplates[cmdName]; exists {
+			if !room.AllowsCommand(cmdName) {
+				log.Warn("Rejecting command %s from %s, which is not available in room %s", cmdName, sender, roomID)
+				s.sendReply(ctx, s.text(roomID, "command.not_in_room", cmdName), sender, replyEventID)
+				record.done(tpl, audit.StatusRejected, fmt.Errorf("not available in room %s", roomID))
+				return
+			}
 			commandTemplate = tpl
-			s.logger.Info("Using command-specific template for: %s", cmdName)
+			log.Info("Using command-specific template for: %s", cmdName)
 		}
 	}
 	if commandTemplate == "" {
-		commandTemplate = s.config.Webhook.DefaultCommand
-		s.logger.Info("Using default command template: %s", commandTemplate)
+		commandTemplate = defaultCommand(roomID, room, s.cfg())
+		log.Info("Using default command template: %s", commandTemplate)
 	}
 
 	// If no command template configured, return error
 	if commandTemplate == "" {
-		errorMsg := "No command template configured. Please set default_command or command_templates in config."
-		s.logger.Error(errorMsg)
-		if replyEventID != "" {
-			if err := s.matrix.SendMessage(errorMsg, matrix.WithReplyTo(replyEventID), matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send error message: %v", err)
-			}
-		} else {
-			if err := s.matrix.SendMessage(errorMsg, matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send error message: %v", err)
-			}
-		}
+		log.Error("No command template configured")
+		s.sendReply(ctx, s.text(roomID, "command.no_template"), sender, replyEventID)
+		record.done("", audit.StatusFailed, errors.New("no command template configured"))
 		return
 	}
 
 	// Get or create session - passing empty threadRootEventID will cause the session manager
 	// to use userID as the session key, ensuring all messages from same user share context
-	session := s.sessionMgr.GetOrCreateSession(sessionThreadRoot, sender, commandTemplate)
-	s.logger.Debug("Session retrieved/created: key=%s, userID=%s, command=%s", session.ID, session.UserID, session.Command)
+	sess := sessions.GetOrCreateSession(sessionThreadRoot, sender, commandTemplate)
+	ctx = logger.NewContext(ctx, "session", sess.ID)
+	log = s.logger.Ctx(ctx)
+	log.Debug("Session retrieved/created: key=%s, userID=%s, command=%s", sess.ID, sess.UserID, sess.Command)
 
-	// Execute the command
-	reply, err := s.sessionMgr.ExecuteCommand(session, args)
-	if err != nil {
-		errorMsg := fmt.Sprintf("Command execution failed: %v", err)
-		s.logger.Error(errorMsg)
-		if replyEventID != "" {
-			if err := s.matrix.SendMessage(errorMsg, matrix.WithReplyTo(replyEventID), matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send error message: %v", err)
-			}
+	dryRun := s.cfg().Webhook.IsDryRun(cmdName)
+	if dryRun {
+		log.Info("Dry-run enabled for command %q, command will not be executed", cmdName)
+	}
+
+	// Queue the command; it runs once all earlier commands in the session have finished
+	ahead, err := sessions.QueueCommand(sess, args, func(reply string, err error) {
+		// The command template is recorded, the rendered command line would
+		// reveal the arguments
+		if dryRun && err == nil {
+			record.done(commandTemplate, audit.StatusDryRun, nil)
 		} else {
-			if err := s.matrix.SendMessage(errorMsg, matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send error message: %v", err)
+			record.done(commandTemplate, resultStatus(err), err)
+		}
+		if err != nil {
+			log.Error("Command execution failed: %v", err)
+			var timeout *session.TimeoutError
+			if errors.As(err, &timeout) {
+				s.sendReply(ctx, s.text(roomID, "command.timeout", timeout.Timeout), sender, replyEventID)
+			} else {
+				s.sendReply(ctx, s.text(roomID, "command.failed", err), sender, replyEventID)
 			}
+			return
+		}
+
+		if dryRun {
+			s.sendReply(ctx, s.text(roomID, "command.dry_run", reply), sender, replyEventID)
+			return
+		}
+
+		reply = s.renderOutput(cmdName, s.postProcessOutput(cmdName, reply))
+
+		// Send the reply
+		if reply != "" {
+			log.Info("Sending command output to Matrix (length: %d)", len(reply))
+			replyID := s.sendOutput(ctx, reply, sender, replyEventID)
+			s.recordFeedbackReply(replyID, feedbackReply{Command: cmdName, Message: message, MessageEventID: eventID, Sender: sender, Reply: reply})
+		} else {
+			log.Info("Command executed successfully but produced no output")
 		}
+	},
+		session.WithSender(sender),
+		session.WithRoom(roomID),
+		session.WithThread(threadRootEventID),
+		session.WithEventID(eventID),
+		session.WithDryRun(dryRun),
+		session.WithMemory(s.memoryValues(roomID, sender)),
+		session.WithLogContext(ctx))
+	if err != nil {
+		log.Error("Failed to queue command: %v", err)
+		record.done(commandTemplate, audit.StatusFailed, err)
+		s.sendReply(ctx, s.text(roomID, "queue.full", ahead), sender, replyEventID)
 		return
 	}
 
-	// Send the reply
-	if reply != "" {
-		s.logger.Info("Sending command output to Matrix (length: %d)", len(reply))
-		if replyEventID != "" {
-			if err := s.matrix.SendMessage(reply, matrix.WithReplyTo(replyEventID), matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send command output: %v", err)
-			}
-		} else {
-			if err := s.matrix.SendMessage(reply, matrix.WithMention(sender)); err != nil {
-				s.logger.Error("Failed to send command output: %v", err)
-			}
+	if ahead > 0 {
+		s.sendReply(ctx, s.queuedNotice(roomID, ahead), sender, replyEventID)
+	}
+}
+
+// findExistingSession returns the session a message continues, if any.
+// A message in a thread continues the session started by the thread root;
+// otherwise a reply continues the sender's most recent session.
+func (s *Server) findExistingSession(ctx context.Context, sessions *session.Manager, sender id.UserID, inReplyToEventID id.EventID, threadRootEventID id.EventID) *session.Session {
+	if threadRootEventID != "" {
+		if existingSession := sessions.GetSession(threadRootEventID, sender); existingSession != nil {
+			s.logger.Ctx(ctx).Info("Found existing session for thread, continuing session: %s", existingSession.ID)
+			return existingSession
+		}
+	}
+
+	// If this is a reply (inReplyToEventID is set), find any existing session for this user
+	// This allows continuing a conversation when replying to the bot's message
+	if inReplyToEventID != "" && string(inReplyToEventID)[0] == '$' {
+		if existingSession := sessions.GetSessionForUser(sender); existingSession != nil {
+			s.logger.Ctx(ctx).Info("Found existing session for reply, continuing session: %s", existingSession.ID)
+			return existingSession
 		}
+	}
+
+	return nil
+}
+
+// isShareCommand reports whether the message is a /share command
+func isShareCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/share"
+}
+
+// handleShare lets a session owner invite other users into their session:
+// /share @alice:example.com [@bob:example.com ...]
+func (s *Server) handleShare(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID) {
+	replyEventID := threadRootEventID
+	if replyEventID == "" {
+		replyEventID = inReplyToEventID
+	}
+
+	sessions := s.sessionsFor(roomID)
+	existingSession := s.findExistingSession(ctx, sessions, sender, inReplyToEventID, threadRootEventID)
+	if existingSession == nil {
+		s.sendReply(ctx, s.text(roomID, "share.no_session"), sender, replyEventID)
+		return
+	}
+	if existingSession.UserID != sender {
+		s.logger.Ctx(ctx).Warn("User %s tried to share session %s owned by %s", sender, existingSession.ID, existingSession.UserID)
+		s.sendReply(ctx, s.text(roomID, "share.not_owner", existingSession.UserID), sender, replyEventID)
+		return
+	}
+
+	var shared []string
+	for _, field := range strings.Fields(message)[1:] {
+		userID := id.UserID(field)
+		if _, _, err := userID.Parse(); err != nil {
+			s.logger.Ctx(ctx).Debug("Ignoring invalid user ID in /share: %s", field)
+			continue
+		}
+		sessions.ShareSession(existingSession, userID)
+		shared = append(shared, field)
+	}
+
+	if len(shared) == 0 {
+		s.sendReply(ctx, s.text(roomID, "share.usage"), sender, replyEventID)
+		return
+	}
+	s.sendReply(ctx, s.text(roomID, "share.done", strings.Join(shared, ", ")), sender, replyEventID)
+}
+
+// postProcessOutput applies the command's output pipeline (or the default
+// one). If processing fails the raw output is returned.
+func (s *Server) postProcessOutput(cmdName string, output string) string {
+	s.configMutex.RLock()
+	pipeline, exists := s.outputPipelines[cmdName]
+	if !exists {
+		pipeline = s.outputPipelines[""]
+	}
+	s.configMutex.RUnlock()
+
+	processed, err := pipeline.Apply(output)
+	if err != nil {
+		s.logger.Warn("Failed to post-process output of command %q, posting raw output: %v", cmdName, err)
+		return output
+	}
+	return processed
+}
+
+// renderOutput renders the reply of a command as webhook.render (or
+// webhook.default_render) says
+func (s *Server) renderOutput(cmdName string, output string) string {
+	cfg := s.cfg().Webhook
+	hint, exists := cfg.Render[cmdName]
+	if !exists {
+		hint = cfg.DefaultRender
+	}
+	return render.Render(output, hint)
+}
+
+// validateRenderHints checks webhook.render and webhook.default_render
+func validateRenderHints(cfg *config.WebhookConfig) error {
+	if !render.ValidHint(cfg.DefaultRender) {
+		return fmt.Errorf("default_render: %q is not one of auto, table, code, code:<language> or none", cfg.DefaultRender)
+	}
+	for name, hint := range cfg.Render {
+		if !render.ValidHint(hint) {
+			return fmt.Errorf("render.%s: %q is not one of auto, table, code, code:<language> or none", name, hint)
+		}
+	}
+	return nil
+}
+
+// compileOutputPipelines compiles the configured output post-processors,
+// keyed by command name with the default pipeline under ""
+func compileOutputPipelines(cfg *config.WebhookConfig) (map[string]*session.OutputPipeline, error) {
+	pipelines := make(map[string]*session.OutputPipeline)
+
+	pipeline, err := session.NewOutputPipeline(cfg.DefaultOutputProcessors)
+	if err != nil {
+		return nil, fmt.Errorf("default_output_processors: %w", err)
+	}
+	pipelines[""] = pipeline
+
+	for name, steps := range cfg.OutputProcessors {
+		pipeline, err := session.NewOutputPipeline(steps)
+		if err != nil {
+			return nil, fmt.Errorf("output_processors.%s: %w", name, err)
+		}
+		pipelines[name] = pipeline
+	}
+	return pipelines, nil
+}
+
+// queuedNotice builds the feedback message for a command waiting in a session queue
+func (s *Server) queuedNotice(roomID id.RoomID, ahead int) string {
+	if ahead == 1 {
+		return s.text(roomID, "queue.behind_one")
+	}
+	return s.text(roomID, "queue.behind_many", ahead, ahead-1)
+}
+
+// sendReply sends a message to the room mentioning the sender, replying to
+// replyEventID when one is set. It returns the ID of the reply, empty if
+// none was sent.
+func (s *Server) sendReply(ctx context.Context, message string, sender id.UserID, replyEventID id.EventID) id.EventID {
+	if s.plugins != nil {
+		if message = s.plugins.Transform(ctx, plugin.Message{RoomID: string(replyRoom(ctx)), Sender: string(sender), EventID: string(replyEventID), Body: message}); message == "" {
+			return ""
+		}
+	}
+	if s.wasm != nil {
+		if message = s.wasm.Transform(ctx, wasm.Message{RoomID: string(replyRoom(ctx)), Sender: string(sender), EventID: string(replyEventID), Command: replySender(ctx), Body: message}); message == "" {
+			return ""
+		}
+	}
+	if message = s.formatReply(ctx, replyRoom(ctx), sender, message); message == "" {
+		return ""
+	}
+	var eventID id.EventID
+	if pages := s.replyPages(message); len(pages) > 1 {
+		eventID = s.sendPagedReply(ctx, pages, sender, replyEventID)
 	} else {
-		s.logger.Info("Command executed successfully but produced no output")
+		var err error
+		if eventID, err = s.matrix.SendMessage(message, replyOptions(ctx, sender, replyEventID)...); err != nil {
+			s.logger.Ctx(ctx).Error("Failed to send reply to Matrix: %v", err)
+		}
+	}
+	s.archiveReply(ctx, message, replyEventID, eventID)
+	s.relayReplyToTelegram(ctx, message, eventID)
+	return eventID
+}
+
+// replyOptions returns the options of a reply to sender in the reply room
+// of ctx
+func replyOptions(ctx context.Context, sender id.UserID, replyEventID id.EventID) []matrix.SendMessageOption {
+	opts := []matrix.SendMessageOption{matrix.WithMention(sender), matrix.WithLogContext(ctx), matrix.WithRoom(replyRoom(ctx)), matrix.WithSender(replySender(ctx))}
+	if replyEventID != "" {
+		opts = append(opts, matrix.WithReplyTo(replyEventID))
 	}
+	return opts
 }
 
-func New(cfg *config.Config, loggerInstance *logger.Logger) (*Server, error) {
+func New(cfg *config.Config, loggerInstance *logger.Logger) (_ *Server, err error) {
+	// Report configuration mistakes before connecting to anything
+	if err := cfg.Validate(); err != nil {
+		loggerInstance.Error("%v", err)
+		return nil, err
+	}
+
+	// Create router
+	r := chi.NewRouter()
+	r.Use(requestID)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+
+	s := &Server{
+		config:     cfg,
+		baseConfig: cfg,
+		router:     r,
+		logger:     loggerInstance.WithComponent("server"),
+		startedAt:  time.Now(),
+		loadConfig: config.LoadConfig,
+	}
+	// Release what was set up when a later step fails
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
 	// Initialize Matrix client
-	matrixClient, err := matrix.New(&cfg.Matrix, loggerInstance)
-	if err != nil {
+	if s.matrix, err = matrix.New(&cfg.Matrix, loggerInstance.WithComponent("matrix")); err != nil {
 		loggerInstance.Error("Failed to initialize Matrix client: %v", err)
 		return nil, fmt.Errorf("failed to initialize Matrix client: %w", err)
 	}
+	matrixClient := s.matrix
 
 	// Update config with current device ID (may have changed after first login)
 	currentDeviceID := matrixClient.GetDeviceID()
@@ -185,64 +784,489 @@ func New(cfg *config.Config, loggerInstance *logger.Logger) (*Server, error) {
 		cfg.Matrix.DeviceID = currentDeviceID
 	}
 
+	if s.store, err = storage.Open(&cfg.Storage); err != nil {
+		loggerInstance.Error("Failed to open storage: %v", err)
+		return nil, err
+	}
+	store := s.store
+
+	// Merge the commands registered at runtime into the webhook settings
+	effective := cfg
+	if cfg.Webhook.CommandStore != "" {
+		if s.commands, err = openCommandStore(store, cfg.Webhook.CommandStore); err != nil {
+			loggerInstance.Error("Failed to open command store: %v", err)
+			return nil, err
+		}
+		effective = mergeRegisteredCommands(cfg, s.commands.list(), loggerInstance)
+	}
+	s.config = effective
+
+	if cfg.Audit.Dir != "" {
+		if s.auditLog, err = audit.Open(cfg.Audit.Dir, cfg.Audit.RetentionDays); err != nil {
+			loggerInstance.Error("Failed to open audit log: %v", err)
+			return nil, err
+		}
+	}
+
+	if cfg.Archive.Enabled {
+		if s.archiver, err = archive.New(&cfg.Archive, loggerInstance.WithComponent("archive")); err != nil {
+			loggerInstance.Error("Failed to set up the archive: %v", err)
+			return nil, err
+		}
+	}
+
+	if cfg.Memory.Enabled {
+		secret := cfg.Memory.EncryptionKey
+		if secret == "" {
+			secret = cfg.Matrix.PickleKey
+		}
+		if s.memory, err = memory.NewStore(&cfg.Memory, secret, store); err != nil {
+			loggerInstance.Error("Failed to load the memory: %v", err)
+			return nil, err
+		}
+	}
+
+	if s.catalogs, err = i18n.Load(cfg.I18n.CatalogDir); err != nil {
+		loggerInstance.Error("Failed to load the message catalogs: %v", err)
+		return nil, err
+	}
+	for _, language := range configuredLanguages(cfg) {
+		if !s.catalogs.Has(language) {
+			loggerInstance.Warn("There is no message catalog for language %s, replies are sent in English", language)
+		}
+	}
+
 	// Initialize webhook dispatcher
-	webhookDispatcher := webhook.New(&cfg.Webhook, loggerInstance)
+	s.dedup = dedup.New(store, cfg.Dedup.MaxEntries, loggerInstance.WithComponent("dedup"))
+	s.webhook = webhook.New(&effective.Webhook, s.dedup, loggerInstance.WithComponent("webhook"))
 
-	// Initialize session manager
-	sessionMgr := session.NewManager(loggerInstance, cfg.Webhook.SessionTimeout, cfg.Webhook.DefaultCommand, "/tmp/pi-sessions")
+	compiled, err := compileConfig(effective)
+	if err != nil {
+		loggerInstance.Error("Invalid configuration: %v", err)
+		return nil, err
+	}
+	for _, warning := range compiled.templateWarnings {
+		loggerInstance.Warn("Webhook template dry run: %s", warning)
+	}
 
-	// Create router
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	// Initialize session manager
+	if s.sessionMgr, err = newSessionManager(&cfg.Webhook, defaultSessionDir, compiled.allowlist, loggerInstance.WithComponent("session")); err != nil {
+		loggerInstance.Error("%v", err)
+		return nil, err
+	}
 
-	s := &Server{
-		config:     cfg,
-		router:     r,
-		matrix:     matrixClient,
-		logger:     loggerInstance,
-		webhook:    webhookDispatcher,
-		sessionMgr: sessionMgr,
+	if cfg.Plugins.Dir != "" {
+		if s.plugins, err = plugin.Load(cfg.Plugins.Dir, time.Duration(cfg.Plugins.Timeout)*time.Second, loggerInstance.WithComponent("plugin")); err != nil {
+			loggerInstance.Error("Failed to load plugins: %v", err)
+			return nil, err
+		}
+	}
+	if len(cfg.WASM.Modules) > 0 {
+		if s.wasm, err = wasm.Load(context.Background(), &cfg.WASM, loggerInstance.WithComponent("wasm")); err != nil {
+			loggerInstance.Error("Failed to load WASM modules: %v", err)
+			return nil, err
+		}
 	}
 
+	s.outputPipelines = compiled.outputPipelines
+	s.alertmanagerTemplate = compiled.alertmanagerTemplate
+	s.customHooks = compiled.customHooks
+	s.notifyTemplates = compiled.notifyTemplates
+	s.errorTemplates = compiled.errorTemplates
+	s.lifecycleTemplates = compiled.lifecycleTemplates
+	s.replyWrappers = compiled.replyWrappers
+	s.transforms = compiled.transforms
+	s.ipAllowlists = compiled.ipAllowlists
+	s.trustedProxies = compiled.trustedProxies
+	s.rooms = compiled.rooms
+	s.scripts = compiled.scripts
+	s.feedTemplate = compiled.feedTemplate
+	s.emailTemplates = compiled.emailTemplates
+	s.inboundEmailTemplate = compiled.inboundEmailTemplate
+	s.quietHours = compiled.quietHours
+
 	// Set the server as the message handler for the Matrix client
 	matrixClient.SetMessageHandler(s)
+	matrixClient.SetRooms(cfg.Rooms)
+	matrixClient.SetBridges(cfg.Bridges)
+	if cfg.Matrix.WithheldNotice {
+		matrixClient.SetWithheldHandler(s)
+	}
+
+	if cfg.Stream.Enabled {
+		s.stream = newStreamHub(cfg.Stream.HistorySize)
+	}
+	if cfg.Stream.Enabled || cfg.Telegram.Enabled || len(cfg.Push.Rules) > 0 || len(cfg.PagerDuty.Services) > 0 || cfg.Hooks.PagerDuty.Enabled || (cfg.Jira.Enabled && cfg.Jira.ExpandKeys) || cfg.Translate.Enabled || cfg.Vision.Enabled || cfg.Feedback.Enabled || cfg.Pagination.Enabled {
+		matrixClient.SetEventHandler(s)
+	}
+	if cfg.Feedback.Enabled {
+		s.feedback = newFeedbackReplies(cfg.Feedback.MaxReplies)
+	}
+	if cfg.Pagination.Enabled {
+		s.pages = newPagedReplies(cfg.Pagination.MaxReplies)
+	}
+	if s.quietQueue, err = quiet.NewQueue(store, s.inQuietHours, s.deliverHeld, loggerInstance.WithComponent("quiet")); err != nil {
+		loggerInstance.Error("Failed to load the notifications held for quiet hours: %v", err)
+		return nil, err
+	}
+	if cfg.LLM.Enabled {
+		s.llm = llm.New(&cfg.LLM, loggerInstance.WithComponent("llm"))
+	}
+	if cfg.Ollama.Enabled {
+		s.ollama = llm.NewOllama(&cfg.Ollama, loggerInstance.WithComponent("llm"))
+	}
+	if cfg.Vision.Enabled {
+		if s.vision, err = vision.NewClient(&cfg.Vision); err != nil {
+			loggerInstance.Error("Failed to set up the vision endpoint: %v", err)
+			return nil, err
+		}
+		s.visionTemplate = compiled.visionTemplate
+	}
+	if cfg.Feeds.Enabled {
+		if s.feeds, err = feed.NewPoller(&cfg.Feeds, cfg.Matrix.RoomID, store, s.postFeedItem, loggerInstance.WithComponent("feed")); err != nil {
+			loggerInstance.Error("Failed to load feeds: %v", err)
+			return nil, err
+		}
+		s.feeds.Start()
+	}
+	if len(cfg.Schedule.Jobs) > 0 {
+		if s.scheduler, err = schedule.New(&cfg.Schedule, store, s.runScheduledJob, loggerInstance.WithComponent("schedule")); err != nil {
+			loggerInstance.Error("Failed to load the schedule: %v", err)
+			return nil, err
+		}
+		s.scheduler.Start()
+	}
+	if cfg.Reminders.Enabled {
+		if s.reminders, err = remind.NewStore(&cfg.Reminders, store, s.postReminder, loggerInstance.WithComponent("remind")); err != nil {
+			loggerInstance.Error("Failed to load reminders: %v", err)
+			return nil, err
+		}
+		s.reminders.Start()
+	}
+	if cfg.Email.IMAP.Enabled {
+		if s.emailPoller, err = email.NewPoller(&cfg.Email.IMAP, store, s.postEmail, loggerInstance.WithComponent("email")); err != nil {
+			loggerInstance.Error("Failed to load the email state: %v", err)
+			return nil, err
+		}
+		s.emailPoller.Start()
+	}
+	if cfg.Telegram.Enabled {
+		s.telegramLinks = newTelegramLinks()
+		s.telegram = telegram.NewRelay(&cfg.Telegram, s.relayFromTelegram, loggerInstance.WithComponent("telegram"))
+		s.telegram.Start()
+	}
+	if len(cfg.Push.Rules) > 0 {
+		if s.push, err = push.New(&cfg.Push, loggerInstance.WithComponent("push")); err != nil {
+			loggerInstance.Error("Failed to set up push notifications: %v", err)
+			return nil, err
+		}
+		s.push.Start()
+	}
+	if s.archiver != nil {
+		s.archiver.Start()
+	}
+	s.quietQueue.Start()
+	if len(cfg.PagerDuty.Services) > 0 || cfg.Hooks.PagerDuty.Enabled {
+		s.pagerDuty = pagerduty.NewClient(&cfg.PagerDuty)
+		s.pagerDutyIncidents = newPagerDutyIncidents()
+	}
+	if cfg.Jira.Enabled {
+		s.jira = jira.NewClient(&cfg.Jira)
+		s.jiraExpansions = newJiraExpansions()
+	}
+	if cfg.HomeAssistant.Enabled {
+		s.homeAssistant = homeassistant.NewClient(&cfg.HomeAssistant)
+		s.homeAssistantSetup = compiled.homeAssistant
+		if eventTypes := s.homeAssistantSetup.eventTypes(); len(eventTypes) > 0 {
+			s.homeAssistantWatcher = homeassistant.NewWatcher(&cfg.HomeAssistant, eventTypes, s.handleHomeAssistantEvent, loggerInstance.WithComponent("homeassistant"))
+			s.homeAssistantWatcher.Start()
+		}
+	}
+	if cfg.Translate.Enabled {
+		s.translator = translate.NewClient(&cfg.Translate)
+	}
+	if s.tenants, s.tenantRooms, err = newTenants(cfg, compiled.allowlist, loggerInstance.WithComponent("session")); err != nil {
+		loggerInstance.Error("Failed to set up tenants: %v", err)
+		return nil, err
+	}
+	for _, sessionMgr := range s.sessionManagers() {
+		sessionMgr.SetExpiryHandler(func(sess *session.Session) { s.exportExpiredSession(sessionMgr, sess) })
+	}
 
 	s.routes()
+	go s.reportWebhookReachability("startup")
+	go s.postLifecycleNotice(lifecycleStartup)
 
 	return s, nil
 }
 
+// ValidateConfig checks a configuration as New and Reload would, without
+// connecting to Matrix or starting anything
+func ValidateConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	_, err := compileConfig(cfg)
+	return err
+}
+
+// compiledConfig holds the parts of the configuration that are validated and
+// compiled up front
+type compiledConfig struct {
+	allowlist            *session.Allowlist // nil unless commands are enabled
+	outputPipelines      map[string]*session.OutputPipeline
+	alertmanagerTemplate *template.Template
+	customHooks          map[string]*customHook
+	notifyTemplates      map[string]*notifyTemplate
+	errorTemplates       map[string]*template.Template
+	lifecycleTemplates   map[string]*template.Template
+	replyWrappers        map[id.RoomID]*replyWrapper
+	transforms           []*inboundTransform
+	ipAllowlists         map[string]ipAllowlist
+	trustedProxies       ipAllowlist
+	rooms                map[id.RoomID]*config.RoomConfig
+	scripts              hookScripts
+	feedTemplate         *template.Template
+	emailTemplates       map[string]*emailTemplate
+	inboundEmailTemplate *template.Template
+	homeAssistant        *homeAssistantSetup
+	visionTemplate       *template.Template
+	quietHours           *quietSchedules
+	templateWarnings     []string // Payload templates that render invalid JSON
+}
+
+// compileConfig validates command templates and compiles output processors
+// and hook templates, so that configuration errors surface at startup (or
+// reload) instead of on first use
+func compileConfig(cfg *config.Config) (*compiledConfig, error) {
+	compiled := &compiledConfig{}
+
+	if commandsEnabledAnywhere(cfg) {
+		allowlist := session.NewAllowlist(cfg.Webhook.AllowedExecutables)
+		if err := validateCommandTemplates(&cfg.Webhook, allowlist); err != nil {
+			return nil, fmt.Errorf("invalid command template: %w", err)
+		}
+		for i, tenant := range cfg.Tenants {
+			if err := validateCommandTemplates(cfg.TenantWebhook(&cfg.Tenants[i]), allowlist); err != nil {
+				return nil, fmt.Errorf("invalid command template: tenants.%s.webhook.%w", tenant.Name, err)
+			}
+		}
+		for i, room := range cfg.Rooms {
+			if room.DefaultCommand == "" {
+				continue
+			}
+			if err := session.ValidateTemplate(room.DefaultCommand, cfg.Webhook.ExecMode, allowlist); err != nil {
+				return nil, fmt.Errorf("invalid command template: rooms[%d].default_command: %w", i, err)
+			}
+		}
+		compiled.allowlist = allowlist
+	}
+	compiled.rooms = compileRooms(cfg.Rooms)
+
+	var err error
+	// Render every payload template once, so that templates that cannot be
+	// rendered fail here rather than at the first message
+	if compiled.templateWarnings, err = webhook.DryRun(&cfg.Webhook, cfg.Rooms); err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	for i, tenant := range cfg.Tenants {
+		warnings, err := webhook.DryRun(cfg.TenantWebhook(&cfg.Tenants[i]), nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: tenants.%s.%w", tenant.Name, err)
+		}
+		for _, warning := range warnings {
+			compiled.templateWarnings = append(compiled.templateWarnings, "tenants."+tenant.Name+"."+warning)
+		}
+	}
+	if compiled.outputPipelines, err = compileOutputPipelines(&cfg.Webhook); err != nil {
+		return nil, fmt.Errorf("invalid output processor: %w", err)
+	}
+	if compiled.alertmanagerTemplate, err = compileAlertmanagerTemplate(&cfg.Hooks.Alertmanager); err != nil {
+		return nil, fmt.Errorf("invalid alertmanager template: %w", err)
+	}
+	if compiled.customHooks, err = compileCustomHooks(cfg.Hooks.Custom); err != nil {
+		return nil, fmt.Errorf("invalid custom hook: %w", err)
+	}
+	if compiled.notifyTemplates, err = compileNotifyTemplates(cfg.Notify.Templates); err != nil {
+		return nil, err
+	}
+	if compiled.errorTemplates, err = compileErrorReplyTemplates(&cfg.ErrorReplies); err != nil {
+		return nil, err
+	}
+	if compiled.lifecycleTemplates, err = compileLifecycleTemplates(&cfg.LifecycleNotices); err != nil {
+		return nil, err
+	}
+	if compiled.replyWrappers, err = compileReplyWrappers(cfg.Rooms); err != nil {
+		return nil, err
+	}
+	if compiled.transforms, err = compileInboundTransforms(cfg.InboundTransforms); err != nil {
+		return nil, fmt.Errorf("invalid %w", err)
+	}
+	if compiled.ipAllowlists, err = compileIPAllowlists(cfg.Server.IPAllowlists); err != nil {
+		return nil, fmt.Errorf("invalid server.ip_allowlists: %w", err)
+	}
+	if cfg.Server.TrustProxyHeaders {
+		if compiled.trustedProxies, err = compileTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("invalid server.trusted_proxies: %w", err)
+		}
+	}
+	if compiled.scripts, err = compileHookScripts(&cfg.Webhook); err != nil {
+		return nil, fmt.Errorf("invalid script: %w", err)
+	}
+	if compiled.feedTemplate, err = compileFeedTemplate(&cfg.Feeds); err != nil {
+		return nil, fmt.Errorf("invalid feeds.template: %w", err)
+	}
+	if compiled.emailTemplates, err = compileEmailTemplates(cfg.Email.Templates); err != nil {
+		return nil, err
+	}
+	if compiled.inboundEmailTemplate, err = compileInboundEmailTemplate(&cfg.Email.IMAP); err != nil {
+		return nil, fmt.Errorf("invalid email.imap.template: %w", err)
+	}
+	if compiled.homeAssistant, err = compileHomeAssistant(&cfg.HomeAssistant, cfg.Matrix.RoomID); err != nil {
+		return nil, err
+	}
+	if compiled.visionTemplate, err = compileVisionTemplate(&cfg.Vision); err != nil {
+		return nil, fmt.Errorf("invalid vision.template: %w", err)
+	}
+	if err := validateRenderHints(&cfg.Webhook); err != nil {
+		return nil, fmt.Errorf("invalid render hint: %w", err)
+	}
+	if compiled.quietHours, err = compileQuietHours(cfg); err != nil {
+		return nil, fmt.Errorf("invalid %w", err)
+	}
+	if err := validateScheduleJobs(cfg.Schedule.Jobs); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	if cfg.Stream.Enabled && cfg.Stream.Token == "" {
+		return nil, fmt.Errorf("stream.token is required when stream.enabled is set")
+	}
+	return compiled, nil
+}
+
+// validateCommandTemplates checks the default command and every command
+// template that is not a webhook payload template (i.e. has no webhook URL)
+func validateCommandTemplates(cfg *config.WebhookConfig, allowlist *session.Allowlist) error {
+	if cfg.DefaultCommand != "" {
+		if err := session.ValidateTemplate(cfg.DefaultCommand, cfg.ExecMode, allowlist); err != nil {
+			return fmt.Errorf("default_command: %w", err)
+		}
+	}
+	for name, tpl := range cfg.CommandTemplates {
+		if _, isWebhook := cfg.Commands[name]; isWebhook {
+			continue
+		}
+		if err := session.ValidateTemplate(tpl, cfg.ExecMode, allowlist); err != nil {
+			return fmt.Errorf("command_templates.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 func (s *Server) routes() {
+	s.router.Use(s.cors)
+
 	s.router.Get("/health", s.handleHealth)
+	s.router.Get("/live", s.handleLive)
+	s.router.Get("/ready", s.handleReady)
 	s.router.Get("/status", s.handleStatus)
-	s.router.Post("/message", s.handleMessage)
+	s.router.Get("/version", s.handleVersion)
+	s.router.With(s.allowIPs("metrics")).Get("/metrics", s.handleMetrics)
+	s.router.Get("/openapi.json", s.handleOpenAPI)
+	s.router.Get("/docs", s.handleDocs)
+
+	// Endpoints that accept content from clients share a rate limit
+	s.router.Group(func(r chi.Router) {
+		r.Use(s.rateLimit(newRateLimiter()))
+
+		limitBody := s.limitRequestBody(func(cfg *config.Config) int64 {
+			return int64(cfg.Server.MaxBodySizeKB) << 10
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.allowIPs("api"))
+			// Allow some headroom over the file size for the other form fields
+			r.With(s.limitRequestBody(func(cfg *config.Config) int64 {
+				return int64(cfg.Server.MediaMaxSizeMB)<<20 + 1<<20
+			})).Post("/media", s.handleMedia)
+
+			r.With(limitBody).Post("/message", s.handleMessage)
+			r.With(limitBody).Put("/message/{eventID}", s.handleEditMessage)
+			r.With(limitBody).Delete("/message/{eventID}", s.handleRedactMessage)
+			r.With(limitBody).Post("/reaction", s.handleReaction)
+			// Always served since a config reload may add templates
+			r.With(limitBody).Post("/notify/{template}", s.handleNotify)
+			r.With(limitBody).Post("/email/{template}", s.handleEmail)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.allowIPs("hooks"), limitBody, s.dedupHooks)
+			if s.cfg().Hooks.Alertmanager.Enabled {
+				r.Post("/hook/alertmanager", s.handleAlertmanager)
+			}
+			if s.cfg().Hooks.Grafana.Enabled {
+				r.Post("/hook/grafana", s.handleGrafana)
+			}
+			if s.cfg().Hooks.Discord.Enabled {
+				r.Post("/hook/discord", s.handleDiscord)
+				r.Post("/hook/discord/{id}/{token}", s.handleDiscord)
+			}
+			if s.cfg().Hooks.PagerDuty.Enabled {
+				r.Post("/hook/pagerduty", s.handlePagerDuty)
+			}
+			// Always served since a config reload may add hooks
+			r.Post("/hook/{name}", s.handleCustomHook)
+		})
+	})
+
+	if s.cfg().Stream.Enabled {
+		s.router.With(s.allowIPs("stream")).Get("/ws", s.handleWebSocket)
+		s.router.With(s.allowIPs("stream")).Get("/events", s.handleEvents)
+	}
+
+	if s.cfg().Server.AdminToken != "" {
+		s.router.With(s.allowIPs("admin")).Route("/admin", s.adminRoutes)
+	}
+
+	if s.cfg().Matrix.AppService.Enabled {
+		s.router.With(s.allowIPs("appservice")).Route("/_matrix/app/v1", s.appServiceRoutes)
+	}
+
+	if s.cfg().Server.EnableDebug {
+		s.router.Group(func(r chi.Router) {
+			r.Use(s.allowIPs("debug"))
+			r.Get("/debug/runtime", s.handleRuntime)
+			r.Mount("/debug", middleware.Profiler())
+		})
+	}
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.logger.Debug("Health check endpoint called")
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	s.logger.Debug("Status endpoint called")
+	cfg := s.cfg()
 	status := map[string]interface{}{
-		"status": "running",
+		"status":  "running",
+		"paused":  s.paused.Load(),
+		"version": buildinfo.Get(),
 		"matrix": map[string]string{
-			"room_id": s.config.Matrix.RoomID,
-			"user_id": s.config.Matrix.UserID,
+			"room_id": cfg.Matrix.RoomID,
+			"user_id": cfg.Matrix.UserID,
 		},
 		"webhooks": map[string]interface{}{
-			"default":           s.config.Webhook.Default,
-			"commands":          s.config.Webhook.Commands,
-			"template":          s.config.Webhook.Template,
-			"command_templates": s.config.Webhook.CommandTemplates,
-			"jq_selector":       s.config.Webhook.JQSelector,
-			"command_selectors": s.config.Webhook.CommandSelectors,
-			"skip_empty":        s.config.Webhook.SkipEmpty,
-			"timeout":           s.config.Webhook.Timeout,
+			"default":           cfg.Webhook.Default,
+			"commands":          cfg.Webhook.Commands,
+			"template":          cfg.Webhook.Template,
+			"command_templates": cfg.Webhook.CommandTemplates,
+			"jq_selector":       cfg.Webhook.JQSelector,
+			"command_selectors": cfg.Webhook.CommandSelectors,
+			"skip_empty":        cfg.Webhook.SkipEmpty,
+			"timeout":           cfg.Webhook.Timeout,
 		},
 	}
 
@@ -251,10 +1275,70 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleVersion returns the version, commit and build date of the binary
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
 type MessageRequest struct {
 	Message  string `json:"message"`
 	AsFile   bool   `json:"as_file,omitempty"`
 	Filename string `json:"filename,omitempty"`
+	// Optional delivery and formatting settings
+	RoomID     string `json:"room_id,omitempty"`
+	Format     string `json:"format,omitempty"`  // markdown (default), html or plain
+	MsgType    string `json:"msgtype,omitempty"` // text (default) or notice
+	ThreadRoot string `json:"thread_root,omitempty"`
+	InReplyTo  string `json:"in_reply_to,omitempty"`
+	// Posted also during quiet hours; other messages are held until they end
+	Critical bool `json:"critical,omitempty"`
+}
+
+// validate checks the optional fields of a message request
+func (req *MessageRequest) validate() error {
+	switch req.Format {
+	case "", matrix.FormatMarkdown, matrix.FormatHTML, matrix.FormatPlain:
+	default:
+		return fmt.Errorf("invalid format %q, expected markdown, html or plain", req.Format)
+	}
+	switch req.MsgType {
+	case "", matrix.MsgTypeText, matrix.MsgTypeNotice:
+	default:
+		return fmt.Errorf("invalid msgtype %q, expected text or notice", req.MsgType)
+	}
+	if req.RoomID != "" && !strings.HasPrefix(req.RoomID, "!") {
+		return fmt.Errorf("invalid room_id %q", req.RoomID)
+	}
+	if req.ThreadRoot != "" && !strings.HasPrefix(req.ThreadRoot, "$") {
+		return fmt.Errorf("invalid thread_root %q", req.ThreadRoot)
+	}
+	if req.InReplyTo != "" && !strings.HasPrefix(req.InReplyTo, "$") {
+		return fmt.Errorf("invalid in_reply_to %q", req.InReplyTo)
+	}
+	return nil
+}
+
+// sendOptions converts the request fields into Matrix send options
+func (req *MessageRequest) sendOptions() []matrix.SendMessageOption {
+	var opts []matrix.SendMessageOption
+	if req.RoomID != "" {
+		opts = append(opts, matrix.WithRoom(id.RoomID(req.RoomID)))
+	}
+	if req.Format != "" {
+		opts = append(opts, matrix.WithFormat(req.Format))
+	}
+	if req.MsgType != "" {
+		opts = append(opts, matrix.WithMsgType(req.MsgType))
+	}
+	if req.ThreadRoot != "" {
+		opts = append(opts, matrix.WithThread(id.EventID(req.ThreadRoot)))
+	}
+	if req.InReplyTo != "" {
+		opts = append(opts, matrix.WithReplyTo(id.EventID(req.InReplyTo)))
+	}
+	return opts
 }
 
 func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
@@ -267,19 +1351,35 @@ func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := req.validate(); err != nil {
+		s.logger.Error("Invalid message request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Set default values for optional parameters
 	if req.Filename == "" {
 		req.Filename = "message.md"
 	}
 
-	s.logger.Info("Received message: %s, as_file: %t, filename: %s", req.Message, req.AsFile, req.Filename)
+	s.logger.Info("Received message: %s, as_file: %t, filename: %s, room_id: %s, format: %s, msgtype: %s, thread_root: %s, in_reply_to: %s",
+		s.logger.Message(req.Message), req.AsFile, req.Filename, req.RoomID, req.Format, req.MsgType, req.ThreadRoot, req.InReplyTo)
+
+	// Files are posted right away, only text is held
+	if !req.AsFile && !req.Critical && s.holdNotification(r.Context(), quiet.Notification{
+		Source: "message", RoomID: req.RoomID, Message: req.Message, Format: req.Format, MsgType: req.MsgType, ThreadRoot: req.ThreadRoot, InReplyTo: req.InReplyTo,
+	}) {
+		writeHeldResponse(w)
+		return
+	}
 
 	// Send message to Matrix
+	var eventID id.EventID
 	var err error
 	if req.AsFile {
-		err = s.matrix.SendFile(req.Message, req.Filename)
+		eventID, err = s.matrix.SendFile(req.Message, req.Filename, append(req.sendOptions(), withRequestID(r))...)
 	} else {
-		err = s.matrix.SendMessage(req.Message)
+		eventID, err = s.matrix.SendMessage(req.Message, append(req.sendOptions(), withRequestID(r))...)
 	}
 
 	if err != nil {
@@ -292,11 +1392,11 @@ func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(SendResponse{Status: "success", EventID: string(eventID)})
 }
 
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.Server.Port)
+	addr := fmt.Sprintf(":%d", s.cfg().Server.Port)
 	s.logger.Info("Starting server on %s", addr)
 
 	s.httpServer = &http.Server{
@@ -307,13 +1407,168 @@ func (s *Server) Start() error {
 	return s.httpServer.ListenAndServe()
 }
 
-func (s *Server) Stop() error {
-	s.logger.Info("Stopping server")
-	if s.sessionMgr != nil {
-		s.sessionMgr.Stop()
+// Shutdown gracefully stops the service: it stops accepting HTTP requests
+// and drains in-flight ones, stops the Matrix sync loop, waits for pending
+// webhook dispatches and session commands, then releases resources. Work
+// still running when ctx expires is abandoned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Shutting down server")
+	var errs []error
+
+	// Rooms learn that the bot is going away while it can still post
+	s.postLifecycleNotice(lifecycleShutdown)
+
+	// End streams first: WebSockets hijack their connections, so the HTTP
+	// server does not wait for them, and SSE requests would never finish
+	if s.stream != nil {
+		s.stream.close()
 	}
+
 	if s.httpServer != nil {
-		return s.httpServer.Close()
+		if err := s.httpServer.Shutdown(ctx); err != nil {
+			s.logger.Error("Failed to drain HTTP requests: %v", err)
+			errs = append(errs, fmt.Errorf("http server: %w", err))
+		}
 	}
-	return nil
+
+	if s.feeds != nil {
+		s.feeds.Stop()
+	}
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+	if s.reminders != nil {
+		s.reminders.Stop()
+	}
+	if s.quietQueue != nil {
+		s.quietQueue.Stop()
+	}
+	if s.emailPoller != nil {
+		s.emailPoller.Stop()
+	}
+	if s.telegram != nil {
+		s.telegram.Stop()
+	}
+	if s.homeAssistantWatcher != nil {
+		s.homeAssistantWatcher.Stop()
+	}
+
+	// Stop receiving Matrix messages before waiting for the work they trigger
+	if s.matrix != nil {
+		if err := s.matrix.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("matrix client: %w", err))
+		}
+	}
+
+	// Send the push notifications of the last messages
+	if s.push != nil {
+		s.push.Stop()
+	}
+
+	if s.webhook != nil {
+		if err := s.webhook.Wait(ctx); err != nil {
+			s.logger.Warn("Timed out waiting for webhook dispatches: %v", err)
+			errs = append(errs, fmt.Errorf("webhook dispatcher: %w", err))
+		}
+	}
+
+	if s.sessionMgr != nil {
+		for _, sessionMgr := range s.sessionManagers() {
+			if err := sessionMgr.Wait(ctx); err != nil {
+				s.logger.Warn("Timed out waiting for session commands: %v", err)
+				errs = append(errs, fmt.Errorf("session manager: %w", err))
+			}
+			sessionMgr.Stop()
+		}
+	}
+
+	if s.plugins != nil {
+		s.plugins.Close()
+	}
+	if s.wasm != nil {
+		s.wasm.Close()
+	}
+
+	// Write the messages and replies still pending
+	if s.archiver != nil {
+		s.archiver.Stop()
+	}
+
+	// Closed last, commands finishing above are still recorded
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("audit log: %w", err))
+		}
+	}
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("storage: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// close releases what New set up when a later step of New fails. Unlike
+// Shutdown, it does not wait for work in progress, as none was accepted yet.
+func (s *Server) close() {
+	if s.homeAssistantWatcher != nil {
+		s.homeAssistantWatcher.Stop()
+	}
+	if s.push != nil {
+		s.push.Stop()
+	}
+	if s.telegram != nil {
+		s.telegram.Stop()
+	}
+	if s.emailPoller != nil {
+		s.emailPoller.Stop()
+	}
+	if s.reminders != nil {
+		s.reminders.Stop()
+	}
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+	if s.feeds != nil {
+		s.feeds.Stop()
+	}
+	if s.quietQueue != nil {
+		s.quietQueue.Stop()
+	}
+	if s.matrix != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := s.matrix.Close(ctx); err != nil {
+			s.logger.Warn("Failed to stop the Matrix client: %v", err)
+		}
+		cancel()
+	}
+	if s.sessionMgr != nil {
+		for _, sessionMgr := range s.sessionManagers() {
+			sessionMgr.Stop()
+		}
+	}
+	if s.plugins != nil {
+		s.plugins.Close()
+	}
+	if s.wasm != nil {
+		s.wasm.Close()
+	}
+	if s.archiver != nil {
+		s.archiver.Stop()
+	}
+	if s.auditLog != nil {
+		s.auditLog.Close()
+	}
+	if s.store != nil {
+		s.store.Close()
+	}
+}
+
+// Stop shuts the server down, waiting up to server.shutdown_timeout seconds
+// for in-flight work to finish
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg().Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+	return s.Shutdown(ctx)
 }
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mule-ai/mule/matrix-microservice/internal/config"
 	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
 	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"github.com/mule-ai/mule/matrix-microservice/internal/storage"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -324,3 +332,547 @@ func TestSessionPersistenceInThread(t *testing.T) {
 		t.Errorf("Expected 1 session, got %d", sessionMgr.GetSessionCount())
 	}
 }
+
+// TestValidateCommandTemplatesSkipsWebhookTemplates verifies that payload
+// templates of webhook commands are not validated as shell commands
+func TestValidateCommandTemplatesSkipsWebhookTemplates(t *testing.T) {
+	cfg := &config.WebhookConfig{
+		DefaultCommand: "pi -p {{.MESSAGE}}",
+		Commands:       map[string]string{"meal": "http://localhost:3000/meal"},
+		CommandTemplates: map[string]string{
+			"meal": `{"request": "{{.MESSAGE}}"}`,
+			"pi":   "pi -p {{.MESSAGE}} --session {{.SESSION}}",
+		},
+	}
+	allowlist := session.NewAllowlist([]string{"pi"})
+
+	if err := validateCommandTemplates(cfg, allowlist); err != nil {
+		t.Errorf("validateCommandTemplates() error = %v", err)
+	}
+
+	cfg.CommandTemplates["shell"] = "sh -c {{.MESSAGE}}"
+	if err := validateCommandTemplates(cfg, allowlist); err == nil {
+		t.Error("validateCommandTemplates() should reject a template invoking a non-allowlisted executable")
+	}
+}
+
+func TestIsShareCommand(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected bool
+	}{
+		{"/share @alice:matrix.org", true},
+		{"  /share", true},
+		{"/shared thing", false},
+		{"/cmd share @alice:matrix.org", false},
+		{"please /share this", false},
+	}
+
+	for _, tt := range tests {
+		if got := isShareCommand(tt.message); got != tt.expected {
+			t.Errorf("isShareCommand(%q) = %v, want %v", tt.message, got, tt.expected)
+		}
+	}
+}
+
+func TestIsExportCommand(t *testing.T) {
+	for message, want := range map[string]bool{
+		"/export":           true,
+		"/export jsonl":     true,
+		"/exports":          false,
+		"/cmd export":       false,
+		"please /export it": false,
+	} {
+		if got := isExportCommand(message); got != want {
+			t.Errorf("isExportCommand(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestMessageRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     MessageRequest
+		wantErr bool
+	}{
+		{"Minimal request", MessageRequest{Message: "hi"}, false},
+		{"All options", MessageRequest{Message: "hi", RoomID: "!room:matrix.org", Format: "html", MsgType: "notice", ThreadRoot: "$root", InReplyTo: "$reply"}, false},
+		{"Unknown format", MessageRequest{Message: "hi", Format: "rtf"}, true},
+		{"Unknown msgtype", MessageRequest{Message: "hi", MsgType: "emote"}, true},
+		{"Room alias instead of ID", MessageRequest{Message: "hi", RoomID: "#room:matrix.org"}, true},
+		{"Invalid thread root", MessageRequest{Message: "hi", ThreadRoot: "root"}, true},
+		{"Invalid reply target", MessageRequest{Message: "hi", InReplyTo: "reply"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := ValidateConfig(validTestConfig()); err != nil {
+		t.Fatalf("ValidateConfig() of a valid config error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(cfg *config.Config)
+		wantErr []string
+	}{
+		{
+			name: "Missing Matrix fields",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix = config.MatrixConfig{}
+			},
+			wantErr: []string{"matrix.homeserver", "matrix.userid", "matrix.accesstoken", "matrix.roomid"},
+		},
+		{
+			name: "Invalid OIDC login",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix.AccessToken = ""
+				cfg.Matrix.OIDC = config.OIDCConfig{Enabled: true, Issuer: "auth.example.com", ClientURI: "https://example.com"}
+			},
+			wantErr: []string{"matrix.oidc.issuer", "matrix.oidc.client_name", "matrix.oidc.token_file"},
+		},
+		{
+			name: "Invalid appservice",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix.AccessToken = ""
+				cfg.Matrix.EnableEncryption = true
+				cfg.Matrix.AppService = config.AppServiceConfig{Enabled: true, ASToken: "as-token", Users: map[string]string{"grafana": "Alerts Grafana"}}
+			},
+			wantErr: []string{"matrix.appservice.hs_token", "matrix.enable_encryption", "matrix.appservice.users.grafana"},
+		},
+		{
+			name: "Invalid egress allowlist",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Egress = config.EgressConfig{AllowedCIDRs: []string{"10.0.0.0/33", "192.168.1.1"}, AllowedHosts: []string{"hooks.example.com", "https://ci.example.com"}}
+			},
+			wantErr: []string{"webhook.egress.allowed_cidrs[0]", "webhook.egress.allowed_hosts[1]"},
+		},
+		{
+			name: "Invalid transcript",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Transcript = config.TranscriptConfig{Format: "pdf", MaxEntries: -1}
+			},
+			wantErr: []string{"webhook.transcript.format", "webhook.transcript.max_entries"},
+		},
+		{
+			name: "Invalid ping URL",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.PingURL = "localhost:8081/noop"
+			},
+			wantErr: []string{"webhook.ping_url"},
+		},
+		{
+			name: "Invalid retries and error replies",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.RetryAttempts = 2
+				cfg.Webhook.RetryDelay = 0
+				cfg.ErrorReplies.Templates = map[string]string{"exec": "failed"}
+			},
+			wantErr: []string{"webhook.retry_delay", "error_replies.templates.exec"},
+		},
+		{
+			name: "Invalid archive",
+			modify: func(cfg *config.Config) {
+				cfg.Archive = config.ArchiveConfig{Enabled: true, Endpoint: "minio:9000", Region: "us-east-1", BatchSize: 0, FlushInterval: 60, RetentionDays: -1, Timeout: 30}
+			},
+			wantErr: []string{"archive.endpoint", "archive.bucket", "archive.access_key", "archive.batch_size", "archive.retention_days"},
+		},
+		{
+			name: "Invalid encryption policy",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix.EnableEncryption = false
+				cfg.EncryptionPolicy = config.EncryptionPolicyConfig{Mode: "block", EnableEncryption: true}
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", EncryptionPolicy: "strict"}}
+			},
+			wantErr: []string{"encryption_policy.mode", "encryption_policy.enable_encryption", "rooms[0].encryption_policy"},
+		},
+		{
+			name: "Invalid dedup",
+			modify: func(cfg *config.Config) {
+				cfg.Dedup = config.DedupConfig{MaxEntries: -1, Events: config.DedupScopeConfig{Enabled: true}, Hooks: config.DedupScopeConfig{Enabled: true, TTL: -5}}
+			},
+			wantErr: []string{"dedup.max_entries", "dedup.events.ttl", "dedup.hooks.ttl"},
+		},
+		{
+			name: "Invalid response pages",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.DefaultResponsePages = config.ResponsePagesConfig{Next: ".next", Cursor: ".cursor"}
+				cfg.Webhook.ResponsePages = map[string]config.ResponsePagesConfig{"issues": {Items: ".items[", Next: ".next", MaxPages: -1}}
+			},
+			wantErr: []string{"webhook.default_response_pages.items: is required", "webhook.default_response_pages: exactly one of next or cursor", "webhook.response_pages.issues.items", "webhook.response_pages.issues.max_pages"},
+		},
+		{
+			name: "Invalid inbound transforms",
+			modify: func(cfg *config.Config) {
+				cfg.InboundTransforms = []config.TransformRule{
+					{Regex: "(", Drop: "spam"},
+					{Drop: "["},
+					{StripQuotes: "some", Rooms: []string{"#ops:example.com"}},
+					{Normalize: "NFX"},
+				}
+			},
+			wantErr: []string{"inbound_transforms[0]: exactly one", "inbound_transforms[0].regex", "inbound_transforms[1].drop", "inbound_transforms[2].strip_quotes", "inbound_transforms[2].rooms[0]", "inbound_transforms[3].normalize"},
+		},
+		{
+			name: "Invalid read marker",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix.ReadMarker = config.ReadMarkerConfig{Enabled: true, GraceWindow: -1}
+			},
+			wantErr: []string{"matrix.read_marker.grace_window", "matrix.read_marker.interval"},
+		},
+		{
+			name: "Invalid webhooks",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Default = "localhost:3000/hook"
+				cfg.Webhook.Commands = map[string]string{"deploy": "ftp://ci"}
+				cfg.Webhook.CommandTemplates = map[string]string{"deploy": "{{MESSAGE}}"}
+				cfg.Webhook.JQSelector = ".choices[0"
+			},
+			wantErr: []string{"webhook.default", "webhook.commands.deploy", "webhook.command_templates.deploy", "webhook.jq_selector"},
+		},
+		{
+			name: "Timeouts",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Timeout = 0
+				cfg.Server.ShutdownTimeout = -1
+			},
+			wantErr: []string{"webhook.timeout", "server.shutdown_timeout"},
+		},
+		{
+			name: "Invalid hook template",
+			modify: func(cfg *config.Config) {
+				cfg.Hooks.Custom = map[string]config.CustomHookConfig{"ci": {Template: "{{ .status"}}
+			},
+			wantErr: []string{"hooks.custom.ci"},
+		},
+		{
+			name: "Invalid rooms",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Commands = map[string]string{"deploy": "http://ci/deploy"}
+				cfg.Rooms = []config.RoomConfig{
+					{RoomID: "!ops:example.com", Commands: []string{"deploy", "rollback"}},
+					{RoomID: "#dev:example.com", AllowedUsers: []string{"alice"}, Webhook: config.RoomWebhookConfig{DefaultAuth: "missing"}},
+					{RoomID: "!ops:example.com", RequireEncryption: true},
+				}
+			},
+			wantErr: []string{`rooms[0].commands: "rollback"`, "rooms[1].room_id", "rooms[1].allowed_users[0]", "rooms[1].webhook.default_auth", "rooms[2].room_id", "rooms[2].require_encryption"},
+		},
+		{
+			name: "Room default command outside the allowlist",
+			modify: func(cfg *config.Config) {
+				enabled := true
+				cfg.Webhook.AllowedExecutables = []string{"echo"}
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", EnableCommands: &enabled, DefaultCommand: "rm {{.MESSAGE}}"}}
+			},
+			wantErr: []string{"rooms[0].default_command"},
+		},
+		{
+			name: "Invalid template options",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.TemplateOptions = config.PayloadTemplateConfig{MissingKey: "panic", Escape: "xml"}
+			},
+			wantErr: []string{"template_options.missing_key", "template_options.escape"},
+		},
+		{
+			name: "Strict template with a missing variable",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.TemplateOptions.Strict = true
+				cfg.Webhook.Template = `{"text": {{json .MESSAGE}}, "user": {{json .USER}}}`
+			},
+			wantErr: []string{"webhook.template", "USER"},
+		},
+		{
+			name: "Strict template rendering invalid JSON",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.TemplateOptions.Strict = true
+				cfg.Webhook.Template = `{"text": {{json .MESSAGE}}}`
+				cfg.Webhook.Commands = map[string]string{"deploy": "http://localhost/deploy"}
+				cfg.Webhook.CommandTemplates = map[string]string{"deploy": `{"text": "{{.MESSAGE}}"}`}
+			},
+			wantErr: []string{"webhook.command_templates.deploy", "not valid JSON"},
+		},
+		{
+			name: "Invalid tenants",
+			modify: func(cfg *config.Config) {
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", Commands: []string{"deploy"}}}
+				cfg.Tenants = []config.TenantConfig{
+					{Name: "ops", Rooms: []string{"!ops:example.com", "!dev:example.com"}, Webhook: config.TenantWebhookConfig{DefaultAuth: "missing"}},
+					{Name: "ops team", Rooms: []string{"!ops:example.com"}, RateLimit: -1},
+				}
+			},
+			wantErr: []string{`rooms[0].commands: "deploy" is not defined in tenants.ops.webhook.commands`, "tenants[0].rooms[1]", "tenants[0].webhook.default_auth", "tenants[1].name", "tenants[1].rooms[0]: !ops:example.com already belongs to tenant ops", "tenants[1].rate_limit"},
+		},
+		{
+			name: "Tenant template rendering invalid JSON",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.TemplateOptions.Strict = true
+				cfg.Webhook.Template = `{"text": {{json .MESSAGE}}}`
+				cfg.Tenants = []config.TenantConfig{{Name: "ops", Rooms: []string{"!room:example.com"}, Webhook: config.TenantWebhookConfig{Template: `{"text": "{{.MESSAGE}}"}`}}}
+			},
+			wantErr: []string{"tenants.ops.webhook.template", "not valid JSON"},
+		},
+		{
+			name: "Invalid pagination",
+			modify: func(cfg *config.Config) {
+				cfg.Pagination = config.PaginationConfig{Enabled: true, PageSize: 10, MaxReplies: 100}
+			},
+			wantErr: []string{"pagination.page_size", "pagination.max_pages", "pagination.reaction"},
+		},
+		{
+			name: "Invalid webhook probe",
+			modify: func(cfg *config.Config) {
+				cfg.WebhookProbe = config.WebhookProbeConfig{RoomID: "#admin:example.com"}
+			},
+			wantErr: []string{"webhook_probe.room_id", "webhook_probe.timeout"},
+		},
+		{
+			name: "Invalid permissions",
+			modify: func(cfg *config.Config) {
+				cfg.Permissions = config.PermissionsConfig{Rules: []config.PermissionRule{
+					{Users: []string{"group:sre", "@ops:[a"}, Commands: []string{"deploy"}},
+					{Users: []string{"@ops:*"}},
+				}}
+			},
+			wantErr: []string{"permissions.rules[0].users: group \"sre\"", "permissions.rules[0].users: invalid pattern", "permissions.rules[1].commands"},
+		},
+		{
+			name: "Invalid webhook mock responses",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Dev = config.WebhookDevConfig{
+					Mock:                true,
+					MockResponses:       map[string]config.MockResponseConfig{"deploy": {Body: `{"text": {{.MESSAGE}`}},
+					DefaultMockResponse: config.MockResponseConfig{Status: 42},
+				}
+			},
+			wantErr: []string{"webhook.dev.mock_responses.deploy.body", "webhook.dev.default_mock_response.status"},
+		},
+		{
+			name: "Invalid confirmations",
+			modify: func(cfg *config.Config) {
+				cfg.Confirmations = config.ConfirmationsConfig{Commands: []string{"deploy", "db_[a"}, Reaction: " ", Replies: []string{"yes", ""}, ConfirmBy: "anyone"}
+			},
+			wantErr: []string{"confirmations.commands[1]", "confirmations.timeout", "confirmations.reaction", "confirmations.replies[1]", "confirmations.confirm_by"},
+		},
+		{
+			name: "Invalid quiet hours",
+			modify: func(cfg *config.Config) {
+				cfg.QuietHours = config.QuietHoursConfig{Ranges: []string{"22:00-07:00", "22:00"}, Days: []string{"monday"}, TimeZone: "Mars/Olympus", Critical: []string{"hook:[a"}}
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", QuietHours: &config.QuietHoursConfig{Ranges: []string{"08:00-08:00"}}}}
+			},
+			wantErr: []string{"quiet_hours.ranges[1]", "quiet_hours.days[0]", "quiet_hours.timezone", "quiet_hours.critical[0]", "rooms[0].quiet_hours.ranges[0]"},
+		},
+		{
+			name: "Invalid bridges",
+			modify: func(cfg *config.Config) {
+				cfg.Bridges = []config.BridgeConfig{
+					{Name: "irc", Users: []string{"@ircbot:example.com"}, Prefix: `^<([^>]+)> `, SenderFormat: "irc_{name}"},
+					{Name: "irc", Users: []string{"@tg[a:example.com"}, Prefix: `(`},
+					{Name: "matrix bridge"},
+				}
+			},
+			wantErr: []string{"bridges[0].prefix: has no group", "bridges[0].sender_format", "bridges[1].name", "bridges[1].users[0]", "bridges[1].prefix: invalid", "bridges[2].name", "bridges[2].users", "bridges[2]: prefix or per_message_profile"},
+		},
+		{
+			name: "Invalid attachment policy",
+			modify: func(cfg *config.Config) {
+				cfg.Attachments.Inbound = config.AttachmentPolicy{MaxSizeMB: -1, MimeTypes: []string{"image/[a"}}
+				cfg.Attachments.Outbound.MimeTypes = []string{"image/*", "pdf"}
+			},
+			wantErr: []string{"attachments.inbound.max_size_mb", "attachments.inbound.mime_types[0]", "attachments.outbound.mime_types[1]"},
+		},
+		{
+			name: "Lifecycle notice templates without their notice",
+			modify: func(cfg *config.Config) {
+				cfg.LifecycleNotices = config.LifecycleNoticesConfig{Startup: true, StartupTemplate: "Up", ShutdownTemplate: "Down"}
+			},
+			wantErr: []string{"lifecycle_notices.shutdown_template"},
+		},
+		{
+			name: "Invalid reply wrapper",
+			modify: func(cfg *config.Config) {
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", ReplySuffix: "— {{.Sender"}}
+			},
+			wantErr: []string{"rooms[0].reply_suffix"},
+		},
+		{
+			name: "Invalid WASM modules",
+			modify: func(cfg *config.Config) {
+				cfg.WASM = config.WASMConfig{
+					Modules: []config.WASMModuleConfig{
+						{Name: "redact", Path: "/etc/redact.wasm", Rooms: []string{"!ops[:example.com"}},
+						{Name: "redact"},
+					},
+					Timeout:     1000,
+					MaxMemoryMB: 8192,
+				}
+			},
+			wantErr: []string{"wasm.modules[0].rooms[0]", "wasm.modules[1].name", "wasm.modules[1].path", "wasm.max_memory_mb"},
+		},
+		{
+			name: "Tenant default command outside the allowlist",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.EnableCommands = true
+				cfg.Webhook.SessionTimeout = 600
+				cfg.Webhook.AllowedExecutables = []string{"echo"}
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com"}}
+				cfg.Tenants = []config.TenantConfig{{Name: "ops", Rooms: []string{"!ops:example.com"}, Webhook: config.TenantWebhookConfig{DefaultCommand: "rm {{.MESSAGE}}"}}}
+			},
+			wantErr: []string{"tenants.ops.webhook.default_command"},
+		},
+		{
+			name: "Proxy headers without trusted proxies",
+			modify: func(cfg *config.Config) {
+				cfg.Server.TrustProxyHeaders = true
+			},
+			wantErr: []string{"server.trusted_proxies"},
+		},
+		{
+			name: "Invalid log target",
+			modify: func(cfg *config.Config) {
+				cfg.Logging = config.LoggingConfig{Target: "eventlog", SyslogAddress: "localhost:514"}
+			},
+			wantErr: []string{"logging.target", "logging.syslog_address"},
+		},
+		{
+			name: "Log rotation by time without rotation",
+			modify: func(cfg *config.Config) {
+				cfg.Logging = config.LoggingConfig{File: "matrix.log", RotateHours: 24}
+			},
+			wantErr: []string{"logging.rotate_hours"},
+		},
+		{
+			name: "Log sampling without a rate",
+			modify: func(cfg *config.Config) {
+				cfg.Logging = config.LoggingConfig{SampleInitial: 10}
+			},
+			wantErr: []string{"logging.sample_thereafter"},
+		},
+		{
+			name: "Invalid language",
+			modify: func(cfg *config.Config) {
+				cfg.I18n.Language = "german"
+				cfg.Rooms = []config.RoomConfig{{RoomID: "!ops:example.com", Language: "de_DE"}}
+			},
+			wantErr: []string{"i18n.language", "rooms[0].language"},
+		},
+		{
+			name: "Invalid memory",
+			modify: func(cfg *config.Config) {
+				cfg.Memory = config.MemoryConfig{Enabled: true, Scope: "everyone"}
+			},
+			wantErr: []string{"memory.scope", "memory.max_keys", "memory.max_value_length"},
+		},
+		{
+			name: "Invalid key rotation",
+			modify: func(cfg *config.Config) {
+				cfg.Matrix.KeyRotation = config.KeyRotationConfig{MaxMessages: 20000, MaxAge: 60, ReshareInterval: -1}
+			},
+			wantErr: []string{"matrix.key_rotation.max_messages", "matrix.key_rotation.max_age", "matrix.key_rotation.reshare_interval"},
+		},
+		{
+			name: "Invalid render hint",
+			modify: func(cfg *config.Config) {
+				cfg.Webhook.Render = map[string]string{"status": "html"}
+			},
+			wantErr: []string{"render.status"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			tt.modify(cfg)
+			err := ValidateConfig(cfg)
+			if err == nil {
+				t.Fatal("ValidateConfig() succeeded")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderOutput(t *testing.T) {
+	s := &Server{config: &config.Config{Webhook: config.WebhookConfig{
+		Render:        map[string]string{"status": "table", "raw": "none"},
+		DefaultRender: "code",
+	}}}
+	output := "service,state\napi,up"
+
+	if got := s.renderOutput("status", output); got != "| service | state |\n| --- | --- |\n| api | up |" {
+		t.Errorf("renderOutput(status) = %q, want a table", got)
+	}
+	if got := s.renderOutput("raw", output); got != output {
+		t.Errorf("renderOutput(raw) = %q, want the output unchanged", got)
+	}
+	if got := s.renderOutput("", output); got != "```\n"+output+"\n```" {
+		t.Errorf("renderOutput() = %q, want a code block", got)
+	}
+}
+
+func TestNewReleasesOnLateFailure(t *testing.T) {
+	// The homeserver answers syncs after a short long poll
+	var syncs, activeSyncs atomic.Int32
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/sync") {
+			w.Write([]byte("{}"))
+			return
+		}
+		syncs.Add(1)
+		activeSyncs.Add(1)
+		defer activeSyncs.Add(-1)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			w.Write([]byte(`{"next_batch": "s1"}`))
+		case <-r.Context().Done():
+		}
+	}))
+	defer homeserver.Close()
+
+	// Reminders are loaded late in New, after the Matrix client, the storage
+	// and the session manager are set up
+	dsn := filepath.Join(t.TempDir(), "state.db")
+	store, err := storage.Open(&config.StorageConfig{Driver: storage.DriverSQLite, DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "reminders", []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	cfg := validTestConfig()
+	cfg.Matrix.Homeserver = homeserver.URL
+	cfg.Matrix.DeviceID = "TESTDEVICE"
+	cfg.Storage.DSN = dsn
+	cfg.Reminders = config.RemindersConfig{Enabled: true, DefaultTime: "09:00"}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	if s, err := New(cfg, log); err == nil || !strings.Contains(err.Error(), "reminders") {
+		if s != nil {
+			s.Shutdown(context.Background())
+		}
+		t.Fatalf("New() with broken reminders error = %v", err)
+	}
+
+	// The sync loop was stopped, so the homeserver sees no more syncs
+	deadline := time.Now().Add(5 * time.Second)
+	for activeSyncs.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	started := syncs.Load()
+	time.Sleep(200 * time.Millisecond)
+	if active := activeSyncs.Load(); active > 0 || syncs.Load() != started {
+		t.Errorf("after New() failed, %d syncs are open and %d were started", active, syncs.Load()-started)
+	}
+}
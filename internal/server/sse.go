@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// sseKeepAliveInterval is how often a comment is sent on idle event streams,
+// so that proxies do not close them
+const sseKeepAliveInterval = 30 * time.Second
+
+// sseEventName returns the SSE event name for a stream event, or "" if it is
+// not sent on /events
+func sseEventName(evt *StreamEvent) string {
+	switch {
+	case evt.Type == StreamEventMessage:
+		return "message"
+	case evt.Type == StreamEventRoom && evt.EventType == event.StateMember.Type:
+		return "membership"
+	}
+	return ""
+}
+
+// handleEvents streams handled messages and membership changes as
+// Server-Sent Events. Reconnecting clients resume after their Last-Event-ID.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !hasStreamToken(r, s.cfg().Stream.Token) {
+		s.logger.Warn("Rejecting event stream request with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	room := r.URL.Query().Get("room")
+	lastEventID := r.Header.Get("Last-Event-ID")
+	sub := s.stream.subscribe(s.cfg().Stream.BufferSize, func(evt *StreamEvent) bool {
+		return (room == "" || evt.RoomID == room) && sseEventName(evt) != ""
+	}, lastEventID)
+	defer s.stream.unsubscribe(sub)
+	s.logger.Info("Event stream consumer connected from %s (room: %q, last event: %q)", r.RemoteAddr, room, lastEventID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case evt, ok := <-sub.events:
+			if !ok {
+				s.logger.Info("Closing event stream of %s: fell behind or server shutting down", r.RemoteAddr)
+				return
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				s.logger.Error("Failed to encode stream event %s: %v", evt.ID, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", evt.ID, sseEventName(&evt), data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			s.logger.Info("Event stream consumer %s disconnected", r.RemoteAddr)
+			return
+		}
+	}
+}
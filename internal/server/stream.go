@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Stream event types
+const (
+	StreamEventMessage = "message" // A message handled by the bot
+	StreamEventRoom    = "event"   // Any event of the room
+)
+
+// StreamEvent is a frame sent to stream consumers
+type StreamEvent struct {
+	ID        string    `json:"id"` // Increases with every published event
+	Type      string    `json:"type"`
+	RoomID    string    `json:"room_id"`
+	Sender    string    `json:"sender"`
+	EventID   string    `json:"event_id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Set for handled messages
+	Body       string `json:"body,omitempty"`
+	ThreadRoot string `json:"thread_root,omitempty"`
+	InReplyTo  string `json:"in_reply_to,omitempty"`
+	// Set for room events
+	EventType string          `json:"event_type,omitempty"`
+	StateKey  *string         `json:"state_key,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+
+	sequence uint64 // Numeric part of ID, for resuming
+}
+
+// streamSubscriber receives published events until it unsubscribes or the
+// hub drops it
+type streamSubscriber struct {
+	events chan StreamEvent // Closed when the hub drops the subscriber
+	filter streamFilter
+}
+
+// streamFilter selects the events a consumer receives
+type streamFilter func(evt *StreamEvent) bool
+
+// streamHub fans out events to stream consumers and keeps the most recent
+// ones so that consumers can resume after reconnecting. Consumers that fall
+// more than their buffer behind are dropped rather than slowing down the
+// sync loop.
+type streamHub struct {
+	mutex       sync.Mutex
+	subscribers map[*streamSubscriber]struct{}
+	closed      bool
+	// Event IDs are "<epoch>-<sequence>", the epoch telling apart IDs issued
+	// before a restart
+	epoch       int64
+	sequence    uint64
+	history     []StreamEvent // Oldest first, at most historySize events
+	historySize int
+}
+
+func newStreamHub(historySize int) *streamHub {
+	return &streamHub{
+		subscribers: make(map[*streamSubscriber]struct{}),
+		epoch:       time.Now().Unix(),
+		historySize: historySize,
+	}
+}
+
+// subscribe registers a consumer. If lastEventID is set, the buffered events
+// published after it are delivered first; an ID from before a restart
+// replays everything buffered since. After the hub is closed the returned
+// subscriber's channel is already closed.
+func (h *streamHub) subscribe(bufferSize int, filter streamFilter, lastEventID string) *streamSubscriber {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var replay []StreamEvent
+	if lastEventID != "" {
+		since := h.resumeSequence(lastEventID)
+		for i := range h.history {
+			if h.history[i].sequence > since && filter(&h.history[i]) {
+				replay = append(replay, h.history[i])
+			}
+		}
+	}
+
+	sub := &streamSubscriber{events: make(chan StreamEvent, bufferSize+len(replay)), filter: filter}
+	for _, evt := range replay {
+		sub.events <- evt
+	}
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// resumeSequence returns the sequence to resume after. IDs from another
+// epoch, or that cannot be parsed, resume from the start.
+func (h *streamHub) resumeSequence(lastEventID string) uint64 {
+	var epoch int64
+	var sequence uint64
+	if _, err := fmt.Sscanf(lastEventID, "%d-%d", &epoch, &sequence); err != nil || epoch != h.epoch {
+		return 0
+	}
+	return sequence
+}
+
+// unsubscribe removes a consumer, closing its channel if the hub has not
+// already done so
+func (h *streamHub) unsubscribe(sub *streamSubscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.subscribers[sub]; exists {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish assigns the event an ID, buffers it and sends it to every
+// interested consumer without blocking
+func (h *streamHub) publish(evt StreamEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.sequence++
+	evt.sequence = h.sequence
+	evt.ID = fmt.Sprintf("%d-%d", h.epoch, h.sequence)
+
+	if h.historySize > 0 {
+		if len(h.history) >= h.historySize {
+			h.history = append(h.history[:0], h.history[len(h.history)-h.historySize+1:]...)
+		}
+		h.history = append(h.history, evt)
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter(&evt) {
+			continue
+		}
+		select {
+		case sub.events <- evt:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// close disconnects every consumer and rejects new ones
+func (h *streamHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// newMessageStreamEvent describes a message handled by the bot
+func newMessageStreamEvent(roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID, eventID id.EventID) StreamEvent {
+	return StreamEvent{
+		Type:       StreamEventMessage,
+		RoomID:     string(roomID),
+		Sender:     string(sender),
+		EventID:    string(eventID),
+		Timestamp:  time.Now(),
+		Body:       message,
+		ThreadRoot: string(threadRootEventID),
+		InReplyTo:  string(inReplyToEventID),
+	}
+}
+
+// newRoomStreamEvent describes a raw room event
+func newRoomStreamEvent(evt *event.Event) StreamEvent {
+	return StreamEvent{
+		Type:      StreamEventRoom,
+		RoomID:    string(evt.RoomID),
+		Sender:    string(evt.Sender),
+		EventID:   string(evt.ID),
+		Timestamp: time.UnixMilli(evt.Timestamp),
+		EventType: evt.Type.Type,
+		StateKey:  evt.StateKey,
+		Content:   evt.Content.VeryRaw,
+	}
+}
+
+// HandleEvent publishes room events to stream consumers, relays messages
+// to Telegram, mirrors them to push notifications, acts on reactions to
+// PagerDuty incidents, forwards reactions to replies as feedback, posts
+// the next page of paged replies and expands Jira issue keys
+func (s *Server) HandleEvent(evt *event.Event) {
+	if s.telegram != nil {
+		s.relayToTelegram(evt)
+	}
+	if s.push != nil {
+		s.mirrorToPush(evt)
+	}
+	if s.pagerDuty != nil {
+		s.handlePagerDutyReaction(evt)
+	}
+	if s.feedback != nil {
+		s.handleFeedbackReaction(evt)
+	}
+	if s.pages != nil {
+		s.handlePageReaction(evt)
+	}
+	if len(s.cfg().Confirmations.Commands) > 0 {
+		s.handleConfirmationReaction(evt)
+	}
+	if s.jira != nil && s.cfg().Jira.ExpandKeys {
+		// Looking the issues up must not hold up the sync
+		go s.expandJiraKeys(evt)
+	}
+	if s.translator != nil {
+		go s.autoTranslate(evt)
+	}
+	if s.vision != nil {
+		if content := s.visionContent(evt); content != nil {
+			// The image is handled here rather than as a message mentioning
+			// the bot
+			if mentionsUser(content, id.UserID(s.cfg().Matrix.UserID)) {
+				s.visionImages.Store(evt.ID, true)
+			}
+			// Downloading and reading the image must not hold up the sync
+			go s.handleImage(evt, content)
+		}
+	}
+	if s.stream != nil {
+		s.stream.publish(newRoomStreamEvent(evt))
+	}
+}
+
+// hasStreamToken checks the stream token, which may also be passed as the
+// access_token query parameter since browsers cannot set headers on
+// WebSocket and EventSource requests
+func hasStreamToken(r *http.Request, token string) bool {
+	if provided := r.URL.Query().Get("access_token"); provided != "" {
+		return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+	}
+	return token != "" && hasBearerToken(r, token)
+}
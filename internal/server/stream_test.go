@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"golang.org/x/net/websocket"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func onlyMessages(evt *StreamEvent) bool { return evt.Type == StreamEventMessage }
+
+func allEvents(evt *StreamEvent) bool { return true }
+
+func TestStreamHubPublish(t *testing.T) {
+	hub := newStreamHub(0)
+	messagesOnly := hub.subscribe(4, onlyMessages, "")
+	everything := hub.subscribe(4, allEvents, "")
+
+	hub.publish(StreamEvent{Type: StreamEventRoom, EventID: "$room"})
+	hub.publish(StreamEvent{Type: StreamEventMessage, EventID: "$message"})
+
+	if evt := <-messagesOnly.events; evt.EventID != "$message" {
+		t.Errorf("message subscriber got %s, want $message", evt.EventID)
+	}
+	for _, want := range []string{"$room", "$message"} {
+		if evt := <-everything.events; evt.EventID != want {
+			t.Errorf("room event subscriber got %s, want %s", evt.EventID, want)
+		}
+	}
+}
+
+func TestStreamHubResume(t *testing.T) {
+	hub := newStreamHub(3)
+	for _, eventID := range []string{"$1", "$2", "$3", "$4"} {
+		hub.publish(StreamEvent{Type: StreamEventMessage, EventID: eventID})
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+	}{
+		{"no last event", "", nil},
+		{"within history", fmt.Sprintf("%d-2", hub.epoch), []string{"$3", "$4"}},
+		{"latest", fmt.Sprintf("%d-4", hub.epoch), nil},
+		{"older than history", fmt.Sprintf("%d-0", hub.epoch), []string{"$2", "$3", "$4"}},
+		{"before restart", "1-3", []string{"$2", "$3", "$4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := hub.subscribe(1, allEvents, tt.lastEventID)
+			defer hub.unsubscribe(sub)
+
+			var got []string
+			for len(sub.events) > 0 {
+				got = append(got, (<-sub.events).EventID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamHubDropsSlowSubscriber(t *testing.T) {
+	hub := newStreamHub(0)
+	sub := hub.subscribe(1, onlyMessages, "")
+
+	hub.publish(StreamEvent{Type: StreamEventMessage, EventID: "$1"})
+	hub.publish(StreamEvent{Type: StreamEventMessage, EventID: "$2"})
+
+	if evt := <-sub.events; evt.EventID != "$1" {
+		t.Errorf("got %s, want $1", evt.EventID)
+	}
+	if _, ok := <-sub.events; ok {
+		t.Error("slow subscriber was not dropped")
+	}
+	// Unsubscribing a dropped subscriber must not close its channel twice
+	hub.unsubscribe(sub)
+}
+
+func TestStreamHubClose(t *testing.T) {
+	hub := newStreamHub(0)
+	sub := hub.subscribe(1, onlyMessages, "")
+	hub.close()
+
+	if _, ok := <-sub.events; ok {
+		t.Error("subscriber channel open after close")
+	}
+	if _, ok := <-hub.subscribe(1, onlyMessages, "").events; ok {
+		t.Error("subscribe after close returned an open channel")
+	}
+}
+
+func newStreamTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Stream: config.StreamConfig{Enabled: true, Token: "secret", BufferSize: 8}}
+	s := &Server{config: cfg, router: chi.NewRouter(), logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), stream: newStreamHub(10)}
+	s.routes()
+
+	ts := httptest.NewServer(s.router)
+	t.Cleanup(func() {
+		s.stream.close()
+		ts.Close()
+	})
+	return s, ts
+}
+
+// waitForSubscribers waits until n stream consumers are connected
+func waitForSubscribers(t *testing.T, hub *streamHub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mutex.Lock()
+		subscribers := len(hub.subscribers)
+		hub.mutex.Unlock()
+		if subscribers == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d stream consumers", n)
+}
+
+func TestStreamsRequireToken(t *testing.T) {
+	_, ts := newStreamTestServer(t)
+
+	for _, path := range []string{"/ws", "/events"} {
+		resp, err := http.Get(ts.URL + path + "?access_token=wrong")
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s status = %d, want %d", path, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestWebSocketStreamsEvents(t *testing.T) {
+	s, ts := newStreamTestServer(t)
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?events=all&access_token=secret"
+	conn, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	waitForSubscribers(t, s.stream, 1)
+	stateKey := "@user:example.com"
+	s.HandleEvent(&event.Event{Type: event.StateMember, RoomID: "!room:example.com", ID: "$join", StateKey: &stateKey})
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "hello", "", "", "$message")
+
+	var roomEvent, message StreamEvent
+	if err := websocket.JSON.Receive(conn, &roomEvent); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if roomEvent.Type != StreamEventRoom || roomEvent.EventType != "m.room.member" || roomEvent.EventID != "$join" {
+		t.Errorf("first frame = %+v, want the membership event", roomEvent)
+	}
+
+	if err := websocket.JSON.Receive(conn, &message); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if message.Type != StreamEventMessage || message.Body != "hello" || message.Sender != "@user:example.com" {
+		t.Errorf("second frame = %+v, want the handled message", message)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s, ts := newStreamTestServer(t)
+
+	// Published before the consumer connects, replayed through Last-Event-ID
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "missed", "", "", "$missed")
+	s.HandleMessage("!room:example.com", id.UserID("@user:example.com"), "seen", "", "", "$seen")
+	lastEventID := fmt.Sprintf("%d-1", s.stream.epoch)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/events?room=!room:example.com", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Last-Event-ID", lastEventID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events error = %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	waitForSubscribers(t, s.stream, 1)
+	stateKey := "@user:example.com"
+	s.HandleEvent(&event.Event{Type: event.EventReaction, RoomID: "!room:example.com", ID: "$reaction"})
+	s.HandleEvent(&event.Event{Type: event.StateMember, RoomID: "!other:example.com", ID: "$elsewhere", StateKey: &stateKey})
+	s.HandleEvent(&event.Event{Type: event.StateMember, RoomID: "!room:example.com", ID: "$join", StateKey: &stateKey})
+
+	// Reactions and other rooms are filtered out
+	want := []struct{ name, eventID string }{
+		{"message", "$seen"},
+		{"membership", "$join"},
+	}
+	reader := bufio.NewReader(resp.Body)
+	for _, w := range want {
+		var name string
+		var evt StreamEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading event stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				break
+			}
+			if value, found := strings.CutPrefix(line, "event: "); found {
+				name = value
+			}
+			if value, found := strings.CutPrefix(line, "data: "); found {
+				if err := json.Unmarshal([]byte(value), &evt); err != nil {
+					t.Fatalf("invalid event data %q: %v", value, err)
+				}
+			}
+		}
+		if name != w.name || evt.EventID != w.eventID {
+			t.Errorf("got %s event %s, want %s event %s", name, evt.EventID, w.name, w.eventID)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/telegram"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Relayed messages remembered to relay the replies to them
+const telegramLinkCapacity = 1000
+
+// Time sending a message to Telegram may take
+const telegramSendTimeout = 30 * time.Second
+
+// telegramLinks pairs relayed messages with their copy on the other side,
+// forgetting the oldest pairs past telegramLinkCapacity
+type telegramLinks struct {
+	mu       sync.Mutex
+	messages map[id.EventID]int
+	events   map[int]id.EventID
+	order    []id.EventID
+}
+
+func newTelegramLinks() *telegramLinks {
+	return &telegramLinks{messages: make(map[id.EventID]int), events: make(map[int]id.EventID)}
+}
+
+func (l *telegramLinks) add(eventID id.EventID, messageID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.messages[eventID]; !exists {
+		l.order = append(l.order, eventID)
+	}
+	l.messages[eventID] = messageID
+	l.events[messageID] = eventID
+	if len(l.order) > telegramLinkCapacity {
+		oldest := l.order[0]
+		l.order = l.order[1:]
+		delete(l.events, l.messages[oldest])
+		delete(l.messages, oldest)
+	}
+}
+
+// message returns the Telegram message of a Matrix event
+func (l *telegramLinks) message(eventID id.EventID) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	messageID, exists := l.messages[eventID]
+	return messageID, exists
+}
+
+// event returns the Matrix event of a Telegram message
+func (l *telegramLinks) event(messageID int) (id.EventID, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	eventID, exists := l.events[messageID]
+	return eventID, exists
+}
+
+type telegramMessageKey struct{}
+
+// withTelegramMessage makes replies sent with ctx also go to Telegram, as
+// replies to a message of the relayed chat
+func withTelegramMessage(ctx context.Context, messageID int) context.Context {
+	return context.WithValue(ctx, telegramMessageKey{}, messageID)
+}
+
+// telegramMessage returns the Telegram message replies of ctx answer, 0 if
+// they stay in Matrix
+func telegramMessage(ctx context.Context) int {
+	messageID, _ := ctx.Value(telegramMessageKey{}).(int)
+	return messageID
+}
+
+// telegramRoom returns the room relayed with Telegram
+func (s *Server) telegramRoom() id.RoomID {
+	cfg := s.cfg()
+	if cfg.Telegram.RoomID != "" {
+		return id.RoomID(cfg.Telegram.RoomID)
+	}
+	return id.RoomID(cfg.Matrix.RoomID)
+}
+
+// telegramUser returns the Matrix user a Telegram user runs commands as,
+// looked up by ID, then by @username. Config keys are lower-case.
+func (s *Server) telegramUser(from *telegram.User) (id.UserID, bool) {
+	users := s.cfg().Telegram.Users
+	if userID, exists := users[strconv.FormatInt(from.ID, 10)]; exists {
+		return id.UserID(userID), true
+	}
+	if from.Username != "" {
+		if userID, exists := users["@"+strings.ToLower(from.Username)]; exists {
+			return id.UserID(userID), true
+		}
+	}
+	return "", false
+}
+
+// telegramCommand removes the bot name Telegram appends to commands in
+// groups: /deploy@mule_bot staging becomes /deploy staging
+func telegramCommand(text string) string {
+	first, rest, _ := strings.Cut(text, " ")
+	if command, _, found := strings.Cut(first, "@"); found && strings.HasPrefix(command, "/") {
+		return strings.TrimSpace(command + " " + rest)
+	}
+	return text
+}
+
+// relayFromTelegram posts a message of the Telegram chat to the room. The
+// messages of users in telegram.users are then handled like Matrix messages
+// of their Matrix user, and the replies to them sent to Telegram too.
+func (s *Server) relayFromTelegram(m *telegram.Message) {
+	text := m.Text
+	if text == "" {
+		text = m.Caption
+	}
+	if strings.TrimSpace(text) == "" || m.From == nil {
+		return
+	}
+
+	roomID := s.telegramRoom()
+	var inReplyTo id.EventID
+	if m.ReplyToMessage != nil {
+		inReplyTo, _ = s.telegramLinks.event(m.ReplyToMessage.MessageID)
+	}
+
+	relayed := fmt.Sprintf("<b>%s</b>: %s", html.EscapeString(m.From.Name()), strings.ReplaceAll(html.EscapeString(text), "\n", "<br>"))
+	opts := []matrix.SendMessageOption{matrix.WithRoom(roomID), matrix.WithFormat(matrix.FormatHTML)}
+	if inReplyTo != "" {
+		opts = append(opts, matrix.WithReplyTo(inReplyTo))
+	}
+	eventID, err := s.matrix.SendMessage(relayed, opts...)
+	if err != nil {
+		s.logger.Error("Failed to relay Telegram message %d to Matrix: %v", m.MessageID, err)
+		return
+	}
+	s.telegramLinks.add(eventID, m.MessageID)
+
+	sender, exists := s.telegramUser(m.From)
+	if !exists {
+		return
+	}
+	if s.paused.Load() {
+		s.logger.Info("Message handling is paused, ignoring Telegram message %d from %s", m.MessageID, sender)
+		return
+	}
+	s.processMessage(withTelegramMessage(context.Background(), m.MessageID), roomID, sender, telegramCommand(text), inReplyTo, "", eventID)
+}
+
+// relayToTelegram sends a message of the room to Telegram if it replies to a
+// relayed message, or if telegram.relay_all is set. The bot's own messages
+// are only relayed as replies, by sendReply.
+func (s *Server) relayToTelegram(evt *event.Event) {
+	if evt.Type != event.EventMessage || evt.RoomID != s.telegramRoom() || evt.Sender == id.UserID(s.cfg().Matrix.UserID) {
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.Body == "" || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+
+	var replyTo int
+	if messageID, exists := s.telegramLinks.message(content.RelatesTo.GetReplyTo()); exists {
+		replyTo = messageID
+	} else if messageID, exists := s.telegramLinks.message(content.RelatesTo.GetThreadParent()); exists {
+		replyTo = messageID
+	}
+	if replyTo == 0 && !s.cfg().Telegram.RelayAll {
+		return
+	}
+
+	body := messageText(content)
+	var text string
+	switch content.MsgType {
+	case event.MsgText, event.MsgNotice:
+		text = fmt.Sprintf("%s: %s", evt.Sender.Localpart(), body)
+	case event.MsgEmote:
+		text = fmt.Sprintf("* %s %s", evt.Sender.Localpart(), body)
+	default:
+		text = fmt.Sprintf("%s sent %s", evt.Sender.Localpart(), body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), telegramSendTimeout)
+	defer cancel()
+	messageID, err := s.telegram.Send(ctx, text, replyTo)
+	if err != nil {
+		s.logger.Error("Failed to relay Matrix message %s to Telegram: %v", evt.ID, err)
+		return
+	}
+	s.telegramLinks.add(evt.ID, messageID)
+}
+
+// relayReplyToTelegram sends a reply of the bot to Telegram when it answers
+// a Telegram message
+func (s *Server) relayReplyToTelegram(ctx context.Context, message string, eventID id.EventID) {
+	replyTo := telegramMessage(ctx)
+	if replyTo == 0 || s.telegram == nil {
+		return
+	}
+	sendCtx, cancel := context.WithTimeout(context.Background(), telegramSendTimeout)
+	defer cancel()
+	messageID, err := s.telegram.Send(sendCtx, message, replyTo)
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Failed to relay reply to Telegram: %v", err)
+		return
+	}
+	if eventID != "" {
+		s.telegramLinks.add(eventID, messageID)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/telegram"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTelegramLinks(t *testing.T) {
+	links := newTelegramLinks()
+	for i := 0; i < telegramLinkCapacity+1; i++ {
+		links.add(id.EventID(fmt.Sprintf("$%d", i)), i)
+	}
+
+	if _, exists := links.message("$0"); exists {
+		t.Error("message($0) exists, want the oldest link forgotten")
+	}
+	if _, exists := links.event(0); exists {
+		t.Error("event(0) exists, want the oldest link forgotten")
+	}
+	if messageID, _ := links.message("$1"); messageID != 1 {
+		t.Errorf("message($1) = %d, want 1", messageID)
+	}
+	if eventID, _ := links.event(telegramLinkCapacity); eventID != id.EventID(fmt.Sprintf("$%d", telegramLinkCapacity)) {
+		t.Errorf("event(%d) = %q", telegramLinkCapacity, eventID)
+	}
+}
+
+func TestTelegramCommand(t *testing.T) {
+	tests := map[string]string{
+		"/deploy@mule_bot staging": "/deploy staging",
+		"/status@mule_bot":         "/status",
+		"/deploy staging":          "/deploy staging",
+		"mail alice@example.com":   "mail alice@example.com",
+	}
+	for text, expected := range tests {
+		if got := telegramCommand(text); got != expected {
+			t.Errorf("telegramCommand(%q) = %q, want %q", text, got, expected)
+		}
+	}
+}
+
+func TestTelegramUser(t *testing.T) {
+	s := &Server{config: &config.Config{Telegram: config.TelegramConfig{Users: map[string]string{
+		"42":     "@alice:example.com",
+		"@bobby": "@bob:example.com",
+	}}}}
+
+	tests := []struct {
+		from     telegram.User
+		expected id.UserID
+	}{
+		{telegram.User{ID: 42, Username: "Bobby"}, "@alice:example.com"},
+		{telegram.User{ID: 7, Username: "Bobby"}, "@bob:example.com"},
+		{telegram.User{ID: 7, Username: "mallory"}, ""},
+		{telegram.User{ID: 7}, ""},
+	}
+	for _, tt := range tests {
+		got, exists := s.telegramUser(&tt.from)
+		if got != tt.expected || exists != (tt.expected != "") {
+			t.Errorf("telegramUser(%+v) = %q, %v, want %q", tt.from, got, exists, tt.expected)
+		}
+	}
+}
+
+// telegramTestServer returns a server relaying with a fake Bot API, and the
+// messages sent to it
+func telegramTestServer(t *testing.T, relayAll bool) (*Server, *[]map[string]interface{}) {
+	var sent []map[string]interface{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+		sent = append(sent, params)
+		fmt.Fprintf(w, `{"ok": true, "result": {"message_id": %d}}`, 500+len(sent))
+	}))
+	t.Cleanup(api.Close)
+
+	cfg := &config.Config{
+		Matrix:   config.MatrixConfig{UserID: "@bot:example.com", RoomID: "!room:example.com"},
+		Telegram: config.TelegramConfig{Enabled: true, Token: "TOKEN", ChatID: -100, APIURL: api.URL, PollTimeout: 1, RelayAll: relayAll},
+	}
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	s := &Server{config: cfg, logger: log, telegramLinks: newTelegramLinks()}
+	s.telegram = telegram.NewRelay(&cfg.Telegram, s.relayFromTelegram, log)
+	return s, &sent
+}
+
+func messageEvent(eventID id.EventID, sender id.UserID, content *event.MessageEventContent) *event.Event {
+	return &event.Event{
+		ID:      eventID,
+		Type:    event.EventMessage,
+		RoomID:  "!room:example.com",
+		Sender:  sender,
+		Content: event.Content{Parsed: content},
+	}
+}
+
+func TestRelayToTelegram(t *testing.T) {
+	s, sent := telegramTestServer(t, false)
+	s.telegramLinks.add("$relayed", 7)
+
+	// Not a reply to a relayed message
+	s.relayToTelegram(messageEvent("$1", "@alice:example.com", &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}))
+	// The bot's own messages
+	s.relayToTelegram(messageEvent("$2", "@bot:example.com", &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "bot",
+		RelatesTo: (&event.RelatesTo{}).SetReplyTo("$relayed"),
+	}))
+	if len(*sent) != 0 {
+		t.Fatalf("relayed %v, want nothing", *sent)
+	}
+
+	s.relayToTelegram(messageEvent("$3", "@alice:example.com", &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "> <@bob:example.com> quoted\n\nsure",
+		RelatesTo: (&event.RelatesTo{}).SetReplyTo("$relayed"),
+	}))
+	if len(*sent) != 1 {
+		t.Fatalf("relayed %d messages, want 1", len(*sent))
+	}
+	message := (*sent)[0]
+	if message["text"] != "alice: sure" {
+		t.Errorf("text = %q, want %q", message["text"], "alice: sure")
+	}
+	if reply, _ := message["reply_parameters"].(map[string]interface{}); reply["message_id"] != float64(7) {
+		t.Errorf("reply_parameters = %v, want message_id 7", message["reply_parameters"])
+	}
+	if messageID, _ := s.telegramLinks.message("$3"); messageID != 501 {
+		t.Errorf("message($3) = %d, want 501", messageID)
+	}
+}
+
+func TestRelayToTelegramAll(t *testing.T) {
+	s, sent := telegramTestServer(t, true)
+
+	s.relayToTelegram(messageEvent("$1", "@alice:example.com", &event.MessageEventContent{MsgType: event.MsgEmote, Body: "waves"}))
+	other := messageEvent("$2", "@alice:example.com", &event.MessageEventContent{MsgType: event.MsgText, Body: "elsewhere"})
+	other.RoomID = "!other:example.com"
+	s.relayToTelegram(other)
+
+	if len(*sent) != 1 || (*sent)[0]["text"] != "* alice waves" {
+		t.Errorf("relayed %v, want only %q", *sent, "* alice waves")
+	}
+}
+
+func TestRelayReplyToTelegram(t *testing.T) {
+	s, sent := telegramTestServer(t, false)
+
+	s.relayReplyToTelegram(context.Background(), "not from Telegram", "$1")
+	s.relayReplyToTelegram(withTelegramMessage(context.Background(), 9), "done", "$2")
+
+	if len(*sent) != 1 || (*sent)[0]["text"] != "done" {
+		t.Fatalf("relayed %v, want only %q", *sent, "done")
+	}
+	if eventID, _ := s.telegramLinks.event(501); eventID != "$2" {
+		t.Errorf("event(501) = %q, want $2", eventID)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// notifyTemplateFuncs returns the functions available to notification
+// templates. They follow the names and argument order of the sprig library
+// (github.com/Masterminds/sprig), covering the functions commonly used to
+// format messages, so that templates written for sprig work unchanged.
+func notifyTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Defaults and conditionals
+		"default":  defaultValue,
+		"empty":    isEmpty,
+		"coalesce": coalesce,
+		"ternary": func(whenTrue, whenFalse interface{}, condition bool) interface{} {
+			if condition {
+				return whenTrue
+			}
+			return whenFalse
+		},
+
+		// Strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      titleCase,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, max(count, 0)) },
+		"trunc":      truncate,
+		"abbrev":     abbreviate,
+		"quote":      func(v interface{}) string { return strconv.Quote(toString(v)) },
+		"squote":     func(v interface{}) string { return "'" + toString(v) + "'" },
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       joinList,
+		"toString":   toString,
+
+		// Lists and dicts
+		"list": func(items ...interface{}) []interface{} { return items },
+		"dict": dict,
+		"keys": keys,
+		"has":  has,
+
+		// Numbers, which JSON input decodes as float64
+		"add":   func(a, b interface{}) float64 { return toFloat(a) + toFloat(b) },
+		"sub":   func(a, b interface{}) float64 { return toFloat(a) - toFloat(b) },
+		"mul":   func(a, b interface{}) float64 { return toFloat(a) * toFloat(b) },
+		"div":   func(a, b interface{}) float64 { return toFloat(a) / toFloat(b) },
+		"round": func(v interface{}, places int) float64 { return round(toFloat(v), places) },
+		"int":   func(v interface{}) int64 { return int64(toFloat(v)) },
+		"float": toFloat,
+
+		// Dates
+		"now":  time.Now,
+		"date": formatDate,
+
+		// Encoding
+		"toJson": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"toPrettyJson": func(v interface{}) (string, error) {
+			data, err := json.MarshalIndent(v, "", "  ")
+			return string(data), err
+		},
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+}
+
+// isEmpty reports whether v is nil or the zero value of its type, with empty
+// strings, slices and maps counting as empty
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	default:
+		return value.IsZero()
+	}
+}
+
+// defaultValue returns given, or def if given is empty
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || isEmpty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// coalesce returns the first value that is not empty
+func coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// titleCase upper-cases the first letter of every word
+func titleCase(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToTitle(r)
+		}
+	}
+	return string(runes)
+}
+
+// truncate keeps the first length runes of s, or the last ones if length is
+// negative
+func truncate(length int, s string) string {
+	runes := []rune(s)
+	if length < 0 && -length < len(runes) {
+		return string(runes[len(runes)+length:])
+	}
+	if length >= 0 && length < len(runes) {
+		return string(runes[:length])
+	}
+	return s
+}
+
+// abbreviate truncates s to width runes, ending it with "..." if shortened
+func abbreviate(width int, s string) string {
+	runes := []rune(s)
+	if width < 4 || len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+func indent(spaces int, s string) string {
+	padding := strings.Repeat(" ", max(spaces, 0))
+	return padding + strings.ReplaceAll(s, "\n", "\n"+padding)
+}
+
+// joinList joins the items of any list, such as a JSON array
+func joinList(sep string, list interface{}) string {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return toString(list)
+	}
+	items := make([]string, value.Len())
+	for i := range items {
+		items[i] = toString(value.Index(i).Interface())
+	}
+	return strings.Join(items, sep)
+}
+
+// toString formats v without the "<nil>" and exponent notation that fmt
+// produces for JSON nulls and large numbers
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// dict builds a map from alternating keys and values
+func dict(pairs ...interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result[toString(pairs[i])] = pairs[i+1]
+	}
+	return result
+}
+
+// keys returns the sorted keys of a map
+func keys(m map[string]interface{}) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// has reports whether a list contains the value
+func has(needle interface{}, list interface{}) bool {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return false
+	}
+	for i := 0; i < value.Len(); i++ {
+		if reflect.DeepEqual(value.Index(i).Interface(), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat converts numbers and numeric strings, anything else is 0
+func toFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case float64:
+		return val
+	case float32:
+		return float64(val)
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// formatDate formats a time.Time, an RFC 3339 string or Unix seconds with a
+// Go layout
+func formatDate(layout string, v interface{}) string {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format(layout)
+	case string:
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			return t.Format(layout)
+		}
+		return val
+	default:
+		seconds := toFloat(val)
+		return time.Unix(int64(seconds), 0).UTC().Format(layout)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestPayloadTemplateOptions(t *testing.T) {
+	payloads := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- string(body)
+	}))
+	defer target.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	message := "say \"hi\"\nthen leave"
+	tests := []struct {
+		name     string
+		command  string
+		message  string
+		options  config.PayloadTemplateConfig
+		expected string // Empty if the dispatch fails
+	}{
+		{"json helper", "", message, config.PayloadTemplateConfig{}, `{"text": {{json .MESSAGE}}}`},
+		{"Escaping of the command", "deploy", message, config.PayloadTemplateConfig{Escape: webhook.EscapeNone, CommandEscapes: map[string]string{"deploy": webhook.EscapeJSON}}, `{"text": "{{.MESSAGE}}"}`},
+		{"Missing key rendered empty", "", "hi", config.PayloadTemplateConfig{MissingKey: "zero"}, `{"text": "{{.MESSAGE}}", "user": "{{.USER}}"}`},
+		{"Missing key fails", "", "hi", config.PayloadTemplateConfig{MissingKey: "error"}, ""},
+		{"Empty message fails in strict mode", "", "", config.PayloadTemplateConfig{Strict: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := tt.expected
+			if template == "" {
+				template = `{"text": "{{.MESSAGE}}", "user": "{{.USER}}"}`
+			}
+			cfg := &config.WebhookConfig{
+				Default:         target.URL,
+				Commands:        map[string]string{"deploy": target.URL},
+				Template:        template,
+				Timeout:         5,
+				TemplateOptions: tt.options,
+			}
+			_, err := webhook.New(cfg, nil, log).Dispatch(context.Background(), tt.message, tt.command)
+			if tt.expected == "" {
+				if err == nil {
+					t.Fatalf("Dispatch() sent %s, want an error", <-payloads)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			payload := <-payloads
+			if !strings.Contains(payload, `"text": "`+strings.ReplaceAll(strings.ReplaceAll(tt.message, `"`, `\"`), "\n", `\n`)+`"`) {
+				t.Errorf("payload = %s, want the message escaped", payload)
+			}
+			if strings.Contains(payload, "<no value>") {
+				t.Errorf("payload = %s, want the missing key empty", payload)
+			}
+		})
+	}
+}
+
+func TestDryRunWarnings(t *testing.T) {
+	cfg := &config.WebhookConfig{
+		Template:         `{"text": "{{.MESSAGE}}"}`,
+		Commands:         map[string]string{"deploy": "http://localhost/deploy"},
+		CommandTemplates: map[string]string{"deploy": `{"text": {{json .MESSAGE}}}`, "shell": "sh -c {{.MESSAGE}}"},
+	}
+	rooms := []config.RoomConfig{{RoomID: "!ops:example.com", Webhook: config.RoomWebhookConfig{Template: `{"text": "{{jsonescape .MESSAGE}}"}`}}}
+	warnings, err := webhook.DryRun(cfg, rooms)
+	if err != nil {
+		t.Fatalf("DryRun() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "webhook.template:") {
+		t.Errorf("warnings = %q, want one for webhook.template", warnings)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"maunium.net/go/mautrix/id"
+)
+
+// defaultSessionDir keeps the session files of rooms of no tenant, and those
+// of tenants without session_dir in subdirectories
+const defaultSessionDir = "/tmp/pi-sessions"
+
+// tenant is a group of rooms with its own webhooks, sessions and rate limit.
+// Tenants are set up at startup, a reload keeps them.
+type tenant struct {
+	config   *config.TenantConfig
+	sessions *session.Manager
+	limiter  *rateLimiter
+	messages atomic.Int64 // Messages handled in the tenant's rooms
+	limited  atomic.Int64 // Messages dropped by the tenant's rate limit
+}
+
+// newSessionManager creates a session manager with the session settings of
+// cfg, keeping its session files in dir
+func newSessionManager(cfg *config.WebhookConfig, dir string, allowlist *session.Allowlist, log *logger.Logger) (*session.Manager, error) {
+	sessionMgr := session.NewManager(log, cfg.SessionTimeout, cfg.DefaultCommand, dir)
+	sessionMgr.SetQueueDepth(cfg.CommandQueueDepth)
+	sessionMgr.SetMaxSessions(cfg.MaxSessions)
+	sessionMgr.SetTranscriptLimit(cfg.Transcript.MaxEntries)
+	sessionMgr.SetAllowlist(allowlist)
+	if err := sessionMgr.SetExecMode(cfg.ExecMode); err != nil {
+		sessionMgr.Stop()
+		return nil, fmt.Errorf("invalid exec_mode: %w", err)
+	}
+	return sessionMgr, nil
+}
+
+// newTenants sets up the tenants of cfg and indexes them by room
+func newTenants(cfg *config.Config, allowlist *session.Allowlist, log *logger.Logger) ([]*tenant, map[id.RoomID]*tenant, error) {
+	tenants := make([]*tenant, 0, len(cfg.Tenants))
+	byRoom := make(map[id.RoomID]*tenant)
+	for i := range cfg.Tenants {
+		tenantCfg := &cfg.Tenants[i]
+		dir := tenantCfg.SessionDir
+		if dir == "" {
+			dir = filepath.Join(defaultSessionDir, tenantCfg.Name)
+		}
+		sessionMgr, err := newSessionManager(cfg.TenantWebhook(tenantCfg), dir, allowlist, log.With("tenant", tenantCfg.Name))
+		if err != nil {
+			for _, t := range tenants {
+				t.sessions.Stop()
+			}
+			return nil, nil, fmt.Errorf("tenant %s: %w", tenantCfg.Name, err)
+		}
+		t := &tenant{config: tenantCfg, sessions: sessionMgr, limiter: newRateLimiter()}
+		tenants = append(tenants, t)
+		for _, roomID := range tenantCfg.Rooms {
+			byRoom[id.RoomID(roomID)] = t
+		}
+	}
+	return tenants, byRoom, nil
+}
+
+// tenantOf returns the tenant of a room, nil if the room belongs to none
+func (s *Server) tenantOf(roomID id.RoomID) *tenant {
+	return s.tenantRooms[roomID]
+}
+
+// sessionsFor returns the session manager of the messages of a room
+func (s *Server) sessionsFor(roomID id.RoomID) *session.Manager {
+	if t := s.tenantOf(roomID); t != nil {
+		return t.sessions
+	}
+	return s.sessionMgr
+}
+
+// sessionManagers returns the global session manager and those of the
+// tenants
+func (s *Server) sessionManagers() []*session.Manager {
+	managers := []*session.Manager{s.sessionMgr}
+	for _, t := range s.tenants {
+		managers = append(managers, t.sessions)
+	}
+	return managers
+}
+
+// allowTenantMessage counts a message of a tenant's room and reports
+// whether it is within the tenant's rate limit. Messages of rooms of no
+// tenant are always allowed.
+func allowTenantMessage(t *tenant) bool {
+	if t == nil {
+		return true
+	}
+	if t.config.RateLimit > 0 {
+		if allowed, _ := t.limiter.allow(t.config.Name, t.config.RateLimit, max(t.config.RateLimitBurst, 1)); !allowed {
+			t.limited.Add(1)
+			return false
+		}
+	}
+	t.messages.Add(1)
+	return true
+}
+
+// writeTenantMetrics writes the messages and sessions of every tenant,
+// labeled by tenant name
+func writeTenantMetrics(w io.Writer, tenants []*tenant) {
+	if len(tenants) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP matrix_tenant_messages_total Messages handled in the rooms of a tenant.")
+	fmt.Fprintln(w, "# TYPE matrix_tenant_messages_total counter")
+	for _, t := range tenants {
+		fmt.Fprintf(w, "matrix_tenant_messages_total{tenant=\"%s\"} %d\n", escapeLabel(t.config.Name), t.messages.Load())
+	}
+	fmt.Fprintln(w, "# HELP matrix_tenant_rate_limited_total Messages dropped by the rate limit of a tenant.")
+	fmt.Fprintln(w, "# TYPE matrix_tenant_rate_limited_total counter")
+	for _, t := range tenants {
+		fmt.Fprintf(w, "matrix_tenant_rate_limited_total{tenant=\"%s\"} %d\n", escapeLabel(t.config.Name), t.limited.Load())
+	}
+	fmt.Fprintln(w, "# HELP matrix_tenant_sessions Live sessions of a tenant.")
+	fmt.Fprintln(w, "# TYPE matrix_tenant_sessions gauge")
+	for _, t := range tenants {
+		fmt.Fprintf(w, "matrix_tenant_sessions{tenant=\"%s\"} %d\n", escapeLabel(t.config.Name), t.sessions.GetSessionCount())
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTenantWebhooks(t *testing.T) {
+	type dispatch struct {
+		target string
+		path   string
+		auth   string
+	}
+	dispatches := make(chan dispatch, 10)
+	newTarget := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dispatches <- dispatch{target: name, path: r.URL.Path, auth: r.Header.Get("Authorization")}
+		}))
+	}
+	global := newTarget("global")
+	defer global.Close()
+	team := newTarget("team")
+	defer team.Close()
+
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			Default:    global.URL + "/default",
+			Commands:   map[string]string{"deploy": global.URL + "/deploy"},
+			Template:   `{"message": "{{.MESSAGE}}"}`,
+			AuthTokens: map[string]string{"deploy": "Bearer global"},
+		},
+		Tenants: []config.TenantConfig{{
+			Name:       "team",
+			Rooms:      []string{"!team:example.com"},
+			SessionDir: t.TempDir(),
+			Webhook: config.TenantWebhookConfig{
+				Default:     team.URL + "/default",
+				Commands:    map[string]string{"status": team.URL + "/status"},
+				Template:    `{"text": "{{.MESSAGE}}"}`,
+				AuthTokens:  map[string]string{"team": "Bearer team"},
+				DefaultAuth: "team",
+			},
+		}},
+	}
+	tenants, tenantRooms, err := newTenants(cfg, nil, log)
+	if err != nil {
+		t.Fatalf("newTenants() error = %v", err)
+	}
+	defer tenants[0].sessions.Stop()
+	s := &Server{config: cfg, logger: log, webhook: webhook.New(&cfg.Webhook, nil, log), tenants: tenants, tenantRooms: tenantRooms}
+
+	s.HandleMessage("!other:example.com", id.UserID("@user:example.com"), "/deploy now", "", "", "$1")
+	s.HandleMessage("!team:example.com", id.UserID("@user:example.com"), "/status", "", "", "$2")
+	// The global commands are not available to the tenant
+	s.HandleMessage("!team:example.com", id.UserID("@user:example.com"), "/deploy now", "", "", "$3")
+
+	expected := []dispatch{
+		{target: "global", path: "/deploy", auth: "Bearer global"},
+		{target: "team", path: "/status", auth: "Bearer team"},
+		{target: "team", path: "/default", auth: "Bearer team"},
+	}
+	for _, want := range expected {
+		select {
+		case got := <-dispatches:
+			if got != want {
+				t.Errorf("dispatch = %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dispatch %+v not received", want)
+		}
+	}
+	if got := tenants[0].messages.Load(); got != 2 {
+		t.Errorf("messages of the tenant = %d, want 2", got)
+	}
+}
+
+func TestTenantSessions(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Tenants: []config.TenantConfig{{Name: "team", Rooms: []string{"!team:example.com"}, SessionDir: t.TempDir()}}}
+	tenants, tenantRooms, err := newTenants(cfg, nil, log)
+	if err != nil {
+		t.Fatalf("newTenants() error = %v", err)
+	}
+	defer tenants[0].sessions.Stop()
+	sessionMgr := session.NewManager(log, 600, "echo {{.MESSAGE}}", t.TempDir())
+	defer sessionMgr.Stop()
+	s := &Server{config: cfg, logger: log, sessionMgr: sessionMgr, tenants: tenants, tenantRooms: tenantRooms}
+
+	user := id.UserID("@user:example.com")
+	if s.sessionsFor("!team:example.com") != tenants[0].sessions || s.sessionsFor("!other:example.com") != sessionMgr {
+		t.Fatal("sessionsFor() does not pick the session manager of the room's tenant")
+	}
+	s.sessionsFor("!team:example.com").GetOrCreateSession("", user, "echo {{.MESSAGE}}")
+	if s.sessionsFor("!other:example.com").GetSessionForUser(user) != nil {
+		t.Error("the session of a tenant's room is visible outside the tenant")
+	}
+
+	sessions := s.listSessions()
+	if len(sessions) != 1 || sessions[0].Tenant != "team" {
+		t.Errorf("listSessions() = %+v, want one session of tenant team", sessions)
+	}
+}
+
+func TestTenantDefaultCommand(t *testing.T) {
+	tenantConfig := func() *config.Config {
+		cfg := validTestConfig()
+		cfg.Webhook.EnableCommands = true
+		cfg.Webhook.SessionTimeout = 600
+		cfg.Webhook.DefaultCommand = "echo global {{.MESSAGE}}"
+		cfg.Rooms = []config.RoomConfig{{RoomID: "!team:example.com"}, {RoomID: "!ops:example.com"}}
+		cfg.Tenants = []config.TenantConfig{
+			{Name: "team", Rooms: []string{"!team:example.com"}, SessionDir: t.TempDir()},
+			{Name: "ops", Rooms: []string{"!ops:example.com"}, SessionDir: t.TempDir(), Webhook: config.TenantWebhookConfig{DefaultCommand: "echo ops {{.MESSAGE}}"}},
+		}
+		return cfg
+	}
+	cfg := tenantConfig()
+	s := newAdminTestServer(t, cfg)
+	tenants, tenantRooms, err := newTenants(cfg, nil, s.logger)
+	if err != nil {
+		t.Fatalf("newTenants() error = %v", err)
+	}
+	for _, tenant := range tenants {
+		defer tenant.sessions.Stop()
+	}
+	s.tenants, s.tenantRooms = tenants, tenantRooms
+
+	// A reload does not hand the global default command to the tenants
+	next := tenantConfig()
+	next.Webhook.DefaultCommand = "echo changed {{.MESSAGE}}"
+	s.loadConfig = func() (*config.Config, error) { return next, nil }
+	if _, err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	tests := []struct {
+		room        id.RoomID
+		wantDefault string
+		want        string
+		wantErr     string
+	}{
+		{"!room:example.com", "echo changed {{.MESSAGE}}", "changed hi", ""},
+		{"!ops:example.com", "echo ops {{.MESSAGE}}", "ops hi", ""},
+		// A tenant without a default command refuses the message
+		{"!team:example.com", "", "", "no command template configured"},
+	}
+	for _, tt := range tests {
+		if got := defaultCommand(tt.room, s.roomSettings(tt.room), s.cfg()); got != tt.wantDefault {
+			t.Errorf("default command of %s = %q, want %q", tt.room, got, tt.wantDefault)
+		}
+		sessions := s.sessionsFor(tt.room)
+		sess := sessions.GetOrCreateSession("", id.UserID("@user:example.com"), "")
+		output, err := sessions.ExecuteCommand(sess, "hi")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("command in %s = %q, %v, want it refused", tt.room, output, err)
+			}
+			continue
+		}
+		if err != nil || strings.TrimSpace(output) != tt.want {
+			t.Errorf("command in %s = %q, %v, want %q", tt.room, output, err, tt.want)
+		}
+	}
+}
+
+func TestTenantRateLimit(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Tenants: []config.TenantConfig{{Name: "team", Rooms: []string{"!team:example.com"}, SessionDir: t.TempDir(), RateLimit: 0.001, RateLimitBurst: 2}}}
+	tenants, _, err := newTenants(cfg, nil, log)
+	if err != nil {
+		t.Fatalf("newTenants() error = %v", err)
+	}
+	defer tenants[0].sessions.Stop()
+
+	for i, want := range []bool{true, true, false} {
+		if got := allowTenantMessage(tenants[0]); got != want {
+			t.Errorf("allowTenantMessage() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if !allowTenantMessage(nil) {
+		t.Error("allowTenantMessage() limited a room of no tenant")
+	}
+
+	var buf bytes.Buffer
+	writeTenantMetrics(&buf, tenants)
+	for _, want := range []string{
+		`matrix_tenant_messages_total{tenant="team"} 2`,
+		`matrix_tenant_rate_limited_total{tenant="team"} 1`,
+		`matrix_tenant_sessions{tenant="team"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/session"
+	"maunium.net/go/mautrix/id"
+)
+
+// isExportCommand reports whether the message is an /export command
+func isExportCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/export"
+}
+
+// handleExport posts the transcript of the session a message continues as a
+// file: /export [markdown|jsonl]
+func (s *Server) handleExport(ctx context.Context, roomID id.RoomID, sender id.UserID, message string, inReplyToEventID id.EventID, threadRootEventID id.EventID) {
+	replyEventID := threadRootEventID
+	if replyEventID == "" {
+		replyEventID = inReplyToEventID
+	}
+	reply := func(text string) { s.sendReply(ctx, text, sender, replyEventID) }
+
+	transcript := s.cfg().Webhook.Transcript
+	format := transcript.Format
+	switch fields := strings.Fields(message); len(fields) {
+	case 1:
+	case 2:
+		format = fields[1]
+	default:
+		reply(s.text(roomID, "export.usage"))
+		return
+	}
+	if format != "" && format != session.TranscriptMarkdown && format != session.TranscriptJSONL {
+		reply(s.text(roomID, "export.usage"))
+		return
+	}
+	if transcript.MaxEntries == 0 {
+		reply(s.text(roomID, "export.disabled"))
+		return
+	}
+
+	sessions := s.sessionsFor(roomID)
+	existingSession := s.findExistingSession(ctx, sessions, sender, inReplyToEventID, threadRootEventID)
+	if existingSession == nil {
+		reply(s.text(roomID, "export.no_session"))
+		return
+	}
+	if sessionOwnershipEnforced(s.roomSettings(roomID), s.cfg()) && !sessions.CanUseSession(existingSession, sender) {
+		s.logger.Ctx(ctx).Warn("User %s tried to export session %s owned by %s", sender, existingSession.ID, existingSession.UserID)
+		reply(s.text(roomID, "export.not_owner", existingSession.UserID))
+		return
+	}
+	entries, _ := sessions.Transcript(existingSession)
+	if len(entries) == 0 {
+		reply(s.text(roomID, "export.empty"))
+		return
+	}
+
+	data, filename, mimeType, err := sessions.RenderTranscript(existingSession, format)
+	if err == nil {
+		opts := []matrix.SendMessageOption{matrix.WithLogContext(ctx), matrix.WithRoom(roomID), matrix.WithSender(replySender(ctx))}
+		if replyEventID != "" {
+			opts = append(opts, matrix.WithReplyTo(replyEventID))
+		}
+		_, err = s.matrix.SendMedia(data, filename, mimeType, s.text(roomID, "export.caption", existingSession.ID, len(entries)), opts...)
+	}
+	if err != nil {
+		s.logger.Ctx(ctx).Error("Failed to export the transcript of session %s: %v", existingSession.ID, err)
+		reply(s.text(roomID, "export.failed", err))
+	}
+}
+
+// exportExpiredSession posts the transcript of a session that expired or
+// was evicted to the room and thread of its last command, if
+// webhook.transcript.export_on_expiry is set
+func (s *Server) exportExpiredSession(sessions *session.Manager, sess *session.Session) {
+	transcript := s.cfg().Webhook.Transcript
+	if !transcript.ExportOnExpiry {
+		return
+	}
+	entries, _ := sessions.Transcript(sess)
+	if len(entries) == 0 {
+		return
+	}
+	last := entries[len(entries)-1]
+	roomID := id.RoomID(last.RoomID)
+
+	data, filename, mimeType, err := sessions.RenderTranscript(sess, transcript.Format)
+	if err != nil {
+		s.logger.Error("Failed to render the transcript of expired session %s: %v", sess.ID, err)
+		return
+	}
+	opts := []matrix.SendMessageOption{matrix.WithRoom(roomID)}
+	if last.Thread != "" {
+		opts = append(opts, matrix.WithThread(id.EventID(last.Thread)))
+	}
+	caption := s.text(roomID, "export.expired_caption", sess.ID, sess.UserID, len(entries))
+	if _, err := s.matrix.SendMedia(data, filename, mimeType, caption, opts...); err != nil {
+		s.logger.Error("Failed to post the transcript of expired session %s: %v", sess.ID, err)
+		return
+	}
+	s.logger.Info("Posted the transcript of expired session %s to %s", sess.ID, roomID)
+}
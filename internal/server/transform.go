@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"golang.org/x/text/unicode/norm"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// inboundTransform is a compiled rule of inbound_transforms
+type inboundTransform struct {
+	name  string
+	rooms map[id.RoomID]bool // nil = every room
+	// step returns the transformed message, false to drop it
+	step func(message string, reply bool) (string, bool)
+}
+
+// compileInboundTransforms compiles the rules of inbound_transforms in order
+func compileInboundTransforms(rules []config.TransformRule) ([]*inboundTransform, error) {
+	compiled := make([]*inboundTransform, 0, len(rules))
+	for i, rule := range rules {
+		t := &inboundTransform{name: rule.Name}
+		if t.name == "" {
+			t.name = strconv.Itoa(i)
+		}
+		if len(rule.Rooms) > 0 {
+			t.rooms = make(map[id.RoomID]bool, len(rule.Rooms))
+			for _, roomID := range rule.Rooms {
+				t.rooms[id.RoomID(roomID)] = true
+			}
+		}
+
+		switch {
+		case rule.Regex != "":
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("inbound_transforms[%d].regex: %w", i, err)
+			}
+			replace := rule.Replace
+			t.step = func(message string, reply bool) (string, bool) {
+				return re.ReplaceAllString(message, replace), true
+			}
+		case rule.Drop != "":
+			re, err := regexp.Compile(rule.Drop)
+			if err != nil {
+				return nil, fmt.Errorf("inbound_transforms[%d].drop: %w", i, err)
+			}
+			t.step = func(message string, reply bool) (string, bool) {
+				return message, !re.MatchString(message)
+			}
+		case rule.StripQuotes != "":
+			all := rule.StripQuotes == "all"
+			t.step = func(message string, reply bool) (string, bool) {
+				return stripQuotes(message, reply, all), true
+			}
+		case rule.Normalize != "":
+			form, err := normalForm(rule.Normalize)
+			if err != nil {
+				return nil, fmt.Errorf("inbound_transforms[%d].normalize: %w", i, err)
+			}
+			t.step = func(message string, reply bool) (string, bool) {
+				return form.String(message), true
+			}
+		default:
+			return nil, fmt.Errorf("inbound_transforms[%d]: exactly one of regex, strip_quotes, normalize or drop must be set", i)
+		}
+		compiled = append(compiled, t)
+	}
+	return compiled, nil
+}
+
+// normalForm returns the Unicode normal form of the name
+func normalForm(name string) (norm.Form, error) {
+	switch name {
+	case "NFC":
+		return norm.NFC, nil
+	case "NFD":
+		return norm.NFD, nil
+	case "NFKC":
+		return norm.NFKC, nil
+	case "NFKD":
+		return norm.NFKD, nil
+	default:
+		return 0, fmt.Errorf("%q is not one of NFC, NFD, NFKC or NFKD", name)
+	}
+}
+
+// stripQuotes removes the quote of the replied-to message from a reply and,
+// if all is set, every other line starting with >
+func stripQuotes(message string, reply, all bool) string {
+	if reply {
+		message = event.TrimReplyFallbackText(message)
+	}
+	if !all {
+		return message
+	}
+	lines := strings.Split(message, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// transformMessage applies the rules of inbound_transforms that apply in the
+// room, in order. It returns the name of the rule that dropped the message,
+// or that left nothing of it, empty if the message is kept.
+func transformMessage(transforms []*inboundTransform, roomID id.RoomID, message string, reply bool) (string, string) {
+	for _, t := range transforms {
+		if t.rooms != nil && !t.rooms[roomID] {
+			continue
+		}
+		var keep bool
+		if message, keep = t.step(message, reply); !keep || strings.TrimSpace(message) == "" {
+			return "", t.name
+		}
+	}
+	return strings.TrimSpace(message), ""
+}
+
+// transformDrops counts the messages dropped by each rule of
+// inbound_transforms
+type transformDrops struct {
+	mutex  sync.Mutex
+	byRule map[string]uint64
+}
+
+func (d *transformDrops) add(rule string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.byRule == nil {
+		d.byRule = make(map[string]uint64)
+	}
+	d.byRule[rule]++
+}
+
+func (d *transformDrops) snapshot() map[string]uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	counts := make(map[string]uint64, len(d.byRule))
+	for rule, count := range d.byRule {
+		counts[rule] = count
+	}
+	return counts
+}
+
+// inboundTransforms returns the compiled rules of inbound_transforms
+func (s *Server) inboundTransforms() []*inboundTransform {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.transforms
+}
+
+// writeTransformMetrics writes the messages dropped by each rule of
+// inbound_transforms
+func writeTransformMetrics(w io.Writer, drops map[string]uint64) {
+	rules := make([]string, 0, len(drops))
+	for rule := range drops {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	fmt.Fprintln(w, "# HELP matrix_inbound_transform_drops_total Messages dropped by a rule of inbound_transforms.")
+	fmt.Fprintln(w, "# TYPE matrix_inbound_transform_drops_total counter")
+	for _, rule := range rules {
+		fmt.Fprintf(w, "matrix_inbound_transform_drops_total{rule=\"%s\"} %d\n", escapeLabel(rule), drops[rule])
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTransformMessage(t *testing.T) {
+	transforms, err := compileInboundTransforms([]config.TransformRule{
+		{StripQuotes: "fallback"},
+		{Normalize: "NFKC"},
+		{Regex: `(?i)^hey bot,?\s*`, Replace: ""},
+		{Name: "spam", Drop: `(?i)free crypto`},
+		{Rooms: []string{"!ops:example.com"}, Regex: `^deploy`, Replace: "/deploy"},
+	})
+	if err != nil {
+		t.Fatalf("compileInboundTransforms() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		roomID  string
+		message string
+		reply   bool
+		want    string
+		dropped string
+	}{
+		{"Unchanged", "!chat:example.com", "/status", false, "/status", ""},
+		{"Regex replace", "!chat:example.com", "Hey bot, /status", false, "/status", ""},
+		{"Reply fallback", "!chat:example.com", "> <@alice:example.com> earlier\n\n/status", true, "/status", ""},
+		{"Quote kept in a message that is no reply", "!chat:example.com", "> quoted\n\nsee above", false, "> quoted\n\nsee above", ""},
+		{"Unicode normalized", "!chat:example.com", "ｓｔａｔｕｓ", false, "status", ""},
+		{"Spam dropped", "!chat:example.com", "FREE CRYPTO here", false, "", "spam"},
+		{"Nothing left", "!chat:example.com", "hey bot", false, "", "2"},
+		{"Room rule", "!ops:example.com", "deploy api", false, "/deploy api", ""},
+		{"Room rule in another room", "!chat:example.com", "deploy api", false, "deploy api", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := transformMessage(transforms, id.RoomID(tt.roomID), tt.message, tt.reply)
+			if got != tt.want || dropped != tt.dropped {
+				t.Errorf("transformMessage() = %q, dropped by %q, want %q, dropped by %q", got, dropped, tt.want, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestStripAllQuotes(t *testing.T) {
+	message := "> <@alice:example.com> earlier\n\n/summarize\n> a quote\nthis"
+	if got := stripQuotes(message, true, true); strings.TrimSpace(got) != "/summarize\nthis" {
+		t.Errorf("stripQuotes() = %q", got)
+	}
+}
+
+func TestTransformMetrics(t *testing.T) {
+	var drops transformDrops
+	drops.add("spam")
+	drops.add("spam")
+	drops.add("0")
+
+	var b strings.Builder
+	writeTransformMetrics(&b, drops.snapshot())
+	for _, want := range []string{`matrix_inbound_transform_drops_total{rule="0"} 1`, `matrix_inbound_transform_drops_total{rule="spam"} 2`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Metrics missing %q:\n%s", want, b.String())
+		}
+	}
+}
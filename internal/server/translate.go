@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/translate"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Language codes such as de, EN-GB or pt-BR
+var languageRegex = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+// isTranslateCommand reports whether the message is a /translate command
+func isTranslateCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && fields[0] == "/translate"
+}
+
+// handleTranslateCommand translates the text of the message and posts the
+// translation in a thread on it:
+// /translate <language> <text>
+func (s *Server) handleTranslateCommand(ctx context.Context, sender id.UserID, eventID id.EventID, message string, threadRootEventID id.EventID) {
+	log := s.logger.Ctx(ctx)
+	reply := func(text string) { s.sendTranslation(ctx, text, sender, eventID, threadRootEventID) }
+	roomID := replyRoom(ctx)
+
+	fields := strings.Fields(message)
+	if len(fields) < 3 || !languageRegex.MatchString(fields[1]) {
+		reply(s.text(roomID, "translate.usage"))
+		return
+	}
+	targetLang := fields[1]
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), fields[0]))
+	text := strings.TrimSpace(strings.TrimPrefix(rest, targetLang))
+	if maxLength := s.cfg().Translate.MaxLength; utf8.RuneCountInString(text) > maxLength {
+		reply(s.text(roomID, "translate.too_long", maxLength))
+		return
+	}
+
+	translation, err := s.translator.Translate(ctx, text, targetLang)
+	if err != nil {
+		log.Error("Failed to translate to %s: %v", targetLang, err)
+		reply(s.text(roomID, "translate.failed", err))
+		return
+	}
+	log.Info("Translated a message of %s from %s to %s", sender, translation.SourceLang, targetLang)
+	reply(formatTranslation(translation, targetLang))
+}
+
+// sendTranslation posts a translation in the thread of the message it
+// translates, starting one if the message is not in a thread
+func (s *Server) sendTranslation(ctx context.Context, text string, sender id.UserID, eventID, threadRootEventID id.EventID) {
+	if threadRootEventID == "" {
+		threadRootEventID = eventID
+	}
+	opts := append(replyOptions(ctx, sender, eventID), matrix.WithThread(threadRootEventID))
+	if _, err := s.matrix.SendMessage(text, opts...); err != nil {
+		s.logger.Ctx(ctx).Error("Failed to send translation to Matrix: %v", err)
+	}
+}
+
+// formatTranslation renders a translation with its languages, e.g.
+// _EN → DE:_ Guten Morgen
+func formatTranslation(translation *translate.Translation, targetLang string) string {
+	source := strings.ToUpper(translation.SourceLang)
+	if source == "" {
+		source = "?"
+	}
+	return fmt.Sprintf("_%s → %s:_ %s", source, strings.ToUpper(targetLang), translation.Text)
+}
+
+// autoTranslate translates the messages of rooms with auto_translate to
+// their language, in a thread on each message. Messages already in that
+// language, the bot's own messages, notices, edits and commands are not
+// translated.
+func (s *Server) autoTranslate(evt *event.Event) {
+	room := s.roomSettings(evt.RoomID)
+	if room == nil || room.AutoTranslate == "" {
+		return
+	}
+	cfg := s.cfg()
+	if evt.Type != event.EventMessage || evt.Sender == id.UserID(cfg.Matrix.UserID) || !room.AllowsUser(string(evt.Sender)) {
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.MsgType != event.MsgText || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+	text := strings.TrimSpace(messageText(content))
+	if text == "" || strings.HasPrefix(text, "/") || utf8.RuneCountInString(text) > cfg.Translate.MaxLength {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Translate.Timeout)*time.Second)
+	defer cancel()
+	translation, err := s.translator.Translate(ctx, text, room.AutoTranslate)
+	if err != nil {
+		s.logger.Warn("Failed to translate message %s in %s: %v", evt.ID, evt.RoomID, err)
+		return
+	}
+	if translate.SameLanguage(translation.SourceLang, room.AutoTranslate) || strings.EqualFold(strings.TrimSpace(translation.Text), text) {
+		return
+	}
+
+	opts := []matrix.SendMessageOption{matrix.WithRoom(evt.RoomID), matrix.WithMsgType(matrix.MsgTypeNotice)}
+	if threadRoot := content.RelatesTo.GetThreadParent(); threadRoot != "" {
+		opts = append(opts, matrix.WithThread(threadRoot), matrix.WithReplyTo(evt.ID))
+	} else {
+		opts = append(opts, matrix.WithThread(evt.ID))
+	}
+	if _, err := s.matrix.SendMessage(formatTranslation(translation, room.AutoTranslate), opts...); err != nil {
+		s.logger.Error("Failed to post translation of message %s: %v", evt.ID, err)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/translate"
+)
+
+func TestFormatTranslation(t *testing.T) {
+	tests := []struct {
+		translation translate.Translation
+		targetLang  string
+		expected    string
+	}{
+		{translate.Translation{Text: "Guten Morgen", SourceLang: "en"}, "de", "_EN → DE:_ Guten Morgen"},
+		{translate.Translation{Text: "Bom dia"}, "pt-br", "_? → PT-BR:_ Bom dia"},
+	}
+	for _, tt := range tests {
+		if got := formatTranslation(&tt.translation, tt.targetLang); got != tt.expected {
+			t.Errorf("formatTranslation() = %q, want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestIsTranslateCommand(t *testing.T) {
+	tests := map[string]bool{
+		"/translate de Good morning": true,
+		"/translate":                 true,
+		"/translations":              false,
+		"translate de hi":            false,
+	}
+	for message, expected := range tests {
+		if got := isTranslateCommand(message); got != expected {
+			t.Errorf("isTranslateCommand(%q) = %v, want %v", message, got, expected)
+		}
+	}
+}
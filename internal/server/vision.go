@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/vision"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// visionImage is the data vision.template renders
+type visionImage struct {
+	Caption  string
+	Text     string // What the vision endpoint made of the image
+	FileName string
+}
+
+// compileVisionTemplate parses vision.template, nil unless images are sent
+// to the vision endpoint
+func compileVisionTemplate(cfg *config.VisionConfig) (*template.Template, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return template.New("vision").Funcs(notifyTemplateFuncs()).Option("missingkey=zero").Parse(cfg.Template)
+}
+
+// visionContent returns the content of an image the vision endpoint should
+// read, nil for other events
+func (s *Server) visionContent(evt *event.Event) *event.MessageEventContent {
+	cfg := s.cfg()
+	if s.paused.Load() || evt.Type != event.EventMessage || evt.Sender == id.UserID(cfg.Matrix.UserID) {
+		return nil
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.MsgType != event.MsgImage || content.RelatesTo.GetReplaceID() != "" {
+		return nil
+	}
+	room := s.roomSettings(evt.RoomID)
+	if !room.AllowsUser(string(evt.Sender)) || (room != nil && room.RequireEncryption && !evt.Mautrix.WasEncrypted) {
+		return nil
+	}
+	if cfg.Vision.RequireMention && !mentionsUser(content, id.UserID(cfg.Matrix.UserID)) {
+		return nil
+	}
+	return content
+}
+
+// handleImage sends an image posted in a room to the vision endpoint and
+// handles the text it returns, rendered with the caption by vision.template,
+// like a message of the sender: replies go to the image, and the caption
+// may name the command the text is routed to.
+func (s *Server) handleImage(evt *event.Event, content *event.MessageEventContent) {
+	cfg := s.cfg()
+	ctx := withReplyRoom(context.Background(), evt.RoomID)
+	ctx = logger.NewContext(ctx, "room_id", string(evt.RoomID), "event_id", string(evt.ID), "sender", string(evt.Sender))
+	log := s.logger.Ctx(ctx)
+	reply := func(text string) { s.sendReply(ctx, text, evt.Sender, evt.ID) }
+
+	policy := &cfg.Attachments.Inbound
+	var mimeType string
+	if content.Info != nil {
+		mimeType = content.Info.MimeType
+	}
+	refuseType := func() bool {
+		if err := attachmentTypeError(policy, mimeType); err != nil {
+			log.Info("Not reading image %s: %v", evt.ID, err)
+			reply(s.text(evt.RoomID, "attachment.type_not_allowed", mimeType, strings.Join(policy.MimeTypes, ", ")))
+			return true
+		}
+		return false
+	}
+	// The declared type is checked before downloading, a missing one after
+	if mimeType != "" && refuseType() {
+		return
+	}
+
+	visionCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Vision.Timeout)*time.Second)
+	defer cancel()
+	maxSize := policy.MaxSize(cfg.Vision.MaxSizeMB)
+	data, err := s.matrix.DownloadMedia(visionCtx, content, maxSize)
+	if errors.Is(err, matrix.ErrMediaTooLarge) {
+		log.Info("Image %s is larger than %d MB, not reading it", evt.ID, maxSize>>20)
+		reply(s.text(evt.RoomID, "vision.too_large", maxSize>>20))
+		return
+	}
+	if err != nil {
+		log.Error("Failed to download image %s: %v", evt.ID, err)
+		reply(s.text(evt.RoomID, "vision.download_failed", err))
+		return
+	}
+
+	if mimeType == "" {
+		if mimeType = detectMimeType("", content.GetFileName(), data); refuseType() {
+			return
+		}
+	}
+
+	img := vision.Image{
+		Data:     data,
+		FileName: content.GetFileName(),
+		MimeType: mimeType,
+		Caption:  content.GetCaption(),
+		Sender:   string(evt.Sender),
+		RoomID:   string(evt.RoomID),
+	}
+	text, err := s.vision.Describe(visionCtx, img)
+	if err != nil {
+		log.Error("Failed to read image %s: %v", evt.ID, err)
+		reply(s.text(evt.RoomID, "vision.read_failed", err))
+		return
+	}
+	log.Info("Read image %s of %s (%d bytes) into %d characters", evt.ID, evt.Sender, len(data), len(text))
+
+	var b strings.Builder
+	if err := s.visionTemplate.Execute(&b, visionImage{Caption: img.Caption, Text: text, FileName: img.FileName}); err != nil {
+		log.Error("Failed to render vision.template: %v", err)
+		return
+	}
+	message := strings.TrimSpace(b.String())
+	if message == "" {
+		log.Info("vision.template rendered an empty message for image %s, nothing to handle", evt.ID)
+		return
+	}
+	s.processMessage(context.Background(), evt.RoomID, evt.Sender, message, content.RelatesTo.GetReplyTo(), content.RelatesTo.GetThreadParent(), evt.ID)
+}
+
+// mentionsUser reports whether a message mentions a user
+func mentionsUser(content *event.MessageEventContent, userID id.UserID) bool {
+	if content.Mentions == nil {
+		return false
+	}
+	for _, mentioned := range content.Mentions.UserIDs {
+		if mentioned == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestVisionContent(t *testing.T) {
+	image := func(sender, body string, mentions ...id.UserID) *event.Event {
+		content := &event.MessageEventContent{MsgType: event.MsgImage, Body: body, FileName: "screenshot.png", URL: "mxc://example.com/image"}
+		if len(mentions) > 0 {
+			content.Mentions = &event.Mentions{UserIDs: mentions}
+		}
+		return &event.Event{
+			Type:    event.EventMessage,
+			RoomID:  "!room:example.com",
+			Sender:  id.UserID(sender),
+			Content: event.Content{Parsed: content},
+		}
+	}
+	text := &event.Event{Type: event.EventMessage, Sender: "@alice:example.com", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}}
+
+	cfg := &config.Config{Matrix: config.MatrixConfig{UserID: "@bot:example.com"}}
+	s := &Server{config: cfg, rooms: compileRooms([]config.RoomConfig{{RoomID: "!room:example.com", AllowedUsers: []string{"@alice:example.com"}}})}
+
+	if s.visionContent(image("@alice:example.com", "screenshot.png")) == nil {
+		t.Error("visionContent() = nil for an image of an allowed user")
+	}
+	if s.visionContent(image("@bot:example.com", "screenshot.png")) != nil {
+		t.Error("visionContent() != nil for an image of the bot")
+	}
+	if s.visionContent(image("@mallory:example.com", "screenshot.png")) != nil {
+		t.Error("visionContent() != nil for an image of a user the room does not allow")
+	}
+	if s.visionContent(text) != nil {
+		t.Error("visionContent() != nil for a text message")
+	}
+
+	cfg.Vision.RequireMention = true
+	if s.visionContent(image("@alice:example.com", "why?")) != nil {
+		t.Error("visionContent() != nil for an image not mentioning the bot with require_mention")
+	}
+	if s.visionContent(image("@alice:example.com", "bot: why?", "@bot:example.com")) == nil {
+		t.Error("visionContent() = nil for an image mentioning the bot with require_mention")
+	}
+}
+
+func TestVisionTemplate(t *testing.T) {
+	tpl, err := compileVisionTemplate(&config.VisionConfig{Enabled: true, Template: "{{ .Caption }}\n\n{{ .Text }}"})
+	if err != nil {
+		t.Fatalf("compileVisionTemplate() error = %v", err)
+	}
+	tests := []struct {
+		image    visionImage
+		expected string
+	}{
+		{visionImage{Caption: "/ask why does this fail?", Text: "Error: disk full"}, "/ask why does this fail?\n\nError: disk full"},
+		{visionImage{Text: "Error: disk full"}, "Error: disk full"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := tpl.Execute(&b, tt.image); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := strings.TrimSpace(b.String()); got != tt.expected {
+			t.Errorf("rendered %q, want %q", got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/matrix"
+	"github.com/mule-ai/mule/matrix-microservice/internal/quiet"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+	"maunium.net/go/mautrix/id"
+)
+
+// reportWebhookReachability probes every configured webhook and posts the
+// results to webhook_probe.room_id, so that a wrong URL is noticed at
+// startup or reload instead of at the first message. when is "startup" or
+// "reload".
+func (s *Server) reportWebhookReachability(when string) {
+	cfg := s.cfg()
+	probe := cfg.WebhookProbe
+	if probe.RoomID == "" || s.matrix == nil {
+		return
+	}
+	endpoints := webhook.Endpoints(cfg)
+	if len(endpoints) == 0 {
+		return
+	}
+
+	timeout := time.Duration(probe.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := webhook.ProbeAll(ctx, endpoints, timeout, cfg.Webhook.UserAgent)
+
+	report, failed := reachabilityReport(when, results)
+	if failed > 0 {
+		s.logger.Warn("%d of %d webhooks are unreachable after %s", failed, len(results), when)
+	} else {
+		s.logger.Info("All %d webhooks are reachable after %s", len(results), when)
+	}
+	if failed == 0 && probe.OnlyFailures {
+		return
+	}
+	if s.holdNotification(ctx, quiet.Notification{Source: "webhook_probe", RoomID: probe.RoomID, Message: report, MsgType: matrix.MsgTypeNotice}) {
+		return
+	}
+	if _, err := s.matrix.SendMessage(report, matrix.WithRoom(id.RoomID(probe.RoomID)), matrix.WithMsgType(matrix.MsgTypeNotice)); err != nil {
+		s.logger.Error("Failed to send the webhook reachability report: %v", err)
+	}
+}
+
+// reachabilityReport formats the results of probing the webhooks, the
+// unreachable first, and returns how many are unreachable
+func reachabilityReport(when string, results []webhook.ProbeResult) (string, int) {
+	var failures, successes []string
+	for _, result := range results {
+		latency := result.Latency.Round(time.Millisecond)
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("- ❌ `%s` after %s: %v", result.Name, latency, result.Err))
+		} else {
+			successes = append(successes, fmt.Sprintf("- ✅ `%s` %s in %s", result.Name, result.Status, latency))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Webhook reachability after %s**: %d of %d reachable\n", when, len(successes), len(results))
+	for _, line := range append(failures, successes...) {
+		b.WriteString("\n" + line)
+	}
+	return b.String(), len(failures)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/webhook"
+)
+
+func TestWebhookEndpoints(t *testing.T) {
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			Default:  "http://hooks/default",
+			Commands: map[string]string{"deploy": "http://hooks/deploy", "alert": "http://hooks/alert"},
+		},
+		Rooms:   []config.RoomConfig{{RoomID: "!ops:example.com", Webhook: config.RoomWebhookConfig{Default: "http://hooks/ops"}}},
+		Tenants: []config.TenantConfig{{Name: "team", Webhook: config.TenantWebhookConfig{Default: "http://team/default"}}},
+	}
+
+	var names []string
+	for _, endpoint := range webhook.Endpoints(cfg) {
+		names = append(names, endpoint.Name)
+	}
+	expected := "rooms[0].webhook.default tenants.team.webhook.default webhook.commands.alert webhook.commands.deploy webhook.default"
+	if got := strings.Join(names, " "); got != expected {
+		t.Errorf("Endpoints() = %s, want %s", got, expected)
+	}
+}
+
+func TestReachabilityReport(t *testing.T) {
+	userAgents := make(chan string, 2)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+	}))
+	defer up.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	results := webhook.ProbeAll(context.Background(), []webhook.Endpoint{
+		{Name: "webhook.commands.broken", URL: failing.URL},
+		{Name: "webhook.commands.typo", URL: "htp://hooks/typo"},
+		{Name: "webhook.default", URL: up.URL},
+	}, 5*time.Second, "ops-bot/1.0")
+	for range 2 {
+		if userAgent := <-userAgents; userAgent != "ops-bot/1.0" {
+			t.Errorf("probe User-Agent = %q, want ops-bot/1.0", userAgent)
+		}
+	}
+
+	report, failed := reachabilityReport("startup", results)
+	if failed != 2 {
+		t.Errorf("reachabilityReport() failed = %d, want 2", failed)
+	}
+	lines := strings.Split(report, "\n")
+	if len(lines) != 5 || lines[0] != "**Webhook reachability after startup**: 1 of 3 reachable" {
+		t.Fatalf("reachabilityReport() = %q", report)
+	}
+	for i, want := range []string{"- ❌ `webhook.commands.broken`", "- ❌ `webhook.commands.typo`", "- ✅ `webhook.default` 200 OK in "} {
+		if !strings.HasPrefix(lines[i+2], want) {
+			t.Errorf("line %d = %q, want prefix %q", i+2, lines[i+2], want)
+		}
+	}
+	if !strings.Contains(report, "502 Bad Gateway") || !strings.Contains(report, "not an http(s) URL") {
+		t.Errorf("reachabilityReport() does not say why webhooks failed: %q", report)
+	}
+}
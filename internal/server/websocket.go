@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// webSocketWriteTimeout bounds how long a frame may take to reach a consumer
+const webSocketWriteTimeout = 10 * time.Second
+
+// handleWebSocket streams handled messages, and with ?events=all every room
+// event, as JSON text frames
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !hasStreamToken(r, s.cfg().Stream.Token) {
+		s.logger.Warn("Rejecting WebSocket stream request with missing or invalid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	roomEvents := r.URL.Query().Get("events") == "all"
+
+	// The token authenticates the consumer, so any origin may connect
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		s.streamWebSocket(conn, roomEvents)
+	}}
+	server.ServeHTTP(w, r)
+}
+
+func (s *Server) streamWebSocket(conn *websocket.Conn, roomEvents bool) {
+	sub := s.stream.subscribe(s.cfg().Stream.BufferSize, func(evt *StreamEvent) bool {
+		return roomEvents || evt.Type == StreamEventMessage
+	}, "")
+	defer s.stream.unsubscribe(sub)
+	s.logger.Info("WebSocket stream consumer connected from %s (room events: %v)", conn.Request().RemoteAddr, roomEvents)
+
+	// Frames from the consumer are ignored; reading notices when it leaves
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		io.Copy(io.Discard, conn)
+	}()
+
+	for {
+		select {
+		case evt, ok := <-sub.events:
+			if !ok {
+				s.logger.Info("Disconnecting WebSocket stream consumer %s: fell behind or server shutting down", conn.Request().RemoteAddr)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := websocket.JSON.Send(conn, evt); err != nil {
+				s.logger.Info("WebSocket stream consumer %s went away: %v", conn.Request().RemoteAddr, err)
+				return
+			}
+		case <-gone:
+			s.logger.Info("WebSocket stream consumer %s disconnected", conn.Request().RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// HandleWithheld tells the sender of a message the bot cannot decrypt why:
+// their device withheld the key from the bot's device
+func (s *Server) HandleWithheld(roomID id.RoomID, sender id.UserID, eventID id.EventID, code event.RoomKeyWithheldCode) {
+	if s.paused.Load() || !s.roomSettings(roomID).AllowsUser(string(sender)) {
+		return
+	}
+	key := "withheld.unauthorised"
+	switch code {
+	case event.RoomKeyWithheldBlacklisted:
+		key = "withheld.blacklisted"
+	case event.RoomKeyWithheldUnverified:
+		key = "withheld.unverified"
+	}
+	ctx := withReplyRoom(context.Background(), roomID)
+	ctx = logger.NewContext(ctx, "room_id", string(roomID), "event_id", string(eventID), "sender", string(sender))
+	s.sendReply(ctx, s.text(roomID, key, s.matrix.GetDeviceID()), sender, eventID)
+}
@@ -0,0 +1,292 @@
+package session
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// placeholderRegex matches {{.NAME}} and {{.MEMORY.key}} placeholders in
+// command templates
+var placeholderRegex = regexp.MustCompile(`\{\{\s*\.([A-Za-z_]+)(?:\.([A-Za-z_][A-Za-z0-9_]*))?\s*\}\}`)
+
+// knownPlaceholders lists every placeholder renderCommand substitutes
+var knownPlaceholders = map[string]bool{
+	"MESSAGE":        true,
+	"CONTEXT":        true,
+	"SESSION":        true,
+	"USER":           true,
+	"USER_LOCALPART": true,
+	"ROOM":           true,
+	"THREAD":         true,
+	"EVENT_ID":       true,
+	"TIMESTAMP":      true,
+}
+
+// shells whose -c argument is itself a script and must be validated as well
+var shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"zsh":  true,
+	"ash":  true,
+}
+
+// Allowlist restricts which executables command templates may invoke.
+// Entries without a slash match a bare command name (e.g. "pi"), entries
+// ending in a slash match any executable inside that directory
+// (e.g. "/opt/tools/"), and any other entry matches an exact path.
+type Allowlist struct {
+	names map[string]bool
+	paths map[string]bool
+	dirs  []string
+}
+
+// NewAllowlist creates an allowlist from config entries. It returns nil when
+// entries is empty, meaning every executable is permitted.
+func NewAllowlist(entries []string) *Allowlist {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	a := &Allowlist{
+		names: make(map[string]bool),
+		paths: make(map[string]bool),
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.HasSuffix(entry, "/"):
+			a.dirs = append(a.dirs, entry)
+		case strings.Contains(entry, "/"):
+			a.paths[path.Clean(entry)] = true
+		default:
+			a.names[entry] = true
+		}
+	}
+	return a
+}
+
+// Allows reports whether the executable may be invoked
+func (a *Allowlist) Allows(executable string) bool {
+	if a == nil {
+		return true
+	}
+	if !strings.Contains(executable, "/") {
+		return a.names[executable]
+	}
+	cleaned := path.Clean(executable)
+	if a.paths[cleaned] {
+		return true
+	}
+	for _, dir := range a.dirs {
+		if strings.HasPrefix(cleaned, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCommand checks that every executable invoked by a fully rendered
+// shell command line is on the allowlist. Arguments to "sh -c" and similar
+// are validated recursively.
+func (a *Allowlist) ValidateCommand(command string) error {
+	if a == nil {
+		return nil
+	}
+	executables, err := commandExecutables(command)
+	if err != nil {
+		return err
+	}
+	for _, exe := range executables {
+		if !a.Allows(exe) {
+			return fmt.Errorf("executable %q is not in the allowlist", exe)
+		}
+	}
+	return nil
+}
+
+// ValidateTemplate checks a command template before any message is
+// substituted: every placeholder must be known, the template must parse as a
+// command in the given exec mode, and (if an allowlist is set) it may only
+// invoke allowed executables. The allowlist may be nil.
+func ValidateTemplate(template string, execMode string, allowlist *Allowlist) error {
+	for _, match := range placeholderRegex.FindAllStringSubmatch(template, -1) {
+		if match[2] != "" && match[1] != "MEMORY" {
+			return fmt.Errorf("unknown placeholder {{.%s.%s}}", match[1], match[2])
+		}
+		if match[2] == "" && !knownPlaceholders[match[1]] {
+			return fmt.Errorf("unknown placeholder {{.%s}}", match[1])
+		}
+	}
+
+	if execMode == ExecModeArgv {
+		argv, err := splitArgv(template)
+		if err != nil {
+			return err
+		}
+		if len(argv) == 0 {
+			return fmt.Errorf("command template is empty")
+		}
+		if placeholderRegex.MatchString(argv[0]) {
+			return fmt.Errorf("executable must not be a placeholder")
+		}
+		if !allowlist.Allows(argv[0]) {
+			return fmt.Errorf("executable %q is not in the allowlist", argv[0])
+		}
+		return nil
+	}
+
+	// Substitute placeholders with an empty quoted value so the template can
+	// be parsed the same way a rendered command would be. Substituted values
+	// are checked again at execution time by ValidateCommand.
+	rendered := placeholderRegex.ReplaceAllString(template, "''")
+	if _, err := commandExecutables(rendered); err != nil {
+		return err
+	}
+	return allowlist.ValidateCommand(rendered)
+}
+
+// commandExecutables returns the executable of every simple command in a
+// shell command line, descending into the script argument of "sh -c".
+func commandExecutables(command string) ([]string, error) {
+	commands, err := splitShellCommands(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var executables []string
+	for _, words := range commands {
+		// Skip leading variable assignments (FOO=bar cmd)
+		for len(words) > 0 && isAssignment(words[0]) {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		exe := words[0]
+		executables = append(executables, exe)
+
+		if shells[path.Base(exe)] {
+			for i := 1; i < len(words)-1; i++ {
+				if words[i] == "-c" {
+					nested, err := commandExecutables(words[i+1])
+					if err != nil {
+						return nil, fmt.Errorf("in %s -c script: %w", exe, err)
+					}
+					executables = append(executables, nested...)
+					break
+				}
+			}
+		}
+	}
+	return executables, nil
+}
+
+var assignmentRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+func isAssignment(word string) bool {
+	return assignmentRegex.MatchString(word)
+}
+
+// splitShellCommands is a minimal POSIX shell lexer. It splits a command line
+// into simple commands (separated by ; & | && || newlines and parentheses)
+// and returns the unquoted words of each. Command substitution outside single
+// quotes is rejected since its contents cannot be validated reliably.
+func splitShellCommands(command string) ([][]string, error) {
+	var commands [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	redirect := false // next word is a redirection target
+
+	endWord := func() {
+		if inWord {
+			if redirect {
+				redirect = false
+			} else {
+				words = append(words, word.String())
+			}
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			inWord = true
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				switch command[i] {
+				case '\\':
+					if i+1 < len(command) {
+						i++
+						word.WriteByte(command[i])
+					}
+				case '`':
+					return nil, fmt.Errorf("command substitution is not allowed")
+				case '$':
+					if i+1 < len(command) && command[i+1] == '(' {
+						return nil, fmt.Errorf("command substitution is not allowed")
+					}
+					word.WriteByte('$')
+				default:
+					word.WriteByte(command[i])
+				}
+			}
+			if i >= len(command) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				if command[i] != '\n' {
+					word.WriteByte(command[i])
+					inWord = true
+				}
+			}
+		case c == '`':
+			return nil, fmt.Errorf("command substitution is not allowed")
+		case c == '$' && i+1 < len(command) && command[i+1] == '(':
+			return nil, fmt.Errorf("command substitution is not allowed")
+		case c == ';' || c == '&' || c == '|' || c == '\n' || c == '(' || c == ')':
+			endCommand()
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == '<' || c == '>':
+			// Redirection: drop the operator and its target from the word list
+			endWord()
+			for i+1 < len(command) && (command[i+1] == '>' || command[i+1] == '&') {
+				i++
+			}
+			redirect = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endCommand()
+
+	return commands, nil
+}
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestAllowlistAllows(t *testing.T) {
+	a := NewAllowlist([]string{"pi", "/usr/bin/git", "/opt/tools/"})
+
+	tests := []struct {
+		executable string
+		allowed    bool
+	}{
+		{"pi", true},
+		{"/usr/bin/git", true},
+		{"/usr/bin/../bin/git", true},
+		{"/opt/tools/deploy", true},
+		{"git", false},
+		{"/usr/local/bin/pi", false},
+		{"rm", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.executable, func(t *testing.T) {
+			if got := a.Allows(tt.executable); got != tt.allowed {
+				t.Errorf("Allows(%q) = %v, want %v", tt.executable, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNilAllowlistAllowsEverything(t *testing.T) {
+	var a *Allowlist = NewAllowlist(nil)
+	if a != nil {
+		t.Fatal("NewAllowlist(nil) should return nil")
+	}
+	if err := a.ValidateCommand("rm -rf /tmp/whatever"); err != nil {
+		t.Errorf("nil allowlist ValidateCommand() error = %v", err)
+	}
+}
+
+func TestAllowlistValidateCommand(t *testing.T) {
+	a := NewAllowlist([]string{"pi", "echo", "sh"})
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"Allowed command", "pi -p 'hello'", false},
+		{"Quoted operators are arguments", "pi -p 'a; rm -rf / && b'", false},
+		{"Pipeline of allowed commands", "echo hi | pi -p 'x'", false},
+		{"Redirection target is not a command", "echo hi > /tmp/out 2>&1", false},
+		{"Env assignment prefix", "FOO=bar pi -p 'x'", false},
+		{"Chained disallowed command", "pi -p 'x'; rm -rf /", true},
+		{"Disallowed command in sh -c script", "sh -c 'echo hi; rm -rf /'", true},
+		{"Allowed sh -c script", "sh -c 'echo hi | pi -p x'", false},
+		{"Command substitution", "echo $(rm -rf /)", true},
+		{"Backtick substitution", "echo \"`id`\"", true},
+		{"Unterminated quote", "echo 'oops", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.ValidateCommand(tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCommand(%q) error = %v, wantErr %v", tt.command, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	a := NewAllowlist([]string{"pi", "sh"})
+
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{"Valid template", "pi -p {{.MESSAGE}} --session {{.SESSION}}", ""},
+		{"Shell template with message script", "sh -c {{.MESSAGE}}", ""},
+		{"Unknown placeholder", "pi -p {{.MESSAGES}}", "unknown placeholder"},
+		{"Memory placeholder", "pi -p {{.MESSAGE}} --lang {{.MEMORY.lang}}", ""},
+		{"Unknown nested placeholder", "pi -p {{.VARS.lang}}", "unknown placeholder"},
+		{"Disallowed executable", "curl {{.MESSAGE}}", "not in the allowlist"},
+		{"Unbalanced quotes", "pi -p \"{{.MESSAGE}}", "unterminated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTemplate(tt.template, ExecModeShell, a)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateTemplate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateTemplate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandRejectsSubstitutionOutsideAllowlist(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sh -c {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+	m.SetAllowlist(NewAllowlist([]string{"sh", "echo"}))
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	output, err := m.ExecuteCommand(session, "echo allowed")
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if output != "allowed\n" {
+		t.Errorf("ExecuteCommand() output = %q, want %q", output, "allowed\n")
+	}
+
+	if _, err := m.ExecuteCommand(session, "echo ok; id"); err == nil {
+		t.Error("ExecuteCommand() should reject a message invoking a non-allowlisted executable")
+	}
+}
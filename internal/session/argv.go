@@ -0,0 +1,72 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitArgv splits a command template into arguments using shell-like quoting
+// rules: whitespace separates arguments, single quotes preserve everything
+// literally, double quotes and backslashes escape. Unlike a shell, operators
+// such as ; | & and $ have no special meaning.
+func splitArgv(template string) ([]string, error) {
+	var argv []string
+	var arg strings.Builder
+	inArg := false
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch c {
+		case '\'':
+			end := strings.IndexByte(template[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			arg.WriteString(template[i+1 : i+1+end])
+			inArg = true
+			i += end + 1
+		case '"':
+			inArg = true
+			i++
+			for ; i < len(template) && template[i] != '"'; i++ {
+				if template[i] == '\\' && i+1 < len(template) {
+					i++
+				}
+				arg.WriteByte(template[i])
+			}
+			if i >= len(template) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+		case '\\':
+			if i+1 < len(template) {
+				i++
+				arg.WriteByte(template[i])
+				inArg = true
+			}
+		case ' ', '\t', '\n':
+			if inArg {
+				argv = append(argv, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		argv = append(argv, arg.String())
+	}
+
+	return argv, nil
+}
+
+// joinArgv renders an argument list as an equivalent shell command line for
+// logging and dry runs
+func joinArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellEscape(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+func TestSplitArgv(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+		wantErr  bool
+	}{
+		{"Simple words", "pi -p {{.MESSAGE}}", []string{"pi", "-p", "{{.MESSAGE}}"}, false},
+		{"Single quotes", "printf '%s %s' a", []string{"printf", "%s %s", "a"}, false},
+		{"Double quotes with escape", `echo "say \"hi\""`, []string{"echo", `say "hi"`}, false},
+		{"Operators are literal", "echo a;b | c", []string{"echo", "a;b", "|", "c"}, false},
+		{"Empty quoted argument", "echo ''", []string{"echo", ""}, false},
+		{"Unterminated quote", "echo 'oops", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := splitArgv(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitArgv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(argv, tt.expected) {
+				t.Errorf("splitArgv() = %q, want %q", argv, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandArgvMode(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "printf '[%s]' {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+	if err := m.SetExecMode(ExecModeArgv); err != nil {
+		t.Fatalf("SetExecMode() error = %v", err)
+	}
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	// Shell metacharacters are passed through as a single literal argument
+	message := "it's $(id); echo `whoami` | cat"
+	output, err := m.ExecuteCommand(session, message)
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if expected := "[" + message + "]"; output != expected {
+		t.Errorf("ExecuteCommand() output = %q, want %q", output, expected)
+	}
+}
+
+func TestExecuteCommandArgvModeAllowlist(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+	m.SetExecMode(ExecModeArgv)
+	m.SetAllowlist(NewAllowlist([]string{"pi"}))
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	if _, err := m.ExecuteCommand(session, "hello"); err == nil {
+		t.Error("ExecuteCommand() should reject an executable outside the allowlist")
+	}
+}
+
+func TestSetExecModeRejectsUnknownMode(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	if err := m.SetExecMode("exec"); err == nil {
+		t.Error("SetExecMode() should reject an unknown mode")
+	}
+}
@@ -1,10 +1,13 @@
 package session
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,19 +32,94 @@ type Session struct {
 	Command         string     // Command template to use
 	Mutex           sync.Mutex // Per-session lock
 	SessionFile     string     // Path to session file for pi --session
+
+	transcript        []TranscriptEntry // Commands run in the session (guarded by Mutex)
+	transcriptDropped int               // Older commands dropped from transcript
+
+	collaborators map[id.UserID]bool // Users the owner shared the session with (guarded by Manager.mutex)
+
+	queueMutex sync.Mutex    // Guards the fields below
+	pending    int           // Commands queued or running in this session
+	running    bool          // A command is currently executing
+	flushGen   int           // Incremented by FlushQueues; older queued commands are dropped
+	killed     bool          // Set by KillSession; queued commands are dropped
+	queueTail  chan struct{} // Closed when the last queued command finishes
+
+	ctx    context.Context // Cancelled by KillSession to stop the running command
+	cancel context.CancelFunc
+}
+
+// SessionInfo is a snapshot of a session for the admin API
+type SessionInfo struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id"`
+	ThreadRoot    string    `json:"thread_root,omitempty"`
+	LastActivity  time.Time `json:"last_activity"`
+	Pending       int       `json:"pending"` // Commands queued or running
+	Collaborators []string  `json:"collaborators,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"` // Set by the server for sessions of a tenant's rooms
+}
+
+// ErrQueueFull is returned by QueueCommand when a session already has the
+// maximum number of commands waiting
+var ErrQueueFull = errors.New("session command queue is full")
+
+// ErrCommandFlushed is passed to the done callback of a queued command that
+// was dropped by FlushQueues before it started
+var ErrCommandFlushed = errors.New("queued command was flushed")
+
+// ErrSessionKilled is passed to the done callback of a command that was
+// stopped or dropped because its session was killed
+var ErrSessionKilled = errors.New("session was killed")
+
+// TimeoutError is returned for a command that ran longer than its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %v", e.Timeout)
+}
+
+// commandContext returns the context commands in the session run under
+func (session *Session) commandContext() context.Context {
+	if session.ctx == nil {
+		return context.Background()
+	}
+	return session.ctx
 }
 
 type Manager struct {
 	sessions        map[string]*Session
-	mutex           sync.RWMutex
+	mutex           sync.RWMutex // Guards sessions and the settings below
 	logger          *logger.Logger
 	sessionTimeout  time.Duration
 	cleanupInterval time.Duration
 	defaultCommand  string
 	sessionDir      string // Directory for pi session files
+	queueDepth      int    // Max commands waiting behind a running one
+	allowlist       *Allowlist
+	execMode        string // ExecModeShell or ExecModeArgv
+	maxSessions     int    // Hard cap on live sessions (0 = unlimited)
+	transcriptLimit int    // Commands kept per session transcript
+	onExpire        func(*Session)
 	stopCleanup     chan struct{}
+	stopOnce        sync.Once
+	running         sync.WaitGroup // Queued and running commands
 }
 
+// Execution modes for session commands
+const (
+	// ExecModeShell renders the template with shell-escaped values and runs it via sh -c
+	ExecModeShell = "shell"
+	// ExecModeArgv splits the template into arguments and executes it directly, without a shell
+	ExecModeArgv = "argv"
+)
+
+// DefaultQueueDepth is the number of commands allowed to wait behind a running
+// command in the same session
+const DefaultQueueDepth = 5
+
 func NewManager(loggerInstance *logger.Logger, sessionTimeoutSeconds int, defaultCommand string, sessionDir string) *Manager {
 	sessionTimeout := time.Duration(sessionTimeoutSeconds) * time.Second
 	if sessionTimeout == 0 {
@@ -60,6 +138,8 @@ func NewManager(loggerInstance *logger.Logger, sessionTimeoutSeconds int, defaul
 		cleanupInterval: 60 * time.Second,
 		defaultCommand:  defaultCommand,
 		sessionDir:      sessionDir,
+		queueDepth:      DefaultQueueDepth,
+		execMode:        ExecModeShell,
 		stopCleanup:     make(chan struct{}),
 	}
 
@@ -76,10 +156,95 @@ func NewManager(loggerInstance *logger.Logger, sessionTimeoutSeconds int, defaul
 	return m
 }
 
+// SetQueueDepth sets how many commands may wait behind a running command in a
+// session. Values below zero are treated as zero (no queueing).
+func (m *Manager) SetQueueDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queueDepth = depth
+}
+
+// SetExecMode selects how commands are executed (ExecModeShell or ExecModeArgv).
+// An empty mode selects ExecModeShell.
+func (m *Manager) SetExecMode(mode string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch mode {
+	case "", ExecModeShell:
+		m.execMode = ExecModeShell
+	case ExecModeArgv:
+		m.execMode = ExecModeArgv
+	default:
+		return fmt.Errorf("unknown exec mode %q", mode)
+	}
+	return nil
+}
+
+// SetMaxSessions caps the number of live sessions. When the cap is reached
+// the least recently used idle session is evicted. Zero disables the cap.
+func (m *Manager) SetMaxSessions(max int) {
+	if max < 0 {
+		max = 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.maxSessions = max
+}
+
+// SetAllowlist restricts the executables rendered commands may invoke.
+// A nil allowlist permits everything.
+func (m *Manager) SetAllowlist(allowlist *Allowlist) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.allowlist = allowlist
+}
+
+// SetDefaultCommand sets the command template used by sessions without one
+func (m *Manager) SetDefaultCommand(command string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.defaultCommand = command
+}
+
+// SetSessionTimeout sets how long idle sessions are kept. Zero selects the
+// default of 10 minutes.
+func (m *Manager) SetSessionTimeout(seconds int) {
+	timeout := time.Duration(seconds) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessionTimeout = timeout
+}
+
 // Stop stops the session manager and cleanup goroutine
 func (m *Manager) Stop() {
-	m.logger.Info("Stopping session manager")
-	close(m.stopCleanup)
+	m.stopOnce.Do(func() {
+		m.logger.Info("Stopping session manager")
+		close(m.stopCleanup)
+	})
+}
+
+// Wait blocks until all queued and running commands have finished or ctx
+// expires
+func (m *Manager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // cleanupLoop runs cleanup periodically
@@ -98,7 +263,7 @@ func (m *Manager) cleanupLoop() {
 	}
 }
 
-// Cleanup removes expired sessions
+// Cleanup removes expired sessions and orphaned session files
 func (m *Manager) Cleanup() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -109,6 +274,7 @@ func (m *Manager) Cleanup() {
 	for key, session := range m.sessions {
 		if now.Sub(session.LastActivity) > m.sessionTimeout {
 			delete(m.sessions, key)
+			m.expired(session)
 			expiredCount++
 		}
 	}
@@ -116,6 +282,69 @@ func (m *Manager) Cleanup() {
 	if expiredCount > 0 {
 		m.logger.Info("Cleaned up %d expired sessions (total active: %d)", expiredCount, len(m.sessions))
 	}
+
+	m.removeOrphanedSessionFiles(now)
+}
+
+// removeOrphanedSessionFiles deletes thread_*.jsonl files in the session
+// directory that have no in-memory session and have not been modified for
+// longer than the session timeout. Must be called with m.mutex held.
+func (m *Manager) removeOrphanedSessionFiles(now time.Time) {
+	files, err := filepath.Glob(filepath.Join(m.sessionDir, "thread_*.jsonl"))
+	if err != nil {
+		m.logger.Warn("Failed to list session files in %s: %v", m.sessionDir, err)
+		return
+	}
+
+	removedCount := 0
+	for _, file := range files {
+		key := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "thread_"), ".jsonl")
+		if _, exists := m.sessions[key]; exists {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil || now.Sub(info.ModTime()) <= m.sessionTimeout {
+			continue
+		}
+
+		if err := os.Remove(file); err != nil {
+			m.logger.Warn("Failed to remove orphaned session file %s: %v", file, err)
+			continue
+		}
+		removedCount++
+	}
+
+	if removedCount > 0 {
+		m.logger.Info("Removed %d orphaned session files from %s", removedCount, m.sessionDir)
+	}
+}
+
+// evictLeastRecentlyUsed removes the idle session with the oldest activity.
+// Sessions with queued or running commands are never evicted. Must be called
+// with m.mutex held.
+func (m *Manager) evictLeastRecentlyUsed() bool {
+	var oldestKey string
+	var oldest *Session
+	for key, session := range m.sessions {
+		if m.QueueLength(session) > 0 {
+			continue
+		}
+		if oldest == nil || session.LastActivity.Before(oldest.LastActivity) {
+			oldestKey = key
+			oldest = session
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	delete(m.sessions, oldestKey)
+	m.expired(oldest)
+	m.logger.Info("Evicted least recently used session: key=%s, userID=%s, idle=%v",
+		oldestKey, oldest.UserID, time.Since(oldest.LastActivity).Round(time.Second))
+	return true
 }
 
 // GetSessionKey generates a session key from thread root event ID or user ID
@@ -140,10 +369,21 @@ func (m *Manager) GetOrCreateSession(threadRootEventID id.EventID, userID id.Use
 
 	session, exists := m.sessions[key]
 	if !exists {
+		// Enforce the session cap before adding a new session
+		for m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+			if !m.evictLeastRecentlyUsed() {
+				m.logger.Warn("Session cap of %d reached but all sessions are busy, exceeding cap", m.maxSessions)
+				break
+			}
+		}
+
 		// Generate unique session file for this thread
 		sessionFile := filepath.Join(m.sessionDir, fmt.Sprintf("thread_%s.jsonl", key))
-		
+
+		ctx, cancel := context.WithCancel(context.Background())
 		session = &Session{
+			ctx:             ctx,
+			cancel:          cancel,
 			ID:              key,
 			UserID:          userID,
 			ThreadRootEvent: threadRootEventID,
@@ -180,6 +420,29 @@ func (m *Manager) GetSession(threadRootEventID id.EventID, userID id.UserID) *Se
 	return m.sessions[key]
 }
 
+// ShareSession allows a user other than the owner to run commands in the session
+func (m *Manager) ShareSession(session *Session, userID id.UserID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if session.collaborators == nil {
+		session.collaborators = make(map[id.UserID]bool)
+	}
+	session.collaborators[userID] = true
+	m.logger.Info("Shared session %s (owner %s) with %s", session.ID, session.UserID, userID)
+}
+
+// CanUseSession reports whether the user owns the session or was invited to it
+func (m *Manager) CanUseSession(session *Session, userID id.UserID) bool {
+	if session.UserID == userID {
+		return true
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return session.collaborators[userID]
+}
+
 // GetSessionForUser finds any existing session for a user (returns most recent by LastActivity)
 func (m *Manager) GetSessionForUser(userID id.UserID) *Session {
 	m.mutex.RLock()
@@ -201,39 +464,242 @@ func (m *Manager) GetSessionForUser(userID id.UserID) *Session {
 	return recent
 }
 
+// ExecOptions holds optional request metadata exposed to command templates
+type ExecOptions struct {
+	Sender            id.UserID
+	RoomID            id.RoomID
+	ThreadRootEventID id.EventID
+	EventID           id.EventID
+	DryRun            bool
+	LogContext        context.Context   // Correlation fields of the command's log lines
+	Memory            map[string]string // Values of {{.MEMORY.key}}
+}
+
+// ExecOption is a function that modifies ExecOptions
+type ExecOption func(*ExecOptions)
+
+// WithSender sets the user who triggered the command
+func WithSender(userID id.UserID) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.Sender = userID
+	}
+}
+
+// WithRoom sets the room the command was sent in
+func WithRoom(roomID id.RoomID) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.RoomID = roomID
+	}
+}
+
+// WithThread sets the thread root event the command was sent in
+func WithThread(eventID id.EventID) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.ThreadRootEventID = eventID
+	}
+}
+
+// WithEventID sets the event ID of the message that triggered the command
+func WithEventID(eventID id.EventID) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.EventID = eventID
+	}
+}
+
+// WithDryRun renders the command without executing it; ExecuteCommand returns
+// the full command line instead of its output and leaves the context untouched
+func WithDryRun(dryRun bool) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.DryRun = dryRun
+	}
+}
+
+// WithMemory sets the remembered values substituted for {{.MEMORY.key}}
+func WithMemory(values map[string]string) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.Memory = values
+	}
+}
+
+// WithLogContext tags the log lines of the command with the request ID and
+// correlation fields carried by ctx. It does not cancel the command.
+func WithLogContext(ctx context.Context) ExecOption {
+	return func(opts *ExecOptions) {
+		opts.LogContext = ctx
+	}
+}
+
+// userLocalpart returns the localpart of a Matrix user ID (@alice:example.com -> alice)
+func userLocalpart(userID id.UserID) string {
+	localpart := strings.TrimPrefix(string(userID), "@")
+	if idx := strings.Index(localpart, ":"); idx >= 0 {
+		localpart = localpart[:idx]
+	}
+	return localpart
+}
+
+// placeholderValues returns placeholder/value pairs for every supported
+// placeholder, and the {{.MEMORY.key}} placeholders of the template,
+// suitable for strings.NewReplacer. If escape is set, values are
+// shell-escaped.
+func placeholderValues(commandTemplate string, session *Session, message string, options *ExecOptions, escape bool) []string {
+	user := options.Sender
+	if user == "" {
+		user = session.UserID
+	}
+	thread := options.ThreadRootEventID
+	if thread == "" {
+		thread = session.ThreadRootEvent
+	}
+
+	// Supported placeholders:
+	// {{.MESSAGE}}        - the user's message
+	// {{.CONTEXT}}        - previous command output
+	// {{.SESSION}}        - path to the session file for pi --session
+	// {{.USER}}           - full Matrix ID of the sender
+	// {{.USER_LOCALPART}} - localpart of the sender's Matrix ID
+	// {{.ROOM}}           - room ID the message was sent in
+	// {{.THREAD}}         - thread root event ID (empty if not in a thread)
+	// {{.EVENT_ID}}       - event ID of the triggering message
+	// {{.TIMESTAMP}}      - execution time in RFC 3339 format
+	// {{.MEMORY.key}}     - value remembered with /set, empty if unset
+	pairs := []string{
+		"{{.MESSAGE}}", message,
+		"{{.CONTEXT}}", session.Context,
+		"{{.SESSION}}", session.SessionFile,
+		"{{.USER}}", string(user),
+		"{{.USER_LOCALPART}}", userLocalpart(user),
+		"{{.ROOM}}", string(options.RoomID),
+		"{{.THREAD}}", string(thread),
+		"{{.EVENT_ID}}", string(options.EventID),
+		"{{.TIMESTAMP}}", time.Now().UTC().Format(time.RFC3339),
+	}
+	// Substituted in the same pass as the others, so that no value is
+	// searched for placeholders
+	for _, match := range placeholderRegex.FindAllStringSubmatch(commandTemplate, -1) {
+		if match[1] == "MEMORY" && match[2] != "" {
+			pairs = append(pairs, match[0], options.Memory[match[2]])
+		}
+	}
+	if escape {
+		for i := 1; i < len(pairs); i += 2 {
+			pairs[i] = shellEscape(pairs[i])
+		}
+	}
+	return pairs
+}
+
+// renderCommand substitutes all supported placeholders in a command template.
+// Every substituted value is shell-escaped.
+func renderCommand(commandTemplate string, session *Session, message string, options *ExecOptions) string {
+	replacer := strings.NewReplacer(placeholderValues(commandTemplate, session, message, options, true)...)
+	return replacer.Replace(commandTemplate)
+}
+
+// renderArgv splits a command template into arguments and then substitutes
+// placeholders in each argument. Because splitting happens before
+// substitution, a placeholder value always stays inside the argument it was
+// written in and is never interpreted by a shell.
+func renderArgv(commandTemplate string, session *Session, message string, options *ExecOptions) ([]string, error) {
+	argv, err := splitArgv(commandTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("command template is empty")
+	}
+
+	replacer := strings.NewReplacer(placeholderValues(commandTemplate, session, message, options, false)...)
+	for i, arg := range argv {
+		argv[i] = replacer.Replace(arg)
+	}
+	return argv, nil
+}
+
 // ExecuteCommand runs a shell command with the given message
-func (m *Manager) ExecuteCommand(session *Session, message string) (string, error) {
+func (m *Manager) ExecuteCommand(session *Session, message string, opts ...ExecOption) (output string, err error) {
+	options := &ExecOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logContext := options.LogContext
+	if logContext == nil {
+		logContext = context.Background()
+	}
+	log := m.logger.Ctx(logContext).With("session", session.ID)
+
 	session.Mutex.Lock()
 	defer session.Mutex.Unlock()
 
 	// Update last activity
 	session.LastActivity = time.Now()
 
+	m.mutex.RLock()
+	defaultCommand, execMode, allowlist, transcriptLimit := m.defaultCommand, m.execMode, m.allowlist, m.transcriptLimit
+	m.mutex.RUnlock()
+
+	// Runs before the session is unlocked
+	if !options.DryRun {
+		entry := TranscriptEntry{Time: session.LastActivity, Sender: string(options.Sender), RoomID: string(options.RoomID), Thread: string(options.ThreadRootEventID), Command: message}
+		if entry.Sender == "" {
+			entry.Sender = string(session.UserID)
+		}
+		defer func() {
+			entry.Output = output
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			m.recordTranscript(session, entry, transcriptLimit)
+		}()
+	}
+
 	// Determine command to execute
 	commandTemplate := session.Command
 	if commandTemplate == "" {
-		commandTemplate = m.defaultCommand
+		commandTemplate = defaultCommand
 	}
 
 	if commandTemplate == "" {
 		return "", fmt.Errorf("no command template configured")
 	}
 
-	m.logger.Info("Executing command with template: %s", commandTemplate)
-	m.logger.Debug("Message to execute: %s", message)
+	log.Info("Executing command with template: %s", commandTemplate)
+	log.Debug("Message to execute: %s", log.Message(message))
+
+	// Build the full command by replacing placeholders
+	var cmd *exec.Cmd
+	var fullCommand string
+	if execMode == ExecModeArgv {
+		argv, err := renderArgv(commandTemplate, session, message, options)
+		if err != nil {
+			log.Error("Failed to build argv from template: %v", err)
+			return "", fmt.Errorf("invalid command template: %w", err)
+		}
+		if !allowlist.Allows(argv[0]) {
+			log.Warn("Refusing to execute command: executable %q is not in the allowlist", argv[0])
+			return "", fmt.Errorf("command rejected: executable %q is not in the allowlist", argv[0])
+		}
+		fullCommand = joinArgv(argv)
+		cmd = exec.CommandContext(session.commandContext(), argv[0], argv[1:]...)
+	} else {
+		fullCommand = renderCommand(commandTemplate, session, message, options)
+		if err := allowlist.ValidateCommand(fullCommand); err != nil {
+			log.Warn("Refusing to execute command: %v (command: %s)", err, log.Message(fullCommand))
+			return "", fmt.Errorf("command rejected: %w", err)
+		}
+		cmd = exec.CommandContext(session.commandContext(), "sh", "-c", fullCommand)
+	}
 
-	// Build the full command by replacing placeholders:
-	// {{.MESSAGE}} - the user's message (shell-escaped)
-	// {{.CONTEXT}} - previous command output (shell-escaped)
-	// {{.SESSION}} - path to the session file for pi --session
-	fullCommand := strings.ReplaceAll(commandTemplate, "{{.MESSAGE}}", shellEscape(message))
-	fullCommand = strings.ReplaceAll(fullCommand, "{{.CONTEXT}}", shellEscape(session.Context))
-	fullCommand = strings.ReplaceAll(fullCommand, "{{.SESSION}}", shellEscape(session.SessionFile))
+	killProcessGroupOnCancel(cmd)
 
-	m.logger.Info("Full command to execute: %s", fullCommand)
+	if options.DryRun {
+		log.Info("Dry run, not executing command: %s", log.Message(fullCommand))
+		return fullCommand, nil
+	}
+
+	log.Info("Full command to execute: %s", log.Message(fullCommand))
 
 	// Execute the command with timeout
-	cmd := exec.Command("sh", "-c", fullCommand)
 
 	// Set a reasonable timeout (1 hour)
 	timeout := 60 * time.Minute
@@ -255,29 +721,185 @@ func (m *Manager) ExecuteCommand(session *Session, message string) (string, erro
 		outputStr := string(result.output)
 
 		if result.err != nil {
+			if session.commandContext().Err() != nil {
+				log.Warn("Command in session %s was stopped because the session was killed", session.ID)
+				return "", ErrSessionKilled
+			}
 			// Check if it's a timeout
 			if strings.Contains(outputStr, "context deadline exceeded") || strings.Contains(result.err.Error(), "timeout") {
-				m.logger.Error("Command timed out")
-				return "", fmt.Errorf("command timed out after %v", timeout)
+				log.Error("Command timed out")
+				return "", &TimeoutError{Timeout: timeout}
 			}
-			m.logger.Error("Command failed: %v, output: %s", result.err, outputStr)
+			log.Error("Command failed: %v, output: %s", result.err, log.Message(outputStr))
 			return "", fmt.Errorf("command failed: %v - %s", result.err, outputStr)
 		}
 
 		// Update session context with the output
 		session.Context = outputStr
-		m.logger.Info("Command executed successfully, output length: %d", len(outputStr))
+		log.Info("Command executed successfully, output length: %d", len(outputStr))
 
 		return outputStr, nil
 
 	case <-time.After(timeout):
 		// Kill the process if it times out
 		if err := cmd.Process.Kill(); err != nil {
-			m.logger.Error("Failed to kill timed out process: %v", err)
+			log.Error("Failed to kill timed out process: %v", err)
+		}
+		log.Error("Command timed out after %v", timeout)
+		return "", &TimeoutError{Timeout: timeout}
+	}
+}
+
+// QueueCommand schedules a command to run in the session after all previously
+// queued commands have finished. It returns the number of commands ahead of
+// this one. done is called with the command result once it has run; commands
+// (and their done callbacks) run strictly in submission order.
+func (m *Manager) QueueCommand(session *Session, message string, done func(output string, err error), opts ...ExecOption) (int, error) {
+	m.mutex.RLock()
+	queueDepth := m.queueDepth
+	m.mutex.RUnlock()
+
+	session.queueMutex.Lock()
+	ahead := session.pending
+	if ahead > queueDepth {
+		session.queueMutex.Unlock()
+		m.logger.Warn("Rejecting command for session %s: %d commands already queued", session.ID, ahead)
+		return ahead, ErrQueueFull
+	}
+	session.pending++
+	m.running.Add(1)
+	prev := session.queueTail
+	finished := make(chan struct{})
+	session.queueTail = finished
+	generation := session.flushGen
+	session.queueMutex.Unlock()
+
+	if ahead > 0 {
+		m.logger.Info("Queued command for session %s behind %d command(s)", session.ID, ahead)
+	}
+
+	go func() {
+		defer m.running.Done()
+		defer close(finished)
+		if prev != nil {
+			<-prev
+		}
+
+		// The command may have been dropped while it waited
+		session.queueMutex.Lock()
+		var skipErr error
+		switch {
+		case session.killed:
+			skipErr = ErrSessionKilled
+		case generation != session.flushGen:
+			skipErr = ErrCommandFlushed
+		default:
+			session.running = true
+		}
+		session.queueMutex.Unlock()
+
+		var output string
+		var err error
+		if skipErr != nil {
+			m.logger.Info("Dropping queued command for session %s: %v", session.ID, skipErr)
+			err = skipErr
+		} else {
+			output, err = m.ExecuteCommand(session, message, opts...)
+		}
+
+		session.queueMutex.Lock()
+		session.pending--
+		session.running = false
+		session.queueMutex.Unlock()
+
+		if done != nil {
+			done(output, err)
+		}
+	}()
+
+	return ahead, nil
+}
+
+// QueueLength returns the number of commands queued or running in the session
+func (m *Manager) QueueLength(session *Session) int {
+	session.queueMutex.Lock()
+	defer session.queueMutex.Unlock()
+	return session.pending
+}
+
+// ListSessions returns a snapshot of all live sessions, most recently active first
+func (m *Manager) ListSessions() []SessionInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		info := SessionInfo{
+			ID:           session.ID,
+			UserID:       string(session.UserID),
+			ThreadRoot:   string(session.ThreadRootEvent),
+			LastActivity: session.LastActivity,
+			Pending:      m.QueueLength(session),
+		}
+		for userID := range session.collaborators {
+			info.Collaborators = append(info.Collaborators, string(userID))
 		}
-		m.logger.Error("Command timed out after %v", timeout)
-		return "", fmt.Errorf("command timed out after %v", timeout)
+		sort.Strings(info.Collaborators)
+		infos = append(infos, info)
 	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LastActivity.After(infos[j].LastActivity)
+	})
+	return infos
+}
+
+// KillSession removes a session, stops its running command and drops its
+// queued commands. It reports whether the session existed.
+func (m *Manager) KillSession(key string) bool {
+	m.mutex.Lock()
+	session, exists := m.sessions[key]
+	delete(m.sessions, key)
+	m.mutex.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	session.queueMutex.Lock()
+	session.killed = true
+	session.queueMutex.Unlock()
+	if session.cancel != nil {
+		session.cancel()
+	}
+
+	m.logger.Info("Killed session: key=%s, userID=%s", key, session.UserID)
+	return true
+}
+
+// FlushQueues drops every command that is queued but not yet running, in all
+// sessions. Running commands are not affected. It returns the number of
+// commands dropped.
+func (m *Manager) FlushQueues() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	flushed := 0
+	for _, session := range m.sessions {
+		session.queueMutex.Lock()
+		waiting := session.pending
+		if session.running {
+			waiting--
+		}
+		if waiting > 0 {
+			session.flushGen++
+			flushed += waiting
+		}
+		session.queueMutex.Unlock()
+	}
+
+	m.logger.Info("Flushed %d queued commands", flushed)
+	return flushed
 }
 
 // UpdateContext updates the session context
@@ -1,6 +1,8 @@
 package session
 
 import (
+	"context"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -396,3 +398,387 @@ func TestExecuteCommandErrorMessage(t *testing.T) {
 		t.Logf("Note: Error handling may vary, output: %s, err: %v", output, err)
 	}
 }
+
+func TestExecuteCommandRequestPlaceholders(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	template := "printf '%s|%s|%s|%s|%s' {{.USER}} {{.USER_LOCALPART}} {{.ROOM}} {{.THREAD}} {{.EVENT_ID}}"
+	m := NewManager(log, 600, template, "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	userID := id.UserID("@alice:matrix.org")
+	session := m.GetOrCreateSession("$root", userID, template)
+
+	output, err := m.ExecuteCommand(session, "hello",
+		WithSender(userID),
+		WithRoom("!room:matrix.org"),
+		WithThread("$root"),
+		WithEventID("$event"))
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	expected := "@alice:matrix.org|alice|!room:matrix.org|$root|$event"
+	if output != expected {
+		t.Errorf("ExecuteCommand() output = %q, want %q", output, expected)
+	}
+}
+
+func TestExecuteCommandMemoryPlaceholders(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	template := "printf '%s|%s' {{.MEMORY.lang}} {{.MEMORY.unset}}"
+	m := NewManager(log, 600, template, "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	output, err := m.ExecuteCommand(session, "", WithMemory(map[string]string{"lang": "de'; echo pwned"}))
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if expected := "de'; echo pwned|"; output != expected {
+		t.Errorf("ExecuteCommand() output = %q, want %q", output, expected)
+	}
+}
+
+func TestExecuteCommandTimestampPlaceholder(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "printf '%s' {{.TIMESTAMP}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	output, err := m.ExecuteCommand(session, "")
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if _, err := time.Parse(time.RFC3339, output); err != nil {
+		t.Errorf("{{.TIMESTAMP}} = %q, not RFC 3339: %v", output, err)
+	}
+}
+
+func TestExecuteCommandPlaceholdersAreEscaped(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "printf '%s' {{.USER}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+
+	output, err := m.ExecuteCommand(session, "", WithSender("@x';echo pwned;':matrix.org"))
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if output != "@x';echo pwned;':matrix.org" {
+		t.Errorf("ExecuteCommand() output = %q, placeholder was not escaped", output)
+	}
+}
+
+func TestQueueCommandRunsInOrder(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 0.1; echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("$queue", "@user:matrix.org", "")
+
+	results := make(chan string, 3)
+	for i, msg := range []string{"first", "second", "third"} {
+		ahead, err := m.QueueCommand(session, msg, func(output string, err error) {
+			if err != nil {
+				t.Errorf("queued command error = %v", err)
+			}
+			results <- output
+		})
+		if err != nil {
+			t.Fatalf("QueueCommand(%q) error = %v", msg, err)
+		}
+		if ahead != i {
+			t.Errorf("QueueCommand(%q) ahead = %d, want %d", msg, ahead, i)
+		}
+	}
+
+	for _, want := range []string{"first\n", "second\n", "third\n"} {
+		select {
+		case got := <-results:
+			if got != want {
+				t.Errorf("queued output = %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for queued command")
+		}
+	}
+}
+
+func TestQueueCommandRejectsWhenFull(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 0.2", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+	m.SetQueueDepth(1)
+
+	session := m.GetOrCreateSession("$full", "@user:matrix.org", "")
+
+	finished := make(chan struct{}, 2)
+	done := func(string, error) { finished <- struct{}{} }
+
+	if _, err := m.QueueCommand(session, "", done); err != nil {
+		t.Fatalf("first QueueCommand() error = %v", err)
+	}
+	if _, err := m.QueueCommand(session, "", done); err != nil {
+		t.Fatalf("second QueueCommand() error = %v", err)
+	}
+	if _, err := m.QueueCommand(session, "", done); err != ErrQueueFull {
+		t.Errorf("third QueueCommand() error = %v, want %v", err, ErrQueueFull)
+	}
+
+	<-finished
+	<-finished
+	if n := m.QueueLength(session); n != 0 {
+		t.Errorf("QueueLength() = %d after queue drained, want 0", n)
+	}
+}
+
+func TestExecuteCommandDryRun(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "touch /tmp/pi-sessions/dry-run-marker && echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+	os.Remove("/tmp/pi-sessions/dry-run-marker")
+
+	session := m.GetOrCreateSession("", "@user:matrix.org", "")
+	m.UpdateContext(session, "previous")
+
+	output, err := m.ExecuteCommand(session, "it's here", WithDryRun(true))
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	expected := `touch /tmp/pi-sessions/dry-run-marker && echo 'it'\''s here'`
+	if output != expected {
+		t.Errorf("ExecuteCommand() dry-run output = %q, want %q", output, expected)
+	}
+	if _, err := os.Stat("/tmp/pi-sessions/dry-run-marker"); err == nil {
+		t.Error("dry run should not execute the command")
+	}
+	if session.Context != "previous" {
+		t.Errorf("dry run should not change context, got %q", session.Context)
+	}
+}
+
+func TestMaxSessionsEvictsLeastRecentlyUsed(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", t.TempDir())
+	m.Stop() // Stop cleanup goroutine
+	m.SetMaxSessions(2)
+
+	first := m.GetOrCreateSession("$first", "@user:matrix.org", "")
+	first.LastActivity = time.Now().Add(-2 * time.Minute)
+	second := m.GetOrCreateSession("$second", "@user:matrix.org", "")
+	second.LastActivity = time.Now().Add(-1 * time.Minute)
+
+	m.GetOrCreateSession("$third", "@user:matrix.org", "")
+
+	if m.GetSessionCount() != 2 {
+		t.Errorf("GetSessionCount() = %d, want 2", m.GetSessionCount())
+	}
+	if m.GetSession("$first", "") != nil {
+		t.Error("least recently used session should have been evicted")
+	}
+	if m.GetSession("$second", "") == nil {
+		t.Error("more recently used session should have been kept")
+	}
+}
+
+func TestCleanupRemovesOrphanedSessionFiles(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	dir := t.TempDir()
+	m := NewManager(log, 1, "echo {{.MESSAGE}}", dir)
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("$live", "@user:matrix.org", "")
+	old := time.Now().Add(-time.Hour)
+
+	orphan := dir + "/thread_orphan.jsonl"
+	recent := dir + "/thread_recent.jsonl"
+	for _, file := range []string{orphan, recent, session.SessionFile} {
+		if err := os.WriteFile(file, []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Chtimes(orphan, old, old)
+	os.Chtimes(session.SessionFile, old, old)
+
+	m.Cleanup()
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned session file should have been removed")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("recently modified session file should have been kept")
+	}
+	if _, err := os.Stat(session.SessionFile); err != nil {
+		t.Error("session file of a live session should have been kept")
+	}
+}
+
+func TestShareSession(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	owner := id.UserID("@owner:matrix.org")
+	guest := id.UserID("@guest:matrix.org")
+	session := m.GetOrCreateSession("$shared", owner, "")
+
+	if !m.CanUseSession(session, owner) {
+		t.Error("owner should be able to use their session")
+	}
+	if m.CanUseSession(session, guest) {
+		t.Error("guest should not be able to use a session that was not shared")
+	}
+
+	m.ShareSession(session, guest)
+
+	if !m.CanUseSession(session, guest) {
+		t.Error("guest should be able to use a shared session")
+	}
+	if m.CanUseSession(session, "@other:matrix.org") {
+		t.Error("sharing with one user should not grant access to others")
+	}
+}
+
+func TestWaitForQueuedCommands(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 0.2; echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	defer m.Stop()
+
+	session := m.GetOrCreateSession("$wait", "@user:matrix.org", "")
+
+	var output string
+	if _, err := m.QueueCommand(session, "drained", func(out string, err error) { output = out }); err != nil {
+		t.Fatalf("QueueCommand() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if output != "drained\n" {
+		t.Errorf("command output after Wait() = %q, want %q", output, "drained\n")
+	}
+}
+
+func TestWaitTimesOut(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 1", "/tmp/pi-sessions")
+	defer m.Stop()
+
+	session := m.GetOrCreateSession("$slow", "@user:matrix.org", "")
+	if _, err := m.QueueCommand(session, "", nil); err != nil {
+		t.Fatalf("QueueCommand() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestStopIsIdempotent(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop()
+	m.Stop()
+}
+
+func TestFlushQueuesDropsWaitingCommands(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 0.3; echo {{.MESSAGE}}", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("$flush", "@user:matrix.org", "")
+
+	type result struct {
+		output string
+		err    error
+	}
+	results := make(chan result, 3)
+	for _, msg := range []string{"running", "waiting1", "waiting2"} {
+		if _, err := m.QueueCommand(session, msg, func(output string, err error) {
+			results <- result{output, err}
+		}); err != nil {
+			t.Fatalf("QueueCommand(%q) error = %v", msg, err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // Let the first command start
+	if flushed := m.FlushQueues(); flushed != 2 {
+		t.Errorf("FlushQueues() = %d, want 2", flushed)
+	}
+
+	first := <-results
+	if first.err != nil || first.output != "running\n" {
+		t.Errorf("running command = %q, %v, want it to complete", first.output, first.err)
+	}
+	for i := 0; i < 2; i++ {
+		if r := <-results; r.err != ErrCommandFlushed {
+			t.Errorf("flushed command error = %v, want %v", r.err, ErrCommandFlushed)
+		}
+	}
+}
+
+func TestKillSessionStopsRunningCommand(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "sleep 5", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	session := m.GetOrCreateSession("$kill", "@user:matrix.org", "")
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := m.QueueCommand(session, "", func(_ string, err error) { errs <- err }); err != nil {
+			t.Fatalf("QueueCommand() error = %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // Let the first command start
+	start := time.Now()
+	if !m.KillSession(session.ID) {
+		t.Fatal("KillSession() = false, want true")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrSessionKilled {
+			t.Errorf("command error = %v, want %v", err, ErrSessionKilled)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("killed command took %v to stop", elapsed)
+	}
+	if m.GetSession("$kill", "@user:matrix.org") != nil {
+		t.Error("killed session should be removed")
+	}
+	if m.KillSession(session.ID) {
+		t.Error("KillSession() of a removed session = true, want false")
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo", "/tmp/pi-sessions")
+	m.Stop() // Stop cleanup goroutine
+
+	older := m.GetOrCreateSession("$older", "@alice:matrix.org", "")
+	older.LastActivity = time.Now().Add(-time.Minute)
+	m.GetOrCreateSession("$newer", "@bob:matrix.org", "")
+	m.ShareSession(older, "@carol:matrix.org")
+
+	infos := m.ListSessions()
+	if len(infos) != 2 {
+		t.Fatalf("ListSessions() returned %d sessions, want 2", len(infos))
+	}
+	if infos[0].ID != "newer" || infos[1].ID != "older" {
+		t.Errorf("ListSessions() order = %s, %s, want newer, older", infos[0].ID, infos[1].ID)
+	}
+	if infos[1].UserID != "@alice:matrix.org" || len(infos[1].Collaborators) != 1 || infos[1].Collaborators[0] != "@carol:matrix.org" {
+		t.Errorf("ListSessions() older session = %+v", infos[1])
+	}
+}
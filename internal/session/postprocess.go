@@ -0,0 +1,138 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// outputStep transforms command output
+type outputStep func(output string) (string, error)
+
+// OutputPipeline is a compiled list of post-processing steps applied to
+// command output before it is posted to Matrix
+type OutputPipeline struct {
+	steps []outputStep
+}
+
+// NewOutputPipeline compiles post-processor steps from config. Each step must
+// set exactly one of jq, regex or tail. It returns nil for an empty list.
+func NewOutputPipeline(stepConfigs []config.OutputProcessorStep) (*OutputPipeline, error) {
+	if len(stepConfigs) == 0 {
+		return nil, nil
+	}
+
+	p := &OutputPipeline{}
+	for i, cfg := range stepConfigs {
+		set := 0
+		if cfg.JQ != "" {
+			set++
+		}
+		if cfg.Regex != "" {
+			set++
+		}
+		if cfg.Tail > 0 {
+			set++
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("step %d: exactly one of jq, regex or tail must be set", i+1)
+		}
+
+		switch {
+		case cfg.JQ != "":
+			step, err := jqStep(cfg.JQ)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			p.steps = append(p.steps, step)
+		case cfg.Regex != "":
+			re, err := regexp.Compile(cfg.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: invalid regex: %w", i+1, err)
+			}
+			replace := cfg.Replace
+			p.steps = append(p.steps, func(output string) (string, error) {
+				return re.ReplaceAllString(output, replace), nil
+			})
+		default:
+			p.steps = append(p.steps, tailStep(cfg.Tail))
+		}
+	}
+	return p, nil
+}
+
+// Apply runs every step in order. A nil pipeline returns the output unchanged.
+func (p *OutputPipeline) Apply(output string) (string, error) {
+	if p == nil {
+		return output, nil
+	}
+	for _, step := range p.steps {
+		var err error
+		output, err = step(output)
+		if err != nil {
+			return "", err
+		}
+	}
+	return output, nil
+}
+
+// jqStep parses the output as JSON and runs a jq program on it. Multiple
+// results are joined with newlines, strings are emitted without quotes.
+func jqStep(program string) (outputStep, error) {
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq program: %w", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq program: %w", err)
+	}
+
+	return func(output string) (string, error) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(output), &data); err != nil {
+			return "", fmt.Errorf("output is not valid JSON: %w", err)
+		}
+
+		var results []string
+		iter := code.Run(data)
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				return "", fmt.Errorf("jq execution error: %w", err)
+			}
+
+			switch val := v.(type) {
+			case string:
+				results = append(results, val)
+			case nil:
+				results = append(results, "")
+			default:
+				jsonBytes, err := json.Marshal(val)
+				if err != nil {
+					return "", fmt.Errorf("failed to marshal jq result: %w", err)
+				}
+				results = append(results, string(jsonBytes))
+			}
+		}
+		return strings.Join(results, "\n"), nil
+	}, nil
+}
+
+// tailStep keeps only the last n lines of output
+func tailStep(n int) outputStep {
+	return func(output string) (string, error) {
+		lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+		if len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestOutputPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []config.OutputProcessorStep
+		input    string
+		expected string
+	}{
+		{
+			name:     "No steps returns output unchanged",
+			steps:    nil,
+			input:    "raw output\n",
+			expected: "raw output\n",
+		},
+		{
+			name:     "jq selects a field",
+			steps:    []config.OutputProcessorStep{{JQ: ".result"}},
+			input:    `{"result": "done", "cost": 3}`,
+			expected: "done",
+		},
+		{
+			name:     "jq with multiple results",
+			steps:    []config.OutputProcessorStep{{JQ: ".items[].name"}},
+			input:    `{"items": [{"name": "a"}, {"name": "b"}]}`,
+			expected: "a\nb",
+		},
+		{
+			name:     "Regex replacement strips ANSI colors",
+			steps:    []config.OutputProcessorStep{{Regex: `\x1b\[[0-9;]*m`, Replace: ""}},
+			input:    "\x1b[32mgreen\x1b[0m text",
+			expected: "green text",
+		},
+		{
+			name:     "Tail keeps last lines",
+			steps:    []config.OutputProcessorStep{{Tail: 2}},
+			input:    "one\ntwo\nthree\nfour\n",
+			expected: "three\nfour",
+		},
+		{
+			name: "Steps run in order",
+			steps: []config.OutputProcessorStep{
+				{JQ: ".log"},
+				{Tail: 1},
+				{Regex: `^(\w+):`, Replace: "[$1]"},
+			},
+			input:    `{"log": "INFO: start\nERROR: failed"}`,
+			expected: "[ERROR] failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewOutputPipeline(tt.steps)
+			if err != nil {
+				t.Fatalf("NewOutputPipeline() error = %v", err)
+			}
+			output, err := pipeline.Apply(tt.input)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("Apply() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewOutputPipelineRejectsInvalidSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []config.OutputProcessorStep
+	}{
+		{"Empty step", []config.OutputProcessorStep{{}}},
+		{"Multiple actions in one step", []config.OutputProcessorStep{{JQ: ".a", Tail: 3}}},
+		{"Invalid jq", []config.OutputProcessorStep{{JQ: ".a["}}},
+		{"Invalid regex", []config.OutputProcessorStep{{Regex: "("}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOutputPipeline(tt.steps); err == nil {
+				t.Error("NewOutputPipeline() should return an error")
+			}
+		})
+	}
+}
+
+func TestOutputPipelineJQOnNonJSON(t *testing.T) {
+	pipeline, err := NewOutputPipeline([]config.OutputProcessorStep{{JQ: ".result"}})
+	if err != nil {
+		t.Fatalf("NewOutputPipeline() error = %v", err)
+	}
+	if _, err := pipeline.Apply("not json"); err == nil {
+		t.Error("Apply() should error when output is not JSON")
+	}
+}
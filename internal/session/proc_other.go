@@ -0,0 +1,9 @@
+//go:build !unix
+
+package session
+
+import "os/exec"
+
+// killProcessGroupOnCancel is a no-op where process groups are unavailable;
+// cancellation only kills the command's own process
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transcript formats
+const (
+	// TranscriptMarkdown renders a transcript as a Markdown document
+	TranscriptMarkdown = "markdown"
+	// TranscriptJSONL renders a transcript as one JSON object per command
+	TranscriptJSONL = "jsonl"
+)
+
+// TranscriptEntry is a command run in a session and its result
+type TranscriptEntry struct {
+	Time    time.Time `json:"time"`
+	Sender  string    `json:"sender"`
+	RoomID  string    `json:"room_id,omitempty"`
+	Thread  string    `json:"thread,omitempty"`
+	Command string    `json:"command"` // The message after the command prefix
+	Output  string    `json:"output,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// SetTranscriptLimit sets how many commands the transcript of a session
+// keeps, older ones are dropped. Zero keeps no transcript.
+func (m *Manager) SetTranscriptLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.transcriptLimit = limit
+}
+
+// SetExpiryHandler sets a function called in its own goroutine with each
+// session that expires or is evicted, e.g. to save its transcript
+func (m *Manager) SetExpiryHandler(handler func(*Session)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onExpire = handler
+}
+
+// expired hands a removed session to the expiry handler. Must be called with
+// m.mutex held.
+func (m *Manager) expired(session *Session) {
+	if m.onExpire != nil {
+		go m.onExpire(session)
+	}
+}
+
+// recordTranscript appends a command to the transcript of the session. Must
+// be called with session.Mutex held.
+func (m *Manager) recordTranscript(session *Session, entry TranscriptEntry, limit int) {
+	if limit == 0 {
+		return
+	}
+	session.transcript = append(session.transcript, entry)
+	if excess := len(session.transcript) - limit; excess > 0 {
+		session.transcript = append([]TranscriptEntry(nil), session.transcript[excess:]...)
+		session.transcriptDropped += excess
+	}
+}
+
+// Transcript returns the commands kept for the session, oldest first, and
+// how many older ones were dropped
+func (m *Manager) Transcript(session *Session) ([]TranscriptEntry, int) {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+	return append([]TranscriptEntry(nil), session.transcript...), session.transcriptDropped
+}
+
+// RenderTranscript renders the transcript of the session as a file in
+// format. It returns the file and its name and MIME type.
+func (m *Manager) RenderTranscript(session *Session, format string) (data []byte, filename, mimeType string, err error) {
+	entries, dropped := m.Transcript(session)
+	switch format {
+	case "", TranscriptMarkdown:
+		return renderMarkdownTranscript(session, entries, dropped), fmt.Sprintf("transcript_%s.md", session.ID), "text/markdown", nil
+	case TranscriptJSONL:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return nil, "", "", err
+			}
+		}
+		return buf.Bytes(), fmt.Sprintf("transcript_%s.jsonl", session.ID), "application/x-ndjson", nil
+	default:
+		return nil, "", "", fmt.Errorf("unknown transcript format %q", format)
+	}
+}
+
+func renderMarkdownTranscript(session *Session, entries []TranscriptEntry, dropped int) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s\n\n", session.ID)
+	fmt.Fprintf(&b, "- Owner: %s\n", session.UserID)
+	fmt.Fprintf(&b, "- Exported: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if dropped > 0 {
+		fmt.Fprintf(&b, "- Commands: %d (%d earlier ones were dropped)\n", len(entries), dropped)
+	} else {
+		fmt.Fprintf(&b, "- Commands: %d\n", len(entries))
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "\n## %s %s\n\n", entry.Time.UTC().Format(time.RFC3339), entry.Sender)
+		writeCodeBlock(&b, entry.Command)
+		b.WriteString("\n")
+		if entry.Error != "" {
+			b.WriteString("Failed:\n\n")
+			writeCodeBlock(&b, entry.Error)
+		} else if entry.Output != "" {
+			writeCodeBlock(&b, entry.Output)
+		} else {
+			b.WriteString("No output\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// writeCodeBlock writes text as a fenced code block, with a fence longer
+// than any run of backticks in text
+func writeCodeBlock(b *strings.Builder, text string) {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	fmt.Fprintf(b, "%s\n%s\n%s\n", fence, strings.TrimRight(text, "\n"), fence)
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTranscript(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "", t.TempDir())
+	m.Stop()
+	m.SetTranscriptLimit(2)
+
+	sess := m.GetOrCreateSession("$thread", "@alice:example.com", "echo {{.MESSAGE}}")
+	m.ExecuteCommand(sess, "first")
+	m.ExecuteCommand(sess, "render only", WithDryRun(true))
+	m.ExecuteCommand(sess, "has ``` fences", WithSender("@bob:example.com"), WithRoom("!room:example.com"))
+	sess.Command = "false"
+	m.ExecuteCommand(sess, "fails")
+
+	entries, dropped := m.Transcript(sess)
+	if len(entries) != 2 || dropped != 1 {
+		t.Fatalf("Transcript() = %+v, %d dropped, want the last 2 commands and 1 dropped", entries, dropped)
+	}
+	if e := entries[0]; e.Command != "has ``` fences" || e.Output != "has ``` fences\n" || e.Sender != "@bob:example.com" || e.RoomID != "!room:example.com" || e.Error != "" {
+		t.Errorf("entries[0] = %+v", e)
+	}
+	if e := entries[1]; e.Command != "fails" || e.Sender != "@alice:example.com" || e.Error == "" {
+		t.Errorf("entries[1] = %+v, want the failure of the owner's command", e)
+	}
+
+	data, filename, mimeType, err := m.RenderTranscript(sess, TranscriptMarkdown)
+	if err != nil || filename != "transcript_thread.md" || mimeType != "text/markdown" {
+		t.Fatalf("RenderTranscript(markdown) = %q, %q, %v", filename, mimeType, err)
+	}
+	markdown := string(data)
+	for _, want := range []string{"# Session thread", "- Owner: @alice:example.com", "(1 earlier ones were dropped)", "````\nhas ``` fences\n````", "Failed:"} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("Markdown transcript lacks %q:\n%s", want, markdown)
+		}
+	}
+
+	data, filename, _, err = m.RenderTranscript(sess, TranscriptJSONL)
+	if err != nil || filename != "transcript_thread.jsonl" {
+		t.Fatalf("RenderTranscript(jsonl) = %q, %v", filename, err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(bytes.NewReader(data)); scanner.Scan(); lines++ {
+		var entry TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Command != entries[lines].Command {
+			t.Errorf("line %d = %s, %v", lines, scanner.Text(), err)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("JSONL transcript has %d lines, want 2", lines)
+	}
+
+	if _, _, _, err := m.RenderTranscript(sess, "pdf"); err == nil {
+		t.Error("RenderTranscript(pdf) succeeded")
+	}
+}
+
+func TestTranscriptDisabled(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 600, "echo {{.MESSAGE}}", t.TempDir())
+	m.Stop()
+
+	sess := m.GetOrCreateSession("", "@alice:example.com", "")
+	m.ExecuteCommand(sess, "hello")
+	if entries, _ := m.Transcript(sess); len(entries) != 0 {
+		t.Errorf("Transcript() = %+v without a limit", entries)
+	}
+}
+
+func TestExpiryHandler(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	m := NewManager(log, 1, "echo {{.MESSAGE}}", t.TempDir())
+	m.Stop()
+	m.SetMaxSessions(1)
+	expired := make(chan *Session, 2)
+	m.SetExpiryHandler(func(s *Session) { expired <- s })
+
+	first := m.GetOrCreateSession("$first", "@alice:example.com", "")
+	m.GetOrCreateSession("$second", "@alice:example.com", "") // Evicts the first
+	select {
+	case s := <-expired:
+		if s != first {
+			t.Errorf("Expiry handler called with %s, want the evicted session", s.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expiry handler not called for the evicted session")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	m.Cleanup()
+	select {
+	case s := <-expired:
+		if s.ThreadRootEvent != id.EventID("$second") {
+			t.Errorf("Expiry handler called with %s, want the expired session", s.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expiry handler not called for the expired session")
+	}
+}
@@ -0,0 +1,216 @@
+// Package storage keeps the state of the features (feed subscriptions,
+// schedule runs, reminders, notifications held for quiet hours, the mailbox
+// position, registered commands and handled events, dispatches and hook
+// deliveries) in one database, so that it can be backed up and moved as a
+// whole
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Drivers of storage.driver
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// ErrNotFound is returned for keys that were never stored
+var ErrNotFound = errors.New("not found")
+
+// Store keeps a document, usually JSON, for each key
+type Store interface {
+	// Get returns the document of key, ErrNotFound if there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores the document of key, replacing the previous one
+	Put(ctx context.Context, key string, value []byte) error
+	// DeleteBefore deletes the documents of keys starting with prefix that
+	// were last stored before t, returning how many
+	DeleteBefore(ctx context.Context, prefix string, t time.Time) (int64, error)
+	Close() error
+}
+
+// Open opens the database of cfg and creates its table if needed
+func Open(cfg *config.StorageConfig) (Store, error) {
+	var driverName, dsn string
+	switch strings.ToLower(cfg.Driver) {
+	case DriverSQLite, "":
+		driverName = "sqlite3"
+		dsn = cfg.DSN
+		if !strings.HasPrefix(dsn, "file:") {
+			dsn = "file:" + dsn
+		}
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_txlock=immediate&_busy_timeout=5000"
+		}
+	case DriverPostgres:
+		driverName, dsn = "pgx", cfg.DSN
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", cfg.Driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	s := &sqlStore{db: db, postgres: driverName == "pgx"}
+	if !s.postgres {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS state (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at BIGINT NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return s, nil
+}
+
+// sqlStore keeps the documents in the state table of a SQLite or Postgres
+// database
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// query rewrites the ? placeholders of a query for Postgres
+func (s *sqlStore) query(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT value FROM state WHERE name = ?`), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from storage: %w", key, err)
+	}
+	return []byte(value), nil
+}
+
+func (s *sqlStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO state (name, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`),
+		key, string(value), time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to write %s to storage: %w", key, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteBefore(ctx context.Context, prefix string, t time.Time) (int64, error) {
+	pattern := likeEscaper.Replace(prefix) + "%"
+	result, err := s.db.ExecContext(ctx, s.query(`DELETE FROM state WHERE name LIKE ? ESCAPE '\' AND updated_at < ?`), pattern, t.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete %s* from storage: %w", prefix, err)
+	}
+	return result.RowsAffected()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// memoryStore keeps the documents in memory, e.g. for tests
+type memoryStore struct {
+	mu        sync.Mutex
+	documents map[string]memoryDocument
+}
+
+type memoryDocument struct {
+	value     []byte
+	updatedAt time.Time
+}
+
+// NewMemory returns a store that keeps its documents in memory only
+func NewMemory() Store {
+	return &memoryStore{documents: make(map[string]memoryDocument)}
+}
+
+func (m *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	document, exists := m.documents[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), document.value...), nil
+}
+
+func (m *memoryStore) Put(ctx context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.documents[key] = memoryDocument{value: append([]byte(nil), value...), updatedAt: time.Now()}
+	return nil
+}
+
+func (m *memoryStore) DeleteBefore(ctx context.Context, prefix string, t time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key, document := range m.documents {
+		if strings.HasPrefix(key, prefix) && document.updatedAt.Before(t) {
+			delete(m.documents, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// Load returns the document of key. Until it is first stored, the content
+// of legacyFile, where earlier versions kept it, is returned instead; nil if
+// neither exists.
+func Load(ctx context.Context, store Store, key, legacyFile string) ([]byte, error) {
+	value, err := store.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return value, err
+	}
+	if legacyFile == "" {
+		return nil, nil
+	}
+	value, err = os.ReadFile(legacyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", legacyFile, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,154 @@
+// Package telegram talks to a Telegram chat through the Bot API
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Longest text of a Telegram message, in UTF-16 code units; counting runes
+// is close enough
+const maxMessageLength = 4096
+
+// User is the sender of a message
+type User struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+// Name returns the full name of the user, or the username if it has none
+func (u *User) Name() string {
+	if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+		return name
+	}
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return fmt.Sprintf("%d", u.ID)
+}
+
+// Chat is the chat a message was sent in
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+// Message is a message of a chat. Media messages have a caption instead of
+// a text.
+type Message struct {
+	MessageID      int      `json:"message_id"`
+	From           *User    `json:"from"`
+	Chat           Chat     `json:"chat"`
+	Date           int64    `json:"date"`
+	Text           string   `json:"text"`
+	Caption        string   `json:"caption"`
+	ReplyToMessage *Message `json:"reply_to_message"`
+}
+
+// Update is an event of getUpdates
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+// response is the envelope of Bot API responses
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// Client calls the methods of the Bot API
+type Client struct {
+	apiURL string
+	token  string
+	http   *http.Client
+}
+
+// NewClient creates a client of the Bot API at apiURL, e.g.
+// https://api.telegram.org
+func NewClient(apiURL, token string) *Client {
+	return &Client{apiURL: strings.TrimSuffix(apiURL, "/"), token: token, http: &http.Client{}}
+}
+
+// call posts the JSON params to a method and decodes its result
+func (c *Client) call(ctx context.Context, method string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+"/bot"+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL holds the token, keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("telegram %s failed with status %d", method, resp.StatusCode)
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram %s failed: %d %s", method, r.ErrorCode, r.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// GetUpdates waits up to timeout for the updates from offset on. Passing the
+// ID of the last update plus one confirms the updates before it.
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(timeout / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// SendMessage sends a plain text message, replying to the message replyTo
+// unless it is 0, and returns the ID of the message sent. Text longer than
+// a message is truncated.
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string, replyTo int) (int, error) {
+	if runes := []rune(text); len(runes) > maxMessageLength {
+		text = string(runes[:maxMessageLength-1]) + "…"
+	}
+	params := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}
+	if replyTo != 0 {
+		params["reply_parameters"] = map[string]interface{}{
+			"message_id":                  replyTo,
+			"allow_sending_without_reply": true,
+		}
+	}
+	var sent Message
+	if err := c.call(ctx, "sendMessage", params, &sent); err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
@@ -0,0 +1,166 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// fakeBotAPI serves getUpdates from a queue and records sendMessage calls
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	updates []Update
+	offsets []int64
+	sent    []map[string]interface{}
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/botTOKEN/") {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"ok": false, "error_code": 401, "description": "Unauthorized"}`))
+		return
+	}
+	var params map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&params)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result interface{}
+	switch strings.TrimPrefix(r.URL.Path, "/botTOKEN/") {
+	case "getUpdates":
+		offset := int64(params["offset"].(float64))
+		f.offsets = append(f.offsets, offset)
+		var pending []Update
+		for _, update := range f.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		if len(pending) == 0 {
+			// Stand in for the long poll
+			f.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			f.mu.Lock()
+		}
+		result = pending
+	case "sendMessage":
+		f.sent = append(f.sent, params)
+		result = Message{MessageID: 100 + len(f.sent)}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"ok": false, "error_code": 404, "description": "Not Found"}`))
+		return
+	}
+	data, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": json.RawMessage(data)})
+}
+
+func TestSendMessage(t *testing.T) {
+	api := &fakeBotAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client := NewClient(server.URL, "TOKEN")
+	messageID, err := client.SendMessage(context.Background(), -100, "hello", 7)
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if messageID != 101 {
+		t.Errorf("SendMessage() = %d, want 101", messageID)
+	}
+
+	sent := api.sent[0]
+	if sent["chat_id"].(float64) != -100 || sent["text"] != "hello" {
+		t.Errorf("sendMessage params = %v", sent)
+	}
+	reply, _ := sent["reply_parameters"].(map[string]interface{})
+	if reply["message_id"].(float64) != 7 {
+		t.Errorf("reply_parameters = %v, want message_id 7", sent["reply_parameters"])
+	}
+
+	long := strings.Repeat("é", maxMessageLength+10)
+	if _, err := client.SendMessage(context.Background(), -100, long, 0); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if text := api.sent[1]["text"].(string); len([]rune(text)) != maxMessageLength || !strings.HasSuffix(text, "…") {
+		t.Errorf("SendMessage() sent %d characters, want %d ending with …", len([]rune(text)), maxMessageLength)
+	}
+	if _, exists := api.sent[1]["reply_parameters"]; exists {
+		t.Error("SendMessage() without replyTo sent reply_parameters")
+	}
+}
+
+func TestClientErrorHidesToken(t *testing.T) {
+	api := &fakeBotAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "WRONG").SendMessage(context.Background(), 1, "hello", 0)
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("SendMessage() error = %v, want 401 Unauthorized", err)
+	}
+
+	server.Close()
+	_, err = NewClient(server.URL, "SECRET").SendMessage(context.Background(), 1, "hello", 0)
+	if err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("SendMessage() error = %v, want an error without the token", err)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	api := &fakeBotAPI{updates: []Update{
+		{UpdateID: 10, Message: &Message{MessageID: 1, Chat: Chat{ID: -100}, Text: "first"}},
+		{UpdateID: 11, Message: &Message{MessageID: 2, Chat: Chat{ID: -200}, Text: "other chat"}},
+		{UpdateID: 12},
+		{UpdateID: 13, Message: &Message{MessageID: 3, Chat: Chat{ID: -100}, Text: "second"}},
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	received := make(chan string, 10)
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	relay := NewRelay(&config.TelegramConfig{APIURL: server.URL, Token: "TOKEN", ChatID: -100, PollTimeout: 1}, func(m *Message) {
+		received <- m.Text
+	}, log)
+	relay.Start()
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case text := <-received:
+			if text != expected {
+				t.Errorf("relayed %q, want %q", text, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+	// Wait for the updates to be confirmed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		api.mu.Lock()
+		offset := api.offsets[len(api.offsets)-1]
+		api.mu.Unlock()
+		if offset == 14 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last offset = %d, want 14", offset)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	relay.Stop()
+
+	select {
+	case text := <-received:
+		t.Errorf("relayed %q more than once", text)
+	default:
+	}
+}
@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"context"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// Wait after a failed request for updates
+const retryDelay = 5 * time.Second
+
+// HandleFunc handles a message of the relayed chat
+type HandleFunc func(m *Message)
+
+// Relay receives the messages of a chat by long polling and sends messages
+// to it
+type Relay struct {
+	client  *Client
+	chatID  int64
+	timeout time.Duration
+	handle  HandleFunc
+	logger  *logger.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRelay creates a relay of the chat of cfg, handing its messages to handle
+func NewRelay(cfg *config.TelegramConfig, handle HandleFunc, log *logger.Logger) *Relay {
+	return &Relay{
+		client:  NewClient(cfg.APIURL, cfg.Token),
+		chatID:  cfg.ChatID,
+		timeout: time.Duration(cfg.PollTimeout) * time.Second,
+		handle:  handle,
+		logger:  log,
+	}
+}
+
+// Start receives messages in the background until Stop. Messages sent
+// while the relay was stopped are received too, as long as Telegram keeps
+// them (24 hours).
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		var offset int64
+		for {
+			updates, err := r.client.GetUpdates(ctx, offset, r.timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				r.logger.Warn("Failed to get Telegram updates: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryDelay):
+				}
+				continue
+			}
+			for _, update := range updates {
+				offset = update.UpdateID + 1
+				if update.Message == nil {
+					continue
+				}
+				if update.Message.Chat.ID != r.chatID {
+					r.logger.Debug("Ignoring Telegram message from chat %d", update.Message.Chat.ID)
+					continue
+				}
+				r.handle(update.Message)
+			}
+		}
+	}()
+}
+
+// Stop stops receiving messages, abandoning a request in progress
+func (r *Relay) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Send sends a message to the chat, see Client.SendMessage
+func (r *Relay) Send(ctx context.Context, text string, replyTo int) (int, error) {
+	return r.client.SendMessage(ctx, r.chatID, text, replyTo)
+}
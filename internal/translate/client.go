@@ -0,0 +1,85 @@
+// Package translate translates texts with DeepL or LibreTranslate
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Translation is a translated text
+type Translation struct {
+	Text string
+	// Language detected in the original text, e.g. en; empty if the service
+	// did not tell
+	SourceLang string
+}
+
+// provider calls the API of a translation service
+type provider interface {
+	translate(ctx context.Context, client *http.Client, text, targetLang string) (*Translation, error)
+}
+
+// Client translates texts with the service of the config
+type Client struct {
+	provider provider
+	http     *http.Client
+}
+
+// NewClient creates a client of the service of cfg, which must be valid
+func NewClient(cfg *config.TranslateConfig) *Client {
+	var p provider
+	if strings.EqualFold(cfg.Provider, "libretranslate") {
+		p = newLibreTranslate(cfg)
+	} else {
+		p = newDeepL(cfg)
+	}
+	return &Client{provider: p, http: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}}
+}
+
+// Translate translates text to a language, e.g. de or pt-BR, detecting the
+// language of the text
+func (c *Client) Translate(ctx context.Context, text, targetLang string) (*Translation, error) {
+	return c.provider.translate(ctx, c.http, text, targetLang)
+}
+
+// SameLanguage reports whether two language codes name the same language,
+// ignoring case and regional variants, e.g. en and EN-GB
+func SameLanguage(a, b string) bool {
+	a, _, _ = strings.Cut(a, "-")
+	b, _, _ = strings.Cut(b, "-")
+	return a != "" && strings.EqualFold(a, b)
+}
+
+// post sends a request and decodes the JSON response into result, failing
+// with the message of errorField of an error response
+func post(client *http.Client, req *http.Request, errorField string, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("translation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("translation request failed: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var problem map[string]interface{}
+		if json.Unmarshal(data, &problem) == nil {
+			if message, ok := problem[errorField].(string); ok && message != "" {
+				return fmt.Errorf("translation request failed with status %d: %s", resp.StatusCode, message)
+			}
+		}
+		return fmt.Errorf("translation request failed with status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("invalid translation response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestDeepL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/translate" || r.Header.Get("Authorization") != "DeepL-Auth-Key key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body struct {
+			Text       []string `json:"text"`
+			TargetLang string   `json:"target_lang"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.TargetLang != "DE" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Value for 'target_lang' not supported."}`))
+			return
+		}
+		w.Write([]byte(`{"translations": [{"detected_source_language": "EN", "text": "Guten Morgen"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.TranslateConfig{Provider: "deepl", URL: server.URL, APIKey: "key", Timeout: 5})
+	translation, err := client.Translate(context.Background(), "Good morning", "de")
+	if err != nil {
+		t.Fatalf("Translate() error = %v", err)
+	}
+	if translation.Text != "Guten Morgen" || translation.SourceLang != "en" {
+		t.Errorf("Translate() = %+v", translation)
+	}
+	if _, err := client.Translate(context.Background(), "Good morning", "xx"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Translate() error = %v, want the DeepL message", err)
+	}
+}
+
+func TestDeepLURL(t *testing.T) {
+	tests := map[string]string{
+		"key":    "https://api.deepl.com/v2/translate",
+		"key:fx": "https://api-free.deepl.com/v2/translate",
+	}
+	for key, expected := range tests {
+		if got := newDeepL(&config.TranslateConfig{APIKey: key}).url; got != expected {
+			t.Errorf("newDeepL(%q).url = %q, want %q", key, got, expected)
+		}
+	}
+}
+
+func TestLibreTranslate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["api_key"] != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "Invalid API key"}`))
+			return
+		}
+		if r.URL.Path != "/translate" || body["source"] != "auto" || body["target"] != "es" || body["q"] != "Good morning" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"translatedText": "Buenos días", "detectedLanguage": {"confidence": 90, "language": "en"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.TranslateConfig{Provider: "libretranslate", URL: server.URL + "/", APIKey: "key", Timeout: 5})
+	translation, err := client.Translate(context.Background(), "Good morning", "es")
+	if err != nil {
+		t.Fatalf("Translate() error = %v", err)
+	}
+	if translation.Text != "Buenos días" || translation.SourceLang != "en" {
+		t.Errorf("Translate() = %+v", translation)
+	}
+
+	client = NewClient(&config.TranslateConfig{Provider: "libretranslate", URL: server.URL, Timeout: 5})
+	if _, err := client.Translate(context.Background(), "Good morning", "es"); err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("Translate() error = %v, want the LibreTranslate message", err)
+	}
+}
+
+func TestSameLanguage(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"en", "EN-GB", true},
+		{"pt-BR", "pt", true},
+		{"de", "en", false},
+		{"", "en", false},
+	}
+	for _, tt := range tests {
+		if got := SameLanguage(tt.a, tt.b); got != tt.expected {
+			t.Errorf("SameLanguage(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+const (
+	defaultDeepLURL     = "https://api.deepl.com"
+	defaultDeepLFreeURL = "https://api-free.deepl.com"
+)
+
+// deepL calls the DeepL API
+type deepL struct {
+	url string
+	key string
+}
+
+// newDeepL creates a DeepL provider. Keys of DeepL API Free end in :fx and
+// only work with its own host.
+func newDeepL(cfg *config.TranslateConfig) *deepL {
+	server := cfg.URL
+	if server == "" {
+		server = defaultDeepLURL
+		if strings.HasSuffix(cfg.APIKey, ":fx") {
+			server = defaultDeepLFreeURL
+		}
+	}
+	return &deepL{url: strings.TrimSuffix(server, "/") + "/v2/translate", key: cfg.APIKey}
+}
+
+func (p *deepL) translate(ctx context.Context, client *http.Client, text, targetLang string) (*Translation, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(targetLang),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+p.key)
+
+	var result struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := post(client, req, "message", &result); err != nil {
+		return nil, err
+	}
+	if len(result.Translations) == 0 {
+		return nil, fmt.Errorf("no translation in the DeepL response")
+	}
+	return &Translation{
+		Text:       result.Translations[0].Text,
+		SourceLang: strings.ToLower(result.Translations[0].DetectedSourceLanguage),
+	}, nil
+}
+
+// libreTranslate calls the API of a LibreTranslate instance
+type libreTranslate struct {
+	url string
+	key string
+}
+
+func newLibreTranslate(cfg *config.TranslateConfig) *libreTranslate {
+	return &libreTranslate{url: strings.TrimSuffix(cfg.URL, "/") + "/translate", key: cfg.APIKey}
+}
+
+func (p *libreTranslate) translate(ctx context.Context, client *http.Client, text, targetLang string) (*Translation, error) {
+	params := map[string]string{
+		"q":      text,
+		"source": "auto",
+		"target": targetLang,
+		"format": "text",
+	}
+	if p.key != "" {
+		params["api_key"] = p.key
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := post(client, req, "error", &result); err != nil {
+		return nil, err
+	}
+	return &Translation{Text: result.TranslatedText, SourceLang: strings.ToLower(result.DetectedLanguage.Language)}, nil
+}
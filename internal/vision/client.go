@@ -0,0 +1,200 @@
+// Package vision describes and transcribes images with a vision model or an
+// OCR service
+package vision
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Image is an image to describe, with the message it was posted in
+type Image struct {
+	Data     []byte
+	MimeType string
+	FileName string
+	Caption  string
+	Sender   string
+	RoomID   string
+}
+
+// Client sends images to the endpoint of the config
+type Client struct {
+	cfg   *config.VisionConfig
+	query *gojq.Query // nil unless jq_selector is set
+	http  *http.Client
+}
+
+// NewClient creates a client of the endpoint of cfg, which must be valid
+func NewClient(cfg *config.VisionConfig) (*Client, error) {
+	c := &Client{cfg: cfg, http: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}}
+	if cfg.JQSelector != "" {
+		query, err := gojq.Parse(cfg.JQSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vision.jq_selector: %w", err)
+		}
+		c.query = query
+	}
+	return c, nil
+}
+
+// Describe returns the text the endpoint extracts from an image
+func (c *Client) Describe(ctx context.Context, img Image) (string, error) {
+	var req *http.Request
+	var err error
+	if strings.EqualFold(c.cfg.API, "multipart") {
+		req, err = c.multipartRequest(ctx, img)
+	} else {
+		req, err = c.chatRequest(ctx, img)
+	}
+	if err != nil {
+		return "", err
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vision request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("vision request failed: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("vision request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if strings.EqualFold(c.cfg.API, "multipart") {
+		return c.multipartText(body)
+	}
+	return chatText(body)
+}
+
+// chatRequest asks an OpenAI-compatible chat completions API about the
+// image, passed as a data URL
+func (c *Client) chatRequest(ctx context.Context, img Image) (*http.Request, error) {
+	prompt := c.cfg.Prompt
+	if img.Caption != "" {
+		prompt += "\n\nThe image was posted with this caption: " + img.Caption
+	}
+	mimeType := img.MimeType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(img.Data)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"model": c.cfg.Model,
+		"messages": []map[string]interface{}{{
+			"role": "user",
+			"content": []map[string]interface{}{
+				{"type": "text", "text": prompt},
+				{"type": "image_url", "image_url": map[string]string{
+					"url": "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(img.Data),
+				}},
+			},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// chatText returns the answer of a chat completions response
+func chatText(body []byte) (string, error) {
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("invalid vision response: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("no choices in the vision response")
+	}
+	return strings.TrimSpace(result.Choices[0].Message.Content), nil
+}
+
+// multipartRequest posts the image as a form, with the message it came in
+func (c *Client) multipartRequest(ctx context.Context, img Image) (*http.Request, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image"; filename=%q`, img.FileName))
+	if img.MimeType != "" {
+		header.Set("Content-Type", img.MimeType)
+	} else {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(img.Data)
+	for name, value := range map[string]string{"caption": img.Caption, "sender": img.Sender, "room_id": img.RoomID} {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req, nil
+}
+
+// multipartText extracts the text of a response of the multipart API
+func (c *Client) multipartText(body []byte) (string, error) {
+	if c.query == nil {
+		return strings.TrimSpace(string(body)), nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("invalid vision response: %w", err)
+	}
+	var results []string
+	iter := c.query.Run(data)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		switch val := v.(type) {
+		case error:
+			return "", fmt.Errorf("vision.jq_selector failed: %w", val)
+		case string:
+			results = append(results, val)
+		case nil:
+		default:
+			encoded, _ := json.Marshal(val)
+			results = append(results, string(encoded))
+		}
+	}
+	return strings.TrimSpace(strings.Join(results, "\n")), nil
+}
@@ -0,0 +1,82 @@
+package vision
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+func TestDescribeOpenAI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content []struct {
+					Type     string `json:"type"`
+					Text     string `json:"text"`
+					ImageURL struct {
+						URL string `json:"url"`
+					} `json:"image_url"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		content := body.Messages[0].Content
+		if body.Model != "gpt-4o" || !strings.HasSuffix(content[0].Text, "caption: why?") || content[1].ImageURL.URL != "data:image/png;base64,cG5n" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": " Error: connection refused "}}]}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewClient(&config.VisionConfig{API: "openai", URL: server.URL, APIKey: "key", Model: "gpt-4o", Prompt: "Describe", Timeout: 5})
+	text, err := client.Describe(context.Background(), Image{Data: []byte("png"), MimeType: "image/png", Caption: "why?"})
+	if err != nil || text != "Error: connection refused" {
+		t.Errorf("Describe() = %q, %v", text, err)
+	}
+}
+
+func TestDescribeMultipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if string(data) != "png" || header.Filename != "screenshot.png" || r.FormValue("sender") != "@alice:example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"lines": [{"text": "Error:"}, {"text": "disk full"}]}`))
+	}))
+	defer server.Close()
+
+	img := Image{Data: []byte("png"), MimeType: "image/png", FileName: "screenshot.png", Sender: "@alice:example.com"}
+	client, _ := NewClient(&config.VisionConfig{API: "multipart", URL: server.URL, JQSelector: ".lines[].text", Timeout: 5})
+	text, err := client.Describe(context.Background(), img)
+	if err != nil || text != "Error:\ndisk full" {
+		t.Errorf("Describe() = %q, %v", text, err)
+	}
+
+	client, _ = NewClient(&config.VisionConfig{API: "multipart", URL: server.URL, Timeout: 5})
+	text, err = client.Describe(context.Background(), img)
+	if err != nil || !strings.HasPrefix(text, `{"lines"`) {
+		t.Errorf("Describe() = %q, %v, want the whole response", text, err)
+	}
+
+	if _, err := client.Describe(context.Background(), Image{Data: []byte("png")}); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("Describe() error = %v, want the status", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Command transform is the module of the tests, built with
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+type message struct {
+	RoomID  string `json:"room_id"`
+	Command string `json:"command"`
+	Body    string `json:"body"`
+}
+
+type result struct {
+	Drop    bool   `json:"drop,omitempty"`
+	Handled bool   `json:"handled,omitempty"`
+	Reply   string `json:"reply,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// buffers keeps what alloc handed out from the garbage collector
+var buffers = map[uint32][]byte{}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buf))))
+	buffers[ptr] = buf
+	return ptr
+}
+
+func input(ptr, size uint32) message {
+	var msg message
+	json.Unmarshal(buffers[ptr][:size], &msg)
+	return msg
+}
+
+func output(r result) uint64 {
+	data, _ := json.Marshal(r)
+	ptr := alloc(uint32(len(data)))
+	copy(buffers[ptr], data)
+	return uint64(ptr)<<32 | uint64(len(data))
+}
+
+//go:wasmexport inbound
+func inbound(ptr, size uint32) uint64 {
+	msg := input(ptr, size)
+	switch {
+	case msg.Body == "ping":
+		return output(result{Handled: true, Reply: "pong from " + msg.RoomID})
+	case strings.Contains(msg.Body, "spam"):
+		return output(result{Drop: true})
+	case strings.HasPrefix(msg.Body, "!"):
+		return output(result{Body: "/" + msg.Body[1:], Command: "rewritten"})
+	case msg.Body == "loop":
+		for {
+		}
+	case msg.Body == "grow":
+		var hog [][]byte
+		for {
+			hog = append(hog, make([]byte, 1<<20))
+		}
+	case msg.Body == "read":
+		data, err := os.ReadFile("/etc/hostname")
+		if err != nil {
+			return output(result{Handled: true, Reply: "no access"})
+		}
+		return output(result{Handled: true, Reply: string(data)})
+	}
+	return 0
+}
+
+//go:wasmexport outbound
+func outbound(ptr, size uint32) uint64 {
+	msg := input(ptr, size)
+	if strings.Contains(msg.Body, "secret") {
+		return output(result{Drop: true})
+	}
+	return output(result{Body: msg.Body + "\n-- " + msg.Command})
+}
+
+func main() {}
@@ -0,0 +1,273 @@
+// Package wasm runs WebAssembly modules that transform messages and replies,
+// sandboxed with wazero. A module sees nothing of the host but its input: it
+// gets no files, environment, network or host functions besides the clock
+// and random source of WASI, and each call runs in a fresh instance with
+// bounded memory and time.
+//
+// A module exports its memory, alloc and at least one of inbound and
+// outbound:
+//
+//	alloc(size u32) u32           // returns size bytes the host may write to
+//	inbound(ptr u32, len u32) u64 // sees a message before it is routed
+//	outbound(ptr u32, len u32) u64 // sees a reply before it is sent
+//
+// inbound and outbound get a Message as JSON and return the address of a
+// Result as JSON, packed as ptr<<32 | len, or 0 to leave the message as it
+// is. Reactor modules (WASI's _initialize, e.g. Go's -buildmode=c-shared)
+// are initialized before every call.
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Names of the functions a module exports
+const (
+	allocFunction    = "alloc"
+	inboundFunction  = "inbound"
+	outboundFunction = "outbound"
+)
+
+// Message is a message or reply as a module sees it
+type Message struct {
+	RoomID     string `json:"room_id"`
+	Sender     string `json:"sender"`
+	EventID    string `json:"event_id,omitempty"`
+	ThreadRoot string `json:"thread_root,omitempty"`
+	// Command the message is for, or the reply answers ("" for none)
+	Command string `json:"command,omitempty"`
+	Body    string `json:"body"`
+}
+
+// Result is what a module decided about a message or reply
+type Result struct {
+	// Drops the message or reply without an answer
+	Drop bool `json:"drop,omitempty"`
+	// The message was handled: Reply (if any) is sent and the message is not
+	// routed further. Inbound only.
+	Handled bool   `json:"handled,omitempty"`
+	Reply   string `json:"reply,omitempty"`
+	// Replaces the body (empty = unchanged)
+	Body string `json:"body,omitempty"`
+	// Dispatches the message to the webhook of this command instead of the
+	// one named in the message (empty = unchanged). Inbound only.
+	Command string `json:"command,omitempty"`
+}
+
+// Host runs the modules of wasm.modules
+type Host struct {
+	runtime wazero.Runtime
+	modules []*module
+	timeout time.Duration // Per call
+	logger  *logger.Logger
+}
+
+type module struct {
+	config   config.WASMModuleConfig
+	compiled wazero.CompiledModule
+	inbound  bool // Exports inbound
+	outbound bool // Exports outbound
+}
+
+// Load compiles the modules of cfg. A module that cannot be read, compiled or
+// started, or lacks the exports it needs, fails the load.
+func Load(ctx context.Context, cfg *config.WASMConfig, log *logger.Logger) (*Host, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(cfg.MaxMemoryMB)*16). // 64 KiB pages
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to set up WASI: %w", err)
+	}
+
+	h := &Host{runtime: runtime, timeout: time.Duration(cfg.Timeout) * time.Millisecond, logger: log}
+	for _, moduleCfg := range cfg.Modules {
+		m, err := h.compile(ctx, moduleCfg)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("wasm module %s: %w", moduleCfg.Name, err)
+		}
+		h.modules = append(h.modules, m)
+		log.Info("Loaded WASM module %s from %s", moduleCfg.Name, moduleCfg.Path)
+	}
+	return h, nil
+}
+
+// compile reads and compiles a module and starts it once to check it
+func (h *Host) compile(ctx context.Context, cfg config.WASMModuleConfig) (*module, error) {
+	code, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := h.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	exports := compiled.ExportedFunctions()
+	m := &module{config: cfg, compiled: compiled, inbound: exports[inboundFunction] != nil, outbound: exports[outboundFunction] != nil}
+	switch {
+	case exports[allocFunction] == nil:
+		err = fmt.Errorf("does not export %s", allocFunction)
+	case !m.inbound && !m.outbound:
+		err = fmt.Errorf("exports neither %s nor %s", inboundFunction, outboundFunction)
+	case compiled.ExportedMemories()["memory"] == nil:
+		err = errors.New("does not export its memory")
+	}
+	if err == nil {
+		var instance api.Module
+		if instance, err = h.instantiate(ctx, m, &bytes.Buffer{}); err == nil {
+			instance.Close(ctx)
+		}
+	}
+	if err != nil {
+		compiled.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+// instantiate starts a fresh instance of a module, writing its stdout and
+// stderr to output
+func (h *Host) instantiate(ctx context.Context, m *module, output *bytes.Buffer) (api.Module, error) {
+	return h.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().
+		WithName(""). // Instances of a module run side by side
+		WithStartFunctions("_initialize").
+		WithStdout(output).
+		WithStderr(output).
+		WithRandSource(rand.Reader).
+		WithSysWalltime().
+		WithSysNanotime())
+}
+
+// HandleMessage passes a message through the inbound modules that apply to
+// it until one drops or handles it. Each module sees the body and command as
+// rewritten by the modules before it. A module that fails is skipped.
+func (h *Host) HandleMessage(ctx context.Context, msg Message) Result {
+	var merged Result
+	for _, m := range h.modules {
+		if !m.inbound || !m.config.AppliesTo(msg.RoomID, msg.Command) {
+			continue
+		}
+		result, err := h.call(ctx, m, inboundFunction, msg)
+		if err != nil {
+			h.logger.Ctx(ctx).Error("WASM module %s failed to handle message: %v", m.config.Name, err)
+			continue
+		}
+		if result.Body != "" {
+			msg.Body = result.Body
+			merged.Body = result.Body
+		}
+		if result.Command != "" {
+			msg.Command = result.Command
+			merged.Command = result.Command
+		}
+		if result.Drop {
+			h.logger.Ctx(ctx).Info("Message dropped by WASM module %s", m.config.Name)
+			return Result{Drop: true}
+		}
+		if result.Handled {
+			h.logger.Ctx(ctx).Info("Message handled by WASM module %s", m.config.Name)
+			merged.Handled = true
+			merged.Reply = result.Reply
+			return merged
+		}
+	}
+	return merged
+}
+
+// Transform passes a reply through the outbound modules that apply to it.
+// The reply is dropped ("" is returned) as soon as a module drops it. A
+// module that fails is skipped.
+func (h *Host) Transform(ctx context.Context, msg Message) string {
+	for _, m := range h.modules {
+		if !m.outbound || !m.config.AppliesTo(msg.RoomID, msg.Command) {
+			continue
+		}
+		result, err := h.call(ctx, m, outboundFunction, msg)
+		if err != nil {
+			h.logger.Ctx(ctx).Error("WASM module %s failed to transform reply: %v", m.config.Name, err)
+			continue
+		}
+		if result.Drop {
+			h.logger.Ctx(ctx).Info("Reply dropped by WASM module %s", m.config.Name)
+			return ""
+		}
+		if result.Body != "" {
+			msg.Body = result.Body
+		}
+	}
+	return msg.Body
+}
+
+// call runs function of a fresh instance of a module on msg
+func (h *Host) call(ctx context.Context, m *module, function string, msg Message) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	result, err := h.run(ctx, m, function, msg, &output)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("took longer than %s", h.timeout)
+	}
+	if err != nil && output.Len() > 0 {
+		err = fmt.Errorf("%w, output: %s", err, strings.TrimSpace(output.String()))
+	}
+	return result, err
+}
+
+func (h *Host) run(ctx context.Context, m *module, function string, msg Message, output *bytes.Buffer) (Result, error) {
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return Result{}, err
+	}
+	instance, err := h.instantiate(ctx, m, output)
+	if err != nil {
+		return Result{}, err
+	}
+	defer instance.Close(ctx)
+
+	allocated, err := instance.ExportedFunction(allocFunction).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", allocFunction, err)
+	}
+	ptr := uint32(allocated[0])
+	if !instance.Memory().Write(ptr, input) {
+		return Result{}, fmt.Errorf("%s returned %d, outside of the memory of the module", allocFunction, ptr)
+	}
+	returned, err := instance.ExportedFunction(function).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", function, err)
+	}
+	if returned[0] == 0 {
+		return Result{}, nil
+	}
+	resultPtr, resultLen := uint32(returned[0]>>32), uint32(returned[0])
+	data, ok := instance.Memory().Read(resultPtr, resultLen)
+	if !ok {
+		return Result{}, fmt.Errorf("%s returned a result outside of the memory of the module", function)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return Result{}, fmt.Errorf("%s returned an invalid result: %w", function, err)
+	}
+	return result, nil
+}
+
+// Close releases the compiled modules
+func (h *Host) Close() {
+	h.runtime.Close(context.Background())
+}
@@ -0,0 +1,160 @@
+package wasm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+var (
+	buildOnce   sync.Once
+	buildPath   string
+	buildOutput []byte
+)
+
+// testModule builds testdata/transform, once for all tests. It skips the
+// test if the toolchain cannot build for wasip1.
+func testModule(t *testing.T) string {
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wasm-test")
+		if err != nil {
+			buildOutput = []byte(err.Error())
+			return
+		}
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", filepath.Join(dir, "transform.wasm"), "./testdata/transform")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if buildOutput, err = cmd.CombinedOutput(); err == nil {
+			buildPath = filepath.Join(dir, "transform.wasm")
+		}
+	})
+	if buildPath == "" {
+		t.Skipf("cannot build the test module: %s", buildOutput)
+	}
+	return buildPath
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if buildPath != "" {
+		os.RemoveAll(filepath.Dir(buildPath))
+	}
+	os.Exit(code)
+}
+
+func testHost(t *testing.T, modules ...config.WASMModuleConfig) *Host {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	h, err := Load(context.Background(), &config.WASMConfig{Modules: modules, Timeout: 2000, MaxMemoryMB: 32}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestHandleMessage(t *testing.T) {
+	h := testHost(t, config.WASMModuleConfig{Name: "transform", Path: testModule(t), Rooms: []string{"!room:example.com"}})
+	ctx := context.Background()
+
+	tests := []struct {
+		room string
+		body string
+		want Result
+	}{
+		{"!room:example.com", "ping", Result{Handled: true, Reply: "pong from !room:example.com"}},
+		{"!room:example.com", "buy spam", Result{Drop: true}},
+		{"!room:example.com", "!deploy prod", Result{Body: "/deploy prod", Command: "rewritten"}},
+		{"!room:example.com", "Hello", Result{}},
+		// The module gets no files of the host
+		{"!room:example.com", "read", Result{Handled: true, Reply: "no access"}},
+		// Failures are logged and the message is routed as usual
+		{"!room:example.com", "loop", Result{}},
+		{"!room:example.com", "grow", Result{}},
+		// The module does not run in other rooms
+		{"!other:example.com", "ping", Result{}},
+	}
+	for _, tt := range tests {
+		got := h.HandleMessage(ctx, Message{RoomID: tt.room, Body: tt.body})
+		if got != tt.want {
+			t.Errorf("HandleMessage(%q in %s) = %+v, want %+v", tt.body, tt.room, got, tt.want)
+		}
+	}
+}
+
+func TestCallLimits(t *testing.T) {
+	h := testHost(t, config.WASMModuleConfig{Name: "transform", Path: testModule(t)})
+	ctx := context.Background()
+
+	tests := []struct {
+		body    string
+		wantErr string
+	}{
+		{"loop", "took longer than 2s"},
+		{"grow", "out of memory"},
+	}
+	for _, tt := range tests {
+		_, err := h.call(ctx, h.modules[0], inboundFunction, Message{Body: tt.body})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("call(%q) error = %v, want it to contain %q", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	h := testHost(t,
+		config.WASMModuleConfig{Name: "deploy", Path: testModule(t), Commands: []string{"deploy*"}},
+		config.WASMModuleConfig{Name: "all", Path: testModule(t)},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		command string
+		body    string
+		want    string
+	}{
+		{"deploy", "done", "done\n-- deploy\n-- deploy"},
+		{"status", "up", "up\n-- status"},
+		{"", "up", "up\n-- "},
+		{"deploy", "the secret is 42", ""},
+	}
+	for _, tt := range tests {
+		if got := h.Transform(ctx, Message{RoomID: "!room:example.com", Command: tt.command, Body: tt.body}); got != tt.want {
+			t.Errorf("Transform(%q for %q) = %q, want %q", tt.body, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	dir := t.TempDir()
+	notWASM := filepath.Join(dir, "plain.wasm")
+	if err := os.WriteFile(notWASM, []byte("not a module"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// An empty module exports neither alloc nor a transform
+	empty := filepath.Join(dir, "empty.wasm")
+	if err := os.WriteFile(empty, []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{filepath.Join(dir, "missing.wasm"), "no such file"},
+		{notWASM, "wasm module bad"},
+		{empty, "does not export alloc"},
+	}
+	for _, tt := range tests {
+		_, err := Load(context.Background(), &config.WASMConfig{Modules: []config.WASMModuleConfig{{Name: "bad", Path: tt.path}}, Timeout: 1000, MaxMemoryMB: 16}, log)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Load(%s) error = %v, want it to contain %q", filepath.Base(tt.path), err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+// Recording is a dispatch written to webhook.dev.record_dir: its message,
+// the requests it sent with their responses, and its reply
+type Recording struct {
+	RecordedAt time.Time  `json:"recorded_at"`
+	RequestID  string     `json:"request_id,omitempty"`
+	RoomID     string     `json:"room_id,omitempty"`
+	Sender     string     `json:"sender,omitempty"`
+	Command    string     `json:"command"`
+	Message    string     `json:"message"`
+	Exchanges  []Exchange `json:"exchanges"`
+	Reply      string     `json:"reply"`
+	Error      string     `json:"error,omitempty"`
+
+	File string `json:"-"` // Set by LoadRecordings
+}
+
+// Exchange is a request of a dispatch, such as one for a further page, and
+// its response
+type Exchange struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers,omitempty"` // Authorization is redacted
+	Body     string            `json:"body"`
+	Status   int               `json:"status,omitempty"`
+	Response string            `json:"response,omitempty"`
+	Mocked   bool              `json:"mocked,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// redacted replaces the Authorization header of recorded requests
+const redacted = "[redacted]"
+
+type (
+	recordingContextKey struct{}
+	replayContextKey    struct{}
+	originContextKey    struct{}
+)
+
+type origin struct {
+	roomID, sender string
+}
+
+// NewOriginContext returns a copy of ctx whose dispatches are recorded as
+// sent for a message of sender in the room
+func NewOriginContext(ctx context.Context, roomID, sender string) context.Context {
+	return context.WithValue(ctx, originContextKey{}, origin{roomID: roomID, sender: sender})
+}
+
+// newRecording starts the recording of a dispatch
+func newRecording(ctx context.Context, message, command string) *Recording {
+	from, _ := ctx.Value(originContextKey{}).(origin)
+	return &Recording{
+		RecordedAt: time.Now().UTC(),
+		RequestID:  requestid.FromContext(ctx),
+		RoomID:     from.roomID,
+		Sender:     from.sender,
+		Command:    command,
+		Message:    message,
+	}
+}
+
+func recordingFromContext(ctx context.Context) *Recording {
+	rec, _ := ctx.Value(recordingContextKey{}).(*Recording)
+	return rec
+}
+
+// finish records the result of the dispatch
+func (r *Recording) finish(reply string, err error) {
+	r.Reply = reply
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		// Without the duration, which differs in every replay
+		r.Error = fmt.Sprintf("webhook returned status code: %d (URL: %s)", statusErr.StatusCode, statusErr.URL)
+	case err != nil:
+		r.Error = err.Error()
+	}
+}
+
+// fileNameRegex matches the characters of a command kept in file names
+var fileNameRegex = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// saveRecording writes the recording to a new file of dir, named after the
+// time and the command so that files sort in the order of the dispatches
+func saveRecording(dir string, rec *Recording) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	command := fileNameRegex.ReplaceAllString(rec.Command, "_")
+	if command == "" {
+		command = "default"
+	}
+	file, err := os.CreateTemp(dir, rec.RecordedAt.Format("20060102T150405.000000000Z")+"-"+command+"-*.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(rec); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadRecordings reads the recordings of dir in the order of their
+// dispatches
+func LoadRecordings(dir string) ([]*Recording, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	recordings := make([]*Recording, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		rec.File = file
+		recordings = append(recordings, &rec)
+	}
+	return recordings, nil
+}
+
+// devTransport adds the requests of dispatches to their recording and
+// answers them with the mock responses of webhook.dev or, when replaying,
+// with the recorded responses. Other requests are sent by next.
+type devTransport struct {
+	dev  config.WebhookDevConfig
+	next http.RoundTripper // nil when replaying
+}
+
+func (t *devTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	rec := recordingFromContext(req.Context())
+	exchange := Exchange{Method: req.Method, URL: req.URL.String(), Headers: recordedHeaders(req.Header), Body: string(body)}
+
+	var resp *http.Response
+	var err error
+	if replayed, replaying := req.Context().Value(replayContextKey{}).([]Exchange); replaying {
+		resp, err = replayResponse(req, replayed, rec)
+	} else if t.dev.Mock {
+		resp, err = t.mock(req, body, rec)
+		exchange.Mocked = true
+	} else if t.next != nil {
+		resp, err = t.next.RoundTrip(req)
+	} else {
+		err = errors.New("requests are not sent while replaying")
+	}
+	if rec == nil {
+		return resp, err
+	}
+
+	if err != nil {
+		exchange.Error = err.Error()
+	} else {
+		data, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			exchange.Error = readErr.Error()
+			rec.Exchanges = append(rec.Exchanges, exchange)
+			return nil, readErr
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		exchange.Status, exchange.Response = resp.StatusCode, string(data)
+	}
+	rec.Exchanges = append(rec.Exchanges, exchange)
+	return resp, err
+}
+
+// recordedHeaders returns the headers of a request with the Authorization
+// header redacted
+func recordedHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name := range header {
+		headers[name] = header.Get(name)
+	}
+	if _, exists := headers["Authorization"]; exists {
+		headers["Authorization"] = redacted
+	}
+	return headers
+}
+
+// mock answers a request with the mock response of its command
+func (t *devTransport) mock(req *http.Request, body []byte, rec *Recording) (*http.Response, error) {
+	var command, message string
+	page := 1
+	if rec != nil {
+		command, message, page = rec.Command, rec.Message, len(rec.Exchanges)+1
+	}
+	mock, exists := t.dev.MockResponses[command]
+	if !exists || command == "" {
+		mock = t.dev.DefaultMockResponse
+	}
+
+	var payload interface{}
+	json.Unmarshal(body, &payload)
+	tmpl, err := template.New("webhook.dev.mock_responses").Funcs(payloadTemplateFuncs()).Parse(mock.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mock response: %w", err)
+	}
+	var buf bytes.Buffer
+	data := map[string]interface{}{"COMMAND": command, "MESSAGE": message, "URL": req.URL.String(), "METHOD": req.Method, "BODY": payload, "PAGE": page}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render mock response: %w", err)
+	}
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return newResponse(req, status, buf.Bytes()), nil
+}
+
+// replayResponse answers the request with the response recorded for the
+// request of the same number
+func replayResponse(req *http.Request, replayed []Exchange, rec *Recording) (*http.Response, error) {
+	i := 0
+	if rec != nil {
+		i = len(rec.Exchanges)
+	}
+	if i >= len(replayed) {
+		return nil, fmt.Errorf("no response was recorded for request %d", i+1)
+	}
+	if replayed[i].Error != "" {
+		return nil, errors.New(replayed[i].Error)
+	}
+	return newResponse(req, replayed[i].Status, []byte(replayed[i].Response)), nil
+}
+
+func newResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// NewReplayer returns a dispatcher that replays recordings with cfg and
+// sends no requests
+func NewReplayer(cfg *config.WebhookConfig, logger *logger.Logger) *Dispatcher {
+	return &Dispatcher{
+		config:    cfg,
+		client:    &http.Client{Transport: &devTransport{dev: cfg.Dev}},
+		logger:    logger,
+		delivered: deliveredScope(nil, cfg, logger),
+	}
+}
+
+// Replay dispatches the message of a recording again, answering its
+// requests with the recorded responses in order, and returns the recording
+// of the replay
+func (d *Dispatcher) Replay(ctx context.Context, recorded *Recording, opts ...DispatchOption) *Recording {
+	current := *d.currentConfig()
+	cfg := &current
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	ctx = NewOriginContext(ctx, recorded.RoomID, recorded.Sender)
+	rec := newRecording(ctx, recorded.Message, recorded.Command)
+	ctx = context.WithValue(ctx, recordingContextKey{}, rec)
+	ctx = context.WithValue(ctx, replayContextKey{}, recorded.Exchanges)
+	reply, err := d.dispatch(ctx, cfg, recorded.Message, recorded.Command)
+	rec.finish(reply, err)
+	return rec
+}
+
+// Difference is a part of a dispatch that changed in its replay
+type Difference struct {
+	Field    string
+	Recorded string
+	Replayed string
+}
+
+// Compare returns how the replay of the recording differs from it. JSON
+// bodies are compared without regard to whitespace.
+func (r *Recording) Compare(replayed *Recording) []Difference {
+	var diffs []Difference
+	add := func(field, recorded, replayed string) {
+		if recorded != replayed {
+			diffs = append(diffs, Difference{Field: field, Recorded: recorded, Replayed: replayed})
+		}
+	}
+	for i := 0; i < max(len(r.Exchanges), len(replayed.Exchanges)); i++ {
+		var before, after Exchange
+		if i < len(r.Exchanges) {
+			before = r.Exchanges[i]
+		}
+		if i < len(replayed.Exchanges) {
+			after = replayed.Exchanges[i]
+		}
+		prefix := fmt.Sprintf("request %d ", i+1)
+		add(prefix+"method", before.Method, after.Method)
+		add(prefix+"url", before.URL, after.URL)
+		add(prefix+"body", compactJSON(before.Body), compactJSON(after.Body))
+	}
+	add("reply", r.Reply, replayed.Reply)
+	add("error", r.Error, replayed.Error)
+	return diffs
+}
+
+// compactJSON removes the insignificant whitespace of a JSON body, other
+// bodies are returned as they are
+func compactJSON(body string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(body)); err != nil {
+		return strings.TrimSpace(body)
+	}
+	return buf.String()
+}
@@ -2,158 +2,348 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 
 	"github.com/itchyny/gojq"
 	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
 	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+	"github.com/mule-ai/mule/matrix-microservice/internal/script"
 )
 
 type Dispatcher struct {
-	config *config.WebhookConfig
-	client *http.Client
-	logger *logger.Logger
+	config   *config.WebhookConfig
+	client   *http.Client
+	mutex    sync.RWMutex // Guards config and client, which SetConfig replaces
+	logger   *logger.Logger
+	inFlight sync.WaitGroup // Dispatches currently in progress
+
+	// Delivered idempotency keys
+	delivered *dedup.Scope
 }
 
-func New(cfg *config.WebhookConfig, logger *logger.Logger) *Dispatcher {
+// New returns a dispatcher recording delivered idempotency keys with dedup,
+// which may be nil to remember them in memory only
+func New(cfg *config.WebhookConfig, dedup *dedup.Deduplicator, logger *logger.Logger) *Dispatcher {
 	logger.Info("Initializing webhook dispatcher")
 	logger.Debug("Default webhook: %s", cfg.Default)
 	logger.Debug("Number of command webhooks: %d", len(cfg.Commands))
 
 	return &Dispatcher{
-		config: cfg,
-		client: &http.Client{
-			Timeout: time.Duration(cfg.Timeout) * time.Second,
-		},
-		logger: logger,
+		config:    cfg,
+		client:    newHTTPClient(cfg),
+		logger:    logger,
+		delivered: deliveredScope(dedup, cfg, logger),
 	}
 }
 
-func (d *Dispatcher) Dispatch(message string, command string) (string, error) {
-	d.logger.Info("Dispatching webhook for message: %s", message)
-	d.logger.Debug("Command extracted: %s", command)
+// SetConfig replaces the webhook configuration, e.g. after a config reload.
+// Dispatches already in progress finish with the previous settings.
+func (d *Dispatcher) SetConfig(cfg *config.WebhookConfig) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config = cfg
+	d.delivered.SetTTL(time.Duration(cfg.Idempotency.TTL) * time.Second)
+	d.client.CloseIdleConnections()
+	d.client = newHTTPClient(cfg)
+	d.logger.Info("Webhook dispatcher configuration updated")
+}
+
+// newHTTPClient returns the client of dispatches, which connects only to the
+// destinations of webhook.egress
+func newHTTPClient(cfg *config.WebhookConfig) *http.Client {
+	var transport http.RoundTripper = newEgressPolicy(&cfg.Egress).transport()
+	if cfg.Dev.Enabled() {
+		transport = &devTransport{dev: cfg.Dev, next: transport}
+	}
+	return &http.Client{
+		Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		Transport: transport,
+	}
+}
+
+func (d *Dispatcher) currentConfig() *config.WebhookConfig {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.config
+}
+
+func (d *Dispatcher) httpClient() *http.Client {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.client
+}
+
+// Wait blocks until all in-flight dispatches have finished or ctx expires
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DispatchOption customizes a single dispatch
+type DispatchOption func(cfg *config.WebhookConfig)
+
+// WithRoomDefaults applies the webhook defaults of a room, which replace the
+// global default webhook, template, jq selector and default auth
+func WithRoomDefaults(room *config.RoomWebhookConfig) DispatchOption {
+	return func(cfg *config.WebhookConfig) {
+		if room.Default != "" {
+			cfg.Default = room.Default
+		}
+		if room.Template != "" {
+			cfg.Template = room.Template
+		}
+		if room.JQSelector != "" {
+			cfg.JQSelector = room.JQSelector
+		}
+		if room.DefaultAuth != "" {
+			cfg.DefaultAuth = room.DefaultAuth
+		}
+	}
+}
+
+// WithTenant dispatches with the webhooks, templates, auth tokens and
+// selectors of a tenant instead of the global ones. Room defaults go after
+// it.
+func WithTenant(tenant *config.TenantConfig) DispatchOption {
+	return tenant.Webhook.Apply
+}
+
+// WebhookURL returns the webhook Dispatch posts a message for the command to
+func (d *Dispatcher) WebhookURL(command string, opts ...DispatchOption) string {
+	current := *d.currentConfig()
+	cfg := &current
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if url, exists := cfg.Commands[command]; exists && command != "" {
+		return url
+	}
+	return cfg.Default
+}
+
+// Dispatch posts the message to the webhook for the command and returns the
+// reply selected from the response. The request ID carried by ctx is sent as
+// the X-Request-ID header, the idempotency key as the Idempotency-Key
+// header; ErrAlreadyDelivered is returned if that key was delivered before.
+// Unless trace propagation is off, the trace context carried by ctx, or one
+// derived from the request ID, is sent as the traceparent and baggage
+// headers. The values of NewMemoryContext are the template's .MEMORY. With
+// webhook.dev, the dispatch is recorded or mocked.
+func (d *Dispatcher) Dispatch(ctx context.Context, message string, command string, opts ...DispatchOption) (string, error) {
+	d.inFlight.Add(1)
+	defer d.inFlight.Done()
+
+	// Options modify a copy, the shared config is never changed
+	current := *d.currentConfig()
+	cfg := &current
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if !cfg.Dev.Enabled() {
+		return d.dispatch(ctx, cfg, message, command)
+	}
+
+	rec := newRecording(ctx, message, command)
+	reply, err := d.dispatch(context.WithValue(ctx, recordingContextKey{}, rec), cfg, message, command)
+	rec.finish(reply, err)
+	if cfg.Dev.RecordDir != "" {
+		if err := saveRecording(cfg.Dev.RecordDir, rec); err != nil {
+			d.logger.Ctx(ctx).Error("Failed to record the dispatch: %v", err)
+		}
+	}
+	return reply, err
+}
+
+// dispatch posts the message with cfg, see Dispatch
+func (d *Dispatcher) dispatch(ctx context.Context, cfg *config.WebhookConfig, message string, command string) (string, error) {
+	requestID := requestid.FromContext(ctx)
+	log := d.logger.Ctx(ctx)
+
+	log.Info("Dispatching webhook for message: %s", log.Message(message))
+	log.Debug("Command extracted: %s", command)
 
 	var webhookURL string
 	var tpl string
 	var authToken string
 	var jqSelector string
+	var templateCommand string // The command whose escaping applies
 
 	// Determine which webhook to use
 	if command != "" {
-		if url, exists := d.config.Commands[command]; exists {
+		if url, exists := cfg.Commands[command]; exists {
 			webhookURL = url
+			templateCommand = command
 
 			// Use command-specific template if available, otherwise use default
-			if cmdTpl, exists := d.config.CommandTemplates[command]; exists {
+			if cmdTpl, exists := cfg.CommandTemplates[command]; exists {
 				tpl = cmdTpl
-				d.logger.Debug("Using command-specific template for: %s", command)
+				log.Debug("Using command-specific template for: %s", command)
 			} else {
-				tpl = d.config.Template
+				tpl = cfg.Template
 			}
 
-			d.logger.Info("Using command webhook: %s for command: %s", url, command)
+			log.Info("Using command webhook: %s for command: %s", url, command)
 
 			// Get auth token for this command
-			if token, exists := d.config.AuthTokens[command]; exists {
+			if token, exists := cfg.AuthTokens[command]; exists {
 				authToken = token
-				d.logger.Debug("Using auth token for command: %s", command)
-			} else if d.config.DefaultAuth != "" {
-				if token, exists := d.config.AuthTokens[d.config.DefaultAuth]; exists {
+				log.Debug("Using auth token for command: %s", command)
+			} else if cfg.DefaultAuth != "" {
+				if token, exists := cfg.AuthTokens[cfg.DefaultAuth]; exists {
 					authToken = token
-					d.logger.Debug("Using default auth token for command: %s", command)
+					log.Debug("Using default auth token for command: %s", command)
 				}
 			}
 
 			// Get JQ selector for this command
-			if selector, exists := d.config.CommandSelectors[command]; exists {
+			if selector, exists := cfg.CommandSelectors[command]; exists {
 				jqSelector = selector
-				d.logger.Debug("Using JQ selector for command: %s", command)
+				log.Debug("Using JQ selector for command: %s", command)
 			}
 		} else {
 			// Command not found, use default
-			webhookURL = d.config.Default
-			tpl = d.config.Template
-			d.logger.Warn("Command %s not found, using default webhook: %s", command, webhookURL)
+			webhookURL = cfg.Default
+			tpl = cfg.Template
+			log.Warn("Command %s not found, using default webhook: %s", command, webhookURL)
 
 			// Get default auth token
-			if d.config.DefaultAuth != "" {
-				if token, exists := d.config.AuthTokens[d.config.DefaultAuth]; exists {
+			if cfg.DefaultAuth != "" {
+				if token, exists := cfg.AuthTokens[cfg.DefaultAuth]; exists {
 					authToken = token
-					d.logger.Debug("Using default auth token")
+					log.Debug("Using default auth token")
 				}
 			}
 		}
 	} else {
 		// No command, use default webhook
-		webhookURL = d.config.Default
-		tpl = d.config.Template
-		d.logger.Info("Using default webhook: %s", webhookURL)
+		webhookURL = cfg.Default
+		tpl = cfg.Template
+		log.Info("Using default webhook: %s", webhookURL)
 
 		// Get default auth token
-		if d.config.DefaultAuth != "" {
-			if token, exists := d.config.AuthTokens[d.config.DefaultAuth]; exists {
+		if cfg.DefaultAuth != "" {
+			if token, exists := cfg.AuthTokens[cfg.DefaultAuth]; exists {
 				authToken = token
-				d.logger.Debug("Using default auth token")
+				log.Debug("Using default auth token")
 			}
 		}
 	}
 
 	// Use default JQ selector if not set for command
 	if jqSelector == "" {
-		jqSelector = d.config.JQSelector
-		d.logger.Debug("Using default JQ selector: %s", jqSelector)
+		jqSelector = cfg.JQSelector
+		log.Debug("Using default JQ selector: %s", jqSelector)
+	}
+
+	idempotencyKey := ""
+	if cfg.Idempotency.Enabled {
+		idempotencyKey = idempotencyKeyFromContext(ctx)
+	}
+	delivered := false
+	if idempotencyKey != "" {
+		claimed, err := d.delivered.Claim(ctx, idempotencyKey)
+		if err != nil {
+			log.Error("Failed to check whether %s was delivered: %v", idempotencyKey, err)
+			return "", fmt.Errorf("failed to check idempotency key: %w", err)
+		}
+		if !claimed {
+			log.Info("Skipping dispatch with idempotency key %s, it was already delivered", idempotencyKey)
+			return "", ErrAlreadyDelivered
+		}
+		defer func() { d.release(idempotencyKey, delivered) }()
 	}
 
 	// Render template with message
-	d.logger.Debug("Rendering template with message")
-	tmpl, err := template.New("webhook").Parse(tpl)
+	log.Debug("Rendering template with message")
+	payload, err := renderPayload("webhook", tpl, cfg, templateCommand, map[string]string{"MESSAGE": message, "IDEMPOTENCY_KEY": idempotencyKey}, memoryFromContext(ctx))
 	if err != nil {
-		d.logger.Error("Failed to parse template: %v", err)
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		log.Error("Failed to render template: %v", err)
+		return "", err
 	}
+	buf := bytes.NewBuffer(payload)
 
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, map[string]string{"MESSAGE": message})
-	if err != nil {
-		d.logger.Error("Failed to execute template: %v", err)
-		return "", fmt.Errorf("failed to execute template: %w", err)
+	// Let the payload script rewrite the rendered payload
+	if cfg.PayloadScript != "" {
+		d.runPayloadScript(ctx, cfg, buf, message, command)
+	}
+	if idempotencyKey != "" {
+		payload := addIdempotencyKey(buf.Bytes(), idempotencyKey)
+		buf.Reset()
+		buf.Write(payload)
+	}
+
+	// Further pages of a paginated response may post the payload again
+	pages := responsePages(cfg, templateCommand)
+	var payloadCopy []byte
+	if pages != nil {
+		payloadCopy = append([]byte(nil), buf.Bytes()...)
 	}
 
 	// Create HTTP request
-	d.logger.Info("Sending HTTP POST request to: %s (Message length: %d bytes, Has auth: %v)",
+	log.Info("Sending HTTP POST request to: %s (Message length: %d bytes, Has auth: %v)",
 		webhookURL, buf.Len(), authToken != "")
-	req, err := http.NewRequest("POST", webhookURL, &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, buf)
 	if err != nil {
-		d.logger.Error("Failed to create request: %v (URL: %s)", err, webhookURL)
+		log.Error("Failed to create request: %v (URL: %s)", err, webhookURL)
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	// The headers of the dispatch, also sent with requests for further pages
+	prepare := func(req *http.Request) {
+		if requestID != "" {
+			req.Header.Set(requestid.Header, requestID)
+		}
+		if idempotencyKey != "" {
+			req.Header.Set(IdempotencyHeader, idempotencyKey)
+		}
+		if cfg.UserAgent != "" {
+			req.Header.Set("User-Agent", cfg.UserAgent)
+		}
+		if cfg.PropagateTrace {
+			setTraceHeaders(ctx, req, requestID)
+		}
 
-	// Add authorization header if token is provided
-	if authToken != "" {
-		req.Header.Set("Authorization", authToken)
-		d.logger.Debug("Added authorization header to request (token prefix: %s...)", authToken[:min(10, len(authToken))])
+		// Add authorization header if token is provided
+		if authToken != "" {
+			req.Header.Set("Authorization", authToken)
+		}
 	}
+	prepare(req)
 
 	// Send HTTP request
 	startTime := time.Now()
-	resp, err := d.client.Do(req)
+	resp, err := d.httpClient().Do(req)
 	duration := time.Since(startTime)
 
 	if err != nil {
-		d.logger.Error("Failed to send webhook: %v (URL: %s, Duration: %v)", err, webhookURL, duration)
+		log.Error("Failed to send webhook: %v (URL: %s, Duration: %v)", err, webhookURL, duration)
 		return "", fmt.Errorf("failed to send webhook: %w", err)
 	}
 	defer resp.Body.Close()
 
-	d.logger.Info("Webhook response status: %d (URL: %s, Duration: %v)", resp.StatusCode, webhookURL, duration)
+	log.Info("Webhook response status: %d (URL: %s, Duration: %v)", resp.StatusCode, webhookURL, duration)
+	// The webhook accepted the dispatch, even if the reply cannot be read
+	delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		// Read response body for error details
@@ -165,38 +355,72 @@ func (d *Dispatcher) Dispatch(message string, command string) (string, error) {
 			bodyStr = bodyStr[:500] + "... (truncated)"
 		}
 
-		d.logger.Error("Webhook returned status code: %d (URL: %s, Response Headers: %v, Response Body: %s)",
-			resp.StatusCode, webhookURL, resp.Header, bodyStr)
-		return "", fmt.Errorf("webhook returned status code: %d (URL: %s, Duration: %v, Response: %s)",
-			resp.StatusCode, webhookURL, duration, bodyStr)
+		log.Error("Webhook returned status code: %d (URL: %s, Response Headers: %v, Response Body: %s)",
+			resp.StatusCode, webhookURL, resp.Header, log.Message(bodyStr))
+		return "", &StatusError{StatusCode: resp.StatusCode, URL: webhookURL, Duration: duration, Response: bodyStr}
 	}
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		d.logger.Error("Failed to read response body: %v", err)
+		log.Error("Failed to read response body: %v", err)
 		return "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	d.logger.Debug("Webhook response body: %s", string(body))
+	log.Debug("Webhook response body: %s", log.Message(string(body)))
+
+	// Merge the items of further pages into the first, so that the selector
+	// sees them all
+	if pages != nil {
+		merged, count, err := d.followPages(ctx, pages, body, webhookURL, payloadCopy, prepare)
+		if err != nil {
+			log.Error("Failed to follow the pages of the response: %v", err)
+			return "", fmt.Errorf("failed to follow response pages: %w", err)
+		}
+		if reported := pageCountFromContext(ctx); reported != nil {
+			*reported = *count
+		}
+		body = merged
+	}
 
 	// If no JQ selector, return empty string (no reply)
 	if jqSelector == "" {
-		d.logger.Info("No JQ selector configured, skipping response parsing")
+		log.Info("No JQ selector configured, skipping response parsing")
 		return "", nil
 	}
 
 	// Parse response using JQ
 	reply, err := d.parseResponseWithJQ(body, jqSelector)
 	if err != nil {
-		d.logger.Error("Failed to parse response with JQ: %v", err)
+		log.Error("Failed to parse response with JQ: %v", err)
 		return "", fmt.Errorf("failed to parse response with JQ: %w", err)
 	}
 
-	d.logger.Info("Webhook dispatched successfully, reply: %s", reply)
+	log.Info("Webhook dispatched successfully, reply: %s", log.Message(reply))
 	return reply, nil
 }
 
+// runPayloadScript replaces the payload in buf with the result of the
+// payload script. The payload is kept if the script returns nil or fails.
+func (d *Dispatcher) runPayloadScript(ctx context.Context, cfg *config.WebhookConfig, buf *bytes.Buffer, message, command string) {
+	log := d.logger.Ctx(ctx)
+	s, err := script.Compile("webhook.payload_script", cfg.PayloadScript, time.Duration(cfg.ScriptTimeout)*time.Millisecond)
+	if err != nil {
+		log.Error("Failed to compile payload script: %v", err)
+		return
+	}
+	payload, ok, err := s.Run(ctx, map[string]string{"payload": buf.String(), "message": message, "command": command})
+	if err != nil {
+		log.Error("Payload script failed, sending the payload unchanged: %v", err)
+		return
+	}
+	if ok {
+		log.Debug("Payload rewritten by payload script")
+		buf.Reset()
+		buf.WriteString(payload)
+	}
+}
+
 func (d *Dispatcher) parseResponseWithJQ(responseBody []byte, selector string) (string, error) {
 	// Parse JSON response
 	var data interface{}
@@ -244,7 +468,7 @@ func (d *Dispatcher) parseResponseWithJQ(responseBody []byte, selector string) (
 	}
 
 	// If no results or empty results and skip_empty is true, return empty string
-	if len(results) == 0 || (d.config.SkipEmpty && d.allEmpty(results)) {
+	if len(results) == 0 || (d.currentConfig().SkipEmpty && d.allEmpty(results)) {
 		return "", nil
 	}
 
@@ -275,29 +499,26 @@ func (d *Dispatcher) ExtractCommand(message string) string {
 }
 
 // HasCommandPrefix checks if the message starts with the configured command prefix
-// If CommandPrefix is empty and EnableCommands is true, it matches all messages
+// If CommandPrefix is empty, it matches all messages. Whether commands are
+// enabled, globally or in a room, is up to the caller.
 func (d *Dispatcher) HasCommandPrefix(message string) bool {
-	if d.config.CommandPrefix == "" {
-		// If prefix is empty but commands are enabled, treat as match all
-		return d.config.EnableCommands
-	}
-	return strings.HasPrefix(message, d.config.CommandPrefix)
+	return strings.HasPrefix(message, d.currentConfig().CommandPrefix)
 }
 
 // GetCommandFromPrefix extracts the command and arguments from a message with command prefix
-// If CommandPrefix is empty and EnableCommands is true, the entire message is treated as arguments
+// If CommandPrefix is empty, the entire message is treated as arguments
 func (d *Dispatcher) GetCommandFromPrefix(message string) (command string, args string) {
 	if !d.HasCommandPrefix(message) {
 		return "", ""
 	}
 
 	// If prefix is empty (match all), treat entire message as args
-	if d.config.CommandPrefix == "" {
+	if d.currentConfig().CommandPrefix == "" {
 		return "", strings.TrimSpace(message)
 	}
 
 	// Remove the prefix
-	rest := strings.TrimPrefix(message, d.config.CommandPrefix)
+	rest := strings.TrimPrefix(message, d.currentConfig().CommandPrefix)
 	rest = strings.TrimSpace(rest)
 
 	// Extract command name (first word after prefix)
@@ -320,3 +541,20 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// setTraceHeaders sets the W3C trace headers of a dispatch, in a new span of
+// the trace carried by ctx. Without one, the trace is derived from the
+// request ID, so that all dispatches of one user action share a trace.
+func setTraceHeaders(ctx context.Context, req *http.Request, requestID string) {
+	trace, exists := requestid.TraceFromContext(ctx)
+	if !exists {
+		if requestID == "" {
+			return
+		}
+		trace = requestid.NewTraceContext(requestID)
+	}
+	req.Header.Set(requestid.TraceparentHeader, trace.Child())
+	if trace.Baggage != "" {
+		req.Header.Set(requestid.BaggageHeader, trace.Baggage)
+	}
+}
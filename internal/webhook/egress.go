@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// ErrEgressDenied is returned for dispatches to a destination webhook.egress
+// does not allow
+var ErrEgressDenied = errors.New("destination not allowed by webhook.egress")
+
+// metadataAddrs are the instance metadata services of cloud providers outside
+// the link-local range
+var metadataAddrs = []netip.Addr{
+	netip.MustParseAddr("fd00:ec2::254"),   // AWS over IPv6
+	netip.MustParseAddr("100.100.100.200"), // Alibaba Cloud
+}
+
+// egressPolicy decides which destinations dispatches may connect to
+type egressPolicy struct {
+	cidrs          []netip.Prefix
+	hosts          []string // Lowercase, *.example.com matches subdomains
+	allowLinkLocal bool
+}
+
+// newEgressPolicy compiles webhook.egress. Invalid entries were reported by
+// the config validation and are left out.
+func newEgressPolicy(cfg *config.EgressConfig) *egressPolicy {
+	p := &egressPolicy{allowLinkLocal: cfg.AllowLinkLocal}
+	for _, entry := range cfg.AllowedCIDRs {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			p.cidrs = append(p.cidrs, netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			p.cidrs = append(p.cidrs, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	for _, host := range cfg.AllowedHosts {
+		p.hosts = append(p.hosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+	}
+	return p
+}
+
+// allowsHost reports whether host is one of the allowed hostnames
+func (p *egressPolicy) allowsHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range p.hosts {
+		if domain, isWildcard := strings.CutPrefix(allowed, "*."); isWildcard {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error unless addr, which host resolved to, may be
+// connected to
+func (p *egressPolicy) check(host string, addr netip.Addr) error {
+	addr = addr.Unmap()
+	if !p.allowLinkLocal {
+		if addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() {
+			return fmt.Errorf("%w: %s is link-local", ErrEgressDenied, addr)
+		}
+		for _, metadata := range metadataAddrs {
+			if addr == metadata {
+				return fmt.Errorf("%w: %s is a cloud metadata service", ErrEgressDenied, addr)
+			}
+		}
+	}
+	if len(p.cidrs) == 0 && len(p.hosts) == 0 {
+		return nil
+	}
+	if p.allowsHost(host) {
+		return nil
+	}
+	for _, prefix := range p.cidrs {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if host != addr.String() {
+		return fmt.Errorf("%w: %s (%s)", ErrEgressDenied, host, addr)
+	}
+	return fmt.Errorf("%w: %s", ErrEgressDenied, addr)
+}
+
+// checkURL resolves the host of a destination and returns an error unless
+// all of its addresses may be connected to
+func (p *egressPolicy) checkURL(ctx context.Context, u *url.URL) error {
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.check(host, addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.check(host, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transport returns an HTTP transport connecting only to destinations the
+// policy allows. The address of every connection is checked after
+// resolving, so a hostname cannot be pointed elsewhere between the check and
+// the connection. Through a proxy (HTTP_PROXY), the proxy must be allowed
+// as well, and the destination is checked by resolving it beforehand.
+func (p *egressPolicy) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := http.ProxyFromEnvironment(req)
+		if err != nil || proxy == nil {
+			return proxy, err
+		}
+		if err := p.checkURL(req.Context(), req.URL); err != nil {
+			return nil, err
+		}
+		return proxy, nil
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				ip, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				addr, err := netip.ParseAddr(ip)
+				if err != nil {
+					return err
+				}
+				return p.check(host, addr)
+			},
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+	return transport
+}
+
+// NewEgressClient returns an HTTP client for fetching other URLs on behalf of
+// callers, e.g. media to upload, that connects only to destinations egress
+// allows, also when following redirects
+func NewEgressClient(egress *config.EgressConfig, timeout time.Duration) *http.Client {
+	p := newEgressPolicy(egress)
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: p.transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.checkURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// CheckDestination returns an error wrapping ErrEgressDenied unless
+// webhook.egress allows dispatches to rawURL, e.g. before registering a
+// command with it
+func (d *Dispatcher) CheckDestination(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return newEgressPolicy(&d.currentConfig().Egress).checkURL(ctx, u)
+}
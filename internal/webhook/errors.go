@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// StatusError is returned by Dispatch when the webhook answers with a status
+// other than 2xx
+type StatusError struct {
+	StatusCode int
+	URL        string
+	Duration   time.Duration
+	Response   string // Body of the response, truncated
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook returned status code: %d (URL: %s, Duration: %v, Response: %s)",
+		e.StatusCode, e.URL, e.Duration, e.Response)
+}
+
+// Transient reports whether a failed dispatch may succeed if it is sent
+// again: the webhook could not be reached or did not answer in time, was
+// rate limited (429) or failed with a server error (5xx). Destinations
+// webhook.egress denies, rejected requests and responses that cannot be
+// parsed are not transient.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, ErrEgressDenied) || errors.Is(err, ErrAlreadyDelivered) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Timeout reports whether a dispatch failed because the webhook did not
+// answer within webhook.timeout
+func Timeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/dedup"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// IdempotencyHeader carries the idempotency key of a dispatch
+const IdempotencyHeader = "Idempotency-Key"
+
+// idempotencyField is added to JSON object payloads
+const idempotencyField = "idempotency_key"
+
+// deliveredPrefix prefixes the storage keys of delivered dispatches
+const deliveredPrefix = "webhook.delivered/"
+
+// DedupScope names the delivered idempotency keys in dedup stats
+const DedupScope = "webhook"
+
+// ErrAlreadyDelivered is returned by Dispatch for a key that was already
+// delivered, or is being delivered
+var ErrAlreadyDelivered = errors.New("dispatch already delivered")
+
+type idempotencyContextKey struct{}
+
+// IdempotencyKey derives the key of the dispatch caused by source, e.g. the
+// ID of a Matrix event
+func IdempotencyKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:16])
+}
+
+// NewIdempotencyContext returns a copy of ctx whose dispatch carries key
+func NewIdempotencyContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyContextKey{}, key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyContextKey{}).(string)
+	return key
+}
+
+// addIdempotencyKey adds the key to a payload that is a JSON object without
+// the field, keeping the rest of the payload as it is
+func addIdempotencyKey(payload []byte, key string) []byte {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return payload
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return payload
+	}
+	if _, exists := fields[idempotencyField]; exists {
+		return payload
+	}
+
+	value, _ := json.Marshal(key)
+	open := len(payload) - len(trimmed) + 1
+	var out bytes.Buffer
+	out.Write(payload[:open])
+	out.WriteString(`"` + idempotencyField + `":`)
+	out.Write(value)
+	if len(fields) > 0 {
+		out.WriteByte(',')
+	}
+	out.Write(payload[open:])
+	return out.Bytes()
+}
+
+// deliveredScope returns the scope of delivered idempotency keys, which are
+// remembered for webhook.idempotency.ttl
+func deliveredScope(d *dedup.Deduplicator, cfg *config.WebhookConfig, log *logger.Logger) *dedup.Scope {
+	if d == nil {
+		d = dedup.New(nil, 0, log)
+	}
+	return d.Scope(DedupScope, deliveredPrefix, time.Duration(cfg.Idempotency.TTL)*time.Second)
+}
+
+// release ends the dispatch of a claimed key, recording it if it was
+// delivered
+func (d *Dispatcher) release(key string, delivered bool) {
+	if err := d.delivered.Release(key, delivered); err != nil {
+		d.logger.Error("Failed to record the delivery of %s: %v", key, err)
+	}
+}
@@ -0,0 +1,268 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/itchyny/gojq"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Pages fetched at most if response_pages does not set max_pages
+const defaultMaxPages = 10
+
+// PageCount is what the dispatches of a context read of paginated responses
+type PageCount struct {
+	Pages     int  // Pages read of the last paginated response
+	Truncated bool // More pages were left after max_pages
+}
+
+type pageCountContextKey struct{}
+
+// NewPageCountContext returns a copy of ctx whose dispatches report the
+// pages they read in the returned PageCount
+func NewPageCountContext(ctx context.Context) (context.Context, *PageCount) {
+	count := &PageCount{}
+	return context.WithValue(ctx, pageCountContextKey{}, count), count
+}
+
+func pageCountFromContext(ctx context.Context) *PageCount {
+	count, _ := ctx.Value(pageCountContextKey{}).(*PageCount)
+	return count
+}
+
+// responsePages returns the pagination of the responses of the command's
+// webhook, nil if they are not paginated
+func responsePages(cfg *config.WebhookConfig, command string) *config.ResponsePagesConfig {
+	if pages, exists := cfg.ResponsePages[command]; exists && command != "" {
+		return &pages
+	}
+	if cfg.DefaultResponsePages.Enabled() {
+		return &cfg.DefaultResponsePages
+	}
+	return nil
+}
+
+// pager follows the pages of a paginated response
+type pager struct {
+	items    *gojq.Code // Items of a page
+	merge    *gojq.Code // Replaces the items of the first page with $items
+	next     *gojq.Code // URL of the next page, nil if a cursor is used
+	cursor   *gojq.Code // Cursor of the next page, nil if a URL is used
+	param    string
+	maxPages int
+}
+
+func newPager(cfg *config.ResponsePagesConfig) (*pager, error) {
+	p := &pager{param: cfg.CursorParam, maxPages: cfg.MaxPages}
+	if p.param == "" {
+		p.param = "cursor"
+	}
+	if p.maxPages <= 0 {
+		p.maxPages = defaultMaxPages
+	}
+	var err error
+	if p.items, err = compileJQ(cfg.Items); err != nil {
+		return nil, fmt.Errorf("invalid items selector: %w", err)
+	}
+	if p.merge, err = compileJQ(cfg.Items+" = $items", "$items"); err != nil {
+		return nil, fmt.Errorf("invalid items selector: %w", err)
+	}
+	if cfg.Next != "" {
+		if p.next, err = compileJQ(cfg.Next); err != nil {
+			return nil, fmt.Errorf("invalid next selector: %w", err)
+		}
+	} else if p.cursor, err = compileJQ(cfg.Cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor selector: %w", err)
+	}
+	return p, nil
+}
+
+func compileJQ(program string, variables ...string) (*gojq.Code, error) {
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query, gojq.WithVariables(variables))
+}
+
+// firstResult returns the first result of a jq program, nil if it has none
+func firstResult(code *gojq.Code, data interface{}, values ...interface{}) (interface{}, error) {
+	v, ok := code.Run(data, values...).Next()
+	if !ok {
+		return nil, nil
+	}
+	if err, isErr := v.(error); isErr {
+		return nil, err
+	}
+	return v, nil
+}
+
+// pageItems returns the items of a page, which must be an array or null
+func (p *pager) pageItems(page interface{}) ([]interface{}, error) {
+	v, err := firstResult(p.items, page)
+	if err != nil {
+		return nil, err
+	}
+	switch items := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return items, nil
+	default:
+		return nil, fmt.Errorf("items are a %T, not an array", v)
+	}
+}
+
+// pageRef returns the next-page URL or cursor of a page as a string, empty
+// on the last page
+func pageRef(code *gojq.Code, page interface{}) (string, error) {
+	v, err := firstResult(code, page)
+	if err != nil {
+		return "", err
+	}
+	switch ref := v.(type) {
+	case nil, bool:
+		return "", nil
+	case string:
+		return ref, nil
+	case float64:
+		return strconv.FormatFloat(ref, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(ref), nil
+	default:
+		return "", fmt.Errorf("next page is a %T, not a string or number", v)
+	}
+}
+
+// followPages fetches the pages after the first response body and returns
+// the first page with the items of all pages read. The next-page URL is
+// fetched with GET, relative to the webhook; a cursor posts the payload to
+// the webhook again with the cursor as a query parameter. prepare sets the
+// headers of each request.
+func (d *Dispatcher) followPages(ctx context.Context, pages *config.ResponsePagesConfig, first []byte, webhookURL string, payload []byte, prepare func(*http.Request)) ([]byte, *PageCount, error) {
+	log := d.logger.Ctx(ctx)
+	p, err := newPager(pages)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(first, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal page 1: %w", err)
+	}
+	items, err := p.pageItems(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("page 1: %w", err)
+	}
+
+	count := &PageCount{Pages: 1}
+	page := doc
+	seen := map[string]bool{}
+	for {
+		code := p.cursor
+		if p.next != nil {
+			code = p.next
+		}
+		ref, err := pageRef(code, page)
+		if err != nil {
+			return nil, nil, fmt.Errorf("page %d: %w", count.Pages, err)
+		}
+		if ref == "" || seen[ref] {
+			break
+		}
+		seen[ref] = true
+		if count.Pages >= p.maxPages {
+			log.Warn("Stopped following pages of %s after %d, more are left", webhookURL, count.Pages)
+			count.Truncated = true
+			break
+		}
+
+		req, err := p.pageRequest(ctx, base, ref, payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("page %d: %w", count.Pages+1, err)
+		}
+		prepare(req)
+		body, err := d.fetchPage(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("page %d: %w", count.Pages+1, err)
+		}
+		page = nil
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal page %d: %w", count.Pages+1, err)
+		}
+		more, err := p.pageItems(page)
+		if err != nil {
+			return nil, nil, fmt.Errorf("page %d: %w", count.Pages+1, err)
+		}
+		items = append(items, more...)
+		count.Pages++
+	}
+
+	if items == nil {
+		items = []interface{}{}
+	}
+	merged, err := firstResult(p.merge, doc, items)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to merge the items of %d pages: %w", count.Pages, err)
+	}
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the merged pages: %w", err)
+	}
+	log.Info("Read %d pages with %d items from %s", count.Pages, len(items), webhookURL)
+	return body, count, nil
+}
+
+// pageRequest returns the request of the page a next-page URL or cursor
+// refers to
+func (p *pager) pageRequest(ctx context.Context, base *url.URL, ref string, payload []byte) (*http.Request, error) {
+	if p.next != nil {
+		next, err := base.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next page URL %q: %w", ref, err)
+		}
+		return http.NewRequestWithContext(ctx, http.MethodGet, next.String(), nil)
+	}
+	target := *base
+	query := target.Query()
+	query.Set(p.param, ref)
+	target.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// fetchPage sends the request of a page and returns the response body
+func (d *Dispatcher) fetchPage(req *http.Request) ([]byte, error) {
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		response := string(body)
+		if len(response) > 500 {
+			response = response[:500] + "... (truncated)"
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, URL: req.URL.String(), Response: response}
+	}
+	return body, nil
+}
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"text/template"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+)
+
+// Escaping of webhook.template_options
+const (
+	EscapeNone = "none"
+	EscapeJSON = "json" // For variables inside JSON strings
+)
+
+// sampleMessage is rendered by the dry run. Its quote and line break break
+// payloads that do not escape the message.
+const sampleMessage = "Sample \"message\"\nof the dry run"
+
+// payloadTemplateFuncs are the helpers of payload templates
+func payloadTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// A JSON string with quotes, e.g. {"text": {{json .MESSAGE}}}
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+		// A string escaped for use inside a JSON string
+		"jsonescape": jsonEscape,
+	}
+}
+
+func jsonEscape(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data[1 : len(data)-1])
+}
+
+type memoryContextKey struct{}
+
+// NewMemoryContext returns a copy of ctx whose dispatch payload can use the
+// remembered values as {{.MEMORY.key}}
+func NewMemoryContext(ctx context.Context, values map[string]string) context.Context {
+	return context.WithValue(ctx, memoryContextKey{}, values)
+}
+
+func memoryFromContext(ctx context.Context) map[string]string {
+	values, _ := ctx.Value(memoryContextKey{}).(map[string]string)
+	return values
+}
+
+var (
+	// memoryRegex matches the {{.MEMORY.key}} references of a template
+	memoryRegex = regexp.MustCompile(`\.MEMORY\.([A-Za-z_][A-Za-z0-9_]*)`)
+	// fieldRegex matches the {{.NAME}} references of a template
+	fieldRegex = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// renderPayload renders the payload template of command with the template
+// options of cfg. The remembered values are .MEMORY, escaped like vars; keys
+// that are not set are empty, also in strict mode.
+func renderPayload(name, text string, cfg *config.WebhookConfig, command string, vars, memory map[string]string) ([]byte, error) {
+	opts := &cfg.TemplateOptions
+	missingKey := opts.MissingKey
+	if opts.Strict {
+		missingKey = "error"
+	}
+	if missingKey == "" {
+		missingKey = "default"
+	}
+	tmpl, err := template.New(name).Funcs(payloadTemplateFuncs()).Option("missingkey=" + missingKey).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	escape := opts.Escape
+	if commandEscape, exists := opts.CommandEscapes[command]; exists && command != "" {
+		escape = commandEscape
+	}
+	// In strict mode empty variables count as missing
+	escaped := func(vars map[string]string) map[string]string {
+		values := make(map[string]string, len(vars))
+		for key, value := range vars {
+			if opts.Strict && value == "" {
+				continue
+			}
+			if escape == EscapeJSON {
+				value = jsonEscape(value)
+			}
+			values[key] = value
+		}
+		return values
+	}
+	data := make(map[string]interface{}, len(vars)+1)
+	for key, value := range escaped(vars) {
+		data[key] = value
+	}
+	remembered := make(map[string]string)
+	for _, match := range memoryRegex.FindAllStringSubmatch(text, -1) {
+		remembered[match[1]] = ""
+	}
+	for key, value := range memory {
+		if escape == EscapeJSON {
+			value = jsonEscape(value)
+		}
+		remembered[key] = value
+	}
+	data["MEMORY"] = remembered
+	// Missing entries of a map of interfaces render as <no value>, so those
+	// the template references are added empty
+	if missingKey == "zero" {
+		for _, match := range fieldRegex.FindAllStringSubmatch(text, -1) {
+			if _, exists := data[match[1]]; !exists {
+				data[match[1]] = ""
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// CheckTemplate renders the payload template of command with a sample
+// message. Payloads that are not valid JSON are an error in strict mode and
+// returned as a warning otherwise.
+func CheckTemplate(setting, command, text string, cfg *config.WebhookConfig) (warning string, err error) {
+	vars := map[string]string{"MESSAGE": sampleMessage}
+	if cfg.Idempotency.Enabled {
+		vars["IDEMPOTENCY_KEY"] = IdempotencyKey("$sample")
+	}
+	payload, err := renderPayload(setting, text, cfg, command, vars, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", setting, err)
+	}
+	if json.Valid(payload) {
+		return "", nil
+	}
+	problem := fmt.Sprintf("%s: the payload of a message with quotes or line breaks is not valid JSON, "+
+		"insert the message with {{json .MESSAGE}} or set webhook.template_options.escape: json", setting)
+	if cfg.TemplateOptions.Strict {
+		return "", errors.New(problem)
+	}
+	return problem, nil
+}
+
+// DryRun checks every payload template of cfg and of the rooms with
+// CheckTemplate, so that a template that cannot be rendered fails at startup
+// rather than at the first message
+func DryRun(cfg *config.WebhookConfig, rooms []config.RoomConfig) (warnings []string, err error) {
+	check := func(setting, command, text string) error {
+		warning, err := CheckTemplate(setting, command, text, cfg)
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		return err
+	}
+
+	if err := check("webhook.template", "", cfg.Template); err != nil {
+		return warnings, err
+	}
+	names := make([]string, 0, len(cfg.CommandTemplates))
+	for name := range cfg.CommandTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Templates of commands without a webhook are command lines
+		if _, isWebhook := cfg.Commands[name]; !isWebhook {
+			continue
+		}
+		if err := check("webhook.command_templates."+name, name, cfg.CommandTemplates[name]); err != nil {
+			return warnings, err
+		}
+	}
+	for i, room := range rooms {
+		if room.Webhook.Template == "" {
+			continue
+		}
+		if err := check(fmt.Sprintf("rooms[%d].webhook.template", i), "", room.Webhook.Template); err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
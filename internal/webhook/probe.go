@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/requestid"
+)
+
+// Endpoint is a configured webhook URL and the setting naming it
+type Endpoint struct {
+	Name string // e.g. webhook.commands.deploy
+	URL  string
+}
+
+// ProbeResult is the outcome of probing one endpoint
+type ProbeResult struct {
+	Endpoint
+	Status  string // Response status, empty if the probe failed to connect
+	Latency time.Duration
+	Err     error
+}
+
+// Endpoints returns the webhook URLs of cfg sorted by setting name: the
+// default and command webhooks, the default webhooks of rooms and the
+// webhooks of tenants. Command templates that run local commands have no
+// URL and are left out.
+func Endpoints(cfg *config.Config) []Endpoint {
+	var endpoints []Endpoint
+	add := func(prefix string, defaultURL string, commands map[string]string) {
+		if defaultURL != "" {
+			endpoints = append(endpoints, Endpoint{Name: prefix + "default", URL: defaultURL})
+		}
+		for name, endpoint := range commands {
+			endpoints = append(endpoints, Endpoint{Name: prefix + "commands." + name, URL: endpoint})
+		}
+	}
+	add("webhook.", cfg.Webhook.Default, cfg.Webhook.Commands)
+	for i, room := range cfg.Rooms {
+		if room.Webhook.Default != "" {
+			endpoints = append(endpoints, Endpoint{Name: fmt.Sprintf("rooms[%d].webhook.default", i), URL: room.Webhook.Default})
+		}
+	}
+	for _, tenant := range cfg.Tenants {
+		add("tenants."+tenant.Name+".webhook.", tenant.Webhook.Default, tenant.Webhook.Commands)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	return endpoints
+}
+
+// Probe sends a HEAD request and returns the response status. Only
+// connection errors and server errors fail the probe; 501 is accepted from
+// servers that do not implement HEAD.
+func Probe(ctx context.Context, client *http.Client, endpoint, userAgent string) (string, error) {
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("not an http(s) URL: %s", endpoint)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+		return resp.Status, fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return resp.Status, nil
+}
+
+// Ping posts an empty JSON object to endpoint as a dispatch would, with the
+// client, egress policy and headers of dispatches, and returns the response
+// status. Statuses other than 2xx fail the ping.
+func (d *Dispatcher) Ping(ctx context.Context, endpoint string) (string, error) {
+	cfg := d.currentConfig()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader("{}"))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(ctx)
+	if requestID != "" {
+		req.Header.Set(requestid.Header, requestID)
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+	if cfg.PropagateTrace {
+		setTraceHeaders(ctx, req, requestID)
+	}
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.Status, fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return resp.Status, nil
+}
+
+// ProbeAll probes the endpoints at once, each within timeout, and returns
+// the results in the order of endpoints
+func ProbeAll(ctx context.Context, endpoints []Endpoint, timeout time.Duration, userAgent string) []ProbeResult {
+	client := &http.Client{Timeout: timeout}
+	results := make([]ProbeResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			status, err := Probe(ctx, client, endpoint.URL, userAgent)
+			results[i] = ProbeResult{Endpoint: endpoint, Status: status, Latency: time.Since(start), Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
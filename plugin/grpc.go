@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The services exchange google.protobuf.Struct messages with the fields of
+// Message and Result, see plugin.proto
+const (
+	handleMessageMethod = "/matrix.plugin.v1.MessageHandler/HandleMessage"
+	transformMethod     = "/matrix.plugin.v1.Transformer/Transform"
+)
+
+// handlerPlugin serves and dispenses a MessageHandler
+type handlerPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	impl MessageHandler
+}
+
+func (p *handlerPlugin) GRPCServer(broker *goplugin.GRPCBroker, s *grpc.Server) error {
+	if p.impl != nil {
+		s.RegisterService(&grpc.ServiceDesc{
+			ServiceName: "matrix.plugin.v1.MessageHandler",
+			HandlerType: (*MessageHandler)(nil),
+			Methods: []grpc.MethodDesc{{
+				MethodName: "HandleMessage",
+				Handler: unaryHandler(handleMessageMethod, func(ctx context.Context, srv interface{}, msg *Message) (interface{}, error) {
+					result, err := srv.(MessageHandler).HandleMessage(ctx, msg)
+					if err == nil && result == nil {
+						result = &Result{}
+					}
+					return result, err
+				}),
+			}},
+			Metadata: "plugin.proto",
+		}, p.impl)
+	}
+	return nil
+}
+
+func (p *handlerPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return &handlerClient{conn: conn}, nil
+}
+
+type handlerClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *handlerClient) HandleMessage(ctx context.Context, msg *Message) (*Result, error) {
+	var result Result
+	if err := invoke(ctx, c.conn, handleMessageMethod, msg, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// transformerPlugin serves and dispenses a Transformer
+type transformerPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	impl Transformer
+}
+
+// transformResult is the response of the Transformer service
+type transformResult struct {
+	Body string `json:"body"`
+}
+
+func (p *transformerPlugin) GRPCServer(broker *goplugin.GRPCBroker, s *grpc.Server) error {
+	if p.impl != nil {
+		s.RegisterService(&grpc.ServiceDesc{
+			ServiceName: "matrix.plugin.v1.Transformer",
+			HandlerType: (*Transformer)(nil),
+			Methods: []grpc.MethodDesc{{
+				MethodName: "Transform",
+				Handler: unaryHandler(transformMethod, func(ctx context.Context, srv interface{}, msg *Message) (interface{}, error) {
+					body, err := srv.(Transformer).Transform(ctx, msg)
+					return &transformResult{Body: body}, err
+				}),
+			}},
+			Metadata: "plugin.proto",
+		}, p.impl)
+	}
+	return nil
+}
+
+func (p *transformerPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return &transformerClient{conn: conn}, nil
+}
+
+type transformerClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *transformerClient) Transform(ctx context.Context, msg *Message) (string, error) {
+	var result transformResult
+	if err := invoke(ctx, c.conn, transformMethod, msg, &result); err != nil {
+		return "", err
+	}
+	return result.Body, nil
+}
+
+// unaryHandler adapts a method taking a Message to a gRPC method handler
+func unaryHandler(fullMethod string, call func(ctx context.Context, srv interface{}, msg *Message) (interface{}, error)) grpc.MethodHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			var msg Message
+			if err := fromStruct(req.(*structpb.Struct), &msg); err != nil {
+				return nil, err
+			}
+			out, err := call(ctx, srv, &msg)
+			if err != nil {
+				return nil, err
+			}
+			return toStruct(out)
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+	}
+}
+
+// invoke calls a method with in as request and decodes the response into out
+func invoke(ctx context.Context, conn *grpc.ClientConn, method string, in, out interface{}) error {
+	req, err := toStruct(in)
+	if err != nil {
+		return err
+	}
+	resp := new(structpb.Struct)
+	if err := conn.Invoke(ctx, method, req, resp); err != nil {
+		return err
+	}
+	return fromStruct(resp, out)
+}
+
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	if s == nil {
+		return errors.New("missing message")
+	}
+	data, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Host runs the plugins of the service
+type Host struct {
+	plugins []*loadedPlugin
+	timeout time.Duration // Per call
+	logger  *logger.Logger
+}
+
+type loadedPlugin struct {
+	name        string
+	kill        func() // Stops the plugin process
+	handler     MessageHandler
+	transformer Transformer
+	// Set once the plugin turned out not to serve the service
+	noHandler     atomic.Bool
+	noTransformer atomic.Bool
+}
+
+// Load starts every executable in dir. Handlers and transformers run in the
+// order of the file names (os.ReadDir sorts them). Calls taking longer than
+// timeout are abandoned.
+func Load(dir string, timeout time.Duration, log *logger.Logger) (*Host, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	h := &Host{timeout: timeout, logger: log}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		loaded, err := h.start(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("plugin %s: %w", entry.Name(), err)
+		}
+		h.plugins = append(h.plugins, loaded)
+		log.Info("Loaded plugin %s", entry.Name())
+	}
+	return h, nil
+}
+
+// start launches a plugin binary and dispenses its services
+func (h *Host) start(path string) (*loadedPlugin, error) {
+	name := filepath.Base(path)
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          pluginSet(Plugins{}),
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:        "plugin." + name,
+			Output:      logWriter{h.logger},
+			Level:       hclog.Info,
+			DisableTime: true,
+		}),
+	})
+	protocol, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, err
+	}
+	loaded, err := dispense(name, protocol)
+	if err != nil {
+		client.Kill()
+		return nil, err
+	}
+	loaded.kill = client.Kill
+	return loaded, nil
+}
+
+// dispense returns the services of a started plugin
+func dispense(name string, protocol goplugin.ClientProtocol) (*loadedPlugin, error) {
+	handler, err := protocol.Dispense(handlerName)
+	if err != nil {
+		return nil, err
+	}
+	transformer, err := protocol.Dispense(transformerName)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedPlugin{name: name, kill: func() { protocol.Close() }, handler: handler.(MessageHandler), transformer: transformer.(Transformer)}, nil
+}
+
+// HandleMessage passes a message through the handlers until one handles it.
+// Each handler sees the body as rewritten by the handlers before it. A
+// handler that fails is skipped.
+func (h *Host) HandleMessage(ctx context.Context, msg Message) Result {
+	var merged Result
+	for _, p := range h.plugins {
+		if p.noHandler.Load() {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		result, err := p.handler.HandleMessage(callCtx, &msg)
+		cancel()
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				p.noHandler.Store(true)
+				continue
+			}
+			h.logger.Ctx(ctx).Error("Plugin %s failed to handle message: %v", p.name, err)
+			continue
+		}
+		if result.Body != "" {
+			msg.Body = result.Body
+			merged.Body = result.Body
+		}
+		if result.Command != "" {
+			merged.Command = result.Command
+		}
+		if result.Handled {
+			h.logger.Ctx(ctx).Info("Message handled by plugin %s", p.name)
+			merged.Handled = true
+			merged.Reply = result.Reply
+			return merged
+		}
+	}
+	return merged
+}
+
+// Transform passes a reply through the transformers. The reply is dropped
+// ("" is returned) as soon as a transformer returns an empty body. A
+// transformer that fails is skipped.
+func (h *Host) Transform(ctx context.Context, msg Message) string {
+	for _, p := range h.plugins {
+		if p.noTransformer.Load() {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		body, err := p.transformer.Transform(callCtx, &msg)
+		cancel()
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				p.noTransformer.Store(true)
+				continue
+			}
+			h.logger.Ctx(ctx).Error("Plugin %s failed to transform reply: %v", p.name, err)
+			continue
+		}
+		if body == "" {
+			h.logger.Ctx(ctx).Info("Reply dropped by plugin %s", p.name)
+			return ""
+		}
+		msg.Body = body
+	}
+	return msg.Body
+}
+
+// Close stops the plugin processes
+func (h *Host) Close() {
+	for _, p := range h.plugins {
+		p.kill()
+	}
+}
+
+// logWriter passes go-plugin log output, including what plugins write to
+// stderr, to the service log
+type logWriter struct {
+	logger *logger.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Info("%s", line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+// Package plugin extends the service with external binaries, run with
+// hashicorp/go-plugin over gRPC. A plugin implements MessageHandler, which
+// sees every message before it is routed, Transformer, which rewrites
+// replies before they are sent, or both, and calls Serve from its main
+// function:
+//
+//	func main() {
+//		plugin.Serve(plugin.Plugins{Handler: myHandler{}})
+//	}
+//
+// The gRPC services are described in plugin.proto, so plugins can be
+// written in any language go-plugin supports.
+package plugin
+
+import (
+	"context"
+
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+// Handshake is shared by the service and its plugins. The protocol version
+// changes when the services change incompatibly.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "MATRIX_MICROSERVICE_PLUGIN",
+	MagicCookieValue: "9f8c1c52-8f4b-4d3c-9a57-3c1b4c1e7a20",
+}
+
+// Names of the plugins in the go-plugin plugin set
+const (
+	handlerName     = "message_handler"
+	transformerName = "transformer"
+)
+
+// Message is a Matrix message received by the bot
+type Message struct {
+	RoomID     string `json:"room_id"`
+	Sender     string `json:"sender"`
+	EventID    string `json:"event_id"`
+	ThreadRoot string `json:"thread_root,omitempty"`
+	Body       string `json:"body"`
+}
+
+// Result is what a MessageHandler decided about a message
+type Result struct {
+	// The message was handled: Reply (if any) is sent and the message is
+	// not routed further
+	Handled bool   `json:"handled"`
+	Reply   string `json:"reply,omitempty"`
+	// Replaces the message body for the following plugins and routing
+	// (empty = unchanged)
+	Body string `json:"body,omitempty"`
+	// Dispatches the message to the webhook of this command instead of the
+	// one named in the message (empty = unchanged)
+	Command string `json:"command,omitempty"`
+}
+
+// MessageHandler sees every message before it is routed to a webhook or
+// command, and may answer it itself or change how it is routed
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, msg *Message) (*Result, error)
+}
+
+// Transformer rewrites a reply before it is sent; msg.Body is the reply.
+// Returning an empty string drops the reply.
+type Transformer interface {
+	Transform(ctx context.Context, msg *Message) (string, error)
+}
+
+// Plugins are the implementations a plugin binary serves; either may be nil
+type Plugins struct {
+	Handler     MessageHandler
+	Transformer Transformer
+}
+
+// Serve serves the implementations to the service. It is called from the
+// main function of a plugin and does not return.
+func Serve(impl Plugins) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         pluginSet(impl),
+		GRPCServer:      goplugin.DefaultGRPCServer,
+	})
+}
+
+// pluginSet maps the implementations to go-plugin plugins. The service
+// dispenses both; calls to a service the plugin does not serve fail with
+// codes.Unimplemented and are skipped.
+func pluginSet(impl Plugins) goplugin.PluginSet {
+	return goplugin.PluginSet{
+		handlerName:     &handlerPlugin{impl: impl.Handler},
+		transformerName: &transformerPlugin{impl: impl.Transformer},
+	}
+}
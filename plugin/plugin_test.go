@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/mule-ai/mule/matrix-microservice/internal/config"
+	"github.com/mule-ai/mule/matrix-microservice/internal/logger"
+)
+
+// router answers !ping, routes messages mentioning deploys to the deploy
+// command and lowercases everything else
+type router struct{}
+
+func (router) HandleMessage(ctx context.Context, msg *Message) (*Result, error) {
+	switch {
+	case msg.Body == "!ping":
+		return &Result{Handled: true, Reply: "pong from " + msg.RoomID}, nil
+	case msg.Body == "!fail":
+		return nil, errors.New("boom")
+	case strings.Contains(msg.Body, "deploy"):
+		return &Result{Command: "deploy"}, nil
+	}
+	return &Result{Body: strings.ToLower(msg.Body)}, nil
+}
+
+// signer signs replies and drops those marked secret
+type signer struct{}
+
+func (signer) Transform(ctx context.Context, msg *Message) (string, error) {
+	if strings.Contains(msg.Body, "secret") {
+		return "", nil
+	}
+	return msg.Body + " -- bot", nil
+}
+
+// testHost connects a host to in-process plugins, in order
+func testHost(t *testing.T, impls ...Plugins) *Host {
+	log, _ := logger.New(&config.LoggingConfig{Level: "error"})
+	h := &Host{timeout: 5 * time.Second, logger: log}
+	for i, impl := range impls {
+		client, _ := goplugin.TestPluginGRPCConn(t, false, pluginSet(impl))
+		loaded, err := dispense(string(rune('a'+i)), client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.plugins = append(h.plugins, loaded)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestHandleMessage(t *testing.T) {
+	// The transformer-only plugin does not serve MessageHandler and is skipped
+	h := testHost(t, Plugins{Transformer: signer{}}, Plugins{Handler: router{}})
+	ctx := context.Background()
+
+	tests := []struct {
+		body string
+		want Result
+	}{
+		{"!ping", Result{Handled: true, Reply: "pong from !room:example.com"}},
+		{"Please deploy", Result{Command: "deploy"}},
+		{"Hello", Result{Body: "hello"}},
+		// Errors are logged and the message is routed as usual
+		{"!fail", Result{}},
+	}
+	for _, tt := range tests {
+		got := h.HandleMessage(ctx, Message{RoomID: "!room:example.com", Body: tt.body})
+		if got != tt.want {
+			t.Errorf("HandleMessage(%q) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	h := testHost(t, Plugins{Handler: router{}}, Plugins{Transformer: signer{}}, Plugins{Transformer: signer{}})
+	ctx := context.Background()
+
+	if got := h.Transform(ctx, Message{Body: "done"}); got != "done -- bot -- bot" {
+		t.Errorf("Transform = %q, want both signatures", got)
+	}
+	if got := h.Transform(ctx, Message{Body: "the secret is 42"}); got != "" {
+		t.Errorf("Transform = %q, want the reply dropped", got)
+	}
+}